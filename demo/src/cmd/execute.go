@@ -3,10 +3,15 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 	
 	"github.com/google/uuid"
 	"github.com/runink/runink/internal/engine"
+	"github.com/runink/runink/runtime"
 	"github.com/spf13/cobra"
 )
 
@@ -187,9 +192,24 @@ func executeDAG(cmd *cobra.Command) error {
 		fmt.Printf("  Isolation Level: %v\n", config.Execution.IsolationLevel)
 	}
 	
-	// Create context with timeout
+	// Sandboxed nodes get this long to exit once the run is cancelled
+	gracePeriod, err := cmd.Flags().GetDuration("grace-period")
+	if err != nil {
+		return err
+	}
+	
+	// Reclaim root filesystems of agents that died before cleaning up
+	if os.Geteuid() == 0 {
+		if err := runtime.SweepRootfs(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to sweep stale rootfs: %v\n", err)
+		}
+	}
+	
+	// Create context with timeout, cancelled on interrupt
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
 	defer cancel()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	
 	// Parse files and build DAG
 	dag, err := engine.BuildDAG(config.Files, config.Execution.IntegratedFlow, config.Execution.RunID)
@@ -211,6 +231,12 @@ func executeDAG(cmd *cobra.Command) error {
 	// Create error handler
 	errorHandler := engine.NewDefaultErrorHandler(config.Execution.ErrorMode == engine.StopOnError)
 	
+	// Sandboxed nodes rebuild the DAG from the same files
+	sliceArgs, sliceFiles, err := sliceExecArgs(config)
+	if err != nil {
+		return err
+	}
+	
 	// Execute the DAG with the enhanced engine
 	startTime := time.Now()
 	err = engine.Execute(
@@ -221,6 +247,8 @@ func executeDAG(cmd *cobra.Command) error {
 		errorHandler,
 		engine.WithDataPassStrategy(config.Execution.DataPass),
 		engine.WithIsolationLevel(config.Execution.IsolationLevel),
+		engine.WithSliceExec(sliceArgs, sliceFiles),
+		engine.WithGracePeriod(gracePeriod),
 	)
 	
 	if config.Execution.Verbose {
@@ -229,3 +257,31 @@ func executeDAG(cmd *cobra.Command) error {
 	
 	return err
 }
+
+// sliceExecArgs returns the slice-exec flags that rebuild the DAG in a
+// sandboxed node, and the absolute paths of the files they refer to
+func sliceExecArgs(config engine.RunConfig) ([]string, []string, error) {
+	var args, files []string
+	for _, file := range []struct{ flag, path string }{
+		{"contract", config.Files.ContractFile},
+		{"conf", config.Files.ConfFile},
+		{"dsl", config.Files.DSLFile},
+		{"herd", config.Files.HerdFile},
+	} {
+		if file.path == "" {
+			continue
+		}
+		path, err := filepath.Abs(file.path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to resolve %s file: %w", file.flag, err)
+		}
+		args = append(args, "--"+file.flag, path)
+		files = append(files, path)
+	}
+	
+	args = append(args,
+		fmt.Sprintf("--integrated-flow=%t", config.Execution.IntegratedFlow),
+		"--run-id", config.Execution.RunID,
+	)
+	return args, files, nil
+}
@@ -6,6 +6,7 @@ package cmd
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -48,6 +49,7 @@ func init() {
 	runCmd.Flags().String("monitoring", "basic", "Monitoring level: 'none', 'basic' (default), or 'verbose'")
 	runCmd.Flags().String("execution-mode", "sync", "Execution mode: 'sync' (default) or 'async'")
 	runCmd.Flags().Bool("integrated-flow", true, "Enable integrated execution flow (default: true)")
-	runCmd.Flags().String("isolation-level", "none", "Isolation level: 'none' (default), 'process', or 'container'")
+	runCmd.Flags().String("isolation-level", "none", "Isolation level: 'none' (default), 'process', or 'container'; neither applies the herd's seccomp profile, Landlock rules or resource limits")
 	runCmd.Flags().String("run-id", "", "Unique identifier for this run (auto-generated if not provided)")
+	runCmd.Flags().Duration("grace-period", 10*time.Second, "Time cancelled sandboxed nodes get to exit after SIGTERM before they are killed")
 }
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/runink/runink/internal/engine"
+	"github.com/spf13/cobra"
+)
+
+// sliceExecCmd runs a single DAG node inside a sandbox. The engine starts it
+// through runtime.Executor when --isolation-level is process or container.
+var sliceExecCmd = &cobra.Command{
+	Use:    engine.SliceExecCommand,
+	Short:  "Run a single DAG node as a sandboxed slice",
+	Hidden: true,
+	Long: `The slice-exec command rebuilds the DAG from the input files and executes
+one node. The node's input is read from stdin and its result is written to
+stdout, both as frames encoded with the edge codec. It is started by the engine
+and not meant to be run by hand.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Stdout carries frames; keep node output off it
+		frames := os.Stdout
+		os.Stdout = os.Stderr
+
+		if err := sliceExec(cmd, frames); err != nil {
+			fmt.Fprintf(os.Stderr, "slice-exec: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sliceExecCmd)
+
+	sliceExecCmd.Flags().String("node", "", "ID of the node to execute")
+	sliceExecCmd.Flags().String("codec", "json", "Codec for the node's input and output")
+	sliceExecCmd.Flags().String("contract", "", "Path to the contract file (.contract)")
+	sliceExecCmd.Flags().String("conf", "", "Path to the configuration file (.conf)")
+	sliceExecCmd.Flags().String("dsl", "", "Path to the domain specific language file (.dsl)")
+	sliceExecCmd.Flags().String("herd", "", "Path to the herd file (.herd)")
+	sliceExecCmd.Flags().Bool("integrated-flow", true, "Enable integrated execution flow (default: true)")
+	sliceExecCmd.Flags().String("run-id", "", "Identifier of the run the node belongs to")
+}
+
+// sliceExec rebuilds the DAG and serves the requested node over stdin and frames
+func sliceExec(cmd *cobra.Command, frames *os.File) error {
+	nodeID, err := cmd.Flags().GetString("node")
+	if err != nil {
+		return err
+	}
+	codecName, err := cmd.Flags().GetString("codec")
+	if err != nil {
+		return err
+	}
+	integratedFlow, err := cmd.Flags().GetBool("integrated-flow")
+	if err != nil {
+		return err
+	}
+	runID, err := cmd.Flags().GetString("run-id")
+	if err != nil {
+		return err
+	}
+
+	files := engine.FileConfig{}
+	for flag, path := range map[string]*string{
+		"contract": &files.ContractFile,
+		"conf":     &files.ConfFile,
+		"dsl":      &files.DSLFile,
+		"herd":     &files.HerdFile,
+	} {
+		if *path, err = cmd.Flags().GetString(flag); err != nil {
+			return err
+		}
+	}
+
+	dag, err := engine.BuildDAG(files, integratedFlow, runID)
+	if err != nil {
+		return fmt.Errorf("failed to build DAG: %w", err)
+	}
+	node, ok := dag.Nodes[nodeID]
+	if !ok {
+		return fmt.Errorf("node %s not found in DAG", nodeID)
+	}
+
+	return engine.ServeSlice(context.Background(), node, engine.GetCodec(codecName), os.Stdin, frames)
+}
@@ -0,0 +1,525 @@
+package dag
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/runink/runink/runtime"
+)
+
+// OptionType defines how a connector option value is parsed
+type OptionType int
+
+const (
+	// StringOption keeps the raw option value
+	StringOption OptionType = iota
+
+	// IntOption parses the value as a base-10 integer
+	IntOption
+
+	// BoolOption parses the value as a boolean
+	BoolOption
+
+	// FloatOption parses the value as a 64-bit float
+	FloatOption
+
+	// DurationOption parses the value as a Go duration (e.g. "5s")
+	DurationOption
+
+	// SecretOption requires a secret reference (e.g.
+	// "secret://finance/warehouse_password") and parses it into a
+	// runtime.SecretRef, so credentials never appear in conf files
+	SecretOption
+)
+
+// String returns a string representation of the option type
+func (t OptionType) String() string {
+	switch t {
+	case StringOption:
+		return "string"
+	case IntOption:
+		return "int"
+	case BoolOption:
+		return "bool"
+	case FloatOption:
+		return "float"
+	case DurationOption:
+		return "duration"
+	case SecretOption:
+		return "secret reference"
+	default:
+		return "unknown"
+	}
+}
+
+// OptionSpec describes a single typed option accepted by a connector
+type OptionSpec struct {
+	Name     string
+	Type     OptionType
+	Default  interface{}
+	Required bool
+}
+
+// EndpointRole defines whether an endpoint is read from or written to
+type EndpointRole int
+
+const (
+	// SourceRole marks an endpoint that records are read from
+	SourceRole EndpointRole = iota
+
+	// SinkRole marks an endpoint that records are written to
+	SinkRole
+)
+
+// String returns a string representation of the endpoint role
+func (r EndpointRole) String() string {
+	if r == SinkRole {
+		return "sink"
+	}
+	return "source"
+}
+
+// Connector maps a URI scheme to the node types that read and write it.
+// SourceNodeType and SinkNodeType are keys into the nodes.NodeRegistry;
+// an empty value means the connector cannot be used in that role.
+type Connector struct {
+	Scheme         string
+	Aliases        []string
+	SourceNodeType string
+	SinkNodeType   string
+
+	// LocationKey is the node config key that receives the URI location
+	// (e.g. "path" for files, "topic" for Kafka)
+	LocationKey string
+
+	// LocalPath marks connectors whose location is a path on the agent's
+	// filesystem; they determine the slice's Landlock ruleset
+	LocalPath bool
+
+	Options []OptionSpec
+}
+
+// Endpoint is a source or sink URI resolved against a connector
+type Endpoint struct {
+	URI       string
+	Scheme    string
+	Location  string
+	Role      EndpointRole
+	NodeType  string
+	Options   map[string]interface{}
+	Connector *Connector
+}
+
+// NodeConfig returns the config map to pass to the endpoint's node factory
+func (e *Endpoint) NodeConfig() map[string]interface{} {
+	config := make(map[string]interface{}, len(e.Options)+1)
+	for key, value := range e.Options {
+		config[key] = value
+	}
+	if e.Connector != nil && e.Connector.LocationKey != "" {
+		config[e.Connector.LocationKey] = e.Location
+	}
+	return config
+}
+
+// Secrets returns the secret references among the endpoint's options
+func (e *Endpoint) Secrets() []runtime.SecretRef {
+	var refs []runtime.SecretRef
+	for _, value := range e.Options {
+		if ref, ok := value.(runtime.SecretRef); ok {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// LaunchConfig returns the node config with each secret option replaced by
+// the value delivered to the running slice, see runtime.LookupSecret. Call
+// it inside the slice, once its secrets are delivered.
+func (e *Endpoint) LaunchConfig() (map[string]interface{}, error) {
+	config := e.NodeConfig()
+	for key, value := range config {
+		ref, ok := value.(runtime.SecretRef)
+		if !ok {
+			continue
+		}
+		secret, err := runtime.LookupSecret(ref)
+		if err != nil {
+			return nil, fmt.Errorf("option '%s': %w", key, err)
+		}
+		config[key] = string(secret)
+	}
+	return config, nil
+}
+
+// ConnectorRegistry is a registry of connectors keyed by URI scheme
+type ConnectorRegistry struct {
+	connectors map[string]*Connector
+	mu         sync.RWMutex
+}
+
+// NewConnectorRegistry creates a new connector registry
+func NewConnectorRegistry() *ConnectorRegistry {
+	return &ConnectorRegistry{
+		connectors: make(map[string]*Connector),
+	}
+}
+
+// Register registers a connector under its scheme and aliases.
+// Registering an existing scheme replaces the previous connector.
+func (r *ConnectorRegistry) Register(connector Connector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	connector.Scheme = strings.ToLower(connector.Scheme)
+	c := &connector
+	r.connectors[c.Scheme] = c
+	for _, alias := range c.Aliases {
+		r.connectors[strings.ToLower(alias)] = c
+	}
+}
+
+// Get returns the connector registered for the given scheme
+func (r *ConnectorRegistry) Get(scheme string) (*Connector, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	connector, ok := r.connectors[strings.ToLower(scheme)]
+	if !ok {
+		return nil, fmt.Errorf("no connector registered for scheme: %s", scheme)
+	}
+	return connector, nil
+}
+
+// Schemes returns all registered schemes and aliases in sorted order
+func (r *ConnectorRegistry) Schemes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	schemes := make([]string, 0, len(r.connectors))
+	for scheme := range r.connectors {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Resolve parses a URI, looks up its connector and parses its options.
+// Options from an `options {}` block are applied first and query
+// parameters on the URI override them.
+func (r *ConnectorRegistry) Resolve(uri string, role EndpointRole, options map[string]string) (*Endpoint, error) {
+	scheme, location, query, err := splitURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	connector, err := r.Get(scheme)
+	if err != nil {
+		return nil, fmt.Errorf("unknown scheme in %q: %w", uri, err)
+	}
+
+	nodeType := connector.SourceNodeType
+	if role == SinkRole {
+		nodeType = connector.SinkNodeType
+	}
+	if nodeType == "" {
+		return nil, fmt.Errorf("connector %s cannot be used as a %s", connector.Scheme, role)
+	}
+
+	raw := make(map[string]string, len(options)+len(query))
+	for key, value := range options {
+		raw[key] = value
+	}
+	for key, values := range query {
+		if len(values) > 0 {
+			raw[key] = values[len(values)-1]
+		}
+	}
+
+	// Leave the query out of errors: it may hold a plain-text credential
+	parsed, err := connector.ParseOptions(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid options for %s://%s: %w", connector.Scheme, location, err)
+	}
+
+	return &Endpoint{
+		URI:       uri,
+		Scheme:    connector.Scheme,
+		Location:  location,
+		Role:      role,
+		NodeType:  nodeType,
+		Options:   parsed,
+		Connector: connector,
+	}, nil
+}
+
+// ParseOptions converts raw option strings into typed values, applies
+// defaults and rejects unknown or missing required options
+func (c *Connector) ParseOptions(raw map[string]string) (map[string]interface{}, error) {
+	specs := make(map[string]OptionSpec, len(c.Options))
+	for _, spec := range c.Options {
+		specs[spec.Name] = spec
+	}
+
+	parsed := make(map[string]interface{}, len(c.Options))
+	for key, value := range raw {
+		spec, ok := specs[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown option '%s' for connector %s", key, c.Scheme)
+		}
+		typed, err := parseOptionValue(value, spec.Type)
+		if err != nil {
+			return nil, fmt.Errorf("option '%s' must be a %s: %w", key, spec.Type, err)
+		}
+		parsed[key] = typed
+	}
+
+	for _, spec := range c.Options {
+		if _, ok := parsed[spec.Name]; ok {
+			continue
+		}
+		if spec.Required {
+			return nil, fmt.Errorf("missing required option '%s' for connector %s", spec.Name, c.Scheme)
+		}
+		if spec.Default != nil {
+			parsed[spec.Name] = spec.Default
+		}
+	}
+
+	return parsed, nil
+}
+
+// parseOptionValue converts a raw option string into the given type
+func parseOptionValue(value string, optionType OptionType) (interface{}, error) {
+	value = strings.Trim(strings.TrimSpace(value), "\"'")
+	switch optionType {
+	case IntOption:
+		return strconv.Atoi(value)
+	case BoolOption:
+		return strconv.ParseBool(value)
+	case FloatOption:
+		return strconv.ParseFloat(value, 64)
+	case DurationOption:
+		return time.ParseDuration(value)
+	case SecretOption:
+		return runtime.ParseSecretRef(value)
+	default:
+		return value, nil
+	}
+}
+
+// splitURI splits a URI into scheme, location and query parameters.
+// Both hierarchical (csv://data/in.csv) and opaque (kafka:topics.trades)
+// forms are accepted. Bare paths and file:// URIs infer their scheme
+// from the file extension.
+func splitURI(uri string) (string, string, url.Values, error) {
+	parsed, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return "", "", nil, fmt.Errorf("invalid URI %q: %w", uri, err)
+	}
+
+	query, err := url.ParseQuery(parsed.RawQuery)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("invalid query in URI %q: %w", uri, err)
+	}
+
+	location := parsed.Opaque
+	if location == "" {
+		location = parsed.Host + parsed.Path
+	}
+	if location == "" {
+		return "", "", nil, fmt.Errorf("URI %q has no location", uri)
+	}
+
+	scheme := strings.ToLower(parsed.Scheme)
+	if scheme == "" || scheme == "file" {
+		scheme = schemeFromExtension(location)
+		if scheme == "" {
+			return "", "", nil, fmt.Errorf("cannot infer connector for %q from its file extension", uri)
+		}
+	}
+
+	return scheme, location, query, nil
+}
+
+// schemeFromExtension maps a file extension to a file-format scheme
+func schemeFromExtension(location string) string {
+	switch strings.ToLower(filepath.Ext(location)) {
+	case ".csv":
+		return "csv"
+	case ".json", ".ndjson", ".jsonl":
+		return "json"
+	case ".parquet":
+		return "parquet"
+	default:
+		return ""
+	}
+}
+
+// connectorURIKeys lists the node config keys that hold endpoint URIs,
+// together with the role the endpoint plays for that key
+var connectorURIKeys = []struct {
+	key  string
+	role func(*Node) EndpointRole
+}{
+	{"uri", func(n *Node) EndpointRole {
+		if n.Type == "sink" {
+			return SinkRole
+		}
+		return SourceRole
+	}},
+	{"source", func(*Node) EndpointRole { return SourceRole }},
+	{"sink", func(*Node) EndpointRole { return SinkRole }},
+}
+
+// ResolveConnectors resolves every source and sink URI in the DAG against
+// the registry and records the resulting endpoints on each node.
+// An unknown scheme or invalid option fails the resolution.
+func (d *DAG) ResolveConnectors(registry *ConnectorRegistry) error {
+	if registry == nil {
+		registry = DefaultConnectors
+	}
+
+	ids := make([]string, 0, len(d.Nodes))
+	for id := range d.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		node := d.Nodes[id]
+		options := nodeOptions(node)
+
+		for _, entry := range connectorURIKeys {
+			uri, ok := node.Config[entry.key].(string)
+			if !ok || uri == "" {
+				continue
+			}
+
+			endpoint, err := registry.Resolve(uri, entry.role(node), options)
+			if err != nil {
+				return fmt.Errorf("node '%s': %w", id, err)
+			}
+
+			if node.Endpoints == nil {
+				node.Endpoints = make(map[string]*Endpoint)
+			}
+			node.Endpoints[entry.key] = endpoint
+		}
+	}
+
+	return nil
+}
+
+// nodeOptions returns the raw `options {}` block attached to a node, if any
+func nodeOptions(node *Node) map[string]string {
+	switch options := node.Config["options"].(type) {
+	case map[string]string:
+		return options
+	case map[string]interface{}:
+		raw := make(map[string]string, len(options))
+		for key, value := range options {
+			raw[key] = fmt.Sprintf("%v", value)
+		}
+		return raw
+	default:
+		return nil
+	}
+}
+
+// DefaultConnectors is the default connector registry
+var DefaultConnectors = NewConnectorRegistry()
+
+// RegisterConnector registers a connector with the default registry
+func RegisterConnector(connector Connector) {
+	DefaultConnectors.Register(connector)
+}
+
+// Initialize the registry with built-in connectors
+func init() {
+	RegisterConnector(Connector{
+		Scheme:         "csv",
+		SourceNodeType: "csv_reader",
+		SinkNodeType:   "csv_writer",
+		LocationKey:    "path",
+		LocalPath:      true,
+		Options: []OptionSpec{
+			{Name: "header", Type: BoolOption, Default: true},
+			{Name: "delimiter", Type: StringOption, Default: ","},
+			{Name: "inferSchema", Type: BoolOption, Default: false},
+		},
+	})
+
+	RegisterConnector(Connector{
+		Scheme:         "json",
+		SourceNodeType: "json_reader",
+		SinkNodeType:   "json_writer",
+		LocationKey:    "path",
+		LocalPath:      true,
+		Options: []OptionSpec{
+			{Name: "lines", Type: BoolOption, Default: true},
+		},
+	})
+
+	RegisterConnector(Connector{
+		Scheme:         "parquet",
+		SourceNodeType: "parquet_reader",
+		SinkNodeType:   "parquet_writer",
+		LocationKey:    "path",
+		LocalPath:      true,
+		Options: []OptionSpec{
+			{Name: "compression", Type: StringOption, Default: "snappy"},
+			{Name: "overwrite", Type: BoolOption, Default: true},
+		},
+	})
+
+	RegisterConnector(Connector{
+		Scheme:         "kafka",
+		SourceNodeType: "kafka_reader",
+		SinkNodeType:   "kafka_writer",
+		LocationKey:    "topic",
+		Options: []OptionSpec{
+			{Name: "brokers", Type: StringOption},
+			{Name: "group", Type: StringOption},
+			{Name: "offset", Type: StringOption, Default: "latest"},
+			{Name: "batch_size", Type: IntOption, Default: 500},
+			{Name: "poll_interval", Type: DurationOption, Default: time.Second},
+			{Name: "sasl_username", Type: StringOption},
+			{Name: "sasl_password", Type: SecretOption},
+		},
+	})
+
+	RegisterConnector(Connector{
+		Scheme:         "snowflake",
+		Aliases:        []string{"sf"},
+		SourceNodeType: "snowflake_reader",
+		SinkNodeType:   "snowflake_writer",
+		LocationKey:    "table",
+		Options: []OptionSpec{
+			{Name: "account", Type: StringOption},
+			{Name: "warehouse", Type: StringOption},
+			{Name: "role", Type: StringOption},
+			{Name: "batch_size", Type: IntOption, Default: 1000},
+			{Name: "user", Type: StringOption},
+			{Name: "password", Type: SecretOption},
+		},
+	})
+
+	RegisterConnector(Connector{
+		Scheme:         "s3",
+		SourceNodeType: "s3_reader",
+		SinkNodeType:   "s3_writer",
+		LocationKey:    "bucket",
+		Options: []OptionSpec{
+			{Name: "region", Type: StringOption},
+			{Name: "format", Type: StringOption, Default: "json"},
+			{Name: "sse", Type: BoolOption, Default: true},
+			{Name: "access_key_id", Type: StringOption},
+			{Name: "secret_access_key", Type: SecretOption},
+		},
+	})
+}
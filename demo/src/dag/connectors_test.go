@@ -0,0 +1,135 @@
+package dag
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runink/runink/parser"
+)
+
+// TestResolveURIForms tests hierarchical, opaque and bare-path URIs
+func TestResolveURIForms(t *testing.T) {
+	tests := []struct {
+		uri      string
+		role     EndpointRole
+		scheme   string
+		location string
+		nodeType string
+	}{
+		{"csv://data/trades.csv", SourceRole, "csv", "data/trades.csv", "csv_reader"},
+		{"kafka:topics.trade_events", SourceRole, "kafka", "topics.trade_events", "kafka_reader"},
+		{"sf:bronze.trades", SinkRole, "snowflake", "bronze.trades", "snowflake_writer"},
+		{"s3://compliance-archive", SinkRole, "s3", "compliance-archive", "s3_writer"},
+		{"source/sample.csv", SourceRole, "csv", "source/sample.csv", "csv_reader"},
+		{"file:///tmp/out.parquet", SinkRole, "parquet", "/tmp/out.parquet", "parquet_writer"},
+	}
+
+	for _, tt := range tests {
+		endpoint, err := DefaultConnectors.Resolve(tt.uri, tt.role, nil)
+		if err != nil {
+			t.Fatalf("Failed to resolve %s: %v", tt.uri, err)
+		}
+		if endpoint.Scheme != tt.scheme {
+			t.Errorf("%s: expected scheme %s, got %s", tt.uri, tt.scheme, endpoint.Scheme)
+		}
+		if endpoint.Location != tt.location {
+			t.Errorf("%s: expected location %s, got %s", tt.uri, tt.location, endpoint.Location)
+		}
+		if endpoint.NodeType != tt.nodeType {
+			t.Errorf("%s: expected node type %s, got %s", tt.uri, tt.nodeType, endpoint.NodeType)
+		}
+	}
+}
+
+// TestResolveTypedOptions tests that options blocks and query parameters are parsed and typed
+func TestResolveTypedOptions(t *testing.T) {
+	endpoint, err := DefaultConnectors.Resolve(
+		"kafka://topics.trade_events?batch_size=50",
+		SourceRole,
+		map[string]string{"batch_size": "10", "poll_interval": "250ms"},
+	)
+	if err != nil {
+		t.Fatalf("Failed to resolve: %v", err)
+	}
+
+	// Query parameters override the options block
+	if endpoint.Options["batch_size"] != 50 {
+		t.Errorf("Expected batch_size 50, got %v", endpoint.Options["batch_size"])
+	}
+	if endpoint.Options["poll_interval"] != 250*time.Millisecond {
+		t.Errorf("Expected poll_interval 250ms, got %v", endpoint.Options["poll_interval"])
+	}
+	if endpoint.Options["offset"] != "latest" {
+		t.Errorf("Expected default offset 'latest', got %v", endpoint.Options["offset"])
+	}
+
+	config := endpoint.NodeConfig()
+	if config["topic"] != "topics.trade_events" {
+		t.Errorf("Expected topic in node config, got %v", config["topic"])
+	}
+
+	if _, err := DefaultConnectors.Resolve("csv://in.csv?header=maybe", SourceRole, nil); err == nil {
+		t.Error("Expected invalid bool option to fail")
+	}
+	if _, err := DefaultConnectors.Resolve("csv://in.csv?colour=red", SourceRole, nil); err == nil {
+		t.Error("Expected unknown option to fail")
+	}
+}
+
+// TestUnknownSchemeFailsResolution tests that an unregistered scheme is rejected
+func TestUnknownSchemeFailsResolution(t *testing.T) {
+	dsl := parser.DSLFile{
+		Source: "ftp://legacy/trades.csv",
+		Steps:  []string{"transform step1 (sink: \"gopher://nowhere\")"},
+	}
+
+	dag, err := Build(dsl)
+	if err != nil {
+		t.Fatalf("Failed to build DAG: %v", err)
+	}
+
+	err = dag.ResolveConnectors(DefaultConnectors)
+	if err == nil || !strings.Contains(err.Error(), "unknown scheme") {
+		t.Errorf("Expected unknown scheme error, got %v", err)
+	}
+}
+
+// TestRegisterCustomConnector tests that third parties can register schemes
+func TestRegisterCustomConnector(t *testing.T) {
+	registry := NewConnectorRegistry()
+	registry.Register(Connector{
+		Scheme:         "Redis",
+		SourceNodeType: "redis_reader",
+		LocationKey:    "key",
+		Options: []OptionSpec{
+			{Name: "db", Type: IntOption, Required: true},
+		},
+	})
+
+	dsl := parser.DSLFile{
+		Source:        "redis://cache/trades",
+		SourceOptions: map[string]string{"db": "3"},
+	}
+
+	dag, err := Build(dsl)
+	if err != nil {
+		t.Fatalf("Failed to build DAG: %v", err)
+	}
+
+	if err := dag.ResolveConnectors(registry); err != nil {
+		t.Fatalf("Failed to resolve connectors: %v", err)
+	}
+
+	endpoint := dag.Nodes["source"].Endpoints["uri"]
+	if endpoint == nil || endpoint.NodeType != "redis_reader" || endpoint.Options["db"] != 3 {
+		t.Errorf("Unexpected endpoint: %+v", endpoint)
+	}
+
+	if _, err := registry.Resolve("redis://cache/trades", SinkRole, map[string]string{"db": "1"}); err == nil {
+		t.Error("Expected source-only connector to be rejected as a sink")
+	}
+	if _, err := registry.Resolve("redis://cache/trades", SourceRole, nil); err == nil {
+		t.Error("Expected missing required option to fail")
+	}
+}
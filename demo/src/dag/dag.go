@@ -8,6 +8,7 @@ import (
         "strings"
 
         "github.com/runink/runink/parser"
+        "github.com/runink/runink/runtime"
 )
 
 // Node represents a node in the DAG
@@ -18,6 +19,18 @@ type Node struct {
         Type        string
         Config      map[string]interface{}
         Function    func() error
+
+        // Endpoints holds the connector endpoints resolved from the node's
+        // source and sink URIs, keyed by the config key they came from
+        Endpoints   map[string]*Endpoint
+
+        // Filesystem is the access the node's slice is limited to, computed
+        // from its local-path endpoints
+        Filesystem  *FilesystemAccess
+
+        // Secrets are the secrets the node's slice receives at launch,
+        // collected from its config and endpoints
+        Secrets     []runtime.SecretRef
 }
 
 // Edge represents a directed edge between two nodes in the DAG
@@ -91,6 +104,9 @@ func Build(dsl parser.DSLFile) (*DAG, error) {
                         "uri": dsl.Source,
                 },
         }
+        if len(dsl.SourceOptions) > 0 {
+                sourceNode.Config["options"] = dsl.SourceOptions
+        }
         dag.AddNode(sourceNode)
         
         // Track dependencies for each step
@@ -216,6 +232,12 @@ func parseStep(step string) stepInfo {
                         info.name = strings.TrimSpace(parts[1])
                 }
         }
+
+        // A step with a plugin command runs as a plugin node, whatever its
+        // kind, e.g. transform enrich (plugin: "/opt/plugins/enrich")
+        if _, ok := info.config["plugin"]; ok {
+                info.stepType = "plugin"
+        }
         
         return info
 }
@@ -145,3 +145,52 @@ func TestEmptyDAG(t *testing.T) {
                 t.Errorf("Expected 1 edge, got %d", len(dag.Edges))
         }
 }
+
+// TestSetRunID tests that the run ID reaches every node's config
+func TestSetRunID(t *testing.T) {
+        dsl := parser.DSLFile{
+                Feature:  "Run Feature",
+                Scenario: "Run Scenario",
+                Source:   "test://source",
+                Steps:    []string{"transform enrich (plugin: /usr/local/bin/enrich)"},
+        }
+
+        dag, err := Build(dsl)
+        if err != nil {
+                t.Fatalf("Failed to build DAG: %v", err)
+        }
+        dag.SetRunID("run-1a2b3c4d")
+
+        for id, node := range dag.Nodes {
+                if runID, _ := node.Config["run_id"].(string); runID != "run-1a2b3c4d" {
+                        t.Errorf("Expected node %s to run under run-1a2b3c4d, got %q", id, runID)
+                }
+        }
+}
+
+// TestPluginStep tests that a step with a plugin command becomes a plugin node
+func TestPluginStep(t *testing.T) {
+        dsl := parser.DSLFile{
+                Source: "test://source",
+                Steps: []string{
+                        "transform enrich (plugin: \"/opt/plugins/enrich\", memory_max: \"256M\")",
+                        "filter active (after: enrich)",
+                },
+        }
+
+        dag, err := Build(dsl)
+        if err != nil {
+                t.Fatalf("Failed to build DAG: %v", err)
+        }
+
+        enrich := dag.Nodes["step_0"]
+        if enrich.Type != "plugin" || enrich.Name != "enrich" {
+                t.Errorf("Expected plugin node enrich, got %s node %s", enrich.Type, enrich.Name)
+        }
+        if enrich.Config["plugin"] != "/opt/plugins/enrich" || enrich.Config["memory_max"] != "256M" {
+                t.Errorf("Unexpected plugin config: %v", enrich.Config)
+        }
+        if active := dag.Nodes["step_1"]; active.Type != "filter" {
+                t.Errorf("Expected a filter node, got %s", active.Type)
+        }
+}
@@ -0,0 +1,62 @@
+package dag
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// FilesystemAccess lists the paths a node's slice may touch. Sources are
+// read-only, sinks are writable; everything else is denied when the
+// runtime enforces it with Landlock.
+type FilesystemAccess struct {
+	ReadPaths  []string
+	WritePaths []string
+}
+
+// ComputeFilesystemAccess derives every node's filesystem access from its
+// resolved endpoints and exposes it to node factories through the
+// "read_paths" and "write_paths" config keys. Relative locations are made
+// absolute against the current directory. Call it after ResolveConnectors.
+func (d *DAG) ComputeFilesystemAccess() error {
+	for id, node := range d.Nodes {
+		access := &FilesystemAccess{
+			ReadPaths:  []string{},
+			WritePaths: []string{},
+		}
+
+		for _, endpoint := range node.Endpoints {
+			if endpoint.Connector == nil || !endpoint.Connector.LocalPath {
+				continue
+			}
+			path, err := filepath.Abs(endpoint.Location)
+			if err != nil {
+				return fmt.Errorf("node '%s': invalid path %q: %w", id, endpoint.Location, err)
+			}
+			if endpoint.Role == SinkRole {
+				access.WritePaths = appendUnique(access.WritePaths, path)
+			} else {
+				access.ReadPaths = appendUnique(access.ReadPaths, path)
+			}
+		}
+		sort.Strings(access.ReadPaths)
+		sort.Strings(access.WritePaths)
+
+		node.Filesystem = access
+		if node.Config == nil {
+			node.Config = make(map[string]interface{})
+		}
+		node.Config["read_paths"] = access.ReadPaths
+		node.Config["write_paths"] = access.WritePaths
+	}
+	return nil
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, item := range list {
+		if item == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
@@ -0,0 +1,51 @@
+package dag
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/runink/runink/parser"
+)
+
+// TestComputeFilesystemAccess tests that local sources are read-only paths,
+// local sinks are write paths and remote endpoints grant nothing
+func TestComputeFilesystemAccess(t *testing.T) {
+	dsl := parser.DSLFile{
+		Source: "csv:///data/finance/trades.csv",
+		Steps: []string{
+			"transform step1 (sink: \"json://out/rejected.json\")",
+			"transform step2 (sink: \"kafka:topics.trade_events\")",
+		},
+	}
+
+	dag, err := Build(dsl)
+	if err != nil {
+		t.Fatalf("Failed to build DAG: %v", err)
+	}
+	if err := dag.ResolveConnectors(DefaultConnectors); err != nil {
+		t.Fatalf("Failed to resolve connectors: %v", err)
+	}
+	if err := dag.ComputeFilesystemAccess(); err != nil {
+		t.Fatalf("Failed to compute filesystem access: %v", err)
+	}
+
+	source := dag.Nodes["source"].Filesystem
+	if !reflect.DeepEqual(source.ReadPaths, []string{"/data/finance/trades.csv"}) || len(source.WritePaths) != 0 {
+		t.Errorf("Unexpected source access: %+v", source)
+	}
+
+	rejected, _ := filepath.Abs("out/rejected.json")
+	step1 := dag.Nodes["step_0"]
+	if !reflect.DeepEqual(step1.Filesystem.WritePaths, []string{rejected}) || len(step1.Filesystem.ReadPaths) != 0 {
+		t.Errorf("Unexpected step1 access: %+v", step1.Filesystem)
+	}
+	if paths, ok := step1.Config["write_paths"].([]string); !ok || len(paths) != 1 {
+		t.Errorf("Expected write_paths in node config, got %v", step1.Config["write_paths"])
+	}
+
+	step2 := dag.Nodes["step_1"].Filesystem
+	if len(step2.ReadPaths) != 0 || len(step2.WritePaths) != 0 {
+		t.Errorf("Expected no filesystem access for a Kafka sink, got %+v", step2)
+	}
+}
@@ -19,6 +19,21 @@ func BuildFromParsedFiles(dsl parser.DSLFile, contract parser.ContractFile, conf
 	enhanceWithConf(dag, conf)
 	enhanceWithHerd(dag, herd)
 
+	// Resolve source and sink URIs against the registered connectors
+	if err := dag.ResolveConnectors(DefaultConnectors); err != nil {
+		return nil, fmt.Errorf("connector resolution failed: %w", err)
+	}
+
+	// Limit each slice's filesystem access to its declared sources and sinks
+	if err := dag.ComputeFilesystemAccess(); err != nil {
+		return nil, fmt.Errorf("filesystem access: %w", err)
+	}
+
+	// Record the secrets each slice receives at launch
+	if err := dag.ComputeSecrets(); err != nil {
+		return nil, fmt.Errorf("secrets: %w", err)
+	}
+
 	// Validate the enhanced DAG
 	if err := dag.Validate(); err != nil {
 		return nil, fmt.Errorf("DAG validation failed: %w", err)
@@ -106,6 +121,17 @@ func enhanceWithHerd(dag *DAG, herd parser.HerdFile) {
 		// Add resource constraints
 		node.Config["cpu_limit"] = herd.ResourceQuotas.CPULimit
 		node.Config["memory_limit"] = herd.ResourceQuotas.MemoryLimit
+		node.Config["resource_quotas"] = herd.ResourceQuotas
+
+		// Add isolation settings for slices launched through the runtime
+		node.Config["runtime_isolation"] = herd.RuntimeIsolation
+
+		// Tag slice logs and keep them for the herd's retention period
+		node.Config["logs_tag"] = herd.ObservabilityHooks.LogsTag
+		node.Config["log_retention_days"] = herd.RetentionPolicy.LogRetentionDays
+
+		// Scope the secrets its slices may receive
+		node.Config["secrets_scope"] = herd.SecretsScope
 	}
 }
 
@@ -132,3 +158,14 @@ func isConfigRelevantToNode(key string, node *Node) bool {
 	
 	return false
 }
+
+// SetRunID records the run the DAG executes under on every node, so that
+// plugin slices are registered and logged under that run
+func (d *DAG) SetRunID(runID string) {
+	for _, node := range d.Nodes {
+		if node.Config == nil {
+			node.Config = make(map[string]interface{})
+		}
+		node.Config["run_id"] = runID
+	}
+}
@@ -0,0 +1,93 @@
+package dag
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/runink/runink/runtime"
+)
+
+// ComputeSecrets collects the secrets each node's slice receives at launch
+// and exposes them to node factories as mount specs under the "secrets"
+// config key, see runtime.ParseSecretMount. A node gets:
+//
+//   - the mounts listed in its own "secrets" config
+//   - each config value that is a secret reference, in the variable named
+//     after its key, e.g. db_password: "secret://finance/db" as DB_PASSWORD
+//   - the secret options of its endpoints, in their default variables
+//
+// Only references are recorded; values are read when the slice launches.
+// Call it after ResolveConnectors.
+func (d *DAG) ComputeSecrets() error {
+	for id, node := range d.Nodes {
+		var specs []string
+		switch secrets := node.Config["secrets"].(type) {
+		case string:
+			specs = strings.Split(secrets, ",")
+		case []string:
+			specs = secrets
+		}
+
+		keys := make([]string, 0, len(node.Config))
+		for key := range node.Config {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value, ok := node.Config[key].(string)
+			if key == "secrets" || !ok || !runtime.IsSecretRef(value) {
+				continue
+			}
+			specs = append(specs, envName(key)+"="+value)
+		}
+
+		var mounts []string
+		var refs []runtime.SecretRef
+		for _, spec := range specs {
+			if strings.TrimSpace(spec) == "" {
+				continue
+			}
+			mount, err := runtime.ParseSecretMount(spec)
+			if err != nil {
+				return fmt.Errorf("node '%s': %w", id, err)
+			}
+			mounts = appendUnique(mounts, strings.TrimSpace(spec))
+			refs = appendRef(refs, mount.Ref)
+		}
+		for _, endpoint := range node.Endpoints {
+			for _, ref := range endpoint.Secrets() {
+				mounts = appendUnique(mounts, ref.String())
+				refs = appendRef(refs, ref)
+			}
+		}
+		if len(refs) == 0 {
+			continue
+		}
+		sort.Strings(mounts)
+		sort.Slice(refs, func(i, j int) bool { return refs[i].String() < refs[j].String() })
+
+		node.Secrets = refs
+		node.Config["secrets"] = mounts
+	}
+	return nil
+}
+
+// envName turns a config key into an environment variable name
+func envName(key string) string {
+	return strings.Map(func(c rune) rune {
+		if (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' {
+			return c
+		}
+		return '_'
+	}, strings.ToUpper(key))
+}
+
+func appendRef(refs []runtime.SecretRef, ref runtime.SecretRef) []runtime.SecretRef {
+	for _, r := range refs {
+		if r == ref {
+			return refs
+		}
+	}
+	return append(refs, ref)
+}
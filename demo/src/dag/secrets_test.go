@@ -0,0 +1,90 @@
+package dag
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/runink/runink/parser"
+	"github.com/runink/runink/runtime"
+)
+
+// TestComputeSecrets tests that secret references in step config and
+// connector options become mounts, and plain credentials are refused
+func TestComputeSecrets(t *testing.T) {
+	dsl := parser.DSLFile{
+		Source: "snowflake://bronze.trades?user=etl&password=secret://finance/warehouse_password",
+		Steps: []string{
+			"plugin enrich (plugin: \"/usr/bin/enrich\", db_password: \"secret://finance/db\")",
+		},
+	}
+
+	dag, err := Build(dsl)
+	if err != nil {
+		t.Fatalf("Failed to build DAG: %v", err)
+	}
+	if err := dag.ResolveConnectors(DefaultConnectors); err != nil {
+		t.Fatalf("Failed to resolve connectors: %v", err)
+	}
+	if err := dag.ComputeSecrets(); err != nil {
+		t.Fatalf("Failed to compute secrets: %v", err)
+	}
+
+	source := dag.Nodes["source"]
+	warehouse := runtime.SecretRef{Herd: "finance", Name: "warehouse_password"}
+	if !reflect.DeepEqual(source.Secrets, []runtime.SecretRef{warehouse}) {
+		t.Errorf("Unexpected source secrets: %v", source.Secrets)
+	}
+	for key, endpoint := range source.Endpoints {
+		if endpoint.Options["password"] != warehouse {
+			t.Errorf("Expected the %s password option to hold a reference, got %v", key, endpoint.Options["password"])
+		}
+	}
+
+	step := dag.Nodes["step_0"]
+	if !reflect.DeepEqual(step.Config["secrets"], []string{"DB_PASSWORD=secret://finance/db"}) {
+		t.Errorf("Unexpected step mounts: %v", step.Config["secrets"])
+	}
+
+	_, err = DefaultConnectors.Resolve("snowflake://bronze.trades?password=hunter2", SourceRole, nil)
+	if err == nil {
+		t.Fatal("Expected a plain-text password to be refused")
+	}
+	if strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("Expected the error to leave out the credential, got %v", err)
+	}
+}
+
+// TestEndpointLaunchConfig tests that secret options are replaced by the
+// values delivered to the slice
+func TestEndpointLaunchConfig(t *testing.T) {
+	endpoint, err := DefaultConnectors.Resolve("snowflake://bronze.trades?user=etl&password=secret://finance/warehouse_password", SourceRole, nil)
+	if err != nil {
+		t.Fatalf("Failed to resolve endpoint: %v", err)
+	}
+
+	t.Setenv("RUNINK_SECRET_FINANCE_WAREHOUSE_PASSWORD", "")
+	os.Unsetenv("RUNINK_SECRET_FINANCE_WAREHOUSE_PASSWORD")
+	if _, err := endpoint.LaunchConfig(); !errors.Is(err, runtime.ErrSecretNotDelivered) {
+		t.Errorf("Expected an undelivered secret to fail, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "warehouse_password")
+	if err := os.WriteFile(path, []byte("s3cret"), 0600); err != nil {
+		t.Fatalf("Failed to write secret: %v", err)
+	}
+	t.Setenv("RUNINK_SECRET_FINANCE_WAREHOUSE_PASSWORD_FILE", path)
+	config, err := endpoint.LaunchConfig()
+	if err != nil {
+		t.Fatalf("Failed to build launch config: %v", err)
+	}
+	if config["password"] != "s3cret" || config["user"] != "etl" || config["table"] != "bronze.trades" {
+		t.Errorf("Unexpected launch config: %v", config)
+	}
+	if endpoint.Options["password"] != (runtime.SecretRef{Herd: "finance", Name: "warehouse_password"}) {
+		t.Errorf("Expected the endpoint to keep the reference, got %v", endpoint.Options["password"])
+	}
+}
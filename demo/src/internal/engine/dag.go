@@ -14,6 +14,7 @@ type DAGConfig struct {
         ConfFile     string
         DSLFile      string
         HerdFile     string
+        RunID        string
         Verbose      bool
 }
 
@@ -73,6 +74,11 @@ func ExecuteDAG(config DAGConfig) error {
                 return fmt.Errorf("failed to build DAG: %v", err)
         }
 
+        // Register the slices of the DAG under the run
+        if config.RunID != "" {
+                dagInstance.SetRunID(config.RunID)
+        }
+
         if config.Verbose {
                 fmt.Println("DAG structure:")
                 fmt.Println(dagInstance.String())
@@ -42,6 +42,24 @@ type ErrorHandler interface {
 	GetErrors() map[string]error
 }
 
+// failureReasoner is implemented by errors that classify why a node
+// failed, such as the runtime's SliceError
+type failureReasoner interface {
+	FailureReason() string
+}
+
+// FailureReason returns the classification of a node failure, such as
+// "OOMKilled", "PidsExhausted" or "Timeout", or "" if err carries none.
+// Retry logic can use it to re-run a slice with more memory rather than
+// retrying blindly.
+func FailureReason(err error) string {
+	var reasoner failureReasoner
+	if errors.As(err, &reasoner) {
+		return reasoner.FailureReason()
+	}
+	return ""
+}
+
 // DefaultErrorHandler provides a basic implementation of ErrorHandler
 type DefaultErrorHandler struct {
 	mu     sync.Mutex
@@ -79,6 +97,21 @@ func (h *DefaultErrorHandler) GetErrors() map[string]error {
 	return result
 }
 
+// GetFailureReasons returns the classification of each classified node
+// failure, keyed by node ID
+func (h *DefaultErrorHandler) GetFailureReasons() map[string]string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	reasons := make(map[string]string)
+	for nodeID, err := range h.errors {
+		if reason := FailureReason(err); reason != "" {
+			reasons[nodeID] = reason
+		}
+	}
+	return reasons
+}
+
 // Monitor defines the interface for monitoring DAG execution
 type Monitor interface {
 	// OnStart is called when a node starts execution
@@ -114,6 +147,10 @@ func (m *DefaultMonitor) OnSuccess(nodeID string, duration time.Duration) {
 
 // OnError implements Monitor.OnError
 func (m *DefaultMonitor) OnError(nodeID string, err error, duration time.Duration) {
+	if reason := FailureReason(err); reason != "" {
+		fmt.Printf("Node %s failed after %v (%s): %v\n", nodeID, duration, reason, err)
+		return
+	}
 	fmt.Printf("Node %s failed after %v: %v\n", nodeID, duration, err)
 }
 
@@ -138,12 +175,34 @@ type Node struct {
 type DAG struct {
 	Nodes       map[string]*Node
 	TopOrder    []string
-	Isolate     bool // Whether to use Linux isolation features
+	Isolate     bool // Whether to run nodes in sandboxed children, see SliceRunner
 	IsolationID string // Identifier for isolation namespace
 }
 
 // Execute runs the DAG with the specified execution mode, monitor, and error handler
-func Execute(ctx context.Context, dag *DAG, mode ExecutionMode, monitor Monitor, errHandler ErrorHandler) error {
+func Execute(ctx context.Context, dag *DAG, mode ExecutionMode, monitor Monitor, errHandler ErrorHandler, opts ...ExecuteOption) error {
+	options := executeOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	
+	// Run nodes in sandboxed children when isolation is requested
+	var sliceRunner *SliceRunner
+	level := options.isolation
+	if dag.Isolate && level == NoIsolation {
+		level = ProcessIsolation
+	}
+	if level != NoIsolation {
+		sliceRunner = &SliceRunner{
+			Level:       level,
+			Args:        options.sliceArgs,
+			HostPaths:   options.hostPaths,
+			Codec:       options.codec,
+			IsolationID: dag.IsolationID,
+			GracePeriod: options.grace,
+		}
+	}
+	
 	if monitor == nil {
 		monitor = NewDefaultMonitor()
 	}
@@ -195,13 +254,6 @@ func Execute(ctx context.Context, dag *DAG, mode ExecutionMode, monitor Monitor,
 		
 		node := dag.Nodes[nodeID]
 		
-		// Setup isolation if required
-		if dag.Isolate {
-			// In a real implementation, this would set up Linux namespaces and cgroups
-			// For this demo, we'll just log that isolation would happen
-			fmt.Printf("Node %s would run in isolated environment %s\n", nodeID, dag.IsolationID)
-		}
-		
 		// Notify monitor that node is starting
 		nodeStartTime := time.Now()
 		monitor.OnStart(nodeID)
@@ -264,8 +316,12 @@ func Execute(ctx context.Context, dag *DAG, mode ExecutionMode, monitor Monitor,
 				}
 			}()
 			
-			// Execute the node function
-			result, err = node.Function(execCtx, inputChan)
+			// Execute the node function, in a sandbox if isolation is enabled
+			if sliceRunner != nil {
+				result, err = sliceRunner.Run(execCtx, nodeID, inputChan)
+			} else {
+				result, err = node.Function(execCtx, inputChan)
+			}
 		}()
 		
 		duration := time.Since(nodeStartTime)
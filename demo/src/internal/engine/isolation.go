@@ -0,0 +1,213 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/runink/runink/runtime"
+)
+
+// IsolationLevel defines how nodes are sandboxed from the engine and from each other
+type IsolationLevel int
+
+const (
+	// NoIsolation runs node functions inside the engine process
+	NoIsolation IsolationLevel = iota
+
+	// ProcessIsolation runs each node in its own child process with private
+	// PID, IPC and UTS namespaces and its own cgroup. The herd's seccomp
+	// profile, Landlock rules and resource limits are not applied.
+	ProcessIsolation
+
+	// ContainerIsolation additionally gives each node a private mount
+	// namespace and a layered root filesystem, still without the herd's
+	// seccomp profile, Landlock rules and resource limits
+	ContainerIsolation
+)
+
+// String returns the string representation of the isolation level
+func (l IsolationLevel) String() string {
+	switch l {
+	case ProcessIsolation:
+		return "process"
+	case ContainerIsolation:
+		return "container"
+	default:
+		return "none"
+	}
+}
+
+// SliceExecCommand is the subcommand a sandboxed child runs to execute a single node
+const SliceExecCommand = "slice-exec"
+
+// ExecuteOption configures optional behavior of Execute
+type ExecuteOption func(*executeOptions)
+
+// executeOptions holds the settings applied by ExecuteOptions
+type executeOptions struct {
+	isolation IsolationLevel
+	sliceArgs []string
+	hostPaths []string
+	codec     Codec
+	grace     time.Duration
+}
+
+// WithIsolationLevel runs every node in a sandboxed child at the given level
+func WithIsolationLevel(level IsolationLevel) ExecuteOption {
+	return func(o *executeOptions) {
+		o.isolation = level
+	}
+}
+
+// WithSliceExec sets the arguments the slice-exec command needs to rebuild
+// the DAG, and the host files it reads to do so
+func WithSliceExec(args []string, hostPaths []string) ExecuteOption {
+	return func(o *executeOptions) {
+		o.sliceArgs = args
+		o.hostPaths = hostPaths
+	}
+}
+
+// WithCodec sets the codec used to stream data to and from sandboxed nodes
+func WithCodec(codec Codec) ExecuteOption {
+	return func(o *executeOptions) {
+		o.codec = codec
+	}
+}
+
+// WithGracePeriod sets how long a cancelled sandboxed node has to exit
+// after SIGTERM before it is killed
+func WithGracePeriod(grace time.Duration) ExecuteOption {
+	return func(o *executeOptions) {
+		o.grace = grace
+	}
+}
+
+// SliceRunner runs nodes in sandboxed children of the runink binary. Each
+// child runs the slice-exec command, which rebuilds the DAG from Args and
+// executes a single node. The node's input and output are streamed over the
+// child's stdin and stdout as codec-encoded frames, so values crossing the
+// sandbox arrive as the codec decodes them (maps, slices and scalars for JSON).
+type SliceRunner struct {
+	// Level selects the sandbox, ProcessIsolation or ContainerIsolation
+	Level IsolationLevel
+
+	// Binary is the runink executable. Default: the running executable
+	Binary string
+
+	// Args are passed to slice-exec after the node flags
+	Args []string
+
+	// HostPaths are host files bound read-only into container sandboxes,
+	// e.g. the files the DAG is built from
+	HostPaths []string
+
+	// Codec encodes the node's input and output. Default: DefaultCodec
+	Codec Codec
+
+	// IsolationID prefixes the nodes' cgroup names and registers the
+	// children under this run ID, so that "runi kill" can stop them
+	IsolationID string
+
+	// GracePeriod is how long a node has to exit after SIGTERM once ctx is
+	// cancelled. Default: runtime.DefaultGracePeriod
+	GracePeriod time.Duration
+}
+
+// Run executes the node in a sandboxed child, feeding it the values
+// received on input, and returns the value the node produced
+func (s *SliceRunner) Run(ctx context.Context, nodeID string, input <-chan any) (any, error) {
+	binary := s.Binary
+	if binary == "" {
+		var err error
+		if binary, err = os.Executable(); err != nil {
+			return nil, fmt.Errorf("failed to locate the runink binary: %w", err)
+		}
+	}
+	codec := s.Codec
+	if codec == nil {
+		codec = DefaultCodec
+	}
+
+	command := append([]string{binary, SliceExecCommand, "--node", nodeID, "--codec", codec.Name()}, s.Args...)
+	executor := runtime.NewExecutor().
+		SetCommand(command).
+		SetCgroupName(fmt.Sprintf("runink-%s-%s", s.IsolationID, nodeID)).
+		SetStderr(os.Stderr).
+		SetContext(ctx).
+		SetRunID(s.IsolationID).
+		SetGracePeriod(s.GracePeriod)
+
+	switch s.Level {
+	case ContainerIsolation:
+		workDir, err := os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get working directory: %w", err)
+		}
+		executor.
+			SetNamespaces(runtime.DefaultNamespaces).
+			SetRootfs(runtime.RootfsSpec{
+				HostPaths:     append(append([]string{}, runtime.DefaultHostPaths...), s.HostPaths...),
+				WritablePaths: []string{workDir},
+			}).
+			SetWorkDir(workDir)
+	default:
+		executor.
+			SetNamespaces(runtime.CLONE_NEWPID | runtime.CLONE_NEWIPC | runtime.CLONE_NEWUTS).
+			SetChrootDir("/")
+		if workDir, err := os.Getwd(); err == nil {
+			executor.SetWorkDir(workDir)
+		}
+	}
+
+	stdinReader, stdinWriter := io.Pipe()
+	stdoutReader, stdoutWriter := io.Pipe()
+	executor.SetStdin(stdinReader).SetStdout(stdoutWriter)
+
+	// Stream the input to the slice
+	go func() {
+		stdinWriter.CloseWithError(runtime.WriteSliceInputs(ctx, stdinWriter, codec, input))
+	}()
+
+	// Read the result, draining stdout so the slice never blocks on it
+	var output any
+	var outputErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		output, outputErr = runtime.ReadSliceResult(stdoutReader, codec)
+		io.Copy(io.Discard, stdoutReader)
+	}()
+
+	result, err := executor.Execute()
+	stdoutWriter.Close()
+	stdinReader.Close()
+	<-done
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to run node %s in a sandbox: %w", nodeID, err)
+	}
+
+	// A slice stopped by the runtime may not have reported anything itself
+	failure := result.Failure("node " + nodeID)
+	if failure != nil && result.FailureReason != "" {
+		return nil, failure
+	}
+	if outputErr != nil {
+		return nil, fmt.Errorf("node %s: %w", nodeID, outputErr)
+	}
+	if failure != nil {
+		return nil, failure
+	}
+
+	return output, nil
+}
+
+// ServeSlice executes node as a sandboxed slice, see runtime.ServeSlice.
+// It is the body of the slice-exec command.
+func ServeSlice(ctx context.Context, node *Node, codec Codec, r io.Reader, w io.Writer) error {
+	return runtime.ServeSlice(ctx, node.ID, node.Function, codec, r, w)
+}
@@ -26,6 +26,8 @@ type NodeMetric struct {
         CPUTimeEnd   time.Duration `json:"cpuTimeEnd"`
         Status       NodeState     `json:"status"`
         Error        string        `json:"error,omitempty"`
+        // FailureReason classifies the failure, e.g. "OOMKilled", see FailureReason
+        FailureReason string       `json:"failureReason,omitempty"`
         RetryCount   int           `json:"retryCount"`
 }
 
@@ -124,6 +126,7 @@ func (m *Monitor) StartNode(nodeID, nodeName string) func(status NodeState, err
                 
                 if err != nil {
                         metric.Error = err.Error()
+                        metric.FailureReason = FailureReason(err)
                 }
                 
                 // Log node completion
@@ -145,6 +148,9 @@ func (m *Monitor) StartNode(nodeID, nodeName string) func(status NodeState, err
                 if err != nil {
                         errStr = fmt.Sprintf(" error=%v", err)
                 }
+                if metric.FailureReason != "" {
+                        errStr += " reason=" + metric.FailureReason
+                }
                 log.Printf("%s Completed node: %s (%s) duration=%v status=%s retries=%d%s",
                         statusSymbol, nodeName, nodeID, metric.Duration, status, retryCount, errStr)
         }
@@ -7,9 +7,13 @@ package main
 import (
 	"github.com/runink/runink/cmd"
 	"github.com/runink/runink/nodes"
+	"github.com/runink/runink/runtime"
 )
 
 func main() {
+	// Act as slice init when re-executed by the runtime executor
+	runtime.SliceInit()
+
 	// Register nodes with the engine
 	nodes.IntegrateWithEngine()
 	
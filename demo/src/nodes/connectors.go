@@ -0,0 +1,27 @@
+// Package nodes provides node implementations for the RunInk DAG execution engine.
+package nodes
+
+import (
+	"fmt"
+
+	"github.com/runink/runink/dag"
+)
+
+// CreateConnectorNode creates the node implementation for a resolved
+// connector endpoint using the factory registered for its node type. Secret
+// options are passed as the values delivered to the slice.
+func (r *NodeRegistry) CreateConnectorNode(id string, endpoint *dag.Endpoint) (interface{}, error) {
+	if endpoint == nil {
+		return nil, fmt.Errorf("no endpoint resolved for node %s", id)
+	}
+	config, err := endpoint.LaunchConfig()
+	if err != nil {
+		return nil, fmt.Errorf("node %s: %w", id, err)
+	}
+	return r.CreateNode(endpoint.NodeType, id, config)
+}
+
+// CreateConnectorNode creates a connector node using the default registry
+func CreateConnectorNode(id string, endpoint *dag.Endpoint) (interface{}, error) {
+	return DefaultRegistry.CreateConnectorNode(id, endpoint)
+}
@@ -4,6 +4,7 @@ package nodes
 import (
 	"context"
 	"fmt"
+	"os"
 
 	"github.com/runink/runink/dag"
 	"github.com/runink/runink/internal/engine"
@@ -11,8 +12,9 @@ import (
 
 // IntegrateWithEngine integrates the CSV and Parquet nodes with the RunInk engine
 func IntegrateWithEngine() {
-	// Register our nodes with the engine
-	fmt.Println("Integrating CSV and Parquet nodes with RunInk engine")
+	// Register our nodes with the engine. Log to stderr: stdout carries
+	// data frames when the binary runs a sandboxed node
+	fmt.Fprintln(os.Stderr, "Integrating CSV and Parquet nodes with RunInk engine")
 	
 	// Register with the default registry
 	RegisterWithEngine(DefaultRegistry)
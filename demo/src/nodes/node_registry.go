@@ -70,6 +70,11 @@ func init() {
 	Register("parquet_writer", func(id string, config map[string]interface{}) (interface{}, error) {
 		return NewParquetWriterNode(id, config)
 	})
+
+	// Register out-of-process plugin step node
+	Register("plugin", func(id string, config map[string]interface{}) (interface{}, error) {
+		return NewPluginNode(id, config)
+	})
 }
 
 // CreateDAGNode creates a DAG node from a node implementation
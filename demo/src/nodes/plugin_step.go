@@ -0,0 +1,293 @@
+// Package nodes provides node implementations for the RunInk DAG execution engine.
+package nodes
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/runink/runink/parser"
+	"github.com/runink/runink/runtime"
+)
+
+// PluginResult holds the records produced by a plugin step
+type PluginResult struct {
+	Records     []map[string]interface{}
+	DeadLetters []map[string]interface{}
+}
+
+// PluginNode implements a node backed by an out-of-process NDJSON plugin
+type PluginNode struct {
+	ID   string
+	Step *runtime.PluginStep
+}
+
+// NewPluginNode creates a new plugin node
+func NewPluginNode(id string, config map[string]interface{}) (*PluginNode, error) {
+	// Extract the plugin command from config
+	var command []string
+	switch cmd := config["plugin"].(type) {
+	case string:
+		command = strings.Fields(cmd)
+	case []string:
+		command = cmd
+	}
+	if len(command) == 0 {
+		return nil, fmt.Errorf("plugin command is required for plugin node")
+	}
+
+	step := runtime.NewPluginStep(id, command)
+
+	// Apply the herd's isolation settings
+	if isolation, ok := config["runtime_isolation"].(parser.RuntimeIsolation); ok {
+		step.Namespaces = runtime.HerdNamespaces(isolation)
+
+		seccomp, err := runtime.HerdSeccompProfile(isolation)
+		if err != nil {
+			return nil, err
+		}
+		step.Seccomp = seccomp
+	}
+
+	// Run in the herd's cgroup with the per-slice minimums
+	if herd, ok := config["herd_id"].(string); ok && herd != "" {
+		step.Herd = herd
+		if quotas, ok := config["resource_quotas"].(parser.ResourceQuotasSection); ok {
+			herdQuota, sliceLimits, err := runtime.HerdLimits(quotas)
+			if err != nil {
+				return nil, err
+			}
+			step.HerdQuota = herdQuota
+			step.Limits.CPUMin = sliceLimits.CPUMin
+			step.Limits.MemoryMin = sliceLimits.MemoryMin
+		}
+	}
+
+	// Limit filesystem access to the paths computed when the DAG was compiled
+	readPaths, hasRead := config["read_paths"].([]string)
+	writePaths, hasWrite := config["write_paths"].([]string)
+	if hasRead || hasWrite {
+		step.Landlock = &runtime.LandlockRuleset{
+			ReadPaths:  readPaths,
+			WritePaths: writePaths,
+		}
+	}
+
+	// Extract optional resource limits
+	if cpu, ok := config["cpu_quota"].(string); ok {
+		step.Limits.CPUQuota = cpu
+	}
+	if memory, ok := config["memory_max"].(string); ok {
+		step.Limits.MemoryMax = memory
+	}
+	if weight, ok := config["io_weight"].(string); ok {
+		step.Limits.IOWeight = weight
+	}
+	if pids, ok := config["pids_max"].(string); ok {
+		step.Limits.PidsMax = pids
+	}
+	if io, ok := config["io_max"].(string); ok {
+		step.Limits.IOMax = io
+	}
+	switch timeout := config["timeout"].(type) {
+	case string:
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout for plugin node: %w", err)
+		}
+		step.Timeout = d
+	case time.Duration:
+		step.Timeout = timeout
+	case int:
+		step.Timeout = time.Duration(timeout) * time.Second
+	}
+	if grace, ok := config["grace_period"].(string); ok {
+		d, err := time.ParseDuration(grace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid grace_period for plugin node: %w", err)
+		}
+		step.GracePeriod = d
+	}
+	if rootfs, ok := config["rootfs"].(string); ok {
+		step.ChrootDir = rootfs
+	}
+
+	// Register the plugin under its run so that runi kill can stop it
+	step.RunID, _ = config["run_id"].(string)
+
+	// Capture plugin logs in per-run slice logs tagged for the herd
+	logDir, _ := config["log_dir"].(string)
+	logsTag, _ := config["logs_tag"].(string)
+	if logDir != "" || logsTag != "" {
+		step.Logs = &runtime.LogConfig{Dir: logDir, Tag: logsTag, RunID: step.RunID}
+		step.Logs.RetentionDays, _ = config["log_retention_days"].(int)
+	}
+
+	// Deliver the secrets collected when the DAG was compiled, e.g.
+	// "DB_PASSWORD=secret://finance/warehouse_password"
+	var secrets []string
+	switch mounts := config["secrets"].(type) {
+	case string:
+		secrets = strings.Split(mounts, ",")
+	case []string:
+		secrets = mounts
+	}
+	if len(secrets) > 0 {
+		delivery, _ := config["secret_delivery"].(string)
+		spec := &runtime.SecretSpec{}
+		var err error
+		if spec.Delivery, err = runtime.ParseSecretDelivery(delivery); err != nil {
+			return nil, err
+		}
+		for _, secret := range secrets {
+			mount, err := runtime.ParseSecretMount(secret)
+			if err != nil {
+				return nil, err
+			}
+			spec.Mounts = append(spec.Mounts, mount)
+		}
+		herd, _ := config["herd_id"].(string)
+		scope, _ := config["secrets_scope"].(parser.SecretsScope)
+		spec.Scope = runtime.HerdSecretScope(herd, scope)
+		endpoint, _ := config["barn_endpoint"].(string)
+		spec.Source = &runtime.BarnSecrets{Endpoint: endpoint}
+		step.Secrets = spec
+	}
+
+	// Extract the egress allow-list, e.g. "5432=db.internal:5432"
+	var egress []string
+	switch rules := config["egress"].(type) {
+	case string:
+		egress = strings.Split(rules, ",")
+	case []string:
+		egress = rules
+	}
+	for _, spec := range egress {
+		rule, err := runtime.ParseEgressRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		step.Egress = append(step.Egress, rule)
+	}
+
+	return &PluginNode{
+		ID:   id,
+		Step: step,
+	}, nil
+}
+
+// Execute streams the input records through the plugin. Records are
+// written to the plugin as they arrive and decoded as it produces them.
+func (n *PluginNode) Execute(ctx context.Context, input <-chan any) (any, error) {
+	stdinReader, stdinWriter := io.Pipe()
+	stdoutReader, stdoutWriter := io.Pipe()
+	dlqReader, dlqWriter := io.Pipe()
+
+	// Encode the input records as NDJSON
+	go func() {
+		stdinWriter.CloseWithError(encodeRecords(ctx, stdinWriter, input))
+	}()
+
+	// Decode the plugin's records and dead letters
+	var records, deadLetters []map[string]interface{}
+	var recordsErr, deadLettersErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		records, recordsErr = decodeRecords(stdoutReader)
+	}()
+	go func() {
+		defer wg.Done()
+		deadLetters, deadLettersErr = decodeRecords(dlqReader)
+	}()
+
+	n.Step.DLQ = dlqWriter
+	err := n.Step.RunContext(ctx, stdinReader, stdoutWriter)
+	stdinReader.Close()
+	stdoutWriter.Close()
+	dlqWriter.Close()
+	wg.Wait()
+
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if recordsErr != nil {
+		return nil, recordsErr
+	}
+	if deadLettersErr != nil {
+		return nil, deadLettersErr
+	}
+
+	return &PluginResult{
+		Records:     records,
+		DeadLetters: deadLetters,
+	}, nil
+}
+
+// encodeRecords writes the records received on input to w as NDJSON. The
+// input is drained even if the plugin stops reading.
+func encodeRecords(ctx context.Context, w io.Writer, input <-chan any) error {
+	encoder := json.NewEncoder(w)
+	for {
+		var item any
+		var ok bool
+		select {
+		case item, ok = <-input:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if !ok {
+			return nil
+		}
+
+		records, isRecords := item.([]map[string]interface{})
+		if !isRecords {
+			records = []map[string]interface{}{{"data": item}}
+			if record, isRecord := item.(map[string]interface{}); isRecord {
+				records[0] = record
+			}
+		}
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				for range input {
+				}
+				return fmt.Errorf("failed to encode record: %w", err)
+			}
+		}
+	}
+}
+
+// decodeRecords decodes NDJSON lines into records. The stream is always
+// drained to EOF so the plugin's output never stalls.
+func decodeRecords(r io.Reader) ([]map[string]interface{}, error) {
+	var records []map[string]interface{}
+	var decodeErr error
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 && decodeErr == nil {
+			var record map[string]interface{}
+			if err := json.Unmarshal(line, &record); err != nil {
+				decodeErr = fmt.Errorf("failed to decode plugin record: %w", err)
+			} else {
+				records = append(records, record)
+			}
+		}
+		if err == io.EOF {
+			return records, decodeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
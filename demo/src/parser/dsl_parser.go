@@ -5,6 +5,7 @@ import (
 	"bufio"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -18,6 +19,7 @@ func ParseDSL(path string) (DSLFile, error) {
 
 	dsl := DSLFile{
 		Metadata:      make(map[string]interface{}),
+		SourceOptions: make(map[string]string),
 		Steps:         []string{},
 		Assertions:    []string{},
 		Notifications: []Notification{},
@@ -31,6 +33,7 @@ func ParseDSL(path string) (DSLFile, error) {
 	inAssertions := false
 	inGoldenTest := false
 	inNotifications := false
+	inSourceOptions := false
 
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
@@ -40,6 +43,20 @@ func ParseDSL(path string) (DSLFile, error) {
 			continue
 		}
 
+		// Source options block, e.g. options { delimiter = ";" }
+		if !inSourceOptions && strings.HasPrefix(line, "options") && strings.Contains(line, "{") {
+			line = strings.TrimSpace(line[strings.Index(line, "{")+1:])
+			inSourceOptions = true
+		}
+		if inSourceOptions {
+			entries, closed := splitSourceOptions(line)
+			for _, entry := range entries {
+				parseSourceOption(&dsl, entry)
+			}
+			inSourceOptions = !closed
+			continue
+		}
+
 		// Feature detection
 		if strings.HasPrefix(line, "Feature:") {
 			dsl.Feature = strings.TrimSpace(strings.TrimPrefix(line, "Feature:"))
@@ -187,3 +204,53 @@ func ParseDSL(path string) (DSLFile, error) {
 
 	return dsl, nil
 }
+
+// splitSourceOptions splits a line of a source options block into its
+// comma-separated entries, leaving commas and braces inside quoted values
+// alone. closed reports whether the line ends the block.
+func splitSourceOptions(line string) (entries []string, closed bool) {
+	start := 0
+	inQuote := false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && inQuote:
+			i++
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case c == ',':
+			entries = append(entries, line[start:i])
+			start = i + 1
+		case c == '}':
+			return append(entries, line[start:i]), true
+		}
+	}
+	return append(entries, line[start:]), false
+}
+
+// parseSourceOption parses a single "key = value" or "key: value" entry
+// from a source options block. Quoted values may hold any character.
+func parseSourceOption(dsl *DSLFile, entry string) {
+	entry = strings.TrimSpace(entry)
+	if entry == "" {
+		return
+	}
+
+	sep := strings.IndexAny(entry, "=:")
+	if sep == -1 {
+		return
+	}
+
+	key := strings.TrimSpace(entry[:sep])
+	value := strings.TrimSpace(entry[sep+1:])
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = value[1 : len(value)-1]
+		}
+	}
+	if key != "" {
+		dsl.SourceOptions[key] = value
+	}
+}
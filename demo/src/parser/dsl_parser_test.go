@@ -0,0 +1,84 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// parseDSLString writes a DSL to a temporary file and parses it
+func parseDSLString(t *testing.T, content string) DSLFile {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pipeline.dsl")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write DSL: %v", err)
+	}
+	dsl, err := ParseDSL(path)
+	if err != nil {
+		t.Fatalf("Failed to parse DSL: %v", err)
+	}
+	return dsl
+}
+
+// TestParseSourceOptions tests the entries of a source options block
+func TestParseSourceOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  string
+		expected map[string]string
+	}{
+		{
+			name:     "one option per line",
+			options:  "options {\n  header = true\n  inferSchema = false\n}",
+			expected: map[string]string{"header": "true", "inferSchema": "false"},
+		},
+		{
+			name:     "comma-separated options",
+			options:  "options { header = true, compression = \"snappy\" }",
+			expected: map[string]string{"header": "true", "compression": "snappy"},
+		},
+		{
+			name:     "quoted commas",
+			options:  "options {\n  brokers = \"b1:9092,b2:9092\", topic = \"trades\"\n}",
+			expected: map[string]string{"brokers": "b1:9092,b2:9092", "topic": "trades"},
+		},
+		{
+			name:     "quoted delimiter",
+			options:  "options { delimiter = \",\" }",
+			expected: map[string]string{"delimiter": ","},
+		},
+		{
+			name:     "quoted brace and escaped quote",
+			options:  "options {\n  quote = \"\\\"\"\n  template = \"{id}\"\n}",
+			expected: map[string]string{"quote": "\"", "template": "{id}"},
+		},
+		{
+			name:     "colon separator",
+			options:  "options {\n  brokers: \"b1:9092\"\n  header: true\n}",
+			expected: map[string]string{"brokers": "b1:9092", "header": "true"},
+		},
+		{
+			name:     "first separator wins",
+			options:  "options {\n  url = \"http://barn:8080/?a=b\"\n  mode: \"k=v\"\n}",
+			expected: map[string]string{"url": "http://barn:8080/?a=b", "mode": "k=v"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dsl := parseDSLString(t, "Feature: Trades\n"+tt.options+"\nScenario: Load\n")
+			if len(dsl.SourceOptions) != len(tt.expected) {
+				t.Errorf("Expected %d options, got %v", len(tt.expected), dsl.SourceOptions)
+			}
+			for key, value := range tt.expected {
+				if got, ok := dsl.SourceOptions[key]; !ok || got != value {
+					t.Errorf("Expected %s = %q, got %q", key, value, got)
+				}
+			}
+			// The block is closed, so later lines are parsed as usual
+			if dsl.Scenario != "Load" {
+				t.Errorf("Expected the scenario after the block, got %q", dsl.Scenario)
+			}
+		})
+	}
+}
@@ -193,6 +193,12 @@ func parseResourceQuotasSection(herd *HerdFile, key, value string) {
 		herd.ResourceQuotas.SliceCPUMin = value
 	case "slice_memory_min":
 		herd.ResourceQuotas.SliceMemoryMin = value
+	case "pids_max":
+		if intVal, err := strconv.Atoi(value); err == nil {
+			herd.ResourceQuotas.PidsMax = intVal
+		}
+	case "io_max":
+		herd.ResourceQuotas.IOMax = value
 	}
 }
 
@@ -325,6 +331,8 @@ func parseRuntimeIsolation(herd *HerdFile, key, value string) {
 		herd.RuntimeIsolation.NetNamespacePerSlice = value == "true"
 	case "mount_namespace_per_slice":
 		herd.RuntimeIsolation.MountNamespacePerSlice = value == "true"
+	case "seccomp_profile":
+		herd.RuntimeIsolation.SeccompProfile = value
 	}
 }
 
@@ -8,6 +8,7 @@ type DSLFile struct {
         Scenario    string
         Metadata    map[string]interface{}
         Source      string
+        SourceOptions map[string]string
         Steps       []string
         Assertions  []string
         GoldenTest  GoldenTest
@@ -143,6 +144,8 @@ type ResourceQuotasSection struct {
         GPULimit          int
         SliceCPUMin       string
         SliceMemoryMin    string
+        PidsMax           int
+        IOMax             string
 }
 
 // RBACPolicy represents an RBAC policy in a herd file
@@ -206,6 +209,7 @@ type RuntimeIsolation struct {
         PIDNamespacePerSlice   bool
         NetNamespacePerSlice   bool
         MountNamespacePerSlice bool
+        SeccompProfile         string
 }
 
 // RetentionPolicy represents the retention policy section in a herd file
@@ -8,13 +8,148 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
+
+	"github.com/runink/runink/parser"
 )
 
 // CgroupV2Path is the path to the cgroup v2 filesystem
 const CgroupV2Path = "/sys/fs/cgroup"
 
+// CgroupRoot returns the directory slice cgroups are created under.
+// Root agents use the cgroup v2 mount. Unprivileged agents use the subtree
+// delegated to them (e.g. by systemd's Delegate=yes) when it is writable.
+func CgroupRoot() string {
+	if os.Geteuid() == 0 {
+		return CgroupV2Path
+	}
+	if delegated, ok := delegatedCgroup(); ok {
+		return delegated
+	}
+	return CgroupV2Path
+}
+
+// delegatedCgroup finds a writable cgroup above the agent's own cgroup.
+// The parent is preferred because a cgroup holding processes cannot
+// enable controllers for its children.
+func delegatedCgroup() (string, bool) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", false
+	}
+
+	// cgroup v2 has a single "0::<path>" entry
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "0::") {
+			continue
+		}
+		own := filepath.Join(CgroupV2Path, strings.TrimPrefix(line, "0::"))
+		for _, candidate := range []string{filepath.Dir(own), own} {
+			if candidate != CgroupV2Path && syscall.Access(candidate, 2 /* W_OK */) == nil {
+				return candidate, true
+			}
+		}
+	}
+	return "", false
+}
+
+// CgroupHierarchy is the subtree of CgroupRoot holding herd and slice cgroups
+const CgroupHierarchy = "runink"
+
+// cpuPeriod is the cpu.max period in microseconds
+const cpuPeriod = 100000
+
+// cgroupControllers are enabled for the children of every cgroup runink creates
+var cgroupControllers = []string{"cpu", "memory", "io", "pids"}
+
+// HerdCgroupName returns the cgroup of a herd, relative to CgroupRoot
+func HerdCgroupName(herd string) string {
+	return filepath.Join(CgroupHierarchy, herd)
+}
+
+// SliceCgroupName returns the cgroup of a slice, nested in its herd's cgroup
+// so that the herd's quotas cap all of its slices together
+func SliceCgroupName(herd, slice string) string {
+	return filepath.Join(CgroupHierarchy, herd, slice)
+}
+
+// HerdLimits translates a herd's resource quotas into the limits of the
+// herd cgroup and the per-slice minimums of each slice cgroup
+func HerdLimits(quotas parser.ResourceQuotasSection) (herd Limits, slice Limits, err error) {
+	herd = Limits{
+		CPUQuota:  quotas.CPULimit,
+		MemoryMax: quotas.MemoryLimit,
+		IOMax:     quotas.IOMax,
+	}
+	if quotas.PidsMax > 0 {
+		herd.PidsMax = strconv.Itoa(quotas.PidsMax)
+	}
+	slice = Limits{
+		CPUMin:    quotas.SliceCPUMin,
+		MemoryMin: quotas.SliceMemoryMin,
+	}
+
+	// A slice's memory.min only holds if its herd is protected as well:
+	// reserve the minimum for every slice the herd may run, up to its limit
+	if quotas.SliceMemoryMin != "" && quotas.SlicesMax > 0 {
+		perSlice, err := ParseByteQuantity(quotas.SliceMemoryMin)
+		if err != nil {
+			return herd, slice, fmt.Errorf("invalid slice_memory_min: %v", err)
+		}
+		reserved := perSlice * int64(quotas.SlicesMax)
+		if quotas.MemoryLimit != "" {
+			limit, err := ParseByteQuantity(quotas.MemoryLimit)
+			if err != nil {
+				return herd, slice, fmt.Errorf("invalid memory_limit: %v", err)
+			}
+			if reserved > limit {
+				reserved = limit
+			}
+		}
+		herd.MemoryMin = strconv.FormatInt(reserved, 10)
+	}
+
+	// Fail at load rather than when the first slice starts
+	if _, err := herd.cgroupFiles(); err != nil {
+		return herd, slice, err
+	}
+	if _, err := slice.cgroupFiles(); err != nil {
+		return herd, slice, err
+	}
+	return herd, slice, nil
+}
+
+// ApplyHerdCgroup creates a herd's cgroup and applies its quotas, which all
+// of the herd's slices share. Calling it again updates the quotas of the
+// running slices live.
+func ApplyHerdCgroup(herd string, limits Limits) error {
+	if herd == "" || strings.ContainsAny(herd, "/") || herd == "." || herd == ".." {
+		return fmt.Errorf("invalid herd name for cgroup: %q", herd)
+	}
+
+	name := HerdCgroupName(herd)
+	if err := createCgroup(name); err != nil {
+		return err
+	}
+	if err := writeLimits(filepath.Join(CgroupRoot(), name), limits); err != nil {
+		return err
+	}
+	return syncMemoryMin(filepath.Join(CgroupRoot(), CgroupHierarchy))
+}
+
+// EnsureHerdCgroup creates a herd's cgroup with its quotas unless it
+// already exists. An existing herd cgroup keeps its quotas, which may have
+// been updated live with ApplyHerdCgroup.
+func EnsureHerdCgroup(herd string, limits Limits) error {
+	if _, err := os.Stat(filepath.Join(CgroupRoot(), HerdCgroupName(herd))); err == nil {
+		return nil
+	}
+	return ApplyHerdCgroup(herd, limits)
+}
+
 // ApplyCgroup creates a cgroup for the process and applies resource limits
-// This provides resource control for CPU, memory, and I/O
+// This provides resource control for CPU, memory, I/O and process counts.
+// Nested names such as SliceCgroupName create the missing ancestors.
 func ApplyCgroup(name string, pid int, limits Limits) error {
 	// Check if cgroup v2 is available
 	if _, err := os.Stat(CgroupV2Path); os.IsNotExist(err) {
@@ -22,10 +157,10 @@ func ApplyCgroup(name string, pid int, limits Limits) error {
 	}
 
 	// Create cgroup directory
-	cgroupPath := filepath.Join(CgroupV2Path, name)
-	if err := os.MkdirAll(cgroupPath, 0755); err != nil {
-		return fmt.Errorf("failed to create cgroup directory: %v", err)
+	if err := createCgroup(name); err != nil {
+		return err
 	}
+	cgroupPath := filepath.Join(CgroupRoot(), name)
 
 	// Add process to cgroup
 	if err := os.WriteFile(
@@ -36,45 +171,284 @@ func ApplyCgroup(name string, pid int, limits Limits) error {
 		return fmt.Errorf("failed to add process to cgroup: %v", err)
 	}
 
-	// Apply CPU limits if specified
-	if limits.CPUQuota != "" {
-		if err := os.WriteFile(
-			filepath.Join(cgroupPath, "cpu.max"),
-			[]byte(limits.CPUQuota),
-			0644,
-		); err != nil {
-			return fmt.Errorf("failed to set CPU quota: %v", err)
+	return writeLimits(cgroupPath, limits)
+}
+
+// UpdateCgroup rewrites the limits of an existing cgroup. The kernel applies
+// them to the processes already running in it.
+func UpdateCgroup(name string, limits Limits) error {
+	cgroupPath := filepath.Join(CgroupRoot(), name)
+	if _, err := os.Stat(cgroupPath); err != nil {
+		return fmt.Errorf("cgroup %s does not exist: %v", name, err)
+	}
+	return writeLimits(cgroupPath, limits)
+}
+
+// createCgroup creates the cgroup and its missing ancestors, enabling the
+// controllers at every level above it. Enabling is best effort: delegated
+// subtrees may not pass every controller down.
+func createCgroup(name string) error {
+	dir := CgroupRoot()
+	for _, segment := range strings.Split(filepath.Clean(name), string(filepath.Separator)) {
+		for _, controller := range cgroupControllers {
+			os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+"+controller), 0644)
+		}
+		dir = filepath.Join(dir, segment)
+		if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("failed to create cgroup directory: %v", err)
+		}
+	}
+	return nil
+}
+
+// cgroupFile is a value to write to a cgroup interface file
+type cgroupFile struct {
+	name  string
+	value string
+	what  string
+}
+
+// cgroupFiles translates the limits into cgroup interface file values
+func (l Limits) cgroupFiles() ([]cgroupFile, error) {
+	var files []cgroupFile
+
+	if l.CPUQuota != "" {
+		value, err := cpuMax(l.CPUQuota)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CPU quota: %v", err)
+		}
+		files = append(files, cgroupFile{"cpu.max", value, "CPU quota"})
+	}
+	if l.CPUMin != "" {
+		milli, err := ParseCPUQuantity(l.CPUMin)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CPU minimum: %v", err)
+		}
+		files = append(files, cgroupFile{"cpu.weight", strconv.FormatInt(cpuWeight(milli), 10), "CPU weight"})
+	}
+	if l.MemoryMax != "" {
+		value, err := memoryValue(l.MemoryMax)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory limit: %v", err)
+		}
+		files = append(files, cgroupFile{"memory.max", value, "memory limit"})
+	}
+	if l.MemoryMin != "" {
+		value, err := memoryValue(l.MemoryMin)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory minimum: %v", err)
+		}
+		files = append(files, cgroupFile{"memory.min", value, "memory minimum"})
+	}
+	if l.PidsMax != "" {
+		if n, err := strconv.Atoi(l.PidsMax); l.PidsMax != "max" && (err != nil || n <= 0) {
+			return nil, fmt.Errorf("invalid pids limit: %s", l.PidsMax)
+		}
+		files = append(files, cgroupFile{"pids.max", l.PidsMax, "pids limit"})
+	}
+	if l.IOWeight != "" {
+		files = append(files, cgroupFile{"io.weight", l.IOWeight, "I/O weight"})
+	}
+	if l.IOMax != "" {
+		lines, err := ioMax(l.IOMax)
+		if err != nil {
+			return nil, fmt.Errorf("invalid I/O limit: %v", err)
+		}
+		// io.max takes one device per write
+		for _, line := range lines {
+			files = append(files, cgroupFile{"io.max", line, "I/O limit"})
+		}
+	}
+
+	return files, nil
+}
+
+// writeLimits writes the limits to the cgroup at cgroupPath
+func writeLimits(cgroupPath string, limits Limits) error {
+	files, err := limits.cgroupFiles()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := os.WriteFile(filepath.Join(cgroupPath, file.name), []byte(file.value), 0644); err != nil {
+			return fmt.Errorf("failed to set %s: %v", file.what, err)
 		}
 	}
+	return nil
+}
+
+// cpuMax converts a CPU quantity such as "500m" to a cpu.max value.
+// Raw cpu.max values ("max" or "<quota> <period>") are passed through.
+func cpuMax(quota string) (string, error) {
+	if quota == "max" || strings.Contains(strings.TrimSpace(quota), " ") {
+		return quota, nil
+	}
+	milli, err := ParseCPUQuantity(quota)
+	if err != nil {
+		return "", err
+	}
+	// The kernel rejects quotas below 1ms
+	us := milli * cpuPeriod / 1000
+	if us < 1000 {
+		us = 1000
+	}
+	return fmt.Sprintf("%d %d", us, cpuPeriod), nil
+}
+
+// cpuWeight converts a CPU minimum to a cpu.weight the way Kubernetes
+// converts CPU requests: millicores to shares, then shares to weight
+func cpuWeight(milli int64) int64 {
+	shares := milli * 1024 / 1000
+	if shares < 2 {
+		shares = 2
+	}
+	if shares > 262144 {
+		shares = 262144
+	}
+	return 1 + (shares-2)*9999/262142
+}
+
+// memoryValue converts a memory quantity such as "64Gi" to bytes; "max" is passed through
+func memoryValue(quantity string) (string, error) {
+	if quantity == "max" {
+		return quantity, nil
+	}
+	bytes, err := ParseByteQuantity(quantity)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(bytes, 10), nil
+}
+
+// ioMax converts io.max rules such as "8:0 rbps=100Mi wiops=1000" to the
+// kernel format. Rules for several devices are separated by ";".
+func ioMax(rules string) ([]string, error) {
+	var lines []string
+	for _, rule := range strings.FieldsFunc(rules, func(r rune) bool { return r == ';' || r == '\n' }) {
+		fields := strings.Fields(rule)
+		if len(fields) == 0 {
+			continue
+		}
+		var major, minor int
+		if _, err := fmt.Sscanf(fields[0], "%d:%d", &major, &minor); err != nil || len(fields) < 2 {
+			return nil, fmt.Errorf("expected \"MAJ:MIN key=value...\", got %q", rule)
+		}
 
-	// Apply memory limits if specified
-	if limits.MemoryMax != "" {
-		if err := os.WriteFile(
-			filepath.Join(cgroupPath, "memory.max"),
-			[]byte(limits.MemoryMax),
-			0644,
-		); err != nil {
-			return fmt.Errorf("failed to set memory limit: %v", err)
+		line := []string{fmt.Sprintf("%d:%d", major, minor)}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("expected key=value, got %q", field)
+			}
+			switch key {
+			case "rbps", "wbps", "riops", "wiops":
+			default:
+				return nil, fmt.Errorf("unknown io.max key %q", key)
+			}
+			if value != "max" {
+				n, err := ParseByteQuantity(value)
+				if err != nil {
+					return nil, err
+				}
+				value = strconv.FormatInt(n, 10)
+			}
+			line = append(line, key+"="+value)
 		}
+		lines = append(lines, strings.Join(line, " "))
 	}
+	return lines, nil
+}
 
-	// Apply I/O weight if specified
-	if limits.IOWeight != "" {
-		if err := os.WriteFile(
-			filepath.Join(cgroupPath, "io.weight"),
-			[]byte(limits.IOWeight),
-			0644,
-		); err != nil {
-			return fmt.Errorf("failed to set I/O weight: %v", err)
+// syncMemoryMin sets a cgroup's memory.min to the sum of its children's,
+// since a child is only protected as far as its ancestors are
+func syncMemoryMin(dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, "memory.min")); err != nil {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read cgroup directory: %v", err)
+	}
+
+	total := "0"
+	var sum int64
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name(), "memory.min"))
+		if err != nil {
+			continue
 		}
+		value := strings.TrimSpace(string(data))
+		if value == "max" {
+			total = value
+			break
+		}
+		n, _ := strconv.ParseInt(value, 10, 64)
+		sum += n
+		total = strconv.FormatInt(sum, 10)
 	}
 
+	if err := os.WriteFile(filepath.Join(dir, "memory.min"), []byte(total), 0644); err != nil {
+		return fmt.Errorf("failed to set memory minimum: %v", err)
+	}
 	return nil
 }
 
+// CgroupEvents counts the resource events of a cgroup and its descendants
+type CgroupEvents struct {
+	// OOMKills is the number of processes killed by the OOM killer (memory.events oom_kill)
+	OOMKills int64
+
+	// PidsMax is the number of forks refused at pids.max (pids.events max)
+	PidsMax int64
+
+	// NrThrottled is the number of periods the cgroup was throttled at
+	// cpu.max, and ThrottledUsec the total time throttled (cpu.stat)
+	NrThrottled   int64
+	ThrottledUsec int64
+}
+
+// ReadCgroupEvents reads a cgroup's resource events. Files of controllers
+// that are not enabled are skipped.
+func ReadCgroupEvents(name string) (CgroupEvents, error) {
+	cgroupPath := filepath.Join(CgroupRoot(), name)
+	if _, err := os.Stat(cgroupPath); err != nil {
+		return CgroupEvents{}, fmt.Errorf("cgroup %s does not exist: %v", name, err)
+	}
+	return readCgroupEvents(cgroupPath), nil
+}
+
+// readCgroupEvents parses the event files in the cgroup directory
+func readCgroupEvents(cgroupPath string) CgroupEvents {
+	events := CgroupEvents{}
+	for file, fields := range map[string]map[string]*int64{
+		"memory.events": {"oom_kill": &events.OOMKills},
+		"pids.events":   {"max": &events.PidsMax},
+		"cpu.stat":      {"nr_throttled": &events.NrThrottled, "throttled_usec": &events.ThrottledUsec},
+	} {
+		data, err := os.ReadFile(filepath.Join(cgroupPath, file))
+		if err != nil {
+			continue
+		}
+		// Flat keyed files: one "key value" pair per line
+		for _, line := range strings.Split(string(data), "\n") {
+			parts := strings.Fields(line)
+			if len(parts) != 2 {
+				continue
+			}
+			if field, ok := fields[parts[0]]; ok {
+				*field, _ = strconv.ParseInt(parts[1], 10, 64)
+			}
+		}
+	}
+	return events
+}
+
 // CleanupCgroup removes the cgroup directory
 func CleanupCgroup(name string) error {
-	cgroupPath := filepath.Join(CgroupV2Path, name)
+	cgroupPath := filepath.Join(CgroupRoot(), name)
 	
 	// Check if the cgroup exists
 	if _, err := os.Stat(cgroupPath); os.IsNotExist(err) {
@@ -0,0 +1,131 @@
+package runtime
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/runink/runink/parser"
+)
+
+// TestParseQuantity tests Kubernetes-style CPU and byte quantities
+func TestParseQuantity(t *testing.T) {
+	cpus := map[string]int64{"500m": 500, "2": 2000, "1.5": 1500, "20000m": 20000}
+	for quantity, expected := range cpus {
+		if milli, err := ParseCPUQuantity(quantity); err != nil || milli != expected {
+			t.Errorf("ParseCPUQuantity(%q) = %d, %v; expected %d", quantity, milli, err, expected)
+		}
+	}
+
+	bytes := map[string]int64{"64Gi": 64 << 30, "512Mi": 512 << 20, "1G": 1e9, "100M": 1e8, "1e3": 1000, "4096": 4096}
+	for quantity, expected := range bytes {
+		if n, err := ParseByteQuantity(quantity); err != nil || n != expected {
+			t.Errorf("ParseByteQuantity(%q) = %d, %v; expected %d", quantity, n, err, expected)
+		}
+	}
+
+	for _, invalid := range []string{"", "lots", "-1Gi", "10Xi", "100Ei"} {
+		if _, err := ParseByteQuantity(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+// TestHerdLimits tests the translation of herd quotas to cgroup files
+func TestHerdLimits(t *testing.T) {
+	herd, slice, err := HerdLimits(parser.ResourceQuotasSection{
+		SlicesMax:      500,
+		CPULimit:       "20000m",
+		MemoryLimit:    "64Gi",
+		SliceCPUMin:    "500m",
+		SliceMemoryMin: "1Gi",
+		PidsMax:        8192,
+		IOMax:          "8:0 rbps=500Mi wbps=max; 8:16 wiops=1000",
+	})
+	if err != nil {
+		t.Fatalf("Failed to translate quotas: %v", err)
+	}
+
+	files := func(l Limits) map[string]string {
+		list, err := l.cgroupFiles()
+		if err != nil {
+			t.Fatalf("Failed to build cgroup files: %v", err)
+		}
+		values := make(map[string]string)
+		for _, file := range list {
+			values[file.name] += file.value + "\n"
+		}
+		return values
+	}
+
+	herdFiles := files(herd)
+	expected := map[string]string{
+		"cpu.max":    "2000000 100000\n",
+		"memory.max": "68719476736\n",
+		"memory.min": "68719476736\n", // 500 slices x 1Gi, capped at the limit
+		"pids.max":   "8192\n",
+		"io.max":     "8:0 rbps=524288000 wbps=max\n8:16 wiops=1000\n",
+	}
+	for file, value := range expected {
+		if herdFiles[file] != value {
+			t.Errorf("Herd %s: expected %q, got %q", file, value, herdFiles[file])
+		}
+	}
+
+	sliceFiles := files(slice)
+	if sliceFiles["cpu.weight"] != "20\n" || sliceFiles["memory.min"] != "1073741824\n" {
+		t.Errorf("Unexpected slice minimums: %v", sliceFiles)
+	}
+
+	// Raw cpu.max values are still accepted
+	if value, err := cpuMax("50000 100000"); err != nil || value != "50000 100000" {
+		t.Errorf("Expected raw cpu.max to pass through, got %q (%v)", value, err)
+	}
+
+	if _, _, err := HerdLimits(parser.ResourceQuotasSection{IOMax: "sda rbps=1"}); err == nil {
+		t.Error("Expected an io.max rule without a device number to be rejected")
+	}
+}
+
+// TestHerdCgroupHierarchy tests that slices are nested in their herd's cgroup
+func TestHerdCgroupHierarchy(t *testing.T) {
+	// Skip this test if not running as root
+	if os.Geteuid() != 0 {
+		t.Skip("This test requires root privileges")
+	}
+	controllers, err := os.ReadFile(filepath.Join(CgroupV2Path, "cgroup.controllers"))
+	if err != nil || !strings.Contains(string(controllers), "cpu") || !strings.Contains(string(controllers), "pids") {
+		t.Skip("cgroup v2 with the cpu and pids controllers is not mounted at " + CgroupV2Path)
+	}
+
+	herd := "runink-test-herd"
+	herdPath := filepath.Join(CgroupRoot(), HerdCgroupName(herd))
+	defer os.Remove(herdPath)
+
+	result, err := NewExecutor().
+		SetCommand([]string{"/bin/sh", "-c", "sleep 0.1; cat /proc/self/cgroup"}).
+		SetChrootDir("/").
+		SetNamespaces(0).
+		SetCgroupName("slice-1").
+		SetHerd(herd, Limits{CPUQuota: "2", PidsMax: "64"}).
+		Execute()
+	if err != nil || result.ExitCode != 0 {
+		t.Fatalf("Failed to execute: %v (exit %d): %s", err, result.ExitCode, result.Stderr)
+	}
+	if !strings.Contains(string(result.Stdout), "/runink/"+herd+"/slice-1") {
+		t.Errorf("Expected the slice to run in its herd's cgroup, got %q", result.Stdout)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(herdPath, "pids.max")); err != nil || strings.TrimSpace(string(data)) != "64" {
+		t.Errorf("Expected herd pids.max 64, got %q (%v)", data, err)
+	}
+
+	// Live update
+	if err := ApplyHerdCgroup(herd, Limits{PidsMax: "128"}); err != nil {
+		t.Fatalf("Failed to update herd quotas: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(herdPath, "pids.max")); strings.TrimSpace(string(data)) != "128" {
+		t.Errorf("Expected herd pids.max 128 after update, got %q", data)
+	}
+}
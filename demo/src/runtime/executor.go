@@ -4,11 +4,17 @@ package runtime
 
 import (
         "bytes"
+        "context"
         "fmt"
+        "io"
         "os"
         "os/exec"
         "path/filepath"
+        "strings"
+        "sync"
+        "sync/atomic"
         "syscall"
+        "time"
 )
 
 // NewExecutor creates a new executor with default configuration
@@ -24,6 +30,9 @@ func NewExecutor() *Executor {
 // Executor manages the execution of commands in isolated environments
 type Executor struct {
         Config ExecutorConfig
+
+        // secretsDir is where the running slice finds its secret files
+        secretsDir string
 }
 
 // SetCommand sets the command to execute
@@ -44,6 +53,12 @@ func (e *Executor) SetChrootDir(dir string) *Executor {
         return e
 }
 
+// SetRootfs sets the layered root filesystem used when no chroot directory is set
+func (e *Executor) SetRootfs(spec RootfsSpec) *Executor {
+        e.Config.Rootfs = &spec
+        return e
+}
+
 // SetWorkDir sets the working directory inside the chroot
 func (e *Executor) SetWorkDir(dir string) *Executor {
         e.Config.WorkDir = dir
@@ -68,6 +83,95 @@ func (e *Executor) SetCgroupName(name string) *Executor {
         return e
 }
 
+// SetHerd places the slice's cgroup under the herd's cgroup, creating it
+// with the herd's quota if needed
+func (e *Executor) SetHerd(herd string, quota Limits) *Executor {
+        e.Config.Herd = herd
+        e.Config.HerdQuota = quota
+        return e
+}
+
+// SetUserNamespace runs the command in a new user namespace with the given
+// id mappings. Empty mappings select the defaults described on ExecutorConfig.
+func (e *Executor) SetUserNamespace(uidMappings, gidMappings []syscall.SysProcIDMap) *Executor {
+        e.Config.Namespaces |= CLONE_NEWUSER
+        e.Config.UidMappings = uidMappings
+        e.Config.GidMappings = gidMappings
+        return e
+}
+
+// SetEgress sets the endpoints a slice may reach from its network namespace
+func (e *Executor) SetEgress(rules []EgressRule) *Executor {
+        e.Config.Egress = rules
+        return e
+}
+
+// SetSeccomp sets the seccomp profile installed before the command runs
+func (e *Executor) SetSeccomp(profile *SeccompProfile) *Executor {
+        e.Config.Seccomp = profile
+        return e
+}
+
+// SetLandlock limits the command's filesystem access to the ruleset
+func (e *Executor) SetLandlock(ruleset *LandlockRuleset) *Executor {
+        e.Config.Landlock = ruleset
+        return e
+}
+
+// SetSecrets delivers the secrets of spec to the command when it starts
+func (e *Executor) SetSecrets(spec *SecretSpec) *Executor {
+        e.Config.Secrets = spec
+        return e
+}
+
+// SetTimeout kills the command if it runs longer than timeout
+func (e *Executor) SetTimeout(timeout time.Duration) *Executor {
+        e.Config.Timeout = timeout
+        return e
+}
+
+// SetLog captures the command's output into a rotating slice log
+func (e *Executor) SetLog(config LogConfig) *Executor {
+        e.Config.Log = &config
+        return e
+}
+
+// SetContext stops the command gracefully when ctx is cancelled, see SetGracePeriod
+func (e *Executor) SetContext(ctx context.Context) *Executor {
+        e.Config.Context = ctx
+        return e
+}
+
+// SetGracePeriod sets how long a cancelled command may take to exit after SIGTERM
+func (e *Executor) SetGracePeriod(grace time.Duration) *Executor {
+        e.Config.GracePeriod = grace
+        return e
+}
+
+// SetRunID registers the command under a run so that KillRun can stop it
+func (e *Executor) SetRunID(runID string) *Executor {
+        e.Config.RunID = runID
+        return e
+}
+
+// SetStdin sets the reader connected to the command's standard input
+func (e *Executor) SetStdin(r io.Reader) *Executor {
+        e.Config.Stdin = r
+        return e
+}
+
+// SetStdout streams the command's standard output to w instead of buffering it
+func (e *Executor) SetStdout(w io.Writer) *Executor {
+        e.Config.Stdout = w
+        return e
+}
+
+// SetStderr streams the command's standard error to w instead of buffering it
+func (e *Executor) SetStderr(w io.Writer) *Executor {
+        e.Config.Stderr = w
+        return e
+}
+
 // ExecInSandbox executes a command in an isolated environment with resource limits
 // This is a simplified version that demonstrates the concept
 func ExecInSandbox(cmd []string, limits Limits, chrootDir string) error {
@@ -108,40 +212,97 @@ func (e *Executor) Execute() (ExecutorResult, error) {
                 args = e.Config.Command[1:]
         }
 
-        // Create a temporary directory for the chroot if not specified
-        tempDir := ""
-        if e.Config.ChrootDir == "" {
-                var err error
-                tempDir, err = os.MkdirTemp("", "runink-chroot-")
+        // Mount a root filesystem for the slice if no chroot is specified
+        chrootDir := e.Config.ChrootDir
+        if chrootDir == "" {
+                root, cleanup, err := e.prepareRoot()
                 if err != nil {
-                        return result, fmt.Errorf("failed to create temporary directory: %v", err)
-                }
-                defer os.RemoveAll(tempDir)
-                e.Config.ChrootDir = tempDir
-
-                // Prepare the chroot environment
-                if err := PrepareChroot(e.Config.ChrootDir); err != nil {
-                        return result, fmt.Errorf("failed to prepare chroot: %v", err)
+                        return result, err
                 }
+                defer cleanup()
+                chrootDir = root
         }
 
         // Create command
         cmd := exec.Command(command, args...)
 
-        // Set up stdout and stderr capture
+        // Set up stdin, and stream, log or capture stdout and stderr
         var stdout, stderr bytes.Buffer
+        cmd.Stdin = e.Config.Stdin
         cmd.Stdout = &stdout
         cmd.Stderr = &stderr
 
+        var stderrTail *tailBuffer
+        if e.Config.Log != nil {
+                config := *e.Config.Log
+                if config.Slice == "" {
+                        config.Slice = e.Config.CgroupName
+                }
+                sliceLog, err := OpenSliceLog(config)
+                if err != nil {
+                        return result, err
+                }
+                defer sliceLog.Close()
+                result.LogPath = sliceLog.Path()
+
+                // Keep the end of stderr for error messages
+                stdoutLog, stderrLog := sliceLog.Stream("stdout"), sliceLog.Stream("stderr")
+                defer stdoutLog.Close()
+                defer stderrLog.Close()
+                stderrTail = &tailBuffer{max: logTailSize}
+                cmd.Stdout = stdoutLog
+                cmd.Stderr = io.MultiWriter(stderrLog, stderrTail)
+        }
+
+        if e.Config.Stdout != nil {
+                cmd.Stdout = e.Config.Stdout
+        }
+        if e.Config.Stderr != nil {
+                cmd.Stderr = e.Config.Stderr
+        }
+
         // Set environment variables
         if len(e.Config.Env) > 0 {
                 cmd.Env = e.Config.Env
         }
 
         // Set up process attributes for isolation
+        // The slice leads its own process group, so it is signalled as a whole
         cmd.SysProcAttr = &syscall.SysProcAttr{
-                Chroot:     e.Config.ChrootDir,
+                Chroot:     chrootDir,
                 Cloneflags: uintptr(e.Config.Namespaces),
+                Setpgid:    true,
+        }
+
+        // Map ids for rootless slices running in a user namespace
+        releaseIDs, err := e.userNamespaceAttr(cmd.SysProcAttr)
+        if err != nil {
+                return result, fmt.Errorf("failed to set up user namespace: %v", err)
+        }
+        defer releaseIDs()
+
+        // Resolve the slice's secrets and hand them over on a tmpfs or in its environment
+        if e.Config.Secrets != nil {
+                delivery, err := e.deliverSecrets(chrootDir, cmd.SysProcAttr)
+                if err != nil {
+                        return result, err
+                }
+                defer delivery.Close()
+                e.secretsDir = delivery.sliceDir
+                if cmd.Env == nil {
+                        cmd.Env = os.Environ()
+                }
+                cmd.Env = append(append([]string{}, cmd.Env...), delivery.env...)
+        }
+
+        // Root agents create the slice's network namespace themselves so that
+        // loopback is up and egress proxies are bound before the slice runs
+        netIsolated := e.Config.Namespaces&CLONE_NEWNET != 0 && os.Geteuid() == 0
+        if len(e.Config.Egress) > 0 && !netIsolated {
+                return result, fmt.Errorf("egress rules require a network namespace created by a root agent")
+        }
+        if netIsolated {
+                cmd.SysProcAttr.Cloneflags &^= CLONE_NEWNET
         }
 
         // Set working directory if specified
@@ -151,30 +312,126 @@ func (e *Executor) Execute() (ExecutorResult, error) {
                 cmd.Dir = "/"
         }
 
+        // Landlock rulesets and seccomp filters are applied by slice init just before exec
+        if e.Config.Seccomp != nil || e.Config.Landlock != nil {
+                closeSpec, err := e.wrapSliceInit(cmd, chrootDir)
+                if err != nil {
+                        return result, err
+                }
+                defer closeSpec()
+        }
+
         // Start the command
-        if err := cmd.Start(); err != nil {
+        if netIsolated {
+                sandbox, err := startInNetNamespace(cmd, e.Config.Egress)
+                if err != nil {
+                        return result, err
+                }
+                defer sandbox.Close()
+        } else if err := cmd.Start(); err != nil {
                 return result, fmt.Errorf("failed to start command: %v", err)
         }
 
+        // Track the slice's process group so that it can be stopped as a whole
+        cgroupName := e.cgroupName()
+        cgroupPath := ""
+        if cgroupName != "" {
+                cgroupPath = filepath.Join(CgroupRoot(), cgroupName)
+        }
+        proc, err := TrackProcess(cmd.Process.Pid, 0, cgroupPath)
+        if err != nil {
+                // The slice already exited; its group is gone too
+                proc = &SliceProcess{Pid: cmd.Process.Pid, Pgid: cmd.Process.Pid, Cgroup: cgroupPath, pidfd: -1}
+        }
+        defer proc.Close()
+
+        // The timeout and the cancellation signal the slice from their own
+        // goroutines; they must be done before proc is closed
+        var signalers sync.WaitGroup
+        defer signalers.Wait()
+
+        // Kill the slice when it runs out of time
+        var timedOut int32
+        if e.Config.Timeout > 0 {
+                signalers.Add(1)
+                timer := time.AfterFunc(e.Config.Timeout, func() {
+                        defer signalers.Done()
+                        // Mark first: Wait may return as soon as the signal lands
+                        atomic.StoreInt32(&timedOut, 1)
+                        proc.Kill()
+                })
+                defer func() {
+                        if timer.Stop() {
+                                signalers.Done()
+                        }
+                }()
+        }
+
         // Apply cgroup limits
-        if e.Config.CgroupName != "" {
-                if err := ApplyCgroup(e.Config.CgroupName, cmd.Process.Pid, e.Config.Limits); err != nil {
+        if cgroupName != "" {
+                if err := ApplyCgroup(cgroupName, cmd.Process.Pid, e.Config.Limits); err != nil {
                         // Don't fail the command if cgroup setup fails, just log the error
                         fmt.Fprintf(os.Stderr, "Warning: failed to apply cgroup limits: %v\n", err)
                 }
                 // Clean up cgroup when done
-                defer CleanupCgroup(e.Config.CgroupName)
+                defer CleanupCgroup(cgroupName)
+        }
+
+        // Register the slice so that its run can be stopped from outside
+        if e.Config.RunID != "" {
+                unregister, err := registerSlice(e.Config.RunID, filepath.Base(e.Config.CgroupName), proc)
+                if err != nil {
+                        fmt.Fprintf(os.Stderr, "Warning: failed to register slice: %v\n", err)
+                } else {
+                        defer unregister()
+                }
+        }
+
+        // Stop the slice gracefully when its context is cancelled
+        exited := make(chan struct{})
+        var canceled int32
+        if ctx := e.Config.Context; ctx != nil {
+                signalers.Add(1)
+                go func() {
+                        defer signalers.Done()
+                        select {
+                        case <-ctx.Done():
+                                atomic.StoreInt32(&canceled, 1)
+                                proc.Terminate(e.gracePeriod(), exited)
+                        case <-exited:
+                        }
+                }()
         }
 
         // Wait for the command to complete
-        err := cmd.Wait()
-        result.Stdout = stdout.Bytes()
-        result.Stderr = stderr.Bytes()
+        err = cmd.Wait()
+        close(exited)
+
+        // Read the cgroup's events before it is removed
+        if cgroupName != "" {
+                if events, eventsErr := ReadCgroupEvents(cgroupName); eventsErr == nil {
+                        result.Events = events
+                }
+        }
+        if e.Config.Stdout == nil && e.Config.Log == nil {
+                result.Stdout = stdout.Bytes()
+        }
+        if e.Config.Stderr == nil {
+                result.Stderr = stderr.Bytes()
+                if stderrTail != nil {
+                        result.Stderr = stderrTail.Bytes()
+                }
+        }
 
         // Get exit code
         if err != nil {
                 if exitErr, ok := err.(*exec.ExitError); ok {
                         result.ExitCode = exitErr.ExitCode()
+                        if atomic.LoadInt32(&canceled) == 1 {
+                                result.FailureReason = FailureCanceled
+                        } else {
+                                result.FailureReason = e.classifyFailure(exitErr, result.Events, atomic.LoadInt32(&timedOut) == 1)
+                        }
                 }
                 result.Error = err
         }
@@ -182,32 +439,63 @@ func (e *Executor) Execute() (ExecutorResult, error) {
         return result, nil
 }
 
-// RunCommand is a simplified function to run a command with isolation
-// This is a convenience function for simple use cases
-func RunCommand(command []string, limits Limits) (string, error) {
-        // Create a temporary directory for the chroot
-        tempDir, err := os.MkdirTemp("", "runink-chroot-")
-        if err != nil {
-                return "", fmt.Errorf("failed to create temporary directory: %v", err)
+// gracePeriod returns how long a cancelled slice may take to exit after SIGTERM
+func (e *Executor) gracePeriod() time.Duration {
+        if e.Config.GracePeriod > 0 {
+                return e.Config.GracePeriod
         }
-        defer os.RemoveAll(tempDir)
+        return DefaultGracePeriod
+}
 
-        // Prepare the chroot environment
-        if err := PrepareChroot(tempDir); err != nil {
-                return "", fmt.Errorf("failed to prepare chroot: %v", err)
+// cgroupName returns the slice's cgroup, nested in its herd's cgroup if set
+func (e *Executor) cgroupName() string {
+        if e.Config.CgroupName == "" || e.Config.Herd == "" {
+                return e.Config.CgroupName
+        }
+        if err := EnsureHerdCgroup(e.Config.Herd, e.Config.HerdQuota); err != nil {
+                fmt.Fprintf(os.Stderr, "Warning: failed to apply herd quotas: %v\n", err)
         }
+        return SliceCgroupName(e.Config.Herd, e.Config.CgroupName)
+}
 
-        // Copy the command binary to the chroot
-        binPath := filepath.Join(tempDir, "bin", filepath.Base(command[0]))
-        if err := copyFile(command[0], binPath); err != nil {
-                return "", fmt.Errorf("failed to copy binary: %v", err)
+// classifyFailure reports failures caused by the runtime's own enforcement
+// or by the slice's cgroup limits
+func (e *Executor) classifyFailure(exitErr *exec.ExitError, events CgroupEvents, timedOut bool) FailureReason {
+        status, _ := exitErr.Sys().(syscall.WaitStatus)
+
+        switch {
+        case timedOut:
+                fmt.Fprintf(os.Stderr, "Warning: slice %s timed out after %v (throttled %d times, %v)\n",
+                        e.Config.CgroupName, e.Config.Timeout, events.NrThrottled,
+                        time.Duration(events.ThrottledUsec)*time.Microsecond)
+                return FailureTimeout
+
+        // Seccomp kills the whole process with SIGSYS on a denied syscall
+        case status.Signaled() && status.Signal() == syscall.SIGSYS && e.Config.Seccomp != nil:
+                fmt.Fprintf(os.Stderr, "Warning: slice %s made a syscall denied by seccomp profile %s\n",
+                        e.Config.CgroupName, e.Config.Seccomp.Name)
+                return FailureSeccompDenied
+
+        // The victim may be a child, so the slice can also just exit non-zero
+        case events.OOMKills > 0:
+                fmt.Fprintf(os.Stderr, "Warning: slice %s was OOM killed\n", e.Config.CgroupName)
+                return FailureOOMKilled
+
+        case events.PidsMax > 0:
+                fmt.Fprintf(os.Stderr, "Warning: slice %s reached its pids limit\n", e.Config.CgroupName)
+                return FailurePidsExhausted
         }
 
+        return ""
+}
+
+// RunCommand is a simplified function to run a command with isolation
+// The command runs on a fresh root filesystem, so dynamically linked binaries work
+func RunCommand(command []string, limits Limits) (string, error) {
         // Create a new executor
         executor := NewExecutor().
-                SetCommand(append([]string{"/bin/" + filepath.Base(command[0])}, command[1:]...)).
-                SetLimits(limits).
-                SetChrootDir(tempDir)
+                SetCommand(command).
+                SetLimits(limits)
 
         // Execute the command
         result, err := executor.Execute()
@@ -224,6 +512,103 @@ func RunCommand(command []string, limits Limits) (string, error) {
         return string(result.Stdout), nil
 }
 
+// prepareRoot builds the root filesystem for a command without a chroot.
+// Root agents mount an overlay rootfs; unprivileged agents cannot mount,
+// so they fall back to a minimal chroot with the command binary copied in.
+func (e *Executor) prepareRoot() (string, func(), error) {
+        binary := e.Config.Command[0]
+
+        if os.Geteuid() == 0 {
+                spec := RootfsSpec{HostPaths: DefaultHostPaths}
+                if e.Config.Rootfs != nil {
+                        spec = *e.Config.Rootfs
+                }
+                if filepath.IsAbs(binary) && spec.BaseDir == "" && !coveredBy(binary, spec.HostPaths) {
+                        spec.HostPaths = append(append([]string{}, spec.HostPaths...), binary)
+                }
+
+                // Expose exactly the paths the ruleset grants
+                if e.Config.Landlock != nil {
+                        spec.HostPaths = append(append([]string{}, spec.HostPaths...), e.Config.Landlock.ReadPaths...)
+                        for _, path := range e.Config.Landlock.WritePaths {
+                                spec.WritablePaths = append(spec.WritablePaths, writeDir(path))
+                        }
+                }
+
+                rootfs, err := MountRootfs(spec)
+                if err != nil {
+                        return "", nil, fmt.Errorf("failed to mount rootfs: %v", err)
+                }
+                return rootfs.Dir, func() {
+                        if err := rootfs.Cleanup(); err != nil {
+                                fmt.Fprintf(os.Stderr, "Warning: failed to clean up rootfs: %v\n", err)
+                        }
+                }, nil
+        }
+
+        if e.Config.Rootfs != nil {
+                return "", nil, fmt.Errorf("mounting a rootfs requires root privileges")
+        }
+
+        tempDir, err := os.MkdirTemp("", "runink-chroot-")
+        if err != nil {
+                return "", nil, fmt.Errorf("failed to create temporary directory: %v", err)
+        }
+        cleanup := func() { os.RemoveAll(tempDir) }
+
+        if err := PrepareChroot(tempDir); err != nil {
+                cleanup()
+                return "", nil, fmt.Errorf("failed to prepare chroot: %v", err)
+        }
+        if filepath.IsAbs(binary) {
+                if err := copyFile(binary, filepath.Join(tempDir, binary)); err != nil {
+                        cleanup()
+                        return "", nil, fmt.Errorf("failed to copy binary: %v", err)
+                }
+        }
+
+        return tempDir, cleanup, nil
+}
+
+// landlockRuleset returns the ruleset slice init applies. Slices in a
+// prepared root also get its private /tmp and input and output directories,
+// and slices with secret files their secrets directory.
+func (e *Executor) landlockRuleset() *LandlockRuleset {
+        if e.Config.Landlock == nil {
+                return nil
+        }
+        ruleset := &LandlockRuleset{
+                ReadPaths:  append([]string{}, e.Config.Landlock.ReadPaths...),
+                WritePaths: append([]string{}, e.Config.Landlock.WritePaths...),
+        }
+        if e.secretsDir != "" {
+                ruleset.ReadPaths = append(ruleset.ReadPaths, e.secretsDir)
+        }
+        if e.Config.ChrootDir == "" {
+                ruleset.ReadPaths = append(ruleset.ReadPaths, "/tmp")
+                ruleset.WritePaths = append(ruleset.WritePaths, "/tmp")
+        }
+        if e.Config.ChrootDir == "" && e.Config.Rootfs != nil {
+                if e.Config.Rootfs.InputDir != "" {
+                        ruleset.ReadPaths = append(ruleset.ReadPaths, "/input")
+                }
+                if e.Config.Rootfs.OutputDir != "" {
+                        ruleset.WritePaths = append(ruleset.WritePaths, "/output")
+                }
+        }
+        return ruleset
+}
+
+// coveredBy reports whether path lies under one of the given paths
+func coveredBy(path string, paths []string) bool {
+        for _, p := range paths {
+                if path == p || strings.HasPrefix(path, strings.TrimSuffix(p, "/")+"/") {
+                        return true
+                }
+        }
+        return false
+}
+
 // Helper function to copy a file
 func copyFile(src, dst string) error {
         // Ensure the destination directory exists
@@ -0,0 +1,95 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestReadCgroupEvents tests parsing of memory.events, pids.events and cpu.stat
+func TestReadCgroupEvents(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"memory.events": "low 0\nhigh 0\nmax 12\noom 1\noom_kill 1\noom_group_kill 0\n",
+		"pids.events":   "max 3\n",
+		"cpu.stat":      "usage_usec 5000\nnr_periods 40\nnr_throttled 25\nthrottled_usec 90000\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	events := readCgroupEvents(dir)
+	expected := CgroupEvents{OOMKills: 1, PidsMax: 3, NrThrottled: 25, ThrottledUsec: 90000}
+	if events != expected {
+		t.Errorf("Expected %+v, got %+v", expected, events)
+	}
+
+	if events := readCgroupEvents(t.TempDir()); events != (CgroupEvents{}) {
+		t.Errorf("Expected no events without controller files, got %+v", events)
+	}
+}
+
+// TestClassifyFailure tests that cgroup events classify a failed slice
+func TestClassifyFailure(t *testing.T) {
+	err := exec.Command("/bin/sh", "-c", "exit 1").Run()
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		t.Fatalf("Expected an exit error, got %v", err)
+	}
+
+	e := NewExecutor()
+	tests := []struct {
+		events   CgroupEvents
+		timedOut bool
+		reason   FailureReason
+	}{
+		{CgroupEvents{}, false, ""},
+		{CgroupEvents{NrThrottled: 100}, false, ""},
+		{CgroupEvents{OOMKills: 1}, false, FailureOOMKilled},
+		{CgroupEvents{PidsMax: 2}, false, FailurePidsExhausted},
+		{CgroupEvents{OOMKills: 1}, true, FailureTimeout},
+	}
+	for _, tt := range tests {
+		if reason := e.classifyFailure(exitErr, tt.events, tt.timedOut); reason != tt.reason {
+			t.Errorf("Events %+v, timed out %v: expected %q, got %q", tt.events, tt.timedOut, tt.reason, reason)
+		}
+	}
+}
+
+// TestExecutorTimeout tests that a slice running past its timeout is killed
+func TestExecutorTimeout(t *testing.T) {
+	// Skip this test if not running as root
+	if os.Geteuid() != 0 {
+		t.Skip("This test requires root privileges")
+	}
+
+	start := time.Now()
+	result, err := NewExecutor().
+		SetCommand([]string{"/bin/sleep", "10"}).
+		SetChrootDir("/").
+		SetNamespaces(0).
+		SetTimeout(100 * time.Millisecond).
+		Execute()
+	if err != nil {
+		t.Fatalf("Failed to execute: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the slice to be killed at its timeout, ran for %v", elapsed)
+	}
+	if result.FailureReason != FailureTimeout {
+		t.Errorf("Expected %s, got %q (exit %d)", FailureTimeout, result.FailureReason, result.ExitCode)
+	}
+
+	failure := fmt.Errorf("node enrich: %w", result.Failure("slice enrich"))
+	if reason := FailureReasonOf(failure); reason != FailureTimeout {
+		t.Errorf("Expected the reason to survive wrapping, got %q", reason)
+	}
+	if (ExecutorResult{}).Failure("slice enrich") != nil {
+		t.Error("Expected no failure for a successful result")
+	}
+}
@@ -0,0 +1,199 @@
+// Package runtime provides isolation and resource control for RunInk node execution
+package runtime
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxFrameSize is the largest payload a single frame may carry
+const maxFrameSize = 256 * 1024 * 1024
+
+// FrameKind tells the receiver how to interpret a frame's payload
+type FrameKind byte
+
+const (
+	// FrameData carries one codec-encoded value
+	FrameData FrameKind = 'd'
+
+	// FrameError carries an error message; the sender stops after it
+	FrameError FrameKind = 'e'
+)
+
+// Frame is a message exchanged with a sandboxed slice over its stdin and
+// stdout. On the wire it is the kind byte, the payload length as a 4-byte
+// big-endian integer, and the payload.
+type Frame struct {
+	Kind    FrameKind
+	Payload []byte
+}
+
+// WriteFrame writes one frame to w
+func WriteFrame(w io.Writer, frame Frame) error {
+	if len(frame.Payload) > maxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds the %d byte limit", len(frame.Payload), maxFrameSize)
+	}
+
+	header := make([]byte, 5)
+	header[0] = byte(frame.Kind)
+	binary.BigEndian.PutUint32(header[1:], uint32(len(frame.Payload)))
+	if _, err := w.Write(header); err != nil {
+		return fmt.Errorf("failed to write frame header: %v", err)
+	}
+	if _, err := w.Write(frame.Payload); err != nil {
+		return fmt.Errorf("failed to write frame payload: %v", err)
+	}
+	return nil
+}
+
+// ReadFrame reads one frame from r. It returns io.EOF if r ends cleanly
+// before a frame and io.ErrUnexpectedEOF if it ends inside one.
+func ReadFrame(r io.Reader) (Frame, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return Frame{}, err
+	}
+
+	frame := Frame{Kind: FrameKind(header[0])}
+	if frame.Kind != FrameData && frame.Kind != FrameError {
+		return Frame{}, fmt.Errorf("unknown frame kind %q", header[0])
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxFrameSize {
+		return Frame{}, fmt.Errorf("frame of %d bytes exceeds the %d byte limit", size, maxFrameSize)
+	}
+
+	frame.Payload = make([]byte, size)
+	if _, err := io.ReadFull(r, frame.Payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Frame{}, err
+	}
+	return frame, nil
+}
+
+// FrameCodec encodes the values carried by data frames
+type FrameCodec interface {
+	Encode(data interface{}) ([]byte, error)
+	Decode(data []byte, target interface{}) error
+}
+
+// SliceFunc is the body of a slice: it consumes the values received on
+// input and returns its result
+type SliceFunc func(ctx context.Context, input <-chan any) (any, error)
+
+// WriteSliceInputs encodes each value received on input as a data frame
+func WriteSliceInputs(ctx context.Context, w io.Writer, codec FrameCodec, input <-chan any) error {
+	for {
+		select {
+		case value, ok := <-input:
+			if !ok {
+				return nil
+			}
+			payload, err := codec.Encode(value)
+			if err != nil {
+				return fmt.Errorf("failed to encode input: %w", err)
+			}
+			if err := WriteFrame(w, Frame{Kind: FrameData, Payload: payload}); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ReadSliceResult reads the single result frame a slice writes before
+// exiting. An error frame is returned as the slice's error.
+func ReadSliceResult(r io.Reader, codec FrameCodec) (any, error) {
+	frame, err := ReadFrame(r)
+	if err == io.EOF {
+		return nil, errors.New("slice exited without a result")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read result: %w", err)
+	}
+	if frame.Kind == FrameError {
+		return nil, errors.New(string(frame.Payload))
+	}
+
+	var output any
+	if err := codec.Decode(frame.Payload, &output); err != nil {
+		return nil, fmt.Errorf("failed to decode result: %w", err)
+	}
+	return output, nil
+}
+
+// ServeSlice runs fn as the slice named name. It decodes the input from r,
+// and writes the result, or the error that stopped fn, to w.
+func ServeSlice(ctx context.Context, name string, fn SliceFunc, codec FrameCodec, r io.Reader, w io.Writer) error {
+	input := make(chan any)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(input)
+		readErr <- readSliceInputs(ctx, r, codec, input)
+	}()
+
+	var result any
+	var err error
+	func() {
+		defer func() {
+			if rec := recover(); rec != nil {
+				err = fmt.Errorf("panic in node %s: %v", name, rec)
+			}
+		}()
+		result, err = fn(ctx, input)
+	}()
+
+	// Consume input fn left unread so the reader finishes
+	for range input {
+	}
+	if err == nil {
+		err = <-readErr
+	}
+
+	var payload []byte
+	if err == nil {
+		if payload, err = codec.Encode(result); err != nil {
+			err = fmt.Errorf("failed to encode result: %w", err)
+		}
+	}
+	if err != nil {
+		if writeErr := WriteFrame(w, Frame{Kind: FrameError, Payload: []byte(err.Error())}); writeErr != nil {
+			return fmt.Errorf("%v (and failed to report it: %v)", err, writeErr)
+		}
+		return err
+	}
+
+	return WriteFrame(w, Frame{Kind: FrameData, Payload: payload})
+}
+
+// readSliceInputs decodes data frames from r and sends the values on input
+func readSliceInputs(ctx context.Context, r io.Reader, codec FrameCodec, input chan<- any) error {
+	for {
+		frame, err := ReadFrame(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read input: %w", err)
+		}
+		if frame.Kind != FrameData {
+			return fmt.Errorf("unexpected input frame %q", frame.Kind)
+		}
+
+		var value any
+		if err := codec.Decode(frame.Payload, &value); err != nil {
+			return fmt.Errorf("failed to decode input: %w", err)
+		}
+		select {
+		case input <- value:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
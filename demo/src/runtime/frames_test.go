@@ -0,0 +1,150 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+)
+
+// TestFrames tests the framing used to stream values to and from slices
+func TestFrames(t *testing.T) {
+	var buf bytes.Buffer
+	frames := []Frame{
+		{Kind: FrameData, Payload: []byte(`{"id":1}`)},
+		{Kind: FrameData, Payload: []byte{}},
+		{Kind: FrameError, Payload: []byte("boom")},
+	}
+	for _, frame := range frames {
+		if err := WriteFrame(&buf, frame); err != nil {
+			t.Fatalf("Failed to write frame: %v", err)
+		}
+	}
+
+	for _, expected := range frames {
+		frame, err := ReadFrame(&buf)
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if frame.Kind != expected.Kind || !bytes.Equal(frame.Payload, expected.Payload) {
+			t.Errorf("Expected %c %q, got %c %q", expected.Kind, expected.Payload, frame.Kind, frame.Payload)
+		}
+	}
+	if _, err := ReadFrame(&buf); err != io.EOF {
+		t.Errorf("Expected EOF after the last frame, got %v", err)
+	}
+
+	// A stream cut off inside a frame is not a clean end
+	WriteFrame(&buf, Frame{Kind: FrameData, Payload: []byte("truncated")})
+	if _, err := ReadFrame(bytes.NewReader(buf.Bytes()[:8])); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected ErrUnexpectedEOF for a truncated frame, got %v", err)
+	}
+
+	if _, err := ReadFrame(bytes.NewReader([]byte{'x', 0, 0, 0, 0})); err == nil {
+		t.Error("Expected an unknown frame kind to be rejected")
+	}
+}
+
+// jsonCodec encodes frame values as JSON, like the engine's default codec
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(data interface{}) ([]byte, error) {
+	return json.Marshal(data)
+}
+
+func (jsonCodec) Decode(data []byte, target interface{}) error {
+	return json.Unmarshal(data, target)
+}
+
+// TestServeSlice tests a slice consuming streamed input and reporting its result
+func TestServeSlice(t *testing.T) {
+	codec := jsonCodec{}
+
+	// Stream two records into the slice, as the engine's SliceRunner does
+	input := make(chan any, 2)
+	input <- map[string]any{"id": 1}
+	input <- map[string]any{"id": 2}
+	close(input)
+
+	var stdin bytes.Buffer
+	if err := WriteSliceInputs(context.Background(), &stdin, codec, input); err != nil {
+		t.Fatalf("Failed to write inputs: %v", err)
+	}
+
+	// The slice counts its input records
+	count := func(ctx context.Context, in <-chan any) (any, error) {
+		count := 0
+		for range in {
+			count++
+		}
+		return map[string]any{"count": count}, nil
+	}
+
+	var stdout bytes.Buffer
+	if err := ServeSlice(context.Background(), "count", count, codec, &stdin, &stdout); err != nil {
+		t.Fatalf("ServeSlice failed: %v", err)
+	}
+
+	output, err := ReadSliceResult(&stdout, codec)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if result, ok := output.(map[string]any); !ok || result["count"] != float64(2) {
+		t.Errorf("Expected count 2, got %v", output)
+	}
+}
+
+// TestServeSliceError tests that failures reach the engine as the slice's error
+func TestServeSliceError(t *testing.T) {
+	codec := jsonCodec{}
+
+	broken := func(ctx context.Context, in <-chan any) (any, error) {
+		return nil, errors.New("bad record")
+	}
+	var stdout bytes.Buffer
+	if err := ServeSlice(context.Background(), "broken", broken, codec, &bytes.Buffer{}, &stdout); err == nil {
+		t.Error("Expected ServeSlice to return the slice error")
+	}
+
+	// The engine sees the slice's error, not a protocol error
+	if _, err := ReadSliceResult(&stdout, codec); err == nil || err.Error() != "bad record" {
+		t.Errorf("Expected the slice error, got %v", err)
+	}
+
+	// A panic is reported like an error, and unread input is drained
+	input := make(chan any, 3)
+	for i := 0; i < 3; i++ {
+		input <- i
+	}
+	close(input)
+	var stdin bytes.Buffer
+	WriteSliceInputs(context.Background(), &stdin, codec, input)
+	panics := func(ctx context.Context, in <-chan any) (any, error) {
+		<-in
+		panic("boom")
+	}
+	stdout.Reset()
+	ServeSlice(context.Background(), "panics", panics, codec, &stdin, &stdout)
+	if _, err := ReadSliceResult(&stdout, codec); err == nil || err.Error() != "panic in node panics: boom" {
+		t.Errorf("Expected the panic, got %v", err)
+	}
+
+	// An error frame in the input stops the slice
+	var bad bytes.Buffer
+	WriteFrame(&bad, Frame{Kind: FrameError, Payload: []byte("x")})
+	drain := func(ctx context.Context, in <-chan any) (any, error) {
+		for range in {
+		}
+		return nil, nil
+	}
+	if err := ServeSlice(context.Background(), "drain", drain, codec, &bad, io.Discard); err == nil {
+		t.Error("Expected an unexpected input frame to fail the slice")
+	}
+
+	// A slice that dies before reporting has no result
+	if _, err := ReadSliceResult(&bytes.Buffer{}, codec); err == nil {
+		t.Error("Expected an error for a slice without a result")
+	}
+}
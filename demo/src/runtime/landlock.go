@@ -0,0 +1,220 @@
+// Package runtime provides isolation and resource control for RunInk node execution
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// Landlock constants from linux/landlock.h. The syscall numbers are shared
+// by every architecture.
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1
+	landlockRulePathBeneath      = 1
+	oPath                        = 0x200000 // O_PATH, missing from package syscall
+
+	landlockAccessExecute    = 1 << 0
+	landlockAccessWriteFile  = 1 << 1
+	landlockAccessReadFile   = 1 << 2
+	landlockAccessReadDir    = 1 << 3
+	landlockAccessRemoveDir  = 1 << 4
+	landlockAccessRemoveFile = 1 << 5
+	landlockAccessMakeChar   = 1 << 6
+	landlockAccessMakeDir    = 1 << 7
+	landlockAccessMakeReg    = 1 << 8
+	landlockAccessMakeSock   = 1 << 9
+	landlockAccessMakeFifo   = 1 << 10
+	landlockAccessMakeBlock  = 1 << 11
+	landlockAccessMakeSym    = 1 << 12
+	landlockAccessRefer      = 1 << 13
+	landlockAccessTruncate   = 1 << 14
+
+	// Rights that apply to regular files; directories take all of them
+	landlockFileAccess = landlockAccessExecute | landlockAccessWriteFile |
+		landlockAccessReadFile | landlockAccessTruncate
+
+	landlockRead  = landlockAccessReadFile | landlockAccessReadDir
+	landlockExec  = landlockRead | landlockAccessExecute
+	landlockWrite = landlockAccessWriteFile | landlockAccessTruncate |
+		landlockAccessMakeReg | landlockAccessMakeDir |
+		landlockAccessRemoveFile | landlockAccessRemoveDir
+)
+
+// landlockSystemPaths are granted to every slice on top of its ruleset,
+// enough to run dynamically linked binaries and shell scripts
+var landlockSystemPaths = []struct {
+	path   string
+	access uint64
+}{
+	{"/bin", landlockExec},
+	{"/sbin", landlockExec},
+	{"/lib", landlockExec},
+	{"/lib64", landlockExec},
+	{"/usr", landlockExec},
+	{"/etc/ld.so.cache", landlockRead},
+	{"/etc/ssl/certs", landlockRead},
+	{"/dev/null", landlockAccessReadFile | landlockAccessWriteFile},
+	{"/dev/zero", landlockAccessReadFile},
+	{"/dev/urandom", landlockAccessReadFile},
+}
+
+// LandlockRuleset is the filesystem access a slice is limited to. Paths are
+// resolved inside the slice's root; access to anything else is denied.
+type LandlockRuleset struct {
+	// ReadPaths are files or directories the slice may read
+	ReadPaths []string `json:"read_paths,omitempty"`
+
+	// WritePaths are files or directories the slice may write. A missing
+	// file is created empty so that it can be granted on its own.
+	WritePaths []string `json:"write_paths,omitempty"`
+}
+
+// writeDir returns the directory a rootfs binds for write access to path:
+// path itself if it is a directory, its parent otherwise
+func writeDir(path string) string {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return path
+	}
+	return filepath.Dir(path)
+}
+
+// writeAccess returns the rights granted on a write path: everything
+// landlockWrite covers beneath a directory, only writing and truncating a
+// file. Rules hold on to existing files, and rights on the parent directory
+// would extend to every file beside a sink, so a missing file is created
+// empty first; without its directory it is skipped.
+func writeAccess(path string) (uint64, error) {
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && info.IsDir():
+		return landlockWrite, nil
+	case err == nil:
+		return landlockAccessWriteFile | landlockAccessTruncate, nil
+	case !os.IsNotExist(err):
+		return 0, fmt.Errorf("failed to stat %s: %v", path, err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s for landlock: %v", path, err)
+	}
+	file.Close()
+	return landlockAccessWriteFile | landlockAccessTruncate, nil
+}
+
+// landlockABI returns the kernel's Landlock ABI version, or 0 without Landlock
+func landlockABI() int {
+	abi, _, errno := syscall.RawSyscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		return 0
+	}
+	return int(abi)
+}
+
+// handledAccess returns every filesystem right the kernel can restrict
+func handledAccess(abi int) uint64 {
+	access := uint64(landlockAccessMakeSym<<1 - 1)
+	if abi >= 2 {
+		access |= landlockAccessRefer
+	}
+	if abi >= 3 {
+		access |= landlockAccessTruncate
+	}
+	return access
+}
+
+// applyLandlock restricts the calling thread to the ruleset, the system
+// paths and the executable at binary. The caller must hold the OS thread and
+// exec right after; the restriction survives execve. Returns false if the
+// kernel has no Landlock support.
+func applyLandlock(r *LandlockRuleset, binary string) (bool, error) {
+	abi := landlockABI()
+	if abi < 1 {
+		return false, nil
+	}
+	handled := handledAccess(abi)
+
+	attr := struct{ handledAccessFS uint64 }{handled}
+	fd, _, errno := syscall.RawSyscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return false, fmt.Errorf("failed to create landlock ruleset: %v", errno)
+	}
+	defer syscall.Close(int(fd))
+
+	allow := func(path string, access uint64) error {
+		return addLandlockRule(int(fd), path, access&handled)
+	}
+	for _, system := range landlockSystemPaths {
+		if err := allow(system.path, system.access); err != nil {
+			return false, err
+		}
+	}
+	if err := allow(binary, landlockAccessExecute|landlockAccessReadFile); err != nil {
+		return false, err
+	}
+	for _, path := range r.ReadPaths {
+		if err := allow(path, landlockRead); err != nil {
+			return false, err
+		}
+	}
+	for _, path := range r.WritePaths {
+		access, err := writeAccess(path)
+		if err != nil {
+			return false, err
+		}
+		if err := allow(path, access); err != nil {
+			return false, err
+		}
+	}
+
+	if err := setNoNewPrivs(); err != nil {
+		return false, err
+	}
+	if _, _, errno := syscall.RawSyscall(sysLandlockRestrictSelf, fd, 0, 0); errno != 0 {
+		return false, fmt.Errorf("failed to apply landlock ruleset: %v", errno)
+	}
+	return true, nil
+}
+
+// addLandlockRule grants access beneath path. Missing paths are skipped.
+func addLandlockRule(rulesetFd int, path string, access uint64) error {
+	fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	if err == syscall.ENOENT {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s for landlock: %v", path, err)
+	}
+	defer syscall.Close(fd)
+
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(fd, &stat); err != nil {
+		return fmt.Errorf("failed to stat %s: %v", path, err)
+	}
+	if stat.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		access &= landlockFileAccess
+	}
+	if access == 0 {
+		return nil
+	}
+
+	// struct landlock_path_beneath_attr is packed: the fd follows at offset 8
+	attr := struct {
+		allowedAccess uint64
+		parentFd      int32
+	}{access, int32(fd)}
+	if _, _, errno := syscall.RawSyscall6(sysLandlockAddRule, uintptr(rulesetFd), landlockRulePathBeneath,
+		uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to add landlock rule for %s: %v", path, errno)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLandlockHelper is run inside the slice by TestExecutorLandlock
+func TestLandlockHelper(t *testing.T) {
+	dir := os.Getenv("RUNINK_LANDLOCK_HELPER")
+	if dir == "" {
+		t.Skip("Helper for TestExecutorLandlock")
+	}
+	report := func(op string, err error) {
+		fmt.Printf("%s: %v\n", op, err == nil)
+	}
+	_, err := os.ReadFile(filepath.Join(dir, "finance", "trades.csv"))
+	report("read-source", err)
+	_, err = os.ReadFile(filepath.Join(dir, "hr", "salaries.csv"))
+	report("read-other-herd", err)
+	err = os.WriteFile(filepath.Join(dir, "out", "report.csv"), []byte("ok\n"), 0644)
+	report("write-sink", err)
+	err = os.WriteFile(filepath.Join(dir, "out", "other.csv"), []byte("ok\n"), 0644)
+	report("write-beside-sink", err)
+	err = os.WriteFile(filepath.Join(dir, "finance", "trades.csv"), []byte("tampered\n"), 0644)
+	report("write-source", err)
+	os.Exit(0)
+}
+
+// TestExecutorLandlock tests that a slice only reaches the paths its ruleset declares
+func TestExecutorLandlock(t *testing.T) {
+	// Skip this test if not running as root
+	if os.Geteuid() != 0 {
+		t.Skip("This test requires root privileges")
+	}
+	if landlockABI() < 1 {
+		t.Skip("Landlock is not supported by the kernel")
+	}
+
+	dir := t.TempDir()
+	for _, file := range []string{"finance/trades.csv", "hr/salaries.csv"} {
+		path := filepath.Join(dir, file)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte("id\n1\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", file, err)
+		}
+	}
+	os.MkdirAll(filepath.Join(dir, "out"), 0755)
+
+	// The host root relies on Landlock alone; a mounted rootfs also binds
+	// only the declared paths
+	for _, chrootDir := range []string{"/", ""} {
+		os.Remove(filepath.Join(dir, "out", "report.csv"))
+
+		result, err := NewExecutor().
+			SetCommand([]string{os.Args[0], "-test.run=TestLandlockHelper"}).
+			SetChrootDir(chrootDir).
+			SetNamespaces(0).
+			SetEnv([]string{"RUNINK_LANDLOCK_HELPER=" + dir}).
+			SetLandlock(&LandlockRuleset{
+				ReadPaths:  []string{filepath.Join(dir, "finance", "trades.csv")},
+				WritePaths: []string{filepath.Join(dir, "out", "report.csv")},
+			}).
+			Execute()
+		if err != nil {
+			t.Fatalf("Failed to execute: %v", err)
+		}
+		if result.ExitCode != 0 {
+			t.Fatalf("Helper failed with exit code %d: %s", result.ExitCode, result.Stderr)
+		}
+
+		output := string(result.Stdout)
+		expectations := []string{
+			"read-source: true",
+			"read-other-herd: false",
+			"write-sink: true",
+			"write-source: false",
+		}
+		// In a mounted rootfs the test directory is beneath the slice's
+		// writable /tmp
+		if chrootDir == "/" {
+			expectations = append(expectations, "write-beside-sink: false")
+		}
+		for _, expected := range expectations {
+			if !strings.Contains(output, expected) {
+				t.Errorf("Chroot %q: expected %q, got:\n%s", chrootDir, expected, output)
+			}
+		}
+	}
+}
@@ -0,0 +1,296 @@
+// Package runtime provides isolation and resource control for RunInk node execution
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLogDir is the root of the slice log tree: <dir>/<run-id>/<slice>.log
+const DefaultLogDir = "/var/log/runink/slices"
+
+const (
+	// defaultLogMaxSize rotates a slice log after 64 MiB
+	defaultLogMaxSize = 64 * 1024 * 1024
+
+	// defaultLogMaxAge rotates a slice log after a day
+	defaultLogMaxAge = 24 * time.Hour
+
+	// maxLogLine splits longer output lines into several records
+	maxLogLine = 64 * 1024
+
+	// logTailSize is how much stderr ExecutorResult keeps when output is logged
+	logTailSize = 64 * 1024
+
+	// logRotatedTimeFormat suffixes rotated files: <slice>.log.<time>
+	logRotatedTimeFormat = "20060102T150405.000000000"
+)
+
+// LogConfig configures the capture of a slice's output into log files
+type LogConfig struct {
+	// Dir is the root of the log tree. Default: DefaultLogDir
+	Dir string
+
+	// RunID and Slice name the log file. Slice defaults to the cgroup name.
+	RunID string
+	Slice string
+
+	// Tag is the herd's logs_tag, recorded with every line
+	Tag string
+
+	// MaxSize and MaxAge rotate the log once it grows past MaxSize bytes or
+	// was opened MaxAge ago. Defaults: 64 MiB and 24h
+	MaxSize int64
+	MaxAge  time.Duration
+
+	// RetentionDays removes logs older than this many days, see
+	// RetentionPolicy.LogRetentionDays. Zero keeps logs forever.
+	RetentionDays int
+}
+
+// LogRecord is one line of slice output as stored in a slice log
+type LogRecord struct {
+	Time   time.Time `json:"time"`
+	Run    string    `json:"run,omitempty"`
+	Slice  string    `json:"slice"`
+	Tag    string    `json:"tag,omitempty"`
+	Stream string    `json:"stream"`
+	Line   string    `json:"line"`
+}
+
+// LogPath returns the path of a slice's current log file
+func LogPath(dir, runID, slice string) string {
+	if dir == "" {
+		dir = DefaultLogDir
+	}
+	if runID == "" {
+		runID = "default"
+	}
+	return filepath.Join(dir, runID, slice+".log")
+}
+
+// SliceLog writes a slice's output as JSON lines to a rotating log file
+type SliceLog struct {
+	config LogConfig
+	path   string
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// OpenSliceLog creates the slice's log file, appending if it exists, and
+// removes logs past their retention
+func OpenSliceLog(config LogConfig) (*SliceLog, error) {
+	if config.Slice == "" || strings.ContainsRune(config.Slice, '/') || strings.ContainsRune(config.RunID, '/') {
+		return nil, fmt.Errorf("invalid slice log name: run %q, slice %q", config.RunID, config.Slice)
+	}
+	if config.Dir == "" {
+		config.Dir = DefaultLogDir
+	}
+	if config.MaxSize <= 0 {
+		config.MaxSize = defaultLogMaxSize
+	}
+	if config.MaxAge <= 0 {
+		config.MaxAge = defaultLogMaxAge
+	}
+
+	if config.RetentionDays > 0 {
+		if err := PruneLogs(config.Dir, config.RetentionDays); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to prune slice logs: %v\n", err)
+		}
+	}
+
+	l := &SliceLog{config: config, path: LogPath(config.Dir, config.RunID, config.Slice)}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0750); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %v", err)
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Path returns the path of the current log file
+func (l *SliceLog) Path() string {
+	return l.path
+}
+
+// Stream returns a writer that records each line written to it under the
+// given stream name, e.g. "stdout". Close flushes a trailing partial line.
+func (l *SliceLog) Stream(name string) io.WriteCloser {
+	return &logStream{log: l, name: name}
+}
+
+// Write appends a record, rotating the file first if it is due
+func (l *SliceLog) Write(record LogRecord) error {
+	record.Run, record.Slice, record.Tag = l.config.RunID, l.config.Slice, l.config.Tag
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return fmt.Errorf("slice log %s is closed", l.path)
+	}
+	if l.size > 0 && (l.size+int64(len(data)) > l.config.MaxSize || time.Since(l.opened) > l.config.MaxAge) {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	return err
+}
+
+// Close closes the log file
+func (l *SliceLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// open opens the current log file for appending
+func (l *SliceLog) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open slice log: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat slice log: %v", err)
+	}
+	l.file, l.size, l.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+// rotate moves the current file aside and starts a new one
+func (l *SliceLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close slice log: %v", err)
+	}
+	l.file = nil
+
+	rotated := l.path + "." + time.Now().UTC().Format(logRotatedTimeFormat)
+	if err := os.Rename(l.path, rotated); err != nil {
+		return fmt.Errorf("failed to rotate slice log: %v", err)
+	}
+	return l.open()
+}
+
+// logStream turns written output into one record per line
+type logStream struct {
+	log     *SliceLog
+	name    string
+	partial []byte
+}
+
+func (s *logStream) Write(p []byte) (int, error) {
+	s.partial = append(s.partial, p...)
+	for {
+		i := bytes.IndexByte(s.partial, '\n')
+		if i < 0 && len(s.partial) < maxLogLine {
+			return len(p), nil
+		}
+		if i < 0 || i > maxLogLine {
+			i = maxLogLine
+		}
+		line := string(bytes.TrimSuffix(s.partial[:i], []byte("\r")))
+		if i < len(s.partial) && s.partial[i] == '\n' {
+			i++
+		}
+		s.partial = s.partial[i:]
+		if err := s.log.Write(LogRecord{Time: time.Now().UTC(), Stream: s.name, Line: line}); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+func (s *logStream) Close() error {
+	if len(s.partial) == 0 {
+		return nil
+	}
+	line := string(s.partial)
+	s.partial = nil
+	return s.log.Write(LogRecord{Time: time.Now().UTC(), Stream: s.name, Line: line})
+}
+
+// PruneLogs removes slice logs under dir that were last written more than
+// retentionDays ago, and run directories left empty
+func PruneLogs(dir string, retentionDays int) error {
+	if retentionDays <= 0 {
+		return nil
+	}
+	cutoff := time.Now().Add(-time.Duration(retentionDays) * 24 * time.Hour)
+
+	runs, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, run := range runs {
+		if !run.IsDir() {
+			continue
+		}
+		runDir := filepath.Join(dir, run.Name())
+		files, err := os.ReadDir(runDir)
+		if err != nil {
+			return err
+		}
+
+		kept := 0
+		for _, file := range files {
+			info, err := file.Info()
+			if err != nil || !strings.Contains(file.Name(), ".log") || info.ModTime().After(cutoff) {
+				kept++
+				continue
+			}
+			if err := os.Remove(filepath.Join(runDir, file.Name())); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if kept == 0 {
+			os.Remove(runDir)
+		}
+	}
+	return nil
+}
+
+// tailBuffer keeps the last max bytes written to it
+type tailBuffer struct {
+	max int
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.max {
+		t.buf = t.buf[len(t.buf)-t.max:]
+	}
+	return len(p), nil
+}
+
+// Bytes returns the retained tail
+func (t *tailBuffer) Bytes() []byte {
+	return t.buf
+}
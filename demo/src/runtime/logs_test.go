@@ -0,0 +1,143 @@
+package runtime
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readLogRecords returns the records in a slice log file
+func readLogRecords(t *testing.T, path string) []LogRecord {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	defer file.Close()
+
+	var records []LogRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		record := LogRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Invalid log record %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// TestSliceLog tests line capture, tagging and size rotation
+func TestSliceLog(t *testing.T) {
+	dir := t.TempDir()
+	sliceLog, err := OpenSliceLog(LogConfig{Dir: dir, RunID: "run-1", Slice: "enrich", Tag: "finance", MaxSize: 200})
+	if err != nil {
+		t.Fatalf("Failed to open slice log: %v", err)
+	}
+
+	stdout := sliceLog.Stream("stdout")
+	stdout.Write([]byte("first line\nsecond "))
+	stdout.Write([]byte("line\r\nunterminated"))
+	stdout.Close()
+	sliceLog.Close()
+
+	if sliceLog.Path() != filepath.Join(dir, "run-1", "enrich.log") {
+		t.Errorf("Unexpected log path %s", sliceLog.Path())
+	}
+
+	// Each record is about 130 bytes, so every record after the first rotates
+	rotated, _ := filepath.Glob(sliceLog.Path() + ".*")
+	if len(rotated) != 2 {
+		t.Fatalf("Expected 2 rotated files, got %v", rotated)
+	}
+
+	var lines []string
+	for _, path := range append(rotated, sliceLog.Path()) {
+		for _, record := range readLogRecords(t, path) {
+			if record.Run != "run-1" || record.Slice != "enrich" || record.Tag != "finance" || record.Stream != "stdout" {
+				t.Errorf("Unexpected record metadata: %+v", record)
+			}
+			lines = append(lines, record.Line)
+		}
+	}
+	expected := []string{"first line", "second line", "unterminated"}
+	if strings.Join(lines, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected lines %q, got %q", expected, lines)
+	}
+
+	if _, err := OpenSliceLog(LogConfig{Dir: dir, RunID: "../escape", Slice: "x"}); err == nil {
+		t.Error("Expected a run ID with a path separator to be rejected")
+	}
+}
+
+// TestPruneLogs tests that logs past their retention are removed
+func TestPruneLogs(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-10 * 24 * time.Hour)
+
+	oldRun := filepath.Join(dir, "run-old")
+	newRun := filepath.Join(dir, "run-new")
+	for _, path := range []string{
+		filepath.Join(oldRun, "a.log"),
+		filepath.Join(newRun, "b.log.20250101T000000.000000000"),
+		filepath.Join(newRun, "b.log"),
+	} {
+		os.MkdirAll(filepath.Dir(path), 0750)
+		if err := os.WriteFile(path, []byte("{}\n"), 0640); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	os.Chtimes(filepath.Join(oldRun, "a.log"), old, old)
+	os.Chtimes(filepath.Join(newRun, "b.log.20250101T000000.000000000"), old, old)
+
+	if err := PruneLogs(dir, 7); err != nil {
+		t.Fatalf("Failed to prune logs: %v", err)
+	}
+
+	if _, err := os.Stat(oldRun); !os.IsNotExist(err) {
+		t.Error("Expected the expired run directory to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(newRun, "b.log.20250101T000000.000000000")); !os.IsNotExist(err) {
+		t.Error("Expected the expired rotated log to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(newRun, "b.log")); err != nil {
+		t.Errorf("Expected the current log to be kept: %v", err)
+	}
+}
+
+// TestExecutorLog tests that slice output is streamed into the slice log
+func TestExecutorLog(t *testing.T) {
+	// Skip this test if not running as root
+	if os.Geteuid() != 0 {
+		t.Skip("This test requires root privileges")
+	}
+
+	dir := t.TempDir()
+	result, err := NewExecutor().
+		SetCommand([]string{"/bin/sh", "-c", "echo out; echo err >&2"}).
+		SetChrootDir("/").
+		SetNamespaces(0).
+		SetCgroupName("log-test").
+		SetLog(LogConfig{Dir: dir, RunID: "run-1", Tag: "finance"}).
+		Execute()
+	if err != nil || result.ExitCode != 0 {
+		t.Fatalf("Failed to execute: %v (exit %d)", err, result.ExitCode)
+	}
+	if result.Stdout != nil {
+		t.Errorf("Expected stdout to go to the log only, got %q", result.Stdout)
+	}
+	if string(result.Stderr) != "err\n" {
+		t.Errorf("Expected the stderr tail, got %q", result.Stderr)
+	}
+
+	streams := make(map[string]string)
+	for _, record := range readLogRecords(t, result.LogPath) {
+		streams[record.Stream] = record.Line
+	}
+	if streams["stdout"] != "out" || streams["stderr"] != "err" {
+		t.Errorf("Unexpected log contents: %v", streams)
+	}
+}
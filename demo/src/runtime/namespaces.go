@@ -5,6 +5,8 @@ package runtime
 import (
 	"fmt"
 	"syscall"
+
+	"github.com/runink/runink/parser"
 )
 
 // Namespace constants for Linux
@@ -25,13 +27,39 @@ const (
 	CLONE_NEWNET = syscall.CLONE_NEWNET
 
 	// CLONE_NEWUSER creates a new user namespace
-	// Note: This requires special privileges and is not used by default
+	// Note: This is not used by default; enable it with Executor.SetUserNamespace
+	// or the herd's EphemeralUserNamespace setting
 	CLONE_NEWUSER = syscall.CLONE_NEWUSER
 
 	// DefaultNamespaces is the default set of namespaces to unshare
 	DefaultNamespaces = CLONE_NEWUTS | CLONE_NEWPID | CLONE_NEWNS | CLONE_NEWIPC
 )
 
+// HerdNamespaces returns the namespaces to unshare for a slice under the
+// herd's runtime isolation settings. UTS and IPC are always unshared.
+func HerdNamespaces(isolation parser.RuntimeIsolation) int {
+	namespaces := CLONE_NEWUTS | CLONE_NEWIPC
+	if isolation.EphemeralUserNamespace {
+		namespaces |= CLONE_NEWUSER
+	}
+	if isolation.PIDNamespacePerSlice {
+		namespaces |= CLONE_NEWPID
+	}
+	if isolation.MountNamespacePerSlice {
+		namespaces |= CLONE_NEWNS
+	}
+	if isolation.NetNamespacePerSlice {
+		namespaces |= CLONE_NEWNET
+	}
+	return namespaces
+}
+
+// HerdSeccompProfile loads the seccomp profile the herd's runtime
+// isolation settings refer to; nil means unconfined
+func HerdSeccompProfile(isolation parser.RuntimeIsolation) (*SeccompProfile, error) {
+	return LoadSeccompProfile(isolation.SeccompProfile)
+}
+
 // EnterNamespaces creates new namespaces for the current process
 // This provides isolation for various system resources
 func EnterNamespaces(namespaces int) error {
@@ -0,0 +1,176 @@
+// Package runtime provides isolation and resource control for RunInk node execution
+package runtime
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	goruntime "runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// EgressRule lets a slice reach one allowed endpoint from its network namespace.
+// The slice connects to 127.0.0.1:ListenPort and a host-side proxy dials Target.
+type EgressRule struct {
+	// ListenPort is the loopback port inside the slice
+	ListenPort int
+
+	// Target is the host:port dialled from the host network namespace
+	Target string
+}
+
+// ParseEgressRule parses "<port>=<host:port>", e.g. "5432=db.internal:5432"
+func ParseEgressRule(spec string) (EgressRule, error) {
+	port, target, ok := strings.Cut(strings.TrimSpace(spec), "=")
+	if !ok {
+		return EgressRule{}, fmt.Errorf("invalid egress rule %q: expected <port>=<host:port>", spec)
+	}
+
+	listenPort, err := strconv.Atoi(port)
+	if err != nil || listenPort <= 0 || listenPort > 65535 {
+		return EgressRule{}, fmt.Errorf("invalid egress port %q", port)
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return EgressRule{}, fmt.Errorf("invalid egress target %q: %v", target, err)
+	}
+
+	return EgressRule{ListenPort: listenPort, Target: target}, nil
+}
+
+// netSandbox owns the egress proxies of a slice's network namespace
+type netSandbox struct {
+	listeners []net.Listener
+	wg        sync.WaitGroup
+}
+
+// startInNetNamespace starts cmd in a new network namespace with loopback up.
+//
+// The namespace is created on a locked OS thread that is thrown away
+// afterwards: the child inherits the thread's namespace, and the proxy
+// listeners are bound inside it before the slice runs.
+func startInNetNamespace(cmd *exec.Cmd, egress []EgressRule) (*netSandbox, error) {
+	type started struct {
+		sandbox *netSandbox
+		err     error
+	}
+	done := make(chan started, 1)
+
+	go func() {
+		// Never unlock: the thread exits with this goroutine instead of
+		// returning to the scheduler inside the slice's namespace
+		goruntime.LockOSThread()
+
+		if err := syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
+			done <- started{err: fmt.Errorf("failed to create network namespace: %v", err)}
+			return
+		}
+		if err := setLoopbackUp(); err != nil {
+			done <- started{err: err}
+			return
+		}
+
+		sandbox := &netSandbox{}
+		for _, rule := range egress {
+			listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", rule.ListenPort))
+			if err != nil {
+				sandbox.Close()
+				done <- started{err: fmt.Errorf("failed to listen for egress to %s: %v", rule.Target, err)}
+				return
+			}
+			sandbox.listeners = append(sandbox.listeners, listener)
+		}
+
+		if err := cmd.Start(); err != nil {
+			sandbox.Close()
+			done <- started{err: fmt.Errorf("failed to start command: %v", err)}
+			return
+		}
+
+		done <- started{sandbox: sandbox}
+	}()
+
+	result := <-done
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	// Proxies accept on the namespaced sockets but dial from host threads
+	for i, listener := range result.sandbox.listeners {
+		result.sandbox.wg.Add(1)
+		go result.sandbox.proxy(listener, egress[i].Target)
+	}
+
+	return result.sandbox, nil
+}
+
+// proxy forwards every connection accepted inside the slice to target
+func (s *netSandbox) proxy(listener net.Listener, target string) {
+	defer s.wg.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			upstream, err := net.Dial("tcp", target)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: egress to %s failed: %v\n", target, err)
+				return
+			}
+			defer upstream.Close()
+
+			copied := make(chan struct{})
+			go func() {
+				io.Copy(upstream, conn)
+				if tcp, ok := upstream.(*net.TCPConn); ok {
+					tcp.CloseWrite()
+				}
+				close(copied)
+			}()
+			io.Copy(conn, upstream)
+			<-copied
+		}()
+	}
+}
+
+// Close stops the egress proxies
+func (s *netSandbox) Close() {
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
+	s.wg.Wait()
+}
+
+// setLoopbackUp brings up lo in the calling thread's network namespace
+func setLoopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open control socket: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	// struct ifreq: interface name followed by the flags union
+	var ifr struct {
+		name  [syscall.IFNAMSIZ]byte
+		flags uint16
+		_     [22]byte
+	}
+	copy(ifr.name[:], "lo")
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCGIFFLAGS, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return fmt.Errorf("failed to read loopback flags: %v", errno)
+	}
+	ifr.flags |= syscall.IFF_UP
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return fmt.Errorf("failed to bring loopback up: %v", errno)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package runtime
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/runink/runink/parser"
+)
+
+// TestParseEgressRule tests parsing allow-list entries
+func TestParseEgressRule(t *testing.T) {
+	rule, err := ParseEgressRule("5432=db.internal:5432")
+	if err != nil {
+		t.Fatalf("Failed to parse rule: %v", err)
+	}
+	if rule.ListenPort != 5432 || rule.Target != "db.internal:5432" {
+		t.Errorf("Unexpected rule: %+v", rule)
+	}
+
+	for _, spec := range []string{"db.internal:5432", "0=db:1", "80=nohost"} {
+		if _, err := ParseEgressRule(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+
+	isolation := parser.RuntimeIsolation{NetNamespacePerSlice: true}
+	if HerdNamespaces(isolation)&CLONE_NEWNET == 0 {
+		t.Error("Expected NetNamespacePerSlice to unshare the network namespace")
+	}
+}
+
+// TestEgressHelper is run inside the slice by TestExecutorNetNamespace
+func TestEgressHelper(t *testing.T) {
+	if os.Getenv("RUNINK_EGRESS_HELPER") == "" {
+		t.Skip("Helper for TestExecutorNetNamespace")
+	}
+
+	// The allowed endpoint is reachable through the loopback proxy
+	conn, err := net.Dial("tcp", os.Getenv("RUNINK_EGRESS_PROXY"))
+	if err != nil {
+		fmt.Println("proxy:", err)
+		os.Exit(1)
+	}
+	reply, _ := bufio.NewReader(conn).ReadString('\n')
+	conn.Close()
+	fmt.Print("proxy:", reply)
+
+	// The host address itself is not
+	if conn, err := net.Dial("tcp", os.Getenv("RUNINK_EGRESS_TARGET")); err == nil {
+		conn.Close()
+		fmt.Println("direct: reachable")
+	} else {
+		fmt.Println("direct: blocked")
+	}
+	os.Exit(0)
+}
+
+// TestExecutorNetNamespace tests loopback-only networking with an allow-listed endpoint
+func TestExecutorNetNamespace(t *testing.T) {
+	// Skip this test if not running as root
+	if os.Geteuid() != 0 {
+		t.Skip("This test requires root privileges")
+	}
+
+	// A host-side endpoint the slice is allowed to reach
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer server.Close()
+	go func() {
+		for {
+			conn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			fmt.Fprintln(conn, "hello from sink")
+			conn.Close()
+		}
+	}()
+
+	executor := NewExecutor().
+		SetCommand([]string{os.Args[0], "-test.run=TestEgressHelper"}).
+		SetChrootDir("/").
+		SetNamespaces(CLONE_NEWNET).
+		SetEnv([]string{
+			"RUNINK_EGRESS_HELPER=1",
+			"RUNINK_EGRESS_PROXY=127.0.0.1:15432",
+			"RUNINK_EGRESS_TARGET=" + server.Addr().String(),
+		}).
+		SetEgress([]EgressRule{{ListenPort: 15432, Target: server.Addr().String()}})
+
+	result, err := executor.Execute()
+	if err != nil {
+		t.Skipf("Network namespaces unavailable: %v", err)
+	}
+
+	output := string(result.Stdout)
+	if result.ExitCode != 0 {
+		t.Fatalf("Helper failed with exit code %d: %s%s", result.ExitCode, output, result.Stderr)
+	}
+	if !strings.Contains(output, "proxy:hello from sink") {
+		t.Errorf("Expected allowed endpoint to be reachable, got %q", output)
+	}
+	if !strings.Contains(output, "direct: blocked") {
+		t.Errorf("Expected host network to be unreachable, got %q", output)
+	}
+}
@@ -0,0 +1,265 @@
+// Package runtime provides isolation and resource control for RunInk node execution
+package runtime
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxRecordSize is the largest NDJSON record a plugin may write on one line
+const maxRecordSize = 16 * 1024 * 1024
+
+// PluginStep runs a pipeline step implemented by an external executable.
+//
+// Records are exchanged as newline-delimited JSON: the plugin reads records
+// on stdin and writes records on stdout. JSON objects written to stderr are
+// dead-letter records; any other stderr output is treated as log output.
+type PluginStep struct {
+	// Name identifies the step in errors, logs and the cgroup name
+	Name string
+
+	// Command is the plugin executable and its arguments
+	Command []string
+
+	// Limits are the resource limits applied to the plugin process
+	Limits Limits
+
+	// Herd places the plugin's cgroup under the herd's cgroup, which is
+	// created with HerdQuota if missing, see HerdLimits
+	Herd      string
+	HerdQuota Limits
+
+	// Namespaces are the namespaces to unshare, see HerdNamespaces
+	Namespaces int
+
+	// ChrootDir is the root filesystem for the plugin. When empty, each run
+	// gets a fresh layered rootfs, see Rootfs
+	ChrootDir string
+
+	// Rootfs overrides the default layered root filesystem
+	Rootfs *RootfsSpec
+
+	// Timeout kills the plugin if it runs longer. Zero means no timeout.
+	Timeout time.Duration
+
+	// GracePeriod is how long a cancelled plugin has to exit after SIGTERM
+	// before it is killed. Default: DefaultGracePeriod
+	GracePeriod time.Duration
+
+	// Env holds the plugin's environment variables
+	Env []string
+
+	// Secrets are delivered to the plugin when it starts, see SecretSpec
+	Secrets *SecretSpec
+
+	// Seccomp is the plugin's syscall profile, see HerdSeccompProfile
+	Seccomp *SeccompProfile
+
+	// Landlock limits the plugin's filesystem access, see LandlockRuleset
+	Landlock *LandlockRuleset
+
+	// Egress lists the endpoints the plugin may reach when it runs in its
+	// own network namespace
+	Egress []EgressRule
+
+	// DLQ receives dead-letter records as NDJSON. Dead letters are dropped when nil.
+	DLQ io.Writer
+
+	// Log receives non-record stderr lines. Default: os.Stderr
+	Log io.Writer
+
+	// Logs captures non-record stderr lines in a rotating slice log
+	// instead of Log, see LogConfig. Slice defaults to Name, RunID to the
+	// step's RunID.
+	Logs *LogConfig
+
+	// RunID registers the plugin under its run while it runs, so that
+	// runi kill --run-id can stop it
+	RunID string
+}
+
+// NewPluginStep creates a plugin step with the default namespaces
+func NewPluginStep(name string, command []string) *PluginStep {
+	return &PluginStep{
+		Name:       name,
+		Command:    command,
+		Namespaces: DefaultNamespaces,
+		Log:        os.Stderr,
+	}
+}
+
+// Run streams the records from r through the plugin and writes the records
+// it produces to w. Its signature matches the contract step functions.
+func (p *PluginStep) Run(r io.Reader, w io.Writer) error {
+	return p.RunContext(context.Background(), r, w)
+}
+
+// RunContext is like Run, but stops the plugin gracefully when ctx is
+// cancelled, see GracePeriod
+func (p *PluginStep) RunContext(ctx context.Context, r io.Reader, w io.Writer) error {
+	if len(p.Command) == 0 {
+		return fmt.Errorf("plugin step %s has no command", p.Name)
+	}
+
+	// Route log lines to the slice log if configured
+	logLine := p.logLine
+	if p.Logs != nil {
+		config := *p.Logs
+		if config.Slice == "" {
+			config.Slice = p.Name
+		}
+		if config.RunID == "" {
+			config.RunID = p.RunID
+		}
+		if sliceLog, err := OpenSliceLog(config); err != nil {
+			// Don't fail the step if logs cannot be captured, just log the error
+			fmt.Fprintf(os.Stderr, "Warning: failed to open slice log: %v\n", err)
+		} else {
+			defer sliceLog.Close()
+			stream := sliceLog.Stream("stderr")
+			defer stream.Close()
+			logLine = func(line []byte) {
+				stream.Write(append(line, '\n'))
+			}
+		}
+	}
+
+	stdoutReader, stdoutWriter := io.Pipe()
+	stderrReader, stderrWriter := io.Pipe()
+
+	// Drain stdout and stderr concurrently so the plugin never blocks on a full pipe
+	var wg sync.WaitGroup
+	var recordErr, dlqErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		recordErr = p.copyRecords(stdoutReader, w)
+	}()
+	go func() {
+		defer wg.Done()
+		dlqErr = p.routeStderr(stderrReader, logLine)
+	}()
+
+	executor := NewExecutor().
+		SetCommand(p.Command).
+		SetLimits(p.Limits).
+		SetChrootDir(p.ChrootDir).
+		SetEnv(p.Env).
+		SetSecrets(p.Secrets).
+		SetEgress(p.Egress).
+		SetSeccomp(p.Seccomp).
+		SetLandlock(p.Landlock).
+		SetNamespaces(p.Namespaces).
+		SetCgroupName(fmt.Sprintf("runink-%s-%d", p.Name, os.Getpid())).
+		SetHerd(p.Herd, p.HerdQuota).
+		SetRunID(p.RunID).
+		SetTimeout(p.Timeout).
+		SetContext(ctx).
+		SetGracePeriod(p.GracePeriod).
+		SetStdin(r).
+		SetStdout(stdoutWriter).
+		SetStderr(stderrWriter)
+
+	if p.Rootfs != nil {
+		executor.SetRootfs(*p.Rootfs)
+	}
+
+	result, err := executor.Execute()
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	wg.Wait()
+
+	if err != nil {
+		return fmt.Errorf("plugin step %s failed to run: %v", p.Name, err)
+	}
+	if err := result.Failure("plugin step " + p.Name); err != nil {
+		return err
+	}
+	if recordErr != nil {
+		return fmt.Errorf("plugin step %s: %v", p.Name, recordErr)
+	}
+	if dlqErr != nil {
+		return fmt.Errorf("plugin step %s: failed to write dead letters: %v", p.Name, dlqErr)
+	}
+
+	return nil
+}
+
+// copyRecords validates each stdout line as a JSON record and forwards it to w.
+// The stream is always drained to EOF so a misbehaving plugin cannot stall.
+func (p *PluginStep) copyRecords(r io.Reader, w io.Writer) error {
+	var firstErr error
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		record := bytes.TrimSpace(scanner.Bytes())
+		if len(record) == 0 || firstErr != nil {
+			continue
+		}
+		if !json.Valid(record) {
+			firstErr = fmt.Errorf("invalid JSON record on stdout line %d", line)
+			continue
+		}
+		if _, err := w.Write(append(record, '\n')); err != nil {
+			firstErr = fmt.Errorf("failed to write record: %v", err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		// Keep draining so the plugin can still exit
+		io.Copy(io.Discard, r)
+		if firstErr == nil {
+			firstErr = fmt.Errorf("failed to read plugin output: %v", err)
+		}
+	}
+
+	return firstErr
+}
+
+// logLine writes a log line to Log, prefixed with the step name
+func (p *PluginStep) logLine(line []byte) {
+	if p.Log != nil {
+		fmt.Fprintf(p.Log, "[%s] %s\n", p.Name, line)
+	}
+}
+
+// routeStderr routes JSON object lines to the DLQ and passes everything else to logLine
+func (p *PluginStep) routeStderr(r io.Reader, logLine func(line []byte)) error {
+	var firstErr error
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		if line[0] == '{' && json.Valid(line) {
+			if p.DLQ != nil && firstErr == nil {
+				if _, err := p.DLQ.Write(append(line, '\n')); err != nil {
+					firstErr = err
+				}
+			}
+			continue
+		}
+
+		logLine(line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		io.Copy(io.Discard, r)
+	}
+
+	return firstErr
+}
@@ -0,0 +1,108 @@
+package runtime
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPluginStepSplitsStreams tests record validation and the stderr DLQ side channel
+func TestPluginStepSplitsStreams(t *testing.T) {
+	var out, dlq, log bytes.Buffer
+	step := &PluginStep{Name: "enrich", DLQ: &dlq, Log: &log}
+
+	if err := step.copyRecords(strings.NewReader("{\"id\":1}\n\n{\"id\":2}\n"), &out); err != nil {
+		t.Fatalf("Failed to copy records: %v", err)
+	}
+	if out.String() != "{\"id\":1}\n{\"id\":2}\n" {
+		t.Errorf("Unexpected records: %q", out.String())
+	}
+
+	if err := step.copyRecords(strings.NewReader("{\"id\":1}\nnot json\n{\"id\":3}\n"), &out); err == nil {
+		t.Error("Expected invalid record to fail the step")
+	}
+
+	stderr := "starting up\n{\"id\":7,\"reason\":\"bad price\"}\n[1,2]\n"
+	if err := step.routeStderr(strings.NewReader(stderr), step.logLine); err != nil {
+		t.Fatalf("Failed to route stderr: %v", err)
+	}
+	if dlq.String() != "{\"id\":7,\"reason\":\"bad price\"}\n" {
+		t.Errorf("Unexpected dead letters: %q", dlq.String())
+	}
+	if log.String() != "[enrich] starting up\n[enrich] [1,2]\n" {
+		t.Errorf("Unexpected log output: %q", log.String())
+	}
+}
+
+// TestPluginStepRun tests running a shell plugin through the Executor
+func TestPluginStepRun(t *testing.T) {
+	// Skip this test if not running as root
+	if os.Geteuid() != 0 {
+		t.Skip("This test requires root privileges")
+	}
+
+	var out, dlq bytes.Buffer
+	step := NewPluginStep("passthrough", []string{"/bin/sh", "-c",
+		`while read -r line; do echo "$line"; done; echo '{"dropped":true}' >&2`})
+	step.ChrootDir = "/"
+	step.Namespaces = 0
+	step.DLQ = &dlq
+
+	if err := step.Run(strings.NewReader("{\"id\":1}\n{\"id\":2}\n"), &out); err != nil {
+		t.Fatalf("Plugin step failed: %v", err)
+	}
+	if out.String() != "{\"id\":1}\n{\"id\":2}\n" {
+		t.Errorf("Unexpected records: %q", out.String())
+	}
+	if dlq.String() != "{\"dropped\":true}\n" {
+		t.Errorf("Unexpected dead letters: %q", dlq.String())
+	}
+
+	step.Command = []string{"/bin/sh", "-c", "exit 3"}
+	if err := step.Run(strings.NewReader(""), &out); err == nil || !strings.Contains(err.Error(), "code 3") {
+		t.Errorf("Expected crashing plugin to report its exit code, got %v", err)
+	}
+}
+
+// TestPluginStepKillRun tests that a plugin step registers under its run
+// so that KillRun stops it
+func TestPluginStepKillRun(t *testing.T) {
+	// Skip this test if not running as root
+	if os.Geteuid() != 0 {
+		t.Skip("This test requires root privileges")
+	}
+
+	defer func(dir string) { RunDir = dir }(RunDir)
+	RunDir = t.TempDir()
+
+	step := NewPluginStep("stuck", []string{"/bin/sh", "-c", "trap '' TERM; while :; do sleep 0.05; done"})
+	step.ChrootDir = "/"
+	step.Namespaces = 0
+	step.RunID = "run-plugin"
+
+	done := make(chan error, 1)
+	go func() { done <- step.Run(strings.NewReader(""), io.Discard) }()
+
+	for i := 0; i < 100; i++ {
+		if records, _ := filepath.Glob(filepath.Join(RunDir, "run-plugin", "*.json")); len(records) > 0 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if count, err := KillRun("run-plugin", 100*time.Millisecond); err != nil || count != 1 {
+		t.Fatalf("Expected to kill 1 plugin, got %d (%v)", count, err)
+	}
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected the killed plugin to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Plugin still running after KillRun")
+	}
+}
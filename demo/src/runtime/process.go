@@ -0,0 +1,305 @@
+// Package runtime provides isolation and resource control for RunInk node execution
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultGracePeriod is how long a cancelled slice has to exit after SIGTERM
+// before it is killed
+const DefaultGracePeriod = 10 * time.Second
+
+// RunDir is where running slices are registered by run ID, so that another
+// process can stop a run: <dir>/<run-id>/<slice>.json
+var RunDir = "/run/runink/runs"
+
+// pidfd syscalls, numbered the same on all architectures
+const (
+	sysPidfdSendSignal = 424
+	sysPidfdOpen       = 434
+)
+
+// exitPollInterval is how often a terminating slice is checked for exit
+const exitPollInterval = 50 * time.Millisecond
+
+// SliceProcess is a running slice's process group. The group leader is
+// held through a pidfd where the kernel supports it, so signals can never
+// reach an unrelated process that reused its pid.
+type SliceProcess struct {
+	// Pid is the group leader, Pgid the process group (the same for slices)
+	Pid  int `json:"pid"`
+	Pgid int `json:"pgid"`
+
+	// StartTime is the leader's start time in clock ticks since boot,
+	// which identifies it together with Pid
+	StartTime uint64 `json:"start_time"`
+
+	// Cgroup is the absolute path of the slice's cgroup, if any. Records
+	// carry the path the agent resolved, since the cgroup root of a
+	// rootless agent is its delegated cgroup, not the one of whoever
+	// stops the run.
+	Cgroup string `json:"cgroup,omitempty"`
+
+	// mu guards pidfd, so that Close never releases it while a signal is
+	// sent through it and the fd number cannot be reused under a signal
+	mu    sync.Mutex
+	pidfd int
+}
+
+// TrackProcess starts tracking the process group led by pid, in the cgroup
+// at path cgroup. It fails if the process exited or pid now belongs to a
+// process started at a different time; a zero startTime skips that check.
+func TrackProcess(pid int, startTime uint64, cgroup string) (*SliceProcess, error) {
+	p := &SliceProcess{Pid: pid, Pgid: pid, Cgroup: cgroup, pidfd: -1}
+
+	// Open the pidfd before checking the start time, so the check applies
+	// to the process the pidfd pins
+	if fd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(pid), 0, 0); errno == 0 {
+		p.pidfd = int(fd)
+	} else if errno == syscall.ESRCH {
+		return nil, fmt.Errorf("process %d has exited", pid)
+	}
+
+	current, err := processStartTime(pid)
+	if err != nil {
+		p.Close()
+		return nil, fmt.Errorf("process %d has exited", pid)
+	}
+	if startTime != 0 && current != startTime {
+		p.Close()
+		return nil, fmt.Errorf("process %d is no longer the tracked slice", pid)
+	}
+	p.StartTime = current
+
+	return p, nil
+}
+
+// Signal sends sig to the leader and every process in its group
+func (p *SliceProcess) Signal(sig syscall.Signal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var err error
+	if p.pidfd >= 0 {
+		if _, _, errno := syscall.Syscall6(sysPidfdSendSignal, uintptr(p.pidfd), uintptr(sig), 0, 0, 0, 0); errno != 0 && errno != syscall.ESRCH {
+			err = errno
+		}
+	}
+
+	// The group may outlive its leader
+	if groupErr := syscall.Kill(-p.Pgid, sig); groupErr != nil && groupErr != syscall.ESRCH {
+		err = groupErr
+	}
+	return err
+}
+
+// Kill sends SIGKILL to the group and to every process left in the slice's
+// cgroup, which also catches processes that started their own group. Once
+// the leader is gone its group id may be reused, so only the cgroup is
+// killed.
+func (p *SliceProcess) Kill() error {
+	var err error
+	if p.Alive() {
+		err = p.Signal(syscall.SIGKILL)
+	}
+	if p.Cgroup != "" {
+		// cgroup.kill exists since Linux 5.14
+		killFile := filepath.Join(p.Cgroup, "cgroup.kill")
+		if _, statErr := os.Stat(killFile); statErr == nil {
+			if writeErr := os.WriteFile(killFile, []byte("1"), 0644); writeErr != nil && err == nil {
+				err = writeErr
+			}
+		}
+	}
+	return err
+}
+
+// Terminate stops the slice gracefully: SIGTERM, then SIGKILL if it has not
+// exited within the grace period. Closing exited reports the exit; without
+// it the leader is polled.
+func (p *SliceProcess) Terminate(grace time.Duration, exited <-chan struct{}) {
+	if err := p.Signal(syscall.SIGTERM); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to signal slice %d: %v\n", p.Pid, err)
+	}
+
+	deadline := time.NewTimer(grace)
+	defer deadline.Stop()
+	poll := time.NewTicker(exitPollInterval)
+	defer poll.Stop()
+	for {
+		select {
+		case <-exited:
+			return
+		case <-poll.C:
+			if exited == nil && !p.Alive() {
+				return
+			}
+		case <-deadline.C:
+			if err := p.Kill(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to kill slice %d: %v\n", p.Pid, err)
+			}
+			return
+		}
+	}
+}
+
+// Alive reports whether the group leader is still running
+func (p *SliceProcess) Alive() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pidfd >= 0 {
+		_, _, errno := syscall.Syscall6(sysPidfdSendSignal, uintptr(p.pidfd), 0, 0, 0, 0, 0)
+		return errno == 0 || errno == syscall.EPERM
+	}
+	current, err := processStartTime(p.Pid)
+	return err == nil && current == p.StartTime
+}
+
+// Close releases the pidfd
+func (p *SliceProcess) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pidfd < 0 {
+		return nil
+	}
+	err := syscall.Close(p.pidfd)
+	p.pidfd = -1
+	return err
+}
+
+// processStartTime reads a process's start time from /proc/<pid>/stat
+func processStartTime(pid int) (uint64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	// The command name may contain spaces; fields resume after its ")"
+	end := strings.LastIndexByte(string(data), ')')
+	if end < 0 {
+		return 0, fmt.Errorf("malformed stat for process %d", pid)
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	// starttime is field 22; fields here start at field 3
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("malformed stat for process %d", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// registerSlice records a running slice under its run ID until the
+// returned function is called
+func registerSlice(runID, slice string, p *SliceProcess) (func(), error) {
+	if strings.ContainsRune(runID, '/') || strings.ContainsRune(slice, '/') {
+		return nil, fmt.Errorf("invalid run %q or slice %q", runID, slice)
+	}
+	dir := filepath.Join(RunDir, runID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create run directory: %v", err)
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, slice+".json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to register slice: %v", err)
+	}
+
+	return func() {
+		os.Remove(path)
+		// Removed only once the run's last slice is gone
+		os.Remove(dir)
+	}, nil
+}
+
+// KillRun stops every registered slice of a run: each gets SIGTERM, then
+// SIGKILL once the grace period is over, and its cgroup is removed. It
+// returns the number of slices that were running.
+func KillRun(runID string, grace time.Duration) (int, error) {
+	if runID == "" || strings.ContainsRune(runID, '/') {
+		return 0, fmt.Errorf("invalid run id: %q", runID)
+	}
+	dir := filepath.Join(RunDir, runID)
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	if len(paths) == 0 {
+		return 0, fmt.Errorf("no running slices found for run %s", runID)
+	}
+
+	var slices []*SliceProcess
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		record := SliceProcess{}
+		if err := json.Unmarshal(data, &record); err != nil {
+			return 0, fmt.Errorf("invalid slice record %s: %v", path, err)
+		}
+
+		// Records of older agents hold the cgroup relative to the root
+		cgroup := record.Cgroup
+		if cgroup != "" && !filepath.IsAbs(cgroup) {
+			cgroup = filepath.Join(CgroupRoot(), cgroup)
+		}
+		p, err := TrackProcess(record.Pid, record.StartTime, cgroup)
+		if err != nil {
+			// Stale record of a slice that already exited
+			os.Remove(path)
+			continue
+		}
+		defer p.Close()
+		slices = append(slices, p)
+	}
+
+	// Signal all slices first so they shut down in parallel
+	for _, p := range slices {
+		if err := p.Signal(syscall.SIGTERM); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to signal slice %d: %v\n", p.Pid, err)
+		}
+	}
+	deadline := time.Now().Add(grace)
+	for _, p := range slices {
+		for p.Alive() && time.Now().Before(deadline) {
+			time.Sleep(exitPollInterval)
+		}
+	}
+
+	var firstErr error
+	for _, p := range slices {
+		if err := p.Kill(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if p.Cgroup != "" {
+			removeCgroupWhenEmpty(p.Cgroup)
+		}
+	}
+
+	return len(slices), firstErr
+}
+
+// removeCgroupWhenEmpty removes the cgroup at path once its killed
+// processes are gone
+func removeCgroupWhenEmpty(path string) {
+	for i := 0; i < 20; i++ {
+		if err := syscall.Rmdir(path); err == nil || err == syscall.ENOENT {
+			return
+		}
+		time.Sleep(exitPollInterval)
+	}
+	fmt.Fprintf(os.Stderr, "Warning: cgroup %s is still busy\n", path)
+}
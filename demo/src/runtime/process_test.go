@@ -0,0 +1,191 @@
+package runtime
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestTrackProcess tests that a reused pid is not mistaken for the slice
+func TestTrackProcess(t *testing.T) {
+	p, err := TrackProcess(os.Getpid(), 0, "")
+	if err != nil {
+		t.Fatalf("Failed to track own process: %v", err)
+	}
+	defer p.Close()
+	if p.StartTime == 0 || !p.Alive() {
+		t.Errorf("Expected a live process with a start time, got %+v", p)
+	}
+
+	if _, err := TrackProcess(os.Getpid(), p.StartTime+1, ""); err == nil {
+		t.Error("Expected a different start time to be rejected")
+	}
+}
+
+// TestExecutorCancel tests that cancelling the context stops the whole
+// process group, escalating to SIGKILL for slices that ignore SIGTERM
+func TestExecutorCancel(t *testing.T) {
+	// Skip this test if not running as root
+	if os.Geteuid() != 0 {
+		t.Skip("This test requires root privileges")
+	}
+
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	tests := []struct {
+		name   string
+		script string
+	}{
+		{"graceful", "sleep 30 & echo $! > " + pidFile + "; wait"},
+		{"ignores SIGTERM", "trap '' TERM; sleep 30 & echo $! > " + pidFile + "; while :; do sleep 0.05; done"},
+	}
+
+	for _, tt := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(300*time.Millisecond, cancel)
+
+		start := time.Now()
+		result, err := NewExecutor().
+			SetCommand([]string{"/bin/sh", "-c", tt.script}).
+			SetChrootDir("/").
+			SetNamespaces(0).
+			SetContext(ctx).
+			SetGracePeriod(200 * time.Millisecond).
+			Execute()
+		if err != nil {
+			t.Fatalf("%s: failed to execute: %v", tt.name, err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%s: expected the slice to stop after cancellation, ran for %v", tt.name, elapsed)
+		}
+		if result.FailureReason != FailureCanceled {
+			t.Errorf("%s: expected %s, got %q", tt.name, FailureCanceled, result.FailureReason)
+		}
+
+		// The background child must not be orphaned
+		data, err := os.ReadFile(pidFile)
+		if err != nil {
+			t.Fatalf("%s: failed to read child pid: %v", tt.name, err)
+		}
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+		time.Sleep(100 * time.Millisecond)
+		if syscall.Kill(pid, 0) == nil {
+			if state, _ := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat")); !strings.Contains(string(state), ") Z ") {
+				t.Errorf("%s: child %d survived cancellation", tt.name, pid)
+				syscall.Kill(pid, syscall.SIGKILL)
+			}
+		}
+	}
+}
+
+// TestKillRun tests stopping all slices of a run from outside the executor
+func TestKillRun(t *testing.T) {
+	// Skip this test if not running as root
+	if os.Geteuid() != 0 {
+		t.Skip("This test requires root privileges")
+	}
+
+	defer func(dir string) { RunDir = dir }(RunDir)
+	RunDir = t.TempDir()
+
+	done := make(chan ExecutorResult, 1)
+	go func() {
+		result, _ := NewExecutor().
+			SetCommand([]string{"/bin/sh", "-c", "trap '' TERM; while :; do sleep 0.05; done"}).
+			SetChrootDir("/").
+			SetNamespaces(0).
+			SetCgroupName("kill-test").
+			SetRunID("run-kill").
+			Execute()
+		done <- result
+	}()
+
+	record := filepath.Join(RunDir, "run-kill", "kill-test.json")
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(record); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// The record holds the cgroup path the agent resolved
+	data, err := os.ReadFile(record)
+	if err != nil {
+		t.Fatalf("Failed to read slice record: %v", err)
+	}
+	registered := SliceProcess{}
+	if err := json.Unmarshal(data, &registered); err != nil {
+		t.Fatalf("Invalid slice record: %v", err)
+	}
+	if expected := filepath.Join(CgroupRoot(), "kill-test"); registered.Cgroup != expected {
+		t.Errorf("Expected cgroup %s in the record, got %s", expected, registered.Cgroup)
+	}
+
+	count, err := KillRun("run-kill", 200*time.Millisecond)
+	if err != nil || count != 1 {
+		t.Fatalf("Expected to kill 1 slice, got %d (%v)", count, err)
+	}
+
+	select {
+	case result := <-done:
+		if result.ExitCode == 0 {
+			t.Error("Expected the killed slice to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Slice still running after KillRun")
+	}
+
+	if _, err := os.Stat(filepath.Dir(record)); !os.IsNotExist(err) {
+		t.Error("Expected the run to be unregistered")
+	}
+	if _, err := KillRun("run-kill", 0); err == nil {
+		t.Error("Expected an error for a run without slices")
+	}
+}
+
+// TestKillAfterLeaderExit tests that the group of an exited leader is not
+// signalled, since its id may belong to another group by now
+func TestKillAfterLeaderExit(t *testing.T) {
+	cmd := exec.Command("/bin/sh", "-c", "sleep 30 >/dev/null 2>&1 & echo $!")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("Failed to create stdout pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	p, err := TrackProcess(cmd.Process.Pid, 0, "")
+	if err != nil {
+		t.Fatalf("Failed to track: %v", err)
+	}
+	defer p.Close()
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read the background pid: %v", err)
+	}
+	member, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatalf("Invalid background pid %q", line)
+	}
+	defer syscall.Kill(member, syscall.SIGKILL)
+	cmd.Wait()
+
+	if p.Alive() {
+		t.Fatal("Expected the leader to have exited")
+	}
+	if err := p.Kill(); err != nil {
+		t.Fatalf("Failed to kill: %v", err)
+	}
+	if err := syscall.Kill(member, 0); err != nil {
+		t.Errorf("Expected the group of an exited leader to be left alone, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+// Package runtime provides isolation and resource control for RunInk node execution
+package runtime
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// quantitySuffixes are the Kubernetes quantity suffixes, longest first so
+// that "Mi" is not mistaken for "M"
+var quantitySuffixes = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30},
+	{"Ti", 1 << 40}, {"Pi", 1 << 50}, {"Ei", 1 << 60},
+	{"k", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12}, {"P", 1e15}, {"E", 1e18},
+	{"m", 1e-3},
+}
+
+// ParseQuantity parses a Kubernetes-style quantity such as "500m", "2",
+// "1.5", "64Gi", "100M" or "1e3" into its value in base units
+func ParseQuantity(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty quantity")
+	}
+
+	number, multiplier := s, 1.0
+	for _, q := range quantitySuffixes {
+		if strings.HasSuffix(s, q.suffix) {
+			number, multiplier = strings.TrimSuffix(s, q.suffix), q.multiplier
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("invalid quantity: %s", s)
+	}
+	if value < 0 {
+		return 0, fmt.Errorf("quantity must not be negative: %s", s)
+	}
+	return value * multiplier, nil
+}
+
+// ParseCPUQuantity parses a CPU quantity into millicores: "500m" is 500, "2" is 2000
+func ParseCPUQuantity(s string) (int64, error) {
+	value, err := ParseQuantity(s)
+	if err != nil {
+		return 0, err
+	}
+	return toInt64(value*1000, s)
+}
+
+// ParseByteQuantity parses a memory or storage quantity into bytes: "1Gi" is 1073741824
+func ParseByteQuantity(s string) (int64, error) {
+	value, err := ParseQuantity(s)
+	if err != nil {
+		return 0, err
+	}
+	return toInt64(value, s)
+}
+
+// toInt64 rounds a parsed quantity, rejecting values that overflow
+func toInt64(value float64, s string) (int64, error) {
+	value = math.Ceil(value)
+	if value >= math.MaxInt64 {
+		return 0, fmt.Errorf("quantity out of range: %s", s)
+	}
+	return int64(value), nil
+}
@@ -0,0 +1,346 @@
+// Package runtime provides isolation and resource control for RunInk node execution
+package runtime
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// rootfsPrefix names the temporary directories holding slice root filesystems
+const rootfsPrefix = "runink-rootfs-"
+
+// rootfsOwnerFile records the pid and start time of the agent that mounted
+// a rootfs, so that a sweep only reclaims the roots of dead agents
+const rootfsOwnerFile = "owner"
+
+// rootfsOwnerGrace is how long a rootfs without an owner file is assumed to
+// be still under construction
+const rootfsOwnerGrace = time.Minute
+
+// DefaultHostPaths is the host allow-list exposed read-only to slices that
+// bring no base layer, enough to run dynamically linked binaries
+var DefaultHostPaths = []string{
+	"/bin", "/sbin", "/lib", "/lib64", "/usr",
+	"/etc/ld.so.cache", "/etc/ssl/certs",
+}
+
+// RootfsSpec describes a slice's layered root filesystem
+type RootfsSpec struct {
+	// BaseDir is the read-only lower layer, e.g. an unpacked step bundle.
+	// Default: an empty skeleton created with PrepareChroot
+	BaseDir string
+
+	// HostPaths are host files or directories bind-mounted read-only at the
+	// same path. Missing paths are skipped; symlinks are recreated as-is.
+	HostPaths []string
+
+	// WritablePaths are host directories bind-mounted read-write at the
+	// same path, created if missing
+	WritablePaths []string
+
+	// InputDir is bind-mounted read-only at /input
+	InputDir string
+
+	// OutputDir is bind-mounted read-write at /output
+	OutputDir string
+}
+
+// Rootfs is a mounted per-slice root filesystem: an overlay of a writable
+// upper dir over the read-only base, plus bind mounts
+type Rootfs struct {
+	// Dir is the merged root to chroot into
+	Dir string
+
+	workRoot string
+	mounts   []string
+}
+
+// MountRootfs assembles a root filesystem for one slice. Writes land in a
+// per-slice upper dir, so the base layer is never modified or copied.
+// Mounting requires root; call Cleanup when the slice exits.
+func MountRootfs(spec RootfsSpec) (*Rootfs, error) {
+	workRoot, err := os.MkdirTemp("", rootfsPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rootfs directory: %v", err)
+	}
+	rootfs := &Rootfs{
+		Dir:      filepath.Join(workRoot, "merged"),
+		workRoot: workRoot,
+	}
+	if err := writeRootfsOwner(workRoot); err != nil {
+		os.RemoveAll(workRoot)
+		return nil, err
+	}
+
+	if err := rootfs.mount(spec); err != nil {
+		rootfs.Cleanup()
+		return nil, err
+	}
+	return rootfs, nil
+}
+
+// writeRootfsOwner records the calling process as the owner of a rootfs
+func writeRootfsOwner(workRoot string) error {
+	pid := os.Getpid()
+	startTime, err := processStartTime(pid)
+	if err != nil {
+		return fmt.Errorf("failed to read agent start time: %v", err)
+	}
+	owner := fmt.Sprintf("%d %d\n", pid, startTime)
+	if err := os.WriteFile(filepath.Join(workRoot, rootfsOwnerFile), []byte(owner), 0644); err != nil {
+		return fmt.Errorf("failed to record rootfs owner: %v", err)
+	}
+	return nil
+}
+
+// rootfsOwnerAlive reports whether the agent that mounted a rootfs is
+// still running. Roots without an owner count as live during the grace
+// period after they were created.
+func rootfsOwnerAlive(workRoot string) bool {
+	data, err := os.ReadFile(filepath.Join(workRoot, rootfsOwnerFile))
+	if os.IsNotExist(err) {
+		info, err := os.Stat(workRoot)
+		return err == nil && time.Since(info.ModTime()) < rootfsOwnerGrace
+	}
+	if err != nil {
+		return true
+	}
+
+	var pid int
+	var startTime uint64
+	if _, err := fmt.Sscanf(string(data), "%d %d", &pid, &startTime); err != nil {
+		return false
+	}
+	current, err := processStartTime(pid)
+	return err == nil && current == startTime
+}
+
+// mount mounts the overlay and the bind mounts on top of it
+func (r *Rootfs) mount(spec RootfsSpec) error {
+	// Bind the work root onto itself as a private mount, so the slice's
+	// mounts neither propagate to the host's shared peers nor receive theirs
+	if err := syscall.Mount(r.workRoot, r.workRoot, "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("failed to bind rootfs directory: %v", err)
+	}
+	r.mounts = append(r.mounts, r.workRoot)
+	if err := syscall.Mount("", r.workRoot, "", syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make rootfs private: %v", err)
+	}
+
+	lower := spec.BaseDir
+	if lower == "" {
+		lower = filepath.Join(r.workRoot, "lower")
+		if err := PrepareChroot(lower); err != nil {
+			return err
+		}
+	}
+	upper := filepath.Join(r.workRoot, "upper")
+	work := filepath.Join(r.workRoot, "work")
+	for _, dir := range []string{upper, work, r.Dir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %v", dir, err)
+		}
+	}
+
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lower, upper, work)
+	if err := syscall.Mount("overlay", r.Dir, "overlay", 0, options); err != nil {
+		return fmt.Errorf("failed to mount overlay: %v", err)
+	}
+	r.mounts = append(r.mounts, r.Dir)
+
+	// Expose the host allow-list read-only
+	for _, path := range spec.HostPaths {
+		if err := r.bindHostPath(path); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range spec.WritablePaths {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %v", path, err)
+		}
+		if err := r.bind(path, path, false); err != nil {
+			return err
+		}
+	}
+
+	if spec.InputDir != "" {
+		if err := r.bind(spec.InputDir, "/input", true); err != nil {
+			return err
+		}
+	}
+	if spec.OutputDir != "" {
+		if err := os.MkdirAll(spec.OutputDir, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %v", err)
+		}
+		if err := r.bind(spec.OutputDir, "/output", false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bindHostPath mirrors a host path into the rootfs
+func (r *Rootfs) bindHostPath(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %v", path, err)
+	}
+
+	// Merged-/usr hosts link /bin and /lib into /usr; keep the link
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(path)
+		if err != nil {
+			return fmt.Errorf("failed to read link %s: %v", path, err)
+		}
+		target := filepath.Join(r.Dir, path)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %v", filepath.Dir(target), err)
+		}
+		os.RemoveAll(target)
+		if err := os.Symlink(link, target); err != nil {
+			return fmt.Errorf("failed to link %s: %v", path, err)
+		}
+		return nil
+	}
+
+	return r.bind(path, path, true)
+}
+
+// bind mounts source at target inside the rootfs
+func (r *Rootfs) bind(source, target string, readOnly bool) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %v", source, err)
+	}
+
+	// Create the mount point in the upper layer
+	mountPoint := filepath.Join(r.Dir, target)
+	if info.IsDir() {
+		err = os.MkdirAll(mountPoint, 0755)
+	} else if err = os.MkdirAll(filepath.Dir(mountPoint), 0755); err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(mountPoint, os.O_CREATE, 0644); err == nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create mount point %s: %v", target, err)
+	}
+
+	if err := syscall.Mount(source, mountPoint, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to bind %s: %v", source, err)
+	}
+	r.mounts = append(r.mounts, mountPoint)
+
+	// Bind mounts ignore MS_RDONLY until remounted
+	if readOnly {
+		flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY | syscall.MS_REC)
+		if err := syscall.Mount("", mountPoint, "", flags, ""); err != nil {
+			return fmt.Errorf("failed to make %s read-only: %v", target, err)
+		}
+	}
+
+	return nil
+}
+
+// Cleanup unmounts everything in reverse order and removes the slice's layers
+func (r *Rootfs) Cleanup() error {
+	var firstErr error
+	for i := len(r.mounts) - 1; i >= 0; i-- {
+		if err := unmount(r.mounts[i]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	r.mounts = nil
+
+	// Never delete through a mount that is still attached
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := os.RemoveAll(r.workRoot); err != nil {
+		return fmt.Errorf("failed to remove rootfs: %v", err)
+	}
+	return nil
+}
+
+// unmount detaches the mount lazily if it is still busy
+func unmount(path string) error {
+	err := syscall.Unmount(path, 0)
+	if err == syscall.EBUSY {
+		err = syscall.Unmount(path, syscall.MNT_DETACH)
+	}
+	if err != nil && err != syscall.EINVAL && err != syscall.ENOENT {
+		return fmt.Errorf("failed to unmount %s: %v", path, err)
+	}
+	return nil
+}
+
+// SweepRootfs removes root filesystems left behind by agents that died
+// before cleaning up; roots whose owner is still running are left alone.
+// Call it when an agent starts.
+func SweepRootfs() error {
+	dirs, err := filepath.Glob(filepath.Join(os.TempDir(), rootfsPrefix+"*"))
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for _, dir := range dirs {
+		if !rootfsOwnerAlive(dir) {
+			stale = append(stale, dir)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return fmt.Errorf("failed to read mountinfo: %v", err)
+	}
+	defer f.Close()
+
+	var mounts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Field 5 is the mount point
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		for _, dir := range stale {
+			if fields[4] == dir || strings.HasPrefix(fields[4], dir+"/") {
+				mounts = append(mounts, fields[4])
+				break
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read mountinfo: %v", err)
+	}
+
+	// Unmount the deepest mounts first
+	sort.Sort(sort.Reverse(sort.StringSlice(mounts)))
+	for _, mountPoint := range mounts {
+		if err := syscall.Unmount(mountPoint, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL {
+			return fmt.Errorf("failed to unmount %s: %v", mountPoint, err)
+		}
+	}
+
+	for _, dir := range stale {
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("failed to remove %s: %v", dir, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRootfsLayers tests the overlay, read-only host paths and input/output binds
+func TestRootfsLayers(t *testing.T) {
+	// Skip this test if not running as root
+	if os.Geteuid() != 0 {
+		t.Skip("This test requires root privileges")
+	}
+
+	dataDir := t.TempDir()
+	inputDir := filepath.Join(dataDir, "in")
+	outputDir := filepath.Join(dataDir, "out")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, "trades.json"), []byte("{\"id\":1}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+
+	rootfs, err := MountRootfs(RootfsSpec{
+		HostPaths: DefaultHostPaths,
+		InputDir:  inputDir,
+		OutputDir: outputDir,
+	})
+	if err != nil {
+		t.Skipf("OverlayFS unavailable: %v", err)
+	}
+	workRoot := rootfs.workRoot
+	defer rootfs.Cleanup()
+
+	script := strings.Join([]string{
+		"cp /input/trades.json /output/trades.json",
+		"touch /scratch",
+		"touch /usr/runink-test 2>/dev/null && echo usr-writable",
+		"touch /input/x 2>/dev/null && echo input-writable",
+		"true",
+	}, "; ")
+	result, err := NewExecutor().
+		SetCommand([]string{"/bin/sh", "-c", script}).
+		SetChrootDir(rootfs.Dir).
+		Execute()
+	if err != nil {
+		t.Fatalf("Failed to execute: %v", err)
+	}
+	if result.ExitCode != 0 {
+		t.Fatalf("Command failed with exit code %d: %s", result.ExitCode, string(result.Stderr))
+	}
+	if strings.Contains(string(result.Stdout), "writable") {
+		t.Errorf("Expected read-only host paths and input, got %q", string(result.Stdout))
+	}
+
+	if data, err := os.ReadFile(filepath.Join(outputDir, "trades.json")); err != nil || string(data) != "{\"id\":1}\n" {
+		t.Errorf("Expected output to be written through the bind mount, got %q (%v)", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(workRoot, "upper", "scratch")); err != nil {
+		t.Errorf("Expected writes to land in the upper dir: %v", err)
+	}
+
+	if err := rootfs.Cleanup(); err != nil {
+		t.Fatalf("Failed to clean up: %v", err)
+	}
+	if _, err := os.Stat(workRoot); !os.IsNotExist(err) {
+		t.Errorf("Expected rootfs to be removed, got %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(outputDir, "trades.json")); err != nil || len(data) == 0 {
+		t.Errorf("Expected output to survive cleanup, got %v", err)
+	}
+}
+
+// TestSweepRootfs tests that only the roots of dead agents are reclaimed
+func TestSweepRootfs(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	// A process that has exited stands in for a dead agent
+	dead := exec.Command("/bin/sh", "-c", "exit 0")
+	if err := dead.Run(); err != nil {
+		t.Fatalf("Failed to run process: %v", err)
+	}
+
+	newRoot := func(name, owner string, age time.Duration) string {
+		dir := filepath.Join(os.TempDir(), rootfsPrefix+name)
+		if err := os.MkdirAll(filepath.Join(dir, "upper"), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+		if owner != "" {
+			if err := os.WriteFile(filepath.Join(dir, rootfsOwnerFile), []byte(owner), 0644); err != nil {
+				t.Fatalf("Failed to write owner: %v", err)
+			}
+		}
+		modTime := time.Now().Add(-age)
+		if err := os.Chtimes(dir, modTime, modTime); err != nil {
+			t.Fatalf("Failed to age %s: %v", dir, err)
+		}
+		return dir
+	}
+
+	live := newRoot("live", "", 0)
+	if err := writeRootfsOwner(live); err != nil {
+		t.Fatalf("Failed to write owner: %v", err)
+	}
+	deadOwner := newRoot("dead", fmt.Sprintf("%d 1\n", dead.Process.Pid), 0)
+	reusedPid := newRoot("reused", fmt.Sprintf("%d 1\n", os.Getpid()), 0)
+	creating := newRoot("creating", "", 0)
+	abandoned := newRoot("abandoned", "", 2*rootfsOwnerGrace)
+
+	if err := SweepRootfs(); err != nil {
+		t.Fatalf("Failed to sweep: %v", err)
+	}
+
+	for dir, kept := range map[string]bool{
+		live:      true,
+		deadOwner: false,
+		reusedPid: false,
+		creating:  true,
+		abandoned: false,
+	} {
+		_, err := os.Stat(dir)
+		if kept && err != nil {
+			t.Errorf("Expected %s to be kept: %v", filepath.Base(dir), err)
+		}
+		if !kept && !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", filepath.Base(dir), err)
+		}
+	}
+}
+
+// TestSweepRootfsKeepsMounted tests that a sweep leaves a live agent's
+// mounted rootfs alone and that its mounts are private
+func TestSweepRootfsKeepsMounted(t *testing.T) {
+	// Skip this test if not running as root
+	if os.Geteuid() != 0 {
+		t.Skip("This test requires root privileges")
+	}
+
+	rootfs, err := MountRootfs(RootfsSpec{HostPaths: DefaultHostPaths})
+	if err != nil {
+		t.Skipf("OverlayFS unavailable: %v", err)
+	}
+	defer rootfs.Cleanup()
+
+	if err := SweepRootfs(); err != nil {
+		t.Fatalf("Failed to sweep: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(rootfs.Dir, "usr")); err != nil {
+		t.Errorf("Expected the live rootfs to stay mounted: %v", err)
+	}
+
+	mountinfo, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		t.Fatalf("Failed to read mountinfo: %v", err)
+	}
+	for _, line := range strings.Split(string(mountinfo), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 7 || !strings.HasPrefix(fields[4], rootfs.workRoot) {
+			continue
+		}
+		// Optional fields up to "-" carry the propagation peer groups
+		for _, field := range fields[6:] {
+			if field == "-" {
+				break
+			}
+			if strings.HasPrefix(field, "shared:") || strings.HasPrefix(field, "master:") {
+				t.Errorf("Expected %s to be private, got %s", fields[4], field)
+			}
+		}
+	}
+}
@@ -0,0 +1,296 @@
+// Package runtime provides isolation and resource control for RunInk node execution
+package runtime
+
+//go:generate ./mksysnum.sh
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"syscall"
+	"unsafe"
+)
+
+// Seccomp filter constants from linux/seccomp.h and linux/filter.h
+const (
+	prSetNoNewPrivs       = 38
+	seccompModeFilter     = 2
+	seccompRetKillProcess = 0x80000000
+	seccompRetErrno       = 0x00050000
+	seccompRetLog         = 0x7ffc0000
+	seccompRetAllow       = 0x7fff0000
+	seccompSetModeFilter  = 1
+	seccompFilterFlagLog  = 2
+	seccompDataNrOffset   = 0
+	seccompDataArchOffset = 4
+	bpfLdWAbs             = syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS
+	bpfJeqK               = syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K
+	bpfJgeK               = syscall.BPF_JMP | syscall.BPF_JGE | syscall.BPF_K
+	bpfJsetK              = syscall.BPF_JMP | syscall.BPF_JSET | syscall.BPF_K
+	bpfRetK               = syscall.BPF_RET | syscall.BPF_K
+	defaultProfileRef     = "@default"
+	unconfinedProfileName = "unconfined"
+	seccompActionKill     = "kill"
+	seccompActionErrno    = "errno"
+	seccompActionLog      = "log"
+
+	// Jump offsets are 8 bits wide, and the clone checks sit between the
+	// denied syscalls and their shared return
+	maxDeniedSyscalls = 250
+
+	// seccompDataArg0Offset is the low word of the first syscall argument,
+	// on the little-endian architectures profiles are built for
+	seccompDataArg0Offset = 16
+
+	// cloneNamespaceFlags are the CLONE_NEW* flags of clone(2)
+	cloneNamespaceFlags = syscall.CLONE_NEWNS | syscall.CLONE_NEWCGROUP | syscall.CLONE_NEWUTS |
+		syscall.CLONE_NEWIPC | syscall.CLONE_NEWUSER | syscall.CLONE_NEWPID | syscall.CLONE_NEWNET
+)
+
+// defaultDeniedSyscalls are the calls no data step needs: tracing, mounts,
+// kernel modules and kexec, namespace changes, clock and key management,
+// and io_uring, whose operations bypass seccomp
+var defaultDeniedSyscalls = []string{
+	"ptrace", "process_vm_readv", "process_vm_writev", "kcmp",
+	"mount", "umount2", "pivot_root", "chroot", "mount_setattr",
+	"open_tree", "move_mount", "fsopen", "fsconfig", "fsmount", "fspick",
+	"kexec_load", "kexec_file_load", "reboot",
+	"init_module", "finit_module", "delete_module",
+	"swapon", "swapoff", "acct", "quotactl",
+	"unshare", "setns", "sethostname", "setdomainname",
+	"settimeofday", "clock_settime", "clock_adjtime", "adjtimex",
+	"keyctl", "add_key", "request_key",
+	"bpf", "perf_event_open", "userfaultfd",
+	"open_by_handle_at", "name_to_handle_at", "lookup_dcookie",
+	"syslog", "vhangup", "iopl", "ioperm",
+	"io_uring_setup", "io_uring_enter", "io_uring_register",
+}
+
+// SeccompProfile is a deny-list of syscalls for a slice
+type SeccompProfile struct {
+	// Name identifies the profile in logs and failure reports
+	Name string `json:"name"`
+
+	// Deny lists syscall names; "@default" expands to the default profile.
+	// Denying unshare also denies clone with CLONE_NEW* flags, and fails
+	// clone3, whose flags the filter cannot read, with ENOSYS so that libc
+	// falls back to clone.
+	Deny []string `json:"deny"`
+
+	// Action is "kill" (default) to kill the slice on a denied call,
+	// "errno" to fail the call with EPERM, or "log" to only log it while
+	// trying a profile out. Denials are logged to the kernel audit log.
+	Action string `json:"action,omitempty"`
+}
+
+// DefaultSeccompProfile returns the profile applied to data steps
+func DefaultSeccompProfile() *SeccompProfile {
+	return &SeccompProfile{
+		Name:   "default",
+		Deny:   []string{defaultProfileRef},
+		Action: seccompActionKill,
+	}
+}
+
+// LoadSeccompProfile resolves a herd's seccomp_profile reference:
+// "" or "default" is the default profile, "unconfined" disables filtering,
+// anything else is a path to a JSON profile
+func LoadSeccompProfile(ref string) (*SeccompProfile, error) {
+	switch ref {
+	case "", "default":
+		return DefaultSeccompProfile(), nil
+	case unconfinedProfileName:
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read seccomp profile: %v", err)
+	}
+	profile := &SeccompProfile{}
+	if err := json.Unmarshal(data, profile); err != nil {
+		return nil, fmt.Errorf("failed to parse seccomp profile %s: %v", ref, err)
+	}
+	if profile.Name == "" {
+		profile.Name = ref
+	}
+
+	// Validate eagerly so a bad profile fails at load, not inside the slice
+	if _, err := profile.program(); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// deniedSyscalls expands the profile's deny list to sorted syscall numbers
+func (p *SeccompProfile) deniedSyscalls() ([]uint32, error) {
+	seen := make(map[uint32]bool)
+	var numbers []uint32
+
+	var add func(names []string) error
+	add = func(names []string) error {
+		for _, name := range names {
+			if name == defaultProfileRef {
+				if err := add(defaultDeniedSyscalls); err != nil {
+					return err
+				}
+				continue
+			}
+			nr, ok := syscallNumbers[name]
+			if !ok {
+				// Arch-specific calls such as iopl do not exist everywhere
+				if containsString(defaultDeniedSyscalls, name) {
+					continue
+				}
+				return fmt.Errorf("unknown syscall %q in seccomp profile %s", name, p.Name)
+			}
+			if !seen[nr] {
+				seen[nr] = true
+				numbers = append(numbers, nr)
+			}
+		}
+		return nil
+	}
+
+	if err := add(p.Deny); err != nil {
+		return nil, err
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	return numbers, nil
+}
+
+// program compiles the profile to a classic BPF filter
+func (p *SeccompProfile) program() ([]syscall.SockFilter, error) {
+	if auditArch == 0 {
+		return nil, fmt.Errorf("seccomp profiles are not supported on this architecture")
+	}
+
+	denyAction := uint32(seccompRetKillProcess)
+	switch p.Action {
+	case "", seccompActionKill:
+	case seccompActionErrno:
+		denyAction = seccompRetErrno | uint32(syscall.EPERM)
+	case seccompActionLog:
+		denyAction = seccompRetLog
+	default:
+		return nil, fmt.Errorf("unknown seccomp action %q in profile %s", p.Action, p.Name)
+	}
+
+	denied, err := p.deniedSyscalls()
+	if err != nil {
+		return nil, err
+	}
+	if len(denied) > maxDeniedSyscalls {
+		return nil, fmt.Errorf("seccomp profile %s denies more than %d syscalls", p.Name, maxDeniedSyscalls)
+	}
+
+	stmt := func(code uint16, k uint32) syscall.SockFilter {
+		return syscall.SockFilter{Code: code, K: k}
+	}
+	jump := func(code uint16, k uint32, jt, jf uint8) syscall.SockFilter {
+		return syscall.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+	}
+
+	// Kill anything built for another ABI; its syscall numbers differ
+	filter := []syscall.SockFilter{
+		stmt(bpfLdWAbs, seccompDataArchOffset),
+		jump(bpfJeqK, auditArch, 1, 0),
+		stmt(bpfRetK, seccompRetKillProcess),
+		stmt(bpfLdWAbs, seccompDataNrOffset),
+	}
+	if x32SyscallBit != 0 {
+		filter = append(filter,
+			jump(bpfJgeK, x32SyscallBit, 0, 1),
+			stmt(bpfRetK, seccompRetKillProcess))
+	}
+
+	// The checks end in the allow, deny and ENOSYS returns; each match
+	// jumps forward to its return
+	checks := len(denied)
+	unshare, ok := syscallNumbers["unshare"]
+	restrictClone := ok && containsUint32(denied, unshare)
+	if restrictClone {
+		checks += 4
+	}
+	allowAt, denyAt, enosysAt := checks, checks+1, checks+2
+	to := func(pc, target int) uint8 {
+		return uint8(target - pc - 1)
+	}
+
+	var checksFilter []syscall.SockFilter
+	for i, nr := range denied {
+		checksFilter = append(checksFilter, jump(bpfJeqK, nr, to(i, denyAt), 0))
+	}
+	if restrictClone {
+		pc := len(denied)
+		checksFilter = append(checksFilter,
+			jump(bpfJeqK, syscallNumbers["clone3"], to(pc, enosysAt), 0),
+			jump(bpfJeqK, syscallNumbers["clone"], 0, to(pc+1, allowAt)),
+			stmt(bpfLdWAbs, seccompDataArg0Offset),
+			jump(bpfJsetK, cloneNamespaceFlags, to(pc+3, denyAt), to(pc+3, allowAt)))
+	}
+	filter = append(filter, checksFilter...)
+	filter = append(filter,
+		stmt(bpfRetK, seccompRetAllow),
+		stmt(bpfRetK, denyAction),
+		stmt(bpfRetK, seccompRetErrno|uint32(syscall.ENOSYS)))
+
+	return filter, nil
+}
+
+// installSeccomp applies the profile to the calling thread. The caller must
+// hold the OS thread and exec right after; the filter survives execve.
+// Denials, not only kills, go to the kernel audit log where the kernel
+// supports SECCOMP_FILTER_FLAG_LOG (4.14).
+func installSeccomp(p *SeccompProfile) error {
+	filter, err := p.program()
+	if err != nil {
+		return err
+	}
+
+	if err := setNoNewPrivs(); err != nil {
+		return err
+	}
+
+	prog := syscall.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+	_, _, errno := syscall.RawSyscall(uintptr(syscallNumbers["seccomp"]), seccompSetModeFilter, seccompFilterFlagLog, uintptr(unsafe.Pointer(&prog)))
+	if errno == syscall.EINVAL || errno == syscall.ENOSYS {
+		// Kernels without the flag or seccomp(2) install it unlogged
+		_, _, errno = syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_SET_SECCOMP, seccompModeFilter, uintptr(unsafe.Pointer(&prog)))
+	}
+	if errno != 0 {
+		return fmt.Errorf("failed to install seccomp profile %s: %v", p.Name, errno)
+	}
+	return nil
+}
+
+// setNoNewPrivs stops the thread and its exec'd children from gaining
+// privileges, which unprivileged seccomp and Landlock require
+func setNoNewPrivs() error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("failed to set no_new_privs: %v", errno)
+	}
+	return nil
+}
+
+func containsUint32(list []uint32, n uint32) bool {
+	for _, item := range list {
+		if item == n {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
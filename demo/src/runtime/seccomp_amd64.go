@@ -0,0 +1,8 @@
+// Package runtime provides isolation and resource control for RunInk node execution
+package runtime
+
+// auditArch is AUDIT_ARCH_X86_64
+const auditArch = 0xc000003e
+
+// x32SyscallBit marks x32 ABI syscalls, which would bypass a number-based filter
+const x32SyscallBit = 0x40000000
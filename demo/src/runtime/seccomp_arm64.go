@@ -0,0 +1,8 @@
+// Package runtime provides isolation and resource control for RunInk node execution
+package runtime
+
+// auditArch is AUDIT_ARCH_AARCH64
+const auditArch = 0xc00000b7
+
+// x32SyscallBit is unused on arm64, which has a single syscall ABI
+const x32SyscallBit = 0
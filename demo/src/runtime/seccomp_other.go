@@ -0,0 +1,11 @@
+//go:build !amd64 && !arm64
+
+// Package runtime provides isolation and resource control for RunInk node execution
+package runtime
+
+// auditArch is unset: seccomp profiles are only built for amd64 and arm64
+const auditArch = 0
+
+const x32SyscallBit = 0
+
+var syscallNumbers = map[string]uint32{}
@@ -0,0 +1,168 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// TestMain lets the test binary act as slice init for the Executor
+func TestMain(m *testing.M) {
+	SliceInit()
+	os.Exit(m.Run())
+}
+
+// TestLoadSeccompProfile tests profile references and validation
+func TestLoadSeccompProfile(t *testing.T) {
+	profile, err := LoadSeccompProfile("")
+	if err != nil || profile == nil || profile.Name != "default" {
+		t.Fatalf("Expected default profile, got %+v (%v)", profile, err)
+	}
+	if _, err := profile.program(); err != nil {
+		t.Fatalf("Failed to compile default profile: %v", err)
+	}
+
+	if profile, err := LoadSeccompProfile("unconfined"); err != nil || profile != nil {
+		t.Errorf("Expected unconfined to disable filtering, got %+v (%v)", profile, err)
+	}
+
+	dir := t.TempDir()
+	custom := filepath.Join(dir, "finance.json")
+	os.WriteFile(custom, []byte(`{"name": "finance-high", "deny": ["@default", "socket"]}`), 0644)
+	profile, err = LoadSeccompProfile(custom)
+	if err != nil {
+		t.Fatalf("Failed to load custom profile: %v", err)
+	}
+	denied, _ := profile.deniedSyscalls()
+	defaults, _ := DefaultSeccompProfile().deniedSyscalls()
+	if len(denied) != len(defaults)+1 {
+		t.Errorf("Expected default denies plus socket, got %d vs %d", len(denied), len(defaults))
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	os.WriteFile(invalid, []byte(`{"deny": ["not_a_syscall"]}`), 0644)
+	if _, err := LoadSeccompProfile(invalid); err == nil {
+		t.Error("Expected unknown syscall to be rejected")
+	}
+}
+
+// runFilter runs a seccomp filter over the seccomp_data of syscall nr
+// with first argument arg0 and returns its action
+func runFilter(t *testing.T, filter []syscall.SockFilter, nr, arg0 uint32) uint32 {
+	data := map[uint32]uint32{seccompDataNrOffset: nr, seccompDataArchOffset: auditArch, seccompDataArg0Offset: arg0}
+	var acc uint32
+	for pc := 0; pc < len(filter); pc++ {
+		ins := filter[pc]
+		switch ins.Code {
+		case bpfLdWAbs:
+			acc = data[ins.K]
+		case bpfJeqK, bpfJgeK, bpfJsetK:
+			taken := (ins.Code == bpfJeqK && acc == ins.K) || (ins.Code == bpfJgeK && acc >= ins.K) || (ins.Code == bpfJsetK && acc&ins.K != 0)
+			if taken {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case bpfRetK:
+			return ins.K
+		default:
+			t.Fatalf("Unexpected BPF instruction %#x at %d", ins.Code, pc)
+		}
+	}
+	t.Fatal("Filter ran off its end")
+	return 0
+}
+
+// TestSeccompProgram tests the filter's decisions, including the clone
+// flags, clone3 and the io_uring calls of the default profile
+func TestSeccompProgram(t *testing.T) {
+	if auditArch == 0 {
+		t.Skip("Seccomp profiles are not supported on this architecture")
+	}
+	filter, err := DefaultSeccompProfile().program()
+	if err != nil {
+		t.Fatalf("Failed to compile default profile: %v", err)
+	}
+	deny := uint32(seccompRetKillProcess)
+	enosys := uint32(seccompRetErrno | uint32(syscall.ENOSYS))
+	tests := []struct {
+		name   string
+		arg0   uint32
+		action uint32
+	}{
+		{"read", 0, seccompRetAllow},
+		{"unshare", syscall.CLONE_NEWUTS, deny},
+		{"io_uring_setup", 0, deny},
+		{"io_uring_enter", 0, deny},
+		{"clone", syscall.CLONE_VM | syscall.CLONE_FS | syscall.CLONE_THREAD, seccompRetAllow},
+		{"clone", syscall.CLONE_NEWUSER | uint32(syscall.SIGCHLD), deny},
+		{"clone", syscall.CLONE_NEWNET, deny},
+		{"clone3", 0, enosys},
+	}
+	for _, test := range tests {
+		if action := runFilter(t, filter, syscallNumbers[test.name], test.arg0); action != test.action {
+			t.Errorf("%s(%#x): expected action %#x, got %#x", test.name, test.arg0, test.action, action)
+		}
+	}
+
+	// clone is left alone by profiles that allow unshare
+	filter, err = (&SeccompProfile{Name: "soft", Deny: []string{"mount"}, Action: "log"}).program()
+	if err != nil {
+		t.Fatalf("Failed to compile profile: %v", err)
+	}
+	for name, action := range map[string]uint32{"clone": seccompRetAllow, "clone3": seccompRetAllow, "mount": seccompRetLog} {
+		if got := runFilter(t, filter, syscallNumbers[name], syscall.CLONE_NEWNS); got != action {
+			t.Errorf("%s: expected action %#x, got %#x", name, action, got)
+		}
+	}
+}
+
+// TestSeccompHelper is run inside the slice by TestExecutorSeccomp
+func TestSeccompHelper(t *testing.T) {
+	if os.Getenv("RUNINK_SECCOMP_HELPER") == "" {
+		t.Skip("Helper for TestExecutorSeccomp")
+	}
+	err := syscall.Unshare(syscall.CLONE_NEWUTS)
+	fmt.Println("unshare:", err)
+	os.Exit(0)
+}
+
+// TestExecutorSeccomp tests that denied syscalls kill the slice with a distinct reason
+func TestExecutorSeccomp(t *testing.T) {
+	// Skip this test if not running as root
+	if os.Geteuid() != 0 {
+		t.Skip("This test requires root privileges")
+	}
+
+	run := func(profile *SeccompProfile) ExecutorResult {
+		result, err := NewExecutor().
+			SetCommand([]string{os.Args[0], "-test.run=TestSeccompHelper"}).
+			SetChrootDir("/").
+			SetNamespaces(0).
+			SetEnv([]string{"RUNINK_SECCOMP_HELPER=1"}).
+			SetSeccomp(profile).
+			Execute()
+		if err != nil {
+			t.Fatalf("Failed to execute: %v", err)
+		}
+		return result
+	}
+
+	result := run(DefaultSeccompProfile())
+	if result.FailureReason != FailureSeccompDenied {
+		t.Errorf("Expected %s, got %q (exit %d): %s", FailureSeccompDenied, result.FailureReason, result.ExitCode, result.Stdout)
+	}
+
+	result = run(&SeccompProfile{Name: "soft", Deny: []string{"unshare"}, Action: "errno"})
+	if result.ExitCode != 0 || !strings.Contains(string(result.Stdout), "operation not permitted") {
+		t.Errorf("Expected unshare to fail with EPERM, got exit %d: %s", result.ExitCode, result.Stdout)
+	}
+
+	result = run(&SeccompProfile{Name: "permissive", Deny: []string{"kexec_load"}})
+	if result.ExitCode != 0 || !strings.Contains(string(result.Stdout), "unshare: <nil>") {
+		t.Errorf("Expected unshare to be allowed, got exit %d: %s%s", result.ExitCode, result.Stdout, result.Stderr)
+	}
+}
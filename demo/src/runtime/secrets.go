@@ -0,0 +1,581 @@
+// Package runtime provides isolation and resource control for RunInk node execution
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/runink/runink/parser"
+)
+
+// SecretScheme is the URI scheme of secret references, e.g.
+// secret://finance/warehouse_password
+const SecretScheme = "secret"
+
+// DefaultBarnEndpoint is the Barn API that secrets are read from
+const DefaultBarnEndpoint = "http://127.0.0.1:8080"
+
+// SecretsDir is where root agents mount the per-slice tmpfs holding the
+// secret files of slices that share the host's root: <dir>/<slice>-<random>
+var SecretsDir = "/run/runink/secrets"
+
+// SecretsMountPath is where slices in a prepared or custom root find their
+// secret files
+const SecretsMountPath = "/run/secrets"
+
+// SecretsDirEnv names the environment variable that holds the directory of
+// a slice's secret files
+const SecretsDirEnv = "RUNINK_SECRETS_DIR"
+
+// tmpfsMagic is the f_type statfs reports for tmpfs
+const tmpfsMagic = 0x01021994
+
+// secretsTmpfsSize caps the tmpfs holding a slice's secret files
+const secretsTmpfsSize = "1m"
+
+var (
+	// ErrCrossHerdSecret is returned for a reference to another herd's
+	// secret when either herd's secrets scope forbids it
+	ErrCrossHerdSecret = errors.New("cross-herd secret references are not allowed")
+
+	// ErrSecretNotDelivered is returned by LookupSecret for a secret that
+	// was not delivered to the running slice
+	ErrSecretNotDelivered = errors.New("secret not delivered to this slice")
+)
+
+var (
+	secretHerdRE = regexp.MustCompile(`^[a-z0-9]([-a-z0-9_.]{0,61}[a-z0-9])?$`)
+	secretNameRE = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]{0,251}[A-Za-z0-9])?$`)
+)
+
+// SecretRef refers to the latest version of a secret stored in Barn
+type SecretRef struct {
+	Herd string
+	Name string
+}
+
+// ParseSecretRef parses a reference of the form secret://<herd>/<name>
+func ParseSecretRef(s string) (SecretRef, error) {
+	rest := strings.TrimPrefix(s, SecretScheme+"://")
+	if rest == s {
+		// Not a reference, so possibly a plain credential: keep it out of the error
+		return SecretRef{}, fmt.Errorf("invalid secret reference: expected %s://<herd>/<name>", SecretScheme)
+	}
+	herd, name, ok := strings.Cut(rest, "/")
+	if !ok || !secretHerdRE.MatchString(herd) || !secretNameRE.MatchString(name) {
+		return SecretRef{}, fmt.Errorf("invalid secret reference %q: expected %s://<herd>/<name>", s, SecretScheme)
+	}
+	return SecretRef{Herd: herd, Name: name}, nil
+}
+
+// IsSecretRef reports whether s is written as a secret reference. It may
+// still fail to parse.
+func IsSecretRef(s string) bool {
+	return strings.HasPrefix(s, SecretScheme+"://")
+}
+
+// String returns the reference as a secret:// URI
+func (r SecretRef) String() string {
+	return SecretScheme + "://" + r.Herd + "/" + r.Name
+}
+
+// EnvName returns the default environment variable a slice finds the
+// secret in, e.g. RUNINK_SECRET_FINANCE_WAREHOUSE_PASSWORD
+func (r SecretRef) EnvName() string {
+	name := strings.ToUpper("RUNINK_SECRET_" + r.Herd + "_" + r.Name)
+	return strings.Map(func(c rune) rune {
+		if (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' {
+			return c
+		}
+		return '_'
+	}, name)
+}
+
+// SecretScope is a herd's secrets policy as it applies to its slices
+type SecretScope struct {
+	// Herd is the herd the scope belongs to
+	Herd string
+
+	// AllowCrossHerd permits references between this herd and others
+	AllowCrossHerd bool
+
+	// Lifetime bounds how long delivered secrets stay available to a
+	// slice. Zero means no limit.
+	Lifetime time.Duration
+}
+
+// HerdSecretScope returns the scope of a herd file's [herd.secrets_scope]
+func HerdSecretScope(herd string, scope parser.SecretsScope) SecretScope {
+	return SecretScope{
+		Herd:           herd,
+		AllowCrossHerd: scope.AllowCrossHerd,
+		Lifetime:       time.Duration(scope.TokenLifetimeSeconds) * time.Second,
+	}
+}
+
+// SecretSource reads secrets and the secrets scopes of their herds
+type SecretSource interface {
+	// Secret returns the value of the latest version of a secret
+	Secret(ctx context.Context, ref SecretRef) ([]byte, error)
+
+	// SecretScope returns the secrets scope of a herd
+	SecretScope(ctx context.Context, herd string) (SecretScope, error)
+}
+
+// BarnSecrets reads secrets from the Barn REST API. Reads are consistent,
+// so a secret written just before a slice launches is delivered to it.
+type BarnSecrets struct {
+	// Endpoint is the Barn API. Default: DefaultBarnEndpoint
+	Endpoint string
+
+	// Client sends the requests. Default: http.DefaultClient
+	Client *http.Client
+}
+
+// Secret implements SecretSource
+func (b *BarnSecrets) Secret(ctx context.Context, ref SecretRef) ([]byte, error) {
+	var secret struct {
+		Value []byte `json:"value"`
+	}
+	path := "/herds/" + url.PathEscape(ref.Herd) + "/secrets/" + url.PathEscape(ref.Name)
+	if err := b.get(ctx, path, &secret); err != nil {
+		return nil, fmt.Errorf("failed to read secret %s: %w", ref, err)
+	}
+	return secret.Value, nil
+}
+
+// SecretScope implements SecretSource
+func (b *BarnSecrets) SecretScope(ctx context.Context, herd string) (SecretScope, error) {
+	var resource struct {
+		Secrets struct {
+			AllowCrossHerd       bool `json:"allowCrossHerd"`
+			TokenLifetimeSeconds int  `json:"tokenLifetimeSeconds"`
+		} `json:"secretsScope"`
+	}
+	if err := b.get(ctx, "/herds/"+url.PathEscape(herd), &resource); err != nil {
+		return SecretScope{}, fmt.Errorf("failed to read herd %s: %w", herd, err)
+	}
+	return SecretScope{
+		Herd:           herd,
+		AllowCrossHerd: resource.Secrets.AllowCrossHerd,
+		Lifetime:       time.Duration(resource.Secrets.TokenLifetimeSeconds) * time.Second,
+	}, nil
+}
+
+func (b *BarnSecrets) get(ctx context.Context, path string, v any) error {
+	endpoint := b.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultBarnEndpoint
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(endpoint, "/")+"/api/v1"+path+"?consistent=true", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach barn at %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&failure); err != nil || failure.Error == "" {
+			return fmt.Errorf("barn: %s", resp.Status)
+		}
+		return fmt.Errorf("barn: %s", failure.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// SecretDelivery selects how secrets reach a slice
+type SecretDelivery string
+
+const (
+	// SecretFiles writes each secret to a file on a tmpfs private to the
+	// slice and sets <ENV>_FILE to its path
+	SecretFiles SecretDelivery = "file"
+
+	// SecretEnv sets <ENV> to the secret's value
+	SecretEnv SecretDelivery = "env"
+)
+
+// ParseSecretDelivery parses "file" or "env"; empty means SecretFiles
+func ParseSecretDelivery(s string) (SecretDelivery, error) {
+	switch SecretDelivery(s) {
+	case "", SecretFiles:
+		return SecretFiles, nil
+	case SecretEnv:
+		return SecretEnv, nil
+	}
+	return "", fmt.Errorf("invalid secret delivery %q: expected file or env", s)
+}
+
+// SecretMount binds a secret to the environment variable a slice finds it in
+type SecretMount struct {
+	Ref SecretRef
+
+	// Env names the variable. Default: Ref.EnvName()
+	Env string
+}
+
+// ParseSecretMount parses "<ENV>=secret://<herd>/<name>", or a bare
+// reference that uses the default variable
+func ParseSecretMount(spec string) (SecretMount, error) {
+	spec = strings.TrimSpace(spec)
+	env, value, ok := strings.Cut(spec, "=")
+	if !ok {
+		env, value = "", spec
+	}
+	ref, err := ParseSecretRef(value)
+	if err != nil {
+		return SecretMount{}, err
+	}
+	if env != "" && !validEnvName(env) {
+		return SecretMount{}, fmt.Errorf("invalid environment variable %q for secret %s", env, ref)
+	}
+	return SecretMount{Ref: ref, Env: env}, nil
+}
+
+// EnvName returns the variable the secret is delivered in
+func (m SecretMount) EnvName() string {
+	if m.Env != "" {
+		return m.Env
+	}
+	return m.Ref.EnvName()
+}
+
+func validEnvName(name string) bool {
+	for i, c := range name {
+		if !(c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (i > 0 && c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// SecretSpec lists the secrets a slice receives and how
+type SecretSpec struct {
+	Mounts []SecretMount
+
+	// Delivery defaults to SecretFiles
+	Delivery SecretDelivery
+
+	// Scope is the secrets scope of the slice's herd
+	Scope SecretScope
+
+	// Source reads the secrets at launch. Default: BarnSecrets at
+	// DefaultBarnEndpoint
+	Source SecretSource
+}
+
+// ResolveSecrets reads the secrets of spec after checking every reference
+// against the secrets scopes. A reference to another herd's secret needs
+// both herds to allow cross-herd references. It returns the values by
+// reference and how long they may stay available to the slice, the
+// shortest lifetime of the scopes involved.
+func ResolveSecrets(ctx context.Context, spec SecretSpec) (map[SecretRef][]byte, time.Duration, error) {
+	source := spec.Source
+	if source == nil {
+		source = &BarnSecrets{}
+	}
+	lifetime := spec.Scope.Lifetime
+	values := make(map[SecretRef][]byte, len(spec.Mounts))
+	owners := make(map[string]SecretScope)
+	for _, mount := range spec.Mounts {
+		ref := mount.Ref
+		if _, ok := values[ref]; ok {
+			continue
+		}
+		if ref.Herd != spec.Scope.Herd {
+			if !spec.Scope.AllowCrossHerd {
+				return nil, 0, fmt.Errorf("secret %s in a slice of herd %q: %w", ref, spec.Scope.Herd, ErrCrossHerdSecret)
+			}
+			owner, ok := owners[ref.Herd]
+			if !ok {
+				var err error
+				if owner, err = source.SecretScope(ctx, ref.Herd); err != nil {
+					return nil, 0, err
+				}
+				owners[ref.Herd] = owner
+			}
+			if !owner.AllowCrossHerd {
+				return nil, 0, fmt.Errorf("secret %s: herd %s does not share its secrets: %w", ref, ref.Herd, ErrCrossHerdSecret)
+			}
+			if owner.Lifetime > 0 && (lifetime == 0 || owner.Lifetime < lifetime) {
+				lifetime = owner.Lifetime
+			}
+		}
+		value, err := source.Secret(ctx, ref)
+		if err != nil {
+			wipeSecrets(values)
+			return nil, 0, err
+		}
+		values[ref] = value
+	}
+	return values, lifetime, nil
+}
+
+// LookupSecret returns a secret delivered to the running slice under the
+// reference's default variable, from the file named by <ENV>_FILE or from
+// <ENV> itself
+func LookupSecret(ref SecretRef) ([]byte, error) {
+	env := ref.EnvName()
+	if path := os.Getenv(env + "_FILE"); path != "" {
+		value, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("secret %s expired or was withdrawn: %w", ref, ErrSecretNotDelivered)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secret %s: %w", ref, err)
+		}
+		return value, nil
+	}
+	if value, ok := os.LookupEnv(env); ok {
+		return []byte(value), nil
+	}
+	return nil, fmt.Errorf("secret %s: %w", ref, ErrSecretNotDelivered)
+}
+
+// secretDelivery holds the secrets delivered to a running slice until it
+// exits or their lifetime ends
+type secretDelivery struct {
+	// env holds the variables to add to the slice's environment
+	env []string
+
+	// dir is the tmpfs directory on the host, sliceDir the same directory
+	// as the slice sees it; both empty for env delivery
+	dir      string
+	sliceDir string
+	created  bool
+	mounted  bool
+
+	expiry *time.Timer
+	once   sync.Once
+}
+
+// deliverSecrets resolves the executor's secrets and hands them to the
+// slice. Files go to a tmpfs inside the slice's root; slices sharing the
+// host's root get a private tmpfs under SecretsDir. Unprivileged agents
+// cannot mount, so the directory must already be on a tmpfs, such as
+// /dev/shm. Values never touch a disk-backed filesystem or the logs.
+func (e *Executor) deliverSecrets(chrootDir string, attr *syscall.SysProcAttr) (*secretDelivery, error) {
+	spec := *e.Config.Secrets
+	ctx := e.Config.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	values, lifetime, err := ResolveSecrets(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+	defer wipeSecrets(values)
+
+	delivery := &secretDelivery{}
+	if spec.Delivery == SecretEnv {
+		// Variables cannot be withdrawn, so the slice must end in time
+		if lifetime > 0 && (e.Config.Timeout <= 0 || e.Config.Timeout > lifetime) {
+			return nil, fmt.Errorf("secrets delivered as environment variables need a timeout within their %v lifetime; use file delivery instead", lifetime)
+		}
+		for _, mount := range spec.Mounts {
+			delivery.env = append(delivery.env, mount.EnvName()+"="+string(values[mount.Ref]))
+		}
+		return delivery, nil
+	}
+
+	owner := secretOwnerOf(attr)
+	if err := delivery.mount(chrootDir, filepath.Base(e.Config.CgroupName), owner); err != nil {
+		return nil, err
+	}
+	for ref, value := range values {
+		if err := delivery.write(ref, value, owner); err != nil {
+			delivery.Close()
+			return nil, err
+		}
+	}
+	delivery.env = append(delivery.env, SecretsDirEnv+"="+delivery.sliceDir)
+	for _, mount := range spec.Mounts {
+		delivery.env = append(delivery.env, mount.EnvName()+"_FILE="+filepath.Join(delivery.sliceDir, mount.Ref.Herd, mount.Ref.Name))
+	}
+	if lifetime > 0 {
+		delivery.expiry = time.AfterFunc(lifetime, delivery.withdraw)
+	}
+	return delivery, nil
+}
+
+// secretOwner is the host uid and gid that root in the slice's user
+// namespace maps to
+type secretOwner struct {
+	uid, gid int
+}
+
+// secretOwnerOf returns the owner for the secret files of a slice in its
+// own user namespace, so that it can read them. Files of other slices
+// stay owned by the agent.
+func secretOwnerOf(attr *syscall.SysProcAttr) *secretOwner {
+	if os.Geteuid() != 0 || attr == nil {
+		return nil
+	}
+	owner := &secretOwner{uid: -1, gid: -1}
+	for _, m := range attr.UidMappings {
+		if m.ContainerID == 0 {
+			owner.uid = m.HostID
+		}
+	}
+	for _, m := range attr.GidMappings {
+		if m.ContainerID == 0 {
+			owner.gid = m.HostID
+		}
+	}
+	if owner.uid < 0 {
+		return nil
+	}
+	if owner.gid < 0 {
+		owner.gid = owner.uid
+	}
+	return owner
+}
+
+// mount creates the tmpfs directory for the slice's secret files
+func (d *secretDelivery) mount(chrootDir, slice string, owner *secretOwner) error {
+	root := os.Geteuid() == 0
+	if chrootDir != "/" {
+		d.sliceDir = SecretsMountPath
+		d.dir = filepath.Join(chrootDir, SecretsMountPath)
+		if _, err := os.Lstat(d.dir); err == nil {
+			// Mount over a directory the root brings along, but never
+			// write into one without a fresh tmpfs
+			if !root {
+				return fmt.Errorf("secrets directory %s already exists", d.dir)
+			}
+		} else if err := os.MkdirAll(d.dir, 0700); err != nil {
+			return fmt.Errorf("failed to create secrets directory: %v", err)
+		} else {
+			d.created = true
+		}
+	} else {
+		base := SecretsDir
+		if !root {
+			base = filepath.Join("/dev/shm", fmt.Sprintf("runink-secrets-%d", os.Getuid()))
+		}
+		if err := os.MkdirAll(base, 0700); err != nil {
+			return fmt.Errorf("failed to create secrets directory: %v", err)
+		}
+		dir, err := os.MkdirTemp(base, slice+"-")
+		if err != nil {
+			return fmt.Errorf("failed to create secrets directory: %v", err)
+		}
+		d.dir, d.sliceDir, d.created = dir, dir, true
+	}
+
+	if root {
+		options := "mode=0700,size=" + secretsTmpfsSize
+		if owner != nil {
+			options += fmt.Sprintf(",uid=%d,gid=%d", owner.uid, owner.gid)
+		}
+		if err := syscall.Mount("tmpfs", d.dir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, options); err != nil {
+			d.Close()
+			return fmt.Errorf("failed to mount secrets tmpfs at %s: %v", d.dir, err)
+		}
+		d.mounted = true
+		return nil
+	}
+
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(d.dir, &fs); err != nil || int64(fs.Type) != tmpfsMagic {
+		d.Close()
+		return fmt.Errorf("secret files need a tmpfs at %s: run the agent as root or deliver secrets as environment variables", d.dir)
+	}
+	return nil
+}
+
+// write stores a secret as <dir>/<herd>/<name>, readable only by its owner
+func (d *secretDelivery) write(ref SecretRef, value []byte, owner *secretOwner) error {
+	herdDir := filepath.Join(d.dir, ref.Herd)
+	path := filepath.Join(herdDir, ref.Name)
+	if err := os.MkdirAll(herdDir, 0700); err != nil {
+		return fmt.Errorf("failed to write secret %s: %v", ref, err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0400)
+	if err != nil {
+		return fmt.Errorf("failed to write secret %s: %v", ref, err)
+	}
+	_, err = file.Write(value)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && owner != nil {
+		if err = os.Lchown(herdDir, owner.uid, owner.gid); err == nil {
+			err = os.Lchown(path, owner.uid, owner.gid)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write secret %s: %v", ref, err)
+	}
+	return nil
+}
+
+// withdraw overwrites and removes the secret files, ending their lifetime.
+// A slice that already read a secret keeps its copy.
+func (d *secretDelivery) withdraw() {
+	d.once.Do(func() {
+		if d.dir == "" {
+			return
+		}
+		filepath.Walk(d.dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() {
+				return nil
+			}
+			if file, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+				file.Write(make([]byte, info.Size()))
+				file.Close()
+			}
+			return os.Remove(path)
+		})
+	})
+}
+
+// Close withdraws the secrets and removes their tmpfs
+func (d *secretDelivery) Close() {
+	if d.expiry != nil {
+		d.expiry.Stop()
+	}
+	d.withdraw()
+	if d.mounted {
+		if err := syscall.Unmount(d.dir, syscall.MNT_DETACH); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to unmount secrets tmpfs %s: %v\n", d.dir, err)
+		}
+	}
+	if d.created {
+		os.RemoveAll(d.dir)
+	}
+}
+
+// wipeSecrets overwrites the resolved values once they were delivered
+func wipeSecrets(values map[SecretRef][]byte) {
+	for _, value := range values {
+		for i := range value {
+			value[i] = 0
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSecrets is a SecretSource backed by maps
+type fakeSecrets struct {
+	values map[SecretRef]string
+	scopes map[string]SecretScope
+}
+
+func (f *fakeSecrets) Secret(ctx context.Context, ref SecretRef) ([]byte, error) {
+	value, ok := f.values[ref]
+	if !ok {
+		return nil, fmt.Errorf("secret %s not found", ref)
+	}
+	return []byte(value), nil
+}
+
+func (f *fakeSecrets) SecretScope(ctx context.Context, herd string) (SecretScope, error) {
+	return f.scopes[herd], nil
+}
+
+// TestParseSecretRef tests secret references and their default variables
+func TestParseSecretRef(t *testing.T) {
+	ref, err := ParseSecretRef("secret://finance/warehouse_password")
+	if err != nil {
+		t.Fatalf("Failed to parse reference: %v", err)
+	}
+	if ref != (SecretRef{Herd: "finance", Name: "warehouse_password"}) {
+		t.Errorf("Unexpected reference %+v", ref)
+	}
+	if ref.EnvName() != "RUNINK_SECRET_FINANCE_WAREHOUSE_PASSWORD" {
+		t.Errorf("Unexpected variable %s", ref.EnvName())
+	}
+	if ref.String() != "secret://finance/warehouse_password" {
+		t.Errorf("Unexpected string %s", ref)
+	}
+
+	for _, invalid := range []string{
+		"finance/warehouse_password",
+		"secret://finance",
+		"secret://finance/",
+		"secret://Finance/key",
+		"secret://finance/a/b",
+	} {
+		if _, err := ParseSecretRef(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+
+	mount, err := ParseSecretMount("DB_PASSWORD=secret://finance/warehouse_password")
+	if err != nil {
+		t.Fatalf("Failed to parse mount: %v", err)
+	}
+	if mount.EnvName() != "DB_PASSWORD" || mount.Ref != ref {
+		t.Errorf("Unexpected mount %+v", mount)
+	}
+	if _, err := ParseSecretMount("1DB=secret://finance/warehouse_password"); err == nil {
+		t.Error("Expected an invalid variable name to be rejected")
+	}
+}
+
+// TestResolveSecretsScope tests that cross-herd references need both
+// herds' consent and take the shorter lifetime
+func TestResolveSecretsScope(t *testing.T) {
+	source := &fakeSecrets{
+		values: map[SecretRef]string{
+			{Herd: "finance", Name: "warehouse_password"}: "hunter2",
+			{Herd: "shared", Name: "api_key"}:             "k3y",
+			{Herd: "private", Name: "api_key"}:            "k3y",
+		},
+		scopes: map[string]SecretScope{
+			"shared":  {Herd: "shared", AllowCrossHerd: true, Lifetime: time.Minute},
+			"private": {Herd: "private"},
+		},
+	}
+	mount := func(s string) SecretMount {
+		m, err := ParseSecretMount(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	closed := SecretScope{Herd: "finance", Lifetime: time.Hour}
+	values, lifetime, err := ResolveSecrets(context.Background(), SecretSpec{
+		Mounts: []SecretMount{mount("secret://finance/warehouse_password")},
+		Scope:  closed,
+		Source: source,
+	})
+	if err != nil {
+		t.Fatalf("Failed to resolve own secret: %v", err)
+	}
+	if string(values[SecretRef{Herd: "finance", Name: "warehouse_password"}]) != "hunter2" || lifetime != time.Hour {
+		t.Errorf("Unexpected resolution %q, %v", values, lifetime)
+	}
+
+	_, _, err = ResolveSecrets(context.Background(), SecretSpec{
+		Mounts: []SecretMount{mount("secret://shared/api_key")},
+		Scope:  closed,
+		Source: source,
+	})
+	if !errors.Is(err, ErrCrossHerdSecret) {
+		t.Errorf("Expected ErrCrossHerdSecret for a closed herd, got %v", err)
+	}
+
+	open := SecretScope{Herd: "finance", AllowCrossHerd: true, Lifetime: time.Hour}
+	_, _, err = ResolveSecrets(context.Background(), SecretSpec{
+		Mounts: []SecretMount{mount("secret://private/api_key")},
+		Scope:  open,
+		Source: source,
+	})
+	if !errors.Is(err, ErrCrossHerdSecret) {
+		t.Errorf("Expected ErrCrossHerdSecret for a herd that does not share, got %v", err)
+	}
+
+	_, lifetime, err = ResolveSecrets(context.Background(), SecretSpec{
+		Mounts: []SecretMount{mount("secret://shared/api_key")},
+		Scope:  open,
+		Source: source,
+	})
+	if err != nil || lifetime != time.Minute {
+		t.Errorf("Expected the shared secret with the owner's lifetime, got %v, %v", lifetime, err)
+	}
+}
+
+// TestBarnSecrets tests reading secrets and scopes from the Barn API
+func TestBarnSecrets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("consistent") != "true" {
+			t.Errorf("Expected a consistent read of %s", r.URL)
+		}
+		switch r.URL.Path {
+		case "/api/v1/herds/finance/secrets/warehouse_password":
+			fmt.Fprint(w, `{"herd":"finance","name":"warehouse_password","version":2,"value":"aHVudGVyMg=="}`)
+		case "/api/v1/herds/finance":
+			fmt.Fprint(w, `{"name":"finance","secretsScope":{"allowCrossHerd":true,"tokenLifetimeSeconds":3600}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"not found","code":"NotFound"}`)
+		}
+	}))
+	defer server.Close()
+
+	barn := &BarnSecrets{Endpoint: server.URL}
+	value, err := barn.Secret(context.Background(), SecretRef{Herd: "finance", Name: "warehouse_password"})
+	if err != nil || string(value) != "hunter2" {
+		t.Errorf("Expected hunter2, got %q, %v", value, err)
+	}
+	scope, err := barn.SecretScope(context.Background(), "finance")
+	if err != nil || !scope.AllowCrossHerd || scope.Lifetime != time.Hour {
+		t.Errorf("Unexpected scope %+v, %v", scope, err)
+	}
+	if _, err := barn.Secret(context.Background(), SecretRef{Herd: "finance", Name: "missing"}); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected not found, got %v", err)
+	}
+}
+
+// TestExecutorSecrets tests file and environment delivery, and that secret
+// files are withdrawn when their lifetime ends and when the slice exits
+func TestExecutorSecrets(t *testing.T) {
+	// Skip this test if not running as root
+	if os.Geteuid() != 0 {
+		t.Skip("This test requires root privileges")
+	}
+
+	SecretsDir = t.TempDir()
+	source := &fakeSecrets{values: map[SecretRef]string{{Herd: "finance", Name: "warehouse_password"}: "hunter2"}}
+	spec := func(delivery SecretDelivery, lifetime time.Duration) *SecretSpec {
+		mount, _ := ParseSecretMount("DB_PASSWORD=secret://finance/warehouse_password")
+		return &SecretSpec{
+			Mounts:   []SecretMount{mount},
+			Delivery: delivery,
+			Scope:    SecretScope{Herd: "finance", Lifetime: lifetime},
+			Source:   source,
+		}
+	}
+	run := func(script string, secrets *SecretSpec, timeout time.Duration) (string, error) {
+		result, err := NewExecutor().
+			SetCommand([]string{"/bin/sh", "-c", script}).
+			SetChrootDir("/").
+			SetNamespaces(0).
+			SetTimeout(timeout).
+			SetSecrets(secrets).
+			Execute()
+		if err == nil && result.Error != nil {
+			err = fmt.Errorf("%v: %s", result.Error, result.Stderr)
+		}
+		return string(result.Stdout), err
+	}
+
+	out, err := run(`cat "$DB_PASSWORD_FILE"; echo " $RUNINK_SECRETS_DIR"`, spec(SecretFiles, 0), 0)
+	if err != nil {
+		t.Fatalf("Failed to run slice with secret files: %v", err)
+	}
+	value, dir, _ := strings.Cut(strings.TrimSpace(out), " ")
+	if value != "hunter2" {
+		t.Errorf("Expected the secret file to hold hunter2, got %q", value)
+	}
+	if !strings.HasPrefix(dir, SecretsDir) {
+		t.Errorf("Expected the secrets under %s, got %s", SecretsDir, dir)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed after the slice exited, got %v", dir, err)
+	}
+
+	out, err = run(`sleep 0.5; cat "$DB_PASSWORD_FILE" 2>/dev/null || echo expired`, spec(SecretFiles, 100*time.Millisecond), 0)
+	if err != nil {
+		t.Fatalf("Failed to run slice with expiring secrets: %v", err)
+	}
+	if strings.TrimSpace(out) != "expired" {
+		t.Errorf("Expected the secret file to be withdrawn after its lifetime, got %q", out)
+	}
+
+	out, err = run(`echo "$DB_PASSWORD"`, spec(SecretEnv, time.Minute), 10*time.Second)
+	if err != nil || strings.TrimSpace(out) != "hunter2" {
+		t.Errorf("Expected the secret in the environment, got %q, %v", out, err)
+	}
+	if _, err := run("true", spec(SecretEnv, time.Minute), 0); err == nil {
+		t.Error("Expected environment delivery without a timeout within the lifetime to be refused")
+	}
+}
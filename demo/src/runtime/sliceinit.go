@@ -0,0 +1,133 @@
+// Package runtime provides isolation and resource control for RunInk node execution
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	goruntime "runtime"
+	"syscall"
+)
+
+// sliceInitArg is the argv[0] that makes an agent binary act as slice init
+const sliceInitArg = "runink-slice-init"
+
+// sliceInitFd is the inherited pipe carrying the slice init spec (ExtraFiles[0])
+const sliceInitFd = 3
+
+// sliceInitSpec tells slice init how to confine itself before exec.
+// Restrictions that only apply to the calling thread and survive execve,
+// such as seccomp filters, are installed here rather than in the agent.
+type sliceInitSpec struct {
+	Chroot   string           `json:"chroot"`
+	WorkDir  string           `json:"work_dir"`
+	Seccomp  *SeccompProfile  `json:"seccomp,omitempty"`
+	Landlock *LandlockRuleset `json:"landlock,omitempty"`
+	Command  []string         `json:"command"`
+}
+
+// SliceInit turns the process into slice init when the Executor started it
+// as one, and never returns in that case. Agent binaries that run slices
+// with seccomp profiles or Landlock rulesets must call it first thing in main.
+func SliceInit() {
+	if len(os.Args) == 0 || os.Args[0] != sliceInitArg {
+		return
+	}
+	if err := runSliceInit(); err != nil {
+		fmt.Fprintf(os.Stderr, "slice init: %v\n", err)
+		os.Exit(127)
+	}
+}
+
+// runSliceInit confines the process and execs the slice command
+func runSliceInit() error {
+	// Filters are per thread; keep the thread that will exec
+	goruntime.LockOSThread()
+
+	f := os.NewFile(sliceInitFd, "slice-init-spec")
+	spec := sliceInitSpec{}
+	err := json.NewDecoder(f).Decode(&spec)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read spec: %v", err)
+	}
+	if len(spec.Command) == 0 {
+		return fmt.Errorf("no command specified")
+	}
+
+	if spec.Chroot != "" {
+		if err := ApplyChroot(spec.Chroot); err != nil {
+			return err
+		}
+	}
+	workDir := spec.WorkDir
+	if workDir == "" {
+		workDir = "/"
+	}
+	if err := os.Chdir(workDir); err != nil {
+		return fmt.Errorf("failed to chdir to %s: %v", workDir, err)
+	}
+
+	path, err := exec.LookPath(spec.Command[0])
+	if err != nil {
+		return err
+	}
+
+	// Landlock paths resolve inside the chroot, so apply it after the chroot
+	if spec.Landlock != nil {
+		applied, err := applyLandlock(spec.Landlock, path)
+		if err != nil {
+			return err
+		}
+		if !applied {
+			fmt.Fprintln(os.Stderr, "slice init: Landlock is not supported by the kernel, filesystem access is not restricted")
+		}
+	}
+
+	if spec.Seccomp != nil {
+		if err := installSeccomp(spec.Seccomp); err != nil {
+			return err
+		}
+	}
+
+	return syscall.Exec(path, spec.Command, os.Environ())
+}
+
+// wrapSliceInit reroutes cmd through slice init, which chroots and applies
+// the Landlock ruleset and seccomp profile itself. The returned function must be called once
+// the command has started.
+func (e *Executor) wrapSliceInit(cmd *exec.Cmd, chrootDir string) (func(), error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate agent binary for slice init: %v", err)
+	}
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create slice init pipe: %v", err)
+	}
+
+	// The spec is small enough to fit in the pipe buffer before the child reads it
+	spec := sliceInitSpec{
+		Chroot:   chrootDir,
+		WorkDir:  cmd.Dir,
+		Seccomp:  e.Config.Seccomp,
+		Landlock: e.landlockRuleset(),
+		Command:  e.Config.Command,
+	}
+	err = json.NewEncoder(writer).Encode(spec)
+	writer.Close()
+	if err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("failed to write slice init spec: %v", err)
+	}
+
+	cmd.Path = self
+	cmd.Args = []string{sliceInitArg}
+	cmd.Dir = "/"
+	cmd.ExtraFiles = append([]*os.File{reader}, cmd.ExtraFiles...)
+	cmd.SysProcAttr.Chroot = ""
+
+	return func() { reader.Close() }, nil
+}
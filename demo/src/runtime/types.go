@@ -2,16 +2,36 @@
 // Package runtime provides isolation and resource control for RunInk node execution
 package runtime
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"time"
+)
+
 // Limits defines resource limits for a node execution
 type Limits struct {
-	// CPUQuota defines the CPU quota in the format "period quota"
-	// e.g., "100000 50000" means 50% of CPU time
+	// CPUQuota defines the CPU limit as a quantity, e.g. "500m" for half a
+	// CPU or "4" for four CPUs. Raw cpu.max values ("50000 100000") are
+	// written as-is.
 	CPUQuota string
 
-	// MemoryMax defines the maximum memory limit in bytes
-	// e.g., "100M" for 100 megabytes
+	// CPUMin defines the CPU share guaranteed under contention as a
+	// quantity, e.g. "500m". It sets cpu.weight.
+	CPUMin string
+
+	// MemoryMax defines the maximum memory limit as a quantity
+	// e.g., "512Mi" for 512 mebibytes or "1G" for 10^9 bytes
 	MemoryMax string
 
+	// MemoryMin defines memory protected from reclaim, e.g. "1Gi"
+	MemoryMin string
+
+	// PidsMax caps the number of processes and threads, e.g. "512"
+	PidsMax string
+
 	// DiskQuota defines the maximum disk space in bytes
 	// e.g., "1G" for 1 gigabyte
 	DiskQuota string
@@ -19,6 +39,10 @@ type Limits struct {
 	// IOWeight defines the I/O weight for the cgroup
 	// Valid values are 10-1000
 	IOWeight string
+
+	// IOMax limits bandwidth and IOPS per device,
+	// e.g., "8:0 rbps=100Mi wiops=1000"; separate devices with ";"
+	IOMax string
 }
 
 // ExecutorConfig holds configuration for the isolated execution environment
@@ -32,6 +56,10 @@ type ExecutorConfig struct {
 	// Root directory for chroot
 	ChrootDir string
 
+	// Rootfs describes the layered root filesystem mounted when ChrootDir is
+	// empty. Default: DefaultHostPaths plus the command binary
+	Rootfs *RootfsSpec
+
 	// Working directory inside the chroot
 	WorkDir string
 
@@ -45,6 +73,58 @@ type ExecutorConfig struct {
 	// CgroupName is the name of the cgroup to create
 	// Default: "runink-<pid>"
 	CgroupName string
+
+	// Herd nests the slice's cgroup under the herd's cgroup, see
+	// SliceCgroupName. HerdQuota is applied if the herd cgroup is missing.
+	Herd      string
+	HerdQuota Limits
+
+	// UidMappings and GidMappings map ids inside a user namespace to host ids.
+	// Only used when Namespaces includes CLONE_NEWUSER.
+	// Default: an ephemeral uid per slice, or the agent's own uid when unprivileged
+	UidMappings []syscall.SysProcIDMap
+	GidMappings []syscall.SysProcIDMap
+
+	// Egress lists the endpoints a slice in its own network namespace may reach.
+	// Without rules the namespace only has loopback.
+	Egress []EgressRule
+
+	// Seccomp is the syscall profile installed before the command runs.
+	// Requires the agent binary to call SliceInit first thing in main.
+	Seccomp *SeccompProfile
+
+	// Landlock limits the command's filesystem access to the ruleset's
+	// paths on kernels with Landlock. Requires SliceInit like Seccomp.
+	Landlock *LandlockRuleset
+
+	// Timeout kills the command if it runs longer. Zero means no timeout.
+	Timeout time.Duration
+
+	// Secrets are resolved when the command starts and delivered to it as
+	// tmpfs files or environment variables, see SecretSpec
+	Secrets *SecretSpec
+
+	// Context stops the command when cancelled: its process group gets
+	// SIGTERM, then SIGKILL after GracePeriod. Default: DefaultGracePeriod
+	Context     context.Context
+	GracePeriod time.Duration
+
+	// RunID registers the command in RunDir while it runs, so KillRun can
+	// stop all slices of the run from another process
+	RunID string
+
+	// Stdin is connected to the command's standard input if set
+	Stdin io.Reader
+
+	// Stdout and Stderr stream the command's output if set.
+	// When nil, the output is written to Log, or buffered and returned
+	// in ExecutorResult if there is no Log either.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Log captures stdout and stderr into a rotating per-slice log file
+	// while the command runs, see LogConfig
+	Log *LogConfig
 }
 
 // ExecutorResult represents the result of an execution
@@ -52,12 +132,99 @@ type ExecutorResult struct {
 	// Exit code of the command
 	ExitCode int
 
-	// Standard output
+	// Standard output (nil when streamed through ExecutorConfig.Stdout)
 	Stdout []byte
 
-	// Standard error
+	// Standard error (nil when streamed through ExecutorConfig.Stderr)
 	Stderr []byte
 
 	// Error if any occurred during execution
 	Error error
+
+	// FailureReason classifies why the slice failed, if it was stopped by
+	// the runtime or its cgroup limits rather than failing on its own
+	FailureReason FailureReason
+
+	// Events are the slice cgroup's resource events, read when it exited
+	Events CgroupEvents
+
+	// LogPath is the slice's log file when output was captured to a log.
+	// Stdout is then nil and Stderr holds only the last 64 KiB.
+	LogPath string
+}
+
+// FailureReason classifies a slice failure caused by the runtime
+type FailureReason string
+
+const (
+	// FailureSeccompDenied means the slice made a syscall its seccomp profile denies
+	FailureSeccompDenied FailureReason = "SeccompDenied"
+
+	// FailureOOMKilled means the OOM killer killed a process of the slice,
+	// either at the slice's or at its herd's memory limit
+	FailureOOMKilled FailureReason = "OOMKilled"
+
+	// FailurePidsExhausted means the slice failed after a fork or clone
+	// was refused at its pids limit
+	FailurePidsExhausted FailureReason = "PidsExhausted"
+
+	// FailureTimeout means the executor killed the slice at its timeout
+	FailureTimeout FailureReason = "Timeout"
+
+	// FailureCanceled means the slice was stopped because its context was cancelled
+	FailureCanceled FailureReason = "Canceled"
+)
+
+// SliceError is returned for a slice that exited unsuccessfully. Callers
+// can inspect Reason to decide how to retry, e.g. with more memory after
+// FailureOOMKilled.
+type SliceError struct {
+	// Name identifies the slice, e.g. "plugin step enrich"
+	Name string
+
+	// Reason classifies the failure; empty if the slice failed on its own
+	Reason FailureReason
+
+	// ExitCode is the slice's exit code, -1 if it was killed by a signal
+	ExitCode int
+
+	// Events are the slice cgroup's resource events
+	Events CgroupEvents
+}
+
+// Error implements the error interface
+func (e *SliceError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("%s failed: %s", e.Name, e.Reason)
+	}
+	return fmt.Sprintf("%s exited with code %d", e.Name, e.ExitCode)
+}
+
+// FailureReason returns the classification as a string, for callers that
+// match errors by interface rather than importing this package
+func (e *SliceError) FailureReason() string {
+	return string(e.Reason)
+}
+
+// Failure returns a *SliceError for an unsuccessful result, or nil
+func (r ExecutorResult) Failure(name string) error {
+	if r.ExitCode == 0 && r.FailureReason == "" {
+		return nil
+	}
+	return &SliceError{
+		Name:     name,
+		Reason:   r.FailureReason,
+		ExitCode: r.ExitCode,
+		Events:   r.Events,
+	}
+}
+
+// FailureReasonOf returns the classification of a slice failure anywhere
+// in err's chain, or "" if there is none
+func FailureReasonOf(err error) FailureReason {
+	var sliceErr *SliceError
+	if errors.As(err, &sliceErr) {
+		return sliceErr.Reason
+	}
+	return ""
 }
@@ -0,0 +1,162 @@
+// Package runtime provides isolation and resource control for RunInk node execution
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// Ephemeral host id range handed out to slices running in a user namespace.
+// This matches the conventional first /etc/subuid range.
+const (
+	EphemeralIDBase  = 100000
+	EphemeralIDCount = 65536
+)
+
+// IDLeaseDir holds the lease files of ephemeral ids in use on this host
+var IDLeaseDir = "/run/runink/uids"
+
+// IDAllocator hands out ephemeral host ids so each slice maps root
+// inside its user namespace to a uid no other slice is using. An id is
+// leased by holding a flock on a file named after it, so allocators in
+// different agents and slice-exec processes never hand out the same id,
+// and the leases of a process that dies are released with its locks.
+type IDAllocator struct {
+	base   int
+	count  int
+	dir    string
+	next   int
+	leases map[int]*os.File
+	mu     sync.Mutex
+}
+
+// NewIDAllocator creates an allocator over [base, base+count) that keeps
+// its leases in dir
+func NewIDAllocator(base, count int, dir string) *IDAllocator {
+	return &IDAllocator{
+		base:   base,
+		count:  count,
+		dir:    dir,
+		leases: make(map[int]*os.File),
+	}
+}
+
+// Allocate returns a host id from the range that no process holds
+func (a *IDAllocator) Allocate() (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := os.MkdirAll(a.dir, 0700); err != nil {
+		return 0, fmt.Errorf("failed to create id lease directory: %v", err)
+	}
+	for i := 0; i < a.count; i++ {
+		id := a.base + (a.next+i)%a.count
+		if a.leases[id] != nil {
+			continue
+		}
+		lease, err := a.lease(id)
+		if err != nil {
+			return 0, err
+		}
+		if lease != nil {
+			a.leases[id] = lease
+			a.next = (a.next + i + 1) % a.count
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("no ephemeral ids left in range %d-%d", a.base, a.base+a.count-1)
+}
+
+// lease locks the lease file of an id, returning nil if another process
+// holds it
+func (a *IDAllocator) lease(id int) (*os.File, error) {
+	path := filepath.Join(a.dir, strconv.Itoa(id))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open id lease: %v", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to lock id lease: %v", err)
+	}
+
+	// A releasing process unlinks the file before unlocking it; a lock on
+	// an unlinked file leases nothing
+	var locked, current syscall.Stat_t
+	if syscall.Fstat(int(f.Fd()), &locked) != nil || syscall.Stat(path, &current) != nil ||
+		locked.Dev != current.Dev || locked.Ino != current.Ino {
+		f.Close()
+		return nil, nil
+	}
+	return f, nil
+}
+
+// Release returns a host id to the range
+func (a *IDAllocator) Release(id int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	lease := a.leases[id]
+	if lease == nil {
+		return
+	}
+	delete(a.leases, id)
+	os.Remove(lease.Name())
+	lease.Close()
+}
+
+// DefaultIDAllocator is the allocator used for slices without explicit mappings
+var DefaultIDAllocator = NewIDAllocator(EphemeralIDBase, EphemeralIDCount, IDLeaseDir)
+
+// RootlessMappings maps root inside the namespace to the calling user.
+// This is the only mapping an unprivileged agent may write itself.
+func RootlessMappings() ([]syscall.SysProcIDMap, []syscall.SysProcIDMap) {
+	return []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Geteuid(), Size: 1}},
+		[]syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getegid(), Size: 1}}
+}
+
+// EphemeralMappings maps root inside the namespace to a single host id
+func EphemeralMappings(hostID int) ([]syscall.SysProcIDMap, []syscall.SysProcIDMap) {
+	return []syscall.SysProcIDMap{{ContainerID: 0, HostID: hostID, Size: 1}},
+		[]syscall.SysProcIDMap{{ContainerID: 0, HostID: hostID, Size: 1}}
+}
+
+// userNamespaceAttr fills in the user namespace mappings for a command.
+// Without explicit mappings, root agents give each slice an ephemeral uid
+// and unprivileged agents map the slice to their own uid.
+// The returned function releases any ephemeral id.
+func (e *Executor) userNamespaceAttr(attr *syscall.SysProcAttr) (func(), error) {
+	release := func() {}
+	if e.Config.Namespaces&CLONE_NEWUSER == 0 {
+		return release, nil
+	}
+
+	uidMappings, gidMappings := e.Config.UidMappings, e.Config.GidMappings
+	if len(uidMappings) == 0 && len(gidMappings) == 0 {
+		if os.Geteuid() == 0 {
+			hostID, err := DefaultIDAllocator.Allocate()
+			if err != nil {
+				return release, err
+			}
+			release = func() { DefaultIDAllocator.Release(hostID) }
+			uidMappings, gidMappings = EphemeralMappings(hostID)
+		} else {
+			uidMappings, gidMappings = RootlessMappings()
+		}
+	}
+
+	attr.UidMappings = uidMappings
+	attr.GidMappings = gidMappings
+
+	// The kernel refuses an unprivileged gid_map unless setgroups is denied
+	attr.GidMappingsEnableSetgroups = os.Geteuid() == 0
+
+	return release, nil
+}
@@ -0,0 +1,151 @@
+package runtime
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/runink/runink/parser"
+)
+
+// TestIDAllocator tests that ephemeral ids are unique until released
+func TestIDAllocator(t *testing.T) {
+	allocator := NewIDAllocator(200000, 2, t.TempDir())
+
+	first, err := allocator.Allocate()
+	if err != nil {
+		t.Fatalf("Failed to allocate: %v", err)
+	}
+	second, err := allocator.Allocate()
+	if err != nil {
+		t.Fatalf("Failed to allocate: %v", err)
+	}
+	if first == second {
+		t.Errorf("Expected distinct ids, got %d twice", first)
+	}
+	if _, err := allocator.Allocate(); err == nil {
+		t.Error("Expected exhausted range to fail")
+	}
+
+	allocator.Release(first)
+	again, err := allocator.Allocate()
+	if err != nil || again != first {
+		t.Errorf("Expected released id %d to be reused, got %d (%v)", first, again, err)
+	}
+}
+
+// TestIDAllocatorHelper is run as a separate process by TestIDAllocatorAcrossProcesses
+func TestIDAllocatorHelper(t *testing.T) {
+	dir := os.Getenv("RUNINK_ID_HELPER")
+	if dir == "" {
+		t.Skip("Helper for TestIDAllocatorAcrossProcesses")
+	}
+	id, err := NewIDAllocator(200000, 2, dir).Allocate()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println(id)
+
+	// Hold the lease until the parent closes stdin, then exit without
+	// releasing it
+	bufio.NewReader(os.Stdin).ReadString('\n')
+	os.Exit(0)
+}
+
+// TestIDAllocatorAcrossProcesses tests that allocators in separate
+// processes never hand out the same id, and that a dead process's lease is freed
+func TestIDAllocatorAcrossProcesses(t *testing.T) {
+	dir := t.TempDir()
+
+	helper := exec.Command(os.Args[0], "-test.run=TestIDAllocatorHelper")
+	helper.Env = append(os.Environ(), "RUNINK_ID_HELPER="+dir)
+	stdin, err := helper.StdinPipe()
+	if err != nil {
+		t.Fatalf("Failed to create stdin pipe: %v", err)
+	}
+	stdout, err := helper.StdoutPipe()
+	if err != nil {
+		t.Fatalf("Failed to create stdout pipe: %v", err)
+	}
+	if err := helper.Start(); err != nil {
+		t.Fatalf("Failed to start helper: %v", err)
+	}
+	defer helper.Wait()
+	defer stdin.Close()
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read helper id: %v", err)
+	}
+	helperID, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatalf("Helper failed to allocate: %s", line)
+	}
+
+	allocator := NewIDAllocator(200000, 2, dir)
+	id, err := allocator.Allocate()
+	if err != nil {
+		t.Fatalf("Failed to allocate: %v", err)
+	}
+	if id == helperID {
+		t.Errorf("Expected an id other than the helper's %d", helperID)
+	}
+	if _, err := allocator.Allocate(); err == nil {
+		t.Error("Expected the range to be exhausted while the helper holds its lease")
+	}
+
+	// The helper exits without releasing; its lease goes with its lock
+	stdin.Close()
+	if err := helper.Wait(); err != nil {
+		t.Fatalf("Helper failed: %v", err)
+	}
+	again, err := allocator.Allocate()
+	if err != nil || again != helperID {
+		t.Errorf("Expected the dead helper's id %d to be reused, got %d (%v)", helperID, again, err)
+	}
+}
+
+// TestHerdUserNamespace tests that EphemeralUserNamespace adds CLONE_NEWUSER
+func TestHerdUserNamespace(t *testing.T) {
+	if HerdNamespaces(parser.RuntimeIsolation{})&CLONE_NEWUSER != 0 {
+		t.Error("Expected no user namespace by default")
+	}
+	if HerdNamespaces(parser.RuntimeIsolation{EphemeralUserNamespace: true})&CLONE_NEWUSER == 0 {
+		t.Error("Expected EphemeralUserNamespace to unshare the user namespace")
+	}
+}
+
+// TestExecutorEphemeralUser tests that a slice runs as root mapped to an ephemeral host uid
+func TestExecutorEphemeralUser(t *testing.T) {
+	// Skip this test if not running as root
+	if os.Geteuid() != 0 {
+		t.Skip("This test requires root privileges")
+	}
+
+	executor := NewExecutor().
+		SetCommand([]string{"/bin/sh", "-c", "cat /proc/self/uid_map"}).
+		SetChrootDir("/").
+		SetNamespaces(0).
+		SetUserNamespace(nil, nil)
+
+	result, err := executor.Execute()
+	if err != nil {
+		t.Skipf("User namespaces unavailable: %v", err)
+	}
+	if result.ExitCode != 0 {
+		t.Fatalf("Command failed with exit code %d: %s", result.ExitCode, string(result.Stderr))
+	}
+
+	fields := strings.Fields(string(result.Stdout))
+	if len(fields) != 3 || fields[0] != "0" || fields[2] != "1" {
+		t.Fatalf("Unexpected uid_map: %q", string(result.Stdout))
+	}
+	if fields[1] == "0" {
+		t.Error("Expected root in the slice to map to an unprivileged host uid")
+	}
+}
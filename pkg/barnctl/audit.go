@@ -0,0 +1,863 @@
+package barnctl
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// DefaultAuditKeyFile holds the Ed25519 key the leader signs audit
+	// checkpoints with
+	DefaultAuditKeyFile = "/etc/barn/audit.key"
+
+	// DefaultAuditPublicKeyFile holds the hex public key audit verify pins,
+	// written next to the key by audit keygen
+	DefaultAuditPublicKeyFile = "/etc/barn/audit.pub"
+
+	// DefaultAuditCheckpointInterval is how often the leader signs the
+	// head of the audit log
+	DefaultAuditCheckpointInterval = 5 * time.Minute
+
+	// defaultAuditPage and maxAuditPage bound the entries of an audit log
+	// page
+	defaultAuditPage = 1000
+	maxAuditPage     = 10000
+
+	// auditSignaturePrefix separates checkpoint signatures from other uses
+	// of the key
+	auditSignaturePrefix = "runink barn audit checkpoint"
+)
+
+// Store keys of the audit log. Entries and checkpoints are written by the
+// store itself; transactions on these keys fail with ErrAuditAppendOnly.
+const (
+	auditKeyPrefix        = "audit/"
+	auditEntryPrefix      = auditKeyPrefix + "log/"
+	auditCheckpointPrefix = auditKeyPrefix + "checkpoints/"
+)
+
+func auditEntryKey(seq uint64) string {
+	return fmt.Sprintf("%s%020d", auditEntryPrefix, seq)
+}
+
+func auditCheckpointKey(seq uint64) string {
+	return fmt.Sprintf("%s%020d", auditCheckpointPrefix, seq)
+}
+
+// AuditEvent describes why the store changed: who asked for it, what
+// they did and to which resource. Secret reads and pipeline executions are
+// recorded as events without changes.
+type AuditEvent struct {
+	Actor    string            `json:"actor"`
+	Action   string            `json:"action"`
+	Resource string            `json:"resource,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+}
+
+// AuditChange is a key written by an audited transaction. Values are
+// recorded by their SHA-256 digest only.
+type AuditChange struct {
+	Op     string `json:"op"`
+	Key    string `json:"key"`
+	Digest string `json:"digest,omitempty"`
+}
+
+// AuditEntry is an entry of the append-only audit log. Its hash covers
+// the entry and the hash of the entry before it, so changing, removing or
+// reordering an entry breaks every hash after it.
+type AuditEntry struct {
+	Seq      uint64    `json:"seq"`
+	Revision uint64    `json:"revision"`
+	Time     time.Time `json:"time"`
+	AuditEvent
+	Changes  []AuditChange `json:"changes,omitempty"`
+	PrevHash string        `json:"prevHash"`
+	Hash     string        `json:"hash"`
+}
+
+// digest returns the hash of the entry, computed with Hash empty
+func (e AuditEntry) digest() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// AuditCheckpoint is the leader's signature over the audit log up to Seq,
+// whose entry has Hash. Verifiers pin PublicKey; a log that lost or
+// changed any checkpointed entry no longer matches the signature.
+type AuditCheckpoint struct {
+	Seq       uint64    `json:"seq"`
+	Hash      string    `json:"hash"`
+	Time      time.Time `json:"time"`
+	PublicKey []byte    `json:"publicKey"`
+	Signature []byte    `json:"signature"`
+}
+
+// signedData returns what the signature of the checkpoint covers
+func (c *AuditCheckpoint) signedData() []byte {
+	return []byte(fmt.Sprintf("%s\n%d\n%s\n%s", auditSignaturePrefix, c.Seq, c.Hash, c.Time.UTC().Format(time.RFC3339Nano)))
+}
+
+// verify checks the checkpoint's signature under its own public key
+func (c *AuditCheckpoint) verify() error {
+	if len(c.PublicKey) != ed25519.PublicKeySize || !ed25519.Verify(c.PublicKey, c.signedData(), c.Signature) {
+		return &AuditError{Seq: c.Seq, Reason: "checkpoint signature is invalid"}
+	}
+	return nil
+}
+
+// AuditLog is the body of GET /api/v1/audit: the entries from the
+// requested sequence number on, and the sequence number of the last entry
+type AuditLog struct {
+	Entries  []AuditEntry `json:"entries"`
+	Head     uint64       `json:"head"`
+	Revision uint64       `json:"revision"`
+}
+
+// AuditCheckpointList is the body of GET /api/v1/audit/checkpoints,
+// oldest first
+type AuditCheckpointList struct {
+	Items    []AuditCheckpoint `json:"items"`
+	Revision uint64            `json:"revision"`
+}
+
+// auditHead is the end of the audit log as the store applied it
+type auditHead struct {
+	seq        uint64
+	hash       string
+	checkpoint uint64
+}
+
+type auditContextKey struct{}
+
+// WithAuditEvent returns a context whose store writes are recorded as
+// event in the audit log
+func WithAuditEvent(ctx context.Context, event AuditEvent) context.Context {
+	return context.WithValue(ctx, auditContextKey{}, &event)
+}
+
+// auditEventFrom returns the audit event of ctx, or nil
+func auditEventFrom(ctx context.Context) *AuditEvent {
+	event, _ := ctx.Value(auditContextKey{}).(*AuditEvent)
+	return event
+}
+
+// appendAudit appends the audit entry of an applied command. Writes
+// without an audit event are recorded as internal ones. The audit log
+// cannot be watched, so its keys leave the watch history alone. The
+// caller holds s.mu.
+func (s *Store) appendAudit(revision uint64, command *storeCommand) {
+	entry := AuditEntry{
+		Seq:        s.audit.seq + 1,
+		Revision:   revision,
+		Time:       command.Time,
+		AuditEvent: AuditEvent{Actor: "barn", Action: "internal"},
+		PrevHash:   s.audit.hash,
+	}
+	if command.Audit != nil {
+		entry.AuditEvent = *command.Audit
+	}
+	for _, op := range command.Ops {
+		change := AuditChange{Op: op.Op, Key: op.Key}
+		if op.Op == opPut {
+			sum := sha256.Sum256(op.Value)
+			change.Digest = "sha256:" + hex.EncodeToString(sum[:])
+		}
+		entry.Changes = append(entry.Changes, change)
+	}
+	entry.Hash = entry.digest()
+
+	key := auditEntryKey(entry.Seq)
+	value, _ := json.Marshal(entry)
+	s.data[key] = storeRecord{Value: value, CreateRevision: revision, ModRevision: revision}
+	s.audit.seq, s.audit.hash = entry.Seq, entry.Hash
+}
+
+// applyCheckpoint stores a checkpoint that signs the current audit log.
+// The caller holds s.mu.
+func (s *Store) applyCheckpoint(revision uint64, checkpoint *AuditCheckpoint) any {
+	if checkpoint.Seq <= s.audit.checkpoint {
+		return &ConflictError{Key: auditCheckpointKey(checkpoint.Seq), Reason: "is not after the last checkpoint"}
+	}
+	record, ok := s.data[auditEntryKey(checkpoint.Seq)]
+	if !ok {
+		return &AuditError{Seq: checkpoint.Seq, Reason: "checkpoint is beyond the end of the log"}
+	}
+	entry := AuditEntry{}
+	if err := json.Unmarshal(record.Value, &entry); err != nil || entry.Hash != checkpoint.Hash {
+		return &AuditError{Seq: checkpoint.Seq, Reason: "checkpoint hash does not match the entry"}
+	}
+	if err := checkpoint.verify(); err != nil {
+		return err
+	}
+
+	key := auditCheckpointKey(checkpoint.Seq)
+	value, _ := json.Marshal(checkpoint)
+	s.data[key] = storeRecord{Value: value, CreateRevision: revision, ModRevision: revision}
+	s.audit.checkpoint = checkpoint.Seq
+	s.revision = revision
+	return revision
+}
+
+// readAuditHead returns the end of the audit log stored in data
+func readAuditHead(data map[string]storeRecord) (auditHead, error) {
+	head := auditHead{}
+	last := ""
+	for key := range data {
+		switch {
+		case strings.HasPrefix(key, auditEntryPrefix) && key > last:
+			last = key
+		case strings.HasPrefix(key, auditCheckpointPrefix):
+			seq, err := strconv.ParseUint(strings.TrimPrefix(key, auditCheckpointPrefix), 10, 64)
+			if err != nil {
+				return head, fmt.Errorf("invalid audit checkpoint key %s: %w", key, ErrCorrupt)
+			}
+			if seq > head.checkpoint {
+				head.checkpoint = seq
+			}
+		}
+	}
+	if last == "" {
+		return head, nil
+	}
+	entry := AuditEntry{}
+	if err := json.Unmarshal(data[last].Value, &entry); err != nil {
+		return head, fmt.Errorf("invalid audit entry %s: %w", last, ErrCorrupt)
+	}
+	head.seq, head.hash = entry.Seq, entry.Hash
+	return head, nil
+}
+
+// AuditHead returns the sequence number and hash of the last local audit
+// entry, and the sequence number of the last checkpoint
+func (s *Store) AuditHead() (seq uint64, hash string, checkpoint uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.audit.seq, s.audit.hash, s.audit.checkpoint
+}
+
+// Audit appends event to the audit log without changing the store. An
+// empty actor is taken from the audit event of ctx.
+func (s *Store) Audit(ctx context.Context, event AuditEvent) error {
+	if event.Action == "" {
+		return fmt.Errorf("audit event has no action")
+	}
+	if event.Actor == "" {
+		if current := auditEventFrom(ctx); current != nil {
+			event.Actor = current.Actor
+		}
+	}
+	_, err := s.propose(ctx, storeCommand{Audit: &event})
+	return err
+}
+
+// Checkpoint signs the head of the audit log with key. It returns nil if
+// no entry was appended since the last checkpoint.
+func (s *Store) Checkpoint(ctx context.Context, key *AuditKey) (*AuditCheckpoint, error) {
+	seq, hash, last := s.AuditHead()
+	if seq == 0 || seq == last {
+		return nil, nil
+	}
+	checkpoint := key.sign(seq, hash, time.Now().UTC())
+	if _, err := s.propose(ctx, storeCommand{Checkpoint: checkpoint}); err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// AuditKey signs audit checkpoints. Only the leader signs, but every
+// member needs the key to sign once it becomes leader.
+type AuditKey struct {
+	private ed25519.PrivateKey
+}
+
+// NewAuditKey returns an audit key for a 32-byte Ed25519 seed
+func NewAuditKey(seed []byte) (*AuditKey, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("audit key must be %d bytes, got %d", ed25519.SeedSize, len(seed))
+	}
+	return &AuditKey{private: ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+// LoadAuditKey reads an audit key file holding the 64 hex digits of its
+// seed, as written by barnctl audit keygen
+func LoadAuditKey(path string) (*AuditKey, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit key: %w", err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		fmt.Fprintf(os.Stderr, "Warning: audit key %s is accessible to other users (mode %v)\n", path, info.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit key: %w", err)
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid audit key %s: %w", path, err)
+	}
+	defer zero(seed)
+	return NewAuditKey(seed)
+}
+
+// PublicKey returns the key auditors verify checkpoints with
+func (k *AuditKey) PublicKey() ed25519.PublicKey {
+	return k.private.Public().(ed25519.PublicKey)
+}
+
+func (k *AuditKey) sign(seq uint64, hash string, now time.Time) *AuditCheckpoint {
+	checkpoint := &AuditCheckpoint{Seq: seq, Hash: hash, Time: now, PublicKey: k.PublicKey()}
+	checkpoint.Signature = ed25519.Sign(k.private, checkpoint.signedData())
+	return checkpoint
+}
+
+// AuditVerification is the result of a successful VerifyAuditLog
+type AuditVerification struct {
+	Entries     int
+	Checkpoints int
+
+	// Head and HeadHash identify the last entry
+	Head     uint64
+	HeadHash string
+
+	// Signed is the sequence number of the last checkpointed entry. The
+	// entries after it could be truncated without breaking a signature.
+	Signed uint64
+}
+
+// VerifyAuditLog checks that entries are the complete audit log from its
+// first entry: consecutive, each chained to the one before and matching
+// its hash. Every checkpoint must be signed by publicKey and match its
+// entry; a checkpoint beyond the last entry means the log was truncated.
+// It returns ErrAuditKeyRequired for checkpoints without a publicKey, and
+// an AuditError for the first problem found.
+func VerifyAuditLog(entries []AuditEntry, checkpoints []AuditCheckpoint, publicKey ed25519.PublicKey) (*AuditVerification, error) {
+	if len(checkpoints) > 0 && len(publicKey) != ed25519.PublicKeySize {
+		return nil, ErrAuditKeyRequired
+	}
+	result := &AuditVerification{Entries: len(entries), Checkpoints: len(checkpoints)}
+	prev := ""
+	for i, entry := range entries {
+		seq := uint64(i) + 1
+		switch {
+		case entry.Seq != seq:
+			return nil, &AuditError{Seq: seq, Reason: fmt.Sprintf("found entry %d instead: entries are missing or reordered", entry.Seq)}
+		case entry.PrevHash != prev:
+			return nil, &AuditError{Seq: seq, Reason: "previous hash does not match the entry before"}
+		case entry.digest() != entry.Hash:
+			return nil, &AuditError{Seq: seq, Reason: "entry does not match its hash"}
+		}
+		prev = entry.Hash
+	}
+	if len(entries) > 0 {
+		result.Head, result.HeadHash = uint64(len(entries)), prev
+	}
+
+	for _, checkpoint := range checkpoints {
+		if !bytes.Equal(checkpoint.PublicKey, publicKey) {
+			return nil, &AuditError{Seq: checkpoint.Seq, Reason: "checkpoint is signed by another key"}
+		}
+		if err := checkpoint.verify(); err != nil {
+			return nil, err
+		}
+		if checkpoint.Seq == 0 || checkpoint.Seq > uint64(len(entries)) {
+			return nil, &AuditError{Seq: checkpoint.Seq, Reason: fmt.Sprintf("checkpoint is beyond the end of the log at %d: the log was truncated", len(entries))}
+		}
+		if entries[checkpoint.Seq-1].Hash != checkpoint.Hash {
+			return nil, &AuditError{Seq: checkpoint.Seq, Reason: "entry does not match its checkpoint"}
+		}
+		if checkpoint.Seq > result.Signed {
+			result.Signed = checkpoint.Seq
+		}
+	}
+	return result, nil
+}
+
+// RunAuditCheckpoints signs the head of the audit log each interval,
+// while the node is the leader, until ctx is done
+func (s *Server) RunAuditCheckpoints(ctx context.Context, interval time.Duration) {
+	if s.config.AuditKey == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !s.store.Raft().IsLeader() {
+			continue
+		}
+		if _, err := s.store.Checkpoint(ctx, s.config.AuditKey); err != nil && !errors.Is(err, ErrConflict) {
+			fmt.Fprintf(os.Stderr, "Warning: failed to sign an audit checkpoint: %v\n", err)
+		}
+	}
+}
+
+// getAuditLog answers a page of the audit log
+func (s *Server) getAuditLog(w http.ResponseWriter, r *http.Request, _ Params) {
+	if !s.prepareRead(w, r) {
+		return
+	}
+	from, err := queryUint(r, "from", 1)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	limit, err := queryUint(r, "limit", defaultAuditPage)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if from == 0 {
+		from = 1
+	}
+	if limit > maxAuditPage {
+		limit = maxAuditPage
+	}
+
+	revision := s.store.Revision()
+	head, _, _ := s.store.AuditHead()
+	log := AuditLog{Entries: []AuditEntry{}, Head: head, Revision: revision}
+	for seq := from; seq <= head && seq < from+limit; seq++ {
+		value, ok := s.store.Get(auditEntryKey(seq))
+		if !ok {
+			s.fail(w, r, &AuditError{Seq: seq, Reason: "entry is missing"})
+			return
+		}
+		entry := AuditEntry{}
+		if err := json.Unmarshal(value, &entry); err != nil {
+			s.fail(w, r, &AuditError{Seq: seq, Reason: "entry is corrupt"})
+			return
+		}
+		log.Entries = append(log.Entries, entry)
+	}
+	writeJSON(w, http.StatusOK, log)
+}
+
+func (s *Server) listAuditCheckpoints(w http.ResponseWriter, r *http.Request, _ Params) {
+	if !s.prepareRead(w, r) {
+		return
+	}
+	ranges, revision := s.store.Ranges(auditCheckpointPrefix)
+	list := AuditCheckpointList{Items: []AuditCheckpoint{}, Revision: revision}
+	for _, kv := range ranges[0] {
+		checkpoint := AuditCheckpoint{}
+		if err := json.Unmarshal(kv.Value, &checkpoint); err != nil {
+			s.fail(w, r, fmt.Errorf("corrupt audit checkpoint %s: %w", kv.Key, err))
+			return
+		}
+		list.Items = append(list.Items, checkpoint)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// recordAudit appends an event reported by a client, such as a Runi
+// agent starting a slice. The actor is the caller, not what it claims.
+func (s *Server) recordAudit(w http.ResponseWriter, r *http.Request, _ Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	event := AuditEvent{}
+	if err := decodeBody(r, &event); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if err := validateAuditEvent(&event); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	event.Actor = ""
+	if err := s.store.Audit(r.Context(), event); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// auditEventSlice returns the herd and slice an event's resource names, as
+// in /api/v1/herds/finance/slices/ledger
+func auditEventSlice(resource string) (herd, slice string, ok bool) {
+	rest, found := strings.CutPrefix(resource, APIPrefix+"/herds/")
+	if !found {
+		return "", "", false
+	}
+	herd, slice, found = strings.Cut(rest, "/slices/")
+	if !found || strings.Contains(slice, "/") {
+		return "", "", false
+	}
+	herd, herdErr := url.PathUnescape(herd)
+	slice, sliceErr := url.PathUnescape(slice)
+	if herdErr != nil || sliceErr != nil || herd == "" || slice == "" {
+		return "", "", false
+	}
+	return herd, slice, true
+}
+
+// validateAuditEvent checks an event reported through the API. Actions
+// are dotted lowercase names, like slice.start.
+func validateAuditEvent(event *AuditEvent) error {
+	if event.Action == "" {
+		return &ValidationError{Field: "action", Message: "is required"}
+	}
+	for _, c := range event.Action {
+		if !(c == '.' || c == '-' || c == '_' || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')) {
+			return &ValidationError{Field: "action", Message: fmt.Sprintf("%q is not a dotted lowercase name", event.Action)}
+		}
+	}
+	if event.Resource == "" {
+		return &ValidationError{Field: "resource", Message: "is required"}
+	}
+	return nil
+}
+
+// queryUint returns a numeric query parameter, or fallback if unset
+func queryUint(r *http.Request, name string, fallback uint64) (uint64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, &ValidationError{Field: name, Message: fmt.Sprintf("%q is not a number", value)}
+	}
+	return n, nil
+}
+
+// AuditLog returns up to limit audit entries from sequence number from
+func (c *Client) AuditLog(ctx context.Context, from, limit uint64) (*AuditLog, error) {
+	query := url.Values{"from": {strconv.FormatUint(from, 10)}}
+	if limit != 0 {
+		query.Set("limit", strconv.FormatUint(limit, 10))
+	}
+	log := &AuditLog{}
+	return log, c.do(ctx, http.MethodGet, "/audit", query, nil, log)
+}
+
+// AuditCheckpoints returns the signed checkpoints of the audit log
+func (c *Client) AuditCheckpoints(ctx context.Context) (*AuditCheckpointList, error) {
+	list := &AuditCheckpointList{}
+	return list, c.do(ctx, http.MethodGet, "/audit/checkpoints", nil, nil, list)
+}
+
+// RecordAudit appends an event to the audit log
+func (c *Client) RecordAudit(ctx context.Context, event AuditEvent) error {
+	return c.do(ctx, http.MethodPost, "/audit", nil, event, nil)
+}
+
+// AuditExport is the file written by barnctl audit export: the whole
+// audit log with its checkpoints
+type AuditExport struct {
+	Entries     []AuditEntry      `json:"entries"`
+	Checkpoints []AuditCheckpoint `json:"checkpoints"`
+	ExportedAt  time.Time         `json:"exportedAt"`
+}
+
+// exportAudit reads the whole audit log and its checkpoints. The
+// checkpoints are read first, so that every one of them is covered by the
+// entries read after.
+func exportAudit(ctx context.Context, api *Client) (*AuditExport, error) {
+	checkpoints, err := api.AuditCheckpoints(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit checkpoints: %w", err)
+	}
+	export := &AuditExport{Entries: []AuditEntry{}, Checkpoints: checkpoints.Items, ExportedAt: time.Now().UTC()}
+	for from := uint64(1); ; {
+		page, err := api.AuditLog(ctx, from, maxAuditPage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the audit log: %w", err)
+		}
+		export.Entries = append(export.Entries, page.Entries...)
+		if len(page.Entries) == 0 || from+uint64(len(page.Entries)) > page.Head {
+			return export, nil
+		}
+		from += uint64(len(page.Entries))
+	}
+}
+
+func newAuditCommand(client *ClientOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Inspect and verify the Barn audit log",
+		Long: `Every change of the Barn, secret read and reported pipeline execution is
+appended to a hash-chained audit log, whose head the leader signs
+periodically with the key in --audit-key-file. Verify the log against the
+public key to prove that no entry was modified, removed or reordered.`,
+	}
+	cmd.AddCommand(
+		newAuditVerifyCommand(client),
+		newAuditExportCommand(client),
+		newAuditLogCommand(client),
+		newAuditKeygenCommand(),
+	)
+	return cmd
+}
+
+func newAuditVerifyCommand(client *ClientOptions) *cobra.Command {
+	var file, publicKey, publicKeyFile, head string
+
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the hash chain and signed checkpoints of the audit log",
+		Long: `Verify the audit log of the Barn, or an export of it with --file. Each
+entry must chain to the one before and match its hash, and each checkpoint
+must carry a valid signature over an entry of the log by the pinned key:
+--public-key, or else the one in --public-key-file as written by audit
+keygen. A log with checkpoints fails without a pinned key. --head checks a
+SEQ:HASH recorded earlier, e.g. by a previous verify, to detect truncation
+after the last checkpoint.`,
+		Example: `  barnctl audit verify --public-key 3b6a27bc...
+  barnctl audit verify --file audit-2026-09.json --head 10422:sha256:9f86d0...`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if publicKey == "" {
+				data, err := os.ReadFile(publicKeyFile)
+				switch {
+				case err == nil:
+					publicKey = strings.TrimSpace(string(data))
+				case !errors.Is(err, os.ErrNotExist) || cmd.Flags().Changed("public-key-file"):
+					return fmt.Errorf("failed to read audit public key: %w", err)
+				}
+			}
+			var key ed25519.PublicKey
+			if publicKey != "" {
+				decoded, err := hex.DecodeString(publicKey)
+				if err != nil || len(decoded) != ed25519.PublicKeySize {
+					return fmt.Errorf("invalid audit public key: expected %d hex-encoded bytes", ed25519.PublicKeySize)
+				}
+				key = decoded
+			}
+
+			var export *AuditExport
+			if file != "" {
+				data, err := os.ReadFile(file)
+				if err != nil {
+					return fmt.Errorf("failed to read audit export: %w", err)
+				}
+				export = &AuditExport{}
+				if err := json.Unmarshal(data, export); err != nil {
+					return fmt.Errorf("invalid audit export %s: %w", file, err)
+				}
+			} else {
+				api, ctx, cancel := client.client(cmd)
+				defer cancel()
+				var err error
+				if export, err = exportAudit(ctx, api); err != nil {
+					return err
+				}
+			}
+
+			result, err := VerifyAuditLog(export.Entries, export.Checkpoints, key)
+			if errors.Is(err, ErrAuditKeyRequired) {
+				return fmt.Errorf("audit log verification failed: %w; set --public-key or --public-key-file", err)
+			}
+			if err != nil {
+				return fmt.Errorf("audit log verification failed: %w", err)
+			}
+			if head != "" {
+				if err := checkAuditHead(export.Entries, head); err != nil {
+					return fmt.Errorf("audit log verification failed: %w", err)
+				}
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Verified %d entries and %d checkpoints\n", result.Entries, result.Checkpoints)
+			if result.Head != 0 {
+				fmt.Fprintf(out, "Head: %d:%s\n", result.Head, result.HeadHash)
+			}
+			if unsigned := result.Head - result.Signed; unsigned > 0 {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Warning: %d entries after the last checkpoint are not signed yet\n", unsigned)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&file, "file", "", "Verify an export written by barnctl audit export instead")
+	cmd.Flags().StringVar(&publicKey, "public-key", os.Getenv("BARN_AUDIT_PUBLIC_KEY"), "Hex public key the checkpoints must be signed with (env BARN_AUDIT_PUBLIC_KEY)")
+	cmd.Flags().StringVar(&publicKeyFile, "public-key-file", DefaultAuditPublicKeyFile, "File with the public key, used without --public-key")
+	cmd.Flags().StringVar(&head, "head", "", "SEQ:HASH of an entry the log must still contain")
+	return cmd
+}
+
+// checkAuditHead checks that entries contain the entry SEQ:HASH
+func checkAuditHead(entries []AuditEntry, head string) error {
+	seqText, hash, ok := strings.Cut(head, ":")
+	seq, err := strconv.ParseUint(seqText, 10, 64)
+	if !ok || err != nil || seq == 0 {
+		return fmt.Errorf("invalid --head %q: expected SEQ:HASH", head)
+	}
+	if seq > uint64(len(entries)) {
+		return &AuditError{Seq: seq, Reason: fmt.Sprintf("recorded head is beyond the end of the log at %d: the log was truncated", len(entries))}
+	}
+	if entries[seq-1].Hash != hash {
+		return &AuditError{Seq: seq, Reason: "entry does not match the recorded head"}
+	}
+	return nil
+}
+
+func newAuditExportCommand(client *ClientOptions) *cobra.Command {
+	var out string
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export the audit log with its checkpoints as JSON",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			export, err := exportAudit(ctx, api)
+			if err != nil {
+				return err
+			}
+			var w io.Writer = cmd.OutOrStdout()
+			if out != "" {
+				file, err := os.OpenFile(out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+				if err != nil {
+					return fmt.Errorf("failed to create audit export: %w", err)
+				}
+				defer file.Close()
+				w = file
+			}
+			return printJSON(w, export)
+		},
+	}
+	cmd.Flags().StringVar(&out, "out", "", "File to write the export to (default stdout)")
+	return cmd
+}
+
+func newAuditLogCommand(client *ClientOptions) *cobra.Command {
+	var from, limit uint64
+	var herd string
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "log",
+		Short: "List audit log entries",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			log, err := api.AuditLog(ctx, from, limit)
+			if err != nil {
+				return fmt.Errorf("failed to read the audit log: %w", err)
+			}
+			entries := log.Entries
+			if herd != "" {
+				entries = entries[:0]
+				for _, entry := range log.Entries {
+					if auditEntryTouches(entry, herd) {
+						entries = append(entries, entry)
+					}
+				}
+			}
+			if asJSON {
+				return printJSON(cmd.OutOrStdout(), entries)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "SEQ\tTIME\tACTOR\tACTION\tRESOURCE\tCHANGES")
+			for _, entry := range entries {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\n", entry.Seq, entry.Time.Format(time.RFC3339),
+					entry.Actor, entry.Action, orDash(entry.Resource), len(entry.Changes))
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().Uint64Var(&from, "from", 1, "Sequence number of the first entry")
+	cmd.Flags().Uint64Var(&limit, "limit", 100, "Maximum number of entries")
+	cmd.Flags().StringVar(&herd, "herd", "", "Only list entries about this herd")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print the entries as JSON")
+	return cmd
+}
+
+// auditEntryTouches reports whether an entry is about herd: its resource
+// or one of its changed keys names it
+func auditEntryTouches(entry AuditEntry, herd string) bool {
+	if strings.Contains(entry.Resource+"/", "/herds/"+herd+"/") {
+		return true
+	}
+	for _, change := range entry.Changes {
+		if strings.Contains("/"+change.Key+"/", "/"+herd+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func newAuditKeygenCommand() *cobra.Command {
+	var out string
+
+	cmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate a key for signing audit checkpoints",
+		Long: `Write a new Ed25519 audit key to --out and its public key to --out with a
+.pub extension, and print the public key. Copy the key file to every Barn
+member and give the public key to the auditors, who pin it in audit verify.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			seed, err := newKey()
+			if err != nil {
+				return err
+			}
+			defer zero(seed)
+			key, err := NewAuditKey(seed)
+			if err != nil {
+				return err
+			}
+			file, err := os.OpenFile(out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+			if err != nil {
+				return fmt.Errorf("failed to create audit key: %w", err)
+			}
+			if _, err := fmt.Fprintln(file, hex.EncodeToString(seed)); err != nil {
+				file.Close()
+				return fmt.Errorf("failed to write audit key: %w", err)
+			}
+			if err := file.Close(); err != nil {
+				return fmt.Errorf("failed to write audit key: %w", err)
+			}
+			public := hex.EncodeToString(key.PublicKey())
+			if err := os.WriteFile(strings.TrimSuffix(out, filepath.Ext(out))+".pub", []byte(public+"\n"), 0644); err != nil {
+				return fmt.Errorf("failed to write audit public key: %w", err)
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), public)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&out, "out", DefaultAuditKeyFile, "File to write the key to")
+	return cmd
+}
+
+// auditActor returns the actor recorded for an API request: its principal,
+// or without one the client's address
+func auditActor(r *http.Request) string {
+	if principal := PrincipalFrom(r.Context()); principal != nil {
+		return principal.Name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "addr:" + host
+}
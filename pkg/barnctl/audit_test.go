@@ -0,0 +1,257 @@
+package barnctl
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestAuditKey(t *testing.T, fill byte) *AuditKey {
+	t.Helper()
+	key, err := NewAuditKey(bytes.Repeat([]byte{fill}, 32))
+	if err != nil {
+		t.Fatalf("Failed to create audit key: %v", err)
+	}
+	return key
+}
+
+// TestAPIAudit tests that writes, secret reads and reported events are
+// chained into the audit log, identically on every member, and that the
+// log cannot be written to
+func TestAPIAudit(t *testing.T) {
+	c, clients := newTestAPI(t, 3)
+	leader := c.leader()
+	api := clients[leader]
+	store := c.stores[leader]
+	ctx := context.Background()
+
+	if _, err := api.CreateHerd(ctx, &Herd{Name: "finance"}); err != nil {
+		t.Fatalf("Failed to create herd: %v", err)
+	}
+	if _, err := api.PutSecret(ctx, "finance", "warehouse_password", []byte("hunter2")); err != nil {
+		t.Fatalf("Failed to put secret: %v", err)
+	}
+	if _, err := api.GetSecret(ctx, "finance", "warehouse_password", 0); err != nil {
+		t.Fatalf("Failed to get secret: %v", err)
+	}
+	err := api.RecordAudit(ctx, AuditEvent{Actor: "root", Action: "slice.start", Resource: "/api/v1/herds/finance/slices/ledger"})
+	if err != nil {
+		t.Fatalf("Failed to record audit event: %v", err)
+	}
+	if err := api.RecordAudit(ctx, AuditEvent{Action: "Slice Start", Resource: "x"}); err == nil {
+		t.Error("Expected an invalid action to be rejected")
+	} else {
+		expectAPIError(t, err, ErrInvalid, "action")
+	}
+
+	log, err := api.AuditLog(ctx, 1, 0)
+	if err != nil {
+		t.Fatalf("Failed to read the audit log: %v", err)
+	}
+	actions := make(map[string]AuditEntry)
+	for _, entry := range log.Entries {
+		actions[entry.Action] = entry
+	}
+	read, ok := actions["secret.read"]
+	if !ok || read.Resource != "/api/v1/herds/finance/secrets/warehouse_password" || len(read.Changes) != 0 {
+		t.Errorf("Expected the secret read to be audited, got %+v", log.Entries)
+	}
+	start, ok := actions["slice.start"]
+	if !ok || start.Actor != read.Actor || start.Actor == "root" {
+		t.Errorf("Expected the caller as actor of the reported event, got %+v", start)
+	}
+	create, ok := actions["POST"]
+	if !ok || create.Resource != "/api/v1/herds" || len(create.Changes) == 0 || create.Changes[0].Digest == "" {
+		t.Errorf("Expected the herd creation with its changes, got %+v", create)
+	}
+	if _, err := VerifyAuditLog(log.Entries, nil, nil); err != nil {
+		t.Errorf("Expected a valid chain, got %v", err)
+	}
+
+	// Every member applies the same chain
+	head, hash, _ := store.AuditHead()
+	last, _ := store.Get(auditEntryKey(head))
+	for id, member := range c.stores {
+		c.waitValue(auditEntryKey(head), string(last), id)
+		if seq, memberHash, _ := member.AuditHead(); seq != head || memberHash != hash {
+			t.Errorf("Expected head %d:%s on %s, got %d:%s", head, hash, id, seq, memberHash)
+		}
+	}
+
+	// Checkpoints sign the head, once
+	key := newTestAuditKey(t, 1)
+	checkpoint, err := store.Checkpoint(ctx, key)
+	if err != nil || checkpoint == nil || checkpoint.Seq != head {
+		t.Fatalf("Expected a checkpoint of entry %d, got %+v, %v", head, checkpoint, err)
+	}
+	if again, err := store.Checkpoint(ctx, key); err != nil || again != nil {
+		t.Errorf("Expected no checkpoint without new entries, got %+v, %v", again, err)
+	}
+	forged := *checkpoint
+	forged.Seq, forged.Hash = head+1, "sha256:00"
+	if _, err := store.propose(ctx, storeCommand{Checkpoint: &forged}); !errors.Is(err, ErrAuditTampered) {
+		t.Errorf("Expected a forged checkpoint to be rejected, got %v", err)
+	}
+
+	// The log is append-only
+	if err := store.Put(ctx, auditEntryKey(1), []byte("{}")); !errors.Is(err, ErrAuditAppendOnly) {
+		t.Errorf("Expected ErrAuditAppendOnly, got %v", err)
+	}
+	if err := store.Delete(ctx, auditEntryKey(1)); !errors.Is(err, ErrAuditAppendOnly) {
+		t.Errorf("Expected ErrAuditAppendOnly, got %v", err)
+	}
+
+	export, err := exportAudit(ctx, api)
+	if err != nil {
+		t.Fatalf("Failed to export the audit log: %v", err)
+	}
+	result, err := VerifyAuditLog(export.Entries, export.Checkpoints, key.PublicKey())
+	if err != nil {
+		t.Fatalf("Expected the export to verify, got %v", err)
+	}
+	if result.Checkpoints != 1 || result.Signed != head || result.Head != uint64(len(export.Entries)) {
+		t.Errorf("Unexpected verification %+v", result)
+	}
+	if _, err := VerifyAuditLog(export.Entries, export.Checkpoints, nil); !errors.Is(err, ErrAuditKeyRequired) {
+		t.Errorf("Expected checkpoints without a pinned key to fail, got %v", err)
+	}
+
+	// audit verify pins the key in --public-key-file, and fails on another
+	dir := t.TempDir()
+	data, _ := json.Marshal(export)
+	os.WriteFile(filepath.Join(dir, "export.json"), data, 0644)
+	os.WriteFile(filepath.Join(dir, "audit.pub"), []byte(hex.EncodeToString(key.PublicKey())+"\n"), 0644)
+	verify := func(args ...string) error {
+		cmd := newAuditVerifyCommand(&ClientOptions{})
+		cmd.SetArgs(append([]string{"--file", filepath.Join(dir, "export.json")}, args...))
+		cmd.SetOut(io.Discard)
+		cmd.SetErr(io.Discard)
+		return cmd.Execute()
+	}
+	if err := verify("--public-key-file", filepath.Join(dir, "audit.pub")); err != nil {
+		t.Errorf("Expected the export to verify against the key file, got %v", err)
+	}
+	if err := verify("--public-key", hex.EncodeToString(newTestAuditKey(t, 2).PublicKey())); !errors.Is(err, ErrAuditTampered) {
+		t.Errorf("Expected another key to fail, got %v", err)
+	}
+	if err := verify("--public-key-file", filepath.Join(dir, "missing.pub")); err == nil {
+		t.Error("Expected a missing key file to fail")
+	}
+
+	// The head survives a restore from a state machine snapshot
+	snapshot, err := store.Snapshot()
+	if err != nil {
+		t.Fatalf("Failed to snapshot: %v", err)
+	}
+	before, beforeHash, beforeCheckpoint := store.AuditHead()
+	if err := store.Restore(snapshot); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	if seq, hash, checkpoint := store.AuditHead(); seq != before || hash != beforeHash || checkpoint != beforeCheckpoint {
+		t.Errorf("Expected head %d:%s after a restore, got %d:%s", before, beforeHash, seq, hash)
+	}
+}
+
+// TestVerifyAuditLog tests that modified, removed and truncated entries
+// fail verification
+func TestVerifyAuditLog(t *testing.T) {
+	c := newTestCluster(t, 1)
+	store := c.stores[c.leader()]
+	ctx := context.Background()
+	key := newTestAuditKey(t, 1)
+
+	for _, herd := range []string{"a", "b", "c", "d"} {
+		if err := store.Put(WithAuditEvent(ctx, AuditEvent{Actor: "alice", Action: "herd.create"}), "herds/"+herd, []byte(herd)); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	if _, err := store.Checkpoint(ctx, key); err != nil {
+		t.Fatalf("Failed to checkpoint: %v", err)
+	}
+	c.put("herds/e", "e")
+
+	var entries []AuditEntry
+	for seq := uint64(1); ; seq++ {
+		value, ok := store.Get(auditEntryKey(seq))
+		if !ok {
+			break
+		}
+		entry := AuditEntry{}
+		if err := json.Unmarshal(value, &entry); err != nil {
+			t.Fatalf("Invalid entry: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	var checkpoints []AuditCheckpoint
+	for _, kv := range store.Range(auditCheckpointPrefix) {
+		checkpoint := AuditCheckpoint{}
+		if err := json.Unmarshal(kv.Value, &checkpoint); err != nil {
+			t.Fatalf("Invalid checkpoint: %v", err)
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	if len(entries) != 5 || len(checkpoints) != 1 || entries[4].Actor != "barn" {
+		t.Fatalf("Expected 5 entries and a checkpoint, got %+v, %+v", entries, checkpoints)
+	}
+	result, err := VerifyAuditLog(entries, checkpoints, key.PublicKey())
+	if err != nil || result.Signed != 4 || result.Head != 5 {
+		t.Fatalf("Expected a valid log signed up to 4, got %+v, %v", result, err)
+	}
+
+	expectTampered := func(name string, seq uint64, entries []AuditEntry, checkpoints []AuditCheckpoint) {
+		t.Helper()
+		_, err := VerifyAuditLog(entries, checkpoints, key.PublicKey())
+		var auditErr *AuditError
+		if !errors.As(err, &auditErr) || !errors.Is(err, ErrAuditTampered) || auditErr.Seq != seq {
+			t.Errorf("%s: expected tampering at entry %d, got %v", name, seq, err)
+		}
+	}
+	clone := func() []AuditEntry { return append([]AuditEntry(nil), entries...) }
+
+	modified := clone()
+	modified[1].Actor = "mallory"
+	expectTampered("modified entry", 2, modified, checkpoints)
+
+	rehashed := clone()
+	rehashed[1].Actor = "mallory"
+	rehashed[1].Hash = rehashed[1].digest()
+	expectTampered("rehashed entry", 3, rehashed, checkpoints)
+
+	removed := append(clone()[:2], entries[3:]...)
+	expectTampered("removed entry", 3, removed, checkpoints)
+
+	// Rewriting the whole chain only fails at the signed checkpoint
+	rewritten := clone()[:4]
+	rewritten[0].Actor = "mallory"
+	for i := range rewritten {
+		if i > 0 {
+			rewritten[i].PrevHash = rewritten[i-1].Hash
+		}
+		rewritten[i].Hash = rewritten[i].digest()
+	}
+	expectTampered("rewritten chain", 4, rewritten, checkpoints)
+
+	expectTampered("truncated log", 4, clone()[:3], checkpoints)
+
+	forged := append([]AuditCheckpoint(nil), checkpoints...)
+	forged[0].Seq = 5
+	expectTampered("forged checkpoint", 5, entries, forged)
+
+	other := newTestAuditKey(t, 2)
+	foreign := []AuditCheckpoint{*other.sign(entries[4].Seq, entries[4].Hash, time.Now().UTC())}
+	expectTampered("foreign key", 5, entries, foreign)
+
+	if err := checkAuditHead(entries[:4], "5:"+entries[4].Hash); !errors.Is(err, ErrAuditTampered) {
+		t.Errorf("Expected a recorded head beyond the log to fail, got %v", err)
+	}
+	if err := checkAuditHead(entries, "5:"+entries[4].Hash); err != nil {
+		t.Errorf("Expected the recorded head to match, got %v", err)
+	}
+}
@@ -6,76 +6,44 @@ import (
 
 // NewBarnctlCommand returns the barnctl root command.
 func NewBarnctlCommand() *cobra.Command {
+	client := &ClientOptions{}
 	cmd := &cobra.Command{
 		Use:   "barnctl",
 		Short: "Manage Herds, Storage, Registry, and Secrets",
 	}
+	client.addFlags(cmd)
 
 	cmd.AddCommand(
-		newHerdCreateCommand(),
-		newHerdDeleteCommand(),
-		newHerdUpdateCommand(),
-		newQuotaSetCommand(),
+		newServeCommand(),
+		newHerdCreateCommand(client),
+		newHerdGetCommand(client),
+		newHerdListCommand(client),
+		newHerdDeleteCommand(client),
+		newHerdUpdateCommand(client),
+		newQuotaSetCommand(client),
 		newLineageCommand(),
-		newSnapshotCommand(),
-		newSecretsPutCommand(),
-		newSecretsGetCommand(),
-		newSecretsListCommand(),
-		newSecretsDeleteCommand(),
-		newSecretsVersionCommand(),
-		newRuniCreateCommand(),
-		newRuniDeleteCommand(),
-		newRuniUpdateCommand(),
+		newSnapshotCommand(client),
+		newAuditCommand(client),
+		newRegistryCommand(client),
+		newSecretsPutCommand(client),
+		newSecretsGetCommand(client),
+		newSecretsListCommand(client),
+		newSecretsDeleteCommand(client),
+		newSecretsVersionCommand(client),
+		newReencryptCommand(client),
+		newRuniCreateCommand(client),
+		newRuniGetCommand(client),
+		newRuniListCommand(client),
+		newRuniDeleteCommand(client),
+		newRuniUpdateCommand(client),
+		newWatchCommand(client),
+		newTokenCommand(client),
 	)
 
 	return cmd
 }
 
 // Placeholder subcommands wiring
-func newHerdCreateCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "herd-create",
-		Short: "Create a new Herd (namespace + quotas + policies)",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// TODO: Implement Herd creation logic
-			return nil
-		},
-	}
-}
-
-func newHerdDeleteCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "herd-delete",
-		Short: "Delete a Herd",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// TODO: Implement Herd deletion logic
-			return nil
-		},
-	}
-}
-
-func newHerdUpdateCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "herd-update",
-		Short: "Update Herd quotas, RBAC, metadata",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// TODO: Implement Herd update logic
-			return nil
-		},
-	}
-}
-
-func newQuotaSetCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "quota-set",
-		Short: "Update Herd quotas live",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// TODO: Implement quota setting logic
-			return nil
-		},
-	}
-}
-
 func newLineageCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "lineage",
@@ -86,103 +54,3 @@ func newLineageCommand() *cobra.Command {
 		},
 	}
 }
-
-func newSnapshotCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "snapshot",
-		Short: "Create or manage Herd snapshots",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// TODO: Implement snapshot logic
-			return nil
-		},
-	}
-}
-
-func newSecretsPutCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "secrets-put",
-		Short: "Store a new secret",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// TODO: Implement secret put logic
-			return nil
-		},
-	}
-}
-
-func newSecretsGetCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "secrets-get",
-		Short: "Retrieve a stored secret",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// TODO: Implement secret get logic
-			return nil
-		},
-	}
-}
-
-func newSecretsListCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "secrets-list",
-		Short: "List available secrets",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// TODO: Implement secrets listing logic
-			return nil
-		},
-	}
-}
-
-func newSecretsDeleteCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "secrets-delete",
-		Short: "Delete a secret",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// TODO: Implement secret delete logic
-			return nil
-		},
-	}
-}
-
-func newSecretsVersionCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "secrets-version",
-		Short: "View secret version history",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// TODO: Implement secret versioning logic
-			return nil
-		},
-	}
-}
-
-func newRuniCreateCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "runi-create",
-		Short: "Create a Runi Slice",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// TODO: Implement runi slice create
-			return nil
-		},
-	}
-}
-
-func newRuniDeleteCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "runi-delete",
-		Short: "Delete a Runi Slice",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// TODO: Implement runi slice delete
-			return nil
-		},
-	}
-}
-
-func newRuniUpdateCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "runi-update",
-		Short: "Update a Runi Slice",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// TODO: Implement runi slice update
-			return nil
-		},
-	}
-}
-```}
@@ -0,0 +1,307 @@
+package barnctl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/runink/runictl"
+)
+
+// DefaultEndpoint is the Barn API of the local node
+const DefaultEndpoint = "http://127.0.0.1:8080"
+
+// Client calls the Barn REST API. Writes that reach a follower are
+// redirected to the leader by the server and repeated by the client.
+type Client struct {
+	Endpoint string
+	HTTP     *http.Client
+}
+
+// NewClient returns a client for the API at endpoint, a URL or host:port
+func NewClient(endpoint string) *Client {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	return &Client{Endpoint: strings.TrimSuffix(endpoint, "/"), HTTP: http.DefaultClient}
+}
+
+// APIError is a failed API request. It unwraps to ErrInvalid, ErrNotFound,
+// ErrConflict, ErrRevisionTooOld, ErrNotLeader, ErrUnauthenticated or
+// ErrForbidden according to its code.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Field      string
+	Revision   uint64
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func (e *APIError) Unwrap() error {
+	switch e.Code {
+	case CodeInvalid:
+		return ErrInvalid
+	case CodeNotFound:
+		return ErrNotFound
+	case CodeConflict:
+		return ErrConflict
+	case CodeRevisionTooOld:
+		return ErrRevisionTooOld
+	case CodeUnavailable:
+		return ErrNotLeader
+	case CodeUnauthenticated:
+		return ErrUnauthenticated
+	case CodeForbidden:
+		return ErrForbidden
+	}
+	return nil
+}
+
+// Status returns the Raft status of the node
+func (c *Client) Status(ctx context.Context) (*StatusResponse, error) {
+	status := &StatusResponse{}
+	return status, c.do(ctx, http.MethodGet, "/status", nil, nil, status)
+}
+
+// ListHerds returns the herds matching selector, "key=value,..." or empty
+func (c *Client) ListHerds(ctx context.Context, selector string) (*HerdList, error) {
+	query := url.Values{}
+	if selector != "" {
+		query.Set("selector", selector)
+	}
+	list := &HerdList{}
+	return list, c.do(ctx, http.MethodGet, "/herds", query, nil, list)
+}
+
+// GetHerd returns a herd as the leader sees it, for read-modify-write
+func (c *Client) GetHerd(ctx context.Context, name string) (*Herd, error) {
+	herd := &Herd{}
+	return herd, c.do(ctx, http.MethodGet, herdPath(name), consistent(), nil, herd)
+}
+
+func (c *Client) CreateHerd(ctx context.Context, herd *Herd) (*Herd, error) {
+	created := &Herd{}
+	return created, c.do(ctx, http.MethodPost, "/herds", nil, herd, created)
+}
+
+// UpdateHerd replaces a herd if it is still at herd.Revision
+func (c *Client) UpdateHerd(ctx context.Context, herd *Herd) (*Herd, error) {
+	updated := &Herd{}
+	return updated, c.do(ctx, http.MethodPut, herdPath(herd.Name), nil, herd, updated)
+}
+
+// DeleteHerd deletes a herd if it is at revision, or at any revision for 0
+func (c *Client) DeleteHerd(ctx context.Context, name string, revision uint64) error {
+	return c.do(ctx, http.MethodDelete, herdPath(name), revisionQuery(revision), nil, nil)
+}
+
+// SetHerdQuota changes the set fields of a herd's quota, at revision or at
+// the latest revision for 0
+func (c *Client) SetHerdQuota(ctx context.Context, name string, quota runictl.HerdQuota, revision uint64) (*Herd, error) {
+	herd := &Herd{}
+	return herd, c.do(ctx, http.MethodPatch, herdPath(name)+"/quota", revisionQuery(revision), quota, herd)
+}
+
+// ListSlices returns the slices of a herd matching selector
+func (c *Client) ListSlices(ctx context.Context, herd, selector string) (*SliceList, error) {
+	query := url.Values{}
+	if selector != "" {
+		query.Set("selector", selector)
+	}
+	list := &SliceList{}
+	return list, c.do(ctx, http.MethodGet, herdPath(herd)+"/slices", query, nil, list)
+}
+
+// GetSlice returns a slice as the leader sees it
+func (c *Client) GetSlice(ctx context.Context, herd, name string) (*Slice, error) {
+	slice := &Slice{}
+	return slice, c.do(ctx, http.MethodGet, slicePath(herd, name), consistent(), nil, slice)
+}
+
+func (c *Client) CreateSlice(ctx context.Context, slice *Slice) (*Slice, error) {
+	created := &Slice{}
+	return created, c.do(ctx, http.MethodPost, herdPath(slice.Herd)+"/slices", nil, slice, created)
+}
+
+// UpdateSlice replaces a slice if it is still at slice.Revision
+func (c *Client) UpdateSlice(ctx context.Context, slice *Slice) (*Slice, error) {
+	updated := &Slice{}
+	return updated, c.do(ctx, http.MethodPut, slicePath(slice.Herd, slice.Name), nil, slice, updated)
+}
+
+// DeleteSlice deletes a slice if it is at revision, or at any revision for 0
+func (c *Client) DeleteSlice(ctx context.Context, herd, name string, revision uint64) error {
+	return c.do(ctx, http.MethodDelete, slicePath(herd, name), revisionQuery(revision), nil, nil)
+}
+
+// ListSecrets returns the secrets of a herd without their values
+func (c *Client) ListSecrets(ctx context.Context, herd string) (*SecretList, error) {
+	list := &SecretList{}
+	return list, c.do(ctx, http.MethodGet, herdPath(herd)+"/secrets", nil, nil, list)
+}
+
+// GetSecret returns the value of a secret version as the leader sees it,
+// or of its latest version that is not deleted for 0
+func (c *Client) GetSecret(ctx context.Context, herd, name string, version uint32) (*SecretValue, error) {
+	query := consistent()
+	if version != 0 {
+		query.Set("version", strconv.FormatUint(uint64(version), 10))
+	}
+	value := &SecretValue{}
+	return value, c.do(ctx, http.MethodGet, secretPath(herd, name), query, nil, value)
+}
+
+// PutSecret stores value as the next version of a secret
+func (c *Client) PutSecret(ctx context.Context, herd, name string, value []byte) (*SecretVersionInfo, error) {
+	version := &SecretVersionInfo{}
+	return version, c.do(ctx, http.MethodPut, secretPath(herd, name), nil, SecretPut{Value: value}, version)
+}
+
+// SecretVersions returns the version history of a secret, oldest first
+func (c *Client) SecretVersions(ctx context.Context, herd, name string) (*SecretVersionList, error) {
+	list := &SecretVersionList{}
+	return list, c.do(ctx, http.MethodGet, secretPath(herd, name)+"/versions", consistent(), nil, list)
+}
+
+// DeleteSecret soft-deletes a secret version, or all versions for 0
+func (c *Client) DeleteSecret(ctx context.Context, herd, name string, version uint32) error {
+	var query url.Values
+	if version != 0 {
+		query = url.Values{"version": {strconv.FormatUint(uint64(version), 10)}}
+	}
+	return c.do(ctx, http.MethodDelete, secretPath(herd, name), query, nil, nil)
+}
+
+// Reencrypt rewraps the secrets of a herd with its current key-encryption
+// key, rotating the key first if rotate is set
+func (c *Client) Reencrypt(ctx context.Context, herd string, rotate bool) (*ReencryptResult, error) {
+	var query url.Values
+	if rotate {
+		query = url.Values{"rotate": {"true"}}
+	}
+	result := &ReencryptResult{}
+	return result, c.do(ctx, http.MethodPost, herdPath(herd)+"/reencrypt", query, nil, result)
+}
+
+// ListSnapshots returns the snapshots of a herd, oldest first
+func (c *Client) ListSnapshots(ctx context.Context, herd string) (*HerdSnapshotList, error) {
+	list := &HerdSnapshotList{}
+	return list, c.do(ctx, http.MethodGet, herdPath(herd)+"/snapshots", nil, nil, list)
+}
+
+// GetSnapshot returns a snapshot with the state it captured
+func (c *Client) GetSnapshot(ctx context.Context, herd, name string) (*HerdSnapshot, error) {
+	snapshot := &HerdSnapshot{}
+	return snapshot, c.do(ctx, http.MethodGet, snapshotPath(herd, name), consistent(), nil, snapshot)
+}
+
+// CreateSnapshot snapshots the current state of a herd. An empty name is
+// generated by the Barn.
+func (c *Client) CreateSnapshot(ctx context.Context, herd, name string) (*HerdSnapshot, error) {
+	snapshot := &HerdSnapshot{}
+	return snapshot, c.do(ctx, http.MethodPost, herdPath(herd)+"/snapshots", nil, HerdSnapshotCreate{Name: name}, snapshot)
+}
+
+// DeleteSnapshot deletes a snapshot
+func (c *Client) DeleteSnapshot(ctx context.Context, herd, name string) error {
+	return c.do(ctx, http.MethodDelete, snapshotPath(herd, name), nil, nil, nil)
+}
+
+// RestoreSnapshot restores a herd to a snapshot
+func (c *Client) RestoreSnapshot(ctx context.Context, herd, name string) (*RestoreResult, error) {
+	result := &RestoreResult{}
+	return result, c.do(ctx, http.MethodPost, snapshotPath(herd, name)+"/restore", nil, nil, result)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, result any) error {
+	target := c.Endpoint + APIPrefix + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	// A bytes.Reader body lets the client repeat it on redirects
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach barn at %s: %w", c.Endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return readAPIError(resp)
+	}
+	if result == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", req.URL, err)
+	}
+	return nil
+}
+
+// readAPIError reads the ErrorResponse of a failed request
+func readAPIError(resp *http.Response) *APIError {
+	failure := ErrorResponse{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxRequestBody)).Decode(&failure); err != nil || failure.Error == "" {
+		failure = ErrorResponse{Error: resp.Status}
+	}
+	return &APIError{
+		StatusCode: resp.StatusCode,
+		Code:       failure.Code,
+		Message:    failure.Error,
+		Field:      failure.Field,
+		Revision:   failure.Revision,
+	}
+}
+
+func herdPath(name string) string {
+	return "/herds/" + url.PathEscape(name)
+}
+
+func slicePath(herd, name string) string {
+	return herdPath(herd) + "/slices/" + url.PathEscape(name)
+}
+
+func secretPath(herd, name string) string {
+	return herdPath(herd) + "/secrets/" + url.PathEscape(name)
+}
+
+func snapshotPath(herd, name string) string {
+	return herdPath(herd) + "/snapshots/" + url.PathEscape(name)
+}
+
+func consistent() url.Values {
+	return url.Values{"consistent": {"true"}}
+}
+
+func revisionQuery(revision uint64) url.Values {
+	if revision == 0 {
+		return nil
+	}
+	return url.Values{"revision": {strconv.FormatUint(revision, 10)}}
+}
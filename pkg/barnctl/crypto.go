@@ -0,0 +1,155 @@
+package barnctl
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+const (
+	// AlgorithmAES256GCM is the cipher of secret values and of the keys
+	// wrapping them
+	AlgorithmAES256GCM = "AES256-GCM"
+
+	// keySize is the size of AES-256 keys
+	keySize = 32
+)
+
+// newKey returns a random AES-256 key
+func newKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return key, nil
+}
+
+// seal encrypts plaintext with AES-256-GCM under key. The result is the
+// random nonce followed by the ciphertext and its tag. The additional data
+// is authenticated but not stored, so the result only opens in the same
+// context, such as the same secret version.
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// open decrypts the result of seal. It fails with ErrDecrypt for the wrong
+// key or context, and for tampered ciphertexts.
+func open(key, sealed, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, fmt.Errorf("ciphertext too short: %w", ErrDecrypt)
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key has %d bytes, not %d", len(key), keySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// zero overwrites key material that is no longer needed
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// The contexts bound into the ciphertexts: a value only opens as the
+// version it was written as, a data key only under the KEK version that
+// wrapped it for that secret version, and a KEK only as its herd's.
+func valueAAD(herd, name string, version uint32) []byte {
+	return []byte(fmt.Sprintf("runink/secret/%s/%s/%d", herd, name, version))
+}
+
+func dataKeyAAD(herd, name string, version, kekVersion uint32) []byte {
+	return []byte(fmt.Sprintf("runink/dek/%s/%s/%d/kek/%d", herd, name, version, kekVersion))
+}
+
+func kekAAD(herd string, version uint32) []byte {
+	return []byte(fmt.Sprintf("runink/kek/%s/%d", herd, version))
+}
+
+// encryptSecret encrypts a value for version with a new data key, which is
+// wrapped by kek. The data key only exists in memory.
+func encryptSecret(kek *herdKEK, version *SecretVersion, value []byte) error {
+	dataKey, err := newKey()
+	if err != nil {
+		return err
+	}
+	defer zero(dataKey)
+
+	ciphertext, err := seal(dataKey, value, valueAAD(version.Herd, version.Name, version.Version))
+	if err != nil {
+		return err
+	}
+	wrapped, err := seal(kek.key, dataKey, dataKeyAAD(version.Herd, version.Name, version.Version, kek.version))
+	if err != nil {
+		return err
+	}
+	version.Algorithm = AlgorithmAES256GCM
+	version.Ciphertext = ciphertext
+	version.WrappedKey = wrapped
+	version.KEKVersion = kek.version
+	return nil
+}
+
+// decryptSecret unwraps the data key of version with kek, which must be
+// the KEK version that wrapped it, and decrypts the value
+func decryptSecret(kek *herdKEK, version *SecretVersion) ([]byte, error) {
+	if version.Algorithm != AlgorithmAES256GCM {
+		return nil, fmt.Errorf("secret %s/%s version %d: unknown algorithm %q: %w", version.Herd, version.Name, version.Version, version.Algorithm, ErrDecrypt)
+	}
+	dataKey, err := open(kek.key, version.WrappedKey, dataKeyAAD(version.Herd, version.Name, version.Version, kek.version))
+	if err != nil {
+		return nil, fmt.Errorf("secret %s/%s version %d: failed to unwrap data key: %w", version.Herd, version.Name, version.Version, err)
+	}
+	defer zero(dataKey)
+
+	value, err := open(dataKey, version.Ciphertext, valueAAD(version.Herd, version.Name, version.Version))
+	if err != nil {
+		return nil, fmt.Errorf("secret %s/%s version %d: %w", version.Herd, version.Name, version.Version, err)
+	}
+	return value, nil
+}
+
+// rewrapSecret moves the data key of version from the KEK old to the KEK
+// next. The ciphertext of the value is left as it is.
+func rewrapSecret(old, next *herdKEK, version *SecretVersion) error {
+	dataKey, err := open(old.key, version.WrappedKey, dataKeyAAD(version.Herd, version.Name, version.Version, old.version))
+	if err != nil {
+		return fmt.Errorf("secret %s/%s version %d: failed to unwrap data key: %w", version.Herd, version.Name, version.Version, err)
+	}
+	defer zero(dataKey)
+
+	wrapped, err := seal(next.key, dataKey, dataKeyAAD(version.Herd, version.Name, version.Version, next.version))
+	if err != nil {
+		return err
+	}
+	version.WrappedKey = wrapped
+	version.KEKVersion = next.version
+	return nil
+}
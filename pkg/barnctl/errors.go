@@ -0,0 +1,180 @@
+package barnctl
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotLeader is returned for writes sent to a node that is not the
+	// Raft leader, wrapped in a NotLeaderError
+	ErrNotLeader = errors.New("not the raft leader")
+
+	// ErrLeadershipLost is returned when the leader stepped down before a
+	// proposed entry was applied. The entry may still be committed.
+	ErrLeadershipLost = errors.New("raft leadership lost before the entry was applied")
+
+	// ErrShutdown is returned by a Raft node that has been shut down
+	ErrShutdown = errors.New("raft node is shut down")
+
+	// ErrUnreachable is returned by transports that cannot reach a peer
+	ErrUnreachable = errors.New("raft peer unreachable")
+
+	// ErrUnavailable is returned for log entries that are not in storage
+	ErrUnavailable = errors.New("raft log entry unavailable")
+
+	// ErrCompacted is returned for log entries replaced by a snapshot
+	ErrCompacted = errors.New("raft log entry compacted")
+
+	// ErrCorrupt is returned for WAL and snapshot files that fail their
+	// checksum anywhere but in a torn final write
+	ErrCorrupt = errors.New("barn storage is corrupt")
+
+	// ErrKeyNotFound is returned for keys that are not in the store
+	ErrKeyNotFound = errors.New("key not found")
+
+	// ErrNotFound is returned for herds and slices that do not exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrInvalid is returned for API requests that fail validation,
+	// wrapped in a ValidationError
+	ErrInvalid = errors.New("invalid request")
+
+	// ErrConflict is returned for transactions whose conditions failed,
+	// wrapped in a ConflictError
+	ErrConflict = errors.New("revision conflict")
+
+	// ErrRevisionTooOld is returned for watches from a revision whose
+	// events were compacted, wrapped in a RevisionTooOldError
+	ErrRevisionTooOld = errors.New("revision too old")
+
+	// ErrDecrypt is returned for secrets and keys that fail to decrypt:
+	// the wrong key, a tampered ciphertext or one moved to another secret
+	ErrDecrypt = errors.New("decryption failed")
+
+	// ErrSecretsDisabled is returned by the secrets API of a node started
+	// without a master key
+	ErrSecretsDisabled = errors.New("secrets are disabled: barn has no master key")
+
+	// ErrAuditAppendOnly is returned for transactions that write audit log
+	// keys, which only the store itself appends to
+	ErrAuditAppendOnly = errors.New("the audit log is append-only")
+
+	// ErrAuditTampered is returned for audit logs that fail verification,
+	// wrapped in an AuditError
+	ErrAuditTampered = errors.New("audit log tampered")
+
+	// ErrAuditKeyRequired is returned for verifying checkpoints without a
+	// pinned public key, as a forger can sign with a key of their own
+	ErrAuditKeyRequired = errors.New("audit checkpoints need a pinned public key to verify")
+
+	// ErrUnauthenticated is returned for API requests without valid
+	// credentials when RBAC is enforced
+	ErrUnauthenticated = errors.New("unauthenticated")
+
+	// ErrForbidden is returned for API requests the herd's policies do not
+	// allow, wrapped in a ForbiddenError
+	ErrForbidden = errors.New("permission denied")
+)
+
+// NotLeaderError is returned for writes sent to a follower. Leader is the
+// ID of the current leader, or empty while an election is in progress.
+type NotLeaderError struct {
+	Leader string
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.Leader == "" {
+		return "not the raft leader: no leader elected"
+	}
+	return fmt.Sprintf("not the raft leader: leader is %s", e.Leader)
+}
+
+func (e *NotLeaderError) Unwrap() error {
+	return ErrNotLeader
+}
+
+// ConflictError is returned for a transaction condition that failed on
+// Key. Revision is the key's current revision, if it exists.
+type ConflictError struct {
+	Key      string
+	Revision uint64
+	Reason   string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("revision conflict: %s %s", e.Key, e.Reason)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+// ValidationError is returned for an invalid field of an API request
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Message)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalid
+}
+
+// RevisionTooOldError is returned for a watch from Revision when the store
+// only keeps the events after Compacted. The watcher has to read the
+// current state and watch from the revision it was read at.
+type RevisionTooOldError struct {
+	Revision  uint64
+	Compacted uint64
+}
+
+func (e *RevisionTooOldError) Error() string {
+	return fmt.Sprintf("revision %d too old: events up to revision %d are compacted", e.Revision, e.Compacted)
+}
+
+func (e *RevisionTooOldError) Unwrap() error {
+	return ErrRevisionTooOld
+}
+
+// ForbiddenError is returned for a principal that may not take Action on
+// Resource in Herd. Reason is the policy decision, as herdctl explains it.
+type ForbiddenError struct {
+	Principal string
+	Herd      string
+	Action    string
+	Resource  string
+	Reason    string
+}
+
+func (e *ForbiddenError) Error() string {
+	if e.Action == "" {
+		return fmt.Sprintf("permission denied: %s", e.Reason)
+	}
+	target := e.Action
+	if e.Resource != "" {
+		target += " on " + e.Resource
+	}
+	return fmt.Sprintf("permission denied: %s may not %s in herd %s: %s", e.Principal, target, e.Herd, e.Reason)
+}
+
+func (e *ForbiddenError) Unwrap() error {
+	return ErrForbidden
+}
+
+// AuditError is returned for an audit log that breaks at entry Seq
+type AuditError struct {
+	Seq    uint64
+	Reason string
+}
+
+func (e *AuditError) Error() string {
+	return fmt.Sprintf("audit log tampered at entry %d: %s", e.Seq, e.Reason)
+}
+
+func (e *AuditError) Unwrap() error {
+	return ErrAuditTampered
+}
@@ -0,0 +1,468 @@
+package barnctl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/runink/runictl"
+)
+
+// maxUpdateAttempts bounds the read-modify-write retries of updates sent
+// without a revision, such as quota changes
+const maxUpdateAttempts = 5
+
+func (s *Server) getStatus(w http.ResponseWriter, r *http.Request, _ Params) {
+	writeJSON(w, http.StatusOK, StatusResponse{Raft: s.store.Raft().Status(), Revision: s.store.Revision()})
+}
+
+func (s *Server) listHerds(w http.ResponseWriter, r *http.Request, _ Params) {
+	if !s.prepareRead(w, r) {
+		return
+	}
+	selector, err := parseSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	list := HerdList{Items: []Herd{}, Revision: s.store.Revision()}
+	for _, kv := range s.store.Range(herdKeyPrefix) {
+		herd := Herd{}
+		if err := decodeResource(kv, &herd, &herd.Revision); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		if matchLabels(herd.Labels, selector) {
+			list.Items = append(list.Items, herd)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) getHerd(w http.ResponseWriter, r *http.Request, params Params) {
+	if !s.prepareRead(w, r) {
+		return
+	}
+	herd, err := s.readHerd(params["herd"])
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, herd)
+}
+
+func (s *Server) createHerd(w http.ResponseWriter, r *http.Request, _ Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	herd := &Herd{}
+	if err := decodeBody(r, herd); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if herd.Revision != 0 {
+		s.fail(w, r, &ValidationError{Field: "revision", Message: "must not be set on create"})
+		return
+	}
+	if err := validateHerd(herd); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	herd.CreatedAt = time.Now().UTC()
+	herd.UpdatedAt = herd.CreatedAt
+	key := herdKey(herd.Name)
+	if err := s.put(r.Context(), key, herd, &herd.Revision, IfRevision(key, 0)); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	w.Header().Set("Location", APIPrefix+"/herds/"+herd.Name)
+	writeJSON(w, http.StatusCreated, herd)
+}
+
+// updateHerd replaces a herd. The request must carry the revision it was
+// read at, so concurrent updates are not lost.
+func (s *Server) updateHerd(w http.ResponseWriter, r *http.Request, params Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	herd := &Herd{}
+	if err := decodeBody(r, herd); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if err := matchPathName(&herd.Name, params["herd"]); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if herd.Revision == 0 {
+		s.fail(w, r, &ValidationError{Field: "revision", Message: "is required for updates"})
+		return
+	}
+	if err := validateHerd(herd); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	current, err := s.readHerd(herd.Name)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	herd.CreatedAt = current.CreatedAt
+	herd.UpdatedAt = time.Now().UTC()
+	key := herdKey(herd.Name)
+	if err := s.put(r.Context(), key, herd, &herd.Revision, IfRevision(key, herd.Revision)); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, herd)
+}
+
+// deleteHerd removes a herd that has no slices left, together with its
+// secrets and key-encryption keys
+func (s *Server) deleteHerd(w http.ResponseWriter, r *http.Request, params Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	revision, err := queryRevision(r)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	name := params["herd"]
+	herd, err := s.readHerd(name)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if slices := s.store.List(slicePrefix(name)); len(slices) > 0 {
+		s.fail(w, r, &ConflictError{
+			Key:      herdKey(name),
+			Revision: herd.Revision,
+			Reason:   fmt.Sprintf("still has %d slices", len(slices)),
+		})
+		return
+	}
+
+	conditions := []Condition{revisionCondition(herdKey(name), revision), IfNoPrefix(slicePrefix(name))}
+	ops := []Op{OpDelete(herdKey(name))}
+	for _, prefix := range []string{secretPrefix(name), kekPrefix(name)} {
+		for _, kv := range s.store.Range(prefix) {
+			conditions = append(conditions, IfRevision(kv.Key, kv.ModRevision))
+			ops = append(ops, OpDelete(kv.Key))
+		}
+	}
+	if _, err := s.store.Txn(r.Context(), conditions, ops...); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// patchQuota changes the set fields of a herd's quota. Without a revision
+// in the query, the change is applied to the latest revision of the herd.
+func (s *Server) patchQuota(w http.ResponseWriter, r *http.Request, params Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	revision, err := queryRevision(r)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	update := runictl.HerdQuota{}
+	if err := decodeBody(r, &update); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if update == (runictl.HerdQuota{}) {
+		s.fail(w, r, &ValidationError{Field: "quota", Message: "at least one of cpu, memory, pids or io is required"})
+		return
+	}
+	if err := update.Validate(); err != nil {
+		s.fail(w, r, &ValidationError{Field: "quota", Message: err.Error()})
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		herd, err := s.readHerd(params["herd"])
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		expected := revision
+		if expected == 0 {
+			expected = herd.Revision
+		}
+		herd.Quota = herd.Quota.Merge(update)
+		herd.UpdatedAt = time.Now().UTC()
+
+		key := herdKey(herd.Name)
+		err = s.put(r.Context(), key, herd, &herd.Revision, IfRevision(key, expected))
+		if err == nil {
+			writeJSON(w, http.StatusOK, herd)
+			return
+		}
+		// Catch up with the write that got in between and try again
+		if revision == 0 && errors.Is(err, ErrConflict) && attempt < maxUpdateAttempts {
+			if err = s.store.Sync(r.Context()); err == nil {
+				continue
+			}
+		}
+		s.fail(w, r, err)
+		return
+	}
+}
+
+func (s *Server) listSlices(w http.ResponseWriter, r *http.Request, params Params) {
+	if !s.prepareRead(w, r) {
+		return
+	}
+	herd := params["herd"]
+	if _, err := s.readHerd(herd); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	selector, err := parseSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	list := SliceList{Items: []Slice{}, Revision: s.store.Revision()}
+	for _, kv := range s.store.Range(slicePrefix(herd)) {
+		slice := Slice{}
+		if err := decodeResource(kv, &slice, &slice.Revision); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		if matchLabels(slice.Labels, selector) && s.visible(r, herd, "read:pipelines", slice.Name) {
+			list.Items = append(list.Items, slice)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) getSlice(w http.ResponseWriter, r *http.Request, params Params) {
+	if !s.prepareRead(w, r) {
+		return
+	}
+	slice, err := s.readSlice(params["herd"], params["slice"])
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, slice)
+}
+
+func (s *Server) createSlice(w http.ResponseWriter, r *http.Request, params Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	slice := &Slice{}
+	if err := decodeBody(r, slice); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if slice.Revision != 0 {
+		s.fail(w, r, &ValidationError{Field: "revision", Message: "must not be set on create"})
+		return
+	}
+	herd, err := s.prepareSlice(slice, params["herd"])
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	if err := s.snapshotBeforeDeploy(r.Context(), herd, slice, nil); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	slice.CreatedAt = time.Now().UTC()
+	slice.UpdatedAt = slice.CreatedAt
+	key := sliceKey(herd.Name, slice.Name)
+	if err := s.put(r.Context(), key, slice, &slice.Revision, IfExists(herdKey(herd.Name)), IfRevision(key, 0)); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	w.Header().Set("Location", APIPrefix+"/herds/"+herd.Name+"/slices/"+slice.Name)
+	writeJSON(w, http.StatusCreated, slice)
+}
+
+func (s *Server) updateSlice(w http.ResponseWriter, r *http.Request, params Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	slice := &Slice{}
+	if err := decodeBody(r, slice); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if err := matchPathName(&slice.Name, params["slice"]); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if slice.Revision == 0 {
+		s.fail(w, r, &ValidationError{Field: "revision", Message: "is required for updates"})
+		return
+	}
+	herd, err := s.prepareSlice(slice, params["herd"])
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	current, err := s.readSlice(herd.Name, slice.Name)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	// Only snapshot before updates that can succeed
+	if current.Revision != slice.Revision {
+		s.fail(w, r, &ConflictError{
+			Key:      sliceKey(herd.Name, slice.Name),
+			Revision: current.Revision,
+			Reason:   fmt.Sprintf("is at revision %d, not %d", current.Revision, slice.Revision),
+		})
+		return
+	}
+	if err := s.snapshotBeforeDeploy(r.Context(), herd, slice, current); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	slice.CreatedAt = current.CreatedAt
+	slice.UpdatedAt = time.Now().UTC()
+	key := sliceKey(herd.Name, slice.Name)
+	if err := s.put(r.Context(), key, slice, &slice.Revision, IfExists(herdKey(herd.Name)), IfRevision(key, slice.Revision)); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, slice)
+}
+
+func (s *Server) deleteSlice(w http.ResponseWriter, r *http.Request, params Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	revision, err := queryRevision(r)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	key := sliceKey(params["herd"], params["slice"])
+	if _, err := s.readSlice(params["herd"], params["slice"]); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if _, err := s.store.Txn(r.Context(), []Condition{revisionCondition(key, revision)}, OpDelete(key)); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// prepareSlice validates a slice sent for herd and returns the herd
+func (s *Server) prepareSlice(slice *Slice, herdName string) (*Herd, error) {
+	if slice.Herd == "" {
+		slice.Herd = herdName
+	} else if slice.Herd != herdName {
+		return nil, &ValidationError{Field: "herd", Message: fmt.Sprintf("%q does not match the path", slice.Herd)}
+	}
+	if err := validateSlice(slice); err != nil {
+		return nil, err
+	}
+	herd, err := s.readHerd(herdName)
+	if err != nil {
+		return nil, err
+	}
+	return herd, validateSliceFits(slice, herd)
+}
+
+func (s *Server) readHerd(name string) (*Herd, error) {
+	kv, ok := s.store.Lookup(herdKey(name))
+	if !ok {
+		return nil, fmt.Errorf("herd %s: %w", name, ErrNotFound)
+	}
+	herd := &Herd{}
+	return herd, decodeResource(kv, herd, &herd.Revision)
+}
+
+func (s *Server) readSlice(herd, name string) (*Slice, error) {
+	kv, ok := s.store.Lookup(sliceKey(herd, name))
+	if !ok {
+		return nil, fmt.Errorf("slice %s/%s: %w", herd, name, ErrNotFound)
+	}
+	slice := &Slice{}
+	return slice, decodeResource(kv, slice, &slice.Revision)
+}
+
+// put stores a resource under key if the conditions hold. The resource is
+// stored without its revision, which is set to the revision of the write.
+func (s *Server) put(ctx context.Context, key string, resource any, revision *uint64, conditions ...Condition) error {
+	*revision = 0
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return err
+	}
+	*revision, err = s.store.Txn(ctx, conditions, OpPut(key, data))
+	return err
+}
+
+// decodeResource decodes a stored resource and sets its revision
+func decodeResource(kv KeyValue, resource any, revision *uint64) error {
+	if err := json.Unmarshal(kv.Value, resource); err != nil {
+		return fmt.Errorf("corrupt resource %s: %w", kv.Key, err)
+	}
+	*revision = kv.ModRevision
+	return nil
+}
+
+// decodeBody decodes a JSON request body, rejecting unknown fields
+func decodeBody(r *http.Request, v any) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return &ValidationError{Field: "body", Message: err.Error()}
+	}
+	return nil
+}
+
+// matchPathName fills in a resource name from the path, or checks that it
+// matches
+func matchPathName(name *string, path string) error {
+	if *name == "" {
+		*name = path
+	} else if *name != path {
+		return &ValidationError{Field: "name", Message: fmt.Sprintf("%q does not match the path", *name)}
+	}
+	return nil
+}
+
+// queryRevision returns the revision query parameter, or 0
+func queryRevision(r *http.Request) (uint64, error) {
+	value := r.URL.Query().Get("revision")
+	if value == "" {
+		return 0, nil
+	}
+	revision, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || revision == 0 {
+		return 0, &ValidationError{Field: "revision", Message: fmt.Sprintf("%q is not a revision", value)}
+	}
+	return revision, nil
+}
+
+// revisionCondition requires key at revision, or only that it exists for 0
+func revisionCondition(key string, revision uint64) Condition {
+	if revision == 0 {
+		return IfExists(key)
+	}
+	return IfRevision(key, revision)
+}
@@ -0,0 +1,245 @@
+package barnctl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/runink/runictl"
+)
+
+// newTestAPI serves the API of every member of a test cluster and returns
+// a client per member
+func newTestAPI(t *testing.T, size int) (*testCluster, map[string]*Client) {
+	c := newTestCluster(t, size)
+
+	// The servers redirect to each other, so their addresses are the peers
+	servers := make(map[string]*httptest.Server)
+	var peers []Peer
+	for _, peer := range c.peers {
+		server := httptest.NewUnstartedServer(nil)
+		servers[peer.ID] = server
+		peers = append(peers, Peer{ID: peer.ID, Addr: server.Listener.Addr().String()})
+	}
+	clients := make(map[string]*Client)
+	for id, server := range servers {
+		config := ServerConfig{Store: c.stores[id], Peers: peers, WatchProgress: testWatchProgress, MasterKey: testMasterKey}
+		server.Config.Handler = NewServer(config).Handler()
+		server.Start()
+		t.Cleanup(server.Close)
+		clients[id] = NewClient(server.URL)
+	}
+	return c, clients
+}
+
+func expectAPIError(t *testing.T, err error, target error, field string) *APIError {
+	t.Helper()
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !errors.Is(err, target) {
+		t.Fatalf("Expected %v, got %v", target, err)
+	}
+	if apiErr.Field != field {
+		t.Errorf("Expected error for field %q, got %q", field, apiErr.Field)
+	}
+	return apiErr
+}
+
+// TestAPIHerds tests herd CRUD, revision checks and quota updates
+func TestAPIHerds(t *testing.T) {
+	c, clients := newTestAPI(t, 1)
+	api := clients[c.leader()]
+	ctx := context.Background()
+
+	herd, err := api.CreateHerd(ctx, &Herd{
+		Name:   "finance",
+		Labels: map[string]string{"team": "fin"},
+		Quota:  runictl.HerdQuota{CPU: "8000m", Memory: "16Gi"},
+		RBAC:   []RoleBinding{{Role: "admin", Subjects: []string{"user:alice"}}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create herd: %v", err)
+	}
+	if herd.Revision == 0 || herd.CreatedAt.IsZero() {
+		t.Errorf("Expected revision and creation time, got %+v", herd)
+	}
+	if _, err := api.CreateHerd(ctx, &Herd{Name: "finance"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected conflict for duplicate herd, got %v", err)
+	}
+	if _, err := api.CreateHerd(ctx, &Herd{Name: "Finance"}); err != nil {
+		expectAPIError(t, err, ErrInvalid, "name")
+	} else {
+		t.Error("Expected invalid name to be rejected")
+	}
+	if _, err := api.CreateHerd(ctx, &Herd{Name: "ops", Labels: map[string]string{"team": "ops"}}); err != nil {
+		t.Fatalf("Failed to create herd: %v", err)
+	}
+
+	list, err := api.ListHerds(ctx, "team=fin")
+	if err != nil {
+		t.Fatalf("Failed to list herds: %v", err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != "finance" {
+		t.Errorf("Expected only finance for team=fin, got %+v", list.Items)
+	}
+
+	// An update at a stale revision conflicts and reports the current one
+	stale := *herd
+	herd.Labels["tier"] = "gold"
+	updated, err := api.UpdateHerd(ctx, herd)
+	if err != nil {
+		t.Fatalf("Failed to update herd: %v", err)
+	}
+	if updated.Revision <= herd.Revision || !updated.CreatedAt.Equal(herd.CreatedAt) {
+		t.Errorf("Expected a new revision and the same creation time, got %+v", updated)
+	}
+	stale.Labels = map[string]string{"team": "other"}
+	_, err = api.UpdateHerd(ctx, &stale)
+	if apiErr := expectAPIError(t, err, ErrConflict, ""); apiErr.Revision != updated.Revision {
+		t.Errorf("Expected current revision %d in conflict, got %d", updated.Revision, apiErr.Revision)
+	}
+	if _, err := api.UpdateHerd(ctx, &Herd{Name: "finance"}); err != nil {
+		expectAPIError(t, err, ErrInvalid, "revision")
+	} else {
+		t.Error("Expected update without revision to be rejected")
+	}
+
+	// Quota updates merge into the latest revision unless one is given
+	herd, err = api.SetHerdQuota(ctx, "finance", runictl.HerdQuota{Memory: "32Gi"}, 0)
+	if err != nil {
+		t.Fatalf("Failed to set quota: %v", err)
+	}
+	if herd.Quota != (runictl.HerdQuota{CPU: "8000m", Memory: "32Gi"}) {
+		t.Errorf("Expected merged quota, got %+v", herd.Quota)
+	}
+	if _, err := api.SetHerdQuota(ctx, "finance", runictl.HerdQuota{CPU: "1"}, updated.Revision); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected conflict for quota at stale revision, got %v", err)
+	}
+	if _, err := api.SetHerdQuota(ctx, "finance", runictl.HerdQuota{Memory: "lots"}, 0); err != nil {
+		expectAPIError(t, err, ErrInvalid, "quota")
+	} else {
+		t.Error("Expected invalid quota to be rejected")
+	}
+
+	if err := api.DeleteHerd(ctx, "ops", 0); err != nil {
+		t.Fatalf("Failed to delete herd: %v", err)
+	}
+	if _, err := api.GetHerd(ctx, "ops"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected deleted herd to be gone, got %v", err)
+	}
+}
+
+// TestAPISlices tests slice CRUD within a herd's quota
+func TestAPISlices(t *testing.T) {
+	c, clients := newTestAPI(t, 1)
+	api := clients[c.leader()]
+	ctx := context.Background()
+
+	if _, err := api.CreateHerd(ctx, &Herd{Name: "finance", Quota: runictl.HerdQuota{CPU: "4", Memory: "8Gi"}}); err != nil {
+		t.Fatalf("Failed to create herd: %v", err)
+	}
+	if _, err := api.CreateSlice(ctx, &Slice{Herd: "missing", Name: "ledger", Scenario: "ledger.dsl"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected not found for slice of missing herd, got %v", err)
+	}
+	if _, err := api.CreateSlice(ctx, &Slice{Herd: "finance", Name: "ledger", Scenario: "ledger.dsl",
+		Resources: runictl.HerdQuota{Memory: "16Gi"}}); err != nil {
+		expectAPIError(t, err, ErrInvalid, "resources.memory")
+	} else {
+		t.Error("Expected slice above the herd quota to be rejected")
+	}
+
+	slice, err := api.CreateSlice(ctx, &Slice{Herd: "finance", Name: "ledger", Scenario: "ledger.dsl",
+		Resources: runictl.HerdQuota{CPU: "2000m"}, Env: map[string]string{"REGION": "eu"}})
+	if err != nil {
+		t.Fatalf("Failed to create slice: %v", err)
+	}
+
+	// A herd with slices is not deleted
+	if err := api.DeleteHerd(ctx, "finance", 0); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected conflict deleting herd with slices, got %v", err)
+	}
+
+	slice.Node = "node-1"
+	updated, err := api.UpdateSlice(ctx, slice)
+	if err != nil {
+		t.Fatalf("Failed to update slice: %v", err)
+	}
+	if got, err := api.GetSlice(ctx, "finance", "ledger"); err != nil || got.Node != "node-1" || got.Revision != updated.Revision {
+		t.Errorf("Expected updated slice at revision %d, got %+v, %v", updated.Revision, got, err)
+	}
+	list, err := api.ListSlices(ctx, "finance", "")
+	if err != nil || len(list.Items) != 1 {
+		t.Fatalf("Expected one slice, got %+v, %v", list, err)
+	}
+
+	if err := api.DeleteSlice(ctx, "finance", "ledger", slice.Revision); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected conflict deleting slice at stale revision, got %v", err)
+	}
+	if err := api.DeleteSlice(ctx, "finance", "ledger", updated.Revision); err != nil {
+		t.Fatalf("Failed to delete slice: %v", err)
+	}
+	if err := api.DeleteHerd(ctx, "finance", 0); err != nil {
+		t.Errorf("Failed to delete empty herd: %v", err)
+	}
+}
+
+// TestAPIFollowers tests that followers redirect writes and consistent
+// reads to the leader
+func TestAPIFollowers(t *testing.T) {
+	c, clients := newTestAPI(t, 3)
+	leader := c.leader()
+	var follower string
+	for id := range clients {
+		if id != leader {
+			follower = id
+			break
+		}
+	}
+	ctx := context.Background()
+
+	api := clients[follower]
+	herd, err := api.CreateHerd(ctx, &Herd{Name: "finance"})
+	if err != nil {
+		t.Fatalf("Failed to create herd through a follower: %v", err)
+	}
+	herd.Labels = map[string]string{"team": "fin"}
+	if _, err := api.UpdateHerd(ctx, herd); err != nil {
+		t.Fatalf("Failed to update herd through a follower: %v", err)
+	}
+	got, err := api.GetHerd(ctx, "finance")
+	if err != nil || got.Labels["team"] != "fin" {
+		t.Errorf("Expected consistent read of the update, got %+v, %v", got, err)
+	}
+}
+
+// TestAPIRouting tests the answers to unknown routes and malformed bodies
+func TestAPIRouting(t *testing.T) {
+	c, clients := newTestAPI(t, 1)
+	endpoint := clients[c.leader()].Endpoint
+
+	for _, test := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodGet, "/api/v1/nothing", "", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/herds/finance", "{}", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/v1/herds", `{"name": "finance", "owner": "alice"}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/herds", `{"name": "finance"`, http.StatusBadRequest},
+		{http.MethodDelete, "/api/v1/herds/finance?revision=x", "", http.StatusBadRequest},
+	} {
+		req, _ := http.NewRequest(test.method, endpoint+test.path, strings.NewReader(test.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", test.method, test.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%s %s: expected %d, got %d", test.method, test.path, test.status, resp.StatusCode)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s %s: expected JSON error, got %s", test.method, test.path, ct)
+		}
+	}
+}
@@ -1,20 +1,38 @@
 package barnctl
 
 import (
-    "github.com/spf13/cobra"
+	"fmt"
+
+	"github.com/spf13/cobra"
 )
 
-func newCreateCommand() *cobra.Command {
-    cmd := &cobra.Command{
-        Use:   "create [name]",
-        Short: "Create a new herd",
-        RunE: func(cmd *cobra.Command, args []string) error {
-            if len(args) < 1 {
-                return fmt.Errorf("herd name is required")
-            }
-            // TODO: Implement herd creation logic
-            return nil
-        },
-    }
-    return cmd
-}
\ No newline at end of file
+func newHerdCreateCommand(client *ClientOptions) *cobra.Command {
+	options := &HerdOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "herd-create NAME",
+		Short: "Create a new Herd (namespace + quotas + policies)",
+		Long: `Create a Herd through the Barn API. Slices of the herd run within its
+quota, and its role bindings decide who may manage it.`,
+		Example: `  barnctl herd-create finance --cpu 20000m --memory 64Gi --label team=fin \
+    --bind admin=user:alice,group:fin-leads --bind viewer=group:analysts`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			herd := &Herd{Name: args[0]}
+			if err := options.apply(herd); err != nil {
+				return err
+			}
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			created, err := api.CreateHerd(ctx, herd)
+			if err != nil {
+				return fmt.Errorf("failed to create herd %s: %w", herd.Name, err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Created herd %s (revision %d)\n", created.Name, created.Revision)
+			return nil
+		},
+	}
+	options.addFlags(cmd)
+	return cmd
+}
@@ -0,0 +1,32 @@
+package barnctl
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newHerdDeleteCommand(client *ClientOptions) *cobra.Command {
+	var revision uint64
+
+	cmd := &cobra.Command{
+		Use:   "herd-delete NAME",
+		Short: "Delete a Herd",
+		Long: `Delete a Herd through the Barn API. A herd that still has slices is not
+deleted; delete them with runi-delete first. The herd's secrets and their
+keys are deleted with it.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			if err := api.DeleteHerd(ctx, args[0], revision); err != nil {
+				return fmt.Errorf("failed to delete herd %s: %w", args[0], err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Deleted herd %s\n", args[0])
+			return nil
+		},
+	}
+	cmd.Flags().Uint64Var(&revision, "revision", 0, "Only delete the herd if it is still at this revision")
+	return cmd
+}
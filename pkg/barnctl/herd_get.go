@@ -0,0 +1,54 @@
+package barnctl
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func newHerdGetCommand(client *ClientOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "herd-get NAME",
+		Short: "Show a Herd as JSON",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			herd, err := api.GetHerd(ctx, args[0])
+			if err != nil {
+				return fmt.Errorf("failed to get herd %s: %w", args[0], err)
+			}
+			return printJSON(cmd.OutOrStdout(), herd)
+		},
+	}
+}
+
+func newHerdListCommand(client *ClientOptions) *cobra.Command {
+	var selector string
+
+	cmd := &cobra.Command{
+		Use:   "herd-list",
+		Short: "List Herds",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			list, err := api.ListHerds(ctx, selector)
+			if err != nil {
+				return fmt.Errorf("failed to list herds: %w", err)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tCPU\tMEMORY\tPIDS\tLABELS\tREVISION")
+			for _, herd := range list.Items {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n", herd.Name, orDash(herd.Quota.CPU), orDash(herd.Quota.Memory),
+					orDash(herd.Quota.Pids), formatLabels(herd.Labels), herd.Revision)
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().StringVarP(&selector, "selector", "l", "", "Only list herds with these labels, e.g. team=fin,tier=gold")
+	return cmd
+}
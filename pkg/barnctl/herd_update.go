@@ -0,0 +1,123 @@
+package barnctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/runink/runictl"
+)
+
+func newHerdUpdateCommand(client *ClientOptions) *cobra.Command {
+	options := &HerdOptions{}
+	var revision uint64
+
+	cmd := &cobra.Command{
+		Use:   "herd-update NAME",
+		Short: "Update Herd quotas, RBAC, metadata",
+		Long: `Update a Herd through the Barn API. The herd is read, the flags are
+applied to it, and it is written back at the revision it was read at. If
+another update got in between, the update is retried on the new revision,
+or fails with a conflict when --revision is given.`,
+		Example: `  barnctl herd-update finance --label tier=gold --label legacy-
+  barnctl herd-update finance --bind admin=user:bob --unbind viewer --revision 42`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if options.empty() {
+				return fmt.Errorf("nothing to update: set labels, quotas or role bindings")
+			}
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			var updated *Herd
+			err := retryConflicts(ctx, revision, func() error {
+				herd, err := api.GetHerd(ctx, args[0])
+				if err != nil {
+					return err
+				}
+				if revision != 0 {
+					herd.Revision = revision
+				}
+				if err := options.apply(herd); err != nil {
+					return err
+				}
+				updated, err = api.UpdateHerd(ctx, herd)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("failed to update herd %s: %w", args[0], err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Updated herd %s (revision %d)\n", updated.Name, updated.Revision)
+			return nil
+		},
+	}
+	options.addFlags(cmd)
+	cmd.Flags().Uint64Var(&revision, "revision", 0, "Only update the herd if it is still at this revision")
+	return cmd
+}
+
+func newQuotaSetCommand(client *ClientOptions) *cobra.Command {
+	var herd string
+	var quota runictl.HerdQuota
+	var revision uint64
+	var local bool
+
+	cmd := &cobra.Command{
+		Use:   "quota-set",
+		Short: "Update Herd quotas live",
+		Long: `Update a Herd's quotas through the Barn API. Only the given limits
+change; the others keep their value. Nodes apply the new limits to the
+Herd's cgroup (/sys/fs/cgroup/runink/<herd>), where they take effect for
+running slices immediately. With --local the limits are only written to
+the cgroup of this node, bypassing the Barn. Quantities use Kubernetes
+notation, e.g. 20000m, 64Gi.`,
+		Example: `  barnctl quota-set --herd finance --cpu 16000m --memory 48Gi
+  barnctl quota-set --herd finance --pids 4096 --io "8:0 rbps=500Mi wbps=200Mi"
+  barnctl quota-set --herd finance --cpu 8000m --local`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if quota == (runictl.HerdQuota{}) {
+				return fmt.Errorf("at least one of --cpu, --memory, --pids or --io is required")
+			}
+			if local {
+				if err := runictl.ApplyHerdQuota(herd, quota); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Updated quotas for herd %s on this node\n", herd)
+				return nil
+			}
+
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+			updated, err := api.SetHerdQuota(ctx, herd, quota, revision)
+			if err != nil {
+				return fmt.Errorf("failed to update quotas for herd %s: %w", herd, err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Updated quotas for herd %s (revision %d)\n", herd, updated.Revision)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&herd, "herd", "", "Herd whose quotas to update")
+	addQuotaFlags(cmd, &quota)
+	cmd.Flags().Uint64Var(&revision, "revision", 0, "Only update the quotas if the herd is still at this revision")
+	cmd.Flags().BoolVar(&local, "local", false, "Write the limits to this node's cgroup instead of the Barn")
+	cmd.MarkFlagRequired("herd")
+
+	return cmd
+}
+
+// retryConflicts runs a read-modify-write until it does not conflict with
+// a concurrent update. With a pinned revision, a conflict is returned.
+func retryConflicts(ctx context.Context, revision uint64, update func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := update()
+		if err == nil || revision != 0 || !errors.Is(err, ErrConflict) || attempt == maxUpdateAttempts {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
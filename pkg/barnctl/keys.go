@@ -0,0 +1,184 @@
+package barnctl
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultMasterKeyFile holds the master key of a Barn node. Every member
+// of a cluster needs the same key.
+const DefaultMasterKeyFile = "/etc/barn/master.key"
+
+// MasterKey wraps the key-encryption keys of the herds. It never leaves
+// the Barn nodes: the store, its WAL and snapshots only hold KEKs wrapped
+// by it, and data keys wrapped by the KEKs.
+type MasterKey struct {
+	key []byte
+}
+
+// NewMasterKey returns a master key for 32 bytes of key material
+func NewMasterKey(key []byte) (*MasterKey, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("master key has %d bytes, not %d", len(key), keySize)
+	}
+	return &MasterKey{key: append([]byte(nil), key...)}, nil
+}
+
+// LoadMasterKey reads a master key file holding 64 hex digits, as written
+// by `head -c 32 /dev/urandom | xxd -p -c 64`
+func LoadMasterKey(path string) (*MasterKey, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master key: %w", err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		fmt.Fprintf(os.Stderr, "Warning: master key %s is accessible to other users (mode %v)\n", path, info.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master key: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key %s: %w", path, err)
+	}
+	defer zero(key)
+	return NewMasterKey(key)
+}
+
+// KeyRecord is a herd's key-encryption key as stored in Barn, wrapped by
+// the master key. A herd's current KEK is its latest version; older
+// versions are kept until no secret version uses them.
+type KeyRecord struct {
+	Herd      string    `json:"herd"`
+	Version   uint32    `json:"version"`
+	Algorithm string    `json:"algorithm"`
+	Wrapped   []byte    `json:"wrapped"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// herdKEK is an unwrapped key-encryption key
+type herdKEK struct {
+	herd      string
+	version   uint32
+	key       []byte
+	createdAt time.Time
+
+	// revision is the store revision of the KeyRecord
+	revision uint64
+}
+
+// keyRing creates, unwraps and destroys the KEKs of the herds
+type keyRing struct {
+	store  *Store
+	master *MasterKey
+}
+
+// records returns the KEK records of a herd, oldest first
+func (k *keyRing) records(herd string) ([]KeyRecord, []uint64, error) {
+	var records []KeyRecord
+	var revisions []uint64
+	for _, kv := range k.store.Range(kekPrefix(herd)) {
+		record := KeyRecord{}
+		if err := json.Unmarshal(kv.Value, &record); err != nil {
+			return nil, nil, fmt.Errorf("corrupt key %s: %w", kv.Key, err)
+		}
+		records = append(records, record)
+		revisions = append(revisions, kv.ModRevision)
+	}
+	return records, revisions, nil
+}
+
+// current returns the current KEK of a herd, or nil if it has none yet
+func (k *keyRing) current(herd string) (*herdKEK, error) {
+	records, revisions, err := k.records(herd)
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	last := len(records) - 1
+	return k.unwrap(records[last], revisions[last])
+}
+
+// get returns a version of a herd's KEK
+func (k *keyRing) get(herd string, version uint32) (*herdKEK, error) {
+	kv, ok := k.store.Lookup(kekKey(herd, version))
+	if !ok {
+		return nil, fmt.Errorf("key-encryption key %d of herd %s: %w", version, herd, ErrNotFound)
+	}
+	record := KeyRecord{}
+	if err := json.Unmarshal(kv.Value, &record); err != nil {
+		return nil, fmt.Errorf("corrupt key %s: %w", kv.Key, err)
+	}
+	return k.unwrap(record, kv.ModRevision)
+}
+
+func (k *keyRing) unwrap(record KeyRecord, revision uint64) (*herdKEK, error) {
+	key, err := open(k.master.key, record.Wrapped, kekAAD(record.Herd, record.Version))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap key-encryption key %d of herd %s: %w", record.Version, record.Herd, err)
+	}
+	return &herdKEK{herd: record.Herd, version: record.Version, key: key, createdAt: record.CreatedAt, revision: revision}, nil
+}
+
+// ensure returns the current KEK of a herd, creating the first one
+func (k *keyRing) ensure(ctx context.Context, herd string) (*herdKEK, error) {
+	kek, err := k.current(herd)
+	if err != nil || kek != nil {
+		return kek, err
+	}
+	kek, err = k.rotate(ctx, herd)
+	if errors.Is(err, ErrConflict) {
+		// Another request created it first
+		if err := k.store.Sync(ctx); err != nil {
+			return nil, err
+		}
+		return k.current(herd)
+	}
+	return kek, err
+}
+
+// rotate creates a new KEK version for a herd, which becomes the current
+// one. Secret versions wrapped by older KEKs stay readable until the
+// reencrypt job moved them to the new one.
+func (k *keyRing) rotate(ctx context.Context, herd string) (*herdKEK, error) {
+	records, _, err := k.records(herd)
+	if err != nil {
+		return nil, err
+	}
+	version := uint32(1)
+	if len(records) > 0 {
+		version = records[len(records)-1].Version + 1
+	}
+
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(k.master.key, key, kekAAD(herd, version))
+	if err != nil {
+		return nil, err
+	}
+	record := KeyRecord{Herd: herd, Version: version, Algorithm: AlgorithmAES256GCM, Wrapped: wrapped, CreatedAt: time.Now().UTC()}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	conditions := []Condition{IfExists(herdKey(herd)), IfRevision(kekKey(herd, version), 0)}
+	revision, err := k.store.Txn(ctx, conditions, OpPut(kekKey(herd, version), data))
+	if err != nil {
+		return nil, err
+	}
+	return &herdKEK{herd: herd, version: version, key: key, createdAt: record.CreatedAt, revision: revision}, nil
+}
+
+// currentCondition holds while kek is the current KEK of its herd, so
+// no secret version is written under a KEK that was rotated meanwhile
+func currentCondition(kek *herdKEK) Condition {
+	return IfRevision(kekKey(kek.herd, kek.version+1), 0)
+}
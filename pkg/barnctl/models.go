@@ -0,0 +1,193 @@
+package barnctl
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/runink/herdctl"
+	"github.com/runink/runictl"
+)
+
+// Herd is a tenant namespace. Its slices run under its quotas; its role
+// bindings grant users and groups roles, whose actions its policies allow
+// or deny.
+type Herd struct {
+	Name     string            `json:"name"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Quota    runictl.HerdQuota `json:"quota"`
+	RBAC     []RoleBinding     `json:"rbac,omitempty"`
+	Policies []herdctl.Policy  `json:"policies,omitempty"`
+	Secrets  SecretsScope      `json:"secretsScope"`
+	Snapshot SnapshotPolicy    `json:"snapshotPolicy"`
+	Revision uint64            `json:"revision,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// RoleBinding grants a role in a herd to subjects such as "user:alice" or
+// "group:data-eng"
+type RoleBinding struct {
+	Role     string   `json:"role"`
+	Subjects []string `json:"subjects"`
+}
+
+// SecretsScope is a herd's policy for its secrets, the [herd.secrets_scope]
+// section of a .herd file
+type SecretsScope struct {
+	// AllowCrossHerd lets slices of other herds read the herd's secrets
+	AllowCrossHerd bool `json:"allowCrossHerd,omitempty"`
+
+	// RotationPolicy is the age at which the herd's key-encryption key is
+	// rotated, as in "90d" or "720h". Empty never rotates it.
+	RotationPolicy string `json:"rotationPolicy,omitempty"`
+
+	// Encryption is the cipher of the herd's secrets. Only EncryptionAES256
+	// is supported, which is also the default.
+	Encryption string `json:"encryption,omitempty"`
+
+	MinimumTLSVersion    string   `json:"minimumTLSVersion,omitempty"`
+	ApprovedKeySigners   []string `json:"approvedKeySigners,omitempty"`
+	TokenLifetimeSeconds int      `json:"tokenLifetimeSeconds,omitempty"`
+}
+
+// SnapshotPolicy is when a herd's state is snapshotted automatically and
+// how long its snapshots are kept, as set by the retention_policy,
+// compliance_requirements and default_contract_policy of a .herd file
+type SnapshotPolicy struct {
+	// RetentionDays is how long snapshots are kept. The latest snapshot of
+	// a herd is always kept; 0 keeps them all.
+	RetentionDays int `json:"retentionDays,omitempty"`
+
+	// OnContractChange snapshots the herd before a slice starts enforcing
+	// another contract
+	OnContractChange bool `json:"onContractChange,omitempty"`
+
+	// OnDeploy snapshots the herd before a slice is created or updated
+	OnDeploy bool `json:"onDeploy,omitempty"`
+}
+
+// EncryptionAES256 encrypts secrets with AES-256-GCM
+const EncryptionAES256 = "AES256"
+
+// Slice is the desired state of a Runi slice: a scenario run in a herd,
+// optionally pinned to a node, within the herd's quota. Contract names the
+// data contract the scenario enforces, such as "contracts/orders.go@v3".
+type Slice struct {
+	Herd      string            `json:"herd"`
+	Name      string            `json:"name"`
+	Scenario  string            `json:"scenario"`
+	Contract  string            `json:"contract,omitempty"`
+	Node      string            `json:"node,omitempty"`
+	Resources runictl.HerdQuota `json:"resources"`
+	Env       map[string]string `json:"env,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Revision  uint64            `json:"revision,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Store keys of the API resources
+const (
+	herdKeyPrefix   = "herds/"
+	sliceKeyPrefix  = "slices/"
+	secretKeyPrefix = "secrets/"
+	kekKeyPrefix    = "keks/"
+
+	snapshotKeyPrefix = "snapshots/"
+	blobKeyPrefix     = "blobs/"
+
+	artifactKeyPrefix = "registry/artifacts/"
+	chunkKeyPrefix    = "registry/chunks/"
+	tagKeyPrefix      = "registry/tags/"
+	releaseKeyPrefix  = "registry/releases/"
+	runRefKeyPrefix   = "registry/runs/"
+
+	tokenRevocationKeyPrefix = "tokens/revoked/"
+
+	// registryGenerationKey is rewritten by every registry write, so that
+	// garbage collection can tell that the registry changed under it
+	registryGenerationKey = "registry/generation"
+)
+
+func herdKey(name string) string {
+	return herdKeyPrefix + name
+}
+
+// slicePrefix is the key prefix of all slices of a herd
+func slicePrefix(herd string) string {
+	return sliceKeyPrefix + herd + "/"
+}
+
+func sliceKey(herd, name string) string {
+	return slicePrefix(herd) + name
+}
+
+// secretPrefix is the key prefix of all secret versions of a herd
+func secretPrefix(herd string) string {
+	return secretKeyPrefix + herd + "/"
+}
+
+// secretVersionPrefix is the key prefix of the versions of a secret
+func secretVersionPrefix(herd, name string) string {
+	return secretPrefix(herd) + name + "/"
+}
+
+// secretVersionKey pads the version so versions sort by key
+func secretVersionKey(herd, name string, version uint32) string {
+	return fmt.Sprintf("%s%010d", secretVersionPrefix(herd, name), version)
+}
+
+// kekPrefix is the key prefix of the key-encryption keys of a herd
+func kekPrefix(herd string) string {
+	return kekKeyPrefix + herd + "/"
+}
+
+func kekKey(herd string, version uint32) string {
+	return fmt.Sprintf("%s%010d", kekPrefix(herd), version)
+}
+
+// snapshotPrefix is the key prefix of the snapshots of a herd
+func snapshotPrefix(herd string) string {
+	return snapshotKeyPrefix + herd + "/"
+}
+
+func snapshotKey(herd, name string) string {
+	return snapshotPrefix(herd) + name
+}
+
+// blobKey is the key of content-addressed data, by its "sha256:<hex>"
+// digest
+func blobKey(digest string) string {
+	return blobKeyPrefix + digest
+}
+
+func artifactKey(digest string) string {
+	return artifactKeyPrefix + digest
+}
+
+func chunkKey(digest string) string {
+	return chunkKeyPrefix + digest
+}
+
+// tagPrefix is the key prefix of the tags of a repository
+func tagPrefix(repository string) string {
+	return tagKeyPrefix + repository + "/"
+}
+
+func tagKey(repository, tag string) string {
+	return tagPrefix(repository) + tag
+}
+
+func releaseKey(name, version string) string {
+	return releaseKeyPrefix + name + "/" + version
+}
+
+func runRefKey(run string) string {
+	return runRefKeyPrefix + run
+}
+
+func tokenRevocationKey(id string) string {
+	return tokenRevocationKeyPrefix + id
+}
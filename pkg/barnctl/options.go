@@ -0,0 +1,418 @@
+package barnctl
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/runink/herdctl"
+	"github.com/runink/runictl"
+)
+
+// ServerOptions are the flags of barnctl serve, named as in
+// linux/etc/runit/barn/run
+type ServerOptions struct {
+	Listen                string
+	RaftListen            string
+	NodeID                string
+	PeersFile             string
+	StoreDir              string
+	SnapshotDir           string
+	WALSync               string
+	CompactionInterval    time.Duration
+	CompactionThreshold   float64
+	CompactionQueueSize   int
+	LogDir                string
+	MasterKeyFile         string
+	ReencryptInterval     time.Duration
+	SnapshotPruneInterval time.Duration
+
+	AuditKeyFile            string
+	AuditCheckpointInterval time.Duration
+
+	RegistryGCInterval time.Duration
+	RegistryGCGrace    time.Duration
+
+	RaftTLSCert string
+	RaftTLSKey  string
+	RaftCA      string
+
+	TLSCert  string
+	TLSKey   string
+	ClientCA string
+
+	RBAC             bool
+	InsecureNoRBAC   bool
+	Admins           []string
+	TokenKeyFiles    []string
+	TokenMaxLifetime time.Duration
+}
+
+func (o *ServerOptions) addFlags(cmd *cobra.Command) {
+	hostname, _ := os.Hostname()
+	flags := cmd.Flags()
+	flags.StringVar(&o.Listen, "listen", DefaultListenAddr, "Address to serve the API on")
+	flags.StringVar(&o.RaftListen, "raft-listen", "", "Address to serve the Raft RPCs on (default the port of this node's raft address in the peers file)")
+	flags.StringVar(&o.NodeID, "node-id", hostname, "ID of this node in the peers file")
+	flags.StringVar(&o.PeersFile, "raft-peers", DefaultPeersFile, "File listing the cluster members")
+	flags.StringVar(&o.RaftTLSCert, "raft-tls-cert", "", "Certificate this node presents to the other members, named by its node ID")
+	flags.StringVar(&o.RaftTLSKey, "raft-tls-key", "", "Private key of --raft-tls-cert")
+	flags.StringVar(&o.RaftCA, "raft-ca", "", "CA issuing the members' certificates; with it the Raft RPCs require mutual TLS")
+	flags.StringVar(&o.StoreDir, "store", DefaultStoreDir, "Directory of the Raft WAL")
+	flags.StringVar(&o.SnapshotDir, "snapshot-dir", DefaultSnapshotDir, "Directory of the state machine snapshots")
+	flags.StringVar(&o.WALSync, "wal-sync", SyncAlways.String(), "When to flush the WAL: always, interval or never")
+	flags.DurationVar(&o.CompactionInterval, "raft-log-compaction-interval", DefaultSnapshotInterval, "How often to check whether to compact the Raft log")
+	flags.Float64Var(&o.CompactionThreshold, "raft-log-compaction-threshold", DefaultSnapshotThreshold, "Fraction of the queue size at which the log is compacted")
+	flags.IntVar(&o.CompactionQueueSize, "raft-log-compaction-queue-size", DefaultMaxLogEntries, "Number of log entries the compaction threshold refers to")
+	flags.StringVar(&o.LogDir, "log-dir", "", "Directory for the access log (default stderr)")
+	flags.StringVar(&o.MasterKeyFile, "master-key-file", DefaultMasterKeyFile, "File with the hex master key wrapping the herd secret keys")
+	flags.DurationVar(&o.ReencryptInterval, "reencrypt-interval", DefaultReencryptInterval, "How often to rotate due herd keys and rewrap secrets")
+	flags.DurationVar(&o.SnapshotPruneInterval, "snapshot-prune-interval", DefaultSnapshotPruneInterval, "How often to delete herd snapshots past their retention")
+	flags.StringVar(&o.AuditKeyFile, "audit-key-file", DefaultAuditKeyFile, "File with the hex Ed25519 key signing the audit log")
+	flags.DurationVar(&o.AuditCheckpointInterval, "audit-checkpoint-interval", DefaultAuditCheckpointInterval, "How often the leader signs the head of the audit log")
+	flags.DurationVar(&o.RegistryGCInterval, "registry-gc-interval", DefaultRegistryGCInterval, "How often to delete unreferenced registry artifacts; 0 disables it")
+	flags.DurationVar(&o.RegistryGCGrace, "registry-gc-grace", DefaultRegistryGCGrace, "How long unreferenced registry artifacts are kept")
+	flags.StringVar(&o.TLSCert, "tls-cert", "", "Certificate to serve the API over HTTPS with")
+	flags.StringVar(&o.TLSKey, "tls-key", "", "Key of --tls-cert")
+	flags.StringVar(&o.ClientCA, "client-ca", "", "CA issuing client certificates; with --rbac their common name is the user and their organizations its groups")
+	flags.BoolVar(&o.RBAC, "rbac", false, "Require tokens or client certificates and enforce the herds' RBAC policies on the API")
+	flags.BoolVar(&o.InsecureNoRBAC, "insecure-no-rbac", false, "Serve secrets without --rbac, to anyone who reaches the API")
+	flags.StringArrayVar(&o.Admins, "admin", nil, "Subject allowed every request with --rbac, e.g. service:barn-admin (repeatable)")
+	flags.StringArrayVar(&o.TokenKeyFiles, "token-key-file", nil, "Public key or HS256 secret verifying tokens, from herdctl token keygen (repeatable)")
+	flags.DurationVar(&o.TokenMaxLifetime, "token-max-lifetime", herdctl.DefaultTokenLifetime, "Longest lifetime of tokens whose herd sets no token lifetime")
+}
+
+// ClientOptions are the flags of the commands that call the Barn API
+type ClientOptions struct {
+	Endpoint   string
+	Timeout    time.Duration
+	TokenFile  string
+	CACert     string
+	ClientCert string
+	ClientKey  string
+}
+
+func (o *ClientOptions) addFlags(cmd *cobra.Command) {
+	endpoint := os.Getenv("BARN_ENDPOINT")
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&o.Endpoint, "endpoint", endpoint, "Barn API endpoint (env BARN_ENDPOINT)")
+	flags.DurationVar(&o.Timeout, "timeout", 30*time.Second, "Timeout of API requests")
+	flags.StringVar(&o.TokenFile, "token-file", os.Getenv("BARN_TOKEN_FILE"), "File with the token to present, from herdctl token mint (env BARN_TOKEN_FILE)")
+	flags.StringVar(&o.CACert, "ca-cert", os.Getenv("BARN_CA_CERT"), "CA verifying the certificate of an https endpoint (env BARN_CA_CERT)")
+	flags.StringVar(&o.ClientCert, "client-cert", os.Getenv("BARN_CLIENT_CERT"), "Client certificate to present to an https endpoint (env BARN_CLIENT_CERT)")
+	flags.StringVar(&o.ClientKey, "client-key", os.Getenv("BARN_CLIENT_KEY"), "Key of --client-cert (env BARN_CLIENT_KEY)")
+}
+
+// client returns an API client and a context bounded by the timeout
+func (o *ClientOptions) client(cmd *cobra.Command) (*Client, context.Context, context.CancelFunc) {
+	ctx := cmd.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, o.Timeout)
+	api := NewClient(o.Endpoint)
+	var transport http.RoundTripper
+	if o.CACert != "" || o.ClientCert != "" || o.ClientKey != "" {
+		transport = o.tlsTransport()
+	}
+	if o.TokenFile != "" {
+		transport = &herdctl.TokenTransport{TokenFile: o.TokenFile, Base: transport}
+	}
+	if transport != nil {
+		api.HTTP = &http.Client{Transport: transport}
+	}
+	return api, ctx, cancel
+}
+
+// tlsTransport returns a transport trusting --ca-cert and presenting
+// --client-cert. As with a token file that cannot be read, files that do
+// not load fail the requests.
+func (o *ClientOptions) tlsTransport() http.RoundTripper {
+	config, err := o.tlsConfig()
+	if err != nil {
+		return failedTransport{err}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return transport
+}
+
+func (o *ClientOptions) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if o.CACert != "" {
+		pem, err := os.ReadFile(o.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %s", o.CACert)
+		}
+	}
+	if o.ClientCert != "" || o.ClientKey != "" {
+		if o.ClientCert == "" || o.ClientKey == "" {
+			return nil, errors.New("--client-cert and --client-key go together")
+		}
+		cert, err := tls.LoadX509KeyPair(o.ClientCert, o.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// failedTransport fails every request with err
+type failedTransport struct {
+	err error
+}
+
+func (t failedTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, t.err
+}
+
+// HerdOptions are the flags of herd-create and herd-update
+type HerdOptions struct {
+	Labels   []string
+	Quota    runictl.HerdQuota
+	Bindings []string
+	Unbind   []string
+	Secrets  SecretsScope
+
+	Allow        []string
+	Deny         []string
+	Revoke       []string
+	PoliciesFile string
+
+	SnapshotRetentionDays int
+	SnapshotOn            []string
+
+	// changed reports whether a flag was set
+	changed func(name string) bool
+}
+
+func (o *HerdOptions) addFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringArrayVar(&o.Labels, "label", nil, "Label as key=value; key- removes it on update (repeatable)")
+	addQuotaFlags(cmd, &o.Quota)
+	flags.StringArrayVar(&o.Bindings, "bind", nil, "Role binding as role=subject[,subject], e.g. admin=user:alice (repeatable)")
+	flags.StringArrayVar(&o.Unbind, "unbind", nil, "Role whose binding to remove on update (repeatable)")
+	flags.StringArrayVar(&o.Allow, "allow", nil, "Policy allowing a role actions as role=action[,action][@resource,...], e.g. analyst=read:*@contracts/ledger (repeatable)")
+	flags.StringArrayVar(&o.Deny, "deny", nil, "Policy denying a role actions, in the form of --allow (repeatable)")
+	flags.StringArrayVar(&o.Revoke, "revoke", nil, "Role whose policies to remove on update (repeatable)")
+	flags.StringVar(&o.PoliciesFile, "policies-from", "", "Herd file whose rbac_policies replace the herd's policies, with its role_bindings")
+	flags.StringVar(&o.Secrets.Encryption, "secrets-encryption", "", "Encryption required for the herd's secrets: "+EncryptionAES256)
+	flags.StringVar(&o.Secrets.RotationPolicy, "secrets-rotation", "", "Age at which the herd's secret key is rotated, e.g. 90d")
+	flags.IntVar(&o.SnapshotRetentionDays, "snapshot-retention-days", 0, "Days to keep the herd's snapshots; 0 keeps them all")
+	flags.StringSliceVar(&o.SnapshotOn, "snapshot-on", nil, "Events before which the herd is snapshotted: deploy, contract-change or none")
+	o.changed = flags.Changed
+}
+
+// empty reports whether no herd flags were given
+func (o *HerdOptions) empty() bool {
+	return len(o.Labels) == 0 && o.Quota == (runictl.HerdQuota{}) && len(o.Bindings) == 0 && len(o.Unbind) == 0 &&
+		len(o.Allow) == 0 && len(o.Deny) == 0 && len(o.Revoke) == 0 && o.PoliciesFile == "" &&
+		o.Secrets.Encryption == "" && o.Secrets.RotationPolicy == "" &&
+		!o.changed("snapshot-retention-days") && len(o.SnapshotOn) == 0
+}
+
+// apply sets the flags given on the command line on herd
+func (o *HerdOptions) apply(herd *Herd) error {
+	labels, err := applyLabels(herd.Labels, o.Labels)
+	if err != nil {
+		return err
+	}
+	herd.Labels = labels
+	herd.Quota = herd.Quota.Merge(o.Quota)
+	if o.Secrets.Encryption != "" {
+		herd.Secrets.Encryption = o.Secrets.Encryption
+	}
+	if o.Secrets.RotationPolicy != "" {
+		herd.Secrets.RotationPolicy = o.Secrets.RotationPolicy
+	}
+	if o.changed("snapshot-retention-days") {
+		herd.Snapshot.RetentionDays = o.SnapshotRetentionDays
+	}
+	if len(o.SnapshotOn) > 0 {
+		herd.Snapshot.OnDeploy, herd.Snapshot.OnContractChange = false, false
+		for _, event := range o.SnapshotOn {
+			switch event {
+			case "deploy":
+				herd.Snapshot.OnDeploy = true
+			case "contract-change":
+				herd.Snapshot.OnContractChange = true
+			case "none":
+			default:
+				return fmt.Errorf("invalid --snapshot-on %q: expected deploy, contract-change or none", event)
+			}
+		}
+	}
+
+	for _, role := range o.Unbind {
+		herd.RBAC = removeBinding(herd.RBAC, role)
+	}
+	for _, value := range o.Bindings {
+		role, subjects, ok := strings.Cut(value, "=")
+		if !ok || subjects == "" {
+			return fmt.Errorf("invalid --bind %q: expected role=subject[,subject]", value)
+		}
+		herd.RBAC = append(removeBinding(herd.RBAC, role), RoleBinding{Role: role, Subjects: strings.Split(subjects, ",")})
+	}
+
+	if o.PoliciesFile != "" {
+		set, err := herdctl.LoadPolicySet(o.PoliciesFile)
+		if err != nil {
+			return err
+		}
+		herd.Policies = set.Policies
+		for _, binding := range set.Bindings {
+			herd.RBAC = append(removeBinding(herd.RBAC, binding.Role), RoleBinding{Role: binding.Role, Subjects: binding.Subjects})
+		}
+	}
+	for _, role := range o.Revoke {
+		herd.Policies = removePolicies(herd.Policies, role)
+	}
+	for _, value := range o.Allow {
+		policy, err := parsePolicy("--allow", value, herdctl.EffectAllow)
+		if err != nil {
+			return err
+		}
+		herd.Policies = append(herd.Policies, policy)
+	}
+	for _, value := range o.Deny {
+		policy, err := parsePolicy("--deny", value, herdctl.EffectDeny)
+		if err != nil {
+			return err
+		}
+		herd.Policies = append(herd.Policies, policy)
+	}
+	return nil
+}
+
+// parsePolicy parses role=action[,action][@resource[,resource]]
+func parsePolicy(flag, value, effect string) (herdctl.Policy, error) {
+	role, rest, ok := strings.Cut(value, "=")
+	if !ok || role == "" || rest == "" {
+		return herdctl.Policy{}, fmt.Errorf("invalid %s %q: expected role=action[,action][@resource,...]", flag, value)
+	}
+	actions, resources, _ := strings.Cut(rest, "@")
+	policy := herdctl.Policy{Role: role, Actions: strings.Split(actions, ",")}
+	if effect == herdctl.EffectDeny {
+		policy.Effect = effect
+	}
+	if resources != "" {
+		policy.Resources = strings.Split(resources, ",")
+	}
+	return policy, nil
+}
+
+// SliceOptions are the flags of runi-create and runi-update
+type SliceOptions struct {
+	Herd      string
+	Scenario  string
+	Contract  string
+	Node      string
+	Resources runictl.HerdQuota
+	Env       []string
+	Labels    []string
+}
+
+func (o *SliceOptions) addFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVar(&o.Herd, "herd", "", "Herd of the slice")
+	flags.StringVar(&o.Scenario, "scenario", "", "Scenario the slice runs")
+	flags.StringVar(&o.Contract, "contract", "", "Contract the scenario enforces, e.g. contracts/orders.go@v3")
+	flags.StringVar(&o.Node, "node", "", "Node to pin the slice to")
+	addQuotaFlags(cmd, &o.Resources)
+	flags.StringArrayVar(&o.Env, "env", nil, "Environment variable as NAME=value; NAME- removes it on update (repeatable)")
+	flags.StringArrayVar(&o.Labels, "label", nil, "Label as key=value; key- removes it on update (repeatable)")
+	cmd.MarkFlagRequired("herd")
+}
+
+// apply sets the flags given on the command line on slice
+func (o *SliceOptions) apply(cmd *cobra.Command, slice *Slice) error {
+	if cmd.Flags().Changed("scenario") {
+		slice.Scenario = o.Scenario
+	}
+	if cmd.Flags().Changed("contract") {
+		slice.Contract = o.Contract
+	}
+	if cmd.Flags().Changed("node") {
+		slice.Node = o.Node
+	}
+	slice.Resources = slice.Resources.Merge(o.Resources)
+
+	env, err := applyLabels(slice.Env, o.Env)
+	if err != nil {
+		return err
+	}
+	slice.Env = env
+	labels, err := applyLabels(slice.Labels, o.Labels)
+	if err != nil {
+		return err
+	}
+	slice.Labels = labels
+	return nil
+}
+
+func addQuotaFlags(cmd *cobra.Command, quota *runictl.HerdQuota) {
+	flags := cmd.Flags()
+	flags.StringVar(&quota.CPU, "cpu", "", "CPU limit, e.g. 20000m")
+	flags.StringVar(&quota.Memory, "memory", "", "Memory limit, e.g. 64Gi")
+	flags.StringVar(&quota.Pids, "pids", "", "Maximum number of processes and threads")
+	flags.StringVar(&quota.IO, "io", "", "Per-device io.max rules, e.g. \"8:0 rbps=500Mi\"")
+}
+
+// applyLabels applies key=value and key- arguments to a copy of labels
+func applyLabels(labels map[string]string, args []string) (map[string]string, error) {
+	if len(args) == 0 {
+		return labels, nil
+	}
+	result := make(map[string]string, len(labels)+len(args))
+	for key, value := range labels {
+		result[key] = value
+	}
+	for _, arg := range args {
+		if key, ok := strings.CutSuffix(arg, "-"); ok && !strings.Contains(key, "=") {
+			delete(result, key)
+			continue
+		}
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid %q: expected key=value or key-", arg)
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+func removePolicies(policies []herdctl.Policy, role string) []herdctl.Policy {
+	var kept []herdctl.Policy
+	for _, policy := range policies {
+		if policy.Role != role {
+			kept = append(kept, policy)
+		}
+	}
+	return kept
+}
+
+func removeBinding(bindings []RoleBinding, role string) []RoleBinding {
+	var kept []RoleBinding
+	for _, binding := range bindings {
+		if binding.Role != role {
+			kept = append(kept, binding)
+		}
+	}
+	return kept
+}
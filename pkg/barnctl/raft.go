@@ -0,0 +1,1175 @@
+package barnctl
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPeersFile lists the members of the Barn Raft cluster
+const DefaultPeersFile = "/etc/runit/raft.peers"
+
+const (
+	// DefaultElectionTimeout is the base time a follower waits for the
+	// leader before it campaigns. Each wait is randomized up to twice this.
+	DefaultElectionTimeout = time.Second
+
+	// DefaultHeartbeatInterval is how often the leader contacts followers
+	DefaultHeartbeatInterval = 100 * time.Millisecond
+
+	// defaultMaxAppendEntries caps the entries sent in one AppendEntries RPC
+	defaultMaxAppendEntries = 256
+
+	// DefaultSnapshotInterval is how often a node checks whether its log
+	// should be compacted, see --raft-log-compaction-interval
+	DefaultSnapshotInterval = 5 * time.Second
+
+	// DefaultMaxLogEntries and DefaultSnapshotThreshold compact the log
+	// once it holds 800 applied entries, see --raft-log-compaction-queue-size
+	// and --raft-log-compaction-threshold
+	DefaultMaxLogEntries     = 1000
+	DefaultSnapshotThreshold = 0.8
+
+	// snapshotTimeoutFactor gives InstallSnapshot RPCs this many election
+	// timeouts to transfer the state
+	snapshotTimeoutFactor = 10
+)
+
+// Role is a node's role in the current term
+type Role int
+
+const (
+	Follower Role = iota
+	// PreCandidate checks that it could win an election before it
+	// increments its term, so a node cut off by a partition does not
+	// depose a healthy leader when it rejoins
+	PreCandidate
+	Candidate
+	Leader
+)
+
+func (r Role) String() string {
+	switch r {
+	case Follower:
+		return "follower"
+	case PreCandidate:
+		return "pre-candidate"
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	default:
+		return fmt.Sprintf("Role(%d)", int(r))
+	}
+}
+
+// DefaultRaftPort is the port members serve the Raft RPCs on when the
+// peers file gives no Raft address. It is kept apart from the API so that
+// it can be firewalled to the members.
+const DefaultRaftPort = "8081"
+
+// Peer is a member of a Raft cluster. Addr serves its API and RaftAddr its
+// Raft RPCs; without a RaftAddr, the RPCs are sent to Addr.
+type Peer struct {
+	ID       string
+	Addr     string
+	RaftAddr string
+}
+
+// LoadPeers reads cluster members from a peers file such as
+// linux/etc/runit/raft.peers. Each line holds "host:port", "id host:port"
+// or "id host:port raft-host:port"; without an ID the address is the
+// member's ID, and without a Raft address the member serves Raft on the
+// API host at DefaultRaftPort. Blank lines and lines starting with # are
+// ignored.
+func LoadPeers(path string) ([]Peer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open peers file: %w", err)
+	}
+	defer file.Close()
+
+	var peers []Peer
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		var peer Peer
+		switch fields := strings.Fields(text); len(fields) {
+		case 1:
+			peer = Peer{ID: fields[0], Addr: fields[0]}
+		case 2:
+			peer = Peer{ID: fields[0], Addr: fields[1]}
+		case 3:
+			peer = Peer{ID: fields[0], Addr: fields[1], RaftAddr: fields[2]}
+		default:
+			return nil, fmt.Errorf("%s:%d: expected \"[id] host:port [raft-host:port]\", got %q", path, line, text)
+		}
+		host, _, err := net.SplitHostPort(peer.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid address %q: %w", path, line, peer.Addr, err)
+		}
+		if peer.RaftAddr == "" {
+			peer.RaftAddr = net.JoinHostPort(host, DefaultRaftPort)
+		}
+		if _, _, err := net.SplitHostPort(peer.RaftAddr); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid raft address %q: %w", path, line, peer.RaftAddr, err)
+		}
+		if peer.RaftAddr == peer.Addr {
+			return nil, fmt.Errorf("%s:%d: the raft address of %s must differ from its API address", path, line, peer.ID)
+		}
+		if seen[peer.ID] {
+			return nil, fmt.Errorf("%s:%d: duplicate peer %s", path, line, peer.ID)
+		}
+		seen[peer.ID] = true
+		peers = append(peers, peer)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read peers file: %w", err)
+	}
+	if len(peers) == 0 {
+		return nil, fmt.Errorf("peers file %s lists no peers", path)
+	}
+	return peers, nil
+}
+
+// FSM is the state machine committed entries are applied to, in log
+// order and exactly once per node lifetime. Snapshot and Restore are never
+// called concurrently with Apply.
+type FSM interface {
+	Apply(entry Entry) any
+
+	// Snapshot serializes the state after the last applied entry
+	Snapshot() ([]byte, error)
+
+	// Restore replaces the state with a snapshot
+	Restore(data []byte) error
+}
+
+// RaftConfig configures a Raft node
+type RaftConfig struct {
+	// ID identifies this node among Peers
+	ID string
+
+	// Peers are all members of the cluster, including this node. Empty
+	// means a single-node cluster.
+	Peers []Peer
+
+	// Storage holds the log and hard state. Default: NewMemoryStorage()
+	Storage Storage
+
+	// Transport carries RPCs between the members
+	Transport Transport
+
+	// FSM receives committed entries
+	FSM FSM
+
+	// ElectionTimeout and HeartbeatInterval default to
+	// DefaultElectionTimeout and DefaultHeartbeatInterval
+	ElectionTimeout   time.Duration
+	HeartbeatInterval time.Duration
+
+	// MaxAppendEntries caps the entries per AppendEntries RPC. Default: 256
+	MaxAppendEntries int
+
+	// Every SnapshotInterval, the node snapshots the state machine and
+	// compacts its log if it holds SnapshotThreshold * MaxLogEntries
+	// applied entries. Default: DefaultSnapshotInterval,
+	// DefaultSnapshotThreshold and DefaultMaxLogEntries
+	SnapshotInterval  time.Duration
+	SnapshotThreshold float64
+	MaxLogEntries     int
+}
+
+// RaftStatus is a snapshot of a node's Raft state
+type RaftStatus struct {
+	ID            string `json:"id"`
+	Role          string `json:"role"`
+	Term          uint64 `json:"term"`
+	Leader        string `json:"leader,omitempty"`
+	LastIndex     uint64 `json:"lastIndex"`
+	CommitIndex   uint64 `json:"commitIndex"`
+	AppliedIndex  uint64 `json:"appliedIndex"`
+	SnapshotIndex uint64 `json:"snapshotIndex"`
+}
+
+// Raft is a member of a Raft cluster. Membership is fixed by the
+// configured peers.
+type Raft struct {
+	config    RaftConfig
+	peers     []string
+	storage   Storage
+	transport Transport
+	fsm       FSM
+
+	mu       sync.Mutex
+	role     Role
+	term     uint64
+	vote     string
+	leader   string
+	shutdown bool
+
+	lastIndex   uint64
+	lastTerm    uint64
+	commitIndex uint64
+	lastApplied uint64
+	applyCond   *sync.Cond
+
+	// The log starts after the snapshot at snapIndex. restore is a snapshot
+	// installed by the leader that the state machine has yet to load.
+	snapIndex uint64
+	snapTerm  uint64
+	restore   *Snapshot
+
+	// applyMu keeps snapshots from seeing a half-applied entry
+	applyMu sync.Mutex
+
+	// Election timing: a follower campaigns once electionTimeout has passed
+	// since lastContact with a leader or candidate
+	lastContact     time.Time
+	electionTimeout time.Duration
+	campaign        int
+
+	// Leader state, per follower
+	nextIndex  map[string]uint64
+	matchIndex map[string]uint64
+	lastAck    map[string]time.Time
+	inflight   map[string]bool
+	pending    map[string]bool
+
+	// waiters are proposals waiting for their entry to be applied
+	waiters map[uint64]*proposal
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// proposal waits for a proposed entry to be applied
+type proposal struct {
+	term uint64
+	done chan proposalResult
+}
+
+type proposalResult struct {
+	value any
+	err   error
+}
+
+// NewRaft starts a Raft node. It serves RPCs on the transport right away
+// and campaigns once it has not heard from a leader for an election timeout.
+func NewRaft(config RaftConfig) (*Raft, error) {
+	if config.ID == "" {
+		return nil, fmt.Errorf("raft node ID is required")
+	}
+	if config.Transport == nil || config.FSM == nil {
+		return nil, fmt.Errorf("raft node %s needs a transport and a state machine", config.ID)
+	}
+	if config.Storage == nil {
+		config.Storage = NewMemoryStorage()
+	}
+	if config.ElectionTimeout <= 0 {
+		config.ElectionTimeout = DefaultElectionTimeout
+	}
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if config.MaxAppendEntries <= 0 {
+		config.MaxAppendEntries = defaultMaxAppendEntries
+	}
+	if config.SnapshotInterval <= 0 {
+		config.SnapshotInterval = DefaultSnapshotInterval
+	}
+	if config.SnapshotThreshold <= 0 {
+		config.SnapshotThreshold = DefaultSnapshotThreshold
+	}
+	if config.MaxLogEntries <= 0 {
+		config.MaxLogEntries = DefaultMaxLogEntries
+	}
+
+	var peers []string
+	member := len(config.Peers) == 0
+	for _, peer := range config.Peers {
+		if peer.ID == config.ID {
+			member = true
+			continue
+		}
+		peers = append(peers, peer.ID)
+	}
+	if !member {
+		return nil, fmt.Errorf("raft node %s is not in its peer list", config.ID)
+	}
+
+	state, err := config.Storage.HardState()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read raft state: %w", err)
+	}
+	lastIndex, err := config.Storage.LastIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read raft log: %w", err)
+	}
+	lastTerm, err := config.Storage.Term(lastIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read raft log: %w", err)
+	}
+
+	// Entries after the snapshot are applied again once the leader tells
+	// this node they are committed
+	snapshot, err := config.Storage.Snapshot()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read raft snapshot: %w", err)
+	}
+	if snapshot.Index > 0 {
+		if err := config.FSM.Restore(snapshot.Data); err != nil {
+			return nil, fmt.Errorf("failed to restore snapshot %d: %w", snapshot.Index, err)
+		}
+	}
+
+	r := &Raft{
+		config:      config,
+		peers:       peers,
+		storage:     config.Storage,
+		transport:   config.Transport,
+		fsm:         config.FSM,
+		term:        state.Term,
+		vote:        state.Vote,
+		lastIndex:   lastIndex,
+		lastTerm:    lastTerm,
+		commitIndex: snapshot.Index,
+		lastApplied: snapshot.Index,
+		snapIndex:   snapshot.Index,
+		snapTerm:    snapshot.Term,
+		nextIndex:   make(map[string]uint64),
+		matchIndex:  make(map[string]uint64),
+		lastAck:     make(map[string]time.Time),
+		inflight:    make(map[string]bool),
+		pending:     make(map[string]bool),
+		waiters:     make(map[uint64]*proposal),
+	}
+	r.applyCond = sync.NewCond(&r.mu)
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.resetElectionTimer()
+
+	if err := r.transport.Serve(r); err != nil {
+		r.cancel()
+		return nil, fmt.Errorf("failed to serve raft RPCs: %w", err)
+	}
+
+	r.wg.Add(3)
+	go r.run()
+	go r.applyLoop()
+	go r.snapshotLoop()
+	return r, nil
+}
+
+// ID returns the node's ID
+func (r *Raft) ID() string {
+	return r.config.ID
+}
+
+// Leader returns the ID of the leader this node knows of, or ""
+func (r *Raft) Leader() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.leader
+}
+
+// IsLeader reports whether this node is the leader
+func (r *Raft) IsLeader() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.role == Leader
+}
+
+// Status returns the node's current Raft state
+func (r *Raft) Status() RaftStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return RaftStatus{
+		ID:            r.config.ID,
+		Role:          r.role.String(),
+		Term:          r.term,
+		Leader:        r.leader,
+		LastIndex:     r.lastIndex,
+		CommitIndex:   r.commitIndex,
+		AppliedIndex:  r.lastApplied,
+		SnapshotIndex: r.snapIndex,
+	}
+}
+
+// Propose replicates data through the log and returns what the state
+// machine's Apply returned for it. Only the leader accepts proposals;
+// other nodes return a NotLeaderError naming the leader.
+func (r *Raft) Propose(ctx context.Context, data []byte) (any, error) {
+	return r.propose(ctx, EntryNormal, data)
+}
+
+// Barrier returns once every entry committed before the call has been
+// applied to this node's state machine. Only the leader accepts barriers.
+func (r *Raft) Barrier(ctx context.Context) error {
+	_, err := r.propose(ctx, EntryNoop, nil)
+	return err
+}
+
+func (r *Raft) propose(ctx context.Context, entryType EntryType, data []byte) (any, error) {
+	r.mu.Lock()
+	if r.shutdown {
+		r.mu.Unlock()
+		return nil, ErrShutdown
+	}
+	if r.role != Leader {
+		leader := r.leader
+		r.mu.Unlock()
+		return nil, &NotLeaderError{Leader: leader}
+	}
+
+	entry := Entry{Index: r.lastIndex + 1, Term: r.term, Type: entryType, Data: data}
+	if err := r.appendEntries([]Entry{entry}); err != nil {
+		r.mu.Unlock()
+		return nil, err
+	}
+	p := &proposal{term: r.term, done: make(chan proposalResult, 1)}
+	r.waiters[entry.Index] = p
+	r.maybeCommit()
+	r.broadcastAppend()
+	r.mu.Unlock()
+
+	select {
+	case result := <-p.done:
+		return result.value, result.err
+	case <-ctx.Done():
+		r.mu.Lock()
+		delete(r.waiters, entry.Index)
+		r.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// Shutdown stops the node. Pending proposals fail with ErrShutdown. The
+// storage is left open.
+func (r *Raft) Shutdown() error {
+	r.mu.Lock()
+	if r.shutdown {
+		r.mu.Unlock()
+		return nil
+	}
+	r.shutdown = true
+	r.failWaiters(ErrShutdown)
+	r.applyCond.Broadcast()
+	r.mu.Unlock()
+
+	r.cancel()
+	err := r.transport.Close()
+	r.wg.Wait()
+	return err
+}
+
+// run drives elections and heartbeats
+func (r *Raft) run() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			r.tick()
+		}
+	}
+}
+
+func (r *Raft) tick() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.shutdown {
+		return
+	}
+
+	if r.role == Leader {
+		// A leader cut off from a majority steps down, so clients of a
+		// minority partition fail fast instead of waiting on commits
+		if !r.hasQuorumContact() {
+			r.becomeFollower(r.term, "")
+			return
+		}
+		r.broadcastAppend()
+		return
+	}
+	if time.Since(r.lastContact) >= r.electionTimeout {
+		r.startCampaign(true)
+	}
+}
+
+// startCampaign asks the peers for votes, first in a pre-vote that leaves
+// all terms unchanged
+func (r *Raft) startCampaign(preVote bool) {
+	r.campaign++
+	campaign := r.campaign
+	r.leader = ""
+	r.resetElectionTimer()
+
+	term := r.term + 1
+	if preVote {
+		r.role = PreCandidate
+	} else {
+		r.role = Candidate
+		r.term = term
+		r.vote = r.config.ID
+		if err := r.persist(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: raft node %s failed to save its vote: %v\n", r.config.ID, err)
+			r.role = Follower
+			return
+		}
+	}
+
+	req := &VoteRequest{
+		Term:         term,
+		CandidateID:  r.config.ID,
+		LastLogIndex: r.lastIndex,
+		LastLogTerm:  r.lastTerm,
+		PreVote:      preVote,
+	}
+	votes := 1
+	if votes >= r.quorum() {
+		r.campaignWon(preVote)
+		return
+	}
+
+	for _, peer := range r.peers {
+		go func(peer string) {
+			ctx, cancel := context.WithTimeout(r.ctx, r.config.ElectionTimeout)
+			defer cancel()
+			resp, err := r.transport.RequestVote(ctx, peer, req)
+			if err != nil {
+				return
+			}
+
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if r.shutdown || r.campaign != campaign {
+				return
+			}
+			if resp.Term > r.term {
+				r.becomeFollower(resp.Term, "")
+				return
+			}
+			if resp.Granted {
+				votes++
+				if votes == r.quorum() {
+					r.campaignWon(preVote)
+				}
+			}
+		}(peer)
+	}
+}
+
+func (r *Raft) campaignWon(preVote bool) {
+	if preVote {
+		r.startCampaign(false)
+		return
+	}
+	r.becomeLeader()
+}
+
+func (r *Raft) becomeLeader() {
+	r.role = Leader
+	r.leader = r.config.ID
+	now := time.Now()
+	for _, peer := range r.peers {
+		r.nextIndex[peer] = r.lastIndex + 1
+		r.matchIndex[peer] = 0
+		r.lastAck[peer] = now
+	}
+
+	// Entries of earlier terms only commit along with one of this term
+	if err := r.appendEntries([]Entry{{Index: r.lastIndex + 1, Term: r.term, Type: EntryNoop}}); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: raft node %s failed to start its term: %v\n", r.config.ID, err)
+		r.becomeFollower(r.term, "")
+		return
+	}
+	r.maybeCommit()
+	r.broadcastAppend()
+}
+
+// becomeFollower moves to term, forgetting the vote of an older term
+func (r *Raft) becomeFollower(term uint64, leader string) {
+	if term > r.term {
+		r.term = term
+		r.vote = ""
+		if err := r.persist(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: raft node %s failed to save its term: %v\n", r.config.ID, err)
+		}
+	}
+	if r.role == Leader {
+		r.failWaiters(ErrLeadershipLost)
+	}
+	if r.role != Follower {
+		r.resetElectionTimer()
+	}
+	r.role = Follower
+	r.leader = leader
+	r.campaign++
+}
+
+// hasQuorumContact reports whether a majority answered the leader within
+// the last election timeout
+func (r *Raft) hasQuorumContact() bool {
+	contacts := 1
+	for _, peer := range r.peers {
+		if time.Since(r.lastAck[peer]) < r.config.ElectionTimeout {
+			contacts++
+		}
+	}
+	return contacts >= r.quorum()
+}
+
+// broadcastAppend sends new entries, or a heartbeat, to every follower
+// without an RPC in flight; the others are sent to when their RPC returns
+func (r *Raft) broadcastAppend() {
+	for _, peer := range r.peers {
+		if r.inflight[peer] {
+			r.pending[peer] = true
+			continue
+		}
+		r.sendAppend(peer)
+	}
+}
+
+func (r *Raft) sendAppend(peer string) {
+	next := r.nextIndex[peer]
+	if next <= r.snapIndex {
+		// The follower is behind the log: send the snapshot instead
+		r.sendSnapshot(peer)
+		return
+	}
+	prevTerm, err := r.storage.Term(next - 1)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: raft node %s failed to read its log: %v\n", r.config.ID, err)
+		return
+	}
+	hi := r.lastIndex + 1
+	if max := next + uint64(r.config.MaxAppendEntries); hi > max {
+		hi = max
+	}
+	entries, err := r.storage.Entries(next, hi)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: raft node %s failed to read its log: %v\n", r.config.ID, err)
+		return
+	}
+
+	req := &AppendEntriesRequest{
+		Term:         r.term,
+		LeaderID:     r.config.ID,
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  prevTerm,
+		Entries:      entries,
+		LeaderCommit: r.commitIndex,
+	}
+	r.inflight[peer] = true
+	r.pending[peer] = false
+
+	go func() {
+		ctx, cancel := context.WithTimeout(r.ctx, r.config.ElectionTimeout)
+		defer cancel()
+		resp, err := r.transport.AppendEntries(ctx, peer, req)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.inflight[peer] = false
+		if r.shutdown || r.role != Leader || r.term != req.Term {
+			return
+		}
+		if err != nil {
+			// Retried with the next heartbeat
+			r.pending[peer] = false
+			return
+		}
+		more := r.handleAppendResponse(peer, req, resp)
+		if r.role == Leader && (more || r.pending[peer]) {
+			r.sendAppend(peer)
+		}
+	}()
+}
+
+// sendSnapshot brings a follower that is behind the compacted log up to
+// the snapshot
+func (r *Raft) sendSnapshot(peer string) {
+	snapshot, err := r.storage.Snapshot()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: raft node %s failed to read its snapshot: %v\n", r.config.ID, err)
+		return
+	}
+	req := &InstallSnapshotRequest{
+		Term:              r.term,
+		LeaderID:          r.config.ID,
+		LastIncludedIndex: snapshot.Index,
+		LastIncludedTerm:  snapshot.Term,
+		Data:              snapshot.Data,
+	}
+	r.inflight[peer] = true
+	r.pending[peer] = false
+
+	go func() {
+		ctx, cancel := context.WithTimeout(r.ctx, snapshotTimeoutFactor*r.config.ElectionTimeout)
+		defer cancel()
+		resp, err := r.transport.InstallSnapshot(ctx, peer, req)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.inflight[peer] = false
+		if r.shutdown || r.role != Leader || r.term != req.Term {
+			return
+		}
+		if err != nil {
+			r.pending[peer] = false
+			return
+		}
+		if resp.Term > r.term {
+			r.becomeFollower(resp.Term, "")
+			return
+		}
+		r.lastAck[peer] = time.Now()
+		if req.LastIncludedIndex > r.matchIndex[peer] {
+			r.matchIndex[peer] = req.LastIncludedIndex
+			r.maybeCommit()
+		}
+		r.nextIndex[peer] = r.matchIndex[peer] + 1
+		if r.nextIndex[peer] <= r.lastIndex || r.pending[peer] {
+			r.sendAppend(peer)
+		}
+	}()
+}
+
+// handleAppendResponse updates the follower's progress and reports whether
+// more entries should be sent right away
+func (r *Raft) handleAppendResponse(peer string, req *AppendEntriesRequest, resp *AppendEntriesResponse) bool {
+	if resp.Term > r.term {
+		r.becomeFollower(resp.Term, "")
+		return false
+	}
+	r.lastAck[peer] = time.Now()
+
+	if resp.Success {
+		match := req.PrevLogIndex + uint64(len(req.Entries))
+		if match > r.matchIndex[peer] {
+			r.matchIndex[peer] = match
+			r.maybeCommit()
+		}
+		r.nextIndex[peer] = match + 1
+		return r.nextIndex[peer] <= r.lastIndex
+	}
+
+	// Skip the follower's whole conflicting term rather than one entry
+	// per round trip
+	next := resp.ConflictIndex
+	if resp.ConflictTerm > 0 {
+		for i := req.PrevLogIndex; i > 0; i-- {
+			term, err := r.storage.Term(i)
+			if err != nil || term < resp.ConflictTerm {
+				break
+			}
+			if term == resp.ConflictTerm {
+				next = i + 1
+				break
+			}
+		}
+	}
+	if next < 1 {
+		next = 1
+	}
+	if next > r.lastIndex+1 {
+		next = r.lastIndex + 1
+	}
+	r.nextIndex[peer] = next
+	return true
+}
+
+// maybeCommit advances the commit index to the highest entry of the
+// current term stored on a majority
+func (r *Raft) maybeCommit() {
+	matches := []uint64{r.lastIndex}
+	for _, peer := range r.peers {
+		matches = append(matches, r.matchIndex[peer])
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i] > matches[j] })
+
+	index := matches[r.quorum()-1]
+	if index <= r.commitIndex {
+		return
+	}
+	if term, err := r.storage.Term(index); err != nil || term != r.term {
+		return
+	}
+	r.commitIndex = index
+	r.applyCond.Broadcast()
+}
+
+// HandleRequestVote answers a vote or pre-vote request
+func (r *Raft) HandleRequestVote(req *VoteRequest) (*VoteResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.shutdown {
+		return nil, ErrShutdown
+	}
+
+	resp := &VoteResponse{Term: r.term}
+	if req.Term < r.term {
+		return resp, nil
+	}
+	upToDate := req.LastLogTerm > r.lastTerm ||
+		(req.LastLogTerm == r.lastTerm && req.LastLogIndex >= r.lastIndex)
+
+	if req.PreVote {
+		// Pre-votes change no state and are refused while a leader is alive
+		resp.Granted = req.Term > r.term && upToDate && !r.leaderAlive()
+		return resp, nil
+	}
+
+	if req.Term > r.term {
+		r.becomeFollower(req.Term, "")
+		resp.Term = r.term
+	}
+	if (r.vote == "" || r.vote == req.CandidateID) && upToDate {
+		r.vote = req.CandidateID
+		if err := r.persist(); err != nil {
+			return nil, fmt.Errorf("failed to save vote: %w", err)
+		}
+		resp.Granted = true
+		r.resetElectionTimer()
+	}
+	return resp, nil
+}
+
+// HandleAppendEntries appends the leader's entries to the local log
+func (r *Raft) HandleAppendEntries(req *AppendEntriesRequest) (*AppendEntriesResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.shutdown {
+		return nil, ErrShutdown
+	}
+
+	resp := &AppendEntriesResponse{Term: r.term}
+	if req.Term < r.term {
+		return resp, nil
+	}
+	if req.Term > r.term || r.role != Follower {
+		r.becomeFollower(req.Term, req.LeaderID)
+		resp.Term = r.term
+	}
+	r.leader = req.LeaderID
+	r.lastContact = time.Now()
+
+	// Entries covered by the snapshot are committed and match the leader's
+	prevIndex, entries := req.PrevLogIndex, req.Entries
+	if prevIndex < r.snapIndex {
+		skip := r.snapIndex - prevIndex
+		if skip > uint64(len(entries)) {
+			skip = uint64(len(entries))
+		}
+		prevIndex, entries = r.snapIndex, entries[skip:]
+	}
+
+	// The entries must follow on from an entry both logs share
+	if prevIndex > r.lastIndex {
+		resp.ConflictIndex = r.lastIndex + 1
+		return resp, nil
+	}
+	prevTerm, err := r.storage.Term(prevIndex)
+	if err != nil {
+		return nil, err
+	}
+	if prevIndex == req.PrevLogIndex && prevTerm != req.PrevLogTerm {
+		resp.ConflictTerm = prevTerm
+		resp.ConflictIndex = prevIndex
+		for resp.ConflictIndex > r.snapIndex+1 {
+			term, err := r.storage.Term(resp.ConflictIndex - 1)
+			if err != nil || term != prevTerm {
+				break
+			}
+			resp.ConflictIndex--
+		}
+		return resp, nil
+	}
+
+	// Skip entries already in the log; replace the log from the first
+	// entry that differs
+	for i, entry := range entries {
+		if entry.Index <= r.lastIndex {
+			term, err := r.storage.Term(entry.Index)
+			if err != nil {
+				return nil, err
+			}
+			if term == entry.Term {
+				continue
+			}
+		}
+		if err := r.appendEntries(entries[i:]); err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	resp.Success = true
+	last := req.PrevLogIndex + uint64(len(req.Entries))
+	if commit := minIndex(req.LeaderCommit, last); commit > r.commitIndex {
+		r.commitIndex = commit
+		r.applyCond.Broadcast()
+	}
+	return resp, nil
+}
+
+// HandleInstallSnapshot replaces the local log and state with the
+// leader's snapshot when this node is behind the leader's compacted log
+func (r *Raft) HandleInstallSnapshot(req *InstallSnapshotRequest) (*InstallSnapshotResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.shutdown {
+		return nil, ErrShutdown
+	}
+
+	resp := &InstallSnapshotResponse{Term: r.term}
+	if req.Term < r.term {
+		return resp, nil
+	}
+	if req.Term > r.term || r.role != Follower {
+		r.becomeFollower(req.Term, req.LeaderID)
+		resp.Term = r.term
+	}
+	r.leader = req.LeaderID
+	r.lastContact = time.Now()
+	if req.LastIncludedIndex <= r.commitIndex {
+		return resp, nil
+	}
+
+	snapshot := Snapshot{Index: req.LastIncludedIndex, Term: req.LastIncludedTerm, Data: req.Data}
+	if err := r.storage.SaveSnapshot(snapshot); err != nil {
+		return nil, fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	lastIndex, err := r.storage.LastIndex()
+	if err != nil {
+		return nil, err
+	}
+	lastTerm, err := r.storage.Term(lastIndex)
+	if err != nil {
+		return nil, err
+	}
+	r.lastIndex, r.lastTerm = lastIndex, lastTerm
+	r.snapIndex, r.snapTerm = snapshot.Index, snapshot.Term
+	r.commitIndex = snapshot.Index
+	r.restore = &snapshot
+	r.applyCond.Broadcast()
+	return resp, nil
+}
+
+// Snapshot saves the state machine and compacts the log up to the last
+// applied entry
+func (r *Raft) Snapshot() error {
+	r.applyMu.Lock()
+	r.mu.Lock()
+	index, shutdown := r.lastApplied, r.shutdown
+	r.mu.Unlock()
+	if shutdown {
+		r.applyMu.Unlock()
+		return ErrShutdown
+	}
+	term, err := r.storage.Term(index)
+	if errors.Is(err, ErrCompacted) {
+		// Replaced by an installed snapshot
+		r.applyMu.Unlock()
+		return nil
+	}
+	var data []byte
+	if err == nil {
+		data, err = r.fsm.Snapshot()
+	}
+	r.applyMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to snapshot state at index %d: %w", index, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if index <= r.snapIndex {
+		return nil
+	}
+	if err := r.storage.SaveSnapshot(Snapshot{Index: index, Term: term, Data: data}); err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	r.snapIndex, r.snapTerm = index, term
+	return nil
+}
+
+// snapshotLoop compacts the log once enough entries have been applied
+func (r *Raft) snapshotLoop() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.config.SnapshotInterval)
+	defer ticker.Stop()
+	threshold := uint64(r.config.SnapshotThreshold * float64(r.config.MaxLogEntries))
+	if threshold < 1 {
+		threshold = 1
+	}
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		r.mu.Lock()
+		due := r.lastApplied >= r.snapIndex+threshold
+		r.mu.Unlock()
+		if !due {
+			continue
+		}
+		if err := r.Snapshot(); err != nil && !errors.Is(err, ErrShutdown) {
+			fmt.Fprintf(os.Stderr, "Warning: raft node %s failed to compact its log: %v\n", r.config.ID, err)
+		}
+	}
+}
+
+// applyLoop applies committed entries and installed snapshots to the state
+// machine and completes the proposals waiting for them
+func (r *Raft) applyLoop() {
+	defer r.wg.Done()
+	for {
+		r.mu.Lock()
+		for r.lastApplied >= r.commitIndex && r.restore == nil && !r.shutdown {
+			r.applyCond.Wait()
+		}
+		if r.shutdown {
+			r.mu.Unlock()
+			return
+		}
+		if snapshot := r.restore; snapshot != nil {
+			r.mu.Unlock()
+			if err := r.restoreSnapshot(snapshot); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: raft node %s failed to restore snapshot %d: %v\n", r.config.ID, snapshot.Index, err)
+				r.pause()
+			}
+			continue
+		}
+		lo, hi := r.lastApplied+1, r.commitIndex+1
+		r.mu.Unlock()
+
+		entries, err := r.storage.Entries(lo, hi)
+		if err != nil {
+			// Entries compacted by an installed snapshot are skipped when it
+			// is restored
+			if !errors.Is(err, ErrCompacted) {
+				fmt.Fprintf(os.Stderr, "Warning: raft node %s failed to read committed entries: %v\n", r.config.ID, err)
+			}
+			r.pause()
+			continue
+		}
+
+		for _, entry := range entries {
+			r.applyMu.Lock()
+			var value any
+			if entry.Type == EntryNormal {
+				value = r.fsm.Apply(entry)
+			}
+
+			r.mu.Lock()
+			r.lastApplied = entry.Index
+			if p, ok := r.waiters[entry.Index]; ok {
+				delete(r.waiters, entry.Index)
+				if p.term == entry.Term {
+					p.done <- proposalResult{value: value}
+				} else {
+					p.done <- proposalResult{err: ErrLeadershipLost}
+				}
+			}
+			r.mu.Unlock()
+			r.applyMu.Unlock()
+		}
+	}
+}
+
+// restoreSnapshot loads an installed snapshot into the state machine
+func (r *Raft) restoreSnapshot(snapshot *Snapshot) error {
+	r.applyMu.Lock()
+	defer r.applyMu.Unlock()
+	if err := r.fsm.Restore(snapshot.Data); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.restore == snapshot {
+		r.restore = nil
+	}
+	if snapshot.Index > r.lastApplied {
+		r.lastApplied = snapshot.Index
+	}
+	return nil
+}
+
+// pause waits a heartbeat before a failed step is retried
+func (r *Raft) pause() {
+	select {
+	case <-r.ctx.Done():
+	case <-time.After(r.config.HeartbeatInterval):
+	}
+}
+
+// appendEntries writes entries to storage, replacing any from the first
+// entry's index on
+func (r *Raft) appendEntries(entries []Entry) error {
+	if err := r.storage.Append(entries); err != nil {
+		return fmt.Errorf("failed to append to raft log: %w", err)
+	}
+	last := entries[len(entries)-1]
+	r.lastIndex, r.lastTerm = last.Index, last.Term
+	return nil
+}
+
+func (r *Raft) persist() error {
+	return r.storage.SetHardState(HardState{Term: r.term, Vote: r.vote})
+}
+
+func (r *Raft) failWaiters(err error) {
+	for index, p := range r.waiters {
+		delete(r.waiters, index)
+		p.done <- proposalResult{err: err}
+	}
+}
+
+// leaderAlive reports whether this node heard from a leader within the
+// minimum election timeout
+func (r *Raft) leaderAlive() bool {
+	if r.role == Leader {
+		return true
+	}
+	return r.leader != "" && time.Since(r.lastContact) < r.config.ElectionTimeout
+}
+
+// resetElectionTimer restarts the election timeout with a random duration
+// between one and two base timeouts, so that split votes are rare
+func (r *Raft) resetElectionTimer() {
+	r.lastContact = time.Now()
+	r.electionTimeout = r.config.ElectionTimeout + time.Duration(rand.Int63n(int64(r.config.ElectionTimeout)))
+}
+
+func (r *Raft) quorum() int {
+	return (len(r.peers)+1)/2 + 1
+}
+
+func minIndex(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,546 @@
+package barnctl
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	testElectionTimeout   = 50 * time.Millisecond
+	testHeartbeatInterval = 10 * time.Millisecond
+	testWait              = 5 * time.Second
+)
+
+// testCluster is a cluster of stores on an in-memory network
+type testCluster struct {
+	t        *testing.T
+	network  *InmemNetwork
+	peers    []Peer
+	stores   map[string]*Store
+	storages map[string]Storage
+
+	// configure adjusts each node's configuration, and may set its storage
+	configure func(config *RaftConfig)
+}
+
+func newTestCluster(t *testing.T, size int) *testCluster {
+	return newTestClusterWith(t, size, nil)
+}
+
+func newTestClusterWith(t *testing.T, size int, configure func(config *RaftConfig)) *testCluster {
+	c := &testCluster{
+		t:         t,
+		network:   NewInmemNetwork(),
+		stores:    make(map[string]*Store),
+		storages:  make(map[string]Storage),
+		configure: configure,
+	}
+	for i := 1; i <= size; i++ {
+		id := fmt.Sprintf("barn-%d", i)
+		c.peers = append(c.peers, Peer{ID: id, Addr: id + ":8800"})
+	}
+	for _, peer := range c.peers {
+		c.start(peer.ID)
+	}
+	t.Cleanup(func() {
+		for id := range c.stores {
+			c.stop(id)
+		}
+	})
+	return c
+}
+
+func (c *testCluster) start(id string) {
+	config := RaftConfig{
+		ID:                id,
+		Peers:             c.peers,
+		Transport:         c.network.Transport(id),
+		ElectionTimeout:   testElectionTimeout,
+		HeartbeatInterval: testHeartbeatInterval,
+	}
+	if c.configure != nil {
+		c.configure(&config)
+	}
+	store, err := NewStore(config)
+	if err != nil {
+		c.t.Fatalf("Failed to start %s: %v", id, err)
+	}
+	c.stores[id] = store
+	c.storages[id] = config.Storage
+}
+
+func (c *testCluster) stop(id string) {
+	c.stores[id].Close()
+	if closer, ok := c.storages[id].(io.Closer); ok {
+		closer.Close()
+	}
+	delete(c.stores, id)
+	delete(c.storages, id)
+}
+
+// leader waits until exactly one of the given members leads and all of
+// them agree on it
+func (c *testCluster) leader(members ...string) string {
+	if len(members) == 0 {
+		for id := range c.stores {
+			members = append(members, id)
+		}
+	}
+	deadline := time.Now().Add(testWait)
+	for time.Now().Before(deadline) {
+		var leaders []string
+		agreed := true
+		for _, id := range members {
+			status := c.stores[id].Raft().Status()
+			if status.Role == Leader.String() {
+				leaders = append(leaders, id)
+			}
+			if status.Leader != c.stores[members[0]].Raft().Leader() || status.Leader == "" {
+				agreed = false
+			}
+		}
+		if len(leaders) == 1 && agreed {
+			return leaders[0]
+		}
+		time.Sleep(testHeartbeatInterval)
+	}
+	c.t.Fatalf("No single leader elected among %v", members)
+	return ""
+}
+
+// put writes through the leader among members
+func (c *testCluster) put(key, value string, members ...string) {
+	ctx, cancel := context.WithTimeout(context.Background(), testWait)
+	defer cancel()
+	if err := c.stores[c.leader(members...)].Put(ctx, key, []byte(value)); err != nil {
+		c.t.Fatalf("Failed to put %s: %v", key, err)
+	}
+}
+
+// waitValue waits until all members see key set to value
+func (c *testCluster) waitValue(key, value string, members ...string) {
+	if len(members) == 0 {
+		for id := range c.stores {
+			members = append(members, id)
+		}
+	}
+	deadline := time.Now().Add(testWait)
+	for _, id := range members {
+		for {
+			got, _ := c.stores[id].Get(key)
+			if string(got) == value {
+				break
+			}
+			if time.Now().After(deadline) {
+				c.t.Fatalf("%s: expected %s=%q, got %q", id, key, value, got)
+			}
+			time.Sleep(testHeartbeatInterval)
+		}
+	}
+}
+
+// TestRaftReplication tests elections and replication in three- and
+// five-node clusters
+func TestRaftReplication(t *testing.T) {
+	for _, size := range []int{3, 5} {
+		t.Run(fmt.Sprintf("%d nodes", size), func(t *testing.T) {
+			c := newTestCluster(t, size)
+			leader := c.leader()
+
+			for i := 0; i < 20; i++ {
+				c.put(fmt.Sprintf("herds/finance/%02d", i), fmt.Sprint(i))
+			}
+			c.waitValue("herds/finance/19", "19")
+			for id, store := range c.stores {
+				if keys := store.List("herds/finance/"); len(keys) != 20 {
+					t.Errorf("%s: expected 20 keys, got %d", id, len(keys))
+				}
+			}
+
+			// Followers refuse writes and name the leader
+			for id, store := range c.stores {
+				if id == leader {
+					continue
+				}
+				err := store.Put(context.Background(), "k", []byte("v"))
+				var notLeader *NotLeaderError
+				if !errors.As(err, &notLeader) || notLeader.Leader != leader {
+					t.Errorf("%s: expected NotLeaderError for leader %s, got %v", id, leader, err)
+				}
+				break
+			}
+
+			// Deletes replicate and report missing keys
+			ctx := context.Background()
+			if err := c.stores[leader].Delete(ctx, "herds/finance/00"); err != nil {
+				t.Fatalf("Failed to delete: %v", err)
+			}
+			if err := c.stores[leader].Delete(ctx, "herds/finance/00"); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("Expected ErrKeyNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+// TestRaftLeaderFailover tests that a new leader takes over with all
+// committed writes when the leader stops, and that the old leader catches
+// up when it restarts
+func TestRaftLeaderFailover(t *testing.T) {
+	c := newTestCluster(t, 3)
+	c.put("before", "1")
+	c.waitValue("before", "1")
+
+	old := c.leader()
+	oldTerm := c.stores[old].Raft().Status().Term
+	c.stop(old)
+
+	leader := c.leader()
+	if leader == old {
+		t.Fatalf("Expected a new leader")
+	}
+	if term := c.stores[leader].Raft().Status().Term; term <= oldTerm {
+		t.Errorf("Expected a term after %d, got %d", oldTerm, term)
+	}
+	if value, _ := c.stores[leader].Get("before"); string(value) != "1" {
+		t.Errorf("New leader lost a committed write: %q", value)
+	}
+	c.put("after", "2")
+
+	// The restarted node has an empty log and is brought up to date
+	c.start(old)
+	c.waitValue("before", "1")
+	c.waitValue("after", "2")
+	if c.leader() != leader {
+		t.Error("Expected the restarted node not to disrupt the leader")
+	}
+}
+
+// TestRaftPartition tests that only a majority partition makes progress
+// and that a healed minority discards its uncommitted entries
+func TestRaftPartition(t *testing.T) {
+	c := newTestCluster(t, 5)
+	c.put("k", "committed")
+	c.waitValue("k", "committed")
+
+	// Cut the leader off with one follower
+	old := c.leader()
+	minority := []string{old}
+	var majority []string
+	for _, peer := range c.peers {
+		if peer.ID == old {
+			continue
+		}
+		if len(minority) < 2 {
+			minority = append(minority, peer.ID)
+		} else {
+			majority = append(majority, peer.ID)
+		}
+	}
+	c.network.Partition(minority, majority)
+
+	// The old leader cannot commit and steps down
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	err := c.stores[old].Put(ctx, "k", []byte("lost"))
+	cancel()
+	if err == nil {
+		t.Fatal("Expected a write in the minority partition to fail")
+	}
+
+	leader := c.leader(majority...)
+	c.put("k", "majority", majority...)
+	c.waitValue("k", "majority", majority...)
+
+	deadline := time.Now().Add(testWait)
+	for c.stores[old].Raft().IsLeader() && time.Now().Before(deadline) {
+		time.Sleep(testHeartbeatInterval)
+	}
+	if c.stores[old].Raft().IsLeader() {
+		t.Error("Expected the minority leader to step down")
+	}
+	for _, id := range minority {
+		if value, _ := c.stores[id].Get("k"); string(value) != "committed" {
+			t.Errorf("%s: expected the minority to keep the last committed value, got %q", id, value)
+		}
+	}
+
+	// After healing, everyone converges on the majority's log
+	c.network.Heal()
+	c.waitValue("k", "majority")
+	c.put("healed", "yes")
+	c.waitValue("healed", "yes")
+	if c.leader() != leader {
+		t.Error("Expected the healed minority not to depose the majority leader")
+	}
+
+	status := c.stores[leader].Raft().Status()
+	for id, store := range c.stores {
+		if s := store.Raft().Status(); s.CommitIndex > status.CommitIndex {
+			t.Errorf("%s: commit index %d ahead of the leader's %d", id, s.CommitIndex, status.CommitIndex)
+		}
+	}
+}
+
+// TestRaftSingleNode tests that a single-node cluster elects itself
+func TestRaftSingleNode(t *testing.T) {
+	store, err := NewStore(RaftConfig{
+		ID:                "barn-1",
+		Transport:         NewInmemNetwork().Transport("barn-1"),
+		ElectionTimeout:   testElectionTimeout,
+		HeartbeatInterval: testHeartbeatInterval,
+	})
+	if err != nil {
+		t.Fatalf("Failed to start store: %v", err)
+	}
+	defer store.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testWait)
+	defer cancel()
+	for !store.Raft().IsLeader() && ctx.Err() == nil {
+		time.Sleep(testHeartbeatInterval)
+	}
+	if err := store.Put(ctx, "k", []byte("v")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := store.Sync(ctx); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if value, ok := store.Get("k"); !ok || string(value) != "v" {
+		t.Errorf("Expected k=v, got %q", value)
+	}
+}
+
+// TestHTTPTransport tests a cluster replicating over HTTP on loopback
+func TestHTTPTransport(t *testing.T) {
+	var peers []Peer
+	for i := 1; i <= 3; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to reserve a port: %v", err)
+		}
+		peers = append(peers, Peer{ID: fmt.Sprintf("barn-%d", i), Addr: listener.Addr().String()})
+		listener.Close()
+	}
+
+	var stores []*Store
+	for _, peer := range peers {
+		store, err := NewStore(RaftConfig{
+			ID:                peer.ID,
+			Peers:             peers,
+			Transport:         NewHTTPTransport(peer.Addr, peers),
+			ElectionTimeout:   testElectionTimeout,
+			HeartbeatInterval: testHeartbeatInterval,
+		})
+		if err != nil {
+			t.Fatalf("Failed to start %s: %v", peer.ID, err)
+		}
+		defer store.Close()
+		stores = append(stores, store)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testWait)
+	defer cancel()
+	for {
+		var err error
+		for _, store := range stores {
+			if err = store.Put(ctx, "k", []byte("v")); err == nil {
+				break
+			}
+		}
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("Failed to write over HTTP: %v", err)
+		}
+		time.Sleep(testHeartbeatInterval)
+	}
+	for _, store := range stores {
+		for value, _ := store.Get("k"); string(value) != "v"; value, _ = store.Get("k") {
+			if ctx.Err() != nil {
+				t.Fatalf("%s: write not replicated", store.Raft().ID())
+			}
+			time.Sleep(testHeartbeatInterval)
+		}
+	}
+}
+
+// TestLoadPeers tests parsing the raft.peers file
+func TestLoadPeers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft.peers")
+	os.WriteFile(path, []byte("# barn members\n10.0.0.2:8080\n\nbarn-3 10.0.0.3:8080\nbarn-4 10.0.0.4:8080 10.1.0.4:7000"), 0644)
+
+	peers, err := LoadPeers(path)
+	if err != nil {
+		t.Fatalf("Failed to load peers: %v", err)
+	}
+	expected := []Peer{
+		{ID: "10.0.0.2:8080", Addr: "10.0.0.2:8080", RaftAddr: "10.0.0.2:8081"},
+		{ID: "barn-3", Addr: "10.0.0.3:8080", RaftAddr: "10.0.0.3:8081"},
+		{ID: "barn-4", Addr: "10.0.0.4:8080", RaftAddr: "10.1.0.4:7000"},
+	}
+	if fmt.Sprint(peers) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, peers)
+	}
+
+	for _, content := range []string{"10.0.0.2", "a b c", "a b c d", "a 10.0.0.2:8080 10.0.0.2:8080", "10.0.0.2:8080\n10.0.0.2:8080", "# empty\n"} {
+		os.WriteFile(path, []byte(content), 0644)
+		if _, err := LoadPeers(path); err == nil {
+			t.Errorf("Expected %q to be rejected", content)
+		}
+	}
+}
+
+// snapshotRecorder is an RPCHandler that records the snapshots it receives
+type snapshotRecorder struct {
+	size int
+}
+
+func (h *snapshotRecorder) HandleRequestVote(req *VoteRequest) (*VoteResponse, error) {
+	return &VoteResponse{}, nil
+}
+
+func (h *snapshotRecorder) HandleAppendEntries(req *AppendEntriesRequest) (*AppendEntriesResponse, error) {
+	return &AppendEntriesResponse{}, nil
+}
+
+func (h *snapshotRecorder) HandleInstallSnapshot(req *InstallSnapshotRequest) (*InstallSnapshotResponse, error) {
+	h.size = len(req.Data)
+	return &InstallSnapshotResponse{Term: req.Term}, nil
+}
+
+// TestServerRaftRPCs tests that Raft RPCs mounted on the API server are
+// not held to the API's body limit
+func TestServerRaftRPCs(t *testing.T) {
+	server := httptest.NewUnstartedServer(nil)
+	peers := []Peer{{ID: "barn-1", Addr: server.Listener.Addr().String()}}
+	transport := NewHTTPTransport("", peers)
+	recorder := &snapshotRecorder{}
+	transport.Serve(recorder)
+	server.Config.Handler = NewServer(ServerConfig{Peers: peers, Raft: transport}).Handler()
+	server.Start()
+	defer server.Close()
+
+	snapshot := &InstallSnapshotRequest{Term: 3, LeaderID: "barn-2", Data: make([]byte, 2*maxRequestBody)}
+	resp, err := transport.InstallSnapshot(context.Background(), "barn-1", snapshot)
+	if err != nil || resp.Term != 3 || recorder.size != len(snapshot.Data) {
+		t.Fatalf("Expected the snapshot to be installed, got %+v, %v", resp, err)
+	}
+}
+
+// testCA issues certificates for tests, written as PEM files to dir
+type testCA struct {
+	t    *testing.T
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func newTestCA(t *testing.T, dir string) *testCA {
+	ca := &testCA{t: t, dir: dir}
+	ca.cert, ca.key, ca.file = ca.issue("ca", true)
+	return ca
+}
+
+// issue writes a certificate for name and its key, and returns the
+// certificate file
+func (ca *testCA) issue(name string, isCA bool) (*x509.Certificate, *ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		ca.t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	parent, signer := template, key
+	if ca.cert != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		ca.t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	file := filepath.Join(ca.dir, name+".crt")
+	os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	os.WriteFile(filepath.Join(ca.dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return cert, key, file
+}
+
+// TestHTTPTransportTLS tests that Raft RPCs over mutual TLS are only
+// accepted from members with certificates of the cluster's CA
+func TestHTTPTransportTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, other := newTestCA(t, dir), newTestCA(t, t.TempDir())
+	for _, name := range []string{"barn-1", "barn-2", "mallory"} {
+		ca.issue(name, false)
+	}
+	other.issue("barn-2", false)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve a port: %v", err)
+	}
+	peers := []Peer{{ID: "barn-1", Addr: "127.0.0.1:1", RaftAddr: listener.Addr().String()}, {ID: "barn-2", Addr: "127.0.0.1:2"}}
+	listener.Close()
+	transport := func(name, certDir string) *HTTPTransport {
+		config, err := PeerTLSConfig(filepath.Join(certDir, name+".crt"), filepath.Join(certDir, name+".key"), ca.file, peers)
+		if err != nil {
+			t.Fatalf("Failed to configure TLS: %v", err)
+		}
+		transport := NewHTTPTransport("", peers)
+		transport.SetTLSConfig(config)
+		return transport
+	}
+
+	server := transport("barn-1", dir)
+	server.Listen = peers[0].RaftAddr
+	recorder := &snapshotRecorder{}
+	if err := server.Serve(recorder); err != nil {
+		t.Fatalf("Failed to serve: %v", err)
+	}
+	defer server.Close()
+
+	ctx := context.Background()
+	snapshot := &InstallSnapshotRequest{Term: 2, LeaderID: "barn-2", Data: []byte("state")}
+	if _, err := transport("barn-2", dir).InstallSnapshot(ctx, "barn-1", snapshot); err != nil || recorder.size != len(snapshot.Data) {
+		t.Fatalf("Expected a member to install a snapshot, got %v", err)
+	}
+	recorder.size = 0
+	if _, err := transport("mallory", dir).InstallSnapshot(ctx, "barn-1", snapshot); err == nil {
+		t.Error("Expected a certificate of no member to be rejected")
+	}
+	if _, err := transport("barn-2", other.dir).InstallSnapshot(ctx, "barn-1", snapshot); err == nil {
+		t.Error("Expected a certificate of another CA to be rejected")
+	}
+	if _, err := NewHTTPTransport("", peers).InstallSnapshot(ctx, "barn-1", snapshot); err == nil {
+		t.Error("Expected plain HTTP to be rejected")
+	}
+	if recorder.size != 0 {
+		t.Error("Expected no snapshot from outside the cluster")
+	}
+}
@@ -0,0 +1,388 @@
+package barnctl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/runink/herdctl"
+)
+
+// Principal is who makes an API request. Name identifies it in the audit
+// log, as in "user:alice". Subjects are the user and groups the herds' role
+// bindings grant roles to; Roles are roles it holds besides those. Token
+// is the token the principal presented, if any; a token for a herd limits
+// the principal to it, and to the token's scopes.
+type Principal struct {
+	Name     string
+	Subjects []string
+	Roles    []string
+	Token    *herdctl.Claims
+}
+
+// Authenticator identifies the principal of a request. It returns nil and
+// no error for requests without its kind of credentials, and an error for
+// credentials it rejects.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// ClientCertAuthenticator authenticates requests by the TLS client
+// certificate the server verified. The certificate's common name is the
+// user and its organizations are the user's groups.
+type ClientCertAuthenticator struct{}
+
+func (ClientCertAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, nil
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	if subject.CommonName == "" {
+		return nil, fmt.Errorf("%w: client certificate without a common name", ErrUnauthenticated)
+	}
+	principal := &Principal{Name: "user:" + subject.CommonName, Subjects: []string{"user:" + subject.CommonName}}
+	for _, group := range subject.Organization {
+		principal.Subjects = append(principal.Subjects, "group:"+group)
+	}
+	return principal, nil
+}
+
+// RBACConfig enforces the herds' RBAC policies on the API. Requests within
+// a herd need an action its policies allow the principal, and so do audit
+// events about a slice and reads of the artifacts a herd owns; the routes
+// outside of any herd, like creating and listing herds, reading the audit
+// log, the registry writes, watches and token revocations, are left to the
+// admins.
+type RBACConfig struct {
+	// Authenticators identify the principal of a request. The first one
+	// that finds credentials decides.
+	Authenticators []Authenticator
+
+	// Admins are the subjects allowed every request
+	Admins []string
+}
+
+type principalContextKey struct{}
+
+// WithPrincipal returns a context of a request made by principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// PrincipalFrom returns the principal of a request context, or nil
+func PrincipalFrom(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalContextKey{}).(*Principal)
+	return principal
+}
+
+// withAuthentication identifies the principal of each request. Requests
+// without credentials go on without one and fail authorization if they
+// need it; /healthz and the Raft RPCs never do.
+func withAuthentication(authenticators []Authenticator) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, authenticator := range authenticators {
+				principal, err := authenticator.Authenticate(r)
+				if err != nil {
+					writeError(w, err)
+					return
+				}
+				if principal != nil {
+					r = r.WithContext(WithPrincipal(r.Context(), principal))
+					break
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// permission is what a route needs: an action in the route's herd, on the
+// resource named by the route parameter param, if any. Routes outside of a
+// herd need an admin, or just a principal if open.
+type permission struct {
+	action string
+	param  string
+	open   bool
+}
+
+// authorize checks each API request against the policies of its herd
+// before next serves it
+func (s *Server) authorize(next http.Handler) http.Handler {
+	if s.config.RBAC == nil {
+		return next
+	}
+	rt := NewRouter()
+	handle := func(method, pattern string, perm permission) {
+		rt.Handle(method, APIPrefix+pattern, func(w http.ResponseWriter, r *http.Request, params Params) {
+			resource := ""
+			if perm.param != "" {
+				_, kind, _ := strings.Cut(perm.action, ":")
+				name := params[perm.param]
+				if perm.param == "body" {
+					var err error
+					if name, err = bodyName(r); err != nil {
+						s.fail(w, r, err)
+						return
+					}
+				}
+				resource = kind + "/" + name
+			}
+			if err := s.allowed(r, params["herd"], perm, resource); err != nil {
+				s.fail(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	open := permission{open: true}
+	admin := permission{}
+
+	handle(http.MethodGet, "/status", open)
+
+	handle(http.MethodGet, "/herds", admin)
+	handle(http.MethodPost, "/herds", admin)
+	handle(http.MethodGet, "/herds/{herd}", permission{action: "read:herd"})
+	handle(http.MethodPut, "/herds/{herd}", permission{action: "manage:herd"})
+	handle(http.MethodDelete, "/herds/{herd}", permission{action: "manage:herd"})
+	handle(http.MethodPatch, "/herds/{herd}/quota", permission{action: "manage:herd"})
+
+	handle(http.MethodGet, "/herds/{herd}/slices", permission{action: "read:pipelines"})
+	handle(http.MethodPost, "/herds/{herd}/slices", permission{action: "execute:pipelines", param: "body"})
+	handle(http.MethodGet, "/herds/{herd}/slices/{slice}", permission{action: "read:pipelines", param: "slice"})
+	handle(http.MethodPut, "/herds/{herd}/slices/{slice}", permission{action: "write:pipelines", param: "slice"})
+	handle(http.MethodDelete, "/herds/{herd}/slices/{slice}", permission{action: "execute:pipelines", param: "slice"})
+
+	handle(http.MethodGet, "/herds/{herd}/secrets", permission{action: "access:secrets"})
+	handle(http.MethodGet, "/herds/{herd}/secrets/{secret}", permission{action: "access:secrets", param: "secret"})
+	handle(http.MethodPut, "/herds/{herd}/secrets/{secret}", permission{action: "access:secrets", param: "secret"})
+	handle(http.MethodDelete, "/herds/{herd}/secrets/{secret}", permission{action: "access:secrets", param: "secret"})
+	handle(http.MethodGet, "/herds/{herd}/secrets/{secret}/versions", permission{action: "access:secrets", param: "secret"})
+	handle(http.MethodPost, "/herds/{herd}/reencrypt", permission{action: "manage:herd"})
+
+	handle(http.MethodGet, "/herds/{herd}/snapshots", permission{action: "read:snapshots"})
+	handle(http.MethodPost, "/herds/{herd}/snapshots", permission{action: "write:snapshots"})
+	handle(http.MethodGet, "/herds/{herd}/snapshots/{snapshot}", permission{action: "read:snapshots", param: "snapshot"})
+	handle(http.MethodDelete, "/herds/{herd}/snapshots/{snapshot}", permission{action: "write:snapshots", param: "snapshot"})
+	handle(http.MethodPost, "/herds/{herd}/snapshots/{snapshot}/restore", permission{action: "manage:herd"})
+
+	handle(http.MethodGet, "/audit", admin)
+	// Events about a slice need the right to run it; any other is for admins
+	rt.Handle(http.MethodPost, APIPrefix+"/audit", func(w http.ResponseWriter, r *http.Request, _ Params) {
+		event := AuditEvent{}
+		if err := peekBody(r, &event); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		perm, herd, resource := admin, "", ""
+		if eventHerd, slice, ok := auditEventSlice(event.Resource); ok {
+			perm, herd, resource = permission{action: "execute:pipelines"}, eventHerd, "pipelines/"+slice
+		}
+		if err := s.allowed(r, herd, perm, resource); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+	handle(http.MethodGet, "/audit/checkpoints", admin)
+
+	// Artifacts a herd owns are read with read:artifacts in it, see
+	// HerdAnnotation; the tags, releases and runs naming them are shared
+	for _, pattern := range []string{"/registry/artifacts/{digest}", "/registry/artifacts/{digest}/referrers"} {
+		rt.Handle(http.MethodGet, APIPrefix+pattern, func(w http.ResponseWriter, r *http.Request, params Params) {
+			if err := s.artifactReadable(r, params["digest"]); err != nil {
+				s.fail(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	rt.Handle(http.MethodGet, APIPrefix+"/registry/chunks/{digest}", func(w http.ResponseWriter, r *http.Request, params Params) {
+		if err := s.chunkReadable(r, params["digest"]); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+	for _, pattern := range []string{"/registry/tags", "/registry/tags/{repository}/{tag}", "/registry/releases",
+		"/registry/releases/{name}/{version}", "/registry/runs/{run}"} {
+		handle(http.MethodGet, pattern, open)
+	}
+	handle(http.MethodPut, "/registry/chunks/{digest}", admin)
+	handle(http.MethodPut, "/registry/artifacts/{digest}", admin)
+	handle(http.MethodPut, "/registry/tags/{repository}/{tag}", admin)
+	handle(http.MethodDelete, "/registry/tags/{repository}/{tag}", admin)
+	handle(http.MethodPost, "/registry/releases", admin)
+	handle(http.MethodPut, "/registry/runs/{run}", admin)
+	handle(http.MethodDelete, "/registry/runs/{run}", admin)
+	handle(http.MethodPost, "/registry/gc", admin)
+
+	handle(http.MethodGet, "/tokens/revocations", admin)
+	handle(http.MethodPost, "/tokens/revocations", admin)
+
+	handle(http.MethodGet, "/watch", admin)
+	return rt
+}
+
+// allowed checks that the request's principal may take the route's action
+// on resource in herd
+func (s *Server) allowed(r *http.Request, herd string, perm permission, resource string) error {
+	principal := PrincipalFrom(r.Context())
+	if principal == nil {
+		return fmt.Errorf("%w: %s %s needs credentials", ErrUnauthenticated, r.Method, r.URL.Path)
+	}
+	if perm.open || s.isAdmin(principal) {
+		return nil
+	}
+	if perm.action == "" {
+		return &ForbiddenError{Principal: principal.Name, Reason: fmt.Sprintf("%s %s is for admins only", r.Method, r.URL.Path)}
+	}
+
+	forbidden := &ForbiddenError{Principal: principal.Name, Herd: herd, Action: perm.action, Resource: resource}
+	if principal.Token != nil {
+		if err := principal.Token.Covers(herd, perm.action, resource); err != nil {
+			forbidden.Reason = err.Error()
+			return forbidden
+		}
+	}
+	stored, err := s.readHerd(herd)
+	if err != nil {
+		// Whether a herd exists is only told to those who may see it
+		subjects := principal.Subjects
+		if len(subjects) == 0 {
+			subjects = []string{principal.Name}
+		}
+		forbidden.Reason = fmt.Sprintf("no role in herd %s is bound to %s", herd, strings.Join(subjects, ", "))
+		return forbidden
+	}
+	decision := herdPolicySet(stored).Evaluate(herdctl.Request{
+		Subjects: principal.Subjects,
+		Roles:    principal.Roles,
+		Action:   perm.action,
+		Resource: resource,
+	})
+	if !decision.Allowed {
+		forbidden.Reason = decision.Reason
+		return forbidden
+	}
+	return nil
+}
+
+// visible reports whether the request's principal may take action on the
+// named resource in herd. List routes only need the action on the whole
+// herd, so their handlers drop the items a policy scoped to them denies.
+func (s *Server) visible(r *http.Request, herd, action, name string) bool {
+	if s.config.RBAC == nil {
+		return true
+	}
+	_, kind, _ := strings.Cut(action, ":")
+	return s.allowed(r, herd, permission{action: action}, kind+"/"+name) == nil
+}
+
+// artifactReadable checks that the request's principal may read the
+// artifact with digest. Missing artifacts are left to the handler.
+func (s *Server) artifactReadable(r *http.Request, digest string) error {
+	artifact, err := s.readArtifact(digest)
+	if err != nil {
+		return s.allowed(r, "", permission{open: true}, "")
+	}
+	return s.artifactAllowed(r, artifact)
+}
+
+// chunkReadable checks that the request's principal may read an artifact
+// made of the chunk with digest. Chunks of no artifact yet, as during a
+// push, are for admins only.
+func (s *Server) chunkReadable(r *http.Request, digest string) error {
+	err := s.allowed(r, "", permission{}, "")
+	if err == nil {
+		return nil
+	}
+	ranges, _ := s.store.Ranges(artifactKeyPrefix)
+	for _, kv := range ranges[0] {
+		artifact := Artifact{}
+		if decodeResource(kv, &artifact, &artifact.Revision) != nil {
+			continue
+		}
+		for _, chunk := range artifact.Chunks {
+			if chunk != digest {
+				continue
+			}
+			if err = s.artifactAllowed(r, &artifact); err == nil {
+				return nil
+			}
+			break
+		}
+	}
+	return err
+}
+
+// artifactAllowed checks that the request's principal may read artifact:
+// any principal if no herd owns it, else one with read:artifacts in it
+func (s *Server) artifactAllowed(r *http.Request, artifact *Artifact) error {
+	herd := artifact.Annotations[HerdAnnotation]
+	if herd == "" {
+		return s.allowed(r, "", permission{open: true}, "")
+	}
+	return s.allowed(r, herd, permission{action: "read:artifacts"}, "artifacts/"+artifact.Digest)
+}
+
+// artifactVisible reports whether the request's principal may read artifact
+func (s *Server) artifactVisible(r *http.Request, artifact *Artifact) bool {
+	herd := artifact.Annotations[HerdAnnotation]
+	return herd == "" || s.visible(r, herd, "read:artifacts", artifact.Digest)
+}
+
+// isAdmin reports whether principal is one of the admins. A token for a
+// herd never makes its holder an admin.
+func (s *Server) isAdmin(principal *Principal) bool {
+	if principal.Token != nil && principal.Token.Herd != "" {
+		return false
+	}
+	for _, admin := range s.config.RBAC.Admins {
+		for _, subject := range principal.Subjects {
+			if subject == admin {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// herdPolicySet returns the policies and role bindings of herd
+func herdPolicySet(herd *Herd) *herdctl.PolicySet {
+	set := &herdctl.PolicySet{Herd: herd.Name, Policies: herd.Policies}
+	for _, binding := range herd.RBAC {
+		set.Bindings = append(set.Bindings, herdctl.Binding{Role: binding.Role, Subjects: binding.Subjects})
+	}
+	return set
+}
+
+// bodyName reads the name of the resource a request creates, and leaves
+// the body to the handler
+func bodyName(r *http.Request) (string, error) {
+	resource := struct {
+		Name string `json:"name"`
+	}{}
+	if err := peekBody(r, &resource); err != nil {
+		return "", err
+	}
+	return resource.Name, nil
+}
+
+// peekBody decodes a request's JSON body into v, and leaves the body to the
+// handler
+func peekBody(r *http.Request, v any) error {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return &ValidationError{Field: "body", Message: err.Error()}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	if err := json.Unmarshal(data, v); err != nil {
+		return &ValidationError{Field: "body", Message: err.Error()}
+	}
+	return nil
+}
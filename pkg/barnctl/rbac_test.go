@@ -0,0 +1,283 @@
+package barnctl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/runink/herdctl"
+)
+
+// testAuthenticator trusts the subjects a test client claims in a header
+type testAuthenticator struct{}
+
+func (testAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	header := r.Header.Get("X-Test-Subjects")
+	if header == "" {
+		return nil, nil
+	}
+	subjects := strings.Split(header, ",")
+	return &Principal{Name: subjects[0], Subjects: subjects}, nil
+}
+
+type testSubjectsTransport string
+
+func (t testSubjectsTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("X-Test-Subjects", string(t))
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// TestAPIRBAC tests that herd requests need an action the herd's policies
+// allow, and that the rest of the API is left to the admins
+func TestAPIRBAC(t *testing.T) {
+	c := newTestCluster(t, 1)
+	leader := c.leader()
+	server := httptest.NewServer(NewServer(ServerConfig{
+		Store:     c.stores[leader],
+		MasterKey: testMasterKey,
+		RBAC:      &RBACConfig{Authenticators: []Authenticator{testAuthenticator{}}, Admins: []string{"group:barn-admins"}},
+	}).Handler())
+	t.Cleanup(server.Close)
+	client := func(subjects string) *Client {
+		api := NewClient(server.URL)
+		if subjects != "" {
+			api.HTTP = &http.Client{Transport: testSubjectsTransport(subjects)}
+		}
+		return api
+	}
+	admin, alice, bob := client("user:root,group:barn-admins"), client("user:alice"), client("user:bob,group:finance-ops")
+	ctx := context.Background()
+
+	if _, err := admin.CreateHerd(ctx, &Herd{
+		Name: "finance",
+		RBAC: []RoleBinding{
+			{Role: "analyst", Subjects: []string{"user:alice"}},
+			{Role: "operator", Subjects: []string{"group:finance-ops"}},
+		},
+		Policies: []herdctl.Policy{
+			{Role: "analyst", Actions: []string{"read:*"}},
+			{Role: "analyst", Effect: herdctl.EffectDeny, Actions: []string{"read:pipelines"}, Resources: []string{"pipelines/payroll-*"}},
+			{Role: "operator", Actions: []string{"execute:pipelines", "write:pipelines"}, Resources: []string{"pipelines/ledger-*"}},
+			{Role: "operator", Actions: []string{"access:secrets"}},
+			{Role: "operator", Effect: herdctl.EffectDeny, Actions: []string{"access:secrets"}, Resources: []string{"secrets/payroll-*"}},
+		},
+	}); err != nil {
+		t.Fatalf("Failed to create herd: %v", err)
+	}
+	if _, err := admin.CreateSlice(ctx, &Slice{Herd: "finance", Name: "payroll-monthly", Scenario: "payroll"}); err != nil {
+		t.Fatalf("Failed to create slice: %v", err)
+	}
+
+	if _, err := client("").GetHerd(ctx, "finance"); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Expected ErrUnauthenticated without credentials, got %v", err)
+	}
+	if _, err := alice.GetHerd(ctx, "finance"); err != nil {
+		t.Errorf("Expected the analyst to read the herd, got %v", err)
+	}
+	herd, _ := admin.GetHerd(ctx, "finance")
+	if _, err := alice.UpdateHerd(ctx, herd); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected the analyst not to manage the herd, got %v", err)
+	}
+	if _, err := alice.ListHerds(ctx, ""); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected listing herds to be left to the admins, got %v", err)
+	}
+	if _, err := alice.GetHerd(ctx, "missing"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected ErrForbidden for a missing herd, got %v", err)
+	}
+
+	// The operator may only run the ledger pipelines
+	if _, err := bob.CreateSlice(ctx, &Slice{Herd: "finance", Name: "ledger-daily", Scenario: "ledger"}); err != nil {
+		t.Fatalf("Expected the operator to create a ledger slice, got %v", err)
+	}
+	_, err := bob.CreateSlice(ctx, &Slice{Herd: "finance", Name: "payroll-daily", Scenario: "payroll"})
+	if !errors.Is(err, ErrForbidden) || !strings.Contains(err.Error(), "execute:pipelines on pipelines/payroll-daily") {
+		t.Errorf("Expected the operator not to create a payroll slice, got %v", err)
+	}
+	if err := bob.DeleteSlice(ctx, "finance", "payroll-monthly", 0); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected the operator not to delete a payroll slice, got %v", err)
+	}
+
+	// The deny wins over the analyst's read:*
+	if _, err := alice.GetSlice(ctx, "finance", "ledger-daily"); err != nil {
+		t.Errorf("Expected the analyst to read the ledger slice, got %v", err)
+	}
+	_, err = alice.GetSlice(ctx, "finance", "payroll-monthly")
+	if !errors.Is(err, ErrForbidden) || !strings.Contains(err.Error(), "denied by role analyst") {
+		t.Errorf("Expected the analyst to be denied the payroll slice, got %v", err)
+	}
+	// and hides the payroll slice from the list
+	if list, err := alice.ListSlices(ctx, "finance", ""); err != nil || len(list.Items) != 1 || list.Items[0].Name != "ledger-daily" {
+		t.Errorf("Expected the analyst to list only the ledger slice, got %+v, %v", list, err)
+	}
+	if list, err := admin.ListSlices(ctx, "finance", ""); err != nil || len(list.Items) != 2 {
+		t.Errorf("Expected the admin to list every slice, got %+v, %v", list, err)
+	}
+	for _, name := range []string{"ledger-key", "payroll-key"} {
+		if _, err := admin.PutSecret(ctx, "finance", name, []byte("s3cret")); err != nil {
+			t.Fatalf("Failed to put secret %s: %v", name, err)
+		}
+	}
+	if list, err := bob.ListSecrets(ctx, "finance"); err != nil || len(list.Items) != 1 || list.Items[0].Name != "ledger-key" {
+		t.Errorf("Expected the operator to list only the ledger secret, got %+v, %v", list, err)
+	}
+
+	// Writes are recorded as made by the principal
+	log, err := admin.AuditLog(ctx, 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to read the audit log: %v", err)
+	}
+	var actors []string
+	for _, entry := range log.Entries {
+		if strings.HasSuffix(entry.Resource, "/slices") {
+			actors = append(actors, entry.Actor)
+		}
+	}
+	if len(actors) != 2 || actors[0] != "user:root" || actors[1] != "user:bob" {
+		t.Errorf("Expected the slices created by the admin and the operator, got %v", actors)
+	}
+
+	// Events about a slice need the right to run it
+	event := AuditEvent{Action: "slice.start", Resource: "/api/v1/herds/finance/slices/ledger-daily"}
+	if err := bob.RecordAudit(ctx, event); err != nil {
+		t.Errorf("Expected the operator to audit a ledger slice, got %v", err)
+	}
+	if err := alice.RecordAudit(ctx, event); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected the analyst not to audit a slice, got %v", err)
+	}
+	for _, resource := range []string{"/api/v1/herds/finance/slices/payroll-monthly", "/api/v1/herds/hr/slices/ledger-daily", "/api/v1/herds/finance", "ledger-daily"} {
+		event := AuditEvent{Action: "slice.start", Resource: resource}
+		if err := bob.RecordAudit(ctx, event); !errors.Is(err, ErrForbidden) {
+			t.Errorf("Expected the operator not to audit %s, got %v", resource, err)
+		}
+	}
+	if err := admin.RecordAudit(ctx, AuditEvent{Action: "node.drain", Resource: "nodes/n1"}); err != nil {
+		t.Errorf("Expected the admin to write any event, got %v", err)
+	}
+
+	// Artifacts a herd owns are read with read:artifacts in it
+	carol := client("user:carol")
+	owned, err := admin.PushArtifact(ctx, ArtifactDAG, "", []byte("ledger dag"), map[string]string{HerdAnnotation: "finance"})
+	if err != nil {
+		t.Fatalf("Failed to push artifact: %v", err)
+	}
+	shared, err := admin.PushArtifact(ctx, ArtifactContract, "", []byte("shared contract"), nil)
+	if err != nil {
+		t.Fatalf("Failed to push artifact: %v", err)
+	}
+	if _, err := admin.AttachArtifact(ctx, shared.Digest, ArtifactSBOM, "", []byte("ledger sbom"), map[string]string{HerdAnnotation: "finance"}); err != nil {
+		t.Fatalf("Failed to attach artifact: %v", err)
+	}
+	if _, data, err := alice.PullArtifact(ctx, owned.Digest); err != nil || string(data) != "ledger dag" {
+		t.Errorf("Expected the analyst to pull the herd's artifact, got %q, %v", data, err)
+	}
+	if _, _, err := carol.PullArtifact(ctx, owned.Digest); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected a principal outside the herd not to pull its artifact, got %v", err)
+	}
+	if err := carol.do(ctx, http.MethodGet, "/registry/chunks/"+owned.Chunks[0], nil, nil, &ArtifactChunk{}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected a principal outside the herd not to read the artifact's chunk, got %v", err)
+	}
+	if _, data, err := carol.PullArtifact(ctx, shared.Digest); err != nil || string(data) != "shared contract" {
+		t.Errorf("Expected any principal to pull a shared artifact, got %q, %v", data, err)
+	}
+	if list, err := carol.Referrers(ctx, shared.Digest, ""); err != nil || len(list.Items) != 0 {
+		t.Errorf("Expected the herd's SBOM to be hidden from referrers, got %+v, %v", list, err)
+	}
+	if list, err := alice.Referrers(ctx, shared.Digest, ""); err != nil || len(list.Items) != 1 {
+		t.Errorf("Expected the analyst to list the herd's SBOM, got %+v, %v", list, err)
+	}
+	if _, err := client("").GetArtifact(ctx, shared.Digest); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Expected ErrUnauthenticated without credentials, got %v", err)
+	}
+}
+
+// TestAPIClientCertificates tests serving the API over HTTPS to clients
+// identified by certificates of the --client-ca
+func TestAPIClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca, other := newTestCA(t, dir), newTestCA(t, t.TempDir())
+	for _, name := range []string{"barn", "alice", "bob"} {
+		ca.issue(name, false)
+	}
+	other.issue("alice", false)
+
+	options := &ServerOptions{TLSCert: filepath.Join(dir, "barn.crt"), TLSKey: filepath.Join(dir, "barn.key"), ClientCA: ca.file}
+	config, err := apiTLSConfig(options)
+	if err != nil {
+		t.Fatalf("Failed to configure TLS: %v", err)
+	}
+	c := newTestCluster(t, 1)
+	server := httptest.NewUnstartedServer(NewServer(ServerConfig{
+		Store:     c.stores[c.leader()],
+		TLSConfig: config,
+		RBAC: &RBACConfig{
+			Authenticators: []Authenticator{ClientCertAuthenticator{}},
+			Admins:         []string{"user:alice"},
+		},
+	}).Handler())
+	server.TLS = config
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	client := func(certDir, name string) *Client {
+		options := &ClientOptions{Endpoint: server.URL, Timeout: 10 * time.Second, CACert: ca.file}
+		if name != "" {
+			options.ClientCert, options.ClientKey = filepath.Join(certDir, name+".crt"), filepath.Join(certDir, name+".key")
+		}
+		api, _, cancel := options.client(&cobra.Command{})
+		cancel()
+		return api
+	}
+	ctx := context.Background()
+
+	if _, err := client(dir, "alice").CreateHerd(ctx, &Herd{Name: "finance"}); err != nil {
+		t.Fatalf("Expected the admin's certificate to create a herd, got %v", err)
+	}
+	if _, err := client(dir, "bob").CreateHerd(ctx, &Herd{Name: "hr"}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected another user's certificate to be forbidden, got %v", err)
+	}
+	if _, err := client(dir, "").ListHerds(ctx, ""); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Expected a request without a certificate to fail, got %v", err)
+	}
+	if _, err := client(other.dir, "alice").ListHerds(ctx, ""); err == nil {
+		t.Error("Expected a certificate of another CA to fail")
+	}
+
+	for _, options := range []*ServerOptions{
+		{ClientCA: ca.file},
+		{TLSCert: filepath.Join(dir, "barn.crt")},
+		{TLSCert: filepath.Join(dir, "barn.crt"), TLSKey: filepath.Join(dir, "barn.key"), ClientCA: filepath.Join(dir, "barn.key")},
+	} {
+		if _, err := apiTLSConfig(options); err == nil {
+			t.Errorf("%+v: expected an error", options)
+		}
+	}
+}
+
+// TestServeSecretsWithoutRBAC tests that barnctl serve refuses to serve
+// secrets without RBAC unless told to
+func TestServeSecretsWithoutRBAC(t *testing.T) {
+	dir := t.TempDir()
+	peers := filepath.Join(dir, "peers")
+	os.WriteFile(peers, []byte("n1 127.0.0.1:18080\n"), 0644)
+	masterKey := filepath.Join(dir, "master.key")
+	os.WriteFile(masterKey, []byte(strings.Repeat("07", keySize)), 0600)
+
+	err := runServer(context.Background(), &ServerOptions{
+		NodeID:        "n1",
+		PeersFile:     peers,
+		StoreDir:      filepath.Join(dir, "wal"),
+		WALSync:       SyncAlways.String(),
+		MasterKeyFile: masterKey,
+	})
+	if err == nil || !strings.Contains(err.Error(), "--insecure-no-rbac") {
+		t.Errorf("Expected serving secrets without --rbac to fail, got %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+package barnctl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// DefaultReencryptInterval is how often the leader rotates the KEKs that
+// are due by their herd's rotation policy and rewraps the data keys
+const DefaultReencryptInterval = time.Hour
+
+// ReencryptResult is the body of POST /api/v1/herds/{herd}/reencrypt
+type ReencryptResult struct {
+	Herd string `json:"herd"`
+
+	// KEKVersion is the herd's current KEK, 0 if the herd has no secrets
+	KEKVersion uint32 `json:"kekVersion"`
+	Rotated    bool   `json:"rotated"`
+
+	// Rewrapped counts the secret versions moved to the current KEK, and
+	// Skipped those that changed meanwhile and are left to the next run
+	Rewrapped int `json:"rewrapped"`
+	Skipped   int `json:"skipped"`
+
+	// Destroyed are the KEK versions no secret version used any more
+	Destroyed []uint32 `json:"destroyed,omitempty"`
+}
+
+// ParseRotationPolicy parses a KEK rotation age, in days as in "90d" or
+// as a duration as in "720h". An empty policy returns 0.
+func ParseRotationPolicy(policy string) (time.Duration, error) {
+	if policy == "" {
+		return 0, nil
+	}
+	var age time.Duration
+	if days, ok := strings.CutSuffix(policy, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid rotation policy %q: expected days as in 90d or a duration", policy)
+		}
+		age = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if age, err = time.ParseDuration(policy); err != nil {
+			return 0, fmt.Errorf("invalid rotation policy %q: expected days as in 90d or a duration", policy)
+		}
+	}
+	if age <= 0 {
+		return 0, fmt.Errorf("invalid rotation policy %q: must be positive", policy)
+	}
+	return age, nil
+}
+
+// reencrypt moves the data keys of a herd's secret versions to its current
+// KEK, after rotating the KEK if rotate is set, and destroys the KEKs no
+// version uses any more. Secrets stay readable throughout: each version is
+// rewrapped in a transaction of its own, and an old KEK is only destroyed
+// once nothing refers to it. Writes and rewraps only commit under the
+// current KEK, so a KEK that is no longer current only loses users.
+func (k *keyRing) reencrypt(ctx context.Context, herd string, rotate bool) (*ReencryptResult, error) {
+	result := &ReencryptResult{Herd: herd}
+	if err := k.store.Sync(ctx); err != nil {
+		return result, err
+	}
+	var kek *herdKEK
+	var err error
+	if rotate {
+		kek, err = k.rotate(ctx, herd)
+		result.Rotated = err == nil
+	} else {
+		kek, err = k.current(herd)
+	}
+	if err != nil || kek == nil {
+		return result, err
+	}
+	defer zero(kek.key)
+	result.KEKVersion = kek.version
+
+	old := make(map[uint32]*herdKEK)
+	defer func() {
+		for _, key := range old {
+			zero(key.key)
+		}
+	}()
+	for _, kv := range k.store.Range(secretPrefix(herd)) {
+		version := &SecretVersion{}
+		if err := json.Unmarshal(kv.Value, version); err != nil {
+			return result, fmt.Errorf("corrupt secret %s: %w", kv.Key, err)
+		}
+		if version.KEKVersion == kek.version {
+			continue
+		}
+		from, ok := old[version.KEKVersion]
+		if !ok {
+			if from, err = k.get(herd, version.KEKVersion); err != nil {
+				return result, err
+			}
+			old[version.KEKVersion] = from
+		}
+		if err := rewrapSecret(from, kek, version); err != nil {
+			return result, err
+		}
+		data, err := json.Marshal(version)
+		if err != nil {
+			return result, err
+		}
+		conditions := []Condition{IfRevision(kv.Key, kv.ModRevision), IfExists(kekKey(herd, kek.version)), currentCondition(kek)}
+		if _, err := k.store.Txn(ctx, conditions, OpPut(kv.Key, data)); err != nil {
+			if !errors.Is(err, ErrConflict) {
+				return result, err
+			}
+			result.Skipped++
+			continue
+		}
+		result.Rewrapped++
+	}
+
+	// Destroy the old KEKs that nothing refers to any more
+	used := make(map[uint32]bool)
+	for _, kv := range k.store.Range(secretPrefix(herd)) {
+		version := SecretVersion{}
+		if err := json.Unmarshal(kv.Value, &version); err != nil {
+			return result, fmt.Errorf("corrupt secret %s: %w", kv.Key, err)
+		}
+		used[version.KEKVersion] = true
+	}
+	records, revisions, err := k.records(herd)
+	if err != nil {
+		return result, err
+	}
+	for i, record := range records {
+		if record.Version >= kek.version || used[record.Version] {
+			continue
+		}
+		key := kekKey(herd, record.Version)
+		if _, err := k.store.Txn(ctx, []Condition{IfRevision(key, revisions[i])}, OpDelete(key)); err != nil {
+			if errors.Is(err, ErrConflict) || errors.Is(err, ErrKeyNotFound) {
+				continue
+			}
+			return result, err
+		}
+		result.Destroyed = append(result.Destroyed, record.Version)
+	}
+	return result, nil
+}
+
+// due reports whether the current KEK of a herd is older than its
+// rotation policy. Herds without secrets have nothing to rotate.
+func (k *keyRing) due(herd *Herd, now time.Time) (bool, error) {
+	age, err := ParseRotationPolicy(herd.Secrets.RotationPolicy)
+	if err != nil || age == 0 {
+		return false, err
+	}
+	records, _, err := k.records(herd.Name)
+	if err != nil || len(records) == 0 {
+		return false, err
+	}
+	return now.Sub(records[len(records)-1].CreatedAt) >= age, nil
+}
+
+// RunReencrypt rotates the KEKs that are due and rewraps the data keys of
+// every herd each interval, while the node is the leader, until ctx is
+// done. Nodes without a master key return at once.
+func (s *Server) RunReencrypt(ctx context.Context, interval time.Duration) {
+	if s.keys == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !s.store.Raft().IsLeader() {
+			continue
+		}
+		s.reencryptAll(ctx)
+	}
+}
+
+func (s *Server) reencryptAll(ctx context.Context) {
+	for _, kv := range s.store.Range(herdKeyPrefix) {
+		herd := Herd{}
+		if err := decodeResource(kv, &herd, &herd.Revision); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			continue
+		}
+		rotate, err := s.keys.due(&herd, time.Now())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: herd %s: %v\n", herd.Name, err)
+			continue
+		}
+		audit := AuditEvent{Actor: "barn", Action: "secrets.reencrypt", Resource: APIPrefix + herdPath(herd.Name)}
+		result, err := s.keys.reencrypt(WithAuditEvent(ctx, audit), herd.Name, rotate)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to reencrypt the secrets of herd %s: %v\n", herd.Name, err)
+			continue
+		}
+		if result.Rotated || result.Rewrapped > 0 || len(result.Destroyed) > 0 {
+			fmt.Fprintf(os.Stderr, "Reencrypted herd %s: KEK version %d, %d rewrapped, %d skipped, %d destroyed\n",
+				herd.Name, result.KEKVersion, result.Rewrapped, result.Skipped, len(result.Destroyed))
+		}
+	}
+}
+
+// reencryptHerd runs the reencrypt job for one herd, rotating its KEK
+// first with ?rotate=true
+func (s *Server) reencryptHerd(w http.ResponseWriter, r *http.Request, params Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	keys, err := s.secretKeys()
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	rotate := false
+	if value := r.URL.Query().Get("rotate"); value != "" {
+		if rotate, err = strconv.ParseBool(value); err != nil {
+			s.fail(w, r, &ValidationError{Field: "rotate", Message: fmt.Sprintf("%q is not a boolean", value)})
+			return
+		}
+	}
+	if _, err := s.readHerd(params["herd"]); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	result, err := keys.reencrypt(r.Context(), params["herd"], rotate)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func newReencryptCommand(client *ClientOptions) *cobra.Command {
+	var herd string
+	var rotate bool
+
+	cmd := &cobra.Command{
+		Use:   "reencrypt",
+		Short: "Rotate a Herd's key-encryption key and rewrap its secrets",
+		Long: `Move the data keys of a herd's secrets to its current key-encryption key,
+rotating the KEK first with --rotate, and destroy the KEKs no secret uses
+any more. Secrets stay readable while the job runs. The Barn leader also
+runs it every --reencrypt-interval, rotating KEKs older than the herd's
+secrets rotation policy.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			result, err := api.Reencrypt(ctx, herd, rotate)
+			if err != nil {
+				return fmt.Errorf("failed to reencrypt the secrets of herd %s: %w", herd, err)
+			}
+			out := cmd.OutOrStdout()
+			if result.KEKVersion == 0 {
+				fmt.Fprintf(out, "Herd %s has no secrets\n", herd)
+				return nil
+			}
+			if result.Rotated {
+				fmt.Fprintf(out, "Rotated the key-encryption key of herd %s to version %d\n", herd, result.KEKVersion)
+			}
+			fmt.Fprintf(out, "Rewrapped %d secret versions with key version %d\n", result.Rewrapped, result.KEKVersion)
+			if result.Skipped > 0 {
+				fmt.Fprintf(out, "Skipped %d secret versions that changed meanwhile; run reencrypt again\n", result.Skipped)
+			}
+			for _, version := range result.Destroyed {
+				fmt.Fprintf(out, "Destroyed key version %d\n", version)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&herd, "herd", "", "Herd whose secrets to reencrypt")
+	cmd.Flags().BoolVar(&rotate, "rotate", false, "Create a new key-encryption key first")
+	cmd.MarkFlagRequired("herd")
+	return cmd
+}
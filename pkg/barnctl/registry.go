@@ -0,0 +1,1625 @@
+package barnctl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// DefaultRegistryGCInterval is how often the leader deletes the
+	// artifacts no tag, release or run refers to
+	DefaultRegistryGCInterval = 24 * time.Hour
+
+	// DefaultRegistryGCGrace is how long unreferenced artifacts and chunks
+	// are kept, so that a push can upload its chunks and tag its artifact
+	// before they are collected
+	DefaultRegistryGCGrace = time.Hour
+
+	// artifactChunkSize is the size of the chunks artifacts are uploaded
+	// and stored in, which like secrets keeps Raft entries small enough
+	// to replicate between heartbeats
+	artifactChunkSize = 64 << 10
+
+	// maxArtifactSize caps artifacts, which the store holds in memory
+	maxArtifactSize = 32 << 20
+
+	// defaultTag is the tag of references without one
+	defaultTag = "latest"
+)
+
+// Artifact kinds
+const (
+	ArtifactDAG      = "dag"
+	ArtifactContract = "contract"
+	ArtifactDataset  = "dataset"
+	ArtifactSBOM     = "sbom"
+)
+
+// HerdAnnotation names the herd that owns an artifact. Reading it, its
+// chunks and its referrers needs read:artifacts in that herd; artifacts
+// without an owner are shared by the herds.
+const HerdAnnotation = "runink.io/herd"
+
+// Artifact is a compiled DAG bundle, contract or golden dataset, stored by
+// the SHA-256 digest of its content. The content is stored in chunks of
+// its own, so artifacts sharing a chunk share its storage. Subject is the
+// digest of the artifact this one describes, like the SBOM of a DAG
+// bundle; it is kept as long as its subject is.
+type Artifact struct {
+	Digest      string            `json:"digest"`
+	Kind        string            `json:"kind"`
+	Subject     string            `json:"subject,omitempty"`
+	MediaType   string            `json:"mediaType,omitempty"`
+	Size        int64             `json:"size"`
+	Chunks      []string          `json:"chunks"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	Revision    uint64            `json:"revision,omitempty"`
+}
+
+// ArtifactChunk is the body of PUT and GET
+// /api/v1/registry/chunks/{digest}
+type ArtifactChunk struct {
+	Data []byte `json:"data"`
+}
+
+// storedChunk is a chunk as stored in Barn. CreatedAt gives the chunks of
+// a push in progress their grace period.
+type storedChunk struct {
+	Data      []byte    `json:"data"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Tag is a mutable name of an artifact within a repository, like
+// ledger:latest
+type Tag struct {
+	Repository string    `json:"repository"`
+	Tag        string    `json:"tag"`
+	Digest     string    `json:"digest"`
+	Kind       string    `json:"kind"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	Revision   uint64    `json:"revision,omitempty"`
+}
+
+// ArtifactList is the body of GET
+// /api/v1/registry/artifacts/{digest}/referrers
+type ArtifactList struct {
+	Items    []Artifact `json:"items"`
+	Revision uint64     `json:"revision"`
+}
+
+// TagPut is the body of PUT /api/v1/registry/tags/{repository}/{tag}
+type TagPut struct {
+	Digest string `json:"digest"`
+}
+
+// TagList is the body of GET /api/v1/registry/tags
+type TagList struct {
+	Items    []Tag  `json:"items"`
+	Revision uint64 `json:"revision"`
+}
+
+// PinnedArtifact is an artifact a release or run uses, by the role it
+// plays, e.g. "dag" or "contract:ledger". Source is the reference it was
+// resolved from.
+type PinnedArtifact struct {
+	Role   string `json:"role"`
+	Digest string `json:"digest"`
+	Kind   string `json:"kind"`
+	Source string `json:"source,omitempty"`
+}
+
+// Release is a frozen set of artifacts, written by runi freeze. Releases
+// are immutable: they can be neither changed nor deleted, and keep their
+// artifacts from garbage collection.
+type Release struct {
+	Name      string           `json:"name"`
+	Version   string           `json:"version"`
+	Artifacts []PinnedArtifact `json:"artifacts"`
+	CreatedAt time.Time        `json:"createdAt"`
+	Revision  uint64           `json:"revision,omitempty"`
+}
+
+// ReleaseCreate is the body of POST /api/v1/registry/releases. Artifacts
+// maps roles to artifact references, resolved when the release is frozen.
+type ReleaseCreate struct {
+	Name      string            `json:"name"`
+	Version   string            `json:"version"`
+	Artifacts map[string]string `json:"artifacts"`
+}
+
+// ReleaseList is the body of GET /api/v1/registry/releases
+type ReleaseList struct {
+	Items    []Release `json:"items"`
+	Revision uint64    `json:"revision"`
+}
+
+// RunRef records the exact artifacts a run used, so that it can be rerun
+// with them after their tags moved on. Run references cannot be changed,
+// only deleted.
+type RunRef struct {
+	Run       string           `json:"run"`
+	Herd      string           `json:"herd,omitempty"`
+	Release   string           `json:"release,omitempty"`
+	Artifacts []PinnedArtifact `json:"artifacts"`
+	CreatedAt time.Time        `json:"createdAt"`
+	Revision  uint64           `json:"revision,omitempty"`
+}
+
+// RunRefCreate is the body of PUT /api/v1/registry/runs/{run}. The run
+// uses the artifacts of Release, NAME@VERSION, and those in Artifacts,
+// which override the release's roles.
+type RunRefCreate struct {
+	Herd      string            `json:"herd,omitempty"`
+	Release   string            `json:"release,omitempty"`
+	Artifacts map[string]string `json:"artifacts,omitempty"`
+}
+
+// GCResult is the body of POST /api/v1/registry/gc
+type GCResult struct {
+	Artifacts []string `json:"artifacts"`
+	Chunks    int      `json:"chunks"`
+	Bytes     int64    `json:"bytes"`
+	DryRun    bool     `json:"dryRun,omitempty"`
+	Revision  uint64   `json:"revision"`
+}
+
+// ArtifactRef refers to an artifact by digest, by repository and tag, or
+// by both, which requires the tag to point to the digest
+type ArtifactRef struct {
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseArtifactRef parses sha256:<hex>, REPOSITORY[:TAG] or
+// REPOSITORY@sha256:<hex>. The tag defaults to latest.
+func ParseArtifactRef(s string) (ArtifactRef, error) {
+	if strings.HasPrefix(s, "sha256:") {
+		return ArtifactRef{Digest: s}, validateDigest("reference", s)
+	}
+	ref := ArtifactRef{}
+	if repository, digest, ok := strings.Cut(s, "@"); ok {
+		ref.Repository, ref.Digest = repository, digest
+		if err := validateDigest("reference", digest); err != nil {
+			return ref, err
+		}
+	} else if repository, tag, ok := strings.Cut(s, ":"); ok {
+		ref.Repository, ref.Tag = repository, tag
+	} else {
+		ref.Repository, ref.Tag = s, defaultTag
+	}
+	if err := validateName("reference", ref.Repository); err != nil {
+		return ref, err
+	}
+	if ref.Digest == "" && !refNameRE.MatchString(ref.Tag) {
+		return ref, &ValidationError{Field: "reference", Message: fmt.Sprintf("invalid tag %q", ref.Tag)}
+	}
+	return ref, nil
+}
+
+func (r ArtifactRef) String() string {
+	switch {
+	case r.Repository == "":
+		return r.Digest
+	case r.Digest != "":
+		return r.Repository + "@" + r.Digest
+	}
+	return r.Repository + ":" + r.Tag
+}
+
+// validateDigest checks a "sha256:<hex>" digest
+func validateDigest(field, digest string) error {
+	hexDigits, ok := strings.CutPrefix(digest, "sha256:")
+	if _, err := hex.DecodeString(hexDigits); !ok || err != nil || len(hexDigits) != 2*sha256.Size || strings.ToLower(hexDigits) != hexDigits {
+		return &ValidationError{Field: field, Message: fmt.Sprintf("%q is not a sha256:<hex> digest", digest)}
+	}
+	return nil
+}
+
+func validateArtifactKind(kind string) error {
+	switch kind {
+	case ArtifactDAG, ArtifactContract, ArtifactDataset, ArtifactSBOM:
+		return nil
+	}
+	return &ValidationError{Field: "kind", Message: fmt.Sprintf("%q is not dag, contract, dataset or sbom", kind)}
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// readArtifact returns the local manifest of an artifact
+func (s *Server) readArtifact(digest string) (*Artifact, error) {
+	kv, ok := s.store.Lookup(artifactKey(digest))
+	if !ok {
+		return nil, fmt.Errorf("artifact %s: %w", digest, ErrNotFound)
+	}
+	artifact := &Artifact{}
+	return artifact, decodeResource(kv, artifact, &artifact.Revision)
+}
+
+func (s *Server) readTag(repository, tag string) (*Tag, error) {
+	kv, ok := s.store.Lookup(tagKey(repository, tag))
+	if !ok {
+		return nil, fmt.Errorf("tag %s:%s: %w", repository, tag, ErrNotFound)
+	}
+	t := &Tag{}
+	return t, decodeResource(kv, t, &t.Revision)
+}
+
+func (s *Server) readRelease(name, version string) (*Release, error) {
+	kv, ok := s.store.Lookup(releaseKey(name, version))
+	if !ok {
+		return nil, fmt.Errorf("release %s@%s: %w", name, version, ErrNotFound)
+	}
+	release := &Release{}
+	return release, decodeResource(kv, release, &release.Revision)
+}
+
+func (s *Server) readRunRef(run string) (*RunRef, error) {
+	kv, ok := s.store.Lookup(runRefKey(run))
+	if !ok {
+		return nil, fmt.Errorf("run %s: %w", run, ErrNotFound)
+	}
+	ref := &RunRef{}
+	return ref, decodeResource(kv, ref, &ref.Revision)
+}
+
+// resolve pins the artifacts of refs by role. It returns the conditions
+// that keep the resolution valid: the artifacts exist and the tags still
+// point to them.
+func (s *Server) resolve(refs map[string]string) ([]PinnedArtifact, []Condition, error) {
+	roles := make([]string, 0, len(refs))
+	for role := range refs {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	var pinned []PinnedArtifact
+	var conditions []Condition
+	for _, role := range roles {
+		if role == "" || strings.ContainsAny(role, " \t\n") {
+			return nil, nil, &ValidationError{Field: "artifacts", Message: fmt.Sprintf("invalid role %q", role)}
+		}
+		ref, err := ParseArtifactRef(refs[role])
+		if err != nil {
+			return nil, nil, &ValidationError{Field: "artifacts", Message: fmt.Sprintf("%s: %v", role, err)}
+		}
+		digest := ref.Digest
+		if ref.Tag != "" {
+			tag, err := s.readTag(ref.Repository, ref.Tag)
+			if err != nil {
+				return nil, nil, err
+			}
+			digest = tag.Digest
+			conditions = append(conditions, IfRevision(tagKey(ref.Repository, ref.Tag), tag.Revision))
+		} else if ref.Repository != "" {
+			if err := s.checkRepository(ref.Repository, digest); err != nil {
+				return nil, nil, err
+			}
+		}
+		artifact, err := s.readArtifact(digest)
+		if err != nil {
+			return nil, nil, err
+		}
+		conditions = append(conditions, IfExists(artifactKey(digest)))
+		pinned = append(pinned, PinnedArtifact{Role: role, Digest: digest, Kind: artifact.Kind, Source: ref.String()})
+	}
+	return pinned, conditions, nil
+}
+
+// checkRepository requires a tag of repository to point to digest
+func (s *Server) checkRepository(repository, digest string) error {
+	for _, kv := range s.store.Range(tagPrefix(repository)) {
+		tag := Tag{}
+		if err := decodeResource(kv, &tag, &tag.Revision); err != nil {
+			return err
+		}
+		if tag.Digest == digest {
+			return nil
+		}
+	}
+	return fmt.Errorf("artifact %s@%s: %w", repository, digest, ErrNotFound)
+}
+
+// collectGarbage deletes the artifacts that no tag, release or run refers
+// to and that are older than grace, and the chunks no remaining artifact
+// uses. The deletion fails with a conflict if the registry changed since
+// it was read.
+func (s *Server) collectGarbage(ctx context.Context, grace time.Duration, dryRun bool, now time.Time) (*GCResult, error) {
+	ranges, revision := s.store.Ranges(artifactKeyPrefix, chunkKeyPrefix, tagKeyPrefix, releaseKeyPrefix, runRefKeyPrefix, registryGenerationKey)
+	artifacts, chunks, tags, releases, runs, generation := ranges[0], ranges[1], ranges[2], ranges[3], ranges[4], ranges[5]
+
+	referenced := make(map[string]bool)
+	for _, kv := range tags {
+		tag := Tag{}
+		if err := decodeResource(kv, &tag, &tag.Revision); err != nil {
+			return nil, err
+		}
+		referenced[tag.Digest] = true
+	}
+	for _, group := range [][]KeyValue{releases, runs} {
+		for _, kv := range group {
+			var pins struct {
+				Artifacts []PinnedArtifact `json:"artifacts"`
+			}
+			if err := json.Unmarshal(kv.Value, &pins); err != nil {
+				return nil, fmt.Errorf("corrupt registry record %s: %w", kv.Key, err)
+			}
+			for _, pin := range pins.Artifacts {
+				referenced[pin.Digest] = true
+			}
+		}
+	}
+
+	cutoff := now.Add(-grace)
+	manifests := make([]Artifact, len(artifacts))
+	kept := make(map[string]bool)
+	for i, kv := range artifacts {
+		if err := decodeResource(kv, &manifests[i], &manifests[i].Revision); err != nil {
+			return nil, err
+		}
+		if referenced[manifests[i].Digest] || manifests[i].CreatedAt.After(cutoff) {
+			kept[manifests[i].Digest] = true
+		}
+	}
+	// Artifacts are kept with their subjects, which may be referrers too
+	for changed := true; changed; {
+		changed = false
+		for _, artifact := range manifests {
+			if !kept[artifact.Digest] && artifact.Subject != "" && kept[artifact.Subject] {
+				kept[artifact.Digest], changed = true, true
+			}
+		}
+	}
+
+	result := &GCResult{Artifacts: []string{}, DryRun: dryRun, Revision: revision}
+	live := make(map[string]bool)
+	var ops []Op
+	for _, artifact := range manifests {
+		if kept[artifact.Digest] {
+			for _, chunk := range artifact.Chunks {
+				live[chunk] = true
+			}
+			continue
+		}
+		result.Artifacts = append(result.Artifacts, artifact.Digest)
+		ops = append(ops, OpDelete(artifactKey(artifact.Digest)))
+	}
+	for _, kv := range chunks {
+		digest := strings.TrimPrefix(kv.Key, chunkKeyPrefix)
+		if live[digest] {
+			continue
+		}
+		chunk := storedChunk{}
+		if err := json.Unmarshal(kv.Value, &chunk); err != nil {
+			return nil, fmt.Errorf("corrupt chunk %s: %w", digest, err)
+		}
+		if chunk.CreatedAt.After(cutoff) {
+			continue
+		}
+		result.Chunks++
+		result.Bytes += int64(len(chunk.Data))
+		ops = append(ops, OpDelete(kv.Key))
+	}
+	if dryRun || len(ops) == 0 {
+		return result, nil
+	}
+
+	condition := IfRevision(registryGenerationKey, 0)
+	if len(generation) > 0 {
+		condition = IfRevision(registryGenerationKey, generation[0].ModRevision)
+	}
+	var err error
+	result.Revision, err = s.store.Txn(ctx, []Condition{condition}, ops...)
+	return result, err
+}
+
+// RunRegistryGC collects the registry's garbage each interval, while the
+// node is the leader, until ctx is done
+func (s *Server) RunRegistryGC(ctx context.Context, interval, grace time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !s.store.Raft().IsLeader() {
+			continue
+		}
+		audit := AuditEvent{Actor: "barn", Action: "registry.gc", Resource: APIPrefix + "/registry"}
+		result, err := s.collectGarbage(WithAuditEvent(ctx, audit), grace, false, time.Now())
+		if err != nil {
+			// A conflict means the registry is in use; the next run retries
+			if !errors.Is(err, ErrConflict) {
+				fmt.Fprintf(os.Stderr, "Warning: registry garbage collection failed: %v\n", err)
+			}
+			continue
+		}
+		if len(result.Artifacts) > 0 || result.Chunks > 0 {
+			fmt.Fprintf(os.Stderr, "Collected %d artifacts and %d chunks (%d bytes) from the registry\n", len(result.Artifacts), result.Chunks, result.Bytes)
+		}
+	}
+}
+
+// registryWrite returns ops followed by the write of the registry
+// generation
+func registryWrite(ops ...Op) []Op {
+	return append(ops, OpPut(registryGenerationKey, nil))
+}
+
+func (s *Server) getChunk(w http.ResponseWriter, r *http.Request, params Params) {
+	if !s.prepareRead(w, r) {
+		return
+	}
+	value, ok := s.store.Get(chunkKey(params["digest"]))
+	if !ok {
+		s.fail(w, r, fmt.Errorf("chunk %s: %w", params["digest"], ErrNotFound))
+		return
+	}
+	chunk := storedChunk{}
+	if err := json.Unmarshal(value, &chunk); err != nil {
+		s.fail(w, r, fmt.Errorf("corrupt chunk %s: %w", params["digest"], err))
+		return
+	}
+	writeJSON(w, http.StatusOK, ArtifactChunk{Data: chunk.Data})
+}
+
+// putChunk stores a chunk under its digest. Putting an existing chunk
+// renews its grace period.
+func (s *Server) putChunk(w http.ResponseWriter, r *http.Request, params Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	digest := params["digest"]
+	if err := validateDigest("digest", digest); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	body := ArtifactChunk{}
+	if err := decodeBody(r, &body); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if len(body.Data) > artifactChunkSize {
+		s.fail(w, r, &ValidationError{Field: "data", Message: fmt.Sprintf("chunks are limited to %d bytes", artifactChunkSize)})
+		return
+	}
+	if digestOf(body.Data) != digest {
+		s.fail(w, r, &ValidationError{Field: "data", Message: "does not match the digest"})
+		return
+	}
+	data, err := json.Marshal(storedChunk{Data: body.Data, CreatedAt: time.Now().UTC()})
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if _, err := s.store.Txn(r.Context(), nil, registryWrite(OpPut(chunkKey(digest), data))...); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getArtifact(w http.ResponseWriter, r *http.Request, params Params) {
+	if !s.prepareRead(w, r) {
+		return
+	}
+	artifact, err := s.readArtifact(params["digest"])
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, artifact)
+}
+
+// putArtifact stores the manifest of an artifact whose chunks were
+// uploaded, after checking that they add up to its digest. Putting an
+// existing artifact returns it unchanged.
+func (s *Server) putArtifact(w http.ResponseWriter, r *http.Request, params Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	artifact := &Artifact{}
+	if err := decodeBody(r, artifact); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if err := matchPathName(&artifact.Digest, params["digest"]); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if err := validateArtifact(artifact); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if err := s.store.Sync(r.Context()); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if existing, err := s.readArtifact(artifact.Digest); err == nil {
+		if existing.Kind != artifact.Kind {
+			s.fail(w, r, &ConflictError{Key: artifactKey(artifact.Digest), Revision: existing.Revision, Reason: "exists as a " + existing.Kind})
+			return
+		}
+		writeJSON(w, http.StatusOK, existing)
+		return
+	}
+
+	// The chunks must add up to the artifact, and stay until it is stored,
+	// as must its subject
+	hash := sha256.New()
+	var size int64
+	conditions := []Condition{IfRevision(artifactKey(artifact.Digest), 0)}
+	if artifact.Subject != "" {
+		if _, err := s.readArtifact(artifact.Subject); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		conditions = append(conditions, IfExists(artifactKey(artifact.Subject)))
+	}
+	for _, digest := range artifact.Chunks {
+		value, ok := s.store.Get(chunkKey(digest))
+		if !ok {
+			s.fail(w, r, &ValidationError{Field: "chunks", Message: fmt.Sprintf("chunk %s was not uploaded", digest)})
+			return
+		}
+		chunk := storedChunk{}
+		if err := json.Unmarshal(value, &chunk); err != nil {
+			s.fail(w, r, fmt.Errorf("corrupt chunk %s: %w", digest, err))
+			return
+		}
+		hash.Write(chunk.Data)
+		size += int64(len(chunk.Data))
+		conditions = append(conditions, IfExists(chunkKey(digest)))
+	}
+	if "sha256:"+hex.EncodeToString(hash.Sum(nil)) != artifact.Digest || size != artifact.Size {
+		s.fail(w, r, &ValidationError{Field: "chunks", Message: "do not add up to the digest and size"})
+		return
+	}
+
+	artifact.CreatedAt = time.Now().UTC()
+	data, err := json.Marshal(artifact)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if artifact.Revision, err = s.store.Txn(r.Context(), conditions, registryWrite(OpPut(artifactKey(artifact.Digest), data))...); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	w.Header().Set("Location", APIPrefix+"/registry/artifacts/"+artifact.Digest)
+	writeJSON(w, http.StatusCreated, artifact)
+}
+
+// listReferrers returns the artifacts whose subject is an artifact,
+// optionally only those of ?kind=
+func (s *Server) listReferrers(w http.ResponseWriter, r *http.Request, params Params) {
+	if !s.prepareRead(w, r) {
+		return
+	}
+	kind := r.URL.Query().Get("kind")
+	ranges, revision := s.store.Ranges(artifactKeyPrefix)
+	list := ArtifactList{Items: []Artifact{}, Revision: revision}
+	for _, kv := range ranges[0] {
+		artifact := Artifact{}
+		if err := decodeResource(kv, &artifact, &artifact.Revision); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		if artifact.Subject == params["digest"] && (kind == "" || artifact.Kind == kind) && s.artifactVisible(r, &artifact) {
+			list.Items = append(list.Items, artifact)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func validateArtifact(artifact *Artifact) error {
+	if err := validateDigest("digest", artifact.Digest); err != nil {
+		return err
+	}
+	if err := validateArtifactKind(artifact.Kind); err != nil {
+		return err
+	}
+	if artifact.Subject != "" {
+		if err := validateDigest("subject", artifact.Subject); err != nil {
+			return err
+		}
+	}
+	if artifact.Size < 0 || artifact.Size > maxArtifactSize {
+		return &ValidationError{Field: "size", Message: fmt.Sprintf("must be at most %d bytes", maxArtifactSize)}
+	}
+	for _, chunk := range artifact.Chunks {
+		if err := validateDigest("chunks", chunk); err != nil {
+			return err
+		}
+	}
+	if herd, ok := artifact.Annotations[HerdAnnotation]; ok {
+		if err := validateName("annotations", herd); err != nil {
+			return err
+		}
+	}
+	return validateLabels(artifact.Annotations)
+}
+
+func (s *Server) listTags(w http.ResponseWriter, r *http.Request, _ Params) {
+	if !s.prepareRead(w, r) {
+		return
+	}
+	prefix := tagKeyPrefix
+	if repository := r.URL.Query().Get("repository"); repository != "" {
+		prefix = tagPrefix(repository)
+	}
+	ranges, revision := s.store.Ranges(prefix)
+	list := TagList{Items: []Tag{}, Revision: revision}
+	for _, kv := range ranges[0] {
+		tag := Tag{}
+		if err := decodeResource(kv, &tag, &tag.Revision); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		list.Items = append(list.Items, tag)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) getTag(w http.ResponseWriter, r *http.Request, params Params) {
+	if !s.prepareRead(w, r) {
+		return
+	}
+	tag, err := s.readTag(params["repository"], params["tag"])
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tag)
+}
+
+// putTag points a tag to an artifact. With ?revision=, the tag must not
+// have moved since.
+func (s *Server) putTag(w http.ResponseWriter, r *http.Request, params Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	body := TagPut{}
+	if err := decodeBody(r, &body); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	revision, err := queryRevision(r)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	tag := &Tag{Repository: params["repository"], Tag: params["tag"], Digest: body.Digest}
+	if err := validateName("repository", tag.Repository); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if !refNameRE.MatchString(tag.Tag) {
+		s.fail(w, r, &ValidationError{Field: "tag", Message: fmt.Sprintf("invalid tag %q", tag.Tag)})
+		return
+	}
+	if err := validateDigest("digest", tag.Digest); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if err := s.store.Sync(r.Context()); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	artifact, err := s.readArtifact(tag.Digest)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	tag.Kind, tag.UpdatedAt = artifact.Kind, time.Now().UTC()
+
+	conditions := []Condition{IfExists(artifactKey(tag.Digest))}
+	if revision != 0 {
+		conditions = append(conditions, IfRevision(tagKey(tag.Repository, tag.Tag), revision))
+	}
+	data, err := json.Marshal(tag)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if tag.Revision, err = s.store.Txn(r.Context(), conditions, registryWrite(OpPut(tagKey(tag.Repository, tag.Tag), data))...); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tag)
+}
+
+func (s *Server) deleteTag(w http.ResponseWriter, r *http.Request, params Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	revision, err := queryRevision(r)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if _, err := s.readTag(params["repository"], params["tag"]); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	key := tagKey(params["repository"], params["tag"])
+	if _, err := s.store.Txn(r.Context(), []Condition{revisionCondition(key, revision)}, registryWrite(OpDelete(key))...); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listReleases(w http.ResponseWriter, r *http.Request, _ Params) {
+	if !s.prepareRead(w, r) {
+		return
+	}
+	ranges, revision := s.store.Ranges(releaseKeyPrefix)
+	list := ReleaseList{Items: []Release{}, Revision: revision}
+	for _, kv := range ranges[0] {
+		release := Release{}
+		if err := decodeResource(kv, &release, &release.Revision); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		list.Items = append(list.Items, release)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) getRelease(w http.ResponseWriter, r *http.Request, params Params) {
+	if !s.prepareRead(w, r) {
+		return
+	}
+	release, err := s.readRelease(params["name"], params["version"])
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, release)
+}
+
+// createRelease freezes a release: its references are resolved to
+// digests once, and the release never changes after
+func (s *Server) createRelease(w http.ResponseWriter, r *http.Request, _ Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	body := ReleaseCreate{}
+	if err := decodeBody(r, &body); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if err := validateName("name", body.Name); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if !refNameRE.MatchString(body.Version) {
+		s.fail(w, r, &ValidationError{Field: "version", Message: fmt.Sprintf("invalid version %q", body.Version)})
+		return
+	}
+	if len(body.Artifacts) == 0 {
+		s.fail(w, r, &ValidationError{Field: "artifacts", Message: "is required"})
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		release, err := s.freeze(r.Context(), &body)
+		if err == nil {
+			w.Header().Set("Location", APIPrefix+"/registry/releases/"+release.Name+"/"+release.Version)
+			writeJSON(w, http.StatusCreated, release)
+			return
+		}
+		// A tag moved between resolving and freezing
+		var conflict *ConflictError
+		if errors.As(err, &conflict) && conflict.Key != releaseKey(body.Name, body.Version) && attempt < maxUpdateAttempts {
+			continue
+		}
+		s.fail(w, r, err)
+		return
+	}
+}
+
+func (s *Server) freeze(ctx context.Context, body *ReleaseCreate) (*Release, error) {
+	if err := s.store.Sync(ctx); err != nil {
+		return nil, err
+	}
+	pinned, conditions, err := s.resolve(body.Artifacts)
+	if err != nil {
+		return nil, err
+	}
+	release := &Release{Name: body.Name, Version: body.Version, Artifacts: pinned, CreatedAt: time.Now().UTC()}
+	data, err := json.Marshal(release)
+	if err != nil {
+		return nil, err
+	}
+	key := releaseKey(release.Name, release.Version)
+	conditions = append([]Condition{IfRevision(key, 0)}, conditions...)
+	release.Revision, err = s.store.Txn(ctx, conditions, registryWrite(OpPut(key, data))...)
+	return release, err
+}
+
+func (s *Server) getRunRef(w http.ResponseWriter, r *http.Request, params Params) {
+	if !s.prepareRead(w, r) {
+		return
+	}
+	ref, err := s.readRunRef(params["run"])
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ref)
+}
+
+// putRunRef records the artifacts of a run. A run's references are
+// recorded once.
+func (s *Server) putRunRef(w http.ResponseWriter, r *http.Request, params Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	body := RunRefCreate{}
+	if err := decodeBody(r, &body); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	run := params["run"]
+	if !refNameRE.MatchString(run) {
+		s.fail(w, r, &ValidationError{Field: "run", Message: fmt.Sprintf("invalid run ID %q", run)})
+		return
+	}
+	if body.Herd != "" {
+		if err := validateName("herd", body.Herd); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+	}
+	if body.Release == "" && len(body.Artifacts) == 0 {
+		s.fail(w, r, &ValidationError{Field: "artifacts", Message: "a release or artifacts are required"})
+		return
+	}
+	if err := s.store.Sync(r.Context()); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		ref, err := s.recordRun(r.Context(), run, &body)
+		if err == nil {
+			writeJSON(w, http.StatusCreated, ref)
+			return
+		}
+		var conflict *ConflictError
+		if errors.As(err, &conflict) && conflict.Key != runRefKey(run) && attempt < maxUpdateAttempts {
+			if err = s.store.Sync(r.Context()); err == nil {
+				continue
+			}
+		}
+		s.fail(w, r, err)
+		return
+	}
+}
+
+func (s *Server) recordRun(ctx context.Context, run string, body *RunRefCreate) (*RunRef, error) {
+	ref := &RunRef{Run: run, Herd: body.Herd, Release: body.Release, CreatedAt: time.Now().UTC()}
+	var conditions []Condition
+	if body.Release != "" {
+		name, version, ok := strings.Cut(body.Release, "@")
+		if !ok {
+			return nil, &ValidationError{Field: "release", Message: fmt.Sprintf("%q is not NAME@VERSION", body.Release)}
+		}
+		release, err := s.readRelease(name, version)
+		if err != nil {
+			return nil, err
+		}
+		for _, pin := range release.Artifacts {
+			if _, override := body.Artifacts[pin.Role]; !override {
+				ref.Artifacts = append(ref.Artifacts, pin)
+			}
+		}
+	}
+	pinned, resolved, err := s.resolve(body.Artifacts)
+	if err != nil {
+		return nil, err
+	}
+	ref.Artifacts = append(ref.Artifacts, pinned...)
+	conditions = append(conditions, resolved...)
+	sort.Slice(ref.Artifacts, func(i, j int) bool { return ref.Artifacts[i].Role < ref.Artifacts[j].Role })
+
+	data, err := json.Marshal(ref)
+	if err != nil {
+		return nil, err
+	}
+	key := runRefKey(run)
+	conditions = append([]Condition{IfRevision(key, 0)}, conditions...)
+	ref.Revision, err = s.store.Txn(ctx, conditions, registryWrite(OpPut(key, data))...)
+	return ref, err
+}
+
+func (s *Server) deleteRunRef(w http.ResponseWriter, r *http.Request, params Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	ref, err := s.readRunRef(params["run"])
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	key := runRefKey(ref.Run)
+	if _, err := s.store.Txn(r.Context(), []Condition{IfRevision(key, ref.Revision)}, registryWrite(OpDelete(key))...); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// registryGC collects garbage on request. ?dryRun=true only reports what
+// would be deleted, and ?grace= overrides the grace period.
+func (s *Server) registryGC(w http.ResponseWriter, r *http.Request, _ Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+	grace := DefaultRegistryGCGrace
+	if value := r.URL.Query().Get("grace"); value != "" {
+		var err error
+		if grace, err = time.ParseDuration(value); err != nil || grace < 0 {
+			s.fail(w, r, &ValidationError{Field: "grace", Message: fmt.Sprintf("%q is not a duration", value)})
+			return
+		}
+	}
+	if err := s.store.Sync(r.Context()); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	result, err := s.collectGarbage(r.Context(), grace, dryRun, time.Now())
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// PushArtifact uploads data in chunks and stores it as an artifact of
+// kind. Pushing an existing artifact returns it.
+func (c *Client) PushArtifact(ctx context.Context, kind, mediaType string, data []byte, annotations map[string]string) (*Artifact, error) {
+	return c.push(ctx, &Artifact{Kind: kind, MediaType: mediaType, Annotations: annotations}, data)
+}
+
+// AttachArtifact pushes an artifact describing the artifact subject, like
+// its SBOM
+func (c *Client) AttachArtifact(ctx context.Context, subject, kind, mediaType string, data []byte, annotations map[string]string) (*Artifact, error) {
+	return c.push(ctx, &Artifact{Kind: kind, Subject: subject, MediaType: mediaType, Annotations: annotations}, data)
+}
+
+// Referrers returns the artifacts attached to an artifact, optionally only
+// those of kind
+func (c *Client) Referrers(ctx context.Context, digest, kind string) (*ArtifactList, error) {
+	var query url.Values
+	if kind != "" {
+		query = url.Values{"kind": {kind}}
+	}
+	list := &ArtifactList{}
+	return list, c.do(ctx, http.MethodGet, "/registry/artifacts/"+url.PathEscape(digest)+"/referrers", query, nil, list)
+}
+
+func (c *Client) push(ctx context.Context, artifact *Artifact, data []byte) (*Artifact, error) {
+	if len(data) > maxArtifactSize {
+		return nil, fmt.Errorf("artifact of %d bytes exceeds the limit of %d", len(data), maxArtifactSize)
+	}
+	artifact.Digest, artifact.Size, artifact.Chunks = digestOf(data), int64(len(data)), []string{}
+	for offset := 0; offset < len(data); offset += artifactChunkSize {
+		chunk := data[offset:min(offset+artifactChunkSize, len(data))]
+		digest := digestOf(chunk)
+		if err := c.do(ctx, http.MethodPut, "/registry/chunks/"+digest, nil, ArtifactChunk{Data: chunk}, nil); err != nil {
+			return nil, fmt.Errorf("failed to upload chunk %s: %w", digest, err)
+		}
+		artifact.Chunks = append(artifact.Chunks, digest)
+	}
+	stored := &Artifact{}
+	return stored, c.do(ctx, http.MethodPut, "/registry/artifacts/"+artifact.Digest, nil, artifact, stored)
+}
+
+// GetArtifact returns the manifest of an artifact
+func (c *Client) GetArtifact(ctx context.Context, digest string) (*Artifact, error) {
+	artifact := &Artifact{}
+	return artifact, c.do(ctx, http.MethodGet, "/registry/artifacts/"+url.PathEscape(digest), nil, nil, artifact)
+}
+
+// PullArtifact returns the manifest and content of an artifact, after
+// checking the content against its digest
+func (c *Client) PullArtifact(ctx context.Context, digest string) (*Artifact, []byte, error) {
+	artifact, err := c.GetArtifact(ctx, digest)
+	if err != nil {
+		return nil, nil, err
+	}
+	data := make([]byte, 0, artifact.Size)
+	for _, chunkDigest := range artifact.Chunks {
+		chunk := ArtifactChunk{}
+		if err := c.do(ctx, http.MethodGet, "/registry/chunks/"+url.PathEscape(chunkDigest), nil, nil, &chunk); err != nil {
+			return nil, nil, fmt.Errorf("failed to download chunk %s: %w", chunkDigest, err)
+		}
+		data = append(data, chunk.Data...)
+	}
+	if digestOf(data) != artifact.Digest {
+		return nil, nil, fmt.Errorf("artifact %s does not match its digest: %w", digest, ErrCorrupt)
+	}
+	return artifact, data, nil
+}
+
+// Resolve returns the digest a reference points to
+func (c *Client) Resolve(ctx context.Context, ref ArtifactRef) (string, error) {
+	if ref.Tag == "" {
+		return ref.Digest, nil
+	}
+	tag, err := c.GetTag(ctx, ref.Repository, ref.Tag)
+	if err != nil {
+		return "", err
+	}
+	return tag.Digest, nil
+}
+
+// ListTags returns the tags of a repository, or of every repository
+func (c *Client) ListTags(ctx context.Context, repository string) (*TagList, error) {
+	var query url.Values
+	if repository != "" {
+		query = url.Values{"repository": {repository}}
+	}
+	list := &TagList{}
+	return list, c.do(ctx, http.MethodGet, "/registry/tags", query, nil, list)
+}
+
+// GetTag returns a tag
+func (c *Client) GetTag(ctx context.Context, repository, tag string) (*Tag, error) {
+	t := &Tag{}
+	return t, c.do(ctx, http.MethodGet, tagPath(repository, tag), nil, nil, t)
+}
+
+// SetTag points a tag to an artifact. A revision other than 0 requires
+// the tag not to have moved since.
+func (c *Client) SetTag(ctx context.Context, repository, tag, digest string, revision uint64) (*Tag, error) {
+	t := &Tag{}
+	return t, c.do(ctx, http.MethodPut, tagPath(repository, tag), revisionQuery(revision), TagPut{Digest: digest}, t)
+}
+
+// DeleteTag removes a tag. Its artifact is collected unless another tag,
+// release or run refers to it.
+func (c *Client) DeleteTag(ctx context.Context, repository, tag string, revision uint64) error {
+	return c.do(ctx, http.MethodDelete, tagPath(repository, tag), revisionQuery(revision), nil, nil)
+}
+
+// CreateRelease freezes a release of the artifacts refs maps roles to
+func (c *Client) CreateRelease(ctx context.Context, name, version string, refs map[string]string) (*Release, error) {
+	release := &Release{}
+	return release, c.do(ctx, http.MethodPost, "/registry/releases", nil, ReleaseCreate{Name: name, Version: version, Artifacts: refs}, release)
+}
+
+// GetRelease returns a frozen release
+func (c *Client) GetRelease(ctx context.Context, name, version string) (*Release, error) {
+	release := &Release{}
+	return release, c.do(ctx, http.MethodGet, "/registry/releases/"+url.PathEscape(name)+"/"+url.PathEscape(version), nil, nil, release)
+}
+
+// ListReleases returns the frozen releases
+func (c *Client) ListReleases(ctx context.Context) (*ReleaseList, error) {
+	list := &ReleaseList{}
+	return list, c.do(ctx, http.MethodGet, "/registry/releases", nil, nil, list)
+}
+
+// RecordRun records the artifacts a run uses
+func (c *Client) RecordRun(ctx context.Context, run string, create RunRefCreate) (*RunRef, error) {
+	ref := &RunRef{}
+	return ref, c.do(ctx, http.MethodPut, "/registry/runs/"+url.PathEscape(run), nil, create, ref)
+}
+
+// GetRun returns the artifacts a run used
+func (c *Client) GetRun(ctx context.Context, run string) (*RunRef, error) {
+	ref := &RunRef{}
+	return ref, c.do(ctx, http.MethodGet, "/registry/runs/"+url.PathEscape(run), nil, nil, ref)
+}
+
+// DeleteRun deletes the references of a run, releasing its artifacts
+func (c *Client) DeleteRun(ctx context.Context, run string) error {
+	return c.do(ctx, http.MethodDelete, "/registry/runs/"+url.PathEscape(run), nil, nil, nil)
+}
+
+// CollectGarbage deletes the unreferenced artifacts older than grace, or
+// only reports them with dryRun. A grace of 0 uses the Barn's default.
+func (c *Client) CollectGarbage(ctx context.Context, grace time.Duration, dryRun bool) (*GCResult, error) {
+	query := url.Values{}
+	if grace > 0 {
+		query.Set("grace", grace.String())
+	}
+	if dryRun {
+		query.Set("dryRun", "true")
+	}
+	result := &GCResult{}
+	return result, c.do(ctx, http.MethodPost, "/registry/gc", query, nil, result)
+}
+
+func tagPath(repository, tag string) string {
+	return "/registry/tags/" + url.PathEscape(repository) + "/" + url.PathEscape(tag)
+}
+
+// parseRoleRefs parses ROLE=REF flags
+func parseRoleRefs(values []string) (map[string]string, error) {
+	refs := make(map[string]string, len(values))
+	for _, value := range values {
+		role, ref, ok := strings.Cut(value, "=")
+		if !ok || role == "" {
+			return nil, fmt.Errorf("invalid --artifact %q: expected ROLE=REF", value)
+		}
+		if _, err := ParseArtifactRef(ref); err != nil {
+			return nil, fmt.Errorf("invalid --artifact %q: %w", value, err)
+		}
+		refs[role] = ref
+	}
+	return refs, nil
+}
+
+func printPinned(cmd *cobra.Command, artifacts []PinnedArtifact) error {
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ROLE\tKIND\tDIGEST\tSOURCE")
+	for _, pin := range artifacts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", pin.Role, pin.Kind, pin.Digest, orDash(pin.Source))
+	}
+	return w.Flush()
+}
+
+// NewFreezeCommand returns runi freeze, which freezes the current
+// artifacts of a pipeline into an immutable release
+func NewFreezeCommand() *cobra.Command {
+	client := &ClientOptions{}
+	var artifacts []string
+
+	cmd := &cobra.Command{
+		Use:   "freeze NAME VERSION --artifact ROLE=REF...",
+		Short: "Freeze artifacts into an immutable release",
+		Long: `Resolve each artifact reference to its digest and record them as release
+NAME@VERSION in the Barn registry. A release never changes and keeps its
+artifacts from garbage collection, so runs of it are reproducible after
+the tags moved on.`,
+		Example: `  runi freeze ledger v1.4.0 --artifact dag=ledger-dag:latest \
+    --artifact contract:ledger=ledger-contract:v3 --artifact golden=ledger-golden@sha256:9f86d0...`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			refs, err := parseRoleRefs(artifacts)
+			if err != nil {
+				return err
+			}
+			if len(refs) == 0 {
+				return errors.New("at least one --artifact is required")
+			}
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			release, err := api.CreateRelease(ctx, args[0], args[1], refs)
+			if err != nil {
+				return fmt.Errorf("failed to freeze release %s@%s: %w", args[0], args[1], err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Froze release %s@%s\n", release.Name, release.Version)
+			return printPinned(cmd, release.Artifacts)
+		},
+	}
+	client.addFlags(cmd)
+	cmd.Flags().StringArrayVar(&artifacts, "artifact", nil, "Artifact of the release as ROLE=REF (repeatable)")
+	return cmd
+}
+
+func newRegistryCommand(client *ClientOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "registry",
+		Short: "Manage the artifact registry of compiled DAGs, contracts and datasets",
+		Long: `The registry stores compiled DAG bundles, contracts and golden datasets by
+the digest of their content. Tags name artifacts within a repository and
+can move; releases frozen with runi freeze and the references of runs
+cannot. Artifacts that nothing refers to are garbage collected, except
+those attached to a kept artifact, like the SBOMs of runi sbom export.
+
+References are sha256:<hex>, REPOSITORY[:TAG] or REPOSITORY@sha256:<hex>.`,
+	}
+	cmd.AddCommand(
+		newRegistryPushCommand(client),
+		newRegistryPullCommand(client),
+		newRegistryInspectCommand(client),
+		newRegistryReferrersCommand(client),
+		newRegistryTagCommand(client),
+		newRegistryUntagCommand(client),
+		newRegistryTagsCommand(client),
+		newRegistryReleasesCommand(client),
+		newRegistryRunCommand(client),
+		newRegistryGCCommand(client),
+	)
+	return cmd
+}
+
+func newRegistryPushCommand(client *ClientOptions) *cobra.Command {
+	var kind, mediaType string
+	var tags, annotations []string
+
+	cmd := &cobra.Command{
+		Use:     "push FILE",
+		Short:   "Upload an artifact and tag it",
+		Example: `  barnctl registry push build/ledger.dag --kind dag --tag ledger-dag:latest --tag ledger-dag:v1.4.0`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateArtifactKind(kind); err != nil {
+				return err
+			}
+			var refs []ArtifactRef
+			for _, value := range tags {
+				ref, err := ParseArtifactRef(value)
+				if err != nil || ref.Tag == "" {
+					return fmt.Errorf("invalid --tag %q: expected REPOSITORY:TAG", value)
+				}
+				refs = append(refs, ref)
+			}
+			labels, err := applyLabels(nil, annotations)
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to read artifact: %w", err)
+			}
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			artifact, err := api.PushArtifact(ctx, kind, mediaType, data, labels)
+			if err != nil {
+				return fmt.Errorf("failed to push %s: %w", args[0], err)
+			}
+			for _, ref := range refs {
+				if _, err := api.SetTag(ctx, ref.Repository, ref.Tag, artifact.Digest, 0); err != nil {
+					return fmt.Errorf("failed to tag %s as %s: %w", artifact.Digest, ref, err)
+				}
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), artifact.Digest)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&kind, "kind", "", "Kind of the artifact: dag, contract, dataset or sbom")
+	cmd.Flags().StringVar(&mediaType, "media-type", "", "Media type of the artifact's content")
+	cmd.Flags().StringArrayVar(&tags, "tag", nil, "Tag the artifact as REPOSITORY:TAG (repeatable)")
+	cmd.Flags().StringArrayVar(&annotations, "annotation", nil, "Annotation KEY=VALUE (repeatable)")
+	cmd.MarkFlagRequired("kind")
+	return cmd
+}
+
+func newRegistryPullCommand(client *ClientOptions) *cobra.Command {
+	var out string
+
+	cmd := &cobra.Command{
+		Use:   "pull REF",
+		Short: "Download an artifact",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ref, err := ParseArtifactRef(args[0])
+			if err != nil {
+				return err
+			}
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			digest, err := api.Resolve(ctx, ref)
+			if err != nil {
+				return fmt.Errorf("failed to resolve %s: %w", ref, err)
+			}
+			_, data, err := api.PullArtifact(ctx, digest)
+			if err != nil {
+				return fmt.Errorf("failed to pull %s: %w", ref, err)
+			}
+			if out == "" {
+				_, err = cmd.OutOrStdout().Write(data)
+				return err
+			}
+			if err := os.WriteFile(out, data, 0644); err != nil {
+				return fmt.Errorf("failed to write artifact: %w", err)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&out, "out", "", "File to write the artifact to (default stdout)")
+	return cmd
+}
+
+func newRegistryInspectCommand(client *ClientOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "inspect REF",
+		Short: "Print the manifest of an artifact as JSON",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ref, err := ParseArtifactRef(args[0])
+			if err != nil {
+				return err
+			}
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			digest, err := api.Resolve(ctx, ref)
+			if err != nil {
+				return fmt.Errorf("failed to resolve %s: %w", ref, err)
+			}
+			artifact, err := api.GetArtifact(ctx, digest)
+			if err != nil {
+				return fmt.Errorf("failed to get artifact %s: %w", digest, err)
+			}
+			return printJSON(cmd.OutOrStdout(), artifact)
+		},
+	}
+}
+
+func newRegistryReferrersCommand(client *ClientOptions) *cobra.Command {
+	var kind string
+
+	cmd := &cobra.Command{
+		Use:   "referrers REF",
+		Short: "List the artifacts attached to an artifact, like its SBOMs",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ref, err := ParseArtifactRef(args[0])
+			if err != nil {
+				return err
+			}
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			digest, err := api.Resolve(ctx, ref)
+			if err != nil {
+				return fmt.Errorf("failed to resolve %s: %w", ref, err)
+			}
+			list, err := api.Referrers(ctx, digest, kind)
+			if err != nil {
+				return fmt.Errorf("failed to list the referrers of %s: %w", digest, err)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "DIGEST\tKIND\tMEDIA TYPE\tSIZE\tCREATED")
+			for _, artifact := range list.Items {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", artifact.Digest, artifact.Kind, orDash(artifact.MediaType), artifact.Size, artifact.CreatedAt.Format(time.RFC3339))
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().StringVar(&kind, "kind", "", "Only list referrers of this kind")
+	return cmd
+}
+
+func newRegistryTagCommand(client *ClientOptions) *cobra.Command {
+	var revision uint64
+
+	cmd := &cobra.Command{
+		Use:   "tag REF REPOSITORY:TAG",
+		Short: "Point a tag to an artifact",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			source, err := ParseArtifactRef(args[0])
+			if err != nil {
+				return err
+			}
+			target, err := ParseArtifactRef(args[1])
+			if err != nil || target.Tag == "" {
+				return fmt.Errorf("invalid tag %q: expected REPOSITORY:TAG", args[1])
+			}
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			digest, err := api.Resolve(ctx, source)
+			if err != nil {
+				return fmt.Errorf("failed to resolve %s: %w", source, err)
+			}
+			if _, err := api.SetTag(ctx, target.Repository, target.Tag, digest, revision); err != nil {
+				return fmt.Errorf("failed to tag %s as %s: %w", digest, target, err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Tagged %s as %s\n", digest, target)
+			return nil
+		},
+	}
+	cmd.Flags().Uint64Var(&revision, "revision", 0, "Only move the tag if it is still at this revision")
+	return cmd
+}
+
+func newRegistryUntagCommand(client *ClientOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "untag REPOSITORY:TAG",
+		Short: "Remove a tag",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ref, err := ParseArtifactRef(args[0])
+			if err != nil || ref.Tag == "" {
+				return fmt.Errorf("invalid tag %q: expected REPOSITORY:TAG", args[0])
+			}
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			if err := api.DeleteTag(ctx, ref.Repository, ref.Tag, 0); err != nil {
+				return fmt.Errorf("failed to remove tag %s: %w", ref, err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Removed tag %s\n", ref)
+			return nil
+		},
+	}
+}
+
+func newRegistryTagsCommand(client *ClientOptions) *cobra.Command {
+	var repository string
+
+	cmd := &cobra.Command{
+		Use:   "tags",
+		Short: "List tags",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			list, err := api.ListTags(ctx, repository)
+			if err != nil {
+				return fmt.Errorf("failed to list tags: %w", err)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "REPOSITORY\tTAG\tKIND\tDIGEST\tUPDATED")
+			for _, tag := range list.Items {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", tag.Repository, tag.Tag, tag.Kind, shortDigest(tag.Digest), tag.UpdatedAt.Format(time.RFC3339))
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().StringVar(&repository, "repository", "", "Only list the tags of this repository")
+	return cmd
+}
+
+func newRegistryReleasesCommand(client *ClientOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "releases [NAME@VERSION]",
+		Short: "List frozen releases, or show the artifacts of one",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			if len(args) == 1 {
+				name, version, ok := strings.Cut(args[0], "@")
+				if !ok {
+					return fmt.Errorf("invalid release %q: expected NAME@VERSION", args[0])
+				}
+				release, err := api.GetRelease(ctx, name, version)
+				if err != nil {
+					return fmt.Errorf("failed to get release %s: %w", args[0], err)
+				}
+				return printPinned(cmd, release.Artifacts)
+			}
+			list, err := api.ListReleases(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to list releases: %w", err)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tVERSION\tARTIFACTS\tFROZEN")
+			for _, release := range list.Items {
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", release.Name, release.Version, len(release.Artifacts), release.CreatedAt.Format(time.RFC3339))
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func newRegistryRunCommand(client *ClientOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Record and show the artifacts of runs",
+	}
+
+	var herd, release string
+	var artifacts []string
+	record := &cobra.Command{
+		Use:     "record RUN",
+		Short:   "Record the exact artifacts a run uses",
+		Example: `  barnctl registry run record 20261018-ledger-7 --herd finance --release ledger@v1.4.0`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			refs, err := parseRoleRefs(artifacts)
+			if err != nil {
+				return err
+			}
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			ref, err := api.RecordRun(ctx, args[0], RunRefCreate{Herd: herd, Release: release, Artifacts: refs})
+			if err != nil {
+				return fmt.Errorf("failed to record run %s: %w", args[0], err)
+			}
+			return printPinned(cmd, ref.Artifacts)
+		},
+	}
+	record.Flags().StringVar(&herd, "herd", "", "Herd of the run")
+	record.Flags().StringVar(&release, "release", "", "Release NAME@VERSION the run uses")
+	record.Flags().StringArrayVar(&artifacts, "artifact", nil, "Artifact of the run as ROLE=REF, overriding the release's (repeatable)")
+
+	get := &cobra.Command{
+		Use:   "get RUN",
+		Short: "Show the artifacts a run used, to rerun it with them",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			ref, err := api.GetRun(ctx, args[0])
+			if err != nil {
+				return fmt.Errorf("failed to get run %s: %w", args[0], err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Run %s (herd %s, release %s)\n", ref.Run, orDash(ref.Herd), orDash(ref.Release))
+			return printPinned(cmd, ref.Artifacts)
+		},
+	}
+
+	remove := &cobra.Command{
+		Use:   "delete RUN",
+		Short: "Delete the references of a run, releasing its artifacts",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			if err := api.DeleteRun(ctx, args[0]); err != nil {
+				return fmt.Errorf("failed to delete run %s: %w", args[0], err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Deleted the references of run %s\n", args[0])
+			return nil
+		},
+	}
+
+	cmd.AddCommand(record, get, remove)
+	return cmd
+}
+
+func newRegistryGCCommand(client *ClientOptions) *cobra.Command {
+	var dryRun bool
+	var grace time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Delete the artifacts no tag, release or run refers to",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			result, err := api.CollectGarbage(ctx, grace, dryRun)
+			if err != nil {
+				return fmt.Errorf("failed to collect garbage: %w", err)
+			}
+			verb := "Deleted"
+			if dryRun {
+				verb = "Would delete"
+			}
+			for _, digest := range result.Artifacts {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s %s\n", verb, digest)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s %d artifacts and %d chunks (%d bytes)\n", verb, len(result.Artifacts), result.Chunks, result.Bytes)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only list what would be deleted")
+	cmd.Flags().DurationVar(&grace, "grace", 0, "Keep unreferenced artifacts younger than this (default the Barn's)")
+	return cmd
+}
@@ -0,0 +1,205 @@
+package barnctl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestAPIRegistry tests pushing and pulling artifacts, moving tags, and
+// that frozen releases and runs keep the artifacts they were resolved to
+func TestAPIRegistry(t *testing.T) {
+	c, clients := newTestAPI(t, 3)
+	api := clients[c.leader()]
+	ctx := context.Background()
+
+	// The bundle spans three chunks, the last one shorter
+	bundle := bytes.Repeat([]byte("compiled ledger dag "), (2*artifactChunkSize+1000)/20)
+	dag, err := api.PushArtifact(ctx, ArtifactDAG, "application/vnd.runink.dag", bundle, nil)
+	if err != nil {
+		t.Fatalf("Failed to push artifact: %v", err)
+	}
+	if len(dag.Chunks) != 3 || dag.Size != int64(len(bundle)) || dag.Digest != digestOf(bundle) {
+		t.Errorf("Unexpected artifact %+v", dag)
+	}
+	if again, err := api.PushArtifact(ctx, ArtifactDAG, "", bundle, nil); err != nil || again.Revision != dag.Revision {
+		t.Errorf("Expected a repeated push to return the artifact, got %+v, %v", again, err)
+	}
+	if _, err := api.PushArtifact(ctx, ArtifactContract, "", bundle, nil); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected a conflict pushing the artifact as another kind, got %v", err)
+	}
+	_, pulled, err := api.PullArtifact(ctx, dag.Digest)
+	if err != nil || !bytes.Equal(pulled, bundle) {
+		t.Fatalf("Expected to pull the bundle back, got %d bytes, %v", len(pulled), err)
+	}
+
+	// Manifests must add up to their chunks
+	forged := *dag
+	forged.Digest = digestOf([]byte("something else"))
+	err = api.do(ctx, "PUT", "/registry/artifacts/"+forged.Digest, nil, &forged, nil)
+	expectAPIError(t, err, ErrInvalid, "chunks")
+
+	contract, err := api.PushArtifact(ctx, ArtifactContract, "", []byte(`{"fields":["id","amount"]}`), nil)
+	if err != nil {
+		t.Fatalf("Failed to push contract: %v", err)
+	}
+	if _, err := api.SetTag(ctx, "ledger-dag", "latest", dag.Digest, 0); err != nil {
+		t.Fatalf("Failed to tag: %v", err)
+	}
+	tag, err := api.SetTag(ctx, "ledger-contract", "latest", contract.Digest, 0)
+	if err != nil {
+		t.Fatalf("Failed to tag: %v", err)
+	}
+	if _, err := api.SetTag(ctx, "ledger-dag", "broken", digestOf([]byte("missing")), 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound tagging a missing artifact, got %v", err)
+	}
+
+	release, err := api.CreateRelease(ctx, "ledger", "v1.0.0", map[string]string{
+		"dag":      "ledger-dag",
+		"contract": "ledger-contract:latest",
+	})
+	if err != nil {
+		t.Fatalf("Failed to freeze release: %v", err)
+	}
+	if len(release.Artifacts) != 2 || release.Artifacts[0].Role != "contract" || release.Artifacts[1].Digest != dag.Digest {
+		t.Errorf("Unexpected release %+v", release)
+	}
+	if _, err := api.CreateRelease(ctx, "ledger", "v1.0.0", map[string]string{"dag": dag.Digest}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected a frozen release to be immutable, got %v", err)
+	}
+
+	// Moving a tag leaves the release as it was frozen
+	next, err := api.PushArtifact(ctx, ArtifactContract, "", []byte(`{"fields":["id","amount","currency"]}`), nil)
+	if err != nil {
+		t.Fatalf("Failed to push contract: %v", err)
+	}
+	if _, err := api.SetTag(ctx, "ledger-contract", "latest", next.Digest, tag.Revision-1); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected a conflict moving a tag from a stale revision, got %v", err)
+	}
+	if _, err := api.SetTag(ctx, "ledger-contract", "latest", next.Digest, tag.Revision); err != nil {
+		t.Fatalf("Failed to move tag: %v", err)
+	}
+	frozen, err := api.GetRelease(ctx, "ledger", "v1.0.0")
+	if err != nil || frozen.Artifacts[0].Digest != contract.Digest {
+		t.Errorf("Expected the release to keep the frozen contract, got %+v, %v", frozen, err)
+	}
+
+	// Runs record the release's artifacts and their overrides
+	run, err := api.RecordRun(ctx, "20261018-ledger-1", RunRefCreate{
+		Herd:      "finance",
+		Release:   "ledger@v1.0.0",
+		Artifacts: map[string]string{"contract": "ledger-contract"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to record run: %v", err)
+	}
+	if len(run.Artifacts) != 2 || run.Artifacts[0].Digest != next.Digest || run.Artifacts[1].Digest != dag.Digest {
+		t.Errorf("Unexpected run references %+v", run.Artifacts)
+	}
+	if _, err := api.RecordRun(ctx, "20261018-ledger-1", RunRefCreate{Release: "ledger@v1.0.0"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected run references to be recorded once, got %v", err)
+	}
+	if _, err := api.RecordRun(ctx, "20261018-ledger-2", RunRefCreate{Release: "ledger@v9"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing release, got %v", err)
+	}
+
+	// Garbage collection spares everything that is referenced or recent
+	dryRun, err := api.CollectGarbage(ctx, 0, true)
+	if err != nil || len(dryRun.Artifacts) != 0 || dryRun.Chunks != 0 {
+		t.Errorf("Expected nothing to collect within the grace period, got %+v, %v", dryRun, err)
+	}
+	if err := api.DeleteTag(ctx, "ledger-dag", "latest", 0); err != nil {
+		t.Fatalf("Failed to untag: %v", err)
+	}
+	if err := api.DeleteTag(ctx, "ledger-dag", "latest", 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound removing a missing tag, got %v", err)
+	}
+	if _, err := api.GetRun(ctx, "20261018-ledger-1"); err != nil {
+		t.Errorf("Failed to get run: %v", err)
+	}
+}
+
+// TestRegistryGC tests that only the artifacts and chunks nothing refers
+// to are collected
+func TestRegistryGC(t *testing.T) {
+	c, clients := newTestAPI(t, 1)
+	leader := c.leader()
+	api := clients[leader]
+	server := NewServer(ServerConfig{Store: c.stores[leader]})
+	ctx := context.Background()
+
+	push := func(data string) *Artifact {
+		t.Helper()
+		artifact, err := api.PushArtifact(ctx, ArtifactDataset, "text/csv", []byte(data), nil)
+		if err != nil {
+			t.Fatalf("Failed to push artifact: %v", err)
+		}
+		return artifact
+	}
+	tagged, frozen, run, orphan := push("id\n1\n"), push("id\n2\n"), push("id\n3\n"), push("id\n4\n")
+	if _, err := api.SetTag(ctx, "golden", "latest", tagged.Digest, 0); err != nil {
+		t.Fatalf("Failed to tag: %v", err)
+	}
+	if _, err := api.CreateRelease(ctx, "golden", "v1", map[string]string{"golden": frozen.Digest}); err != nil {
+		t.Fatalf("Failed to freeze release: %v", err)
+	}
+	if _, err := api.RecordRun(ctx, "run-1", RunRefCreate{Artifacts: map[string]string{"golden": run.Digest}}); err != nil {
+		t.Fatalf("Failed to record run: %v", err)
+	}
+
+	// A chunk uploaded by a push that never finished
+	stray := []byte("partial upload")
+	if err := api.do(ctx, "PUT", "/registry/chunks/"+digestOf(stray), nil, ArtifactChunk{Data: stray}, nil); err != nil {
+		t.Fatalf("Failed to upload chunk: %v", err)
+	}
+
+	later := time.Now().Add(2 * DefaultRegistryGCGrace)
+	result, err := server.collectGarbage(ctx, DefaultRegistryGCGrace, false, later)
+	if err != nil {
+		t.Fatalf("Failed to collect garbage: %v", err)
+	}
+	if len(result.Artifacts) != 1 || result.Artifacts[0] != orphan.Digest || result.Chunks != 2 {
+		t.Errorf("Expected the orphan and its chunk and the stray chunk collected, got %+v", result)
+	}
+	if _, err := api.GetArtifact(ctx, orphan.Digest); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the orphan to be deleted, got %v", err)
+	}
+	for _, kept := range []*Artifact{tagged, frozen, run} {
+		if _, _, err := api.PullArtifact(ctx, kept.Digest); err != nil {
+			t.Errorf("Expected %s to be kept, got %v", kept.Digest, err)
+		}
+	}
+
+	// Deleting the run releases its artifact
+	if err := api.DeleteRun(ctx, "run-1"); err != nil {
+		t.Fatalf("Failed to delete run: %v", err)
+	}
+	result, err = server.collectGarbage(ctx, DefaultRegistryGCGrace, false, later)
+	if err != nil || len(result.Artifacts) != 1 || result.Artifacts[0] != run.Digest {
+		t.Errorf("Expected the run's artifact collected, got %+v, %v", result, err)
+	}
+}
+
+// TestParseArtifactRef tests the forms of artifact references
+func TestParseArtifactRef(t *testing.T) {
+	digest := digestOf([]byte("dag"))
+	tests := map[string]ArtifactRef{
+		digest:                 {Digest: digest},
+		"ledger-dag":           {Repository: "ledger-dag", Tag: "latest"},
+		"ledger-dag:v1.4.0":    {Repository: "ledger-dag", Tag: "v1.4.0"},
+		"ledger-dag@" + digest: {Repository: "ledger-dag", Digest: digest},
+	}
+	for input, expected := range tests {
+		ref, err := ParseArtifactRef(input)
+		if err != nil || ref != expected {
+			t.Errorf("%s: expected %+v, got %+v, %v", input, expected, ref, err)
+		}
+	}
+	for _, input := range []string{"", "Ledger", "ledger:", "ledger@sha256:abc", "sha256:" + digest[7:10]} {
+		if _, err := ParseArtifactRef(input); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%q: expected ErrInvalid, got %v", input, err)
+		}
+	}
+}
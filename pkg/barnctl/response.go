@@ -0,0 +1,101 @@
+package barnctl
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Error codes of ErrorResponse
+const (
+	CodeInvalid     = "invalid"
+	CodeNotFound    = "not_found"
+	CodeConflict    = "conflict"
+	CodeUnavailable = "unavailable"
+	CodeInternal    = "internal"
+
+	// CodeUnauthenticated and CodeForbidden fail requests without valid
+	// credentials and requests the herd's policies deny
+	CodeUnauthenticated = "unauthenticated"
+	CodeForbidden       = "forbidden"
+
+	// CodeRevisionTooOld fails watches from a compacted revision. The
+	// error's revision is the latest compacted one.
+	CodeRevisionTooOld = "revision_too_old"
+)
+
+// ErrorResponse is the body of every failed API request. Revision is the
+// current revision of the resource on conflicts, and the compacted revision
+// for watches that are too old.
+type ErrorResponse struct {
+	Error    string `json:"error"`
+	Code     string `json:"code"`
+	Field    string `json:"field,omitempty"`
+	Revision uint64 `json:"revision,omitempty"`
+}
+
+// HerdList is the body of GET /api/v1/herds. Revision is the store
+// revision the list was read at.
+type HerdList struct {
+	Items    []Herd `json:"items"`
+	Revision uint64 `json:"revision"`
+}
+
+// SliceList is the body of GET /api/v1/herds/{herd}/slices
+type SliceList struct {
+	Items    []Slice `json:"items"`
+	Revision uint64  `json:"revision"`
+}
+
+// StatusResponse is the body of GET /api/v1/status
+type StatusResponse struct {
+	Raft     RaftStatus `json:"raft"`
+	Revision uint64     `json:"revision"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeError answers with the status and code matching err
+func writeError(w http.ResponseWriter, err error) {
+	status, resp := errorResponse(err)
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+	writeJSON(w, status, resp)
+}
+
+// errorResponse returns the status and body answering err
+func errorResponse(err error) (int, ErrorResponse) {
+	resp := ErrorResponse{Error: err.Error(), Code: CodeInternal}
+	status := http.StatusInternalServerError
+
+	var validation *ValidationError
+	var conflict *ConflictError
+	var tooOld *RevisionTooOldError
+	var notLeader *NotLeaderError
+	switch {
+	case errors.As(err, &validation):
+		status, resp.Code, resp.Field = http.StatusBadRequest, CodeInvalid, validation.Field
+	case errors.Is(err, ErrInvalid):
+		status, resp.Code = http.StatusBadRequest, CodeInvalid
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrKeyNotFound):
+		status, resp.Code = http.StatusNotFound, CodeNotFound
+	case errors.As(err, &conflict):
+		status, resp.Code, resp.Revision = http.StatusConflict, CodeConflict, conflict.Revision
+	case errors.Is(err, ErrConflict):
+		status, resp.Code = http.StatusConflict, CodeConflict
+	case errors.As(err, &tooOld):
+		status, resp.Code, resp.Revision = http.StatusGone, CodeRevisionTooOld, tooOld.Compacted
+	case errors.Is(err, ErrUnauthenticated):
+		status, resp.Code = http.StatusUnauthorized, CodeUnauthenticated
+	case errors.Is(err, ErrForbidden):
+		status, resp.Code = http.StatusForbidden, CodeForbidden
+	case errors.As(err, &notLeader), errors.Is(err, ErrLeadershipLost), errors.Is(err, ErrShutdown), errors.Is(err, ErrSecretsDisabled):
+		status, resp.Code = http.StatusServiceUnavailable, CodeUnavailable
+	}
+	return status, resp
+}
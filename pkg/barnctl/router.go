@@ -0,0 +1,150 @@
+package barnctl
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// APIPrefix is the path prefix of version 1 of the Barn API
+const APIPrefix = "/api/v1"
+
+// Params holds the values of a route's {name} path segments
+type Params map[string]string
+
+// HandlerFunc handles a routed request
+type HandlerFunc func(w http.ResponseWriter, r *http.Request, params Params)
+
+// Router dispatches requests by method and path pattern. Patterns are
+// slash-separated; a {name} segment matches any one segment.
+type Router struct {
+	routes []route
+}
+
+type route struct {
+	method   string
+	segments []string
+	handler  HandlerFunc
+}
+
+// NewRouter returns an empty router
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle routes method requests for pattern to handler
+func (rt *Router) Handle(method, pattern string, handler HandlerFunc) {
+	rt.routes = append(rt.routes, route{
+		method:   method,
+		segments: strings.Split(strings.Trim(pattern, "/"), "/"),
+		handler:  handler,
+	})
+}
+
+// ServeHTTP dispatches the request, answering 404 for unknown paths and
+// 405 for known paths with another method
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var allowed []string
+	for _, route := range rt.routes {
+		params, ok := route.match(segments)
+		if !ok {
+			continue
+		}
+		if route.method != r.Method {
+			allowed = append(allowed, route.method)
+			continue
+		}
+		route.handler(w, r, params)
+		return
+	}
+
+	if len(allowed) > 0 {
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{
+			Error: fmt.Sprintf("method %s not allowed for %s", r.Method, r.URL.Path),
+			Code:  CodeInvalid,
+		})
+		return
+	}
+	writeJSON(w, http.StatusNotFound, ErrorResponse{Error: fmt.Sprintf("no route for %s", r.URL.Path), Code: CodeNotFound})
+}
+
+func (rt route) match(segments []string) (Params, bool) {
+	if len(segments) != len(rt.segments) {
+		return nil, false
+	}
+	params := make(Params)
+	for i, segment := range rt.segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[segment[1:len(segment)-1]] = segments[i]
+		} else if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// routes wires the API handlers
+func (s *Server) routes() *Router {
+	rt := NewRouter()
+	rt.Handle(http.MethodGet, APIPrefix+"/status", s.getStatus)
+
+	rt.Handle(http.MethodGet, APIPrefix+"/herds", s.listHerds)
+	rt.Handle(http.MethodPost, APIPrefix+"/herds", s.createHerd)
+	rt.Handle(http.MethodGet, APIPrefix+"/herds/{herd}", s.getHerd)
+	rt.Handle(http.MethodPut, APIPrefix+"/herds/{herd}", s.updateHerd)
+	rt.Handle(http.MethodDelete, APIPrefix+"/herds/{herd}", s.deleteHerd)
+	rt.Handle(http.MethodPatch, APIPrefix+"/herds/{herd}/quota", s.patchQuota)
+
+	rt.Handle(http.MethodGet, APIPrefix+"/herds/{herd}/slices", s.listSlices)
+	rt.Handle(http.MethodPost, APIPrefix+"/herds/{herd}/slices", s.createSlice)
+	rt.Handle(http.MethodGet, APIPrefix+"/herds/{herd}/slices/{slice}", s.getSlice)
+	rt.Handle(http.MethodPut, APIPrefix+"/herds/{herd}/slices/{slice}", s.updateSlice)
+	rt.Handle(http.MethodDelete, APIPrefix+"/herds/{herd}/slices/{slice}", s.deleteSlice)
+
+	rt.Handle(http.MethodGet, APIPrefix+"/herds/{herd}/secrets", s.listSecrets)
+	rt.Handle(http.MethodGet, APIPrefix+"/herds/{herd}/secrets/{secret}", s.getSecret)
+	rt.Handle(http.MethodPut, APIPrefix+"/herds/{herd}/secrets/{secret}", s.putSecret)
+	rt.Handle(http.MethodDelete, APIPrefix+"/herds/{herd}/secrets/{secret}", s.deleteSecret)
+	rt.Handle(http.MethodGet, APIPrefix+"/herds/{herd}/secrets/{secret}/versions", s.listSecretVersions)
+	rt.Handle(http.MethodPost, APIPrefix+"/herds/{herd}/reencrypt", s.reencryptHerd)
+
+	rt.Handle(http.MethodGet, APIPrefix+"/herds/{herd}/snapshots", s.listSnapshots)
+	rt.Handle(http.MethodPost, APIPrefix+"/herds/{herd}/snapshots", s.createSnapshot)
+	rt.Handle(http.MethodGet, APIPrefix+"/herds/{herd}/snapshots/{snapshot}", s.getSnapshot)
+	rt.Handle(http.MethodDelete, APIPrefix+"/herds/{herd}/snapshots/{snapshot}", s.deleteSnapshot)
+	rt.Handle(http.MethodPost, APIPrefix+"/herds/{herd}/snapshots/{snapshot}/restore", s.restoreSnapshot)
+
+	rt.Handle(http.MethodGet, APIPrefix+"/audit", s.getAuditLog)
+	rt.Handle(http.MethodPost, APIPrefix+"/audit", s.recordAudit)
+	rt.Handle(http.MethodGet, APIPrefix+"/audit/checkpoints", s.listAuditCheckpoints)
+
+	rt.Handle(http.MethodGet, APIPrefix+"/registry/chunks/{digest}", s.getChunk)
+	rt.Handle(http.MethodPut, APIPrefix+"/registry/chunks/{digest}", s.putChunk)
+	rt.Handle(http.MethodGet, APIPrefix+"/registry/artifacts/{digest}", s.getArtifact)
+	rt.Handle(http.MethodPut, APIPrefix+"/registry/artifacts/{digest}", s.putArtifact)
+	rt.Handle(http.MethodGet, APIPrefix+"/registry/artifacts/{digest}/referrers", s.listReferrers)
+	rt.Handle(http.MethodGet, APIPrefix+"/registry/tags", s.listTags)
+	rt.Handle(http.MethodGet, APIPrefix+"/registry/tags/{repository}/{tag}", s.getTag)
+	rt.Handle(http.MethodPut, APIPrefix+"/registry/tags/{repository}/{tag}", s.putTag)
+	rt.Handle(http.MethodDelete, APIPrefix+"/registry/tags/{repository}/{tag}", s.deleteTag)
+	rt.Handle(http.MethodGet, APIPrefix+"/registry/releases", s.listReleases)
+	rt.Handle(http.MethodPost, APIPrefix+"/registry/releases", s.createRelease)
+	rt.Handle(http.MethodGet, APIPrefix+"/registry/releases/{name}/{version}", s.getRelease)
+	rt.Handle(http.MethodGet, APIPrefix+"/registry/runs/{run}", s.getRunRef)
+	rt.Handle(http.MethodPut, APIPrefix+"/registry/runs/{run}", s.putRunRef)
+	rt.Handle(http.MethodDelete, APIPrefix+"/registry/runs/{run}", s.deleteRunRef)
+	rt.Handle(http.MethodPost, APIPrefix+"/registry/gc", s.registryGC)
+
+	rt.Handle(http.MethodGet, APIPrefix+"/tokens/revocations", s.listTokenRevocations)
+	rt.Handle(http.MethodPost, APIPrefix+"/tokens/revocations", s.revokeToken)
+
+	rt.Handle(http.MethodGet, APIPrefix+"/watch", s.watch)
+	return rt
+}
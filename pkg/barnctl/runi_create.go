@@ -0,0 +1,39 @@
+package barnctl
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newRuniCreateCommand(client *ClientOptions) *cobra.Command {
+	options := &SliceOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "runi-create NAME",
+		Short: "Create a Runi Slice",
+		Long: `Create a Runi slice in a Herd through the Barn API. The slice's
+resources must fit within the herd's quota.`,
+		Example: `  barnctl runi-create nightly-ledger --herd finance --scenario ledger.dsl \
+    --cpu 4000m --memory 8Gi --env REGION=eu-west-1`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			slice := &Slice{Herd: options.Herd, Name: args[0]}
+			if err := options.apply(cmd, slice); err != nil {
+				return err
+			}
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			created, err := api.CreateSlice(ctx, slice)
+			if err != nil {
+				return fmt.Errorf("failed to create slice %s/%s: %w", slice.Herd, slice.Name, err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Created slice %s/%s (revision %d)\n", created.Herd, created.Name, created.Revision)
+			return nil
+		},
+	}
+	options.addFlags(cmd)
+	cmd.MarkFlagRequired("scenario")
+	return cmd
+}
@@ -0,0 +1,32 @@
+package barnctl
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newRuniDeleteCommand(client *ClientOptions) *cobra.Command {
+	var herd string
+	var revision uint64
+
+	cmd := &cobra.Command{
+		Use:   "runi-delete NAME",
+		Short: "Delete a Runi Slice",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			if err := api.DeleteSlice(ctx, herd, args[0], revision); err != nil {
+				return fmt.Errorf("failed to delete slice %s/%s: %w", herd, args[0], err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Deleted slice %s/%s\n", herd, args[0])
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&herd, "herd", "", "Herd of the slice")
+	cmd.Flags().Uint64Var(&revision, "revision", 0, "Only delete the slice if it is still at this revision")
+	cmd.MarkFlagRequired("herd")
+	return cmd
+}
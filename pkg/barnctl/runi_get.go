@@ -0,0 +1,61 @@
+package barnctl
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func newRuniGetCommand(client *ClientOptions) *cobra.Command {
+	var herd string
+
+	cmd := &cobra.Command{
+		Use:   "runi-get NAME",
+		Short: "Show a Runi Slice as JSON",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			slice, err := api.GetSlice(ctx, herd, args[0])
+			if err != nil {
+				return fmt.Errorf("failed to get slice %s/%s: %w", herd, args[0], err)
+			}
+			return printJSON(cmd.OutOrStdout(), slice)
+		},
+	}
+	cmd.Flags().StringVar(&herd, "herd", "", "Herd of the slice")
+	cmd.MarkFlagRequired("herd")
+	return cmd
+}
+
+func newRuniListCommand(client *ClientOptions) *cobra.Command {
+	var herd, selector string
+
+	cmd := &cobra.Command{
+		Use:   "runi-list",
+		Short: "List the Runi Slices of a Herd",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			list, err := api.ListSlices(ctx, herd, selector)
+			if err != nil {
+				return fmt.Errorf("failed to list slices of herd %s: %w", herd, err)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tSCENARIO\tNODE\tCPU\tMEMORY\tLABELS\tREVISION")
+			for _, slice := range list.Items {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\n", slice.Name, slice.Scenario, orDash(slice.Node),
+					orDash(slice.Resources.CPU), orDash(slice.Resources.Memory), formatLabels(slice.Labels), slice.Revision)
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().StringVar(&herd, "herd", "", "Herd whose slices to list")
+	cmd.Flags().StringVarP(&selector, "selector", "l", "", "Only list slices with these labels")
+	cmd.MarkFlagRequired("herd")
+	return cmd
+}
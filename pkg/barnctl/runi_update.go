@@ -0,0 +1,49 @@
+package barnctl
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newRuniUpdateCommand(client *ClientOptions) *cobra.Command {
+	options := &SliceOptions{}
+	var revision uint64
+
+	cmd := &cobra.Command{
+		Use:   "runi-update NAME",
+		Short: "Update a Runi Slice",
+		Long: `Update a Runi slice through the Barn API. Like herd-update, the slice
+is read, changed and written back at the revision it was read at.`,
+		Example: `  barnctl runi-update nightly-ledger --herd finance --memory 12Gi --env DEBUG-`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			var updated *Slice
+			err := retryConflicts(ctx, revision, func() error {
+				slice, err := api.GetSlice(ctx, options.Herd, args[0])
+				if err != nil {
+					return err
+				}
+				if revision != 0 {
+					slice.Revision = revision
+				}
+				if err := options.apply(cmd, slice); err != nil {
+					return err
+				}
+				updated, err = api.UpdateSlice(ctx, slice)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("failed to update slice %s/%s: %w", options.Herd, args[0], err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Updated slice %s/%s (revision %d)\n", updated.Herd, updated.Name, updated.Revision)
+			return nil
+		},
+	}
+	options.addFlags(cmd)
+	cmd.Flags().Uint64Var(&revision, "revision", 0, "Only update the slice if it is still at this revision")
+	return cmd
+}
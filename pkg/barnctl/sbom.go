@@ -0,0 +1,672 @@
+package barnctl
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"debug/buildinfo"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// SBOM formats
+const (
+	SBOMFormatSPDX      = "spdx"
+	SBOMFormatCycloneDX = "cyclonedx"
+)
+
+const (
+	spdxMediaType      = "application/spdx+json"
+	cycloneDXMediaType = "application/vnd.cyclonedx+json"
+)
+
+// sbomSettings are the build settings recorded for binaries. Others, like
+// -ldflags, may carry values set with -X that do not belong in an SBOM.
+var sbomSettings = map[string]bool{
+	"GOOS": true, "GOARCH": true, "GOAMD64": true, "GOARM": true, "CGO_ENABLED": true,
+	"-buildmode": true, "-compiler": true, "-tags": true, "-trimpath": true,
+	"vcs": true, "vcs.revision": true, "vcs.time": true, "vcs.modified": true,
+}
+
+// SBOM is the software bill of materials of a pipeline: its compiled
+// pipeline and step binaries, the Go modules they were built from and the
+// connectors they read and write through
+type SBOM struct {
+	Name       string          `json:"name"`
+	Subject    string          `json:"subject,omitempty"`
+	Created    time.Time       `json:"created"`
+	Binaries   []SBOMBinary    `json:"binaries"`
+	Modules    []SBOMModule    `json:"modules"`
+	Connectors []SBOMConnector `json:"connectors"`
+}
+
+// SBOMBinary is a Go binary and the build information embedded in it.
+// Modules lists the keys of the modules it links.
+type SBOMBinary struct {
+	Name      string            `json:"name"`
+	Digest    string            `json:"digest"`
+	GoVersion string            `json:"goVersion"`
+	Path      string            `json:"path,omitempty"`
+	Module    string            `json:"module,omitempty"`
+	Version   string            `json:"version,omitempty"`
+	Settings  map[string]string `json:"settings,omitempty"`
+	Modules   []string          `json:"modules"`
+}
+
+// SBOMModule is a Go module. Linked modules are compiled into a binary;
+// the others are only listed in a go.sum, and needed to build.
+type SBOMModule struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Sum     string `json:"sum,omitempty"`
+	Linked  bool   `json:"linked"`
+}
+
+// SBOMConnector is a source or sink a pipeline declares, by its URI
+// without credentials
+type SBOMConnector struct {
+	Name   string `json:"name"`
+	Scheme string `json:"scheme"`
+	URI    string `json:"uri"`
+}
+
+func (m SBOMModule) key() string {
+	return m.Path + "@" + m.Version
+}
+
+func (m SBOMModule) purl() string {
+	return "pkg:golang/" + m.Path + "@" + m.Version
+}
+
+// NewSBOM returns an empty SBOM
+func NewSBOM(name, subject string, created time.Time) *SBOM {
+	return &SBOM{Name: name, Subject: subject, Created: created.UTC().Truncate(time.Second), Binaries: []SBOMBinary{}, Modules: []SBOMModule{}, Connectors: []SBOMConnector{}}
+}
+
+// AddBinary adds a compiled pipeline or step binary, with the modules its
+// build information lists. The standard library is listed as module
+// stdlib at the binary's Go version.
+func (b *SBOM) AddBinary(name string, data []byte) error {
+	info, err := buildinfo.Read(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s is not a Go binary with build information: %w", name, err)
+	}
+	binary := SBOMBinary{
+		Name:      name,
+		Digest:    digestOf(data),
+		GoVersion: info.GoVersion,
+		Path:      info.Path,
+		Module:    info.Main.Path,
+		Version:   info.Main.Version,
+		Settings:  make(map[string]string),
+		Modules:   []string{},
+	}
+	for _, setting := range info.Settings {
+		if sbomSettings[setting.Key] {
+			binary.Settings[setting.Key] = setting.Value
+		}
+	}
+
+	stdlib := SBOMModule{Path: "stdlib", Version: info.GoVersion, Linked: true}
+	binary.Modules = append(binary.Modules, b.addModule(stdlib))
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		// Replacements by local directories have no version
+		if dep.Version == "" {
+			continue
+		}
+		binary.Modules = append(binary.Modules, b.addModule(SBOMModule{Path: dep.Path, Version: dep.Version, Sum: dep.Sum, Linked: true}))
+	}
+	sort.Strings(binary.Modules)
+	b.Binaries = append(b.Binaries, binary)
+	sort.Slice(b.Binaries, func(i, j int) bool { return b.Binaries[i].Name < b.Binaries[j].Name })
+	return nil
+}
+
+// AddGoSum adds the modules of a go.sum. Modules no binary links are
+// listed as build dependencies; the sums of linked ones fill in those
+// missing from the build information.
+func (b *SBOM) AddGoSum(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 || !strings.HasPrefix(fields[2], "h1:") {
+			return fmt.Errorf("invalid go.sum line %d: %q", line, scanner.Text())
+		}
+		// Sums of go.mod files alone are for modules the build never loaded
+		if strings.HasSuffix(fields[1], "/go.mod") {
+			continue
+		}
+		b.addModule(SBOMModule{Path: fields[0], Version: fields[1], Sum: fields[2]})
+	}
+	return scanner.Err()
+}
+
+// AddConnector adds a source or sink of the pipeline. Credentials, query
+// and fragment are removed from the URI; a URI without a scheme is a
+// local file.
+func (b *SBOM) AddConnector(name, uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("invalid URI of connector %s: %w", name, err)
+	}
+	u.User, u.RawQuery, u.ForceQuery, u.Fragment, u.RawFragment = nil, "", false, "", ""
+	connector := SBOMConnector{Name: name, Scheme: u.Scheme, URI: u.String()}
+	if connector.Scheme == "" {
+		connector.Scheme = "file"
+	}
+	for _, existing := range b.Connectors {
+		if existing.Name == name {
+			return fmt.Errorf("connector %s is declared twice", name)
+		}
+	}
+	b.Connectors = append(b.Connectors, connector)
+	sort.Slice(b.Connectors, func(i, j int) bool { return b.Connectors[i].Name < b.Connectors[j].Name })
+	return nil
+}
+
+// addModule adds a module or merges it into the one of the same version,
+// and returns its key
+func (b *SBOM) addModule(module SBOMModule) string {
+	for i := range b.Modules {
+		existing := &b.Modules[i]
+		if existing.key() == module.key() {
+			existing.Linked = existing.Linked || module.Linked
+			if existing.Sum == "" {
+				existing.Sum = module.Sum
+			}
+			return existing.key()
+		}
+	}
+	b.Modules = append(b.Modules, module)
+	sort.Slice(b.Modules, func(i, j int) bool { return b.Modules[i].key() < b.Modules[j].key() })
+	return module.key()
+}
+
+// Encode returns the SBOM as an SPDX 2.3 or CycloneDX 1.5 JSON document,
+// and the document's media type
+func (b *SBOM) Encode(format string) ([]byte, string, error) {
+	var document any
+	var mediaType string
+	switch format {
+	case SBOMFormatSPDX:
+		document, mediaType = b.spdx(), spdxMediaType
+	case SBOMFormatCycloneDX:
+		document, mediaType = b.cycloneDX(), cycloneDXMediaType
+	default:
+		return nil, "", fmt.Errorf("unknown SBOM format %q: expected spdx or cyclonedx", format)
+	}
+	data, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, "", err
+	}
+	return append(data, '\n'), mediaType, nil
+}
+
+// id returns a stable identifier of the SBOM's content, for the SPDX
+// document namespace and the CycloneDX serial number
+func (b *SBOM) id() [sha256.Size]byte {
+	data, _ := json.Marshal(b)
+	return sha256.Sum256(data)
+}
+
+// sumSHA256 returns the hex SHA-256 of a go.sum h1: hash, the hash of the
+// module's file tree
+func sumSHA256(sum string) string {
+	hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sum, "h1:"))
+	if err != nil || len(hash) != sha256.Size || !strings.HasPrefix(sum, "h1:") {
+		return ""
+	}
+	return hex.EncodeToString(hash)
+}
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name                  string            `json:"name"`
+	SPDXID                string            `json:"SPDXID"`
+	VersionInfo           string            `json:"versionInfo,omitempty"`
+	DownloadLocation      string            `json:"downloadLocation"`
+	FilesAnalyzed         bool              `json:"filesAnalyzed"`
+	Checksums             []spdxChecksum    `json:"checksums,omitempty"`
+	ExternalRefs          []spdxExternalRef `json:"externalRefs,omitempty"`
+	PrimaryPackagePurpose string            `json:"primaryPackagePurpose,omitempty"`
+	LicenseConcluded      string            `json:"licenseConcluded"`
+	LicenseDeclared       string            `json:"licenseDeclared"`
+	CopyrightText         string            `json:"copyrightText"`
+	Comment               string            `json:"comment,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// spdxIDRE matches the characters SPDX identifiers may not contain
+var spdxIDRE = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// spdxIDs hands out unique SPDX identifiers
+type spdxIDs map[string]bool
+
+func (ids spdxIDs) next(kind, name string) string {
+	id := "SPDXRef-" + kind + "-" + strings.Trim(spdxIDRE.ReplaceAllString(name, "-"), "-")
+	unique := id
+	for n := 2; ids[unique]; n++ {
+		unique = fmt.Sprintf("%s-%d", id, n)
+	}
+	ids[unique] = true
+	return unique
+}
+
+func (b *SBOM) spdx() *spdxDocument {
+	id := b.id()
+	doc := &spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              b.Name,
+		DocumentNamespace: "https://runink.io/spdx/" + url.PathEscape(b.Name) + "-" + hex.EncodeToString(id[:16]),
+		CreationInfo:      spdxCreationInfo{Created: b.Created.Format(time.RFC3339), Creators: []string{"Tool: runi"}},
+		Packages:          []spdxPackage{},
+		Relationships:     []spdxRelationship{},
+	}
+	ids := spdxIDs{}
+	pkg := func(name, id string) spdxPackage {
+		return spdxPackage{Name: name, SPDXID: id, DownloadLocation: "NOASSERTION", LicenseConcluded: "NOASSERTION", LicenseDeclared: "NOASSERTION", CopyrightText: "NOASSERTION"}
+	}
+	relate := func(from, relationship, to string) {
+		doc.Relationships = append(doc.Relationships, spdxRelationship{SPDXElementID: from, RelationshipType: relationship, RelatedSPDXElement: to})
+	}
+
+	modules := make(map[string]string)
+	for _, module := range b.Modules {
+		p := pkg(module.Path, ids.next("Module", module.key()))
+		p.VersionInfo = module.Version
+		p.PrimaryPackagePurpose = "LIBRARY"
+		if module.Path != "stdlib" {
+			p.DownloadLocation = "https://proxy.golang.org/" + module.Path + "/@v/" + module.Version + ".zip"
+		}
+		p.ExternalRefs = []spdxExternalRef{{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: module.purl()}}
+		if hash := sumSHA256(module.Sum); hash != "" {
+			p.Checksums = []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: hash}}
+		}
+		doc.Packages = append(doc.Packages, p)
+		modules[module.key()] = p.SPDXID
+	}
+	connectors := make([]string, len(b.Connectors))
+	for i, connector := range b.Connectors {
+		connectors[i] = ids.next("Connector", connector.Name)
+	}
+	for _, binary := range b.Binaries {
+		p := pkg(binary.Name, ids.next("Binary", binary.Name))
+		p.VersionInfo = binary.Version
+		p.PrimaryPackagePurpose = "APPLICATION"
+		p.Checksums = []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: strings.TrimPrefix(binary.Digest, "sha256:")}}
+		p.Comment = binary.comment()
+		doc.Packages = append(doc.Packages, p)
+		relate(doc.SPDXID, "DESCRIBES", p.SPDXID)
+		for _, key := range binary.Modules {
+			relate(p.SPDXID, "DEPENDS_ON", modules[key])
+		}
+		for _, module := range b.Modules {
+			if !module.Linked {
+				relate(modules[module.key()], "BUILD_DEPENDENCY_OF", p.SPDXID)
+			}
+		}
+		for _, id := range connectors {
+			relate(p.SPDXID, "DEPENDS_ON", id)
+		}
+	}
+	for i, connector := range b.Connectors {
+		p := pkg(connector.Name, connectors[i])
+		p.PrimaryPackagePurpose = "OTHER"
+		p.Comment = fmt.Sprintf("%s connector at %s", connector.Scheme, connector.URI)
+		doc.Packages = append(doc.Packages, p)
+		if len(b.Binaries) == 0 {
+			relate(doc.SPDXID, "DESCRIBES", p.SPDXID)
+		}
+	}
+	return doc
+}
+
+func (binary SBOMBinary) comment() string {
+	parts := []string{"Go " + binary.GoVersion}
+	if binary.Module != "" {
+		parts = append(parts, "module "+binary.Module)
+	}
+	keys := make([]string, 0, len(binary.Settings))
+	for key := range binary.Settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		parts = append(parts, key+"="+binary.Settings[key])
+	}
+	return strings.Join(parts, ", ")
+}
+
+type cycloneDXDocument struct {
+	BOMFormat    string                `json:"bomFormat"`
+	SpecVersion  string                `json:"specVersion"`
+	SerialNumber string                `json:"serialNumber"`
+	Version      int                   `json:"version"`
+	Metadata     cycloneDXMetadata     `json:"metadata"`
+	Components   []cycloneDXComponent  `json:"components"`
+	Services     []cycloneDXService    `json:"services,omitempty"`
+	Dependencies []cycloneDXDependency `json:"dependencies"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string             `json:"timestamp"`
+	Tools     cycloneDXTools     `json:"tools"`
+	Component cycloneDXComponent `json:"component"`
+}
+
+type cycloneDXTools struct {
+	Components []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXComponent struct {
+	Type       string              `json:"type"`
+	BOMRef     string              `json:"bom-ref,omitempty"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version,omitempty"`
+	Scope      string              `json:"scope,omitempty"`
+	PURL       string              `json:"purl,omitempty"`
+	Hashes     []cycloneDXHash     `json:"hashes,omitempty"`
+	Properties []cycloneDXProperty `json:"properties,omitempty"`
+}
+
+type cycloneDXHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cycloneDXService struct {
+	BOMRef     string              `json:"bom-ref"`
+	Name       string              `json:"name"`
+	Endpoints  []string            `json:"endpoints"`
+	Group      string              `json:"group,omitempty"`
+	Properties []cycloneDXProperty `json:"properties,omitempty"`
+}
+
+type cycloneDXDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+func (b *SBOM) cycloneDX() *cycloneDXDocument {
+	id := b.id()
+	// The serial number is a version 4 UUID taken from the content's hash
+	id[6], id[8] = id[6]&0x0f|0x40, id[8]&0x3f|0x80
+	serial := fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+
+	root := cycloneDXComponent{Type: "application", BOMRef: "pipeline:" + b.Name, Name: b.Name}
+	if b.Subject != "" {
+		root.Properties = []cycloneDXProperty{{Name: "runink:artifact", Value: b.Subject}}
+	}
+	doc := &cycloneDXDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: serial,
+		Version:      1,
+		Metadata: cycloneDXMetadata{
+			Timestamp: b.Created.Format(time.RFC3339),
+			Tools:     cycloneDXTools{Components: []cycloneDXComponent{{Type: "application", Name: "runi"}}},
+			Component: root,
+		},
+		Components:   []cycloneDXComponent{},
+		Dependencies: []cycloneDXDependency{},
+	}
+
+	linked, connectors := []string{}, []string{}
+	for _, module := range b.Modules {
+		component := cycloneDXComponent{Type: "library", BOMRef: module.purl(), Name: module.Path, Version: module.Version, Scope: "required", PURL: module.purl()}
+		if !module.Linked {
+			component.Scope = "excluded"
+		}
+		if hash := sumSHA256(module.Sum); hash != "" {
+			component.Hashes = []cycloneDXHash{{Alg: "SHA-256", Content: hash}}
+		}
+		doc.Components = append(doc.Components, component)
+	}
+	for _, connector := range b.Connectors {
+		ref := "connector:" + connector.Name
+		doc.Services = append(doc.Services, cycloneDXService{
+			BOMRef:     ref,
+			Name:       connector.Name,
+			Endpoints:  []string{connector.URI},
+			Properties: []cycloneDXProperty{{Name: "runink:connector", Value: connector.Scheme}},
+		})
+		connectors = append(connectors, ref)
+	}
+	for _, binary := range b.Binaries {
+		ref := "binary:" + binary.Name
+		component := cycloneDXComponent{Type: "application", BOMRef: ref, Name: binary.Name, Version: binary.Version, Hashes: []cycloneDXHash{{Alg: "SHA-256", Content: strings.TrimPrefix(binary.Digest, "sha256:")}}}
+		component.Properties = append(component.Properties, cycloneDXProperty{Name: "runink:go-version", Value: binary.GoVersion})
+		if binary.Module != "" {
+			component.Properties = append(component.Properties, cycloneDXProperty{Name: "runink:go-module", Value: binary.Module})
+		}
+		doc.Components = append(doc.Components, component)
+
+		dependsOn := []string{}
+		for _, key := range binary.Modules {
+			dependsOn = append(dependsOn, "pkg:golang/"+key)
+		}
+		dependsOn = append(dependsOn, connectors...)
+		doc.Dependencies = append(doc.Dependencies, cycloneDXDependency{Ref: ref, DependsOn: dependsOn})
+		linked = append(linked, ref)
+	}
+	if len(b.Binaries) == 0 {
+		linked = connectors
+	}
+	doc.Dependencies = append([]cycloneDXDependency{{Ref: root.BOMRef, DependsOn: linked}}, doc.Dependencies...)
+	return doc
+}
+
+// parseConnectors parses NAME=URI flags
+func parseConnectors(values []string) ([][2]string, error) {
+	var connectors [][2]string
+	for _, value := range values {
+		name, uri, ok := strings.Cut(value, "=")
+		if !ok || name == "" || uri == "" {
+			return nil, fmt.Errorf("invalid --connector %q: expected NAME=URI", value)
+		}
+		connectors = append(connectors, [2]string{name, uri})
+	}
+	return connectors, nil
+}
+
+// NewSBOMCommand returns runi sbom, which exports the software bill of
+// materials of compiled pipelines
+func NewSBOMCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sbom",
+		Short: "Generate software bills of materials for pipeline bundles",
+	}
+	cmd.AddCommand(newSBOMExportCommand())
+	return cmd
+}
+
+func newSBOMExportCommand() *cobra.Command {
+	client := &ClientOptions{}
+	var format, name, out string
+	var binaries, goSums, connectors []string
+	var attach bool
+
+	cmd := &cobra.Command{
+		Use:   "export [REF]",
+		Short: "Export the SBOM of a pipeline as SPDX or CycloneDX JSON",
+		Long: `Generate the software bill of materials of a pipeline from the Go build
+information of its compiled pipeline and step binaries, the modules of its
+go.sum files and the connectors it declares, and write it as SPDX 2.3 or
+CycloneDX 1.5 JSON.
+
+REF is a DAG bundle in the Barn registry, whose compiled pipeline binary is
+read like one given with --binary. With --attach the SBOM is pushed to the
+registry and attached to REF, which keeps it for as long as REF is kept.
+Credentials and query strings are removed from connector URIs.`,
+		Example: `  runi sbom export --format spdx --binary build/pipeline --go-sum build/pipeline/go.sum \
+    --connector source=kafka://broker:9092/ledger --connector sink=s3://warehouse/ledger
+  runi sbom export ledger-dag:v1.4.0 --format cyclonedx --attach`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			declared, err := parseConnectors(connectors)
+			if err != nil {
+				return err
+			}
+			if len(args) == 0 && len(binaries) == 0 {
+				return errors.New("a registry reference or at least one --binary is required")
+			}
+			if attach && len(args) == 0 {
+				return errors.New("--attach requires a registry reference")
+			}
+
+			var ref ArtifactRef
+			var subject *Artifact
+			var bundle []byte
+			var subjectDigest string
+			if len(args) == 1 {
+				if ref, err = ParseArtifactRef(args[0]); err != nil {
+					return err
+				}
+				api, ctx, cancel := client.client(cmd)
+				defer cancel()
+				digest, err := api.Resolve(ctx, ref)
+				if err != nil {
+					return fmt.Errorf("failed to resolve %s: %w", ref, err)
+				}
+				if subject, bundle, err = api.PullArtifact(ctx, digest); err != nil {
+					return fmt.Errorf("failed to pull %s: %w", ref, err)
+				}
+				subjectDigest = subject.Digest
+			}
+
+			if name == "" {
+				switch {
+				case subject != nil && ref.Repository != "":
+					name = ref.Repository
+				case subject != nil:
+					name = subject.Digest
+				default:
+					name = filepath.Base(binaries[0])
+				}
+			}
+			sbom := NewSBOM(name, subjectDigest, time.Now())
+			if subject != nil && subject.Kind == ArtifactDAG {
+				if err := sbom.AddBinary(ref.String(), bundle); err != nil {
+					return err
+				}
+			}
+			for _, path := range binaries {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return fmt.Errorf("failed to read binary: %w", err)
+				}
+				if err := sbom.AddBinary(filepath.Base(path), data); err != nil {
+					return err
+				}
+			}
+			for _, path := range goSums {
+				f, err := os.Open(path)
+				if err != nil {
+					return fmt.Errorf("failed to read go.sum: %w", err)
+				}
+				err = sbom.AddGoSum(f)
+				f.Close()
+				if err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+			}
+			for _, connector := range declared {
+				if err := sbom.AddConnector(connector[0], connector[1]); err != nil {
+					return err
+				}
+			}
+
+			document, mediaType, err := sbom.Encode(format)
+			if err != nil {
+				return err
+			}
+			if attach {
+				api, ctx, cancel := client.client(cmd)
+				defer cancel()
+				annotations := map[string]string{"runink.io/sbom-format": format}
+				attached, err := api.AttachArtifact(ctx, subjectDigest, ArtifactSBOM, mediaType, document, annotations)
+				if err != nil {
+					return fmt.Errorf("failed to attach the SBOM to %s: %w", ref, err)
+				}
+				fmt.Fprintf(cmd.ErrOrStderr(), "Attached SBOM %s to %s\n", attached.Digest, subjectDigest)
+				if out == "" {
+					return nil
+				}
+			}
+			if out == "" {
+				_, err = cmd.OutOrStdout().Write(document)
+				return err
+			}
+			if err := os.WriteFile(out, document, 0644); err != nil {
+				return fmt.Errorf("failed to write SBOM: %w", err)
+			}
+			return nil
+		},
+	}
+	client.addFlags(cmd)
+	cmd.Flags().StringVar(&format, "format", SBOMFormatSPDX, "Format of the SBOM: spdx or cyclonedx")
+	cmd.Flags().StringVar(&name, "name", "", "Name of the pipeline (default the repository of REF or the first binary)")
+	cmd.Flags().StringVar(&out, "out", "", "File to write the SBOM to (default stdout, or none with --attach)")
+	cmd.Flags().StringArrayVar(&binaries, "binary", nil, "Compiled pipeline or step binary (repeatable)")
+	cmd.Flags().StringArrayVar(&goSums, "go-sum", nil, "go.sum of a pipeline or step module (repeatable)")
+	cmd.Flags().StringArrayVar(&connectors, "connector", nil, "Source or sink of the pipeline as NAME=URI (repeatable)")
+	cmd.Flags().BoolVar(&attach, "attach", false, "Push the SBOM to the registry, attached to REF")
+	return cmd
+}
@@ -0,0 +1,404 @@
+package barnctl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSecretSize caps secret values
+const maxSecretSize = 64 << 10
+
+// SecretVersion is a version of a secret as stored in Barn. Its value is
+// encrypted with a data key of its own, which is wrapped by a version of
+// the herd's key-encryption key. Versions are immutable: rotating the KEK
+// only rewraps the data key, and deleting a version only sets DeletedAt.
+type SecretVersion struct {
+	Herd       string     `json:"herd"`
+	Name       string     `json:"name"`
+	Version    uint32     `json:"version"`
+	Algorithm  string     `json:"algorithm"`
+	Ciphertext []byte     `json:"ciphertext"`
+	WrappedKey []byte     `json:"wrappedKey"`
+	KEKVersion uint32     `json:"kekVersion"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	DeletedAt  *time.Time `json:"deletedAt,omitempty"`
+}
+
+// info returns the version without its ciphertext
+func (v *SecretVersion) info(revision uint64) SecretVersionInfo {
+	return SecretVersionInfo{
+		Herd:       v.Herd,
+		Name:       v.Name,
+		Version:    v.Version,
+		KEKVersion: v.KEKVersion,
+		CreatedAt:  v.CreatedAt,
+		DeletedAt:  v.DeletedAt,
+		Revision:   revision,
+	}
+}
+
+// SecretVersionInfo describes a secret version without its value
+type SecretVersionInfo struct {
+	Herd       string     `json:"herd"`
+	Name       string     `json:"name"`
+	Version    uint32     `json:"version"`
+	KEKVersion uint32     `json:"kekVersion"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	DeletedAt  *time.Time `json:"deletedAt,omitempty"`
+	Revision   uint64     `json:"revision"`
+}
+
+// SecretInfo summarizes a secret. Version is its latest version that is
+// not deleted, or 0 if all are.
+type SecretInfo struct {
+	Name      string    `json:"name"`
+	Version   uint32    `json:"version"`
+	Versions  int       `json:"versions"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// SecretValue is the decrypted value of a secret version
+type SecretValue struct {
+	Herd      string    `json:"herd"`
+	Name      string    `json:"name"`
+	Version   uint32    `json:"version"`
+	Value     []byte    `json:"value"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SecretPut is the body of PUT /api/v1/herds/{herd}/secrets/{secret}
+type SecretPut struct {
+	Value []byte `json:"value"`
+}
+
+// SecretList is the body of GET /api/v1/herds/{herd}/secrets
+type SecretList struct {
+	Items    []SecretInfo `json:"items"`
+	Revision uint64       `json:"revision"`
+}
+
+// SecretVersionList is the body of GET
+// /api/v1/herds/{herd}/secrets/{secret}/versions, oldest first
+type SecretVersionList struct {
+	Items    []SecretVersionInfo `json:"items"`
+	Revision uint64              `json:"revision"`
+}
+
+// putSecret stores a value as the next version of a secret, encrypted
+// under the herd's current KEK
+func (s *Server) putSecret(w http.ResponseWriter, r *http.Request, params Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	keys, err := s.secretKeys()
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	name := params["secret"]
+	if err := validateSecretName(name); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	body := SecretPut{}
+	if err := decodeBody(r, &body); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	defer zero(body.Value)
+	if len(body.Value) > maxSecretSize {
+		s.fail(w, r, &ValidationError{Field: "value", Message: fmt.Sprintf("exceeds %d bytes", maxSecretSize)})
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		version, revision, err := s.writeSecretVersion(r.Context(), keys, params["herd"], name, body.Value)
+		if err == nil {
+			writeJSON(w, http.StatusCreated, version.info(revision))
+			return
+		}
+		// Another version, a KEK rotation or a herd update got in between
+		if errors.Is(err, ErrConflict) && attempt < maxUpdateAttempts {
+			if err = s.store.Sync(r.Context()); err == nil {
+				continue
+			}
+		}
+		s.fail(w, r, err)
+		return
+	}
+}
+
+// writeSecretVersion encrypts value as the version after the latest one.
+// The write requires the herd, its secrets policy and the KEK to be the
+// ones the value was encrypted for.
+func (s *Server) writeSecretVersion(ctx context.Context, keys *keyRing, herdName, name string, value []byte) (*SecretVersion, uint64, error) {
+	herd, err := s.readHerd(herdName)
+	if err != nil {
+		return nil, 0, err
+	}
+	if herd.Secrets.Encryption != "" && herd.Secrets.Encryption != EncryptionAES256 {
+		return nil, 0, &ValidationError{
+			Field:   "secretsScope.encryption",
+			Message: fmt.Sprintf("herd %s requires %s, only %s is supported", herd.Name, herd.Secrets.Encryption, EncryptionAES256),
+		}
+	}
+	kek, err := keys.ensure(ctx, herd.Name)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer zero(kek.key)
+
+	versions, err := s.readSecretVersions(herd.Name, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	version := &SecretVersion{Herd: herd.Name, Name: name, Version: 1, CreatedAt: time.Now().UTC()}
+	if len(versions) > 0 {
+		version.Version = versions[len(versions)-1].Version + 1
+	}
+	if err := encryptSecret(kek, version, value); err != nil {
+		return nil, 0, err
+	}
+	data, err := json.Marshal(version)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	key := secretVersionKey(herd.Name, name, version.Version)
+	conditions := []Condition{
+		IfRevision(herdKey(herd.Name), herd.Revision),
+		IfRevision(kekKey(herd.Name, kek.version), kek.revision),
+		currentCondition(kek),
+		IfRevision(key, 0),
+	}
+	revision, err := s.store.Txn(ctx, conditions, OpPut(key, data))
+	return version, revision, err
+}
+
+// getSecret decrypts a secret version, by default the latest one that is
+// not deleted
+func (s *Server) getSecret(w http.ResponseWriter, r *http.Request, params Params) {
+	if !s.prepareRead(w, r) {
+		return
+	}
+	keys, err := s.secretKeys()
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	number, err := queryVersion(r)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	version, err := s.readLiveSecretVersion(params["herd"], params["secret"], number)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	kek, err := keys.get(version.Herd, version.KEKVersion)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	defer zero(kek.key)
+	value, err := decryptSecret(kek, version)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	defer zero(value)
+
+	// A read that cannot be audited is not answered
+	audit := AuditEvent{Action: "secret.read", Resource: r.URL.Path, Details: map[string]string{"version": strconv.FormatUint(uint64(version.Version), 10)}}
+	if err := s.store.Audit(r.Context(), audit); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, SecretValue{Herd: version.Herd, Name: version.Name, Version: version.Version, Value: value, CreatedAt: version.CreatedAt})
+}
+
+func (s *Server) listSecrets(w http.ResponseWriter, r *http.Request, params Params) {
+	if !s.prepareRead(w, r) {
+		return
+	}
+	herd := params["herd"]
+	if _, err := s.readHerd(herd); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	list := SecretList{Items: []SecretInfo{}, Revision: s.store.Revision()}
+	for _, kv := range s.store.Range(secretPrefix(herd)) {
+		version := SecretVersion{}
+		if err := json.Unmarshal(kv.Value, &version); err != nil {
+			s.fail(w, r, fmt.Errorf("corrupt secret %s: %w", kv.Key, err))
+			return
+		}
+		// Versions of a secret are adjacent and sorted
+		if n := len(list.Items); n == 0 || list.Items[n-1].Name != version.Name {
+			list.Items = append(list.Items, SecretInfo{Name: version.Name})
+		}
+		info := &list.Items[len(list.Items)-1]
+		info.Versions++
+		if version.DeletedAt == nil {
+			info.Version = version.Version
+			info.UpdatedAt = version.CreatedAt
+		}
+	}
+	visible := list.Items[:0]
+	for _, info := range list.Items {
+		if s.visible(r, herd, "access:secrets", info.Name) {
+			visible = append(visible, info)
+		}
+	}
+	list.Items = visible
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) listSecretVersions(w http.ResponseWriter, r *http.Request, params Params) {
+	if !s.prepareRead(w, r) {
+		return
+	}
+	herd, name := params["herd"], params["secret"]
+	kvs := s.store.Range(secretVersionPrefix(herd, name))
+	if len(kvs) == 0 {
+		s.fail(w, r, fmt.Errorf("secret %s/%s: %w", herd, name, ErrNotFound))
+		return
+	}
+
+	list := SecretVersionList{Items: []SecretVersionInfo{}, Revision: s.store.Revision()}
+	for _, kv := range kvs {
+		version := SecretVersion{}
+		if err := json.Unmarshal(kv.Value, &version); err != nil {
+			s.fail(w, r, fmt.Errorf("corrupt secret %s: %w", kv.Key, err))
+			return
+		}
+		list.Items = append(list.Items, version.info(kv.ModRevision))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// deleteSecret soft-deletes a secret version, or every version without
+// ?version. Deleted versions stay in the history but cannot be read.
+func (s *Server) deleteSecret(w http.ResponseWriter, r *http.Request, params Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	number, err := queryVersion(r)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	herd, name := params["herd"], params["secret"]
+	kvs := s.store.Range(secretVersionPrefix(herd, name))
+	if len(kvs) == 0 {
+		s.fail(w, r, fmt.Errorf("secret %s/%s: %w", herd, name, ErrNotFound))
+		return
+	}
+
+	now := time.Now().UTC()
+	var conditions []Condition
+	var ops []Op
+	for _, kv := range kvs {
+		version := SecretVersion{}
+		if err := json.Unmarshal(kv.Value, &version); err != nil {
+			s.fail(w, r, fmt.Errorf("corrupt secret %s: %w", kv.Key, err))
+			return
+		}
+		if (number != 0 && version.Version != number) || version.DeletedAt != nil {
+			continue
+		}
+		version.DeletedAt = &now
+		data, err := json.Marshal(version)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		conditions = append(conditions, IfRevision(kv.Key, kv.ModRevision))
+		ops = append(ops, OpPut(kv.Key, data))
+	}
+	if len(ops) == 0 {
+		if number != 0 {
+			err = fmt.Errorf("version %d of secret %s/%s: %w", number, herd, name, ErrNotFound)
+		} else {
+			err = fmt.Errorf("secret %s/%s has no versions left: %w", herd, name, ErrNotFound)
+		}
+		s.fail(w, r, err)
+		return
+	}
+	if _, err := s.store.Txn(r.Context(), conditions, ops...); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// secretKeys returns the key ring, or ErrSecretsDisabled on nodes without
+// a master key
+func (s *Server) secretKeys() (*keyRing, error) {
+	if s.keys == nil {
+		return nil, ErrSecretsDisabled
+	}
+	return s.keys, nil
+}
+
+// readSecretVersions returns the versions of a secret, oldest first
+func (s *Server) readSecretVersions(herd, name string) ([]*SecretVersion, error) {
+	var versions []*SecretVersion
+	for _, kv := range s.store.Range(secretVersionPrefix(herd, name)) {
+		version := &SecretVersion{}
+		if err := json.Unmarshal(kv.Value, version); err != nil {
+			return nil, fmt.Errorf("corrupt secret %s: %w", kv.Key, err)
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// readLiveSecretVersion returns a version of a secret that is not deleted,
+// or the latest such version for 0
+func (s *Server) readLiveSecretVersion(herd, name string, number uint32) (*SecretVersion, error) {
+	versions, err := s.readSecretVersions(herd, name)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		version := versions[i]
+		if number != 0 && version.Version != number {
+			continue
+		}
+		if version.DeletedAt != nil {
+			if number == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("version %d of secret %s/%s is deleted: %w", number, herd, name, ErrNotFound)
+		}
+		return version, nil
+	}
+	if number != 0 {
+		return nil, fmt.Errorf("version %d of secret %s/%s: %w", number, herd, name, ErrNotFound)
+	}
+	return nil, fmt.Errorf("secret %s/%s: %w", herd, name, ErrNotFound)
+}
+
+// queryVersion returns the secret version query parameter, or 0
+func queryVersion(r *http.Request) (uint32, error) {
+	value := r.URL.Query().Get("version")
+	if value == "" {
+		return 0, nil
+	}
+	version, err := strconv.ParseUint(strings.TrimPrefix(value, "v"), 10, 32)
+	if err != nil || version == 0 {
+		return 0, &ValidationError{Field: "version", Message: fmt.Sprintf("%q is not a secret version", value)}
+	}
+	return uint32(version), nil
+}
@@ -0,0 +1,38 @@
+package barnctl
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newSecretsDeleteCommand(client *ClientOptions) *cobra.Command {
+	var herd string
+	var version uint32
+
+	cmd := &cobra.Command{
+		Use:   "secrets-delete NAME",
+		Short: "Delete a secret version, or all of them",
+		Long: `Soft-delete a version of a herd secret, or every version without --version.
+Deleted versions stay in the version history but can no longer be read.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			if err := api.DeleteSecret(ctx, herd, args[0], version); err != nil {
+				return fmt.Errorf("failed to delete secret %s/%s: %w", herd, args[0], err)
+			}
+			if version != 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "Deleted secret %s/%s version %d\n", herd, args[0], version)
+			} else {
+				fmt.Fprintf(cmd.OutOrStdout(), "Deleted secret %s/%s\n", herd, args[0])
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&herd, "herd", "", "Herd of the secret")
+	cmd.Flags().Uint32Var(&version, "version", 0, "Version to delete (default all)")
+	cmd.MarkFlagRequired("herd")
+	return cmd
+}
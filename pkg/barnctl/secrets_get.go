@@ -0,0 +1,102 @@
+package barnctl
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newSecretsGetCommand(client *ClientOptions) *cobra.Command {
+	var herd string
+	var version uint32
+
+	cmd := &cobra.Command{
+		Use:   "secrets-get NAME",
+		Short: "Print the value of a secret version",
+		Long: `Print the decrypted value of a herd secret as it was stored, by default
+its latest version that is not deleted.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			secret, err := api.GetSecret(ctx, herd, args[0], version)
+			if err != nil {
+				return fmt.Errorf("failed to get secret %s/%s: %w", herd, args[0], err)
+			}
+			defer zero(secret.Value)
+			_, err = cmd.OutOrStdout().Write(secret.Value)
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&herd, "herd", "", "Herd of the secret")
+	cmd.Flags().Uint32Var(&version, "version", 0, "Version to print (default the latest)")
+	cmd.MarkFlagRequired("herd")
+	return cmd
+}
+
+func newSecretsListCommand(client *ClientOptions) *cobra.Command {
+	var herd string
+
+	cmd := &cobra.Command{
+		Use:   "secrets-list",
+		Short: "List the secrets of a Herd",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			list, err := api.ListSecrets(ctx, herd)
+			if err != nil {
+				return fmt.Errorf("failed to list the secrets of herd %s: %w", herd, err)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tVERSION\tVERSIONS\tUPDATED")
+			for _, secret := range list.Items {
+				version, updated := "deleted", "-"
+				if secret.Version != 0 {
+					version, updated = fmt.Sprint(secret.Version), secret.UpdatedAt.Format(time.RFC3339)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", secret.Name, version, secret.Versions, updated)
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().StringVar(&herd, "herd", "", "Herd whose secrets to list")
+	cmd.MarkFlagRequired("herd")
+	return cmd
+}
+
+func newSecretsVersionCommand(client *ClientOptions) *cobra.Command {
+	var herd string
+
+	cmd := &cobra.Command{
+		Use:   "secrets-version NAME",
+		Short: "Show the version history of a secret",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			list, err := api.SecretVersions(ctx, herd, args[0])
+			if err != nil {
+				return fmt.Errorf("failed to get the versions of secret %s/%s: %w", herd, args[0], err)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "VERSION\tKEY VERSION\tCREATED\tDELETED")
+			for _, version := range list.Items {
+				deleted := "-"
+				if version.DeletedAt != nil {
+					deleted = version.DeletedAt.Format(time.RFC3339)
+				}
+				fmt.Fprintf(w, "%d\t%d\t%s\t%s\n", version.Version, version.KEKVersion, version.CreatedAt.Format(time.RFC3339), deleted)
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().StringVar(&herd, "herd", "", "Herd of the secret")
+	cmd.MarkFlagRequired("herd")
+	return cmd
+}
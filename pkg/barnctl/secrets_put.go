@@ -0,0 +1,54 @@
+package barnctl
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func newSecretsPutCommand(client *ClientOptions) *cobra.Command {
+	var herd, fromFile string
+
+	cmd := &cobra.Command{
+		Use:   "secrets-put NAME",
+		Short: "Store a new secret version",
+		Long: `Store a new version of a herd secret through the Barn API. Barn encrypts
+it with AES-256-GCM under a data key of its own, wrapped by the herd's
+key-encryption key. The value is read from --from-file, or from stdin, so
+it does not show up in the process list or the shell history.`,
+		Example: `  barnctl secrets-put warehouse_password --herd finance < password.txt
+  barnctl secrets-put tls_key --herd finance --from-file tls.key`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var value []byte
+			var err error
+			if fromFile == "" || fromFile == "-" {
+				value, err = io.ReadAll(io.LimitReader(cmd.InOrStdin(), maxSecretSize+1))
+			} else {
+				value, err = os.ReadFile(fromFile)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read secret value: %w", err)
+			}
+			defer zero(value)
+			if len(value) > maxSecretSize {
+				return fmt.Errorf("secret value exceeds %d bytes", maxSecretSize)
+			}
+
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+			version, err := api.PutSecret(ctx, herd, args[0], value)
+			if err != nil {
+				return fmt.Errorf("failed to store secret %s/%s: %w", herd, args[0], err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Stored secret %s/%s version %d\n", herd, version.Name, version.Version)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&herd, "herd", "", "Herd of the secret")
+	cmd.Flags().StringVar(&fromFile, "from-file", "", "File to read the value from (default stdin)")
+	cmd.MarkFlagRequired("herd")
+	return cmd
+}
@@ -0,0 +1,223 @@
+package barnctl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testMasterKey, _ = NewMasterKey(bytes.Repeat([]byte{7}, keySize))
+
+// TestAPISecrets tests secret versions, soft deletes and the herd's
+// encryption policy
+func TestAPISecrets(t *testing.T) {
+	c, clients := newTestAPI(t, 1)
+	api := clients[c.leader()]
+	ctx := context.Background()
+
+	if _, err := api.CreateHerd(ctx, &Herd{Name: "legacy", Secrets: SecretsScope{Encryption: "DES"}}); err != nil {
+		expectAPIError(t, err, ErrInvalid, "secretsScope.encryption")
+	} else {
+		t.Error("Expected unsupported encryption to be rejected")
+	}
+	if _, err := api.PutSecret(ctx, "finance", "warehouse_password", []byte("x")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected not found for secret of missing herd, got %v", err)
+	}
+	if _, err := api.CreateHerd(ctx, &Herd{Name: "finance", Secrets: SecretsScope{Encryption: EncryptionAES256}}); err != nil {
+		t.Fatalf("Failed to create herd: %v", err)
+	}
+	if _, err := api.PutSecret(ctx, "finance", "bad/name", []byte("x")); err == nil {
+		t.Error("Expected invalid secret name to be rejected")
+	}
+
+	for _, value := range []string{"hunter2", "correct horse"} {
+		if _, err := api.PutSecret(ctx, "finance", "warehouse_password", []byte(value)); err != nil {
+			t.Fatalf("Failed to put secret: %v", err)
+		}
+	}
+	expectSecret := func(version uint32, want string, wantVersion uint32) {
+		t.Helper()
+		secret, err := api.GetSecret(ctx, "finance", "warehouse_password", version)
+		if err != nil {
+			t.Fatalf("Failed to get version %d: %v", version, err)
+		}
+		if string(secret.Value) != want || secret.Version != wantVersion {
+			t.Errorf("Expected version %d %q, got version %d %q", wantVersion, want, secret.Version, secret.Value)
+		}
+	}
+	expectSecret(0, "correct horse", 2)
+	expectSecret(1, "hunter2", 1)
+
+	// Only ciphertexts reach the store, and with it the WAL and snapshots
+	for _, kv := range c.stores[c.leader()].Range("") {
+		if bytes.Contains(kv.Value, []byte("hunter2")) || bytes.Contains(kv.Value, []byte("correct horse")) {
+			t.Errorf("Plaintext secret stored under %s", kv.Key)
+		}
+	}
+
+	// Deleting a version falls back to the previous one and keeps it in
+	// the history
+	if err := api.DeleteSecret(ctx, "finance", "warehouse_password", 2); err != nil {
+		t.Fatalf("Failed to delete version: %v", err)
+	}
+	expectSecret(0, "hunter2", 1)
+	if _, err := api.GetSecret(ctx, "finance", "warehouse_password", 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected deleted version to be unreadable, got %v", err)
+	}
+	versions, err := api.SecretVersions(ctx, "finance", "warehouse_password")
+	if err != nil || len(versions.Items) != 2 || versions.Items[1].DeletedAt == nil {
+		t.Fatalf("Expected two versions with the second deleted, got %+v, %v", versions, err)
+	}
+
+	if err := api.DeleteSecret(ctx, "finance", "warehouse_password", 0); err != nil {
+		t.Fatalf("Failed to delete secret: %v", err)
+	}
+	if _, err := api.GetSecret(ctx, "finance", "warehouse_password", 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected deleted secret to be unreadable, got %v", err)
+	}
+	version, err := api.PutSecret(ctx, "finance", "warehouse_password", []byte("new"))
+	if err != nil || version.Version != 3 {
+		t.Fatalf("Expected version 3 after deletes, got %+v, %v", version, err)
+	}
+	list, err := api.ListSecrets(ctx, "finance")
+	if err != nil || len(list.Items) != 1 || list.Items[0].Version != 3 || list.Items[0].Versions != 3 {
+		t.Errorf("Expected one secret at version 3 of 3, got %+v, %v", list, err)
+	}
+
+	// Deleting the herd takes its secrets and keys with it
+	if err := api.DeleteHerd(ctx, "finance", 0); err != nil {
+		t.Fatalf("Failed to delete herd: %v", err)
+	}
+	for _, prefix := range []string{secretPrefix("finance"), kekPrefix("finance")} {
+		if keys := c.stores[c.leader()].List(prefix); len(keys) > 0 {
+			t.Errorf("Expected no keys under %s, got %v", prefix, keys)
+		}
+	}
+}
+
+// TestSecretsReencrypt tests that rotating a KEK keeps every version
+// readable and destroys the old KEK once nothing uses it
+func TestSecretsReencrypt(t *testing.T) {
+	c, clients := newTestAPI(t, 3)
+	api := clients[c.leader()]
+	ctx := context.Background()
+
+	if _, err := api.CreateHerd(ctx, &Herd{Name: "finance", Secrets: SecretsScope{RotationPolicy: "90d"}}); err != nil {
+		t.Fatalf("Failed to create herd: %v", err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := api.PutSecret(ctx, "finance", name, []byte("value of "+name)); err != nil {
+			t.Fatalf("Failed to put secret: %v", err)
+		}
+	}
+
+	result, err := api.Reencrypt(ctx, "finance", true)
+	if err != nil {
+		t.Fatalf("Failed to reencrypt: %v", err)
+	}
+	if !result.Rotated || result.KEKVersion != 2 || result.Rewrapped != 3 || len(result.Destroyed) != 1 || result.Destroyed[0] != 1 {
+		t.Errorf("Expected rotation to version 2 with 3 rewrapped and version 1 destroyed, got %+v", result)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		secret, err := api.GetSecret(ctx, "finance", name, 0)
+		if err != nil || string(secret.Value) != "value of "+name {
+			t.Errorf("Expected %s to be readable after rotation, got %+v, %v", name, secret, err)
+		}
+	}
+	versions, err := api.SecretVersions(ctx, "finance", "a")
+	if err != nil || versions.Items[0].KEKVersion != 2 {
+		t.Errorf("Expected version wrapped by key 2, got %+v, %v", versions, err)
+	}
+
+	// Nothing is left to do without a rotation
+	if result, err := api.Reencrypt(ctx, "finance", false); err != nil || result.Rewrapped != 0 || len(result.Destroyed) != 0 {
+		t.Errorf("Expected nothing to reencrypt, got %+v, %v", result, err)
+	}
+
+	// The rotation policy is counted from the current KEK
+	keys := &keyRing{store: c.stores[c.leader()], master: testMasterKey}
+	herd := &Herd{Name: "finance", Secrets: SecretsScope{RotationPolicy: "90d"}}
+	if due, err := keys.due(herd, time.Now()); err != nil || due {
+		t.Errorf("Expected new key not to be due, got %v, %v", due, err)
+	}
+	if due, err := keys.due(herd, time.Now().Add(91*24*time.Hour)); err != nil || !due {
+		t.Errorf("Expected key to be due after 91 days, got %v, %v", due, err)
+	}
+}
+
+// TestSecretsKeyBinding tests that ciphertexts only decrypt as the secret
+// version they were written as, and only under the right master key
+func TestSecretsKeyBinding(t *testing.T) {
+	c, clients := newTestAPI(t, 1)
+	api := clients[c.leader()]
+	store := c.stores[c.leader()]
+	ctx := context.Background()
+
+	if _, err := api.CreateHerd(ctx, &Herd{Name: "finance"}); err != nil {
+		t.Fatalf("Failed to create herd: %v", err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, err := api.PutSecret(ctx, "finance", name, []byte(name)); err != nil {
+			t.Fatalf("Failed to put secret: %v", err)
+		}
+	}
+	read := func(name string) *SecretVersion {
+		data, _ := store.Get(secretVersionKey("finance", name, 1))
+		version := &SecretVersion{}
+		if err := json.Unmarshal(data, version); err != nil {
+			t.Fatal(err)
+		}
+		return version
+	}
+
+	keys := &keyRing{store: store, master: testMasterKey}
+	kek, err := keys.get("finance", 1)
+	if err != nil {
+		t.Fatalf("Failed to unwrap KEK: %v", err)
+	}
+	a, b := read("a"), read("b")
+	a.Ciphertext, a.WrappedKey = b.Ciphertext, b.WrappedKey
+	if _, err := decryptSecret(kek, a); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected moved ciphertext to fail decryption, got %v", err)
+	}
+
+	other, _ := NewMasterKey(bytes.Repeat([]byte{8}, keySize))
+	wrong := &keyRing{store: store, master: other}
+	if _, err := wrong.get("finance", 1); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected KEK not to unwrap under another master key, got %v", err)
+	}
+
+	// A node without a master key refuses secrets
+	server := httptest.NewServer(NewServer(ServerConfig{Store: store}).Handler())
+	defer server.Close()
+	_, err = NewClient(server.URL).GetSecret(ctx, "finance", "a", 0)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without a master key, got %v", err)
+	}
+}
+
+func TestParseRotationPolicy(t *testing.T) {
+	for _, test := range []struct {
+		policy string
+		age    time.Duration
+		ok     bool
+	}{
+		{"", 0, true},
+		{"90d", 90 * 24 * time.Hour, true},
+		{"720h", 720 * time.Hour, true},
+		{"0d", 0, false},
+		{"-1h", 0, false},
+		{"monthly", 0, false},
+	} {
+		age, err := ParseRotationPolicy(test.policy)
+		if (err == nil) != test.ok || age != test.age {
+			t.Errorf("ParseRotationPolicy(%q) = %v, %v", test.policy, age, err)
+		}
+	}
+}
@@ -0,0 +1,447 @@
+package barnctl
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/runink/herdctl"
+)
+
+const (
+	// DefaultListenAddr is where Barn serves its API, see
+	// linux/etc/runit/barn/run
+	DefaultListenAddr = ":8080"
+
+	// maxRequestBody caps API request bodies
+	maxRequestBody = 1 << 20
+
+	// shutdownTimeout is how long in-flight requests get on shutdown
+	shutdownTimeout = 10 * time.Second
+)
+
+// ServerConfig configures a Barn API server
+type ServerConfig struct {
+	// Addr is the listen address. Default: DefaultListenAddr
+	Addr string
+
+	// Store holds the API resources
+	Store *Store
+
+	// Peers are the cluster members. Each member serves the API at its
+	// peer address; followers redirect writes to the leader's.
+	Peers []Peer
+
+	// Raft, if set, serves the Raft RPCs of an HTTPTransport under /raft/,
+	// for members on a network that only they reach. barnctl serve serves
+	// them on a listener of their own instead.
+	Raft http.Handler
+
+	// TLSConfig enables HTTPS, for the API and the redirects to the leader
+	TLSConfig *tls.Config
+
+	// AccessLog receives one line per request if set
+	AccessLog io.Writer
+
+	// WatchProgress is how often idle watch streams report the store
+	// revision. Default: DefaultWatchProgress
+	WatchProgress time.Duration
+
+	// MasterKey wraps the herds' key-encryption keys. Without it the
+	// secrets API fails with ErrSecretsDisabled.
+	MasterKey *MasterKey
+
+	// AuditKey signs the audit log checkpoints. Without it the log is
+	// still hash-chained, but not signed.
+	AuditKey *AuditKey
+
+	// RBAC, if set, authenticates API requests and enforces the herds'
+	// policies on them
+	RBAC *RBACConfig
+}
+
+// Server serves the Barn REST API: herds with their quotas, role bindings,
+// policies and labels, Runi slices, the herds' envelope-encrypted secrets,
+// herd snapshots, the audit log and the artifact registry. Reads are served
+// from the local store; a request with ?consistent=true is answered by the
+// leader after it caught up with every committed write. Updates carry the
+// revision they were read at and fail with 409 Conflict if the resource
+// changed since. Changes are streamed to watchers from any member. With
+// RBAC, requests are only served if the herds' policies allow them.
+type Server struct {
+	config ServerConfig
+	store  *Store
+	addrs  map[string]string
+	server *http.Server
+	keys   *keyRing
+
+	// done is closed on shutdown to end the watch streams
+	done     chan struct{}
+	shutdown sync.Once
+}
+
+// NewServer returns a server for the store
+func NewServer(config ServerConfig) *Server {
+	if config.Addr == "" {
+		config.Addr = DefaultListenAddr
+	}
+	if config.WatchProgress == 0 {
+		config.WatchProgress = DefaultWatchProgress
+	}
+	addrs := make(map[string]string, len(config.Peers))
+	for _, peer := range config.Peers {
+		addrs[peer.ID] = peer.Addr
+	}
+	s := &Server{config: config, store: config.Store, addrs: addrs, done: make(chan struct{})}
+	if config.MasterKey != nil {
+		s.keys = &keyRing{store: config.Store, master: config.MasterKey}
+	}
+	s.server = &http.Server{
+		Handler:           s.Handler(),
+		TLSConfig:         config.TLSConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	s.server.RegisterOnShutdown(func() {
+		s.shutdown.Do(func() { close(s.done) })
+	})
+	return s
+}
+
+// Handler returns the API, health check and Raft handlers. The body limit,
+// authentication and audit only apply to the API: snapshots and batches
+// of log entries sent to the Raft handler are larger than any API request.
+func (s *Server) Handler() http.Handler {
+	api := []Middleware{withBodyLimit(maxRequestBody)}
+	if s.config.RBAC != nil {
+		api = append(api, withAuthentication(s.config.RBAC.Authenticators))
+	}
+	api = append(api, withAudit)
+
+	mux := http.NewServeMux()
+	mux.Handle(APIPrefix+"/", chain(s.authorize(s.routes()), api...))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	if s.config.Raft != nil {
+		mux.Handle("/raft/", s.config.Raft)
+	}
+
+	middleware := []Middleware{withRecovery}
+	if s.config.AccessLog != nil {
+		middleware = append(middleware, withAccessLog(s.config.AccessLog))
+	}
+	return chain(mux, middleware...)
+}
+
+// ListenAndServe serves until Shutdown is called
+func (s *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.config.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.config.Addr, err)
+	}
+	return s.Serve(listener)
+}
+
+// Serve serves on listener until Shutdown is called
+func (s *Server) Serve(listener net.Listener) error {
+	var err error
+	if s.config.TLSConfig != nil {
+		err = s.server.ServeTLS(listener, "", "")
+	} else {
+		err = s.server.Serve(listener)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting requests and waits for in-flight ones
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// prepareRead redirects consistent reads to the leader and waits for the
+// leader to apply every committed write. It reports whether the request
+// should be answered from the local store.
+func (s *Server) prepareRead(w http.ResponseWriter, r *http.Request) bool {
+	consistent, _ := strconv.ParseBool(r.URL.Query().Get("consistent"))
+	if !consistent {
+		return true
+	}
+	if s.redirectToLeader(w, r) {
+		return false
+	}
+	if err := s.store.Sync(r.Context()); err != nil {
+		s.fail(w, r, err)
+		return false
+	}
+	return true
+}
+
+// redirectToLeader answers requests that must be served by the leader with
+// a redirect to it, and reports whether it did
+func (s *Server) redirectToLeader(w http.ResponseWriter, r *http.Request) bool {
+	raft := s.store.Raft()
+	if raft.IsLeader() {
+		return false
+	}
+	s.fail(w, r, &NotLeaderError{Leader: raft.Leader()})
+	return true
+}
+
+// fail answers with err. Requests that reached a follower are redirected
+// to the leader with 307, which clients repeat with the same method and
+// body; without a known leader they fail with 503.
+func (s *Server) fail(w http.ResponseWriter, r *http.Request, err error) {
+	var notLeader *NotLeaderError
+	if errors.As(err, &notLeader) {
+		if addr, ok := s.addrs[notLeader.Leader]; ok {
+			scheme := "http"
+			if s.config.TLSConfig != nil {
+				scheme = "https"
+			}
+			http.Redirect(w, r, scheme+"://"+addr+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+			return
+		}
+	}
+	writeError(w, err)
+}
+
+func newServeCommand() *cobra.Command {
+	options := &ServerOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run a Barn node: the Raft-replicated store and its HTTP API",
+		Long: `Run a Barn cluster member. The node persists its Raft log in a WAL under
+--store, keeps snapshots in --snapshot-dir, serves the REST API on --listen
+and the Raft RPCs of the members listed in --raft-peers on --raft-listen,
+over mutual TLS with the members' certificates if --raft-ca is set. Secrets
+are encrypted under the key in --master-key-file, which every member needs;
+without it they are disabled. The leader signs the audit log with the key
+in --audit-key-file and garbage collects the artifact registry every
+--registry-gc-interval. The API is served over HTTPS with --tls-cert and
+--tls-key. With --rbac, API requests need a token signed by a key in
+--token-key-file or a client certificate issued by --client-ca, and the
+herds' policies decide what it allows. Secrets are only served without
+--rbac if --insecure-no-rbac says so.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runServer(cmd.Context(), options)
+		},
+	}
+	options.addFlags(cmd)
+	return cmd
+}
+
+// raftTransport returns the transport of the Raft RPCs, served apart from
+// the API and over mutual TLS if configured
+func raftTransport(options *ServerOptions, peers []Peer) (*HTTPTransport, error) {
+	listen := options.RaftListen
+	if listen == "" {
+		for _, peer := range peers {
+			if peer.ID == options.NodeID {
+				_, port, _ := net.SplitHostPort(peer.RaftAddr)
+				listen = ":" + port
+			}
+		}
+		if listen == "" {
+			return nil, fmt.Errorf("node %s is not in the peers file %s", options.NodeID, options.PeersFile)
+		}
+	}
+	transport := NewHTTPTransport(listen, peers)
+
+	switch {
+	case options.RaftTLSCert == "" && options.RaftTLSKey == "" && options.RaftCA == "":
+		fmt.Fprintf(os.Stderr, "Warning: the Raft RPCs on %s are not authenticated; firewall it to the members or set --raft-ca\n", listen)
+	case options.RaftTLSCert == "" || options.RaftTLSKey == "" || options.RaftCA == "":
+		return nil, errors.New("--raft-tls-cert, --raft-tls-key and --raft-ca go together")
+	default:
+		config, err := PeerTLSConfig(options.RaftTLSCert, options.RaftTLSKey, options.RaftCA, peers)
+		if err != nil {
+			return nil, err
+		}
+		transport.SetTLSConfig(config)
+	}
+	return transport, nil
+}
+
+// apiTLSConfig returns the TLS configuration of the API, or nil to serve
+// it over plain HTTP. With --client-ca, clients may present a certificate
+// issued by that CA, which the ClientCertAuthenticator identifies.
+func apiTLSConfig(options *ServerOptions) (*tls.Config, error) {
+	switch {
+	case options.TLSCert == "" && options.TLSKey == "":
+		if options.ClientCA != "" {
+			return nil, errors.New("--client-ca needs --tls-cert and --tls-key")
+		}
+		return nil, nil
+	case options.TLSCert == "" || options.TLSKey == "":
+		return nil, errors.New("--tls-cert and --tls-key go together")
+	}
+	cert, err := tls.LoadX509KeyPair(options.TLSCert, options.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API certificate: %w", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if options.ClientCA != "" {
+		pem, err := os.ReadFile(options.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in client CA %s", options.ClientCA)
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// runServer runs a Barn node until it receives SIGINT or SIGTERM
+func runServer(ctx context.Context, options *ServerOptions) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	peers, err := LoadPeers(options.PeersFile)
+	if err != nil {
+		return err
+	}
+	policy, err := ParseSyncPolicy(options.WALSync)
+	if err != nil {
+		return err
+	}
+	wal, err := OpenWAL(options.StoreDir, WALOptions{SnapshotDir: options.SnapshotDir, SyncPolicy: policy})
+	if err != nil {
+		return err
+	}
+	defer wal.Close()
+
+	var accessLog io.Writer = os.Stderr
+	if options.LogDir != "" {
+		if err := os.MkdirAll(options.LogDir, 0755); err != nil {
+			return fmt.Errorf("failed to create log directory: %w", err)
+		}
+		file, err := os.OpenFile(filepath.Join(options.LogDir, "access.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open access log: %w", err)
+		}
+		defer file.Close()
+		accessLog = file
+	}
+
+	var masterKey *MasterKey
+	if options.MasterKeyFile != "" {
+		masterKey, err = LoadMasterKey(options.MasterKeyFile)
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Warning: no master key at %s, secrets are disabled\n", options.MasterKeyFile)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	var auditKey *AuditKey
+	if options.AuditKeyFile != "" {
+		auditKey, err = LoadAuditKey(options.AuditKeyFile)
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Warning: no audit key at %s, audit checkpoints are not signed\n", options.AuditKeyFile)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if masterKey != nil && !options.RBAC {
+		if !options.InsecureNoRBAC {
+			return errors.New("refusing to serve secrets without --rbac, anyone who reaches the API could read them; pass --insecure-no-rbac if that is intended")
+		}
+		fmt.Fprintf(os.Stderr, "WARNING: --insecure-no-rbac: the secrets on %s are open to anyone who reaches the API\n", options.Listen)
+	}
+	tlsConfig, err := apiTLSConfig(options)
+	if err != nil {
+		return err
+	}
+	if options.RBAC && len(options.TokenKeyFiles) == 0 && options.ClientCA == "" {
+		return errors.New("--rbac needs a --token-key-file or a --client-ca to authenticate requests with")
+	}
+	if options.RBAC && tlsConfig == nil {
+		fmt.Fprintf(os.Stderr, "Warning: the API on %s is plain HTTP, tokens and secrets cross the network in clear; set --tls-cert\n", options.Listen)
+	}
+	var tokenVerifier *herdctl.TokenVerifier
+	if len(options.TokenKeyFiles) > 0 {
+		if tokenVerifier, err = loadTokenVerifier(options.TokenKeyFiles); err != nil {
+			return err
+		}
+	}
+
+	transport, err := raftTransport(options, peers)
+	if err != nil {
+		return err
+	}
+	store, err := NewStore(RaftConfig{
+		ID:                options.NodeID,
+		Peers:             peers,
+		Storage:           wal,
+		Transport:         transport,
+		SnapshotInterval:  options.CompactionInterval,
+		SnapshotThreshold: options.CompactionThreshold,
+		MaxLogEntries:     options.CompactionQueueSize,
+	})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var rbac *RBACConfig
+	if options.RBAC {
+		rbac = &RBACConfig{Admins: options.Admins}
+		if options.ClientCA != "" {
+			rbac.Authenticators = append(rbac.Authenticators, ClientCertAuthenticator{})
+		}
+		if tokenVerifier != nil {
+			rbac.Authenticators = append(rbac.Authenticators, &TokenAuthenticator{Verifier: tokenVerifier, Store: store, MaxLifetime: options.TokenMaxLifetime})
+		}
+	}
+	server := NewServer(ServerConfig{
+		Addr:      options.Listen,
+		Store:     store,
+		Peers:     peers,
+		AccessLog: accessLog,
+		MasterKey: masterKey,
+		AuditKey:  auditKey,
+		RBAC:      rbac,
+		TLSConfig: tlsConfig,
+	})
+	errc := make(chan error, 1)
+	go func() { errc <- server.ListenAndServe() }()
+	go server.RunReencrypt(ctx, options.ReencryptInterval)
+	go server.RunSnapshotPrune(ctx, options.SnapshotPruneInterval)
+	go server.RunAuditCheckpoints(ctx, options.AuditCheckpointInterval)
+	go server.RunRegistryGC(ctx, options.RegistryGCInterval, options.RegistryGCGrace)
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,696 @@
+package barnctl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// DefaultSnapshotPruneInterval is how often the leader deletes the herd
+// snapshots that are past their herd's retention
+const DefaultSnapshotPruneInterval = time.Hour
+
+// HerdState is what a snapshot captures of a herd: the herd with its
+// quota, role bindings and policies, its slices with the scenarios and
+// contracts they run, and the metadata of its secret versions. Secret
+// values stay in their versions, which are immutable, so a snapshot only
+// records which versions were deleted.
+type HerdState struct {
+	Herd    Herd                `json:"herd"`
+	Slices  []Slice             `json:"slices"`
+	Secrets []SecretVersionInfo `json:"secrets"`
+}
+
+// HerdSnapshot is a point-in-time copy of a herd's state. The state is stored
+// content-addressed under its digest, so snapshots of an unchanged herd
+// share it.
+type HerdSnapshot struct {
+	Herd   string `json:"herd"`
+	Name   string `json:"name"`
+	Digest string `json:"digest"`
+	Size   int    `json:"size"`
+
+	// Reason tells why the snapshot was taken, e.g. "before deploying
+	// slice nightly-ledger"
+	Reason string `json:"reason,omitempty"`
+
+	// StoreRevision is the store revision the state was read at
+	StoreRevision uint64    `json:"storeRevision"`
+	CreatedAt     time.Time `json:"createdAt"`
+	Revision      uint64    `json:"revision,omitempty"`
+
+	// State is only returned by GET /api/v1/herds/{herd}/snapshots/{snapshot}
+	State *HerdState `json:"state,omitempty"`
+}
+
+// HerdSnapshotCreate is the body of POST /api/v1/herds/{herd}/snapshots. An
+// empty name is generated from the store revision.
+type HerdSnapshotCreate struct {
+	Name string `json:"name,omitempty"`
+}
+
+// HerdSnapshotList is the body of GET /api/v1/herds/{herd}/snapshots, oldest
+// first
+type HerdSnapshotList struct {
+	Items    []HerdSnapshot `json:"items"`
+	Revision uint64         `json:"revision"`
+}
+
+// RestoreResult is the body of POST
+// /api/v1/herds/{herd}/snapshots/{snapshot}/restore
+type RestoreResult struct {
+	Herd     string `json:"herd"`
+	Snapshot string `json:"snapshot"`
+	Revision uint64 `json:"revision"`
+
+	// Backup is the snapshot of the herd taken before the restore, empty
+	// if the herd did not exist
+	Backup string `json:"backup,omitempty"`
+
+	Slices        int `json:"slices"`
+	RemovedSlices int `json:"removedSlices"`
+
+	// Secrets counts the secret versions deleted or undeleted. Missing are
+	// the versions in the snapshot that no longer exist, because the herd
+	// was deleted in between.
+	Secrets int      `json:"secrets"`
+	Missing []string `json:"missing,omitempty"`
+}
+
+// captureHerd reads the state of a herd at one store revision
+func (s *Server) captureHerd(name string) (*HerdState, uint64, error) {
+	ranges, revision := s.store.Ranges(herdKey(name), slicePrefix(name), secretPrefix(name))
+	state := &HerdState{Slices: []Slice{}, Secrets: []SecretVersionInfo{}}
+	found := false
+	for _, kv := range ranges[0] {
+		if kv.Key == herdKey(name) {
+			if err := decodeResource(kv, &state.Herd, &state.Herd.Revision); err != nil {
+				return nil, 0, err
+			}
+			found = true
+		}
+	}
+	if !found {
+		return nil, 0, fmt.Errorf("herd %s: %w", name, ErrNotFound)
+	}
+	// Revisions belong to the store, not to the state
+	state.Herd.Revision = 0
+	for _, kv := range ranges[1] {
+		slice := Slice{}
+		if err := decodeResource(kv, &slice, &slice.Revision); err != nil {
+			return nil, 0, err
+		}
+		slice.Revision = 0
+		state.Slices = append(state.Slices, slice)
+	}
+	for _, kv := range ranges[2] {
+		version := SecretVersion{}
+		if err := json.Unmarshal(kv.Value, &version); err != nil {
+			return nil, 0, fmt.Errorf("corrupt secret %s: %w", kv.Key, err)
+		}
+		state.Secrets = append(state.Secrets, version.info(0))
+	}
+	return state, revision, nil
+}
+
+// takeSnapshot stores the current state of a herd as a snapshot. An empty
+// name is generated from kind and the store revision. With dedup, no
+// snapshot is taken if the herd's latest one has the same state, and that
+// one is returned instead.
+func (s *Server) takeSnapshot(ctx context.Context, herd, name, kind, reason string, dedup bool) (*HerdSnapshot, error) {
+	if err := s.store.Sync(ctx); err != nil {
+		return nil, err
+	}
+	state, revision, err := s.captureHerd(herd)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	if dedup {
+		snapshots, err := s.readSnapshots(herd)
+		if err != nil {
+			return nil, err
+		}
+		if n := len(snapshots); n > 0 && snapshots[n-1].Digest == digest {
+			return snapshots[n-1], nil
+		}
+	}
+	if name == "" {
+		name = fmt.Sprintf("%s-%d", kind, revision)
+	}
+	snapshot := &HerdSnapshot{
+		Herd:          herd,
+		Name:          name,
+		Digest:        digest,
+		Size:          len(data),
+		Reason:        reason,
+		StoreRevision: revision,
+		CreatedAt:     time.Now().UTC(),
+	}
+	manifest, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	// Putting the blob again, even if it exists, bumps its revision, so a
+	// concurrent prune that found it unused no longer deletes it
+	key := snapshotKey(herd, name)
+	snapshot.Revision, err = s.store.Txn(ctx, []Condition{IfRevision(key, 0)}, OpPut(blobKey(digest), data), OpPut(key, manifest))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.pruneSnapshots(ctx, herd, state.Herd.Snapshot.RetentionDays, time.Now()); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to prune the snapshots of herd %s: %v\n", herd, err)
+	}
+	return snapshot, nil
+}
+
+// snapshotBeforeDeploy takes the snapshot a herd's policy asks for before
+// slice replaces current, which is nil for a new slice. The deploy fails if
+// the snapshot does, since the policy is a compliance requirement.
+func (s *Server) snapshotBeforeDeploy(ctx context.Context, herd *Herd, slice, current *Slice) error {
+	previous := ""
+	if current != nil {
+		previous = current.Contract
+	}
+	var kind, reason string
+	switch {
+	case herd.Snapshot.OnContractChange && slice.Contract != previous:
+		kind, reason = "contract", fmt.Sprintf("before slice %s changed contract from %q to %q", slice.Name, previous, slice.Contract)
+	case herd.Snapshot.OnDeploy:
+		kind, reason = "deploy", fmt.Sprintf("before deploying slice %s", slice.Name)
+	default:
+		return nil
+	}
+	if _, err := s.takeSnapshot(ctx, herd.Name, "", kind, reason, true); err != nil {
+		return fmt.Errorf("failed to snapshot herd %s before deploying slice %s: %w", herd.Name, slice.Name, err)
+	}
+	return nil
+}
+
+// readSnapshots returns the snapshots of a herd, oldest first
+func (s *Server) readSnapshots(herd string) ([]*HerdSnapshot, error) {
+	var snapshots []*HerdSnapshot
+	for _, kv := range s.store.Range(snapshotPrefix(herd)) {
+		snapshot := &HerdSnapshot{}
+		if err := decodeResource(kv, snapshot, &snapshot.Revision); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool { return snapshots[i].StoreRevision < snapshots[j].StoreRevision })
+	return snapshots, nil
+}
+
+func (s *Server) readSnapshot(herd, name string) (*HerdSnapshot, error) {
+	kv, ok := s.store.Lookup(snapshotKey(herd, name))
+	if !ok {
+		return nil, fmt.Errorf("snapshot %s/%s: %w", herd, name, ErrNotFound)
+	}
+	snapshot := &HerdSnapshot{}
+	return snapshot, decodeResource(kv, snapshot, &snapshot.Revision)
+}
+
+// readState reads the state of a snapshot and checks it against its digest
+func (s *Server) readState(snapshot *HerdSnapshot) (*HerdState, error) {
+	data, ok := s.store.Get(blobKey(snapshot.Digest))
+	if !ok {
+		return nil, fmt.Errorf("snapshot %s/%s: state %s is missing: %w", snapshot.Herd, snapshot.Name, snapshot.Digest, ErrCorrupt)
+	}
+	sum := sha256.Sum256(data)
+	if "sha256:"+hex.EncodeToString(sum[:]) != snapshot.Digest {
+		return nil, fmt.Errorf("snapshot %s/%s: state does not match %s: %w", snapshot.Herd, snapshot.Name, snapshot.Digest, ErrCorrupt)
+	}
+	state := &HerdState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("snapshot %s/%s: %w", snapshot.Herd, snapshot.Name, err)
+	}
+	return state, nil
+}
+
+// removeSnapshot deletes a snapshot, and its state if no other snapshot
+// refers to it. States include the herd's name, so only snapshots of the
+// same herd can share one.
+func (s *Server) removeSnapshot(ctx context.Context, snapshot *HerdSnapshot) error {
+	key := snapshotKey(snapshot.Herd, snapshot.Name)
+	conditions := []Condition{IfRevision(key, snapshot.Revision)}
+	ops := []Op{OpDelete(key)}
+
+	others, err := s.readSnapshots(snapshot.Herd)
+	if err != nil {
+		return err
+	}
+	shared := false
+	for _, other := range others {
+		if other.Name != snapshot.Name && other.Digest == snapshot.Digest {
+			shared = true
+		}
+	}
+	if blob, ok := s.store.Lookup(blobKey(snapshot.Digest)); ok && !shared {
+		conditions = append(conditions, IfRevision(blob.Key, blob.ModRevision))
+		ops = append(ops, OpDelete(blob.Key))
+	}
+	_, err = s.store.Txn(ctx, conditions, ops...)
+	return err
+}
+
+// pruneSnapshots deletes the snapshots of a herd older than retentionDays,
+// always keeping the latest one, and returns their names
+func (s *Server) pruneSnapshots(ctx context.Context, herd string, retentionDays int, now time.Time) ([]string, error) {
+	if retentionDays <= 0 {
+		return nil, nil
+	}
+	snapshots, err := s.readSnapshots(herd)
+	if err != nil || len(snapshots) < 2 {
+		return nil, err
+	}
+	cutoff := now.Add(-time.Duration(retentionDays) * 24 * time.Hour)
+	var pruned []string
+	for _, snapshot := range snapshots[:len(snapshots)-1] {
+		if !snapshot.CreatedAt.Before(cutoff) {
+			continue
+		}
+		if err := s.removeSnapshot(ctx, snapshot); err != nil {
+			if errors.Is(err, ErrConflict) || errors.Is(err, ErrKeyNotFound) {
+				continue
+			}
+			return pruned, err
+		}
+		pruned = append(pruned, snapshot.Name)
+	}
+	return pruned, nil
+}
+
+// RunSnapshotPrune deletes the snapshots past their herd's retention each
+// interval, while the node is the leader, until ctx is done
+func (s *Server) RunSnapshotPrune(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !s.store.Raft().IsLeader() {
+			continue
+		}
+		s.pruneAll(ctx)
+	}
+}
+
+// pruneAll prunes the snapshots of every herd. The snapshots of deleted
+// herds are kept for the retention of the herd's last snapshot.
+func (s *Server) pruneAll(ctx context.Context) {
+	herds := make(map[string]bool)
+	for _, key := range s.store.List(snapshotKeyPrefix) {
+		herd, _, _ := strings.Cut(strings.TrimPrefix(key, snapshotKeyPrefix), "/")
+		herds[herd] = true
+	}
+	for name := range herds {
+		retention := 0
+		if herd, err := s.readHerd(name); err == nil {
+			retention = herd.Snapshot.RetentionDays
+		} else if snapshots, err := s.readSnapshots(name); err == nil && len(snapshots) > 0 {
+			if state, err := s.readState(snapshots[len(snapshots)-1]); err == nil {
+				retention = state.Herd.Snapshot.RetentionDays
+			}
+		}
+		audit := AuditEvent{Actor: "barn", Action: "snapshots.prune", Resource: APIPrefix + herdPath(name) + "/snapshots"}
+		pruned, err := s.pruneSnapshots(WithAuditEvent(ctx, audit), name, retention, time.Now())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to prune the snapshots of herd %s: %v\n", name, err)
+		}
+		if len(pruned) > 0 {
+			fmt.Fprintf(os.Stderr, "Pruned %d snapshots of herd %s\n", len(pruned), name)
+		}
+	}
+}
+
+// restore writes the state of a snapshot back in one transaction: the
+// herd, its slices, and which of its secret versions are deleted. Slices
+// created since are removed, and secret versions written since are
+// deleted.
+func (s *Server) restore(ctx context.Context, snapshot *HerdSnapshot, state *HerdState) (*RestoreResult, error) {
+	herd := snapshot.Herd
+	result := &RestoreResult{Herd: herd, Snapshot: snapshot.Name}
+	ranges, _ := s.store.Ranges(herdKey(herd), slicePrefix(herd), secretPrefix(herd))
+	now := time.Now().UTC()
+	var conditions []Condition
+	var ops []Op
+	put := func(key string, current uint64, v any) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		conditions = append(conditions, IfRevision(key, current))
+		ops = append(ops, OpPut(key, data))
+		return nil
+	}
+
+	current := uint64(0)
+	for _, kv := range ranges[0] {
+		if kv.Key == herdKey(herd) {
+			current = kv.ModRevision
+		}
+	}
+	restored := state.Herd
+	restored.UpdatedAt = now
+	if err := put(herdKey(herd), current, &restored); err != nil {
+		return nil, err
+	}
+
+	slices := make(map[string]uint64, len(ranges[1]))
+	for _, kv := range ranges[1] {
+		slices[kv.Key] = kv.ModRevision
+	}
+	for _, slice := range state.Slices {
+		key := sliceKey(herd, slice.Name)
+		slice.UpdatedAt = now
+		if err := put(key, slices[key], &slice); err != nil {
+			return nil, err
+		}
+		delete(slices, key)
+		result.Slices++
+	}
+	for key, revision := range slices {
+		conditions = append(conditions, IfRevision(key, revision))
+		ops = append(ops, OpDelete(key))
+		result.RemovedSlices++
+	}
+
+	type versionID struct {
+		name    string
+		version uint32
+	}
+	wanted := make(map[versionID]*time.Time, len(state.Secrets))
+	for _, info := range state.Secrets {
+		wanted[versionID{info.Name, info.Version}] = info.DeletedAt
+	}
+	for _, kv := range ranges[2] {
+		version := &SecretVersion{}
+		if err := json.Unmarshal(kv.Value, version); err != nil {
+			return nil, fmt.Errorf("corrupt secret %s: %w", kv.Key, err)
+		}
+		deletedAt, ok := wanted[versionID{version.Name, version.Version}]
+		delete(wanted, versionID{version.Name, version.Version})
+		if !ok {
+			// Written after the snapshot
+			deletedAt = &now
+			if version.DeletedAt != nil {
+				continue
+			}
+		}
+		if (deletedAt == nil) == (version.DeletedAt == nil) {
+			continue
+		}
+		version.DeletedAt = deletedAt
+		if err := put(kv.Key, kv.ModRevision, version); err != nil {
+			return nil, err
+		}
+		result.Secrets++
+	}
+	for id := range wanted {
+		result.Missing = append(result.Missing, fmt.Sprintf("%s v%d", id.name, id.version))
+	}
+	sort.Strings(result.Missing)
+
+	var err error
+	result.Revision, err = s.store.Txn(ctx, conditions, ops...)
+	return result, err
+}
+
+func (s *Server) listSnapshots(w http.ResponseWriter, r *http.Request, params Params) {
+	if !s.prepareRead(w, r) {
+		return
+	}
+	revision := s.store.Revision()
+	snapshots, err := s.readSnapshots(params["herd"])
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	list := HerdSnapshotList{Items: []HerdSnapshot{}, Revision: revision}
+	for _, snapshot := range snapshots {
+		if s.visible(r, params["herd"], "read:snapshots", snapshot.Name) {
+			list.Items = append(list.Items, *snapshot)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) getSnapshot(w http.ResponseWriter, r *http.Request, params Params) {
+	if !s.prepareRead(w, r) {
+		return
+	}
+	snapshot, err := s.readSnapshot(params["herd"], params["snapshot"])
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if snapshot.State, err = s.readState(snapshot); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// createSnapshot snapshots a herd on request
+func (s *Server) createSnapshot(w http.ResponseWriter, r *http.Request, params Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	body := HerdSnapshotCreate{}
+	if err := decodeBody(r, &body); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if body.Name != "" {
+		if err := validateName("name", body.Name); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+	}
+	snapshot, err := s.takeSnapshot(r.Context(), params["herd"], body.Name, "manual", "", false)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	w.Header().Set("Location", APIPrefix+"/herds/"+snapshot.Herd+"/snapshots/"+snapshot.Name)
+	writeJSON(w, http.StatusCreated, snapshot)
+}
+
+func (s *Server) deleteSnapshot(w http.ResponseWriter, r *http.Request, params Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	snapshot, err := s.readSnapshot(params["herd"], params["snapshot"])
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if err := s.removeSnapshot(r.Context(), snapshot); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restoreSnapshot restores a herd to a snapshot, after snapshotting its
+// current state so the restore can be undone
+func (s *Server) restoreSnapshot(w http.ResponseWriter, r *http.Request, params Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	if err := s.store.Sync(r.Context()); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	snapshot, err := s.readSnapshot(params["herd"], params["snapshot"])
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	state, err := s.readState(snapshot)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	backup := ""
+	if _, err := s.readHerd(snapshot.Herd); err == nil {
+		taken, err := s.takeSnapshot(r.Context(), snapshot.Herd, "", "restore", "before restoring "+snapshot.Name, true)
+		if err != nil {
+			s.fail(w, r, fmt.Errorf("failed to snapshot herd %s before the restore: %w", snapshot.Herd, err))
+			return
+		}
+		backup = taken.Name
+	}
+
+	for attempt := 1; ; attempt++ {
+		result, err := s.restore(r.Context(), snapshot, state)
+		if err == nil {
+			result.Backup = backup
+			writeJSON(w, http.StatusOK, result)
+			return
+		}
+		// A slice or secret changed in between
+		if errors.Is(err, ErrConflict) && attempt < maxUpdateAttempts {
+			if err = s.store.Sync(r.Context()); err == nil {
+				continue
+			}
+		}
+		s.fail(w, r, err)
+		return
+	}
+}
+
+func newSnapshotCommand(client *ClientOptions) *cobra.Command {
+	var herd string
+
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Create, list and restore Herd snapshots",
+		Long: `Manage point-in-time snapshots of a herd: its quota, role bindings and
+policies, its slices with their scenarios and contracts, and the metadata
+of its secret versions. Snapshots are stored content-addressed in the
+Barn, so snapshots of an unchanged herd share their state.
+
+Herds can be snapshotted automatically before their slices are deployed
+(--snapshot-on deploy) or change contract (--snapshot-on contract-change),
+and their snapshots are pruned after --snapshot-retention-days, keeping
+the latest one.`,
+	}
+	cmd.PersistentFlags().StringVar(&herd, "herd", "", "Herd of the snapshots")
+	cmd.MarkPersistentFlagRequired("herd")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "create [NAME]",
+		Short: "Snapshot the current state of a Herd",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			name := ""
+			if len(args) > 0 {
+				name = args[0]
+			}
+			snapshot, err := api.CreateSnapshot(ctx, herd, name)
+			if err != nil {
+				return fmt.Errorf("failed to snapshot herd %s: %w", herd, err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Created snapshot %s of herd %s (%s)\n", snapshot.Name, herd, snapshot.Digest)
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the snapshots of a Herd",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			list, err := api.ListSnapshots(ctx, herd)
+			if err != nil {
+				return fmt.Errorf("failed to list the snapshots of herd %s: %w", herd, err)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tDIGEST\tSTORE REVISION\tCREATED\tREASON")
+			for _, snapshot := range list.Items {
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", snapshot.Name, shortDigest(snapshot.Digest), snapshot.StoreRevision,
+					snapshot.CreatedAt.Format(time.RFC3339), orDash(snapshot.Reason))
+			}
+			return w.Flush()
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "get NAME",
+		Short: "Show a snapshot with its state as JSON",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			snapshot, err := api.GetSnapshot(ctx, herd, args[0])
+			if err != nil {
+				return fmt.Errorf("failed to get snapshot %s/%s: %w", herd, args[0], err)
+			}
+			return printJSON(cmd.OutOrStdout(), snapshot)
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "restore NAME",
+		Short: "Restore a Herd to a snapshot",
+		Long: `Restore a herd to a snapshot: the herd and its slices are replaced by
+the snapshot's, slices created since are removed, and the secret versions
+written since are deleted. The herd's state before the restore is
+snapshotted first, so the restore can be undone.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			result, err := api.RestoreSnapshot(ctx, herd, args[0])
+			if err != nil {
+				return fmt.Errorf("failed to restore herd %s to snapshot %s: %w", herd, args[0], err)
+			}
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Restored herd %s to snapshot %s (revision %d)\n", herd, result.Snapshot, result.Revision)
+			if result.Backup != "" {
+				fmt.Fprintf(out, "The previous state is snapshot %s\n", result.Backup)
+			}
+			fmt.Fprintf(out, "%d slices restored, %d removed, %d secret versions changed\n", result.Slices, result.RemovedSlices, result.Secrets)
+			for _, missing := range result.Missing {
+				fmt.Fprintf(os.Stderr, "Warning: secret %s no longer exists\n", missing)
+			}
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "delete NAME",
+		Short: "Delete a snapshot",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			if err := api.DeleteSnapshot(ctx, herd, args[0]); err != nil {
+				return fmt.Errorf("failed to delete snapshot %s/%s: %w", herd, args[0], err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Deleted snapshot %s/%s\n", herd, args[0])
+			return nil
+		},
+	})
+	return cmd
+}
+
+// shortDigest abbreviates a "sha256:<hex>" digest for tables
+func shortDigest(digest string) string {
+	if len(digest) > len("sha256:")+12 {
+		return digest[:len("sha256:")+12]
+	}
+	return digest
+}
@@ -0,0 +1,186 @@
+package barnctl
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runink/runictl"
+)
+
+// TestAPISnapshots tests that a restore brings back a herd's quota, slices
+// and secret versions, and that snapshots share unchanged states
+func TestAPISnapshots(t *testing.T) {
+	c, clients := newTestAPI(t, 1)
+	api := clients[c.leader()]
+	ctx := context.Background()
+
+	if _, err := api.CreateHerd(ctx, &Herd{Name: "finance", Quota: runictl.HerdQuota{CPU: "4"}}); err != nil {
+		t.Fatalf("Failed to create herd: %v", err)
+	}
+	if _, err := api.CreateSlice(ctx, &Slice{Herd: "finance", Name: "ledger", Scenario: "ledger.dsl", Contract: "contracts/ledger.go@v1"}); err != nil {
+		t.Fatalf("Failed to create slice: %v", err)
+	}
+	if _, err := api.PutSecret(ctx, "finance", "warehouse_password", []byte("hunter2")); err != nil {
+		t.Fatalf("Failed to put secret: %v", err)
+	}
+
+	before, err := api.CreateSnapshot(ctx, "finance", "before")
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	if !strings.HasPrefix(before.Digest, "sha256:") || before.StoreRevision == 0 {
+		t.Errorf("Expected a digest and store revision, got %+v", before)
+	}
+	again, err := api.CreateSnapshot(ctx, "finance", "")
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	if again.Digest != before.Digest || again.Name == "" {
+		t.Errorf("Expected a generated name and the same state, got %+v", again)
+	}
+	if _, err := api.CreateSnapshot(ctx, "finance", "before"); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected conflict for a duplicate snapshot name, got %v", err)
+	}
+
+	// Change everything the snapshot captured
+	if _, err := api.SetHerdQuota(ctx, "finance", runictl.HerdQuota{CPU: "8"}, 0); err != nil {
+		t.Fatalf("Failed to set quota: %v", err)
+	}
+	if err := api.DeleteSlice(ctx, "finance", "ledger", 0); err != nil {
+		t.Fatalf("Failed to delete slice: %v", err)
+	}
+	if _, err := api.CreateSlice(ctx, &Slice{Herd: "finance", Name: "audit", Scenario: "audit.dsl"}); err != nil {
+		t.Fatalf("Failed to create slice: %v", err)
+	}
+	if _, err := api.PutSecret(ctx, "finance", "warehouse_password", []byte("correct horse")); err != nil {
+		t.Fatalf("Failed to put secret: %v", err)
+	}
+	if err := api.DeleteSecret(ctx, "finance", "warehouse_password", 1); err != nil {
+		t.Fatalf("Failed to delete secret version: %v", err)
+	}
+
+	result, err := api.RestoreSnapshot(ctx, "finance", "before")
+	if err != nil {
+		t.Fatalf("Failed to restore snapshot: %v", err)
+	}
+	if result.Slices != 1 || result.RemovedSlices != 1 || result.Secrets != 2 || result.Backup == "" {
+		t.Errorf("Unexpected restore result %+v", result)
+	}
+	herd, err := api.GetHerd(ctx, "finance")
+	if err != nil || herd.Quota.CPU != "4" {
+		t.Errorf("Expected the restored quota, got %+v, %v", herd, err)
+	}
+	slices, err := api.ListSlices(ctx, "finance", "")
+	if err != nil || len(slices.Items) != 1 || slices.Items[0].Name != "ledger" || slices.Items[0].Contract != "contracts/ledger.go@v1" {
+		t.Errorf("Expected only the restored slice, got %+v, %v", slices, err)
+	}
+	secret, err := api.GetSecret(ctx, "finance", "warehouse_password", 0)
+	if err != nil || string(secret.Value) != "hunter2" || secret.Version != 1 {
+		t.Errorf("Expected version 1 to be current again, got %+v, %v", secret, err)
+	}
+
+	// The backup undoes the restore
+	if _, err := api.RestoreSnapshot(ctx, "finance", result.Backup); err != nil {
+		t.Fatalf("Failed to restore the backup: %v", err)
+	}
+	if herd, err := api.GetHerd(ctx, "finance"); err != nil || herd.Quota.CPU != "8" {
+		t.Errorf("Expected the quota from before the restore, got %+v, %v", herd, err)
+	}
+
+	// Shared states are deleted with the last snapshot referring to them
+	store := c.stores[c.leader()]
+	if err := api.DeleteSnapshot(ctx, "finance", "before"); err != nil {
+		t.Fatalf("Failed to delete snapshot: %v", err)
+	}
+	if _, ok := store.Get(blobKey(before.Digest)); !ok {
+		t.Error("Expected the state to be kept for the other snapshot")
+	}
+	if err := api.DeleteSnapshot(ctx, "finance", again.Name); err != nil {
+		t.Fatalf("Failed to delete snapshot: %v", err)
+	}
+	if _, ok := store.Get(blobKey(before.Digest)); ok {
+		t.Error("Expected the unused state to be deleted")
+	}
+	if _, err := api.GetSnapshot(ctx, "finance", "before"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected deleted snapshot to be gone, got %v", err)
+	}
+}
+
+// TestSnapshotPolicy tests automatic snapshots on deploys and contract
+// changes, and pruning by retention
+func TestSnapshotPolicy(t *testing.T) {
+	c, clients := newTestAPI(t, 1)
+	api := clients[c.leader()]
+	ctx := context.Background()
+
+	expectSnapshots := func(herd string, want int) []HerdSnapshot {
+		t.Helper()
+		list, err := api.ListSnapshots(ctx, herd)
+		if err != nil {
+			t.Fatalf("Failed to list snapshots: %v", err)
+		}
+		if len(list.Items) != want {
+			t.Fatalf("Expected %d snapshots of %s, got %+v", want, herd, list.Items)
+		}
+		return list.Items
+	}
+
+	if _, err := api.CreateHerd(ctx, &Herd{Name: "deploys", Snapshot: SnapshotPolicy{OnDeploy: true}}); err != nil {
+		t.Fatalf("Failed to create herd: %v", err)
+	}
+	slice, err := api.CreateSlice(ctx, &Slice{Herd: "deploys", Name: "ledger", Scenario: "ledger.dsl"})
+	if err != nil {
+		t.Fatalf("Failed to create slice: %v", err)
+	}
+	snapshots := expectSnapshots("deploys", 1)
+	if !strings.HasPrefix(snapshots[0].Name, "deploy-") {
+		t.Errorf("Expected a deploy snapshot, got %+v", snapshots[0])
+	}
+	got, err := api.GetSnapshot(ctx, "deploys", snapshots[0].Name)
+	if err != nil || got.State == nil || len(got.State.Slices) != 0 {
+		t.Errorf("Expected the state before the deploy, got %+v, %v", got, err)
+	}
+	slice.Node = "node-1"
+	if _, err := api.UpdateSlice(ctx, slice); err != nil {
+		t.Fatalf("Failed to update slice: %v", err)
+	}
+	expectSnapshots("deploys", 2)
+
+	if _, err := api.CreateHerd(ctx, &Herd{Name: "contracts", Snapshot: SnapshotPolicy{OnContractChange: true}}); err != nil {
+		t.Fatalf("Failed to create herd: %v", err)
+	}
+	slice, err = api.CreateSlice(ctx, &Slice{Herd: "contracts", Name: "ledger", Scenario: "ledger.dsl", Contract: "contracts/ledger.go@v1"})
+	if err != nil {
+		t.Fatalf("Failed to create slice: %v", err)
+	}
+	expectSnapshots("contracts", 1)
+	slice.Node = "node-1"
+	if slice, err = api.UpdateSlice(ctx, slice); err != nil {
+		t.Fatalf("Failed to update slice: %v", err)
+	}
+	expectSnapshots("contracts", 1)
+	slice.Contract = "contracts/ledger.go@v2"
+	if _, err = api.UpdateSlice(ctx, slice); err != nil {
+		t.Fatalf("Failed to update slice: %v", err)
+	}
+	if snapshots := expectSnapshots("contracts", 2); !strings.HasPrefix(snapshots[1].Name, "contract-") {
+		t.Errorf("Expected a contract snapshot, got %+v", snapshots[1])
+	}
+
+	// Snapshots past the retention are pruned, except the latest
+	server := NewServer(ServerConfig{Store: c.stores[c.leader()]})
+	pruned, err := server.pruneSnapshots(ctx, "deploys", 30, time.Now().Add(31*24*time.Hour))
+	if err != nil || len(pruned) != 1 {
+		t.Fatalf("Expected one snapshot to be pruned, got %v, %v", pruned, err)
+	}
+	expectSnapshots("deploys", 1)
+
+	if _, err := api.CreateHerd(ctx, &Herd{Name: "negative", Snapshot: SnapshotPolicy{RetentionDays: -1}}); err != nil {
+		expectAPIError(t, err, ErrInvalid, "snapshotPolicy.retentionDays")
+	} else {
+		t.Error("Expected a negative retention to be rejected")
+	}
+}
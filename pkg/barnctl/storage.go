@@ -0,0 +1,222 @@
+package barnctl
+
+import (
+	"fmt"
+	"sync"
+)
+
+// EntryType distinguishes state machine commands from Raft's own entries
+type EntryType uint8
+
+const (
+	// EntryNormal carries a command for the state machine
+	EntryNormal EntryType = iota
+
+	// EntryNoop is appended by a new leader to commit entries of earlier
+	// terms, and by barriers. It is not passed to the state machine.
+	EntryNoop
+)
+
+// Entry is one entry of the replicated log. Indexes start at 1.
+type Entry struct {
+	Index uint64    `json:"index"`
+	Term  uint64    `json:"term"`
+	Type  EntryType `json:"type"`
+	Data  []byte    `json:"data,omitempty"`
+}
+
+// HardState is the Raft state that must be durable before a node answers
+// an RPC: its term and the candidate it voted for in that term
+type HardState struct {
+	Term uint64 `json:"term"`
+	Vote string `json:"vote,omitempty"`
+}
+
+// Snapshot is the state machine's state after applying every entry up to
+// and including Index, which replaces those entries in the log
+type Snapshot struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Data  []byte `json:"data,omitempty"`
+}
+
+// Storage persists a node's Raft log, hard state and latest snapshot.
+// Implementations must be safe for concurrent use and durable when a
+// method returns.
+type Storage interface {
+	// HardState returns the last saved hard state
+	HardState() (HardState, error)
+
+	// SetHardState saves the hard state
+	SetHardState(state HardState) error
+
+	// FirstIndex returns the index of the first entry not covered by the
+	// snapshot
+	FirstIndex() (uint64, error)
+
+	// LastIndex returns the index of the last entry, or of the snapshot if
+	// no entries follow it
+	LastIndex() (uint64, error)
+
+	// Term returns the term of the entry at index. The snapshot's index
+	// has the snapshot's term; earlier indexes fail with ErrCompacted.
+	Term(index uint64) (uint64, error)
+
+	// Entries returns the entries in [lo, hi)
+	Entries(lo, hi uint64) ([]Entry, error)
+
+	// Append adds entries to the log. Existing entries from the index of
+	// the first new entry on are replaced.
+	Append(entries []Entry) error
+
+	// Snapshot returns the latest snapshot, with Index 0 if there is none
+	Snapshot() (Snapshot, error)
+
+	// SaveSnapshot stores a snapshot and drops the entries it covers. The
+	// entries after it are kept if the log holds the snapshot's last entry;
+	// otherwise the whole log is replaced by the snapshot. Snapshots older
+	// than the current one are ignored.
+	SaveSnapshot(snapshot Snapshot) error
+}
+
+// memoryLog is the log suffix after a snapshot, shared by the storages
+type memoryLog struct {
+	snapshot Snapshot
+	entries  []Entry
+}
+
+func (l *memoryLog) lastIndex() uint64 {
+	return l.snapshot.Index + uint64(len(l.entries))
+}
+
+func (l *memoryLog) term(index uint64) (uint64, error) {
+	switch {
+	case index < l.snapshot.Index:
+		return 0, fmt.Errorf("term of index %d: %w", index, ErrCompacted)
+	case index == l.snapshot.Index:
+		return l.snapshot.Term, nil
+	case index > l.lastIndex():
+		return 0, fmt.Errorf("term of index %d: %w", index, ErrUnavailable)
+	}
+	return l.entries[index-l.snapshot.Index-1].Term, nil
+}
+
+func (l *memoryLog) slice(lo, hi uint64) ([]Entry, error) {
+	if lo <= l.snapshot.Index {
+		return nil, fmt.Errorf("entries [%d, %d): %w", lo, hi, ErrCompacted)
+	}
+	if lo > hi || hi > l.lastIndex()+1 {
+		return nil, fmt.Errorf("entries [%d, %d): %w", lo, hi, ErrUnavailable)
+	}
+	offset := l.snapshot.Index + 1
+	return append([]Entry(nil), l.entries[lo-offset:hi-offset]...), nil
+}
+
+// checkAppend verifies that entries continue the log without a gap
+func (l *memoryLog) checkAppend(entries []Entry) error {
+	first := entries[0].Index
+	if first <= l.snapshot.Index {
+		return fmt.Errorf("append at index %d: %w", first, ErrCompacted)
+	}
+	if first > l.lastIndex()+1 {
+		return fmt.Errorf("append at index %d leaves a gap after %d", first, l.lastIndex())
+	}
+	return nil
+}
+
+func (l *memoryLog) append(entries []Entry) {
+	l.entries = append(l.entries[:entries[0].Index-l.snapshot.Index-1], entries...)
+}
+
+// compact replaces the entries covered by snapshot, and reports whether
+// the entries after it were kept
+func (l *memoryLog) compact(snapshot Snapshot) bool {
+	kept := false
+	if term, err := l.term(snapshot.Index); err == nil && term == snapshot.Term {
+		l.entries = append([]Entry(nil), l.entries[snapshot.Index-l.snapshot.Index:]...)
+		kept = true
+	} else {
+		l.entries = nil
+	}
+	l.snapshot = snapshot
+	return kept
+}
+
+// MemoryStorage keeps the Raft log in memory. It is used for tests and
+// for nodes that rebuild their state from the cluster on restart.
+type MemoryStorage struct {
+	mu    sync.RWMutex
+	state HardState
+	log   memoryLog
+}
+
+// NewMemoryStorage returns an empty in-memory Raft log
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+func (s *MemoryStorage) HardState() (HardState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state, nil
+}
+
+func (s *MemoryStorage) SetHardState(state HardState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+	return nil
+}
+
+func (s *MemoryStorage) FirstIndex() (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.log.snapshot.Index + 1, nil
+}
+
+func (s *MemoryStorage) LastIndex() (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.log.lastIndex(), nil
+}
+
+func (s *MemoryStorage) Term(index uint64) (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.log.term(index)
+}
+
+func (s *MemoryStorage) Entries(lo, hi uint64) ([]Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.log.slice(lo, hi)
+}
+
+func (s *MemoryStorage) Append(entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.log.checkAppend(entries); err != nil {
+		return err
+	}
+	s.log.append(entries)
+	return nil
+}
+
+func (s *MemoryStorage) Snapshot() (Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.log.snapshot, nil
+}
+
+func (s *MemoryStorage) SaveSnapshot(snapshot Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if snapshot.Index <= s.log.snapshot.Index {
+		return nil
+	}
+	s.log.compact(snapshot)
+	return nil
+}
@@ -0,0 +1,397 @@
+package barnctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store is Barn's replicated key-value state. Writes go through the Raft
+// log and must be sent to the leader; reads are served from the local
+// copy, which may lag behind the leader on followers.
+//
+// Every write creates a new store revision, the log index of the write.
+// Keys remember the revision that created them and the one that last
+// changed them, so clients can update them with optimistic concurrency.
+type Store struct {
+	raft *Raft
+
+	mu       sync.RWMutex
+	data     map[string]storeRecord
+	revision uint64
+
+	// history holds the latest events for watches, in revision order.
+	// Events up to compacted are gone; changed is closed and replaced
+	// whenever events are added or the store is restored.
+	history      []Event
+	historyLimit int
+	compacted    uint64
+	changed      chan struct{}
+
+	// audit is the head of the audit log, see audit.go
+	audit auditHead
+}
+
+// KeyValue is a key with its value and revisions
+type KeyValue struct {
+	Key            string `json:"key"`
+	Value          []byte `json:"value"`
+	CreateRevision uint64 `json:"createRevision"`
+	ModRevision    uint64 `json:"modRevision"`
+}
+
+// storeRecord is a stored value with its revisions
+type storeRecord struct {
+	Value          []byte `json:"value"`
+	CreateRevision uint64 `json:"createRevision"`
+	ModRevision    uint64 `json:"modRevision"`
+}
+
+// Condition guards a transaction. It holds if Key was last changed at
+// Revision, where 0 means the key must not exist. With Exists, the key only
+// has to exist; with Prefix, no key starting with Key may exist.
+type Condition struct {
+	Key      string `json:"key"`
+	Revision uint64 `json:"revision,omitempty"`
+	Exists   bool   `json:"exists,omitempty"`
+	Prefix   bool   `json:"prefix,omitempty"`
+}
+
+// IfRevision requires key to be at revision, or absent for 0
+func IfRevision(key string, revision uint64) Condition {
+	return Condition{Key: key, Revision: revision}
+}
+
+// IfExists requires key to exist
+func IfExists(key string) Condition {
+	return Condition{Key: key, Exists: true}
+}
+
+// IfNoPrefix requires that no key starts with prefix
+func IfNoPrefix(prefix string) Condition {
+	return Condition{Key: prefix, Prefix: true}
+}
+
+// Op is a write in a transaction
+type Op struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+// OpPut sets key to value
+func OpPut(key string, value []byte) Op {
+	return Op{Op: opPut, Key: key, Value: value}
+}
+
+// OpDelete removes key, failing the transaction if it does not exist
+func OpDelete(key string) Op {
+	return Op{Op: opDelete, Key: key}
+}
+
+// storeCommand is a transaction, as stored in the Raft log. Time is when
+// the leader proposed it, and Audit describes it in the audit log; a
+// command without ops only appends its audit event. A command with a
+// Checkpoint only signs the audit log.
+type storeCommand struct {
+	Conditions []Condition      `json:"if,omitempty"`
+	Ops        []Op             `json:"ops"`
+	Time       time.Time        `json:"time,omitempty"`
+	Audit      *AuditEvent      `json:"audit,omitempty"`
+	Checkpoint *AuditCheckpoint `json:"checkpoint,omitempty"`
+}
+
+// storeSnapshot is the store's state machine snapshot
+type storeSnapshot struct {
+	Revision uint64                 `json:"revision"`
+	Data     map[string]storeRecord `json:"data"`
+}
+
+// NewStore starts a store replicated with the given Raft configuration.
+// The store is the configuration's state machine; config.FSM is ignored.
+func NewStore(config RaftConfig) (*Store, error) {
+	s := &Store{
+		data:         make(map[string]storeRecord),
+		historyLimit: DefaultWatchHistory,
+		changed:      make(chan struct{}),
+	}
+	config.FSM = s
+	raft, err := NewRaft(config)
+	if err != nil {
+		return nil, err
+	}
+	s.raft = raft
+	return s, nil
+}
+
+// Raft returns the store's Raft node
+func (s *Store) Raft() *Raft {
+	return s.raft
+}
+
+// Put sets key to value once a majority of the cluster stored the write
+func (s *Store) Put(ctx context.Context, key string, value []byte) error {
+	_, err := s.Txn(ctx, nil, OpPut(key, value))
+	return err
+}
+
+// Delete removes key, failing with ErrKeyNotFound if it does not exist
+func (s *Store) Delete(ctx context.Context, key string) error {
+	_, err := s.Txn(ctx, nil, OpDelete(key))
+	return err
+}
+
+// Txn applies ops atomically if all conditions hold, and returns the
+// revision of the write. A failed condition returns a ConflictError and
+// applies nothing.
+func (s *Store) Txn(ctx context.Context, conditions []Condition, ops ...Op) (uint64, error) {
+	if len(ops) == 0 {
+		return 0, fmt.Errorf("transaction has no operations")
+	}
+	for _, op := range ops {
+		if op.Key == "" {
+			return 0, fmt.Errorf("key is required")
+		}
+		if strings.HasPrefix(op.Key, auditKeyPrefix) {
+			return 0, fmt.Errorf("%s: %w", op.Key, ErrAuditAppendOnly)
+		}
+	}
+	for _, condition := range conditions {
+		if condition.Prefix && (condition.Revision != 0 || condition.Exists) {
+			return 0, fmt.Errorf("prefix condition on %s can only require absence", condition.Key)
+		}
+	}
+
+	return s.propose(ctx, storeCommand{Conditions: conditions, Ops: ops, Audit: auditEventFrom(ctx)})
+}
+
+// propose replicates a command and returns its revision
+func (s *Store) propose(ctx context.Context, command storeCommand) (uint64, error) {
+	command.Time = time.Now().UTC()
+	data, err := json.Marshal(command)
+	if err != nil {
+		return 0, err
+	}
+	result, err := s.raft.Propose(ctx, data)
+	if err != nil {
+		return 0, err
+	}
+	if err, ok := result.(error); ok {
+		return 0, err
+	}
+	return result.(uint64), nil
+}
+
+// Get returns the local value of key
+func (s *Store) Get(key string) ([]byte, bool) {
+	kv, ok := s.Lookup(key)
+	return kv.Value, ok
+}
+
+// Lookup returns the local value of key with its revisions
+func (s *Store) Lookup(key string) (KeyValue, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	record, ok := s.data[key]
+	if !ok {
+		return KeyValue{}, false
+	}
+	return record.keyValue(key), true
+}
+
+// List returns the local keys with the given prefix, sorted
+func (s *Store) List(prefix string) []string {
+	var keys []string
+	for _, kv := range s.Range(prefix) {
+		keys = append(keys, kv.Key)
+	}
+	return keys
+}
+
+// Range returns the local keys with the given prefix and their values,
+// sorted by key
+func (s *Store) Range(prefix string) []KeyValue {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rangeLocked(prefix)
+}
+
+// Ranges returns a range per prefix, all read at the local revision it
+// also returns, so together they are a consistent view of the store
+func (s *Store) Ranges(prefixes ...string) ([][]KeyValue, uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ranges := make([][]KeyValue, len(prefixes))
+	for i, prefix := range prefixes {
+		ranges[i] = s.rangeLocked(prefix)
+	}
+	return ranges, s.revision
+}
+
+func (s *Store) rangeLocked(prefix string) []KeyValue {
+	var kvs []KeyValue
+	for key, record := range s.data {
+		if strings.HasPrefix(key, prefix) {
+			kvs = append(kvs, record.keyValue(key))
+		}
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	return kvs
+}
+
+// Revision returns the revision of the last write applied locally
+func (s *Store) Revision() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.revision
+}
+
+// Sync waits until every write committed before the call is visible to
+// Get. Call it on the leader before a read that must not be stale.
+func (s *Store) Sync(ctx context.Context) error {
+	return s.raft.Barrier(ctx)
+}
+
+// Close stops the store's Raft node
+func (s *Store) Close() error {
+	return s.raft.Shutdown()
+}
+
+// Snapshot serializes the store. It implements FSM.
+func (s *Store) Snapshot() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return json.Marshal(storeSnapshot{Revision: s.revision, Data: s.data})
+}
+
+// Restore replaces the store with a snapshot. It implements FSM.
+func (s *Store) Restore(data []byte) error {
+	snapshot := storeSnapshot{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("invalid store snapshot: %w", err)
+	}
+	if snapshot.Data == nil {
+		snapshot.Data = make(map[string]storeRecord)
+	}
+	head, err := readAuditHead(snapshot.Data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data, s.revision, s.audit = snapshot.Data, snapshot.Revision, head
+
+	// The events leading up to the snapshot are unknown
+	s.history, s.compacted = nil, snapshot.Revision
+	s.notify()
+	return nil
+}
+
+// Apply applies a committed transaction and returns its revision or the
+// reason it failed. It implements FSM.
+func (s *Store) Apply(entry Entry) any {
+	command := storeCommand{}
+	if err := json.Unmarshal(entry.Data, &command); err != nil {
+		return fmt.Errorf("invalid store command at index %d: %w", entry.Index, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if command.Checkpoint != nil {
+		return s.applyCheckpoint(entry.Index, command.Checkpoint)
+	}
+	for _, condition := range command.Conditions {
+		if err := s.check(condition); err != nil {
+			return err
+		}
+	}
+
+	// Check every operation before applying any of them
+	exists := make(map[string]bool)
+	for _, op := range command.Ops {
+		if _, ok := exists[op.Key]; !ok {
+			_, exists[op.Key] = s.data[op.Key]
+		}
+		if strings.HasPrefix(op.Key, auditKeyPrefix) {
+			return fmt.Errorf("%s: %w", op.Key, ErrAuditAppendOnly)
+		}
+		switch op.Op {
+		case opPut:
+			exists[op.Key] = true
+		case opDelete:
+			if !exists[op.Key] {
+				return fmt.Errorf("%s: %w", op.Key, ErrKeyNotFound)
+			}
+			exists[op.Key] = false
+		default:
+			return fmt.Errorf("unknown store operation %q at index %d", op.Op, entry.Index)
+		}
+	}
+
+	events := make([]Event, 0, len(command.Ops))
+	for _, op := range command.Ops {
+		switch op.Op {
+		case opPut:
+			record := storeRecord{Value: op.Value, CreateRevision: entry.Index, ModRevision: entry.Index}
+			if old, ok := s.data[op.Key]; ok {
+				record.CreateRevision = old.CreateRevision
+			}
+			s.data[op.Key] = record
+			events = append(events, Event{Type: EventPut, Key: op.Key, Value: op.Value, CreateRevision: record.CreateRevision, Revision: entry.Index})
+		case opDelete:
+			delete(s.data, op.Key)
+			events = append(events, Event{Type: EventDelete, Key: op.Key, Revision: entry.Index})
+		}
+	}
+	if len(command.Ops) > 0 || command.Audit != nil {
+		s.appendAudit(entry.Index, &command)
+	}
+	s.revision = entry.Index
+	s.record(events)
+	return entry.Index
+}
+
+// check returns a ConflictError if condition does not hold
+func (s *Store) check(condition Condition) error {
+	if condition.Prefix {
+		for key := range s.data {
+			if strings.HasPrefix(key, condition.Key) {
+				return &ConflictError{Key: key, Reason: "exists"}
+			}
+		}
+		return nil
+	}
+
+	record, ok := s.data[condition.Key]
+	switch {
+	case !ok && (condition.Exists || condition.Revision != 0):
+		return &ConflictError{Key: condition.Key, Reason: "does not exist"}
+	case ok && !condition.Exists && condition.Revision == 0:
+		return &ConflictError{Key: condition.Key, Revision: record.ModRevision, Reason: "already exists"}
+	case ok && !condition.Exists && record.ModRevision != condition.Revision:
+		return &ConflictError{
+			Key:      condition.Key,
+			Revision: record.ModRevision,
+			Reason:   fmt.Sprintf("is at revision %d, not %d", record.ModRevision, condition.Revision),
+		}
+	}
+	return nil
+}
+
+func (r storeRecord) keyValue(key string) KeyValue {
+	return KeyValue{
+		Key:            key,
+		Value:          append([]byte(nil), r.Value...),
+		CreateRevision: r.CreateRevision,
+		ModRevision:    r.ModRevision,
+	}
+}
@@ -0,0 +1,270 @@
+package barnctl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/runink/herdctl"
+)
+
+// TokenRevocation revokes a token before it expires. It is kept until
+// then, as a token past its expiry is rejected anyway.
+type TokenRevocation struct {
+	ID        string    `json:"id"`
+	Subject   string    `json:"subject,omitempty"`
+	Herd      string    `json:"herd,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Reason    string    `json:"reason,omitempty"`
+	RevokedAt time.Time `json:"revokedAt"`
+	Revision  uint64    `json:"revision,omitempty"`
+}
+
+// TokenRevocationList is the body of GET /api/v1/tokens/revocations
+type TokenRevocationList struct {
+	Items    []TokenRevocation `json:"items"`
+	Revision uint64            `json:"revision"`
+}
+
+// TokenAuthenticator authenticates requests by the bearer tokens of
+// herdctl token mint. A token for a herd grants its role in that herd
+// only, within its contract and pipeline scopes; a token without a herd
+// identifies its subject. Tokens must not be revoked, and may not live
+// longer than their herd's token_lifetime_seconds, or MaxLifetime.
+type TokenAuthenticator struct {
+	Verifier *herdctl.TokenVerifier
+	Store    *Store
+
+	// MaxLifetime caps the tokens of herds without a token lifetime, and
+	// those without a herd. Default: herdctl.DefaultTokenLifetime
+	MaxLifetime time.Duration
+}
+
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token := herdctl.BearerToken(r)
+	if token == "" {
+		return nil, nil
+	}
+	claims, err := a.Verifier.Verify(token, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	if _, revoked := a.Store.Lookup(tokenRevocationKey(claims.ID)); revoked {
+		return nil, fmt.Errorf("%w: token %s is revoked", ErrUnauthenticated, claims.ID)
+	}
+	maxLifetime := a.MaxLifetime
+	if maxLifetime <= 0 {
+		maxLifetime = herdctl.DefaultTokenLifetime
+	}
+	if claims.Herd != "" {
+		if kv, ok := a.Store.Lookup(herdKey(claims.Herd)); ok {
+			herd := &Herd{}
+			if err := decodeResource(kv, herd, &herd.Revision); err != nil {
+				return nil, err
+			}
+			if herd.Secrets.TokenLifetimeSeconds > 0 {
+				maxLifetime = time.Duration(herd.Secrets.TokenLifetimeSeconds) * time.Second
+			}
+		}
+	}
+	if claims.Lifetime() > maxLifetime {
+		return nil, fmt.Errorf("%w: token lifetime %s exceeds %s", ErrUnauthenticated, claims.Lifetime(), maxLifetime)
+	}
+
+	principal := &Principal{Name: claims.Subject, Token: claims}
+	if claims.Herd == "" {
+		principal.Subjects = []string{claims.Subject}
+	} else {
+		principal.Roles = []string{claims.Role}
+	}
+	return principal, nil
+}
+
+// listTokenRevocations returns the revocations of tokens not yet expired
+func (s *Server) listTokenRevocations(w http.ResponseWriter, r *http.Request, _ Params) {
+	if !s.prepareRead(w, r) {
+		return
+	}
+	ranges, revision := s.store.Ranges(tokenRevocationKeyPrefix)
+	list := TokenRevocationList{Items: []TokenRevocation{}, Revision: revision}
+	now := time.Now()
+	for _, kv := range ranges[0] {
+		revocation := TokenRevocation{}
+		if err := decodeResource(kv, &revocation, &revocation.Revision); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		if revocation.ExpiresAt.After(now) {
+			list.Items = append(list.Items, revocation)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// revokeToken adds a token to the revocation list, and drops the
+// revocations of expired tokens from it
+func (s *Server) revokeToken(w http.ResponseWriter, r *http.Request, _ Params) {
+	if s.redirectToLeader(w, r) {
+		return
+	}
+	revocation := &TokenRevocation{}
+	if err := decodeBody(r, revocation); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if !tokenIDRE.MatchString(revocation.ID) {
+		s.fail(w, r, &ValidationError{Field: "id", Message: fmt.Sprintf("invalid token ID %q", revocation.ID)})
+		return
+	}
+	if revocation.ExpiresAt.IsZero() {
+		s.fail(w, r, &ValidationError{Field: "expiresAt", Message: "is required"})
+		return
+	}
+	revocation.RevokedAt, revocation.Revision = time.Now().UTC(), 0
+	data, err := json.Marshal(revocation)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		var conditions []Condition
+		ops := []Op{OpPut(tokenRevocationKey(revocation.ID), data)}
+		for _, kv := range s.store.Range(tokenRevocationKeyPrefix) {
+			expired := TokenRevocation{}
+			if json.Unmarshal(kv.Value, &expired) == nil && expired.ExpiresAt.Before(revocation.RevokedAt) && kv.Key != tokenRevocationKey(revocation.ID) {
+				conditions = append(conditions, IfRevision(kv.Key, kv.ModRevision))
+				ops = append(ops, OpDelete(kv.Key))
+			}
+		}
+		revocation.Revision, err = s.store.Txn(r.Context(), conditions, ops...)
+		if err == nil {
+			writeJSON(w, http.StatusCreated, revocation)
+			return
+		}
+		// Another revocation pruned the same ones first
+		if errors.Is(err, ErrConflict) && attempt < maxUpdateAttempts {
+			if err = s.store.Sync(r.Context()); err == nil {
+				continue
+			}
+		}
+		s.fail(w, r, err)
+		return
+	}
+}
+
+// RevokeToken adds a token to the Barn's revocation list
+func (c *Client) RevokeToken(ctx context.Context, revocation *TokenRevocation) (*TokenRevocation, error) {
+	result := &TokenRevocation{}
+	return result, c.do(ctx, http.MethodPost, "/tokens/revocations", nil, revocation, result)
+}
+
+// ListTokenRevocations returns the revoked tokens that are not expired yet
+func (c *Client) ListTokenRevocations(ctx context.Context) (*TokenRevocationList, error) {
+	list := &TokenRevocationList{}
+	return list, c.do(ctx, http.MethodGet, "/tokens/revocations", nil, nil, list)
+}
+
+func newTokenCommand(client *ClientOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "token",
+		Short: "Revoke tokens minted with herdctl token mint",
+		Long: `Tokens are verified by the Barn until they expire. A token that leaked can
+be revoked before then; its revocation is kept until the token expires.`,
+	}
+	cmd.AddCommand(newTokenRevokeCommand(client), newTokenRevocationsCommand(client))
+	return cmd
+}
+
+func newTokenRevokeCommand(client *ClientOptions) *cobra.Command {
+	var tokenFile, reason string
+
+	cmd := &cobra.Command{
+		Use:   "revoke [TOKEN]",
+		Short: "Revoke a token, given as the argument or --file",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var token string
+			switch {
+			case len(args) == 1:
+				token = args[0]
+			case tokenFile != "":
+				var err error
+				if token, err = herdctl.ReadTokenFile(tokenFile); err != nil {
+					return err
+				}
+			default:
+				return errors.New("a TOKEN or --file is required")
+			}
+			// Revoking needs no key: a forged token is rejected anyway
+			claims, err := herdctl.ParseTokenUnverified(token)
+			if err != nil {
+				return err
+			}
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			revocation, err := api.RevokeToken(ctx, &TokenRevocation{
+				ID:        claims.ID,
+				Subject:   claims.Subject,
+				Herd:      claims.Herd,
+				ExpiresAt: claims.Expires(),
+				Reason:    reason,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to revoke token %s: %w", claims.ID, err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Revoked token %s of %s\n", revocation.ID, revocation.Subject)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&tokenFile, "file", "f", "", "File with the token to revoke")
+	cmd.Flags().StringVar(&reason, "reason", "", "Why the token is revoked")
+	return cmd
+}
+
+func newTokenRevocationsCommand(client *ClientOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "revocations",
+		Short: "List the revoked tokens that are not expired yet",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, ctx, cancel := client.client(cmd)
+			defer cancel()
+
+			list, err := api.ListTokenRevocations(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to list token revocations: %w", err)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tSUBJECT\tHERD\tEXPIRES\tREASON")
+			for _, revocation := range list.Items {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", revocation.ID, revocation.Subject, revocation.Herd, revocation.ExpiresAt.Format(time.RFC3339), revocation.Reason)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+// loadTokenVerifier reads the keys of barnctl serve --token-key-file
+func loadTokenVerifier(files []string) (*herdctl.TokenVerifier, error) {
+	keys := make([]*herdctl.TokenKey, 0, len(files))
+	for _, file := range files {
+		key, err := herdctl.LoadTokenKey(file)
+		if err != nil {
+			return nil, err
+		}
+		if key.CanSign() && key.Alg == herdctl.AlgEdDSA {
+			fmt.Fprintf(os.Stderr, "Warning: %s is a private key, the Barn only needs its public key\n", file)
+		}
+		keys = append(keys, key)
+	}
+	return herdctl.NewTokenVerifier(keys...), nil
+}
@@ -0,0 +1,108 @@
+package barnctl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runink/herdctl"
+)
+
+// TestAPITokens tests that tokens are held to their herd, role, scopes and
+// lifetime cap, and are rejected once revoked
+func TestAPITokens(t *testing.T) {
+	key, err := herdctl.GenerateTokenKey(herdctl.AlgEdDSA)
+	if err != nil {
+		t.Fatalf("Failed to generate token key: %v", err)
+	}
+	c := newTestCluster(t, 1)
+	leader := c.leader()
+	store := c.stores[leader]
+	server := httptest.NewServer(NewServer(ServerConfig{
+		Store:     store,
+		MasterKey: testMasterKey,
+		RBAC: &RBACConfig{
+			Authenticators: []Authenticator{&TokenAuthenticator{Verifier: herdctl.NewTokenVerifier(key.Public()), Store: store}},
+			Admins:         []string{"service:barn-admin"},
+		},
+	}).Handler())
+	t.Cleanup(server.Close)
+	client := func(req herdctl.TokenRequest) (*Client, *herdctl.Claims) {
+		token, claims, err := herdctl.MintToken(key, req, time.Now())
+		if err != nil {
+			t.Fatalf("Failed to mint token: %v", err)
+		}
+		api := NewClient(server.URL)
+		api.HTTP = &http.Client{Transport: &herdctl.TokenTransport{Token: token}}
+		return api, claims
+	}
+	admin, _ := client(herdctl.TokenRequest{Subject: "service:barn-admin"})
+	operator, claims := client(herdctl.TokenRequest{Subject: "service:ci-ledger", Herd: "finance", Role: "operator", Pipelines: []string{"ledger-*"}, Lifetime: 5 * time.Minute})
+	ctx := context.Background()
+
+	if _, err := admin.CreateHerd(ctx, &Herd{
+		Name:    "finance",
+		Secrets: SecretsScope{TokenLifetimeSeconds: 600},
+		Policies: []herdctl.Policy{
+			{Role: "operator", Actions: []string{"read:*", "execute:pipelines"}},
+		},
+	}); err != nil {
+		t.Fatalf("Failed to create herd: %v", err)
+	}
+	if _, err := admin.CreateSlice(ctx, &Slice{Herd: "finance", Name: "payroll-monthly", Scenario: "payroll"}); err != nil {
+		t.Fatalf("Failed to create slice: %v", err)
+	}
+
+	// The token holds the operator role within its pipeline scope only
+	if _, err := operator.CreateSlice(ctx, &Slice{Herd: "finance", Name: "ledger-daily", Scenario: "ledger"}); err != nil {
+		t.Fatalf("Expected the token to create a ledger slice, got %v", err)
+	}
+	_, err = operator.GetSlice(ctx, "finance", "payroll-monthly")
+	if !errors.Is(err, ErrForbidden) || !strings.Contains(err.Error(), "scoped to pipelines ledger-*") {
+		t.Errorf("Expected the token not to read a payroll slice, got %v", err)
+	}
+	if _, err := operator.ListSlices(ctx, "finance", ""); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected the token not to list every slice, got %v", err)
+	}
+	if _, err := operator.GetHerd(ctx, "finance"); err != nil {
+		t.Errorf("Expected the token to read its herd, got %v", err)
+	}
+	if _, err := operator.ListTokenRevocations(ctx); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected the revocations to be left to the admins, got %v", err)
+	}
+
+	// The herd caps the lifetime of its tokens
+	long, _ := client(herdctl.TokenRequest{Subject: "service:ci-ledger", Herd: "finance", Role: "operator", Lifetime: 20 * time.Minute, MaxLifetime: time.Hour})
+	if _, err := long.GetHerd(ctx, "finance"); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Expected a token over the herd's lifetime to fail, got %v", err)
+	}
+	other, _ := client(herdctl.TokenRequest{Subject: "service:ci-ledger", Herd: "hr", Role: "operator"})
+	if _, err := other.GetHerd(ctx, "finance"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected a token of another herd to fail, got %v", err)
+	}
+
+	// Revoking the token rejects it, and prunes the expired revocations
+	if _, err := admin.RevokeToken(ctx, &TokenRevocation{ID: strings.Repeat("0", 32), ExpiresAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("Failed to revoke token: %v", err)
+	}
+	if _, err := admin.RevokeToken(ctx, &TokenRevocation{ID: claims.ID, Subject: claims.Subject, ExpiresAt: claims.Expires(), Reason: "leaked"}); err != nil {
+		t.Fatalf("Failed to revoke token: %v", err)
+	}
+	if _, err := operator.GetHerd(ctx, "finance"); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Expected the revoked token to fail, got %v", err)
+	}
+	list, err := admin.ListTokenRevocations(ctx)
+	if err != nil || len(list.Items) != 1 || list.Items[0].ID != claims.ID || list.Items[0].Reason != "leaked" {
+		t.Errorf("Expected the revocation of the token, got %+v, %v", list, err)
+	}
+	if revoked := store.Range(tokenRevocationKeyPrefix); len(revoked) != 1 {
+		t.Errorf("Expected the expired revocation to be pruned, got %d revocations", len(revoked))
+	}
+	if _, err := admin.RevokeToken(ctx, &TokenRevocation{ID: "../herds/finance", ExpiresAt: time.Now()}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Expected an invalid token ID to fail, got %v", err)
+	}
+}
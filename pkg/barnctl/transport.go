@@ -0,0 +1,471 @@
+package barnctl
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// VoteRequest asks a peer for its vote. With PreVote set it only asks
+// whether the peer would vote, and Term is the term the candidate would
+// campaign in.
+type VoteRequest struct {
+	Term         uint64 `json:"term"`
+	CandidateID  string `json:"candidateId"`
+	LastLogIndex uint64 `json:"lastLogIndex"`
+	LastLogTerm  uint64 `json:"lastLogTerm"`
+	PreVote      bool   `json:"preVote,omitempty"`
+}
+
+// VoteResponse answers a VoteRequest
+type VoteResponse struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+// AppendEntriesRequest replicates entries, or is a heartbeat when empty
+type AppendEntriesRequest struct {
+	Term         uint64  `json:"term"`
+	LeaderID     string  `json:"leaderId"`
+	PrevLogIndex uint64  `json:"prevLogIndex"`
+	PrevLogTerm  uint64  `json:"prevLogTerm"`
+	Entries      []Entry `json:"entries,omitempty"`
+	LeaderCommit uint64  `json:"leaderCommit"`
+}
+
+// AppendEntriesResponse answers an AppendEntriesRequest. On a log mismatch
+// ConflictTerm is the term of the follower's entry at PrevLogIndex, or 0
+// if it has none, and ConflictIndex the first index to retry from.
+type AppendEntriesResponse struct {
+	Term          uint64 `json:"term"`
+	Success       bool   `json:"success"`
+	ConflictTerm  uint64 `json:"conflictTerm,omitempty"`
+	ConflictIndex uint64 `json:"conflictIndex,omitempty"`
+}
+
+// InstallSnapshotRequest sends the leader's snapshot to a follower that
+// needs entries the leader has compacted
+type InstallSnapshotRequest struct {
+	Term              uint64 `json:"term"`
+	LeaderID          string `json:"leaderId"`
+	LastIncludedIndex uint64 `json:"lastIncludedIndex"`
+	LastIncludedTerm  uint64 `json:"lastIncludedTerm"`
+	Data              []byte `json:"data"`
+}
+
+// InstallSnapshotResponse answers an InstallSnapshotRequest
+type InstallSnapshotResponse struct {
+	Term uint64 `json:"term"`
+}
+
+// RPCHandler serves the Raft RPCs a transport receives
+type RPCHandler interface {
+	HandleRequestVote(req *VoteRequest) (*VoteResponse, error)
+	HandleAppendEntries(req *AppendEntriesRequest) (*AppendEntriesResponse, error)
+	HandleInstallSnapshot(req *InstallSnapshotRequest) (*InstallSnapshotResponse, error)
+}
+
+// Transport carries Raft RPCs between cluster members, addressed by peer ID
+type Transport interface {
+	RequestVote(ctx context.Context, target string, req *VoteRequest) (*VoteResponse, error)
+	AppendEntries(ctx context.Context, target string, req *AppendEntriesRequest) (*AppendEntriesResponse, error)
+	InstallSnapshot(ctx context.Context, target string, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error)
+
+	// Serve starts delivering incoming RPCs to handler
+	Serve(handler RPCHandler) error
+
+	// Close stops serving RPCs
+	Close() error
+}
+
+// InmemNetwork connects in-memory transports within one process. It can
+// partition its members to test elections and failover.
+type InmemNetwork struct {
+	mu       sync.RWMutex
+	handlers map[string]RPCHandler
+	groups   map[string]int
+}
+
+// NewInmemNetwork returns a network in which all members can reach each other
+func NewInmemNetwork() *InmemNetwork {
+	return &InmemNetwork{
+		handlers: make(map[string]RPCHandler),
+		groups:   make(map[string]int),
+	}
+}
+
+// Transport returns the transport of member id
+func (n *InmemNetwork) Transport(id string) Transport {
+	return &inmemTransport{network: n, id: id}
+}
+
+// Partition splits the network: members can only reach members of their
+// own group. Members not listed in any group form one more group.
+func (n *InmemNetwork) Partition(groups ...[]string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.groups = make(map[string]int)
+	for i, group := range groups {
+		for _, id := range group {
+			n.groups[id] = i + 1
+		}
+	}
+}
+
+// Heal removes all partitions
+func (n *InmemNetwork) Heal() {
+	n.Partition()
+}
+
+// route returns the handler of to if from can reach it
+func (n *InmemNetwork) route(from, to string) (RPCHandler, error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	handler, ok := n.handlers[to]
+	if !ok || n.groups[from] != n.groups[to] {
+		return nil, fmt.Errorf("%s -> %s: %w", from, to, ErrUnreachable)
+	}
+	return handler, nil
+}
+
+type inmemTransport struct {
+	network *InmemNetwork
+	id      string
+}
+
+func (t *inmemTransport) RequestVote(ctx context.Context, target string, req *VoteRequest) (*VoteResponse, error) {
+	resp, err := t.call(ctx, target, func(handler RPCHandler) (any, error) {
+		return handler.HandleRequestVote(req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*VoteResponse), nil
+}
+
+func (t *inmemTransport) AppendEntries(ctx context.Context, target string, req *AppendEntriesRequest) (*AppendEntriesResponse, error) {
+	resp, err := t.call(ctx, target, func(handler RPCHandler) (any, error) {
+		return handler.HandleAppendEntries(req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*AppendEntriesResponse), nil
+}
+
+func (t *inmemTransport) InstallSnapshot(ctx context.Context, target string, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error) {
+	resp, err := t.call(ctx, target, func(handler RPCHandler) (any, error) {
+		return handler.HandleInstallSnapshot(req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*InstallSnapshotResponse), nil
+}
+
+// call runs the RPC unless a partition separates the members before the
+// request is delivered or before the response returns
+func (t *inmemTransport) call(ctx context.Context, target string, rpc func(RPCHandler) (any, error)) (any, error) {
+	handler, err := t.network.route(t.id, target)
+	if err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		resp any
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := rpc(handler)
+		done <- result{resp, err}
+	}()
+
+	var res result
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if res.err != nil {
+		return nil, res.err
+	}
+	if _, err := t.network.route(target, t.id); err != nil {
+		return nil, err
+	}
+	return res.resp, nil
+}
+
+func (t *inmemTransport) Serve(handler RPCHandler) error {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+	if _, ok := t.network.handlers[t.id]; ok {
+		return fmt.Errorf("member %s is already serving", t.id)
+	}
+	t.network.handlers[t.id] = handler
+	return nil
+}
+
+func (t *inmemTransport) Close() error {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+	delete(t.network.handlers, t.id)
+	return nil
+}
+
+// Paths of the Raft RPCs served by HTTPTransport
+const (
+	raftVotePath     = "/raft/v1/vote"
+	raftAppendPath   = "/raft/v1/append"
+	raftSnapshotPath = "/raft/v1/snapshot"
+)
+
+// HTTPTransport carries Raft RPCs as JSON over HTTP, or HTTPS when
+// TLSConfig is set. It listens on Listen if set; otherwise it is mounted
+// as an http.Handler on another server. The RPCs can replace the whole
+// store, so they are only to be served to the members: on a listener of
+// their own that only the members reach, and with a TLSConfig from
+// PeerTLSConfig that requires their certificates.
+type HTTPTransport struct {
+	Listen    string
+	TLSConfig *tls.Config
+	Client    *http.Client
+
+	addrs map[string]string
+
+	mu      sync.RWMutex
+	handler RPCHandler
+	server  *http.Server
+}
+
+// NewHTTPTransport returns a transport that reaches peers at their
+// RaftAddr, or their Addr if they have none
+func NewHTTPTransport(listen string, peers []Peer) *HTTPTransport {
+	addrs := make(map[string]string, len(peers))
+	for _, peer := range peers {
+		addrs[peer.ID] = peer.Addr
+		if peer.RaftAddr != "" {
+			addrs[peer.ID] = peer.RaftAddr
+		}
+	}
+	return &HTTPTransport{Listen: listen, Client: http.DefaultClient, addrs: addrs}
+}
+
+// SetTLSConfig makes the transport serve and send its RPCs over TLS
+func (t *HTTPTransport) SetTLSConfig(config *tls.Config) {
+	t.TLSConfig = config
+	t.Client = &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+}
+
+// PeerTLSConfig returns a mutual TLS configuration for the Raft RPCs of
+// peers. Both sides present the certificate in certFile and keyFile, and
+// accept only certificates issued by the CA in caFile whose common name
+// or a DNS name is the ID of a peer.
+func PeerTLSConfig(certFile, keyFile, caFile string, peers []Peer) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load raft certificate: %w", err)
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read raft CA: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in raft CA %s", caFile)
+	}
+	members := make(map[string]bool, len(peers))
+	for _, peer := range peers {
+		members[peer.ID] = true
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAnyClientCert,
+		MinVersion:   tls.VersionTLS12,
+		// The peers are identified by their ID rather than their address,
+		// so both sides verify the other's certificate themselves
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			return verifyPeerCertificate(state.PeerCertificates, roots, members)
+		},
+	}, nil
+}
+
+// verifyPeerCertificate checks that certs chain up to roots and name one
+// of members
+func verifyPeerCertificate(certs []*x509.Certificate, roots *x509.CertPool, members map[string]bool) error {
+	if len(certs) == 0 {
+		return errors.New("raft peer presented no certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   time.Now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("raft peer certificate: %w", err)
+	}
+	for _, name := range append([]string{certs[0].Subject.CommonName}, certs[0].DNSNames...) {
+		if members[name] {
+			return nil
+		}
+	}
+	return fmt.Errorf("raft peer certificate %q names no member of the cluster", certs[0].Subject.CommonName)
+}
+
+func (t *HTTPTransport) RequestVote(ctx context.Context, target string, req *VoteRequest) (*VoteResponse, error) {
+	resp := &VoteResponse{}
+	if err := t.post(ctx, target, raftVotePath, req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t *HTTPTransport) AppendEntries(ctx context.Context, target string, req *AppendEntriesRequest) (*AppendEntriesResponse, error) {
+	resp := &AppendEntriesResponse{}
+	if err := t.post(ctx, target, raftAppendPath, req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t *HTTPTransport) InstallSnapshot(ctx context.Context, target string, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error) {
+	resp := &InstallSnapshotResponse{}
+	if err := t.post(ctx, target, raftSnapshotPath, req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t *HTTPTransport) post(ctx context.Context, target, path string, req, resp any) error {
+	addr, ok := t.addrs[target]
+	if !ok {
+		return fmt.Errorf("unknown peer %s: %w", target, ErrUnreachable)
+	}
+	scheme := "http"
+	if t.TLSConfig != nil {
+		scheme = "https"
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, scheme+"://"+addr+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := t.Client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("%s: %v: %w", target, err, ErrUnreachable)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(httpResp.Body, 1024))
+		return fmt.Errorf("%s: %s: %s", target, httpResp.Status, bytes.TrimSpace(message))
+	}
+	if err := json.NewDecoder(httpResp.Body).Decode(resp); err != nil {
+		return fmt.Errorf("%s: invalid response: %w", target, err)
+	}
+	return nil
+}
+
+// ServeHTTP answers Raft RPCs
+func (t *HTTPTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.mu.RLock()
+	handler := t.handler
+	t.mu.RUnlock()
+	if handler == nil {
+		http.Error(w, "raft node is not running", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var resp any
+	var err error
+	switch r.URL.Path {
+	case raftVotePath:
+		req := &VoteRequest{}
+		if err = json.NewDecoder(r.Body).Decode(req); err == nil {
+			resp, err = handler.HandleRequestVote(req)
+		}
+	case raftAppendPath:
+		req := &AppendEntriesRequest{}
+		if err = json.NewDecoder(r.Body).Decode(req); err == nil {
+			resp, err = handler.HandleAppendEntries(req)
+		}
+	case raftSnapshotPath:
+		req := &InstallSnapshotRequest{}
+		if err = json.NewDecoder(r.Body).Decode(req); err == nil {
+			resp, err = handler.HandleInstallSnapshot(req)
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (t *HTTPTransport) Serve(handler RPCHandler) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.handler = handler
+	if t.Listen == "" {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", t.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", t.Listen, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/raft/", t)
+	t.server = &http.Server{Handler: mux, TLSConfig: t.TLSConfig}
+	go func(server *http.Server) {
+		if t.TLSConfig != nil {
+			server.ServeTLS(listener, "", "")
+		} else {
+			server.Serve(listener)
+		}
+	}(t.server)
+	return nil
+}
+
+func (t *HTTPTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.handler = nil
+	if t.server == nil {
+		return nil
+	}
+	err := t.server.Close()
+	t.server = nil
+	return err
+}
@@ -0,0 +1,36 @@
+package barnctl
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+)
+
+// printJSON writes v as indented JSON
+func printJSON(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// formatLabels renders labels as a sorted "key=value,..." selector, or "-"
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return "-"
+	}
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// orDash renders empty table cells as "-"
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
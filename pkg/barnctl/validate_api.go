@@ -0,0 +1,215 @@
+package barnctl
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/runink/runictl"
+)
+
+var (
+	// nameRE matches herd, slice and role names: DNS labels, so names can
+	// be used in cgroup paths, hostnames and store keys
+	nameRE = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+	// labelKeyRE matches label keys with an optional DNS prefix, as in
+	// "runink.io/tier"
+	labelKeyRE   = regexp.MustCompile(`^([a-z0-9]([-a-z0-9.]{0,251}[a-z0-9])?/)?[A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?$`)
+	labelValueRE = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?)?$`)
+
+	envNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// secretNameRE matches secret names such as "warehouse_password",
+	// which end up in store keys and secret:// references
+	secretNameRE = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]{0,251}[A-Za-z0-9])?$`)
+
+	// refNameRE matches registry tags, release versions and run IDs, such
+	// as "v1.4.0" or "20261018-ledger_7"
+	refNameRE = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]{0,126}[A-Za-z0-9])?$`)
+
+	// tokenIDRE matches the jti of the tokens herdctl mints
+	tokenIDRE = regexp.MustCompile(`^[0-9a-f]{32}$`)
+)
+
+// validateHerd checks a herd received by the API
+func validateHerd(herd *Herd) error {
+	if err := validateName("name", herd.Name); err != nil {
+		return err
+	}
+	if err := validateLabels(herd.Labels); err != nil {
+		return err
+	}
+	if err := herd.Quota.Validate(); err != nil {
+		return &ValidationError{Field: "quota", Message: err.Error()}
+	}
+
+	if err := validateSecretsScope(&herd.Secrets); err != nil {
+		return err
+	}
+
+	if herd.Snapshot.RetentionDays < 0 {
+		return &ValidationError{Field: "snapshotPolicy.retentionDays", Message: "must not be negative"}
+	}
+
+	roles := make(map[string]bool)
+	for i, binding := range herd.RBAC {
+		field := fmt.Sprintf("rbac[%d]", i)
+		if err := validateName(field+".role", binding.Role); err != nil {
+			return err
+		}
+		if roles[binding.Role] {
+			return &ValidationError{Field: field + ".role", Message: fmt.Sprintf("role %s is bound twice", binding.Role)}
+		}
+		roles[binding.Role] = true
+		if len(binding.Subjects) == 0 {
+			return &ValidationError{Field: field + ".subjects", Message: "at least one subject is required"}
+		}
+		for _, subject := range binding.Subjects {
+			kind, name, ok := strings.Cut(subject, ":")
+			if !ok || name == "" || strings.ContainsAny(name, " \t\n") ||
+				(kind != "user" && kind != "group" && kind != "service") {
+				return &ValidationError{Field: field + ".subjects", Message: fmt.Sprintf("%q is not user:, group: or service:<name>", subject)}
+			}
+		}
+	}
+
+	for i, policy := range herd.Policies {
+		field := fmt.Sprintf("policies[%d]", i)
+		if err := validateName(field+".role", policy.Role); err != nil {
+			return err
+		}
+		if err := policy.Validate(); err != nil {
+			return &ValidationError{Field: field, Message: err.Error()}
+		}
+	}
+	return nil
+}
+
+// validateSecretsScope checks a herd's secrets policy. Secrets are only
+// encrypted with AES-256-GCM, so no other encryption can be required.
+func validateSecretsScope(scope *SecretsScope) error {
+	if scope.Encryption != "" && scope.Encryption != EncryptionAES256 {
+		return &ValidationError{Field: "secretsScope.encryption", Message: fmt.Sprintf("%q is not supported, only %s", scope.Encryption, EncryptionAES256)}
+	}
+	if _, err := ParseRotationPolicy(scope.RotationPolicy); err != nil {
+		return &ValidationError{Field: "secretsScope.rotationPolicy", Message: err.Error()}
+	}
+	if scope.TokenLifetimeSeconds < 0 {
+		return &ValidationError{Field: "secretsScope.tokenLifetimeSeconds", Message: "must not be negative"}
+	}
+	return nil
+}
+
+// validateSecretName checks the name of a secret in a request path
+func validateSecretName(name string) error {
+	if !secretNameRE.MatchString(name) {
+		return &ValidationError{Field: "name", Message: fmt.Sprintf("%q is not a secret name", name)}
+	}
+	return nil
+}
+
+// validateSlice checks a slice received by the API
+func validateSlice(slice *Slice) error {
+	if err := validateName("name", slice.Name); err != nil {
+		return err
+	}
+	if strings.TrimSpace(slice.Scenario) == "" {
+		return &ValidationError{Field: "scenario", Message: "is required"}
+	}
+	if strings.ContainsAny(slice.Contract, " \t\n") {
+		return &ValidationError{Field: "contract", Message: fmt.Sprintf("%q is not a contract reference", slice.Contract)}
+	}
+	if slice.Node != "" && strings.ContainsAny(slice.Node, " \t\n/") {
+		return &ValidationError{Field: "node", Message: fmt.Sprintf("%q is not a node name", slice.Node)}
+	}
+	if err := slice.Resources.Validate(); err != nil {
+		return &ValidationError{Field: "resources", Message: err.Error()}
+	}
+	for name := range slice.Env {
+		if !envNameRE.MatchString(name) {
+			return &ValidationError{Field: "env", Message: fmt.Sprintf("%q is not an environment variable name", name)}
+		}
+	}
+	return validateLabels(slice.Labels)
+}
+
+// validateSliceFits checks that the slice's resources stay within the
+// limits of its herd's quota
+func validateSliceFits(slice *Slice, herd *Herd) error {
+	limits := []struct {
+		field        string
+		slice, quota string
+		parse        func(string) (int64, error)
+	}{
+		{"resources.cpu", slice.Resources.CPU, herd.Quota.CPU, runictl.ParseCPUQuantity},
+		{"resources.memory", slice.Resources.Memory, herd.Quota.Memory, runictl.ParseByteQuantity},
+		{"resources.pids", slice.Resources.Pids, herd.Quota.Pids, func(s string) (int64, error) { return strconv.ParseInt(s, 10, 64) }},
+	}
+	for _, limit := range limits {
+		if limit.quota == "" || limit.quota == "max" {
+			continue
+		}
+		quota, err := limit.parse(limit.quota)
+		if err != nil {
+			continue
+		}
+		requested, err := limit.parse(limit.slice)
+		if limit.slice == "max" || (err == nil && requested > quota) {
+			return &ValidationError{
+				Field:   limit.field,
+				Message: fmt.Sprintf("%s exceeds the quota of herd %s (%s)", limit.slice, herd.Name, limit.quota),
+			}
+		}
+	}
+	return nil
+}
+
+func validateName(field, name string) error {
+	if name == "" {
+		return &ValidationError{Field: field, Message: "is required"}
+	}
+	if !nameRE.MatchString(name) {
+		return &ValidationError{Field: field, Message: fmt.Sprintf("%q must be a lowercase DNS label", name)}
+	}
+	return nil
+}
+
+func validateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if !labelKeyRE.MatchString(key) {
+			return &ValidationError{Field: "labels", Message: fmt.Sprintf("invalid key %q", key)}
+		}
+		if !labelValueRE.MatchString(value) {
+			return &ValidationError{Field: "labels", Message: fmt.Sprintf("invalid value %q for %s", value, key)}
+		}
+	}
+	return nil
+}
+
+// parseSelector parses a label selector of comma-separated key=value pairs
+func parseSelector(selector string) (map[string]string, error) {
+	labels := make(map[string]string)
+	if selector == "" {
+		return labels, nil
+	}
+	for _, term := range strings.Split(selector, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(term), "=")
+		if !ok || !labelKeyRE.MatchString(key) {
+			return nil, &ValidationError{Field: "selector", Message: fmt.Sprintf("%q is not key=value", term)}
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// matchLabels reports whether labels has every label of selector
+func matchLabels(labels, selector map[string]string) bool {
+	for key, value := range selector {
+		if got, ok := labels[key]; !ok || got != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,678 @@
+package barnctl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultStoreDir holds the Barn WAL, see linux/etc/runit/barn/run
+	DefaultStoreDir = "/var/barn/active"
+
+	// DefaultSnapshotDir holds the Barn state machine snapshots
+	DefaultSnapshotDir = "/var/barn/backups"
+
+	// DefaultSegmentSize starts a new WAL segment after 64 MiB
+	DefaultSegmentSize = 64 << 20
+
+	// DefaultSyncInterval is how often SyncInterval flushes the WAL
+	DefaultSyncInterval = 100 * time.Millisecond
+
+	// maxWALRecord rejects record lengths that can only come from a torn
+	// or corrupt header
+	maxWALRecord = 256 << 20
+)
+
+// SyncPolicy decides when WAL writes are flushed to disk
+type SyncPolicy int
+
+const (
+	// SyncAlways flushes before every write returns. Raft's guarantees
+	// only hold with this policy.
+	SyncAlways SyncPolicy = iota
+
+	// SyncInterval flushes every SyncInterval. A power failure loses the
+	// writes of the last interval.
+	SyncInterval
+
+	// SyncNever leaves flushing to the operating system
+	SyncNever
+)
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncAlways:
+		return "always"
+	case SyncInterval:
+		return "interval"
+	case SyncNever:
+		return "never"
+	default:
+		return fmt.Sprintf("SyncPolicy(%d)", int(p))
+	}
+}
+
+// ParseSyncPolicy parses "always", "interval" or "never"
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	for _, policy := range []SyncPolicy{SyncAlways, SyncInterval, SyncNever} {
+		if policy.String() == s {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown sync policy %q: expected always, interval or never", s)
+}
+
+// WALOptions configures a WAL
+type WALOptions struct {
+	// SnapshotDir holds the snapshots. Default: <dir>/snap
+	SnapshotDir string
+
+	// SegmentSize starts a new segment once the current one is larger.
+	// Default: DefaultSegmentSize
+	SegmentSize int64
+
+	// SyncPolicy and SyncInterval decide when writes reach the disk.
+	// Default: SyncAlways, and DefaultSyncInterval for SyncInterval
+	SyncPolicy   SyncPolicy
+	SyncInterval time.Duration
+}
+
+// WAL record types
+const (
+	walHardState byte = iota + 1
+	walEntry
+	walSnapshot
+)
+
+const (
+	walSegmentExt  = ".wal"
+	snapshotExt    = ".snap"
+	snapshotMagic  = "BARNSNAP"
+	walHeaderSize  = 8
+	walEntryHeader = 17
+)
+
+var walCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// WAL is a Storage that writes the Raft log and hard state to checksummed,
+// segmented log files, and snapshots to separate files. The log is also
+// kept in memory from the latest snapshot on.
+//
+// Each record is a little-endian uint32 length and CRC-32C, then a type
+// byte and the payload. Every segment starts with the hard state and the
+// snapshot position, so segments before the latest snapshot can be
+// deleted. A record cut short at the end of the last segment is a torn
+// write from a crash and is truncated on open; any other checksum failure
+// is reported as ErrCorrupt.
+type WAL struct {
+	dir     string
+	snapDir string
+	options WALOptions
+
+	mu       sync.Mutex
+	state    HardState
+	log      memoryLog
+	segments []walSegment
+	file     *os.File
+	size     int64
+	dirty    bool
+	err      error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// walSegment is a log file, named <seq>-<first index>.wal in hex
+type walSegment struct {
+	seq   uint64
+	first uint64
+	path  string
+}
+
+// OpenWAL opens the WAL in dir, creating it if needed, and recovers the
+// log from the latest snapshot and the segments after it
+func OpenWAL(dir string, options WALOptions) (*WAL, error) {
+	if options.SnapshotDir == "" {
+		options.SnapshotDir = filepath.Join(dir, "snap")
+	}
+	if options.SegmentSize <= 0 {
+		options.SegmentSize = DefaultSegmentSize
+	}
+	if options.SyncInterval <= 0 {
+		options.SyncInterval = DefaultSyncInterval
+	}
+	for _, d := range []string{dir, options.SnapshotDir} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", d, err)
+		}
+	}
+
+	w := &WAL{dir: dir, snapDir: options.SnapshotDir, options: options}
+	snapshot, err := loadSnapshot(w.snapDir)
+	if err != nil {
+		return nil, err
+	}
+	w.log.snapshot = snapshot
+
+	if err := w.replay(); err != nil {
+		return nil, err
+	}
+
+	if options.SyncPolicy == SyncInterval {
+		w.stop, w.done = make(chan struct{}), make(chan struct{})
+		go w.syncLoop()
+	}
+	return w, nil
+}
+
+// replay rebuilds the log and hard state from the segments
+func (w *WAL) replay() error {
+	paths, err := filepath.Glob(filepath.Join(w.dir, "*"+walSegmentExt))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		var seq, first uint64
+		if _, err := fmt.Sscanf(filepath.Base(path), "%016x-%016x"+walSegmentExt, &seq, &first); err != nil {
+			continue
+		}
+		w.segments = append(w.segments, walSegment{seq: seq, first: first, path: path})
+	}
+	sort.Slice(w.segments, func(i, j int) bool { return w.segments[i].seq < w.segments[j].seq })
+
+	for i, segment := range w.segments {
+		last := i == len(w.segments)-1
+		data, err := os.ReadFile(segment.path)
+		if err != nil {
+			return fmt.Errorf("failed to read WAL segment: %w", err)
+		}
+		valid, err := w.replaySegment(data, last)
+		if err != nil {
+			return fmt.Errorf("%s: %w", segment.path, err)
+		}
+		if !last {
+			continue
+		}
+
+		// Drop a torn final write and continue writing after the last
+		// complete record
+		if valid < int64(len(data)) {
+			if err := os.Truncate(segment.path, valid); err != nil {
+				return fmt.Errorf("failed to truncate torn WAL write: %w", err)
+			}
+		}
+		file, err := os.OpenFile(segment.path, os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open WAL segment: %w", err)
+		}
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to sync WAL segment: %w", err)
+		}
+		w.file, w.size = file, valid
+	}
+
+	if w.file == nil {
+		return w.newSegment(false)
+	}
+	return nil
+}
+
+// replaySegment applies the records of one segment and returns the length
+// of its valid prefix. Only the last segment may end in a torn write.
+func (w *WAL) replaySegment(data []byte, last bool) (int64, error) {
+	offset := 0
+	for offset < len(data) {
+		record, next, torn := readWALRecord(data, offset)
+		if torn {
+			if !last {
+				return 0, fmt.Errorf("damaged record at offset %d: %w", offset, ErrCorrupt)
+			}
+			return int64(offset), nil
+		}
+		if record == nil {
+			return 0, fmt.Errorf("checksum mismatch at offset %d: %w", offset, ErrCorrupt)
+		}
+		if err := w.replayRecord(record[0], record[1:]); err != nil {
+			return 0, fmt.Errorf("record at offset %d: %w", offset, err)
+		}
+		offset = next
+	}
+	return int64(offset), nil
+}
+
+// readWALRecord returns the record at offset and the offset after it.
+// torn reports a record cut short by the end of the data; a complete
+// record with a bad checksum is returned as nil.
+func readWALRecord(data []byte, offset int) (record []byte, next int, torn bool) {
+	if len(data)-offset < walHeaderSize {
+		return nil, 0, true
+	}
+	length := int(binary.LittleEndian.Uint32(data[offset:]))
+	sum := binary.LittleEndian.Uint32(data[offset+4:])
+	end := offset + walHeaderSize + length
+
+	// Zeroes past the last write are left by file systems that extended
+	// the file before the crash
+	if length == 0 {
+		return nil, 0, len(bytes.Trim(data[offset:], "\x00")) == 0
+	}
+	if length > maxWALRecord || end > len(data) {
+		return nil, 0, true
+	}
+	record = data[offset+walHeaderSize : end]
+	if crc32.Checksum(record, walCRCTable) != sum {
+		// A bad checksum on the final record is an incomplete write
+		return nil, 0, end == len(data)
+	}
+	return record, end, false
+}
+
+func (w *WAL) replayRecord(kind byte, payload []byte) error {
+	switch kind {
+	case walHardState:
+		if len(payload) < 8 {
+			return ErrCorrupt
+		}
+		w.state = HardState{Term: binary.LittleEndian.Uint64(payload), Vote: string(payload[8:])}
+	case walSnapshot:
+		if len(payload) != 17 {
+			return ErrCorrupt
+		}
+		if index := binary.LittleEndian.Uint64(payload); index > w.log.snapshot.Index {
+			return fmt.Errorf("snapshot %d is missing: %w", index, ErrCorrupt)
+		}
+		if payload[16] == 1 {
+			// The snapshot replaced the log: entries of earlier segments
+			// are obsolete even if deleting them was interrupted
+			w.log.entries = nil
+		}
+	case walEntry:
+		if len(payload) < walEntryHeader {
+			return ErrCorrupt
+		}
+		entry := Entry{
+			Index: binary.LittleEndian.Uint64(payload),
+			Term:  binary.LittleEndian.Uint64(payload[8:]),
+			Type:  EntryType(payload[16]),
+			Data:  append([]byte(nil), payload[walEntryHeader:]...),
+		}
+		if entry.Index <= w.log.snapshot.Index {
+			return nil
+		}
+		if entry.Index > w.log.lastIndex()+1 {
+			return fmt.Errorf("entry %d follows %d: %w", entry.Index, w.log.lastIndex(), ErrCorrupt)
+		}
+		w.log.append([]Entry{entry})
+	default:
+		return fmt.Errorf("unknown record type %d: %w", kind, ErrCorrupt)
+	}
+	return nil
+}
+
+func (w *WAL) HardState() (HardState, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.state, nil
+}
+
+func (w *WAL) SetHardState(state HardState) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.write(encodeHardState(nil, state)); err != nil {
+		return err
+	}
+	w.state = state
+	return nil
+}
+
+func (w *WAL) FirstIndex() (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.log.snapshot.Index + 1, nil
+}
+
+func (w *WAL) LastIndex() (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.log.lastIndex(), nil
+}
+
+func (w *WAL) Term(index uint64) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.log.term(index)
+}
+
+func (w *WAL) Entries(lo, hi uint64) ([]Entry, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.log.slice(lo, hi)
+}
+
+func (w *WAL) Append(entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.log.checkAppend(entries); err != nil {
+		return err
+	}
+
+	var buf []byte
+	for _, entry := range entries {
+		buf = encodeEntry(buf, entry)
+	}
+	if err := w.write(buf); err != nil {
+		return err
+	}
+	w.log.append(entries)
+
+	if w.size >= w.options.SegmentSize {
+		return w.rollover(false)
+	}
+	return nil
+}
+
+func (w *WAL) Snapshot() (Snapshot, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.log.snapshot, nil
+}
+
+func (w *WAL) SaveSnapshot(snapshot Snapshot) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	if snapshot.Index <= w.log.snapshot.Index {
+		return nil
+	}
+
+	path, err := writeSnapshot(w.snapDir, snapshot)
+	if err != nil {
+		return err
+	}
+	if !w.log.compact(snapshot) {
+		// The old log is obsolete: start a segment that resets it before
+		// deleting the segments holding it
+		if err := w.rollover(true); err != nil {
+			return err
+		}
+	}
+	w.removeSegments(snapshot.Index)
+	removeSnapshots(w.snapDir, path)
+	return nil
+}
+
+// Close flushes and closes the WAL
+func (w *WAL) Close() error {
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Sync()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	w.file = nil
+	if w.err == nil {
+		w.err = fmt.Errorf("WAL %s is closed", w.dir)
+	}
+	return err
+}
+
+// write appends records to the current segment, flushing them according
+// to the sync policy. A failed write is cut off again so that it cannot
+// hide later records; if that fails too, the WAL refuses further writes.
+func (w *WAL) write(records []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	n, err := w.file.Write(records)
+	if err == nil && w.options.SyncPolicy == SyncAlways {
+		err = w.file.Sync()
+	}
+	if err != nil {
+		if n > 0 {
+			if truncErr := w.file.Truncate(w.size); truncErr != nil {
+				w.err = fmt.Errorf("WAL is unusable after a failed write: %w", truncErr)
+			}
+		}
+		return fmt.Errorf("failed to write WAL: %w", err)
+	}
+	w.size += int64(n)
+	w.dirty = w.options.SyncPolicy != SyncAlways
+	return nil
+}
+
+// rollover closes the current segment and starts the next one
+func (w *WAL) rollover(reset bool) error {
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync WAL segment: %w", err)
+	}
+	w.file.Close()
+	w.file = nil
+	return w.newSegment(reset)
+}
+
+// newSegment creates a segment starting with the hard state and the
+// snapshot position. A reset segment discards the log of the segments
+// before it.
+func (w *WAL) newSegment(reset bool) error {
+	var seq uint64 = 1
+	if len(w.segments) > 0 {
+		seq = w.segments[len(w.segments)-1].seq + 1
+	}
+	first := w.log.lastIndex() + 1
+	path := filepath.Join(w.dir, fmt.Sprintf("%016x-%016x%s", seq, first, walSegmentExt))
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		w.err = fmt.Errorf("failed to create WAL segment: %w", err)
+		return w.err
+	}
+	header := encodeHardState(nil, w.state)
+	header = encodeSnapshotMark(header, w.log.snapshot, reset)
+	if _, err := file.Write(header); err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = syncDir(w.dir)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		w.err = fmt.Errorf("failed to start WAL segment: %w", err)
+		return w.err
+	}
+
+	w.segments = append(w.segments, walSegment{seq: seq, first: first, path: path})
+	w.file, w.size, w.dirty = file, int64(len(header)), false
+	return nil
+}
+
+// removeSegments deletes the segments that only hold entries up to index.
+// The current segment is always kept.
+func (w *WAL) removeSegments(index uint64) {
+	current := len(w.segments) - 1
+	kept := w.segments[:0]
+	for i, segment := range w.segments {
+		// A segment's entries end before the next segment's first index.
+		// Without entries after the snapshot, all older segments go.
+		obsolete := i < current && (w.segments[i+1].first <= index+1 || len(w.log.entries) == 0)
+		if obsolete && os.Remove(segment.path) == nil {
+			continue
+		}
+		kept = append(kept, segment)
+	}
+	w.segments = kept
+}
+
+// syncLoop flushes the WAL every SyncInterval
+func (w *WAL) syncLoop() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.options.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.dirty && w.file != nil {
+				if err := w.file.Sync(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to sync WAL: %v\n", err)
+				} else {
+					w.dirty = false
+				}
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// appendWALRecord appends a framed record to buf
+func appendWALRecord(buf []byte, kind byte, payload ...[]byte) []byte {
+	start := len(buf)
+	buf = append(buf, make([]byte, walHeaderSize)...)
+	buf = append(buf, kind)
+	for _, p := range payload {
+		buf = append(buf, p...)
+	}
+	record := buf[start+walHeaderSize:]
+	binary.LittleEndian.PutUint32(buf[start:], uint32(len(record)))
+	binary.LittleEndian.PutUint32(buf[start+4:], crc32.Checksum(record, walCRCTable))
+	return buf
+}
+
+func encodeEntry(buf []byte, entry Entry) []byte {
+	header := make([]byte, walEntryHeader)
+	binary.LittleEndian.PutUint64(header, entry.Index)
+	binary.LittleEndian.PutUint64(header[8:], entry.Term)
+	header[16] = byte(entry.Type)
+	return appendWALRecord(buf, walEntry, header, entry.Data)
+}
+
+func encodeHardState(buf []byte, state HardState) []byte {
+	term := make([]byte, 8)
+	binary.LittleEndian.PutUint64(term, state.Term)
+	return appendWALRecord(buf, walHardState, term, []byte(state.Vote))
+}
+
+func encodeSnapshotMark(buf []byte, snapshot Snapshot, reset bool) []byte {
+	mark := make([]byte, 17)
+	binary.LittleEndian.PutUint64(mark, snapshot.Index)
+	binary.LittleEndian.PutUint64(mark[8:], snapshot.Term)
+	if reset {
+		mark[16] = 1
+	}
+	return appendWALRecord(buf, walSnapshot, mark)
+}
+
+// writeSnapshot stores a snapshot as <index>-<term>.snap, atomically
+func writeSnapshot(dir string, snapshot Snapshot) (string, error) {
+	body := make([]byte, 16, 16+len(snapshot.Data))
+	binary.LittleEndian.PutUint64(body, snapshot.Index)
+	binary.LittleEndian.PutUint64(body[8:], snapshot.Term)
+	body = append(body, snapshot.Data...)
+
+	data := make([]byte, len(snapshotMagic)+4, len(snapshotMagic)+4+len(body))
+	copy(data, snapshotMagic)
+	binary.LittleEndian.PutUint32(data[len(snapshotMagic):], crc32.Checksum(body, walCRCTable))
+	data = append(data, body...)
+
+	path := filepath.Join(dir, fmt.Sprintf("%016x-%016x%s", snapshot.Index, snapshot.Term, snapshotExt))
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err == nil {
+		err = syncDir(dir)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return path, nil
+}
+
+// loadSnapshot reads the latest snapshot in dir. Snapshots that were
+// being written during a crash are removed.
+func loadSnapshot(dir string) (Snapshot, error) {
+	leftovers, _ := filepath.Glob(filepath.Join(dir, "*"+snapshotExt+".tmp"))
+	for _, path := range leftovers {
+		os.Remove(path)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+snapshotExt))
+	if err != nil || len(paths) == 0 {
+		return Snapshot{}, err
+	}
+	sort.Strings(paths)
+	path := paths[len(paths)-1]
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	header := len(snapshotMagic) + 4
+	if len(data) < header+16 || !strings.HasPrefix(string(data), snapshotMagic) {
+		return Snapshot{}, fmt.Errorf("%s: invalid snapshot: %w", path, ErrCorrupt)
+	}
+	body := data[header:]
+	if crc32.Checksum(body, walCRCTable) != binary.LittleEndian.Uint32(data[len(snapshotMagic):]) {
+		return Snapshot{}, fmt.Errorf("%s: checksum mismatch: %w", path, ErrCorrupt)
+	}
+	return Snapshot{
+		Index: binary.LittleEndian.Uint64(body),
+		Term:  binary.LittleEndian.Uint64(body[8:]),
+		Data:  body[16:],
+	}, nil
+}
+
+// removeSnapshots deletes the snapshots in dir other than keep
+func removeSnapshots(dir, keep string) {
+	paths, _ := filepath.Glob(filepath.Join(dir, "*"+snapshotExt))
+	for _, path := range paths {
+		if path != keep {
+			os.Remove(path)
+		}
+	}
+}
+
+// syncDir flushes a directory so that created and renamed files persist
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,339 @@
+package barnctl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func openTestWAL(t *testing.T, dir string, options WALOptions) *WAL {
+	t.Helper()
+	w, err := OpenWAL(dir, options)
+	if err != nil {
+		t.Fatalf("Failed to open WAL: %v", err)
+	}
+	return w
+}
+
+// testEntries returns entries lo..hi in term
+func testEntries(lo, hi, term uint64) []Entry {
+	var entries []Entry
+	for i := lo; i <= hi; i++ {
+		entries = append(entries, Entry{Index: i, Term: term, Data: []byte(fmt.Sprintf("entry-%d-%d", i, term))})
+	}
+	return entries
+}
+
+// expectLog checks that the WAL holds exactly the expected entries after
+// its snapshot
+func expectLog(t *testing.T, w *WAL, expected []Entry) {
+	t.Helper()
+	first, _ := w.FirstIndex()
+	last, _ := w.LastIndex()
+	entries, err := w.Entries(first, last+1)
+	if err != nil {
+		t.Fatalf("Failed to read entries: %v", err)
+	}
+	if fmt.Sprint(entries) != fmt.Sprint(expected) {
+		t.Fatalf("Expected entries %v, got %v", expected, entries)
+	}
+}
+
+func walSegments(t *testing.T, dir string) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+walSegmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// TestWALRecovery tests that the log and hard state survive a reopen,
+// including entries replaced by a new leader
+func TestWALRecovery(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, WALOptions{})
+	w.SetHardState(HardState{Term: 1, Vote: "barn-1"})
+	w.Append(testEntries(1, 10, 1))
+	w.SetHardState(HardState{Term: 2})
+	w.Append(testEntries(8, 12, 2))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close WAL: %v", err)
+	}
+	if err := w.Append(testEntries(13, 13, 2)); err == nil {
+		t.Error("Expected a closed WAL to refuse writes")
+	}
+
+	w = openTestWAL(t, dir, WALOptions{})
+	defer w.Close()
+	if state, _ := w.HardState(); state != (HardState{Term: 2}) {
+		t.Errorf("Expected hard state {2 }, got %v", state)
+	}
+	expectLog(t, w, append(testEntries(1, 7, 1), testEntries(8, 12, 2)...))
+}
+
+// TestWALSnapshots tests segment rollover and that snapshots delete the
+// segments they cover
+func TestWALSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	options := WALOptions{SegmentSize: 256, SyncPolicy: SyncNever}
+	w := openTestWAL(t, dir, options)
+	for i := uint64(1); i <= 100; i++ {
+		if err := w.Append(testEntries(i, i, 1)); err != nil {
+			t.Fatalf("Failed to append: %v", err)
+		}
+	}
+	segments := len(walSegments(t, dir))
+	if segments < 10 {
+		t.Fatalf("Expected the log to roll over to many segments, got %d", segments)
+	}
+
+	snapshot := Snapshot{Index: 80, Term: 1, Data: []byte("state at 80")}
+	if err := w.SaveSnapshot(snapshot); err != nil {
+		t.Fatalf("Failed to save snapshot: %v", err)
+	}
+	if remaining := len(walSegments(t, dir)); remaining >= segments/2 {
+		t.Errorf("Expected covered segments to be deleted, %d of %d remain", remaining, segments)
+	}
+	if _, err := w.Term(50); !errors.Is(err, ErrCompacted) {
+		t.Errorf("Expected ErrCompacted for a compacted entry, got %v", err)
+	}
+	w.Close()
+
+	w = openTestWAL(t, dir, options)
+	defer w.Close()
+	if got, _ := w.Snapshot(); fmt.Sprint(got) != fmt.Sprint(snapshot) {
+		t.Errorf("Expected snapshot %v, got %v", snapshot, got)
+	}
+	expectLog(t, w, testEntries(81, 100, 1))
+	if snapshots, _ := filepath.Glob(filepath.Join(dir, "snap", "*")); len(snapshots) != 1 {
+		t.Errorf("Expected one snapshot file, got %v", snapshots)
+	}
+}
+
+// TestWALInstalledSnapshot tests that a snapshot from the leader replaces
+// a conflicting log, even if deleting the old segments was interrupted
+func TestWALInstalledSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, WALOptions{})
+	w.Append(testEntries(1, 20, 1))
+	old := walSegments(t, dir)
+	saved := make(map[string][]byte)
+	for _, path := range old {
+		saved[path], _ = os.ReadFile(path)
+	}
+
+	if err := w.SaveSnapshot(Snapshot{Index: 15, Term: 2, Data: []byte("leader state")}); err != nil {
+		t.Fatalf("Failed to save snapshot: %v", err)
+	}
+	if last, _ := w.LastIndex(); last != 15 {
+		t.Errorf("Expected the log to end at the snapshot, got last index %d", last)
+	}
+	w.Append(testEntries(16, 17, 2))
+	w.Close()
+
+	// A crash before the old segments were deleted leaves them behind
+	for path, data := range saved {
+		os.WriteFile(path, data, 0600)
+	}
+	w = openTestWAL(t, dir, WALOptions{})
+	defer w.Close()
+	expectLog(t, w, testEntries(16, 17, 2))
+}
+
+// TestWALTornWrites injects the faults a crash can leave in the final
+// segment and checks that recovery keeps every complete record
+func TestWALTornWrites(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, WALOptions{})
+	w.SetHardState(HardState{Term: 1, Vote: "barn-2"})
+	w.Append(testEntries(1, 4, 1))
+	segment := walSegments(t, dir)[0]
+	info, _ := os.Stat(segment)
+	before := info.Size()
+	w.Append(testEntries(5, 5, 1))
+	w.Close()
+
+	original, err := os.ReadFile(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordLen := int64(len(original)) - before
+
+	// recover writes the damaged segment, reopens the WAL and checks that
+	// it holds entries 1..last and accepts new writes
+	recover := func(t *testing.T, data []byte, last uint64) {
+		t.Helper()
+		os.WriteFile(segment, data, 0600)
+		w := openTestWAL(t, dir, WALOptions{})
+		expectLog(t, w, testEntries(1, last, 1))
+		if state, _ := w.HardState(); state != (HardState{Term: 1, Vote: "barn-2"}) {
+			t.Errorf("Expected the hard state to survive, got %v", state)
+		}
+		if err := w.Append(testEntries(last+1, last+1, 1)); err != nil {
+			t.Fatalf("Failed to append after recovery: %v", err)
+		}
+		w.Close()
+
+		w = openTestWAL(t, dir, WALOptions{})
+		defer w.Close()
+		expectLog(t, w, testEntries(1, last+1, 1))
+	}
+
+	t.Run("cut", func(t *testing.T) {
+		for cut := int64(1); cut < recordLen; cut++ {
+			recover(t, original[:int64(len(original))-cut], 4)
+		}
+	})
+	t.Run("zero tail", func(t *testing.T) {
+		recover(t, append(append([]byte(nil), original...), make([]byte, 4096)...), 5)
+	})
+	t.Run("partial header", func(t *testing.T) {
+		recover(t, append(append([]byte(nil), original...), 0x2a, 0, 0), 5)
+	})
+	t.Run("bad checksum", func(t *testing.T) {
+		damaged := append([]byte(nil), original...)
+		damaged[len(damaged)-1] ^= 0xff
+		recover(t, damaged, 4)
+	})
+
+	t.Run("corrupt record", func(t *testing.T) {
+		damaged := append([]byte(nil), original...)
+		damaged[before-3] ^= 0xff
+		os.WriteFile(segment, damaged, 0600)
+		if _, err := OpenWAL(dir, WALOptions{}); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Expected ErrCorrupt for a damaged record before the tail, got %v", err)
+		}
+	})
+
+	t.Run("corrupt segment", func(t *testing.T) {
+		dir := t.TempDir()
+		w := openTestWAL(t, dir, WALOptions{SegmentSize: 128})
+		w.Append(testEntries(1, 10, 1))
+		w.Close()
+		segments := walSegments(t, dir)
+		if len(segments) < 2 {
+			t.Fatalf("Expected several segments, got %d", len(segments))
+		}
+		// Losing the end of an earlier segment is never a torn write
+		info, _ := os.Stat(segments[0])
+		os.Truncate(segments[0], info.Size()-1)
+		if _, err := OpenWAL(dir, WALOptions{}); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Expected ErrCorrupt for a damaged earlier segment, got %v", err)
+		}
+	})
+
+	t.Run("corrupt snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		w := openTestWAL(t, dir, WALOptions{})
+		w.Append(testEntries(1, 10, 1))
+		w.SaveSnapshot(Snapshot{Index: 5, Term: 1, Data: []byte("state")})
+		w.Close()
+		snapshots, _ := filepath.Glob(filepath.Join(dir, "snap", "*"+snapshotExt))
+		data, _ := os.ReadFile(snapshots[0])
+		data[len(data)-1] ^= 0xff
+		os.WriteFile(snapshots[0], data, 0600)
+		if _, err := OpenWAL(dir, WALOptions{}); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Expected ErrCorrupt for a damaged snapshot, got %v", err)
+		}
+	})
+}
+
+// TestWALSyncInterval tests that interval syncing flushes in the background
+func TestWALSyncInterval(t *testing.T) {
+	w := openTestWAL(t, t.TempDir(), WALOptions{SyncPolicy: SyncInterval, SyncInterval: time.Millisecond})
+	defer w.Close()
+	w.Append(testEntries(1, 3, 1))
+	deadline := time.Now().Add(testWait)
+	for {
+		w.mu.Lock()
+		dirty := w.dirty
+		w.mu.Unlock()
+		if !dirty {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the WAL to be synced")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := ParseSyncPolicy("sometimes"); err == nil {
+		t.Error("Expected an unknown sync policy to be rejected")
+	}
+}
+
+// TestStoreRestart tests that Barn state survives restarts of every node,
+// and that nodes behind the compacted log catch up from a snapshot
+func TestStoreRestart(t *testing.T) {
+	root := t.TempDir()
+	var noCompaction string
+	c := newTestClusterWith(t, 3, func(config *RaftConfig) {
+		config.SnapshotInterval = testHeartbeatInterval
+		config.MaxLogEntries = 10
+		if config.ID == noCompaction {
+			config.MaxLogEntries = 1 << 20
+		}
+		storage, err := OpenWAL(filepath.Join(root, config.ID), WALOptions{})
+		if err != nil {
+			t.Fatalf("Failed to open WAL: %v", err)
+		}
+		config.Storage = storage
+	})
+
+	for i := 0; i < 30; i++ {
+		c.put(fmt.Sprintf("herds/finance/%02d", i), fmt.Sprint(i))
+	}
+	c.waitValue("herds/finance/29", "29")
+
+	// A stopped node misses writes the others compact away
+	lagging := c.leader()
+	compacted := c.stores[lagging].Raft().Status().SnapshotIndex
+	c.stop(lagging)
+	for i := 30; i < 60; i++ {
+		c.put(fmt.Sprintf("herds/finance/%02d", i), fmt.Sprint(i))
+	}
+	c.waitValue("herds/finance/59", "59")
+	deadline := time.Now().Add(testWait)
+	for id, store := range c.stores {
+		for store.Raft().Status().SnapshotIndex < 30 {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: expected the log to be compacted", id)
+			}
+			time.Sleep(testHeartbeatInterval)
+		}
+	}
+
+	// Without compacting its own log, only an installed snapshot moves the
+	// restarted node's snapshot on
+	noCompaction = lagging
+	c.start(lagging)
+	c.waitValue("herds/finance/59", "59")
+	if status := c.stores[lagging].Raft().Status(); status.SnapshotIndex <= compacted {
+		t.Errorf("Expected %s to catch up from a snapshot, got %+v", lagging, status)
+	}
+	noCompaction = ""
+
+	// Restart the whole cluster from disk
+	for _, peer := range c.peers {
+		c.stop(peer.ID)
+	}
+	for _, peer := range c.peers {
+		c.start(peer.ID)
+	}
+	c.leader()
+	for id, store := range c.stores {
+		// Nodes restore their snapshot before an election
+		if keys := store.List("herds/finance/"); len(keys) < 30 {
+			t.Errorf("%s: expected the snapshot to be restored, got %d keys", id, len(keys))
+		}
+	}
+	c.waitValue("herds/finance/59", "59")
+	c.put("after", "restart")
+	c.waitValue("after", "restart")
+}
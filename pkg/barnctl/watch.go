@@ -0,0 +1,532 @@
+package barnctl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// DefaultWatchHistory is how many events the store keeps for watches.
+	// Watches that fall further behind fail with ErrRevisionTooOld.
+	DefaultWatchHistory = 10000
+
+	// DefaultWatchProgress is how often an idle watch stream reports the
+	// store revision, so that its cursor keeps up with compaction
+	DefaultWatchProgress = 10 * time.Second
+
+	// watchRetryMin and watchRetryMax bound the delay before a Watcher
+	// reconnects a broken stream
+	watchRetryMin = 100 * time.Millisecond
+	watchRetryMax = 5 * time.Second
+)
+
+// Event types. Progress events carry no change; they report that the
+// stream has delivered every event up to their revision.
+const (
+	EventPut      = "put"
+	EventDelete   = "delete"
+	EventProgress = "progress"
+	eventError    = "error"
+)
+
+// Event is a change of a key. Several events share a revision if they were
+// written in one transaction.
+type Event struct {
+	Type           string
+	Key            string
+	Value          []byte
+	CreateRevision uint64
+	Revision       uint64
+}
+
+// record adds the events of a write to the history, dropping the oldest
+// revisions beyond the history limit. The caller holds s.mu.
+func (s *Store) record(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	s.history = append(s.history, events...)
+	if excess := len(s.history) - s.historyLimit; excess > 0 {
+		// Drop whole revisions, so no watch sees half a transaction
+		s.compacted = s.history[excess-1].Revision
+		for excess < len(s.history) && s.history[excess].Revision == s.compacted {
+			excess++
+		}
+		s.history = s.history[excess:]
+	}
+	s.notify()
+}
+
+// notify wakes up the watchers. The caller holds s.mu.
+func (s *Store) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Watcher follows the changes of the keys under a set of prefixes
+type Watcher struct {
+	store    *Store
+	prefixes []string
+	next     uint64
+}
+
+// Watch returns a watcher for the keys under prefixes that starts at
+// revision, or with the next write for 0. It fails with a
+// RevisionTooOldError if the events since revision were compacted.
+func (s *Store) Watch(prefixes []string, revision uint64) (*Watcher, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if revision == 0 {
+		revision = s.revision + 1
+	} else if revision <= s.compacted {
+		return nil, &RevisionTooOldError{Revision: revision, Compacted: s.compacted}
+	}
+	return &Watcher{store: s, prefixes: prefixes, next: revision}, nil
+}
+
+// Next waits for changes and returns the events of every revision written
+// since the last call, in order. It fails with a RevisionTooOldError if
+// the watcher fell so far behind that events were compacted.
+func (w *Watcher) Next(ctx context.Context) ([]Event, error) {
+	for {
+		events, changed, err := w.poll()
+		if err != nil || len(events) > 0 {
+			return events, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Revision returns the revision up to which the watcher returned every
+// event. Watching again from the revision after it resumes the watch.
+func (w *Watcher) Revision() uint64 {
+	return w.next - 1
+}
+
+func (w *Watcher) poll() ([]Event, <-chan struct{}, error) {
+	s := w.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if w.next <= s.compacted {
+		return nil, nil, &RevisionTooOldError{Revision: w.next, Compacted: s.compacted}
+	}
+
+	var events []Event
+	start := sort.Search(len(s.history), func(i int) bool { return s.history[i].Revision >= w.next })
+	for _, event := range s.history[start:] {
+		if w.matches(event.Key) {
+			events = append(events, event)
+		}
+	}
+	if s.revision >= w.next {
+		w.next = s.revision + 1
+	}
+	return events, s.changed, nil
+}
+
+func (w *Watcher) matches(key string) bool {
+	for _, prefix := range w.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchEvent is the data of a watch stream event. Value is the stored
+// resource, such as a Herd or Slice.
+type WatchEvent struct {
+	Type           string          `json:"type"`
+	Key            string          `json:"key,omitempty"`
+	Value          json.RawMessage `json:"value,omitempty"`
+	CreateRevision uint64          `json:"createRevision,omitempty"`
+	Revision       uint64          `json:"revision"`
+}
+
+// watch streams the changes under the prefix query parameters as
+// server-sent events:
+//
+//	id: 42
+//	event: put
+//	data: {"type":"put","key":"herds/finance","value":{...},"revision":42}
+//
+// The id is the revision up to which the stream delivered every event. It
+// is only set on the last event of a revision, and on the progress events
+// sent when the stream starts and while it is idle. A stream resumes from
+// ?revision=<id+1>, or from the Last-Event-ID header. Watches are served
+// from the local store, so a cursor from one member is valid on all.
+func (s *Server) watch(w http.ResponseWriter, r *http.Request, _ Params) {
+	prefixes, revision, err := watchRequest(r)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	watcher, err := s.store.Watch(prefixes, revision)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	// End the stream on shutdown, which does not wait for streams
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	stream := &eventStream{w: w, rc: http.NewResponseController(w)}
+
+	err = stream.progress(watcher.Revision())
+	for err == nil {
+		var events []Event
+		waitCtx, waitCancel := context.WithTimeout(ctx, s.config.WatchProgress)
+		events, err = watcher.Next(waitCtx)
+		waitCancel()
+		switch {
+		case err == nil:
+			err = stream.events(events)
+		case ctx.Err() != nil:
+			return
+		case errors.Is(err, context.DeadlineExceeded):
+			err = stream.progress(watcher.Revision())
+		default:
+			stream.fail(err)
+			return
+		}
+	}
+}
+
+// watchRequest returns the prefixes and the start revision of a watch
+func watchRequest(r *http.Request) ([]string, uint64, error) {
+	prefixes := r.URL.Query()["prefix"]
+	if len(prefixes) == 0 {
+		return nil, 0, &ValidationError{Field: "prefix", Message: "is required"}
+	}
+	for _, prefix := range prefixes {
+		if !strings.HasPrefix(prefix, herdKeyPrefix) && !strings.HasPrefix(prefix, sliceKeyPrefix) {
+			return nil, 0, &ValidationError{Field: "prefix", Message: fmt.Sprintf("%q is not under %s or %s", prefix, herdKeyPrefix, sliceKeyPrefix)}
+		}
+	}
+
+	revision, err := queryRevision(r)
+	if err != nil || revision != 0 {
+		return prefixes, revision, err
+	}
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		last, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return nil, 0, &ValidationError{Field: "Last-Event-ID", Message: fmt.Sprintf("%q is not a revision", id)}
+		}
+		revision = last + 1
+	}
+	return prefixes, revision, nil
+}
+
+// eventStream writes server-sent events
+type eventStream struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (e *eventStream) events(events []Event) error {
+	for i, event := range events {
+		last := i == len(events)-1 || events[i+1].Revision != event.Revision
+		data := WatchEvent{
+			Type:           event.Type,
+			Key:            event.Key,
+			Value:          jsonValue(event.Value),
+			CreateRevision: event.CreateRevision,
+			Revision:       event.Revision,
+		}
+		if err := e.write(event.Revision, last, event.Type, data); err != nil {
+			return err
+		}
+	}
+	return e.rc.Flush()
+}
+
+func (e *eventStream) progress(revision uint64) error {
+	if err := e.write(revision, true, EventProgress, WatchEvent{Type: EventProgress, Revision: revision}); err != nil {
+		return err
+	}
+	return e.rc.Flush()
+}
+
+// fail ends the stream with an error event
+func (e *eventStream) fail(err error) {
+	_, resp := errorResponse(err)
+	e.write(0, false, eventError, resp)
+	e.rc.Flush()
+}
+
+func (e *eventStream) write(id uint64, withID bool, event string, data any) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if withID {
+		if _, err := fmt.Fprintf(e.w, "id: %d\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(e.w, "event: %s\ndata: %s\n\n", event, body)
+	return err
+}
+
+// jsonValue returns value as JSON. API resources are stored as JSON; other
+// values are sent as JSON strings.
+func jsonValue(value []byte) json.RawMessage {
+	if value == nil || json.Valid(value) {
+		return value
+	}
+	quoted, _ := json.Marshal(string(value))
+	return quoted
+}
+
+// ClientWatcher follows a watch stream of the Barn API. When the stream
+// breaks, it reconnects and resumes after the last revision it returned
+// every event of.
+type ClientWatcher struct {
+	client   *Client
+	ctx      context.Context
+	cancel   context.CancelFunc
+	prefixes []string
+	next     uint64
+	retry    time.Duration
+
+	body   io.ReadCloser
+	reader *bufio.Reader
+}
+
+// Watch follows the changes under prefixes from revision on, or from now
+// for 0, until ctx ends or the watcher is closed
+func (c *Client) Watch(ctx context.Context, prefixes []string, revision uint64) *ClientWatcher {
+	ctx, cancel := context.WithCancel(ctx)
+	return &ClientWatcher{client: c, ctx: ctx, cancel: cancel, prefixes: prefixes, next: revision, retry: watchRetryMin}
+}
+
+// Next returns the next put or delete event. It fails with a
+// RevisionTooOldError if the events since the cursor were compacted, and
+// with the error of the context once it ends.
+func (w *ClientWatcher) Next() (*WatchEvent, error) {
+	for {
+		if w.body == nil {
+			if err := w.connect(); err != nil {
+				if !retryWatch(err) {
+					return nil, err
+				}
+				if err := w.backoff(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		id, event, data, err := w.readEvent()
+		if err != nil {
+			w.disconnect()
+			if err := w.backoff(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		switch event {
+		case EventProgress:
+			w.advance(id)
+		case EventPut, EventDelete:
+			watchEvent := &WatchEvent{}
+			if err := json.Unmarshal(data, watchEvent); err != nil {
+				return nil, fmt.Errorf("invalid watch event: %w", err)
+			}
+			w.advance(id)
+			return watchEvent, nil
+		case eventError:
+			w.disconnect()
+			failure := ErrorResponse{}
+			json.Unmarshal(data, &failure)
+			err := w.failed(&APIError{StatusCode: http.StatusOK, Code: failure.Code, Message: failure.Error, Revision: failure.Revision})
+			if !retryWatch(err) {
+				return nil, err
+			}
+			if err := w.backoff(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// Revision returns the revision up to which Next returned every event
+func (w *ClientWatcher) Revision() uint64 {
+	if w.next == 0 {
+		return 0
+	}
+	return w.next - 1
+}
+
+// Close ends the watch
+func (w *ClientWatcher) Close() error {
+	w.cancel()
+	w.disconnect()
+	return nil
+}
+
+func (w *ClientWatcher) connect() error {
+	query := url.Values{"prefix": w.prefixes}
+	if w.next != 0 {
+		query.Set("revision", strconv.FormatUint(w.next, 10))
+	}
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodGet, w.client.Endpoint+APIPrefix+"/watch?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := w.client.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach barn at %s: %w", w.client.Endpoint, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return w.failed(readAPIError(resp))
+	}
+	w.body, w.reader = resp.Body, bufio.NewReader(resp.Body)
+	w.retry = watchRetryMin
+	return nil
+}
+
+// failed turns a revision-too-old API error into a RevisionTooOldError
+func (w *ClientWatcher) failed(err *APIError) error {
+	if errors.Is(err, ErrRevisionTooOld) {
+		return &RevisionTooOldError{Revision: w.next, Compacted: err.Revision}
+	}
+	return err
+}
+
+func (w *ClientWatcher) disconnect() {
+	if w.body != nil {
+		w.body.Close()
+		w.body, w.reader = nil, nil
+	}
+}
+
+func (w *ClientWatcher) advance(id string) {
+	if revision, err := strconv.ParseUint(id, 10, 64); err == nil {
+		w.next = revision + 1
+	}
+}
+
+// backoff waits before a reconnect, longer after each failed attempt
+func (w *ClientWatcher) backoff() error {
+	timer := time.NewTimer(w.retry)
+	defer timer.Stop()
+	select {
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	case <-timer.C:
+	}
+	w.retry = min(2*w.retry, watchRetryMax)
+	return nil
+}
+
+// readEvent reads the next server-sent event
+func (w *ClientWatcher) readEvent() (id, event string, data []byte, err error) {
+	var lines []string
+	for {
+		line, err := w.reader.ReadString('\n')
+		if err != nil {
+			return "", "", nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if event == "" && len(lines) == 0 {
+				continue
+			}
+			return id, event, []byte(strings.Join(lines, "\n")), nil
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			id = value
+		case "event":
+			event = value
+		case "data":
+			lines = append(lines, value)
+		}
+	}
+}
+
+// retryWatch reports whether a watch that failed with err is reconnected
+func retryWatch(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, ErrRevisionTooOld)
+	}
+	return apiErr.Code == CodeUnavailable || apiErr.Code == CodeInternal
+}
+
+func newWatchCommand(client *ClientOptions) *cobra.Command {
+	var prefixes []string
+	var revision uint64
+
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Stream changes of herds and slices",
+		Long: `Stream the changes of the keys under the given prefixes as JSON lines,
+one per event. Herds are stored under herds/<herd> and slices under
+slices/<herd>/<slice>. Without --revision the stream starts with the next
+change; a stream resumes after the revision of the last event it printed.`,
+		Example: `  barnctl watch --prefix herds/
+  barnctl watch --prefix slices/finance/ --revision 1042`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			watcher := NewClient(client.Endpoint).Watch(ctx, prefixes, revision)
+			defer watcher.Close()
+
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			for {
+				event, err := watcher.Next()
+				if err != nil {
+					if errors.Is(err, context.Canceled) {
+						return nil
+					}
+					return fmt.Errorf("watch failed after revision %d: %w", watcher.Revision(), err)
+				}
+				if err := encoder.Encode(event); err != nil {
+					return err
+				}
+			}
+		},
+	}
+	cmd.Flags().StringArrayVar(&prefixes, "prefix", []string{herdKeyPrefix}, "Key prefix to watch (repeatable)")
+	cmd.Flags().Uint64Var(&revision, "revision", 0, "First revision to stream")
+	return cmd
+}
@@ -0,0 +1,261 @@
+package barnctl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testWatchProgress = 20 * time.Millisecond
+
+func setWatchHistory(store *Store, limit int) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.historyLimit = limit
+}
+
+func nextEvents(t *testing.T, watcher *Watcher) []Event {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testWait)
+	defer cancel()
+	events, err := watcher.Next(ctx)
+	if err != nil {
+		t.Fatalf("Failed to watch: %v", err)
+	}
+	return events
+}
+
+// TestStoreWatch tests the events, cursors and compaction of store watches
+func TestStoreWatch(t *testing.T) {
+	c := newTestCluster(t, 1)
+	store := c.stores[c.leader()]
+	ctx := context.Background()
+
+	start := store.Revision() + 1
+	c.put("herds/finance", "1")
+	c.put("slices/finance/ledger", "2")
+	revision, err := store.Txn(ctx, nil, OpPut("herds/ops", []byte("3")), OpDelete("herds/finance"))
+	if err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+
+	watcher, err := store.Watch([]string{"herds/"}, start)
+	if err != nil {
+		t.Fatalf("Failed to watch: %v", err)
+	}
+	var got []string
+	for _, event := range nextEvents(t, watcher) {
+		got = append(got, fmt.Sprintf("%s %s %s", event.Type, event.Key, event.Value))
+	}
+	expected := []string{"put herds/finance 1", "put herds/ops 3", "delete herds/finance "}
+	if strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected events %q, got %q", expected, got)
+	}
+	if watcher.Revision() != revision {
+		t.Errorf("Expected cursor at revision %d, got %d", revision, watcher.Revision())
+	}
+
+	// Writes outside the prefixes advance the cursor without events
+	c.put("slices/finance/ledger", "4")
+	c.put("herds/ops", "5")
+	events := nextEvents(t, watcher)
+	if len(events) != 1 || events[0].Key != "herds/ops" || events[0].CreateRevision != revision {
+		t.Errorf("Expected update of herds/ops created at %d, got %+v", revision, events)
+	}
+
+	// Watches from 0 start with the next write
+	fromNow, _ := store.Watch([]string{"slices/"}, 0)
+	c.put("slices/finance/ledger", "6")
+	if events := nextEvents(t, fromNow); len(events) != 1 || string(events[0].Value) != "6" {
+		t.Errorf("Expected only the next write, got %+v", events)
+	}
+
+	// Watchers that fall behind the history fail, as do new watches
+	setWatchHistory(store, 3)
+	for i := 0; i < 5; i++ {
+		c.put(fmt.Sprintf("herds/h%d", i), "x")
+	}
+	if _, err := watcher.Next(ctx); !errors.Is(err, ErrRevisionTooOld) {
+		t.Errorf("Expected ErrRevisionTooOld for a lagging watcher, got %v", err)
+	}
+	_, err = store.Watch([]string{"herds/"}, start)
+	var tooOld *RevisionTooOldError
+	if !errors.As(err, &tooOld) || tooOld.Revision != start || tooOld.Compacted != store.Revision()-3 {
+		t.Errorf("Expected events up to %d compacted, got %v", store.Revision()-3, err)
+	}
+
+	// Restoring a snapshot compacts every event before it
+	current, _ := store.Watch([]string{"herds/"}, store.Revision()+1)
+	snapshot, _ := store.Snapshot()
+	if err := store.Restore(snapshot); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	if _, err := store.Watch([]string{"herds/"}, store.Revision()); !errors.Is(err, ErrRevisionTooOld) {
+		t.Errorf("Expected ErrRevisionTooOld before the snapshot, got %v", err)
+	}
+	c.put("herds/after", "y")
+	if events := nextEvents(t, current); len(events) != 1 || events[0].Key != "herds/after" {
+		t.Errorf("Expected watch at the snapshot revision to continue, got %+v", events)
+	}
+}
+
+// cutTransport breaks the first watch stream after limit bytes
+type cutTransport struct {
+	limit int
+
+	mu  sync.Mutex
+	cut bool
+}
+
+func (t *cutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.cut {
+		t.cut = true
+		resp.Body = &cutBody{ReadCloser: resp.Body, left: t.limit}
+	}
+	return resp, nil
+}
+
+type cutBody struct {
+	io.ReadCloser
+	left int
+}
+
+func (b *cutBody) Read(p []byte) (int, error) {
+	if b.left <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if len(p) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.left -= n
+	return n, err
+}
+
+// TestAPIWatch tests watch streams, their resumption and their errors
+func TestAPIWatch(t *testing.T) {
+	c, clients := newTestAPI(t, 1)
+	leader := c.leader()
+	api := clients[leader]
+	ctx := context.Background()
+
+	status, err := api.Status(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	herd, err := api.CreateHerd(ctx, &Herd{Name: "finance"})
+	if err != nil {
+		t.Fatalf("Failed to create herd: %v", err)
+	}
+	herd.Labels = map[string]string{"team": "fin"}
+	if herd, err = api.UpdateHerd(ctx, herd); err != nil {
+		t.Fatalf("Failed to update herd: %v", err)
+	}
+	if err := api.DeleteHerd(ctx, "finance", 0); err != nil {
+		t.Fatalf("Failed to delete herd: %v", err)
+	}
+
+	// The first stream breaks in the middle of the first event
+	flaky := NewClient(api.Endpoint)
+	flaky.HTTP = &http.Client{Transport: &cutTransport{limit: 100}}
+	watcher := flaky.Watch(ctx, []string{"herds/"}, status.Revision+1)
+	defer watcher.Close()
+
+	var types []string
+	for i := 0; i < 3; i++ {
+		event, err := watcher.Next()
+		if err != nil {
+			t.Fatalf("Failed to watch: %v", err)
+		}
+		types = append(types, event.Type)
+		if i == 1 {
+			watched := Herd{}
+			if err := json.Unmarshal(event.Value, &watched); err != nil || watched.Labels["team"] != "fin" {
+				t.Errorf("Expected the updated herd, got %s", event.Value)
+			}
+			if event.Revision != herd.Revision {
+				t.Errorf("Expected revision %d, got %d", herd.Revision, event.Revision)
+			}
+		}
+	}
+	if strings.Join(types, " ") != "put put delete" {
+		t.Errorf("Expected put put delete, got %v", types)
+	}
+
+	// A new watch resumes after the cursor
+	if _, err := api.CreateHerd(ctx, &Herd{Name: "ops"}); err != nil {
+		t.Fatalf("Failed to create herd: %v", err)
+	}
+	resumed := api.Watch(ctx, []string{"herds/"}, watcher.Revision()+1)
+	defer resumed.Close()
+	if event, err := resumed.Next(); err != nil || event.Key != "herds/ops" {
+		t.Errorf("Expected herds/ops after the cursor, got %+v, %v", event, err)
+	}
+
+	// Compacted revisions and invalid prefixes are not retried
+	setWatchHistory(c.stores[leader], 1)
+	if _, err := api.CreateHerd(ctx, &Herd{Name: "data"}); err != nil {
+		t.Fatalf("Failed to create herd: %v", err)
+	}
+	_, err = api.Watch(ctx, []string{"herds/"}, status.Revision+1).Next()
+	var tooOld *RevisionTooOldError
+	if !errors.As(err, &tooOld) || tooOld.Revision != status.Revision+1 {
+		t.Errorf("Expected RevisionTooOldError, got %v", err)
+	}
+	if _, err := api.Watch(ctx, []string{"secrets/"}, 0).Next(); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Expected ErrInvalid for a prefix outside herds and slices, got %v", err)
+	}
+}
+
+// TestAPIWatchProgress tests that idle streams report the revision, and
+// that they resume from the Last-Event-ID header
+func TestAPIWatchProgress(t *testing.T) {
+	c, clients := newTestAPI(t, 1)
+	api := clients[c.leader()]
+	ctx, cancel := context.WithTimeout(context.Background(), testWait)
+	defer cancel()
+
+	herd, err := api.CreateHerd(ctx, &Herd{Name: "finance"})
+	if err != nil {
+		t.Fatalf("Failed to create herd: %v", err)
+	}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, api.Endpoint+APIPrefix+"/watch?prefix=slices/", nil)
+	req.Header.Set("Last-Event-ID", fmt.Sprint(herd.Revision-1))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to watch: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %s", ct)
+	}
+
+	// The slice watch skips the herd write but reports its revision
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Stream ended before progress to revision %d: %v", herd.Revision, err)
+		}
+		if strings.TrimSpace(line) == fmt.Sprintf("id: %d", herd.Revision) {
+			if event, _ := reader.ReadString('\n'); strings.TrimSpace(event) != "event: progress" {
+				t.Errorf("Expected a progress event, got %q", event)
+			}
+			break
+		}
+	}
+}
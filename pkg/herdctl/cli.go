@@ -122,17 +122,6 @@ func newMetricsCommand() *cobra.Command {
 	}
 }
 
-func newPolicyCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "policy",
-		Short: "Manage herd RBAC policies",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// TODO: Implement policy management
-			return nil
-		},
-	}
-}
-
 func newRDFCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "rdf",
@@ -198,14 +187,3 @@ func newSignerCommand() *cobra.Command {
 		},
 	}
 }
-
-func newTokenCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "token",
-		Short: "Mint scoped tokens",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// TODO: Implement token minting
-			return nil
-		},
-	}
-}
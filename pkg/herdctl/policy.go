@@ -0,0 +1,572 @@
+package herdctl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// Effects of a policy
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// actionPartRE matches the verb and the kind of an action, as in
+// read:contracts
+var actionPartRE = regexp.MustCompile(`^(\*|[a-z][a-z0-9-]*)$`)
+
+// ErrDenied is returned by policy check for requests the policies deny
+var ErrDenied = errors.New("permission denied")
+
+// Policy grants or denies a role actions in a herd, the [[herd.rbac_policies]]
+// of a .herd file. Actions are verb:kind as in "read:contracts"; either half
+// may be "*", and "*" alone is every action. Resources narrow the policy to
+// kind/name patterns such as "contracts/ledger" or "pipelines/trades-*";
+// without them it covers every resource of the herd.
+type Policy struct {
+	Role      string   `json:"role"`
+	Effect    string   `json:"effect,omitempty"`
+	Actions   []string `json:"actions"`
+	Resources []string `json:"resources,omitempty"`
+}
+
+// Binding grants a role to subjects such as "user:alice", "group:data-eng"
+// or "service:ingest", the [[herd.role_bindings]] of a .herd file
+type Binding struct {
+	Role     string   `json:"role"`
+	Subjects []string `json:"subjects"`
+}
+
+// PolicySet is the policies and role bindings of a herd.
+// TokenLifetimeSeconds caps the lifetime of the herd's tokens, as the
+// token_lifetime_seconds of its [herd.secrets_scope]; 0 leaves the default.
+type PolicySet struct {
+	Herd     string    `json:"herd"`
+	Policies []Policy  `json:"policies"`
+	Bindings []Binding `json:"bindings"`
+
+	TokenLifetimeSeconds int `json:"tokenLifetimeSeconds,omitempty"`
+}
+
+// Request is an action a principal takes in a herd. Subjects are the
+// principal's user and groups, as in Binding; Roles are roles it holds
+// besides the ones bound to them. Resource is the kind/name of the one
+// resource acted on, or empty for an action on every resource of the kind,
+// such as listing them.
+type Request struct {
+	Subjects []string `json:"subjects,omitempty"`
+	Roles    []string `json:"roles,omitempty"`
+	Action   string   `json:"action"`
+	Resource string   `json:"resource,omitempty"`
+}
+
+// Decision is the outcome of a request. Explain has a line for each policy
+// of the principal's roles, saying whether and why it applied.
+type Decision struct {
+	Allowed bool          `json:"allowed"`
+	Reason  string        `json:"reason"`
+	Roles   []string      `json:"roles"`
+	Explain []PolicyMatch `json:"explain,omitempty"`
+}
+
+// PolicyMatch is how a policy applies to a request. Action and Resource are
+// the patterns that matched.
+type PolicyMatch struct {
+	Role     string `json:"role"`
+	Effect   string `json:"effect"`
+	Matched  bool   `json:"matched"`
+	Action   string `json:"action,omitempty"`
+	Resource string `json:"resource,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+// Evaluate decides the request. Policies apply through the roles bound to
+// the request's subjects; an explicit deny wins over any allow, and
+// requests no policy allows are denied.
+func (p *PolicySet) Evaluate(req Request) Decision {
+	decision := Decision{}
+	roles := make(map[string]bool)
+	for _, role := range append(p.Roles(req.Subjects), req.Roles...) {
+		if !roles[role] {
+			roles[role] = true
+			decision.Roles = append(decision.Roles, role)
+		}
+	}
+	if len(decision.Roles) == 0 {
+		decision.Reason = fmt.Sprintf("no role in herd %s is bound to %s", p.Herd, strings.Join(req.Subjects, ", "))
+		return decision
+	}
+	sort.Strings(decision.Roles)
+
+	allowed, denied := -1, -1
+	for _, policy := range p.Policies {
+		if !roles[policy.Role] {
+			continue
+		}
+		match := policy.match(req)
+		switch {
+		case !match.Matched:
+		case match.Effect == EffectDeny && denied < 0:
+			denied = len(decision.Explain)
+		case match.Effect == EffectAllow && allowed < 0:
+			allowed = len(decision.Explain)
+		}
+		decision.Explain = append(decision.Explain, match)
+	}
+
+	switch {
+	case denied >= 0:
+		match := decision.Explain[denied]
+		decision.Reason = fmt.Sprintf("denied by role %s: %s", match.Role, match.Reason)
+	case allowed >= 0:
+		match := decision.Explain[allowed]
+		decision.Allowed = true
+		decision.Reason = fmt.Sprintf("allowed by role %s: %s", match.Role, match.Reason)
+	default:
+		decision.Reason = fmt.Sprintf("no policy of role %s allows %s", strings.Join(decision.Roles, ", "), describeRequest(req))
+	}
+	return decision
+}
+
+// Roles returns the sorted roles bound to any of subjects
+func (p *PolicySet) Roles(subjects []string) []string {
+	var roles []string
+	for _, binding := range p.Bindings {
+	bound:
+		for _, subject := range binding.Subjects {
+			for _, s := range subjects {
+				if s == subject {
+					roles = append(roles, binding.Role)
+					break bound
+				}
+			}
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// match reports whether the policy covers the request
+func (p Policy) match(req Request) PolicyMatch {
+	match := PolicyMatch{Role: p.Role, Effect: p.effect()}
+	for _, pattern := range p.Actions {
+		if MatchAction(pattern, req.Action) {
+			match.Action = pattern
+			break
+		}
+	}
+	if match.Action == "" {
+		match.Reason = fmt.Sprintf("none of %s is %s", strings.Join(p.Actions, ", "), req.Action)
+		return match
+	}
+
+	if len(p.Resources) > 0 {
+		if req.Resource == "" {
+			match.Reason = fmt.Sprintf("%s is scoped to %s, not every resource", match.Action, strings.Join(p.Resources, ", "))
+			return match
+		}
+		for _, pattern := range p.Resources {
+			if MatchResource(pattern, req.Resource) {
+				match.Resource = pattern
+				break
+			}
+		}
+		if match.Resource == "" {
+			match.Reason = fmt.Sprintf("%s is not in %s", req.Resource, strings.Join(p.Resources, ", "))
+			return match
+		}
+	}
+
+	match.Matched = true
+	match.Reason = fmt.Sprintf("%s %s", p.effect(), match.Action)
+	if match.Resource != "" {
+		match.Reason += " on " + match.Resource
+	}
+	return match
+}
+
+func (p Policy) effect() string {
+	if p.Effect == "" {
+		return EffectAllow
+	}
+	return p.Effect
+}
+
+func describeRequest(req Request) string {
+	if req.Resource == "" {
+		return req.Action
+	}
+	return req.Action + " on " + req.Resource
+}
+
+// MatchAction reports whether the action pattern covers action
+func MatchAction(pattern, action string) bool {
+	if pattern == "*" || pattern == action {
+		return true
+	}
+	patternVerb, patternKind, ok := strings.Cut(pattern, ":")
+	if !ok {
+		return false
+	}
+	verb, kind, ok := strings.Cut(action, ":")
+	if !ok {
+		return false
+	}
+	return (patternVerb == "*" || patternVerb == verb) && (patternKind == "*" || patternKind == kind)
+}
+
+// MatchResource reports whether the kind/name pattern covers resource.
+// Patterns use path.Match syntax within each half.
+func MatchResource(pattern, resource string) bool {
+	matched, err := path.Match(pattern, resource)
+	return err == nil && matched
+}
+
+// ValidateAction checks an action or action pattern
+func ValidateAction(action string) error {
+	if action == "*" {
+		return nil
+	}
+	verb, kind, ok := strings.Cut(action, ":")
+	if !ok || !actionPartRE.MatchString(verb) || !actionPartRE.MatchString(kind) {
+		return fmt.Errorf("%q is not an action such as read:contracts", action)
+	}
+	return nil
+}
+
+// ValidateResource checks a resource or resource pattern
+func ValidateResource(resource string) error {
+	kind, name, ok := strings.Cut(resource, "/")
+	if !ok || kind == "" || name == "" || strings.Contains(name, "/") || strings.ContainsAny(resource, " \t\n") {
+		return fmt.Errorf("%q is not a resource such as contracts/ledger", resource)
+	}
+	if _, err := path.Match(resource, ""); err != nil {
+		return fmt.Errorf("%q is not a valid pattern: %w", resource, err)
+	}
+	return nil
+}
+
+// Validate checks a policy's effect, actions and resources
+func (p Policy) Validate() error {
+	if strings.TrimSpace(p.Role) == "" {
+		return errors.New("role is required")
+	}
+	if p.Effect != "" && p.Effect != EffectAllow && p.Effect != EffectDeny {
+		return fmt.Errorf("effect %q is not %s or %s", p.Effect, EffectAllow, EffectDeny)
+	}
+	if len(p.Actions) == 0 {
+		return errors.New("at least one action is required")
+	}
+	for _, action := range p.Actions {
+		if err := ValidateAction(action); err != nil {
+			return err
+		}
+	}
+	for _, resource := range p.Resources {
+		if err := ValidateResource(resource); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks the policies and bindings
+func (p *PolicySet) Validate() error {
+	if p.TokenLifetimeSeconds < 0 {
+		return errors.New("token lifetime must not be negative")
+	}
+	for i, policy := range p.Policies {
+		if err := policy.Validate(); err != nil {
+			return fmt.Errorf("policy %d: %w", i+1, err)
+		}
+	}
+	for _, binding := range p.Bindings {
+		if strings.TrimSpace(binding.Role) == "" {
+			return errors.New("role binding without a role")
+		}
+		for _, subject := range binding.Subjects {
+			kind, name, ok := strings.Cut(subject, ":")
+			if !ok || name == "" || (kind != "user" && kind != "group" && kind != "service") {
+				return fmt.Errorf("role binding %s: %q is not user:, group: or service:<name>", binding.Role, subject)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadPolicySet reads the policies, role bindings and token lifetime of a
+// .herd file, or of a herd as JSON in the form the Barn API returns it
+func LoadPolicySet(file string) (*PolicySet, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var set *PolicySet
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		herd := struct {
+			Name     string    `json:"name"`
+			RBAC     []Binding `json:"rbac"`
+			Policies []Policy  `json:"policies"`
+			Secrets  struct {
+				TokenLifetimeSeconds int `json:"tokenLifetimeSeconds"`
+			} `json:"secretsScope"`
+		}{}
+		if err := json.Unmarshal(data, &herd); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+		set = &PolicySet{Herd: herd.Name, Policies: herd.Policies, Bindings: herd.RBAC, TokenLifetimeSeconds: herd.Secrets.TokenLifetimeSeconds}
+	} else if set, err = ParseHerdPolicies(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	if err := set.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid policies in %s: %w", file, err)
+	}
+	return set, nil
+}
+
+// ParseHerdPolicies reads the id, [[herd.rbac_policies]],
+// [[herd.role_bindings]] and token lifetime of a .herd file. Other tables
+// are skipped.
+func ParseHerdPolicies(r io.Reader) (*PolicySet, error) {
+	set := &PolicySet{}
+	scanner := bufio.NewScanner(r)
+	table := ""
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(stripComment(scanner.Text()))
+
+		// Arrays may span lines
+		for strings.Count(text, "[") > strings.Count(text, "]") && scanner.Scan() {
+			line++
+			text += " " + strings.TrimSpace(stripComment(scanner.Text()))
+		}
+
+		switch {
+		case text == "":
+			continue
+		case strings.HasPrefix(text, "[["):
+			table = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(text, "[["), "]]"))
+			switch table {
+			case "herd.rbac_policies":
+				set.Policies = append(set.Policies, Policy{})
+			case "herd.role_bindings":
+				set.Bindings = append(set.Bindings, Binding{})
+			}
+			continue
+		case strings.HasPrefix(text, "["):
+			table = strings.TrimSpace(strings.Trim(text, "[]"))
+			continue
+		}
+
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", line)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		var err error
+		switch table {
+		case "herd":
+			if key == "id" {
+				set.Herd, err = parseString(value)
+			}
+		case "herd.secrets_scope":
+			if key == "token_lifetime_seconds" {
+				if set.TokenLifetimeSeconds, err = strconv.Atoi(value); err != nil {
+					err = fmt.Errorf("%s is not an integer", value)
+				}
+			}
+		case "herd.rbac_policies":
+			policy := &set.Policies[len(set.Policies)-1]
+			switch key {
+			case "role":
+				policy.Role, err = parseString(value)
+			case "effect":
+				policy.Effect, err = parseString(value)
+			case "actions":
+				policy.Actions, err = parseStrings(value)
+			case "resources":
+				policy.Resources, err = parseStrings(value)
+			default:
+				err = fmt.Errorf("unknown key %s", key)
+			}
+		case "herd.role_bindings":
+			binding := &set.Bindings[len(set.Bindings)-1]
+			switch key {
+			case "role":
+				binding.Role, err = parseString(value)
+			case "subjects":
+				binding.Subjects, err = parseStrings(value)
+			default:
+				err = fmt.Errorf("unknown key %s", key)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return set, scanner.Err()
+}
+
+// stripComment removes a # comment that is not inside a string
+func stripComment(line string) string {
+	quote := rune(0)
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseString reads a basic "..." or literal '...' string
+func parseString(value string) (string, error) {
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return value[1 : len(value)-1], nil
+	}
+	s, err := strconv.Unquote(value)
+	if err != nil || value[0] != '"' {
+		return "", fmt.Errorf("%s is not a string", value)
+	}
+	return s, nil
+}
+
+// parseStrings reads an array of strings
+func parseStrings(value string) ([]string, error) {
+	if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+		return nil, fmt.Errorf("%s is not an array", value)
+	}
+	var values []string
+	rest := strings.TrimSpace(value[1 : len(value)-1])
+	for rest != "" {
+		end := 1
+		if quote := rest[0]; quote == '"' || quote == '\'' {
+			for end < len(rest) && (rest[end] != quote || (quote == '"' && rest[end-1] == '\\')) {
+				end++
+			}
+			end++
+		}
+		if end > len(rest) {
+			return nil, fmt.Errorf("%s has an unterminated string", value)
+		}
+		s, err := parseString(rest[:end])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, s)
+		rest = strings.TrimSpace(rest[end:])
+		if rest != "" && rest[0] != ',' {
+			return nil, fmt.Errorf("%s is not an array of strings", value)
+		}
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, ","))
+	}
+	return values, nil
+}
+
+func newPolicyCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "policy",
+		Short: "Manage herd RBAC policies",
+	}
+	cmd.AddCommand(newPolicyCheckCommand())
+	return cmd
+}
+
+func newPolicyCheckCommand() *cobra.Command {
+	var file string
+	var subjects, roles []string
+	var explain, asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "check ACTION [RESOURCE]",
+		Short: "Check whether subjects may take an action in a herd",
+		Long: `Check an action such as read:contracts, optionally on one resource such as
+contracts/ledger, against the policies and role bindings of a herd. The
+herd is read from a .herd file or from its JSON as returned by Barn. The
+subjects hold the roles bound to them and those given with --role. Deny
+policies win over allow policies, and anything not allowed is denied. The
+command fails if the action is denied; --explain shows how each policy of
+the roles applied.`,
+		Example: `  herdctl policy check --file finance.herd --role finance-analyst read:contracts contracts/ledger --explain`,
+		Args:    cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := ValidateAction(args[0]); err != nil || strings.Contains(args[0], "*") {
+				return fmt.Errorf("invalid action %q: expected verb:kind such as read:contracts", args[0])
+			}
+			if len(subjects) == 0 && len(roles) == 0 {
+				return errors.New("a --subject or --role is required")
+			}
+			req := Request{Subjects: subjects, Roles: roles, Action: args[0]}
+			if len(args) == 2 {
+				if err := ValidateResource(args[1]); err != nil || strings.ContainsAny(args[1], "*?[") {
+					return fmt.Errorf("invalid resource %q: expected kind/name such as contracts/ledger", args[1])
+				}
+				req.Resource = args[1]
+			}
+			set, err := LoadPolicySet(file)
+			if err != nil {
+				return err
+			}
+			decision := set.Evaluate(req)
+
+			out := cmd.OutOrStdout()
+			if asJSON {
+				if !explain {
+					decision.Explain = nil
+				}
+				encoder := json.NewEncoder(out)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(decision); err != nil {
+					return err
+				}
+			} else {
+				result := "allowed"
+				if !decision.Allowed {
+					result = "denied"
+				}
+				fmt.Fprintf(out, "%s: %s\n", result, decision.Reason)
+				if explain && len(decision.Explain) > 0 {
+					tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+					fmt.Fprintln(tw, "ROLE\tEFFECT\tMATCHED\tREASON")
+					for _, match := range decision.Explain {
+						fmt.Fprintf(tw, "%s\t%s\t%t\t%s\n", match.Role, match.Effect, match.Matched, match.Reason)
+					}
+					if err := tw.Flush(); err != nil {
+						return err
+					}
+				}
+			}
+			if !decision.Allowed {
+				return fmt.Errorf("%w: %s", ErrDenied, describeRequest(req))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&file, "file", "f", "", "Herd file or herd JSON with the policies")
+	cmd.Flags().StringArrayVar(&subjects, "subject", nil, "Subject making the request, e.g. user:alice or group:data-eng (repeatable)")
+	cmd.Flags().StringArrayVar(&roles, "role", nil, "Role held besides the roles bound to the subjects (repeatable)")
+	cmd.Flags().BoolVar(&explain, "explain", false, "Show how each policy of the roles applied")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print the decision as JSON")
+	cmd.MarkFlagRequired("file")
+	return cmd
+}
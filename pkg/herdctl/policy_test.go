@@ -0,0 +1,110 @@
+package herdctl
+
+import (
+	"strings"
+	"testing"
+)
+
+const testHerdFile = `
+[herd]
+id = "finance"  # the herd
+
+[herd.labels]
+compliance = ["SOX", "GDPR"]
+
+[herd.secrets_scope]
+token_lifetime_seconds = 900
+
+[[herd.rbac_policies]]
+role = "finance-analyst"
+actions = ["read:*", "execute:pipelines"]
+
+[[herd.rbac_policies]]
+role = "finance-analyst"
+effect = "deny"
+actions = ["read:contracts"]
+resources = ["contracts/payroll-*"]
+
+[[herd.rbac_policies]]
+role = "ledger-operator"
+actions = [
+  "execute:pipelines",
+  "write:pipelines",
+]
+resources = ["pipelines/ledger"]
+
+[[herd.role_bindings]]
+role = "finance-analyst"
+subjects = ["user:alice", "group:finance-ops"]
+
+[[herd.role_bindings]]
+role = "ledger-operator"
+subjects = ["user:bob"]
+`
+
+// TestPolicySet tests deny precedence, wildcards and resource scoping
+func TestPolicySet(t *testing.T) {
+	set, err := ParseHerdPolicies(strings.NewReader(testHerdFile))
+	if err != nil {
+		t.Fatalf("Failed to parse herd file: %v", err)
+	}
+	if err := set.Validate(); err != nil {
+		t.Fatalf("Invalid policies: %v", err)
+	}
+	if set.Herd != "finance" || set.TokenLifetimeSeconds != 900 || len(set.Policies) != 3 || len(set.Bindings) != 2 || len(set.Policies[2].Actions) != 2 {
+		t.Fatalf("Unexpected policies %+v", set)
+	}
+
+	tests := []struct {
+		name    string
+		req     Request
+		allowed bool
+		reason  string
+	}{
+		{"wildcard verb", Request{Subjects: []string{"user:carol", "group:finance-ops"}, Action: "read:lineage"}, true, "allowed by role finance-analyst: allow read:*"},
+		{"scoped deny", Request{Subjects: []string{"user:alice"}, Action: "read:contracts", Resource: "contracts/payroll-2026"}, false, "denied by role finance-analyst: deny read:contracts on contracts/payroll-*"},
+		{"outside the deny", Request{Subjects: []string{"user:alice"}, Action: "read:contracts", Resource: "contracts/ledger"}, true, "allowed by role finance-analyst"},
+		{"scoped allow", Request{Subjects: []string{"user:bob"}, Action: "write:pipelines", Resource: "pipelines/ledger"}, true, "allow write:pipelines on pipelines/ledger"},
+		{"other resource", Request{Subjects: []string{"user:bob"}, Action: "write:pipelines", Resource: "pipelines/payroll"}, false, "no policy of role ledger-operator allows write:pipelines on pipelines/payroll"},
+		{"every resource", Request{Subjects: []string{"user:bob"}, Action: "execute:pipelines"}, false, "no policy of role ledger-operator"},
+		{"unbound", Request{Subjects: []string{"user:mallory"}, Action: "read:contracts"}, false, "no role in herd finance is bound to user:mallory"},
+		{"role", Request{Roles: []string{"ledger-operator"}, Action: "execute:pipelines", Resource: "pipelines/ledger"}, true, "allowed by role ledger-operator"},
+	}
+	for _, test := range tests {
+		decision := set.Evaluate(test.req)
+		if decision.Allowed != test.allowed || !strings.Contains(decision.Reason, test.reason) {
+			t.Errorf("%s: expected %t, %q, got %t, %q", test.name, test.allowed, test.reason, decision.Allowed, decision.Reason)
+		}
+	}
+
+	// Alice holds the analyst role only, and both its policies are explained
+	decision := set.Evaluate(Request{Subjects: []string{"user:alice"}, Action: "read:contracts", Resource: "contracts/payroll-2026"})
+	if len(decision.Roles) != 1 || len(decision.Explain) != 2 || !decision.Explain[0].Matched || decision.Explain[0].Action != "read:*" {
+		t.Errorf("Unexpected explanation %+v", decision)
+	}
+}
+
+// TestPolicyValidate tests the form of actions and resources
+func TestPolicyValidate(t *testing.T) {
+	for _, action := range []string{"*", "read:contracts", "*:pipelines", "read:*", "manage:herd"} {
+		if err := ValidateAction(action); err != nil {
+			t.Errorf("%s: %v", action, err)
+		}
+	}
+	for _, action := range []string{"", "read", "Read:contracts", "read:contracts:x", "read:"} {
+		if err := ValidateAction(action); err == nil {
+			t.Errorf("%q: expected an error", action)
+		}
+	}
+	for _, resource := range []string{"", "contracts", "contracts/", "contracts/a/b", "contracts/[a"} {
+		if err := ValidateResource(resource); err == nil {
+			t.Errorf("%q: expected an error", resource)
+		}
+	}
+	if err := (Policy{Role: "analyst", Effect: "maybe", Actions: []string{"read:*"}}).Validate(); err == nil {
+		t.Error("Expected an unknown effect to fail")
+	}
+	if _, err := ParseHerdPolicies(strings.NewReader("[[herd.rbac_policies]]\nrole = \"analyst\"\nverbs = [\"get\"]\n")); err == nil {
+		t.Error("Expected an unknown policy key to fail")
+	}
+}
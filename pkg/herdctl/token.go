@@ -0,0 +1,661 @@
+package herdctl
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Signing algorithms of tokens
+const (
+	AlgEdDSA = "EdDSA"
+	AlgHS256 = "HS256"
+)
+
+const (
+	// DefaultTokenLifetime caps the lifetime of tokens whose herd sets no
+	// token_lifetime_seconds, and of tokens without a herd
+	DefaultTokenLifetime = time.Hour
+
+	// TokenIssuer is the iss claim of the tokens herdctl mints
+	TokenIssuer = "runink"
+
+	// tokenLeeway absorbs clock skew between the minter and the verifier
+	tokenLeeway = 30 * time.Second
+
+	// hs256KeySize is the size of generated HS256 secrets, and the least
+	// accepted
+	hs256KeySize = 32
+)
+
+// Token key file kinds, the first word of a key file
+const (
+	keyKindEd25519Private = "ed25519-private"
+	keyKindEd25519Public  = "ed25519-public"
+	keyKindHS256          = "hs256"
+)
+
+var (
+	// ErrTokenInvalid is returned for tokens that are malformed, signed
+	// with an unknown key or not yet valid
+	ErrTokenInvalid = errors.New("invalid token")
+
+	// ErrTokenExpired is returned for tokens past their expiry
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// Claims are the claims of a token. A token for a herd holds Role in it,
+// narrowed to the contracts and pipelines in Contracts and Pipelines if
+// set; names may be path.Match patterns. A token without a herd only
+// proves its subject, to which the herds' role bindings then apply.
+type Claims struct {
+	ID        string `json:"jti"`
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+
+	Herd      string   `json:"herd,omitempty"`
+	Role      string   `json:"role,omitempty"`
+	Contracts []string `json:"contracts,omitempty"`
+	Pipelines []string `json:"pipelines,omitempty"`
+}
+
+// Lifetime is how long the token was minted for
+func (c *Claims) Lifetime() time.Duration {
+	return time.Duration(c.ExpiresAt-c.IssuedAt) * time.Second
+}
+
+// Expires returns when the token expires
+func (c *Claims) Expires() time.Time {
+	return time.Unix(c.ExpiresAt, 0).UTC()
+}
+
+// Covers checks that the token's scopes reach resource, kind/name, in herd
+// for action. Without a resource, a token scoped to some resources of the
+// action's kind does not cover them all.
+func (c *Claims) Covers(herd, action, resource string) error {
+	if c.Herd == "" {
+		return nil
+	}
+	if herd != c.Herd {
+		return fmt.Errorf("the token is for herd %s", c.Herd)
+	}
+	_, kind, _ := strings.Cut(action, ":")
+	if resource != "" {
+		kind, _, _ = strings.Cut(resource, "/")
+	}
+	var scope []string
+	switch kind {
+	case "contracts":
+		scope = c.Contracts
+	case "pipelines":
+		scope = c.Pipelines
+	}
+	if len(scope) == 0 {
+		return nil
+	}
+	if resource == "" {
+		return fmt.Errorf("the token is scoped to %s %s, not every one", kind, strings.Join(scope, ", "))
+	}
+	for _, pattern := range scope {
+		if MatchResource(kind+"/"+pattern, resource) {
+			return nil
+		}
+	}
+	return fmt.Errorf("the token is scoped to %s %s", kind, strings.Join(scope, ", "))
+}
+
+// Validate checks the claims a token needs besides its times
+func (c *Claims) Validate() error {
+	switch {
+	case c.ID == "":
+		return errors.New("jti is required")
+	case c.Issuer != TokenIssuer:
+		return fmt.Errorf("issuer %q is not %s", c.Issuer, TokenIssuer)
+	case !validSubject(c.Subject):
+		return fmt.Errorf("subject %q is not user: or service:<name>", c.Subject)
+	case (c.Herd == "") != (c.Role == ""):
+		return errors.New("herd and role go together")
+	case c.Herd == "" && (len(c.Contracts) > 0 || len(c.Pipelines) > 0):
+		return errors.New("contract and pipeline scopes need a herd")
+	case c.ExpiresAt <= c.IssuedAt:
+		return errors.New("exp must be after iat")
+	}
+	for _, name := range append(append([]string(nil), c.Contracts...), c.Pipelines...) {
+		if err := ValidateResource("scope/" + name); err != nil {
+			return fmt.Errorf("invalid scope %q", name)
+		}
+	}
+	return nil
+}
+
+// validSubject reports whether subject names a user or a service; groups
+// cannot hold tokens
+func validSubject(subject string) bool {
+	kind, name, ok := strings.Cut(subject, ":")
+	return ok && name != "" && !strings.ContainsAny(name, " \t\n") && (kind == "user" || kind == "service")
+}
+
+// TokenKey signs or verifies tokens. EdDSA keys sign with their Ed25519
+// private key, and verify with the public key alone; HS256 keys do both
+// with a shared secret.
+type TokenKey struct {
+	Alg string
+
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+	secret  []byte
+}
+
+// GenerateTokenKey returns a new key for alg
+func GenerateTokenKey(alg string) (*TokenKey, error) {
+	switch alg {
+	case AlgEdDSA:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return &TokenKey{Alg: alg, private: private, public: public}, nil
+	case AlgHS256:
+		secret := make([]byte, hs256KeySize)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		return &TokenKey{Alg: alg, secret: secret}, nil
+	}
+	return nil, fmt.Errorf("unknown token algorithm %q: expected %s or %s", alg, AlgEdDSA, AlgHS256)
+}
+
+// LoadTokenKey reads a key file: its kind, ed25519-private, ed25519-public
+// or hs256, and the hex key
+func LoadTokenKey(file string) (*TokenKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParseTokenKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid token key %s: %w", file, err)
+	}
+	return key, nil
+}
+
+// ParseTokenKey parses the contents of a key file
+func ParseTokenKey(text string) (*TokenKey, error) {
+	fields := strings.Fields(text)
+	if len(fields) != 2 {
+		return nil, errors.New("expected the key kind and the hex key")
+	}
+	raw, err := hex.DecodeString(fields[1])
+	if err != nil {
+		return nil, errors.New("the key is not hex")
+	}
+	switch fields[0] {
+	case keyKindEd25519Private:
+		if len(raw) != ed25519.SeedSize {
+			return nil, fmt.Errorf("ed25519 private key is %d bytes, not %d", len(raw), ed25519.SeedSize)
+		}
+		private := ed25519.NewKeyFromSeed(raw)
+		return &TokenKey{Alg: AlgEdDSA, private: private, public: private.Public().(ed25519.PublicKey)}, nil
+	case keyKindEd25519Public:
+		if len(raw) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("ed25519 public key is %d bytes, not %d", len(raw), ed25519.PublicKeySize)
+		}
+		return &TokenKey{Alg: AlgEdDSA, public: ed25519.PublicKey(raw)}, nil
+	case keyKindHS256:
+		if len(raw) < hs256KeySize {
+			return nil, fmt.Errorf("hs256 secret is %d bytes, at least %d are needed", len(raw), hs256KeySize)
+		}
+		return &TokenKey{Alg: AlgHS256, secret: raw}, nil
+	}
+	return nil, fmt.Errorf("unknown key kind %q", fields[0])
+}
+
+// String returns the key in the key file format. It includes the private
+// key or secret, if the key has one.
+func (k *TokenKey) String() string {
+	switch {
+	case k.secret != nil:
+		return keyKindHS256 + " " + hex.EncodeToString(k.secret) + "\n"
+	case k.private != nil:
+		return keyKindEd25519Private + " " + hex.EncodeToString(k.private.Seed()) + "\n"
+	}
+	return keyKindEd25519Public + " " + hex.EncodeToString(k.public) + "\n"
+}
+
+// Public returns the part of the key that verifies tokens. For HS256
+// that is the secret itself.
+func (k *TokenKey) Public() *TokenKey {
+	if k.Alg == AlgEdDSA {
+		return &TokenKey{Alg: k.Alg, public: k.public}
+	}
+	return k
+}
+
+// ID is the key ID in the headers of the tokens the key signs, derived from
+// the public key or the secret
+func (k *TokenKey) ID() string {
+	material := []byte(k.public)
+	if k.Alg == AlgHS256 {
+		material = append([]byte("hs256:"), k.secret...)
+	}
+	sum := sha256.Sum256(material)
+	return hex.EncodeToString(sum[:8])
+}
+
+// CanSign reports whether the key has its private part
+func (k *TokenKey) CanSign() bool {
+	return k.private != nil || k.secret != nil
+}
+
+func (k *TokenKey) sign(input []byte) []byte {
+	if k.Alg == AlgHS256 {
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return mac.Sum(nil)
+	}
+	return ed25519.Sign(k.private, input)
+}
+
+func (k *TokenKey) verify(input, signature []byte) bool {
+	if k.Alg == AlgHS256 {
+		return hmac.Equal(k.sign(input), signature)
+	}
+	return ed25519.Verify(k.public, input, signature)
+}
+
+// tokenHeader is the JOSE header of a token
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+// Sign returns the claims as a compact JWT signed with the key
+func (k *TokenKey) Sign(claims *Claims) (string, error) {
+	if !k.CanSign() {
+		return "", errors.New("the token key is a public key, it cannot sign")
+	}
+	header, err := json.Marshal(tokenHeader{Alg: k.Alg, Typ: "JWT", Kid: k.ID()})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return input + "." + base64.RawURLEncoding.EncodeToString(k.sign([]byte(input))), nil
+}
+
+// TokenRequest is what a minted token is for. Lifetime defaults to, and
+// may not exceed, MaxLifetime, which defaults to DefaultTokenLifetime.
+type TokenRequest struct {
+	Subject     string
+	Herd        string
+	Role        string
+	Contracts   []string
+	Pipelines   []string
+	Lifetime    time.Duration
+	MaxLifetime time.Duration
+}
+
+// MintToken returns a token for the request, signed with key, and its
+// claims
+func MintToken(key *TokenKey, req TokenRequest, now time.Time) (string, *Claims, error) {
+	maxLifetime := req.MaxLifetime
+	if maxLifetime <= 0 {
+		maxLifetime = DefaultTokenLifetime
+	}
+	lifetime := req.Lifetime
+	if lifetime == 0 {
+		lifetime = maxLifetime
+	}
+	if lifetime < time.Second || lifetime > maxLifetime {
+		return "", nil, fmt.Errorf("token lifetime %s is not between 1s and %s", lifetime, maxLifetime)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+	claims := &Claims{
+		ID:        hex.EncodeToString(id),
+		Issuer:    TokenIssuer,
+		Subject:   req.Subject,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(lifetime).Unix(),
+		Herd:      req.Herd,
+		Role:      req.Role,
+		Contracts: req.Contracts,
+		Pipelines: req.Pipelines,
+	}
+	if err := claims.Validate(); err != nil {
+		return "", nil, err
+	}
+	token, err := key.Sign(claims)
+	return token, claims, err
+}
+
+// TokenVerifier verifies tokens signed with any of its keys
+type TokenVerifier struct {
+	keys map[string]*TokenKey
+}
+
+// NewTokenVerifier returns a verifier of the tokens keys signed
+func NewTokenVerifier(keys ...*TokenKey) *TokenVerifier {
+	v := &TokenVerifier{keys: make(map[string]*TokenKey, len(keys))}
+	for _, key := range keys {
+		v.keys[key.ID()] = key.Public()
+	}
+	return v
+}
+
+// Verify checks the token's signature, claims and validity at now, and
+// returns its claims. It does not know about revocations or the lifetime
+// the token's herd allows; that is up to the caller.
+func (v *TokenVerifier) Verify(token string, now time.Time) (*Claims, error) {
+	header, claims, input, signature, err := splitToken(token)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := v.keys[header.Kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: signed with unknown key %q", ErrTokenInvalid, header.Kid)
+	}
+	// The key decides the algorithm, so a public key is never taken for
+	// an HMAC secret
+	if header.Alg != key.Alg || !key.verify(input, signature) {
+		return nil, fmt.Errorf("%w: bad signature", ErrTokenInvalid)
+	}
+	if err := claims.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTokenInvalid, err)
+	}
+	if now.Add(tokenLeeway).Unix() < claims.IssuedAt {
+		return nil, fmt.Errorf("%w: issued in the future", ErrTokenInvalid)
+	}
+	if now.Add(-tokenLeeway).Unix() >= claims.ExpiresAt {
+		return nil, fmt.Errorf("%w at %s", ErrTokenExpired, claims.Expires().Format(time.RFC3339))
+	}
+	return claims, nil
+}
+
+// ParseTokenUnverified returns the claims of a token without checking its
+// signature, to tell what a token is for
+func ParseTokenUnverified(token string) (*Claims, error) {
+	_, claims, _, _, err := splitToken(token)
+	return claims, err
+}
+
+func splitToken(token string) (*tokenHeader, *Claims, []byte, []byte, error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil, nil, nil, nil, fmt.Errorf("%w: not a JWT", ErrTokenInvalid)
+	}
+	decoded := make([][]byte, 3)
+	for i, part := range parts {
+		var err error
+		if decoded[i], err = base64.RawURLEncoding.DecodeString(part); err != nil {
+			return nil, nil, nil, nil, fmt.Errorf("%w: not a JWT", ErrTokenInvalid)
+		}
+	}
+	header := &tokenHeader{}
+	if err := json.Unmarshal(decoded[0], header); err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("%w: bad header", ErrTokenInvalid)
+	}
+	claims := &Claims{}
+	decoder := json.NewDecoder(bytes.NewReader(decoded[1]))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(claims); err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("%w: bad claims", ErrTokenInvalid)
+	}
+	input := []byte(parts[0] + "." + parts[1])
+	return header, claims, input, decoded[2], nil
+}
+
+// ReadTokenFile reads a token from file, as written by token mint
+func ReadTokenFile(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// TokenTransport adds a bearer token to the requests it sends through
+// Base, or http.DefaultTransport, including redirected ones. The token is
+// Token, or read from TokenFile for each request so that a rotated token
+// is picked up.
+type TokenTransport struct {
+	Token     string
+	TokenFile string
+	Base      http.RoundTripper
+}
+
+func (t *TokenTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	token := t.Token
+	if t.TokenFile != "" {
+		var err error
+		if token, err = ReadTokenFile(t.TokenFile); err != nil {
+			return nil, err
+		}
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+token)
+	return base.RoundTrip(r)
+}
+
+// BearerToken returns the bearer token of a request, or ""
+func BearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+func newTokenCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "token",
+		Short: "Mint scoped tokens",
+	}
+	cmd.AddCommand(newTokenKeygenCommand(), newMintCommand("mint"), newTokenVerifyCommand())
+	return cmd
+}
+
+// NewMintTokenCommand returns runi mint-token, the same as herdctl token
+// mint
+func NewMintTokenCommand() *cobra.Command {
+	return newMintCommand("mint-token")
+}
+
+func newMintCommand(use string) *cobra.Command {
+	var keyFile, herdFile, out string
+	req := TokenRequest{}
+
+	cmd := &cobra.Command{
+		Use:   use + " --key-file FILE [--herd HERD --role ROLE]",
+		Short: "Mint a short-lived token scoped to a herd role, contracts and pipelines",
+		Long: `Mint a signed JWT for CI jobs and agents. A token for a herd holds one role
+in it, optionally narrowed to contracts and pipelines; a token without a
+herd only proves its subject. The lifetime defaults to and is capped by
+the herd's token_lifetime_seconds, read from --file, and is otherwise at
+most an hour. The Barn checks the cap against the herd again.`,
+		Example: `  runi mint-token --key-file token.key --file finance.herd --role finance-admin --pipeline ledger-daily --subject service:ci-ledger`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := LoadTokenKey(keyFile)
+			if err != nil {
+				return err
+			}
+			if herdFile != "" {
+				set, err := LoadPolicySet(herdFile)
+				if err != nil {
+					return err
+				}
+				if req.Herd == "" {
+					req.Herd = set.Herd
+				} else if req.Herd != set.Herd {
+					return fmt.Errorf("--herd %s does not match herd %s in %s", req.Herd, set.Herd, herdFile)
+				}
+				if !set.hasRole(req.Role) {
+					return fmt.Errorf("herd %s has no policies for role %s", set.Herd, req.Role)
+				}
+				req.MaxLifetime = time.Duration(set.TokenLifetimeSeconds) * time.Second
+			}
+			if req.Subject == "" {
+				current, err := user.Current()
+				if err != nil {
+					return fmt.Errorf("--subject is required: %w", err)
+				}
+				req.Subject = "user:" + current.Username
+			}
+			token, claims, err := MintToken(key, req, time.Now())
+			if err != nil {
+				return err
+			}
+			if out == "" {
+				fmt.Fprintln(cmd.OutOrStdout(), token)
+				return nil
+			}
+			if err := os.WriteFile(out, []byte(token+"\n"), 0600); err != nil {
+				return fmt.Errorf("failed to write token: %w", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Wrote token %s to %s, expiring at %s\n", claims.ID, out, claims.Expires().Format(time.RFC3339))
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&keyFile, "key-file", "", "Private key or HS256 secret to sign with, from token keygen")
+	flags.StringVarP(&herdFile, "file", "f", "", "Herd file or herd JSON to check the role and the lifetime cap against")
+	flags.StringVar(&req.Herd, "herd", "", "Herd the token is for")
+	flags.StringVar(&req.Role, "role", "", "Role the token holds in the herd")
+	flags.StringVar(&req.Subject, "subject", "", "Subject the token is issued to, user:<name> or service:<name> (default the current user)")
+	flags.StringArrayVar(&req.Contracts, "contract", nil, "Contract the token is narrowed to; a pattern such as ledger-* (repeatable)")
+	flags.StringArrayVar(&req.Pipelines, "pipeline", nil, "Pipeline the token is narrowed to; a pattern such as ledger-* (repeatable)")
+	flags.DurationVar(&req.Lifetime, "ttl", 0, "Lifetime of the token (default the herd's cap)")
+	flags.StringVarP(&out, "out", "o", "", "File to write the token to (default stdout)")
+	cmd.MarkFlagRequired("key-file")
+	return cmd
+}
+
+// hasRole reports whether any policy is for role
+func (p *PolicySet) hasRole(role string) bool {
+	for _, policy := range p.Policies {
+		if policy.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+func newTokenKeygenCommand() *cobra.Command {
+	var alg, out string
+
+	cmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate a key to sign tokens with",
+		Long: `Generate a token signing key. An EdDSA key is written to --out and its
+public key, which is all the Barn needs to verify tokens, to --out.pub. An
+HS256 secret is shared by the minter and the Barn.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := GenerateTokenKey(alg)
+			if err != nil {
+				return err
+			}
+			if err := writeNewFile(out, key.String()); err != nil {
+				return err
+			}
+			if alg == AlgEdDSA {
+				if err := writeNewFile(out+".pub", key.Public().String()); err != nil {
+					return err
+				}
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Wrote %s key %s to %s\n", alg, key.ID(), out)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&alg, "alg", AlgEdDSA, "Signing algorithm: EdDSA or HS256")
+	cmd.Flags().StringVar(&out, "out", "token.key", "File to write the key to")
+	return cmd
+}
+
+// writeNewFile writes a key file readable by its owner only, and never
+// overwrites one
+func writeNewFile(file, content string) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create key file: %w", err)
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	return f.Close()
+}
+
+func newTokenVerifyCommand() *cobra.Command {
+	var keyFiles []string
+	var tokenFile string
+
+	cmd := &cobra.Command{
+		Use:   "verify [TOKEN]",
+		Short: "Verify a token and print its claims",
+		Long: `Verify the signature and expiry of a token, from the argument or
+--token-file, with the given keys, and print its claims as JSON. Whether
+the token was revoked is only known to the Barn.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var token string
+			switch {
+			case len(args) == 1:
+				token = args[0]
+			case tokenFile != "":
+				var err error
+				if token, err = ReadTokenFile(tokenFile); err != nil {
+					return err
+				}
+			default:
+				return errors.New("a TOKEN or --token-file is required")
+			}
+			var keys []*TokenKey
+			for _, file := range keyFiles {
+				key, err := LoadTokenKey(file)
+				if err != nil {
+					return err
+				}
+				keys = append(keys, key)
+			}
+			claims, err := NewTokenVerifier(keys...).Verify(token, time.Now())
+			if err != nil {
+				return err
+			}
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(claims)
+		},
+	}
+	cmd.Flags().StringArrayVar(&keyFiles, "key-file", nil, "Key to verify with: a public key, private key or HS256 secret (repeatable)")
+	cmd.Flags().StringVar(&tokenFile, "token-file", "", "File with the token")
+	cmd.MarkFlagRequired("key-file")
+	return cmd
+}
@@ -0,0 +1,123 @@
+package herdctl
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTokens tests signing and verifying tokens with both algorithms, and
+// that a token is only accepted by the key that signed it
+func TestTokens(t *testing.T) {
+	now := time.Now()
+	for _, alg := range []string{AlgEdDSA, AlgHS256} {
+		key, err := GenerateTokenKey(alg)
+		if err != nil {
+			t.Fatalf("%s: failed to generate key: %v", alg, err)
+		}
+		loaded, err := ParseTokenKey(key.String())
+		if err != nil || loaded.ID() != key.ID() {
+			t.Fatalf("%s: failed to reload key: %v", alg, err)
+		}
+		token, minted, err := MintToken(key, TokenRequest{
+			Subject:   "service:ci-ledger",
+			Herd:      "finance",
+			Role:      "ledger-operator",
+			Pipelines: []string{"ledger-*"},
+		}, now)
+		if err != nil {
+			t.Fatalf("%s: failed to mint token: %v", alg, err)
+		}
+		if minted.Lifetime() != DefaultTokenLifetime {
+			t.Errorf("%s: expected the default lifetime, got %s", alg, minted.Lifetime())
+		}
+
+		// EdDSA tokens verify with the public key alone
+		claims, err := NewTokenVerifier(key.Public()).Verify(token, now)
+		if err != nil {
+			t.Fatalf("%s: failed to verify token: %v", alg, err)
+		}
+		if claims.ID != minted.ID || claims.Herd != "finance" || claims.Role != "ledger-operator" || claims.Subject != "service:ci-ledger" {
+			t.Errorf("%s: unexpected claims %+v", alg, claims)
+		}
+
+		if _, err := NewTokenVerifier(key).Verify(token, now.Add(DefaultTokenLifetime+time.Minute)); !errors.Is(err, ErrTokenExpired) {
+			t.Errorf("%s: expected ErrTokenExpired, got %v", alg, err)
+		}
+		other, _ := GenerateTokenKey(alg)
+		if _, err := NewTokenVerifier(other).Verify(token, now); !errors.Is(err, ErrTokenInvalid) {
+			t.Errorf("%s: expected an unknown key to fail, got %v", alg, err)
+		}
+		parts := strings.Split(token, ".")
+		tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"jti":"`+minted.ID+`","iss":"runink","sub":"user:root","iat":1,"exp":9999999999}`)) + "." + parts[2]
+		if _, err := NewTokenVerifier(key).Verify(tampered, now); !errors.Is(err, ErrTokenInvalid) {
+			t.Errorf("%s: expected changed claims to fail, got %v", alg, err)
+		}
+	}
+
+	// An HS256 token made with an EdDSA public key as the secret must not
+	// pass for an EdDSA token
+	key, _ := GenerateTokenKey(AlgEdDSA)
+	public := key.Public()
+	secret := &TokenKey{Alg: AlgHS256, secret: public.public}
+	forged, err := secret.Sign(&Claims{ID: "00", Issuer: TokenIssuer, Subject: "user:root", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	header, _ := base64.RawURLEncoding.DecodeString(strings.Split(forged, ".")[0])
+	forged = strings.Replace(forged, strings.Split(forged, ".")[0],
+		base64.RawURLEncoding.EncodeToString([]byte(strings.Replace(string(header), secret.ID(), public.ID(), 1))), 1)
+	if _, err := NewTokenVerifier(public).Verify(forged, now); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("Expected an algorithm confusion to fail, got %v", err)
+	}
+}
+
+// TestMintToken tests the lifetime cap and the claims a token needs
+func TestMintToken(t *testing.T) {
+	key, _ := GenerateTokenKey(AlgHS256)
+	now := time.Now()
+
+	if _, _, err := MintToken(key, TokenRequest{Subject: "user:alice", Herd: "finance", Role: "analyst", Lifetime: time.Hour, MaxLifetime: 15 * time.Minute}, now); err == nil {
+		t.Error("Expected a lifetime over the herd's cap to fail")
+	}
+	_, claims, err := MintToken(key, TokenRequest{Subject: "user:alice", Herd: "finance", Role: "analyst", MaxLifetime: 15 * time.Minute}, now)
+	if err != nil || claims.Lifetime() != 15*time.Minute {
+		t.Errorf("Expected the herd's cap as the lifetime, got %+v, %v", claims, err)
+	}
+	for _, req := range []TokenRequest{
+		{Subject: "group:finance-ops", Herd: "finance", Role: "analyst"},
+		{Subject: "user:alice", Herd: "finance"},
+		{Subject: "user:alice", Pipelines: []string{"ledger"}},
+		{Subject: "user:alice", Herd: "finance", Role: "analyst", Contracts: []string{"a/b"}},
+	} {
+		if _, _, err := MintToken(key, req, now); err == nil {
+			t.Errorf("%+v: expected an error", req)
+		}
+	}
+}
+
+// TestClaimsCovers tests the herd, contract and pipeline scopes of tokens
+func TestClaimsCovers(t *testing.T) {
+	claims := &Claims{Herd: "finance", Role: "operator", Pipelines: []string{"ledger-*"}}
+	tests := []struct {
+		herd, action, resource string
+		covered                bool
+	}{
+		{"finance", "execute:pipelines", "pipelines/ledger-daily", true},
+		{"finance", "execute:pipelines", "pipelines/payroll-daily", false},
+		{"finance", "read:pipelines", "", false},
+		{"finance", "read:herd", "", true},
+		{"finance", "read:contracts", "contracts/payroll", true},
+		{"hr", "read:herd", "", false},
+	}
+	for _, test := range tests {
+		if err := claims.Covers(test.herd, test.action, test.resource); (err == nil) != test.covered {
+			t.Errorf("%s %s %s: expected %t, got %v", test.herd, test.action, test.resource, test.covered, err)
+		}
+	}
+	if err := (&Claims{Subject: "user:alice"}).Covers("hr", "manage:herd", ""); err != nil {
+		t.Errorf("Expected a token without a herd to leave it to the policies, got %v", err)
+	}
+}
@@ -41,6 +41,10 @@ Key domains:
 		runinkctl.NewruninkctlCommand(),
 		herdctl.NewHerdctlCommand(),
 		runictl.NewRunictlCommand(),
+		runictl.NewKillCommand(),
+		barnctl.NewFreezeCommand(),
+		barnctl.NewSBOMCommand(),
+		herdctl.NewMintTokenCommand(),
 	)
 
 	// Execute
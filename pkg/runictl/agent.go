@@ -0,0 +1,496 @@
+package runictl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/runink/herdctl"
+	"github.com/spf13/cobra"
+)
+
+// DefaultBarnEndpoint is the Barn API of the local node
+const DefaultBarnEndpoint = "http://127.0.0.1:8080"
+
+// DefaultAgentStateDir holds the agent's watch cursor and the slices
+// assigned to the node: <dir>/cursor and <dir>/assignments.json
+const DefaultAgentStateDir = "/var/lib/runink/agent"
+
+// agentRetryMin and agentRetryMax bound the delay before the agent
+// reconnects to the Barn
+const (
+	agentRetryMin = 100 * time.Millisecond
+	agentRetryMax = 10 * time.Second
+)
+
+// Barn store keys the agent follows
+const (
+	barnHerdPrefix  = "herds/"
+	barnSlicePrefix = "slices/"
+)
+
+// errRevisionTooOld is returned when the Barn compacted the events after
+// the agent's cursor
+var errRevisionTooOld = errors.New("barn revision too old")
+
+// AssignedSlice is a slice the Barn assigned to this node
+type AssignedSlice struct {
+	Herd      string            `json:"herd"`
+	Name      string            `json:"name"`
+	Scenario  string            `json:"scenario"`
+	Node      string            `json:"node"`
+	Resources HerdQuota         `json:"resources"`
+	Env       map[string]string `json:"env,omitempty"`
+	Revision  uint64            `json:"revision"`
+}
+
+// barnHerd is the part of a Barn herd the agent uses
+type barnHerd struct {
+	Name  string    `json:"name"`
+	Quota HerdQuota `json:"quota"`
+}
+
+// barnEvent is an event of a Barn watch stream
+type barnEvent struct {
+	Type     string          `json:"type"`
+	Key      string          `json:"key"`
+	Value    json.RawMessage `json:"value"`
+	Revision uint64          `json:"revision"`
+}
+
+// Agent follows the Barn's herds and slices through a watch stream. It
+// applies herd quota changes to the local cgroups and keeps the slices
+// assigned to its node in its state directory. The agent resumes from the
+// last revision it processed; if the Barn compacted the events since, it
+// reads the full state again.
+type Agent struct {
+	Endpoint string
+	NodeID   string
+	StateDir string
+	HTTP     *http.Client
+
+	// TokenFile holds the bearer token the agent presents to the Barn. It
+	// is read again for each request, so a rotated token is picked up.
+	TokenFile string
+
+	// Out receives a line per assignment change
+	Out io.Writer
+
+	cursor uint64
+	slices map[string]AssignedSlice
+	quotas map[string]HerdQuota
+	retry  time.Duration
+}
+
+func newAgentCommand() *cobra.Command {
+	hostname, _ := os.Hostname()
+	agent := &Agent{HTTP: http.DefaultClient}
+
+	cmd := &cobra.Command{
+		Use:   "agent",
+		Short: "Follow slice assignments and herd quotas from the Barn",
+		Long: `Run the node agent. It watches the Barn for changes of herds and slices,
+applies herd quota changes to this node's cgroups, and writes the slices
+assigned to this node to <state-dir>/assignments.json. After a restart it
+resumes from the revision in <state-dir>/cursor.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			agent.Out = cmd.OutOrStdout()
+			return agent.Run(ctx)
+		},
+	}
+
+	cmd.Flags().StringVar(&agent.Endpoint, "barn-endpoint", DefaultBarnEndpoint, "Barn API endpoint")
+	cmd.Flags().StringVar(&agent.NodeID, "node-id", hostname, "Node whose slice assignments to follow")
+	cmd.Flags().StringVar(&agent.StateDir, "state-dir", DefaultAgentStateDir, "Directory for the watch cursor and the assignments")
+	cmd.Flags().StringVar(&agent.TokenFile, "token-file", os.Getenv("BARN_TOKEN_FILE"), "File with the token to present to the Barn, from herdctl token mint")
+
+	return cmd
+}
+
+// Run follows the Barn until ctx ends
+func (a *Agent) Run(ctx context.Context) error {
+	if !strings.Contains(a.Endpoint, "://") {
+		a.Endpoint = "http://" + a.Endpoint
+	}
+	if err := os.MkdirAll(a.StateDir, 0755); err != nil {
+		return fmt.Errorf("failed to create agent state directory: %w", err)
+	}
+	if err := a.load(); err != nil {
+		return err
+	}
+	a.quotas = make(map[string]HerdQuota)
+	a.retry = agentRetryMin
+
+	for {
+		var err error
+		if a.cursor == 0 {
+			err = a.resync(ctx)
+		}
+		if err == nil {
+			err = a.follow(ctx)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, errRevisionTooOld) {
+			fmt.Fprintf(os.Stderr, "Warning: barn compacted the events after revision %d, reading the full state\n", a.cursor)
+			a.cursor = 0
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %v; retrying in %s\n", err, a.retry)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(a.retry):
+		}
+		a.retry = min(2*a.retry, agentRetryMax)
+	}
+}
+
+// resync reads every herd and slice and continues from the revision of the
+// herd list. Events after it are replayed, which is harmless.
+func (a *Agent) resync(ctx context.Context) error {
+	var herds struct {
+		Items    []barnHerd `json:"items"`
+		Revision uint64     `json:"revision"`
+	}
+	if err := a.get(ctx, "/herds", &herds); err != nil {
+		return err
+	}
+
+	slices := make(map[string]AssignedSlice)
+	for _, herd := range herds.Items {
+		a.applyQuota(herd)
+		var list struct {
+			Items []AssignedSlice `json:"items"`
+		}
+		if err := a.get(ctx, "/herds/"+url.PathEscape(herd.Name)+"/slices", &list); err != nil {
+			return err
+		}
+		for _, slice := range list.Items {
+			if slice.Node == a.NodeID {
+				slices[slice.Herd+"/"+slice.Name] = slice
+			}
+		}
+	}
+
+	for key, slice := range slices {
+		if _, ok := a.slices[key]; !ok {
+			fmt.Fprintf(a.Out, "Assigned slice %s (scenario %s)\n", key, slice.Scenario)
+		}
+	}
+	for key := range a.slices {
+		if _, ok := slices[key]; !ok {
+			fmt.Fprintf(a.Out, "Unassigned slice %s\n", key)
+		}
+	}
+	a.slices, a.cursor = slices, herds.Revision
+	return a.save()
+}
+
+// follow processes the watch stream after the cursor until it breaks
+func (a *Agent) follow(ctx context.Context) error {
+	query := url.Values{"prefix": {barnHerdPrefix, barnSlicePrefix}, "revision": {strconv.FormatUint(a.cursor+1, 10)}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.Endpoint+"/api/v1/watch?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if err := a.authorize(req); err != nil {
+		return err
+	}
+	resp, err := a.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach barn at %s: %w", a.Endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		return errRevisionTooOld
+	}
+	if resp.StatusCode != http.StatusOK {
+		return barnError(resp.Status, resp.Body)
+	}
+	a.retry = agentRetryMin
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		id, event, data, err := readServerEvent(reader)
+		if err != nil {
+			return fmt.Errorf("barn watch stream broke: %w", err)
+		}
+		switch event {
+		case "put", "delete":
+			change := barnEvent{}
+			if err := json.Unmarshal(data, &change); err != nil {
+				return fmt.Errorf("invalid barn event: %w", err)
+			}
+			if err := a.handle(change); err != nil {
+				return err
+			}
+		case "error":
+			var failure struct {
+				Code string `json:"code"`
+			}
+			json.Unmarshal(data, &failure)
+			if failure.Code == "revision_too_old" {
+				return errRevisionTooOld
+			}
+			return barnError("watch failed", strings.NewReader(string(data)))
+		}
+
+		// The id marks a revision whose events were all processed
+		if id != "" {
+			if revision, err := strconv.ParseUint(id, 10, 64); err == nil && revision > a.cursor {
+				a.cursor = revision
+				if err := a.saveCursor(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// handle applies a herd or slice change
+func (a *Agent) handle(event barnEvent) error {
+	switch {
+	case strings.HasPrefix(event.Key, barnHerdPrefix):
+		name := strings.TrimPrefix(event.Key, barnHerdPrefix)
+		if event.Type == "delete" {
+			delete(a.quotas, name)
+			return nil
+		}
+		herd := barnHerd{}
+		if err := json.Unmarshal(event.Value, &herd); err != nil {
+			return fmt.Errorf("invalid herd %s: %w", name, err)
+		}
+		if quota, ok := a.quotas[name]; !ok || quota != herd.Quota {
+			a.applyQuota(herd)
+		}
+
+	case strings.HasPrefix(event.Key, barnSlicePrefix):
+		key := strings.TrimPrefix(event.Key, barnSlicePrefix)
+		_, assigned := a.slices[key]
+		slice := AssignedSlice{}
+		if event.Type == "put" {
+			if err := json.Unmarshal(event.Value, &slice); err != nil {
+				return fmt.Errorf("invalid slice %s: %w", key, err)
+			}
+			slice.Revision = event.Revision
+		}
+
+		switch {
+		case slice.Node == a.NodeID && event.Type == "put":
+			if assigned {
+				fmt.Fprintf(a.Out, "Updated slice %s (revision %d)\n", key, slice.Revision)
+			} else {
+				fmt.Fprintf(a.Out, "Assigned slice %s (scenario %s)\n", key, slice.Scenario)
+			}
+			a.slices[key] = slice
+		case assigned:
+			fmt.Fprintf(a.Out, "Unassigned slice %s\n", key)
+			delete(a.slices, key)
+		default:
+			return nil
+		}
+		return a.saveAssignments()
+	}
+	return nil
+}
+
+// applyQuota writes a herd's quota to its cgroup if the herd has one on
+// this node
+func (a *Agent) applyQuota(herd barnHerd) {
+	a.quotas[herd.Name] = herd.Quota
+	if herd.Quota == (HerdQuota{}) {
+		return
+	}
+	err := ApplyHerdQuota(herd.Name, herd.Quota)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "Warning: failed to apply quota of herd %s: %v\n", herd.Name, err)
+	}
+}
+
+// authorize adds the agent's token to a request to the Barn
+func (a *Agent) authorize(req *http.Request) error {
+	if a.TokenFile == "" {
+		return nil
+	}
+	token, err := herdctl.ReadTokenFile(a.TokenFile)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (a *Agent) get(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.Endpoint+"/api/v1"+path, nil)
+	if err != nil {
+		return err
+	}
+	if err := a.authorize(req); err != nil {
+		return err
+	}
+	resp, err := a.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach barn at %s: %w", a.Endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return barnError(resp.Status, resp.Body)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid barn response for %s: %w", path, err)
+	}
+	return nil
+}
+
+func (a *Agent) post(ctx context.Context, path string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.Endpoint+"/api/v1"+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if err := a.authorize(req); err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach barn at %s: %w", a.Endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return barnError(resp.Status, resp.Body)
+	}
+	return nil
+}
+
+// load reads the cursor and assignments saved by the previous run
+func (a *Agent) load() error {
+	a.slices = make(map[string]AssignedSlice)
+	data, err := os.ReadFile(filepath.Join(a.StateDir, "cursor"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read agent cursor: %w", err)
+	}
+	if a.cursor, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring invalid agent cursor %q\n", data)
+		a.cursor = 0
+		return nil
+	}
+
+	var slices []AssignedSlice
+	data, err = os.ReadFile(filepath.Join(a.StateDir, "assignments.json"))
+	if err == nil {
+		err = json.Unmarshal(data, &slices)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		// Without the assignments the cursor is useless
+		fmt.Fprintf(os.Stderr, "Warning: ignoring agent state: %v\n", err)
+		a.cursor = 0
+		return nil
+	}
+	for _, slice := range slices {
+		a.slices[slice.Herd+"/"+slice.Name] = slice
+	}
+	return nil
+}
+
+func (a *Agent) save() error {
+	if err := a.saveAssignments(); err != nil {
+		return err
+	}
+	return a.saveCursor()
+}
+
+func (a *Agent) saveCursor() error {
+	return writeFileAtomic(filepath.Join(a.StateDir, "cursor"), []byte(strconv.FormatUint(a.cursor, 10)+"\n"))
+}
+
+func (a *Agent) saveAssignments() error {
+	slices := make([]AssignedSlice, 0, len(a.slices))
+	for _, slice := range a.slices {
+		slices = append(slices, slice)
+	}
+	sort.Slice(slices, func(i, j int) bool {
+		return slices[i].Herd+"/"+slices[i].Name < slices[j].Herd+"/"+slices[j].Name
+	})
+	data, err := json.MarshalIndent(slices, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(a.StateDir, "assignments.json"), append(data, '\n'))
+}
+
+// writeFileAtomic replaces path with data, so readers never see a partial
+// file
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// readServerEvent reads the next event of a server-sent event stream
+func readServerEvent(reader *bufio.Reader) (id, event string, data []byte, err error) {
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", "", nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if event == "" && len(lines) == 0 {
+				continue
+			}
+			return id, event, []byte(strings.Join(lines, "\n")), nil
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			id = value
+		case "event":
+			event = value
+		case "data":
+			lines = append(lines, value)
+		}
+	}
+}
+
+// barnError returns the error message of a failed Barn request
+func barnError(status string, body io.Reader) error {
+	var failure struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(body, 1<<16)).Decode(&failure); err != nil || failure.Error == "" {
+		return fmt.Errorf("barn: %s", status)
+	}
+	return fmt.Errorf("barn: %s", failure.Error)
+}
@@ -0,0 +1,295 @@
+package runictl
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// The cgroup layout and the quota translation below are the runink
+// runtime's (runtime/cgroup.go and runtime/quantity.go), so that a quota
+// the agent updates live lands in the cgroup the runtime created, with the
+// same values the runtime writes. Change both together.
+
+// CgroupV2Path is the path to the cgroup v2 filesystem
+const CgroupV2Path = "/sys/fs/cgroup"
+
+// CgroupRoot returns the directory herd and slice cgroups live under.
+// Root agents use the cgroup v2 mount. Unprivileged agents use the subtree
+// delegated to them (e.g. by systemd's Delegate=yes) when it is writable.
+func CgroupRoot() string {
+	if os.Geteuid() == 0 {
+		return CgroupV2Path
+	}
+	if delegated, ok := delegatedCgroup(); ok {
+		return delegated
+	}
+	return CgroupV2Path
+}
+
+// delegatedCgroup finds a writable cgroup above the agent's own cgroup.
+// The parent is preferred because a cgroup holding processes cannot
+// enable controllers for its children.
+func delegatedCgroup() (string, bool) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", false
+	}
+
+	// cgroup v2 has a single "0::<path>" entry
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "0::") {
+			continue
+		}
+		own := filepath.Join(CgroupV2Path, strings.TrimPrefix(line, "0::"))
+		for _, candidate := range []string{filepath.Dir(own), own} {
+			if candidate != CgroupV2Path && syscall.Access(candidate, 2 /* W_OK */) == nil {
+				return candidate, true
+			}
+		}
+	}
+	return "", false
+}
+
+// CgroupHierarchy is the subtree of CgroupRoot holding herd and slice
+// cgroups: runink/<herd>/<slice>
+const CgroupHierarchy = "runink"
+
+// cpuPeriod is the cpu.max period in microseconds
+const cpuPeriod = 100000
+
+// HerdQuota holds a herd's resource quotas as Kubernetes-style quantities.
+// Empty fields are left unchanged when the quota is applied.
+type HerdQuota struct {
+	CPU    string `json:"cpu,omitempty"`    // e.g. "20000m"
+	Memory string `json:"memory,omitempty"` // e.g. "64Gi"
+	Pids   string `json:"pids,omitempty"`   // e.g. "8192" or "max"
+	IO     string `json:"io,omitempty"`     // e.g. "8:0 rbps=500Mi wbps=200Mi"; devices separated by ";"
+}
+
+// HerdCgroupPath returns the cgroup directory of a herd
+func HerdCgroupPath(herd string) string {
+	return filepath.Join(CgroupRoot(), CgroupHierarchy, herd)
+}
+
+// ApplyHerdQuota writes the quota to the herd's cgroup. The kernel applies
+// the new limits to the herd's running slices immediately.
+func ApplyHerdQuota(herd string, quota HerdQuota) error {
+	if herd == "" || strings.Contains(herd, "/") || herd == "." || herd == ".." {
+		return fmt.Errorf("invalid herd name: %q", herd)
+	}
+	files, err := quota.cgroupFiles()
+	if err != nil {
+		return err
+	}
+
+	dir := HerdCgroupPath(herd)
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("herd %s has no cgroup on this node: %w", herd, err)
+	}
+	for _, file := range files {
+		if err := os.WriteFile(filepath.Join(dir, file[0]), []byte(file[1]), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file[0], err)
+		}
+	}
+	return nil
+}
+
+// Validate checks that every set field of the quota can be applied
+func (q HerdQuota) Validate() error {
+	_, err := q.cgroupFiles()
+	return err
+}
+
+// Merge returns the quota with the set fields of update applied
+func (q HerdQuota) Merge(update HerdQuota) HerdQuota {
+	if update.CPU != "" {
+		q.CPU = update.CPU
+	}
+	if update.Memory != "" {
+		q.Memory = update.Memory
+	}
+	if update.Pids != "" {
+		q.Pids = update.Pids
+	}
+	if update.IO != "" {
+		q.IO = update.IO
+	}
+	return q
+}
+
+// cgroupFiles translates the quota into (file, value) pairs
+func (q HerdQuota) cgroupFiles() ([][2]string, error) {
+	var files [][2]string
+
+	if q.CPU != "" {
+		value, err := cpuMax(q.CPU)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CPU quota: %w", err)
+		}
+		files = append(files, [2]string{"cpu.max", value})
+	}
+	if q.Memory != "" {
+		value, err := memoryValue(q.Memory)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory limit: %w", err)
+		}
+		files = append(files, [2]string{"memory.max", value})
+	}
+	if q.Pids != "" {
+		if n, err := strconv.Atoi(q.Pids); q.Pids != "max" && (err != nil || n <= 0) {
+			return nil, fmt.Errorf("invalid pids limit: %s", q.Pids)
+		}
+		files = append(files, [2]string{"pids.max", q.Pids})
+	}
+	if q.IO != "" {
+		lines, err := ioMax(q.IO)
+		if err != nil {
+			return nil, fmt.Errorf("invalid I/O limit: %w", err)
+		}
+		// io.max takes one device per write
+		for _, line := range lines {
+			files = append(files, [2]string{"io.max", line})
+		}
+	}
+
+	return files, nil
+}
+
+// cpuMax converts a CPU quantity such as "500m" to a cpu.max value.
+// Raw cpu.max values ("max" or "<quota> <period>") are passed through.
+func cpuMax(quota string) (string, error) {
+	if quota == "max" || strings.Contains(strings.TrimSpace(quota), " ") {
+		return quota, nil
+	}
+	milli, err := ParseCPUQuantity(quota)
+	if err != nil {
+		return "", err
+	}
+	// The kernel rejects quotas below 1ms
+	us := milli * cpuPeriod / 1000
+	if us < 1000 {
+		us = 1000
+	}
+	return fmt.Sprintf("%d %d", us, cpuPeriod), nil
+}
+
+// memoryValue converts a memory quantity such as "64Gi" to bytes; "max" is passed through
+func memoryValue(quantity string) (string, error) {
+	if quantity == "max" {
+		return quantity, nil
+	}
+	bytes, err := ParseByteQuantity(quantity)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(bytes, 10), nil
+}
+
+// ioMax converts io.max rules such as "8:0 rbps=100Mi wiops=1000" to the
+// kernel format. Rules for several devices are separated by ";".
+func ioMax(rules string) ([]string, error) {
+	var lines []string
+	for _, rule := range strings.FieldsFunc(rules, func(r rune) bool { return r == ';' || r == '\n' }) {
+		fields := strings.Fields(rule)
+		if len(fields) == 0 {
+			continue
+		}
+		var major, minor int
+		if _, err := fmt.Sscanf(fields[0], "%d:%d", &major, &minor); err != nil || len(fields) < 2 {
+			return nil, fmt.Errorf("expected \"MAJ:MIN key=value...\", got %q", rule)
+		}
+
+		line := []string{fmt.Sprintf("%d:%d", major, minor)}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("expected key=value, got %q", field)
+			}
+			switch key {
+			case "rbps", "wbps", "riops", "wiops":
+			default:
+				return nil, fmt.Errorf("unknown io.max key %q", key)
+			}
+			if value != "max" {
+				n, err := ParseByteQuantity(value)
+				if err != nil {
+					return nil, err
+				}
+				value = strconv.FormatInt(n, 10)
+			}
+			line = append(line, key+"="+value)
+		}
+		lines = append(lines, strings.Join(line, " "))
+	}
+	return lines, nil
+}
+
+// quantitySuffixes are the Kubernetes quantity suffixes, longest first so
+// that "Mi" is not mistaken for "M"
+var quantitySuffixes = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30},
+	{"Ti", 1 << 40}, {"Pi", 1 << 50}, {"Ei", 1 << 60},
+	{"k", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12}, {"P", 1e15}, {"E", 1e18},
+	{"m", 1e-3},
+}
+
+// ParseQuantity parses a Kubernetes-style quantity such as "500m", "2",
+// "1.5", "64Gi", "100M" or "1e3" into its value in base units
+func ParseQuantity(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty quantity")
+	}
+
+	number, multiplier := s, 1.0
+	for _, q := range quantitySuffixes {
+		if strings.HasSuffix(s, q.suffix) {
+			number, multiplier = strings.TrimSuffix(s, q.suffix), q.multiplier
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("invalid quantity: %s", s)
+	}
+	if value < 0 {
+		return 0, fmt.Errorf("quantity must not be negative: %s", s)
+	}
+	return value * multiplier, nil
+}
+
+// ParseCPUQuantity parses a CPU quantity into millicores: "500m" is 500, "2" is 2000
+func ParseCPUQuantity(s string) (int64, error) {
+	value, err := ParseQuantity(s)
+	if err != nil {
+		return 0, err
+	}
+	return toInt64(value*1000, s)
+}
+
+// ParseByteQuantity parses a memory or storage quantity into bytes: "1Gi" is 1073741824
+func ParseByteQuantity(s string) (int64, error) {
+	value, err := ParseQuantity(s)
+	if err != nil {
+		return 0, err
+	}
+	return toInt64(value, s)
+}
+
+// toInt64 rounds a parsed quantity, rejecting values that overflow
+func toInt64(value float64, s string) (int64, error) {
+	value = math.Ceil(value)
+	if value >= math.MaxInt64 {
+		return 0, fmt.Errorf("quantity out of range: %s", s)
+	}
+	return int64(value), nil
+}
@@ -0,0 +1,126 @@
+package runictl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestParseQuantity tests Kubernetes-style CPU and byte quantities
+func TestParseQuantity(t *testing.T) {
+	cpus := map[string]int64{"500m": 500, "2": 2000, "1.5": 1500, "20000m": 20000}
+	for quantity, expected := range cpus {
+		if milli, err := ParseCPUQuantity(quantity); err != nil || milli != expected {
+			t.Errorf("ParseCPUQuantity(%q) = %d, %v; expected %d", quantity, milli, err, expected)
+		}
+	}
+
+	bytes := map[string]int64{"64Gi": 64 << 30, "512Mi": 512 << 20, "1G": 1e9, "100M": 1e8, "1e3": 1000, "4096": 4096}
+	for quantity, expected := range bytes {
+		if n, err := ParseByteQuantity(quantity); err != nil || n != expected {
+			t.Errorf("ParseByteQuantity(%q) = %d, %v; expected %d", quantity, n, err, expected)
+		}
+	}
+
+	for _, invalid := range []string{"", "lots", "-1Gi", "10Xi", "100Ei"} {
+		if _, err := ParseByteQuantity(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+// TestHerdQuotaCgroupFiles tests the translation of herd quotas to cgroup
+// files, which must match the values the runtime writes
+func TestHerdQuotaCgroupFiles(t *testing.T) {
+	quota := HerdQuota{
+		CPU:    "20000m",
+		Memory: "64Gi",
+		Pids:   "8192",
+		IO:     "8:0 rbps=500Mi wbps=max; 8:16 wiops=1000",
+	}
+	list, err := quota.cgroupFiles()
+	if err != nil {
+		t.Fatalf("Failed to build cgroup files: %v", err)
+	}
+	files := make(map[string]string)
+	for _, file := range list {
+		files[file[0]] += file[1] + "\n"
+	}
+	expected := map[string]string{
+		"cpu.max":    "2000000 100000\n",
+		"memory.max": "68719476736\n",
+		"pids.max":   "8192\n",
+		"io.max":     "8:0 rbps=524288000 wbps=max\n8:16 wiops=1000\n",
+	}
+	for file, value := range expected {
+		if files[file] != value {
+			t.Errorf("%s: expected %q, got %q", file, value, files[file])
+		}
+	}
+
+	// Raw and unlimited values pass through
+	for _, q := range []HerdQuota{{CPU: "50000 100000"}, {CPU: "max", Memory: "max", Pids: "max"}} {
+		if err := q.Validate(); err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", q, err)
+		}
+	}
+	// Tiny quotas are raised to the kernel's 1ms minimum
+	if list, err := (HerdQuota{CPU: "1m"}).cgroupFiles(); err != nil || list[0][1] != "1000 100000" {
+		t.Errorf("Expected the minimum cpu.max, got %v (%v)", list, err)
+	}
+
+	for _, invalid := range []HerdQuota{{CPU: "lots"}, {Memory: "-1Gi"}, {Pids: "0"}, {IO: "sda rbps=1"}, {IO: "8:0 speed=1"}} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", invalid)
+		}
+	}
+}
+
+// TestHerdQuotaMerge tests that only the set fields of an update apply
+func TestHerdQuotaMerge(t *testing.T) {
+	quota := HerdQuota{CPU: "8", Memory: "16Gi"}.Merge(HerdQuota{Memory: "32Gi", Pids: "512"})
+	if quota != (HerdQuota{CPU: "8", Memory: "32Gi", Pids: "512"}) {
+		t.Errorf("Unexpected merged quota: %+v", quota)
+	}
+}
+
+// TestApplyHerdQuota tests updating the quota of a herd cgroup live
+func TestApplyHerdQuota(t *testing.T) {
+	for _, herd := range []string{"", ".", "..", "a/b"} {
+		if err := ApplyHerdQuota(herd, HerdQuota{Pids: "64"}); err == nil {
+			t.Errorf("Expected herd name %q to be rejected", herd)
+		}
+	}
+	if err := ApplyHerdQuota("runink-test-missing", HerdQuota{Pids: "64"}); err == nil {
+		t.Error("Expected a herd without a cgroup to fail")
+	}
+
+	// Skip the rest if not running as root
+	if os.Geteuid() != 0 {
+		t.Skip("This test requires root privileges")
+	}
+	controllers, err := os.ReadFile(filepath.Join(CgroupV2Path, "cgroup.controllers"))
+	if err != nil || !strings.Contains(string(controllers), "pids") {
+		t.Skip("cgroup v2 with the pids controller is not mounted at " + CgroupV2Path)
+	}
+
+	// The herd cgroup as the runtime creates it
+	hierarchy := filepath.Join(CgroupRoot(), CgroupHierarchy)
+	for _, dir := range []string{CgroupRoot(), hierarchy} {
+		os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+pids"), 0644)
+	}
+	herd := "runink-test-quota"
+	herdPath := HerdCgroupPath(herd)
+	if err := os.MkdirAll(herdPath, 0755); err != nil {
+		t.Fatalf("Failed to create herd cgroup: %v", err)
+	}
+	defer os.Remove(herdPath)
+
+	if err := ApplyHerdQuota(herd, HerdQuota{Pids: "128"}); err != nil {
+		t.Fatalf("Failed to apply quota: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(herdPath, "pids.max")); err != nil || strings.TrimSpace(string(data)) != "128" {
+		t.Errorf("Expected herd pids.max 128, got %q (%v)", data, err)
+	}
+}
@@ -7,8 +7,9 @@ import (
 // NewRunictlCommand returns the runictl root command.
 func NewRunictlCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "runictl",
-		Short: "Schedule and Execute Slices with Isolation and Placement",
+		Use:     "runictl",
+		Aliases: []string{"slicectl"},
+		Short:   "Schedule and Execute Slices with Isolation and Placement",
 	}
 
 	cmd.AddCommand(
@@ -21,23 +22,13 @@ func NewRunictlCommand() *cobra.Command {
 		newSecretsCommand(),
 		newMonitorCommand(),
 		newHeartbeatCommand(),
+		newLogsCommand(),
 	)
 
 	return cmd
 }
 
 // Placeholder subcommands wiring
-func newAgentCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "agent",
-		Short: "Manage slice agents",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// TODO: Implement agent management
-			return nil
-		},
-	}
-}
-
 func newSchedulerCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "scheduler",
@@ -93,17 +84,6 @@ func newPlacementCommand() *cobra.Command {
 	}
 }
 
-func newSecretsCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "secrets",
-		Short: "Manage secrets for slices",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// TODO: Implement slice secrets management
-			return nil
-		},
-	}
-}
-
 func newMonitorCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "monitor",
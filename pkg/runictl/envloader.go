@@ -0,0 +1,245 @@
+package runictl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SecretDelivery selects how resolved secrets reach a slice
+type SecretDelivery string
+
+const (
+	// SecretFiles writes each secret to a file on a private tmpfs and sets
+	// NAME_FILE to its path
+	SecretFiles SecretDelivery = "file"
+
+	// SecretEnv sets NAME to the secret itself
+	SecretEnv SecretDelivery = "env"
+)
+
+// ParseSecretDelivery parses "file" or "env"; empty means SecretFiles
+func ParseSecretDelivery(s string) (SecretDelivery, error) {
+	switch SecretDelivery(s) {
+	case "", SecretFiles:
+		return SecretFiles, nil
+	case SecretEnv:
+		return SecretEnv, nil
+	}
+	return "", fmt.Errorf("invalid secret delivery %q: expected file or env", s)
+}
+
+// EnvLoader builds the environment a slice launches with. Plain values
+// pass through; secret:// values are resolved from the Barn at launch and
+// delivered as Delivery says.
+type EnvLoader struct {
+	Secrets *SecretsClient
+
+	// Herd is the herd the slice belongs to, whose secrets scope applies
+	Herd string
+
+	// Delivery defaults to SecretFiles
+	Delivery SecretDelivery
+
+	// SecretsDir is where root mounts the slice's secrets tmpfs.
+	// Default: DefaultSecretsDir
+	SecretsDir string
+
+	// Timeout is how long the slice may run. Environment delivery needs
+	// one within the secrets' lifetime, since variables cannot be withdrawn.
+	Timeout time.Duration
+}
+
+// SliceEnv is a slice's environment with its secrets delivered. Close it
+// once the slice exited to withdraw the secret files.
+type SliceEnv struct {
+	// Env holds NAME=VALUE entries to add to the slice's environment
+	Env []string
+
+	files *secretFiles
+}
+
+// Close withdraws the slice's secret files and removes their tmpfs
+func (e *SliceEnv) Close() {
+	if e.files != nil {
+		e.files.Close()
+		e.files = nil
+	}
+}
+
+// References returns the secret references in env by variable name
+func (l *EnvLoader) References(env map[string]string) (map[string]SecretRef, error) {
+	refs := make(map[string]SecretRef)
+	for name, value := range env {
+		if !strings.HasPrefix(value, "secret://") {
+			continue
+		}
+		ref, err := ParseSecretRef(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		refs[name] = ref
+	}
+	return refs, nil
+}
+
+// Load resolves the secret references in env and returns the slice's
+// environment. slice names the secrets tmpfs.
+func (l *EnvLoader) Load(ctx context.Context, slice string, env map[string]string) (*SliceEnv, error) {
+	refs, err := l.References(env)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sliceEnv := &SliceEnv{}
+	for _, name := range names {
+		if _, ok := refs[name]; !ok {
+			sliceEnv.Env = append(sliceEnv.Env, name+"="+env[name])
+		}
+	}
+	if len(refs) == 0 {
+		return sliceEnv, nil
+	}
+
+	values, lifetime, err := resolveSecrets(ctx, l.Secrets, l.Herd, uniqueRefs(refs))
+	if err != nil {
+		return nil, err
+	}
+	defer wipeSecrets(values)
+
+	if l.Delivery == SecretEnv {
+		if lifetime > 0 && (l.Timeout <= 0 || l.Timeout > lifetime) {
+			return nil, fmt.Errorf("secrets delivered as environment variables need a timeout within their %v lifetime; use file delivery instead", lifetime)
+		}
+		for _, name := range names {
+			if ref, ok := refs[name]; ok {
+				sliceEnv.Env = append(sliceEnv.Env, name+"="+string(values[ref]))
+			}
+		}
+		return sliceEnv, nil
+	}
+
+	base := l.SecretsDir
+	if base == "" {
+		base = DefaultSecretsDir
+	}
+	if slice == "" {
+		slice = "slice"
+	}
+	files, err := mountSecretFiles(base, l.Herd+"-"+filepath.Base(slice))
+	if err != nil {
+		return nil, err
+	}
+	sliceEnv.files = files
+	paths := make(map[SecretRef]string, len(values))
+	for ref, value := range values {
+		if paths[ref], err = files.write(ref, value); err != nil {
+			sliceEnv.Close()
+			return nil, err
+		}
+	}
+	sliceEnv.Env = append(sliceEnv.Env, "RUNINK_SECRETS_DIR="+files.dir)
+	for _, name := range names {
+		if ref, ok := refs[name]; ok {
+			sliceEnv.Env = append(sliceEnv.Env, name+"_FILE="+paths[ref])
+		}
+	}
+	if lifetime > 0 {
+		files.expiry = time.AfterFunc(lifetime, files.withdraw)
+	}
+	return sliceEnv, nil
+}
+
+// ReadEnvFile reads NAME=VALUE lines. Blank lines and lines starting with
+// # are skipped, and values may be quoted.
+func ReadEnvFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file: %w", err)
+	}
+	defer file.Close()
+
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(strings.TrimPrefix(text, "export "), "=")
+		name = strings.TrimSpace(name)
+		if !ok || !validEnvName(name) {
+			return nil, fmt.Errorf("%s:%d: expected NAME=VALUE", path, line)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env[name] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read env file: %w", err)
+	}
+	return env, nil
+}
+
+// findAssignedSlice returns a slice the agent recorded as assigned to
+// this node. herd may be empty if the slice name is unique.
+func findAssignedSlice(stateDir, herd, name string) (AssignedSlice, error) {
+	data, err := os.ReadFile(filepath.Join(stateDir, "assignments.json"))
+	if err != nil {
+		return AssignedSlice{}, fmt.Errorf("failed to read slice assignments: %w", err)
+	}
+	var slices []AssignedSlice
+	if err := json.Unmarshal(data, &slices); err != nil {
+		return AssignedSlice{}, fmt.Errorf("invalid slice assignments: %w", err)
+	}
+	var found []AssignedSlice
+	for _, slice := range slices {
+		if slice.Name == name && (herd == "" || slice.Herd == herd) {
+			found = append(found, slice)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return AssignedSlice{}, fmt.Errorf("slice %s is not assigned to this node", name)
+	case 1:
+		return found[0], nil
+	}
+	return AssignedSlice{}, errors.New("slice " + name + " exists in several herds: set --herd")
+}
+
+// uniqueRefs returns the distinct references of refs
+func uniqueRefs(refs map[string]SecretRef) []SecretRef {
+	seen := make(map[SecretRef]bool, len(refs))
+	var unique []SecretRef
+	for _, ref := range refs {
+		if !seen[ref] {
+			seen[ref] = true
+			unique = append(unique, ref)
+		}
+	}
+	sort.Slice(unique, func(i, j int) bool { return unique[i].String() < unique[j].String() })
+	return unique
+}
+
+func validEnvName(name string) bool {
+	for i, c := range name {
+		if !(c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (i > 0 && c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return name != ""
+}
@@ -0,0 +1,48 @@
+package runictl
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Audit actions of slice executions
+const (
+	ActionSliceStart = "slice.start"
+	ActionSliceExit  = "slice.exit"
+)
+
+// executionReportTimeout bounds reporting a slice's exit, which happens
+// after the slice's own context may be done
+const executionReportTimeout = 10 * time.Second
+
+// executionEvent is the body of POST /api/v1/audit
+type executionEvent struct {
+	Action   string            `json:"action"`
+	Resource string            `json:"resource"`
+	Details  map[string]string `json:"details,omitempty"`
+}
+
+// RecordExecution appends a slice execution to the Barn audit log. The
+// Barn records this node as the actor; with RBAC its token needs
+// execute:pipelines on the slice.
+func (a *Agent) RecordExecution(ctx context.Context, herd, slice, action string, details map[string]string) error {
+	return a.post(ctx, "/audit", executionEvent{
+		Action:   action,
+		Resource: "/api/v1/herds/" + url.PathEscape(herd) + "/slices/" + url.PathEscape(slice),
+		Details:  details,
+	})
+}
+
+// RecordExit reports a slice's exit with its code, -1 if it did not exit
+// on its own
+func (a *Agent) RecordExit(herd, slice string, code int, details map[string]string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), executionReportTimeout)
+	defer cancel()
+	exit := map[string]string{"exitCode": strconv.Itoa(code)}
+	for name, value := range details {
+		exit[name] = value
+	}
+	return a.RecordExecution(ctx, herd, slice, ActionSliceExit, exit)
+}
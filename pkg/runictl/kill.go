@@ -0,0 +1,249 @@
+package runictl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// DefaultRunDir is where the runtime registers the slices of each run:
+// <dir>/<run-id>/<slice>.json
+const DefaultRunDir = "/run/runink/runs"
+
+// DefaultGracePeriod is how long slices have to exit after SIGTERM
+const DefaultGracePeriod = 10 * time.Second
+
+// pidfd syscalls, numbered the same on all architectures
+const (
+	sysPidfdSendSignal = 424
+	sysPidfdOpen       = 434
+)
+
+// killPollInterval is how often terminating slices are checked for exit
+const killPollInterval = 50 * time.Millisecond
+
+// SliceProcess is a running slice as registered by the runtime
+type SliceProcess struct {
+	Pid       int    `json:"pid"`
+	Pgid      int    `json:"pgid"`
+	StartTime uint64 `json:"start_time"`
+
+	// Cgroup is the absolute path of the slice's cgroup the agent
+	// resolved; records of older agents hold it relative to CgroupRoot()
+	Cgroup string `json:"cgroup,omitempty"`
+
+	pidfd int
+}
+
+// NewKillCommand returns the command that stops all slices of a run
+func NewKillCommand() *cobra.Command {
+	var (
+		runID  string
+		runDir string
+		grace  time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "kill --run-id <run-id>",
+		Short: "Stop all slices of a run",
+		Long: `Send SIGTERM to the process group of every slice of a run, wait for the
+grace period, then SIGKILL whatever is left and remove the slices' cgroups.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			killed, err := KillRun(runDir, runID, grace)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Stopped %d slice(s) of run %s\n", killed, runID)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&runID, "run-id", "", "Run whose slices to stop")
+	cmd.Flags().StringVar(&runDir, "run-dir", DefaultRunDir, "Directory the runtime registers running slices in")
+	cmd.Flags().DurationVar(&grace, "grace", DefaultGracePeriod, "Time slices get to exit after SIGTERM before they are killed")
+	cmd.MarkFlagRequired("run-id")
+
+	return cmd
+}
+
+// KillRun stops every registered slice of a run and returns how many were
+// still running. Records of slices that already exited are removed.
+func KillRun(runDir, runID string, grace time.Duration) (int, error) {
+	if runID == "" || filepath.Base(runID) != runID {
+		return 0, fmt.Errorf("invalid run id: %q", runID)
+	}
+	paths, err := filepath.Glob(filepath.Join(runDir, runID, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	if len(paths) == 0 {
+		return 0, fmt.Errorf("no running slices found for run %s", runID)
+	}
+
+	var slices []*SliceProcess
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		p := &SliceProcess{}
+		if err := json.Unmarshal(data, p); err != nil {
+			return 0, fmt.Errorf("invalid slice record %s: %w", path, err)
+		}
+		if err := p.open(); err != nil {
+			// Stale record of a slice that already exited
+			os.Remove(path)
+			continue
+		}
+		defer p.close()
+		slices = append(slices, p)
+	}
+
+	// Signal all slices first so they shut down in parallel
+	for _, p := range slices {
+		if err := p.signal(syscall.SIGTERM); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to signal slice %d: %v\n", p.Pid, err)
+		}
+	}
+	deadline := time.Now().Add(grace)
+	for _, p := range slices {
+		for p.alive() && time.Now().Before(deadline) {
+			time.Sleep(killPollInterval)
+		}
+	}
+
+	var errs []error
+	for _, p := range slices {
+		if err := p.kill(); err != nil {
+			errs = append(errs, fmt.Errorf("slice %d: %w", p.Pid, err))
+		}
+		if p.Cgroup != "" {
+			if err := removeCgroup(p.cgroupPath()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return len(slices), errors.Join(errs...)
+}
+
+// open pins the slice's leader with a pidfd and checks it is still the
+// registered process rather than a later one that reused the pid
+func (p *SliceProcess) open() error {
+	p.pidfd = -1
+	if fd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(p.Pid), 0, 0); errno == 0 {
+		p.pidfd = int(fd)
+	} else if errno == syscall.ESRCH {
+		return fmt.Errorf("process %d has exited", p.Pid)
+	}
+
+	started, err := processStartTime(p.Pid)
+	if err != nil || (p.StartTime != 0 && started != p.StartTime) {
+		p.close()
+		return fmt.Errorf("process %d is no longer the registered slice", p.Pid)
+	}
+	if p.Pgid == 0 {
+		p.Pgid = p.Pid
+	}
+	return nil
+}
+
+// signal sends sig to the leader and every process in its group
+func (p *SliceProcess) signal(sig syscall.Signal) error {
+	var err error
+	if p.pidfd >= 0 {
+		if _, _, errno := syscall.Syscall6(sysPidfdSendSignal, uintptr(p.pidfd), uintptr(sig), 0, 0, 0, 0); errno != 0 && errno != syscall.ESRCH {
+			err = errno
+		}
+	}
+	if groupErr := syscall.Kill(-p.Pgid, sig); groupErr != nil && groupErr != syscall.ESRCH {
+		err = groupErr
+	}
+	return err
+}
+
+// kill sends SIGKILL to the group and to everything left in the cgroup.
+// Once the leader is gone its group id may be reused, so only the cgroup
+// is killed.
+func (p *SliceProcess) kill() error {
+	var err error
+	if p.alive() {
+		err = p.signal(syscall.SIGKILL)
+	}
+	if p.Cgroup != "" {
+		// cgroup.kill exists since Linux 5.14
+		killFile := filepath.Join(p.cgroupPath(), "cgroup.kill")
+		if _, statErr := os.Stat(killFile); statErr == nil {
+			if writeErr := os.WriteFile(killFile, []byte("1"), 0644); writeErr != nil && err == nil {
+				err = writeErr
+			}
+		}
+	}
+	return err
+}
+
+// cgroupPath returns the absolute path of the slice's cgroup
+func (p *SliceProcess) cgroupPath() string {
+	if filepath.IsAbs(p.Cgroup) {
+		return p.Cgroup
+	}
+	return filepath.Join(CgroupRoot(), p.Cgroup)
+}
+
+// alive reports whether the slice's leader is still running
+func (p *SliceProcess) alive() bool {
+	if p.pidfd >= 0 {
+		_, _, errno := syscall.Syscall6(sysPidfdSendSignal, uintptr(p.pidfd), 0, 0, 0, 0, 0)
+		return errno == 0 || errno == syscall.EPERM
+	}
+	started, err := processStartTime(p.Pid)
+	return err == nil && started == p.StartTime
+}
+
+// close releases the pidfd
+func (p *SliceProcess) close() {
+	if p.pidfd >= 0 {
+		syscall.Close(p.pidfd)
+		p.pidfd = -1
+	}
+}
+
+// processStartTime reads a process's start time from /proc/<pid>/stat
+func processStartTime(pid int) (uint64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	// The command name may contain spaces; fields resume after its ")"
+	end := strings.LastIndexByte(string(data), ')')
+	if end < 0 {
+		return 0, fmt.Errorf("malformed stat for process %d", pid)
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("malformed stat for process %d", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// removeCgroup removes a slice cgroup once its killed processes are gone
+func removeCgroup(path string) error {
+	var err error
+	for i := 0; i < 20; i++ {
+		if err = syscall.Rmdir(path); err == nil || err == syscall.ENOENT {
+			return nil
+		}
+		time.Sleep(killPollInterval)
+	}
+	return fmt.Errorf("failed to remove cgroup %s: %w", path, err)
+}
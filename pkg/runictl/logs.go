@@ -0,0 +1,170 @@
+package runictl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// DefaultLogDir is the root of the slice log tree written by the runtime:
+// <dir>/<run-id>/<slice>.log, rotated to <slice>.log.<time>
+const DefaultLogDir = "/var/log/runink/slices"
+
+// logPollInterval is how often a followed log is checked for new records
+const logPollInterval = 250 * time.Millisecond
+
+// LogRecord is one line of slice output as stored in a slice log
+type LogRecord struct {
+	Time   time.Time `json:"time"`
+	Run    string    `json:"run,omitempty"`
+	Slice  string    `json:"slice"`
+	Tag    string    `json:"tag,omitempty"`
+	Stream string    `json:"stream"`
+	Line   string    `json:"line"`
+}
+
+func newLogsCommand() *cobra.Command {
+	var (
+		dir    string
+		runID  string
+		follow bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "logs <slice-id>",
+		Short: "Show the output of a slice",
+		Long: `Print the log of a slice, optionally following it while the slice runs.
+
+Without --run-id, the most recent run of the slice is shown.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := FindSliceLog(dir, runID, args[0])
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+			defer stop()
+			return FollowSliceLog(ctx, path, cmd.OutOrStdout(), follow)
+		},
+	}
+
+	cmd.Flags().StringVar(&dir, "log-dir", DefaultLogDir, "Root directory of the slice logs")
+	cmd.Flags().StringVar(&runID, "run-id", "", "Run the slice belongs to (default: the latest run)")
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "Keep printing new output until interrupted")
+
+	return cmd
+}
+
+// FindSliceLog returns the current log file of a slice. Without a run ID it
+// picks the run whose log for the slice was written last.
+func FindSliceLog(dir, runID, slice string) (string, error) {
+	if slice == "" || filepath.Base(slice) != slice {
+		return "", fmt.Errorf("invalid slice id: %q", slice)
+	}
+	if runID != "" && filepath.Base(runID) != runID {
+		return "", fmt.Errorf("invalid run id: %q", runID)
+	}
+	if runID != "" {
+		path := filepath.Join(dir, runID, slice+".log")
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("no log for slice %s in run %s: %w", slice, runID, err)
+		}
+		return path, nil
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*", slice+".log"))
+	if err != nil {
+		return "", err
+	}
+	var latest string
+	var latestTime time.Time
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err == nil && info.ModTime().After(latestTime) {
+			latest, latestTime = path, info.ModTime()
+		}
+	}
+	if latest == "" {
+		return "", fmt.Errorf("no logs found for slice %s in %s", slice, dir)
+	}
+	return latest, nil
+}
+
+// FollowSliceLog prints the records of a slice log as "time [stream] line".
+// With follow set it waits for new records, across rotations, until ctx is done.
+func FollowSliceLog(ctx context.Context, path string, w io.Writer, follow bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open slice log: %w", err)
+	}
+	defer func() { file.Close() }()
+
+	reader := bufio.NewReader(file)
+	var partial []byte
+	for {
+		chunk, err := reader.ReadBytes('\n')
+		partial = append(partial, chunk...)
+		if err == nil {
+			if err := printLogRecord(w, partial); err != nil {
+				return err
+			}
+			partial = nil
+			continue
+		}
+		if err != io.EOF {
+			return fmt.Errorf("failed to read slice log: %w", err)
+		}
+		if !follow {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(logPollInterval):
+		}
+
+		// The runtime renamed the file aside; drain it and open the new one
+		current, statErr := os.Stat(path)
+		opened, openedErr := file.Stat()
+		if statErr != nil || openedErr != nil || os.SameFile(current, opened) {
+			continue
+		}
+		rest, _ := io.ReadAll(reader)
+		for _, line := range bytes.SplitAfter(append(partial, rest...), []byte("\n")) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				printLogRecord(w, line)
+			}
+		}
+		partial = nil
+
+		next, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		file.Close()
+		file = next
+		reader = bufio.NewReader(file)
+	}
+}
+
+// printLogRecord formats one JSON record; other lines are printed unchanged
+func printLogRecord(w io.Writer, line []byte) error {
+	record := LogRecord{}
+	if err := json.Unmarshal(line, &record); err != nil {
+		_, err := w.Write(line)
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%s [%s] %s\n", record.Time.Format(time.RFC3339Nano), record.Stream, record.Line)
+	return err
+}
@@ -0,0 +1,113 @@
+package runictl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// appendLogRecord appends a record to a slice log the way the runtime writes it
+func appendLogRecord(t *testing.T, path, stream, line string) {
+	t.Helper()
+	data, err := json.Marshal(LogRecord{Time: time.Now().UTC(), Run: "run-1", Slice: "tail", Stream: stream, Line: line})
+	if err != nil {
+		t.Fatalf("Failed to encode record: %v", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		t.Fatalf("Failed to write record: %v", err)
+	}
+}
+
+// TestFindSliceLog tests picking the log of a run, or of the latest run
+func TestFindSliceLog(t *testing.T) {
+	dir := t.TempDir()
+	for _, run := range []string{"run-1", "run-2"} {
+		os.MkdirAll(filepath.Join(dir, run), 0755)
+		appendLogRecord(t, filepath.Join(dir, run, "tail.log"), "stdout", run)
+	}
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(dir, "run-1", "tail.log"), old, old)
+
+	if path, err := FindSliceLog(dir, "", "tail"); err != nil || path != filepath.Join(dir, "run-2", "tail.log") {
+		t.Errorf("Expected the latest run, got %q (%v)", path, err)
+	}
+	if path, err := FindSliceLog(dir, "run-1", "tail"); err != nil || path != filepath.Join(dir, "run-1", "tail.log") {
+		t.Errorf("Expected run-1, got %q (%v)", path, err)
+	}
+	for _, ids := range [][2]string{{"", "missing"}, {"run-3", "tail"}, {"", "../tail"}, {"../run-1", "tail"}} {
+		if _, err := FindSliceLog(dir, ids[0], ids[1]); err == nil {
+			t.Errorf("Expected run %q slice %q to fail", ids[0], ids[1])
+		}
+	}
+}
+
+// TestFollowSliceLog tests that following a log picks up new and rotated records
+func TestFollowSliceLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tail.log")
+	appendLogRecord(t, path, "stderr", "before")
+
+	var out safeBuffer
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- FollowSliceLog(ctx, path, &out, true) }()
+
+	// Rotate the file while it is being followed, as the runtime does
+	time.Sleep(100 * time.Millisecond)
+	appendLogRecord(t, path, "stderr", "before rotation")
+	if err := os.Rename(path, path+".20250101T000000.000000000"); err != nil {
+		t.Fatalf("Failed to rotate log: %v", err)
+	}
+	appendLogRecord(t, path, "stdout", "after rotation")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), "after rotation") && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("FollowSliceLog failed: %v", err)
+	}
+
+	output := out.String()
+	for _, expected := range []string{"[stderr] before\n", "[stderr] before rotation\n", "[stdout] after rotation\n"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected %q in %q", expected, output)
+		}
+	}
+
+	// Without follow it stops at the end of the file; other lines pass through
+	os.WriteFile(path, []byte("not json\n"), 0644)
+	out = safeBuffer{}
+	if err := FollowSliceLog(context.Background(), path, &out, false); err != nil || out.String() != "not json\n" {
+		t.Errorf("Expected the raw line, got %q (%v)", out.String(), err)
+	}
+}
+
+// safeBuffer is a bytes.Buffer safe for concurrent use
+type safeBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *safeBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *safeBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,474 @@
+package runictl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// DefaultSecretsDir is where root agents mount the tmpfs holding a slice's
+// secret files: <dir>/<herd>-<slice>-<random>
+const DefaultSecretsDir = "/run/runink/secrets"
+
+// tmpfsMagic is the f_type statfs reports for tmpfs
+const tmpfsMagic = 0x01021994
+
+// ErrCrossHerdSecret is returned for a reference to another herd's secret
+// when either herd's secrets scope forbids it
+var ErrCrossHerdSecret = errors.New("cross-herd secret references are not allowed")
+
+var (
+	secretHerdRE = regexp.MustCompile(`^[a-z0-9]([-a-z0-9_.]{0,61}[a-z0-9])?$`)
+	secretNameRE = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]{0,251}[A-Za-z0-9])?$`)
+)
+
+// SecretRef refers to the latest version of a secret stored in Barn, as in
+// secret://finance/warehouse_password
+type SecretRef struct {
+	Herd string
+	Name string
+}
+
+// ParseSecretRef parses a secret://<herd>/<name> reference
+func ParseSecretRef(s string) (SecretRef, error) {
+	rest := strings.TrimPrefix(s, "secret://")
+	if rest == s {
+		// Not a reference, so possibly a plain credential: keep it out of the error
+		return SecretRef{}, errors.New("invalid secret reference: expected secret://<herd>/<name>")
+	}
+	herd, name, ok := strings.Cut(rest, "/")
+	if !ok || !secretHerdRE.MatchString(herd) || !secretNameRE.MatchString(name) {
+		return SecretRef{}, fmt.Errorf("invalid secret reference %q: expected secret://<herd>/<name>", s)
+	}
+	return SecretRef{Herd: herd, Name: name}, nil
+}
+
+// String returns the reference as a secret:// URI
+func (r SecretRef) String() string {
+	return "secret://" + r.Herd + "/" + r.Name
+}
+
+// SecretScope is the part of a herd's secrets scope that applies to slices
+type SecretScope struct {
+	AllowCrossHerd bool
+	Lifetime       time.Duration
+}
+
+// SecretsClient reads secrets and herd scopes from the Barn with
+// consistent reads, presenting the token in TokenFile if set
+type SecretsClient struct {
+	Endpoint  string
+	HTTP      *http.Client
+	TokenFile string
+}
+
+// Secret returns the latest version of a secret
+func (c *SecretsClient) Secret(ctx context.Context, ref SecretRef) ([]byte, error) {
+	var secret struct {
+		Value []byte `json:"value"`
+	}
+	path := "/herds/" + url.PathEscape(ref.Herd) + "/secrets/" + url.PathEscape(ref.Name)
+	if err := c.agent().get(ctx, path+"?consistent=true", &secret); err != nil {
+		return nil, fmt.Errorf("failed to read secret %s: %w", ref, err)
+	}
+	return secret.Value, nil
+}
+
+// Scope returns the secrets scope of a herd
+func (c *SecretsClient) Scope(ctx context.Context, herd string) (SecretScope, error) {
+	var resource struct {
+		Secrets struct {
+			AllowCrossHerd       bool `json:"allowCrossHerd"`
+			TokenLifetimeSeconds int  `json:"tokenLifetimeSeconds"`
+		} `json:"secretsScope"`
+	}
+	if err := c.agent().get(ctx, "/herds/"+url.PathEscape(herd)+"?consistent=true", &resource); err != nil {
+		return SecretScope{}, fmt.Errorf("failed to read herd %s: %w", herd, err)
+	}
+	return SecretScope{
+		AllowCrossHerd: resource.Secrets.AllowCrossHerd,
+		Lifetime:       time.Duration(resource.Secrets.TokenLifetimeSeconds) * time.Second,
+	}, nil
+}
+
+// agent returns an Agent for the client's endpoint, to share its requests
+func (c *SecretsClient) agent() *Agent {
+	endpoint := c.Endpoint
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Agent{Endpoint: strings.TrimSuffix(endpoint, "/"), HTTP: client, TokenFile: c.TokenFile}
+}
+
+// resolveSecrets reads the referenced secrets of a slice of herd after
+// checking them against the secrets scopes: a reference to another herd
+// needs both herds to allow cross-herd references. It returns the values
+// and how long they may stay available, the shortest lifetime involved.
+func resolveSecrets(ctx context.Context, client *SecretsClient, herd string, refs []SecretRef) (map[SecretRef][]byte, time.Duration, error) {
+	scopes := make(map[string]SecretScope)
+	scope := func(name string) (SecretScope, error) {
+		if s, ok := scopes[name]; ok {
+			return s, nil
+		}
+		s, err := client.Scope(ctx, name)
+		scopes[name] = s
+		return s, err
+	}
+
+	own, err := scope(herd)
+	if err != nil {
+		return nil, 0, err
+	}
+	lifetime := own.Lifetime
+	values := make(map[SecretRef][]byte, len(refs))
+	for _, ref := range refs {
+		if _, ok := values[ref]; ok {
+			continue
+		}
+		if ref.Herd != herd {
+			if !own.AllowCrossHerd {
+				return nil, 0, fmt.Errorf("secret %s in a slice of herd %s: %w", ref, herd, ErrCrossHerdSecret)
+			}
+			owner, err := scope(ref.Herd)
+			if err != nil {
+				return nil, 0, err
+			}
+			if !owner.AllowCrossHerd {
+				return nil, 0, fmt.Errorf("secret %s: herd %s does not share its secrets: %w", ref, ref.Herd, ErrCrossHerdSecret)
+			}
+			if owner.Lifetime > 0 && (lifetime == 0 || owner.Lifetime < lifetime) {
+				lifetime = owner.Lifetime
+			}
+		}
+		value, err := client.Secret(ctx, ref)
+		if err != nil {
+			wipeSecrets(values)
+			return nil, 0, err
+		}
+		values[ref] = value
+	}
+	return values, lifetime, nil
+}
+
+// secretFiles is a tmpfs directory holding a slice's secret files
+type secretFiles struct {
+	dir     string
+	mounted bool
+	expiry  *time.Timer
+	once    sync.Once
+}
+
+// mountSecretFiles creates the tmpfs directory for a slice's secrets.
+// Root mounts a fresh tmpfs under base; others use a directory under
+// /dev/shm and refuse anything that is not a tmpfs.
+func mountSecretFiles(base, name string) (*secretFiles, error) {
+	root := os.Geteuid() == 0
+	if !root {
+		base = filepath.Join("/dev/shm", fmt.Sprintf("runink-secrets-%d", os.Getuid()))
+	}
+	if err := os.MkdirAll(base, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create secrets directory: %w", err)
+	}
+	dir, err := os.MkdirTemp(base, name+"-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create secrets directory: %w", err)
+	}
+	files := &secretFiles{dir: dir}
+
+	if root {
+		if err := syscall.Mount("tmpfs", dir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "mode=0700,size=1m"); err != nil {
+			files.Close()
+			return nil, fmt.Errorf("failed to mount secrets tmpfs at %s: %w", dir, err)
+		}
+		files.mounted = true
+		return files, nil
+	}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(dir, &fs); err != nil || int64(fs.Type) != tmpfsMagic {
+		files.Close()
+		return nil, fmt.Errorf("secret files need a tmpfs at %s: run as root or use --delivery env", dir)
+	}
+	return files, nil
+}
+
+// write stores a secret as <dir>/<herd>/<name> with mode 0400 and returns
+// its path
+func (f *secretFiles) write(ref SecretRef, value []byte) (string, error) {
+	herdDir := filepath.Join(f.dir, ref.Herd)
+	path := filepath.Join(herdDir, ref.Name)
+	if err := os.MkdirAll(herdDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to write secret %s: %w", ref, err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0400)
+	if err != nil {
+		return "", fmt.Errorf("failed to write secret %s: %w", ref, err)
+	}
+	_, err = file.Write(value)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write secret %s: %w", ref, err)
+	}
+	return path, nil
+}
+
+// withdraw overwrites and removes the secret files
+func (f *secretFiles) withdraw() {
+	f.once.Do(func() {
+		filepath.Walk(f.dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() {
+				return nil
+			}
+			if file, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+				file.Write(make([]byte, info.Size()))
+				file.Close()
+			}
+			return os.Remove(path)
+		})
+	})
+}
+
+// Close withdraws the secrets and removes their tmpfs
+func (f *secretFiles) Close() {
+	if f.expiry != nil {
+		f.expiry.Stop()
+	}
+	f.withdraw()
+	if f.mounted {
+		if err := syscall.Unmount(f.dir, syscall.MNT_DETACH); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to unmount secrets tmpfs %s: %v\n", f.dir, err)
+		}
+	}
+	os.RemoveAll(f.dir)
+}
+
+// wipeSecrets overwrites resolved values once they were delivered
+func wipeSecrets(values map[SecretRef][]byte) {
+	for _, value := range values {
+		for i := range value {
+			value[i] = 0
+		}
+	}
+}
+
+// secretsOptions are the flags shared by the secrets subcommands
+type secretsOptions struct {
+	endpoint  string
+	tokenFile string
+	stateDir  string
+	herd      string
+	slice     string
+	env       []string
+	envFile   string
+}
+
+func (o *secretsOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&o.endpoint, "barn-endpoint", DefaultBarnEndpoint, "Barn API endpoint")
+	cmd.Flags().StringVar(&o.tokenFile, "token-file", os.Getenv("BARN_TOKEN_FILE"), "File with the token to present to the Barn, from herdctl token mint")
+	cmd.Flags().StringVar(&o.stateDir, "state-dir", DefaultAgentStateDir, "Agent state directory holding the assigned slices")
+	cmd.Flags().StringVar(&o.herd, "herd", "", "Herd the slice belongs to")
+	cmd.Flags().StringVar(&o.slice, "slice", "", "Take the environment of this slice assigned to the node")
+	cmd.Flags().StringArrayVar(&o.env, "env", nil, "Environment variable NAME=VALUE; VALUE may be a secret:// reference")
+	cmd.Flags().StringVar(&o.envFile, "env-file", "", "File of NAME=VALUE lines; values may be secret:// references")
+}
+
+// loader returns the env loader and the slice's environment
+func (o *secretsOptions) loader() (*EnvLoader, map[string]string, error) {
+	env := make(map[string]string)
+	if o.slice != "" {
+		slice, err := findAssignedSlice(o.stateDir, o.herd, o.slice)
+		if err != nil {
+			return nil, nil, err
+		}
+		o.herd = slice.Herd
+		for name, value := range slice.Env {
+			env[name] = value
+		}
+	}
+	if o.herd == "" {
+		return nil, nil, errors.New("--herd or --slice is required")
+	}
+	if o.envFile != "" {
+		fileEnv, err := ReadEnvFile(o.envFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		for name, value := range fileEnv {
+			env[name] = value
+		}
+	}
+	for _, entry := range o.env {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || !validEnvName(name) {
+			return nil, nil, fmt.Errorf("invalid --env %q: expected NAME=VALUE", name)
+		}
+		env[name] = value
+	}
+	loader := &EnvLoader{Secrets: &SecretsClient{Endpoint: o.endpoint, TokenFile: o.tokenFile}, Herd: o.herd, SecretsDir: DefaultSecretsDir}
+	return loader, env, nil
+}
+
+func newSecretsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "secrets",
+		Short: "Inject Barn secrets into slices",
+		Long: `Resolve the secret:// references in a slice's environment when it launches.
+Secrets are read from the Barn and delivered as files on a private tmpfs or
+as environment variables; they are never written to disk or logs.`,
+	}
+	cmd.AddCommand(newSecretsExecCommand(), newSecretsCheckCommand())
+	return cmd
+}
+
+func newSecretsExecCommand() *cobra.Command {
+	options := &secretsOptions{}
+	var delivery string
+	var timeout time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "exec [flags] -- <command> [args...]",
+		Short: "Run a command with its secrets resolved",
+		Long: `Run a command in the environment of a slice, with every secret:// value
+resolved. With --delivery file (the default), NAME_FILE names a file on a
+private tmpfs that holds the secret; with --delivery env, NAME holds the
+secret itself. The herd's secrets scope is enforced: references to other
+herds' secrets need both herds to set allow_cross_herd, and the files are
+withdrawn after token_lifetime_seconds. Environment variables cannot be
+withdrawn, so env delivery needs a --timeout within the lifetime. The start
+and exit of the command are recorded in the Barn audit log; with RBAC the
+token needs execute:pipelines on the slice.
+
+  runictl secrets exec --herd finance \
+    --env DB_PASSWORD=secret://finance/warehouse_password -- /usr/local/bin/load`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			loader, env, err := options.loader()
+			if err != nil {
+				return err
+			}
+			if loader.Delivery, err = ParseSecretDelivery(delivery); err != nil {
+				return err
+			}
+			loader.Timeout = timeout
+			sliceEnv, err := loader.Load(ctx, options.slice, env)
+			if err != nil {
+				return err
+			}
+			defer sliceEnv.Close()
+
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			// Executions are audited; one that cannot be is not started
+			agent, slice := loader.Secrets.agent(), options.slice
+			if slice == "" {
+				slice = filepath.Base(args[0])
+			}
+			details := map[string]string{"command": args[0], "delivery": string(loader.Delivery)}
+			if err := agent.RecordExecution(ctx, loader.Herd, slice, ActionSliceStart, details); err != nil {
+				return fmt.Errorf("failed to audit the start of slice %s: %w", slice, err)
+			}
+
+			command := exec.CommandContext(ctx, args[0], args[1:]...)
+			command.Env = append(os.Environ(), sliceEnv.Env...)
+			command.Stdin, command.Stdout, command.Stderr = os.Stdin, cmd.OutOrStdout(), cmd.ErrOrStderr()
+			command.Cancel = func() error { return command.Process.Signal(syscall.SIGTERM) }
+			command.WaitDelay = DefaultGracePeriod
+			err = command.Run()
+			code := 0
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				code = exitErr.ExitCode()
+			} else if err != nil {
+				code = -1
+			}
+			if err := agent.RecordExit(loader.Herd, slice, code, map[string]string{"command": args[0]}); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to audit the exit of slice %s: %v\n", slice, err)
+			}
+			if code > 0 {
+				sliceEnv.Close()
+				os.Exit(code)
+			}
+			return err
+		},
+	}
+	options.addFlags(cmd)
+	cmd.Flags().StringVar(&delivery, "delivery", string(SecretFiles), "How secrets reach the command: file or env")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "Stop the command after this long")
+	return cmd
+}
+
+func newSecretsCheckCommand() *cobra.Command {
+	options := &secretsOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Check that a slice's secret references resolve",
+		Long: `Resolve the secret:// references in a slice's environment against the Barn
+and the herds' secrets scopes without delivering them. Values are never
+printed.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			loader, env, err := options.loader()
+			if err != nil {
+				return err
+			}
+			refs, err := loader.References(env)
+			if err != nil {
+				return err
+			}
+			if len(refs) == 0 {
+				fmt.Fprintln(cmd.OutOrStdout(), "No secret references")
+				return nil
+			}
+			values, lifetime, err := resolveSecrets(cmd.Context(), loader.Secrets, loader.Herd, uniqueRefs(refs))
+			if err != nil {
+				return err
+			}
+			wipeSecrets(values)
+
+			names := make([]string, 0, len(refs))
+			for name := range refs {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tSECRET")
+			for _, name := range names {
+				fmt.Fprintf(w, "%s\t%s\n", name, refs[name])
+			}
+			w.Flush()
+			if lifetime > 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "Secrets are withdrawn after %v\n", lifetime)
+			}
+			return nil
+		},
+	}
+	options.addFlags(cmd)
+	return cmd
+}
@@ -1,6 +1,8 @@
 package runink
 
 import (
+	"fmt"
+
 	"github.com/spf13/cobra"
 )
 
@@ -73,14 +75,34 @@ func newOptimizerCommand() *cobra.Command {
 }
 
 func newRenderCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "render",
+	opts := RenderOptions{}
+	var noBuild bool
+
+	cmd := &cobra.Command{
+		Use:   "render <feature-file>",
 		Short: "Render a DAG into executable Go code",
+		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// TODO: Implement DAG rendering
+			opts.FeaturePath = args[0]
+			opts.Build = !noBuild
+			if err := Render(opts); err != nil {
+				return err
+			}
+			if opts.Build {
+				fmt.Fprintf(cmd.OutOrStdout(), "Built pipeline %s from %s\n", opts.Output, opts.FeaturePath)
+			} else {
+				fmt.Fprintf(cmd.OutOrStdout(), "Rendered pipeline source to %s\n", opts.OutDir)
+			}
 			return nil
 		},
 	}
+
+	cmd.Flags().StringVar(&opts.ContractPath, "contract", "", "Contract file or package directory (default: the feature's contract metadata)")
+	cmd.Flags().StringVar(&opts.ContractsDir, "contracts-dir", "contracts", "Directory the feature's contract metadata is relative to")
+	cmd.Flags().StringVar(&opts.OutDir, "out", "build/pipeline", "Directory to write the generated Go module to")
+	cmd.Flags().StringVarP(&opts.Output, "output", "o", "pipeline", "Path of the compiled pipeline binary")
+	cmd.Flags().BoolVar(&noBuild, "no-build", false, "Only generate the Go source, skip go build")
+	return cmd
 }
 
 func newComplianceCommand() *cobra.Command {
@@ -0,0 +1,173 @@
+package runink
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Contract is a Go contract package whose step functions a pipeline calls
+type Contract struct {
+	Package string
+	Files   []string
+	Steps   []string
+	Funcs   map[string]bool
+	Vars    map[string]bool
+}
+
+// LoadContract loads a contract from a single file or a package directory.
+// Step functions are exported funcs with the signature func(io.Reader, io.Writer) error;
+// their order comes from the package-level Steps variable when present.
+func LoadContract(path string) (*Contract, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("stat contract: %w", err)
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files, err = contractFiles(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	contract := &Contract{
+		Funcs: make(map[string]bool),
+		Vars:  make(map[string]bool),
+	}
+
+	fset := token.NewFileSet()
+	var declaredOrder []string
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read contract: %w", err)
+		}
+		f, err := parser.ParseFile(fset, file, src, 0)
+		if err != nil {
+			return nil, fmt.Errorf("parse contract: %w", err)
+		}
+
+		if contract.Package == "" {
+			contract.Package = f.Name.Name
+		} else if contract.Package != f.Name.Name {
+			return nil, fmt.Errorf("contract files mix packages %s and %s", contract.Package, f.Name.Name)
+		}
+		contract.Files = append(contract.Files, file)
+
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil && d.Name.IsExported() && isStepSignature(d.Type) {
+					contract.Funcs[d.Name.Name] = true
+					declaredOrder = append(declaredOrder, d.Name.Name)
+				}
+			case *ast.GenDecl:
+				if d.Tok != token.VAR {
+					continue
+				}
+				for _, spec := range d.Specs {
+					collectContractVars(contract, spec.(*ast.ValueSpec))
+				}
+			}
+		}
+	}
+
+	if len(contract.Funcs) == 0 {
+		return nil, fmt.Errorf("contract %s declares no step functions", path)
+	}
+
+	// Fall back to declaration order when the contract has no Steps variable
+	if len(contract.Steps) == 0 {
+		contract.Steps = declaredOrder
+	}
+	for _, step := range contract.Steps {
+		if !contract.Funcs[step] {
+			return nil, fmt.Errorf("contract step %s is not a func(io.Reader, io.Writer) error", step)
+		}
+	}
+
+	return contract, nil
+}
+
+// contractFiles lists the Go and .contract sources of a contract directory
+func contractFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read contract dir: %w", err)
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		if strings.HasSuffix(name, ".go") || strings.HasSuffix(name, ".contract") {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no contract sources in %s", dir)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// collectContractVars records exported string vars and the Steps order
+func collectContractVars(contract *Contract, spec *ast.ValueSpec) {
+	for i, name := range spec.Names {
+		if name.Name == "Steps" && i < len(spec.Values) {
+			if lit, ok := spec.Values[i].(*ast.CompositeLit); ok {
+				for _, elt := range lit.Elts {
+					if basic, ok := elt.(*ast.BasicLit); ok && basic.Kind == token.STRING {
+						if step, err := strconv.Unquote(basic.Value); err == nil {
+							contract.Steps = append(contract.Steps, step)
+						}
+					}
+				}
+			}
+			continue
+		}
+		if ident, ok := spec.Type.(*ast.Ident); ok && ident.Name == "string" && name.IsExported() {
+			contract.Vars[name.Name] = true
+		}
+	}
+}
+
+// isStepSignature reports whether ft is func(io.Reader, io.Writer) error
+func isStepSignature(ft *ast.FuncType) bool {
+	if ft.Params == nil || ft.Results == nil || len(ft.Results.List) != 1 {
+		return false
+	}
+	var params []string
+	for _, field := range ft.Params.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			params = append(params, typeString(field.Type))
+		}
+	}
+	return len(params) == 2 &&
+		params[0] == "io.Reader" && params[1] == "io.Writer" &&
+		typeString(ft.Results.List[0].Type) == "error"
+}
+
+// typeString renders simple identifiers and qualified type names
+func typeString(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.SelectorExpr:
+		return typeString(t.X) + "." + t.Sel.Name
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+package runink
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestLoadContract tests loading the cdm_trade demo contract
+func TestLoadContract(t *testing.T) {
+	contract, err := LoadContract(cdmTradeContract)
+	if err != nil {
+		t.Fatalf("Failed to load contract: %v", err)
+	}
+	if contract.Package != "contracts" {
+		t.Errorf("Unexpected package %q", contract.Package)
+	}
+	steps := []string{"DecodeCDMEvent", "ValidateMandatoryFields", "ApplyFieldMasking", "TagComplianceMetadata",
+		"DetectSchemaDrift", "RouteValidRecords", "RouteInvalidRecords"}
+	if !reflect.DeepEqual(contract.Steps, steps) {
+		t.Errorf("Unexpected steps: %v", contract.Steps)
+	}
+	if !contract.Vars["ContractVersion"] || !contract.Vars["DefaultMaskingPolicy"] {
+		t.Errorf("Unexpected vars: %v", contract.Vars)
+	}
+	if contract.Funcs["applyMask"] {
+		t.Error("Expected unexported helpers not to be steps")
+	}
+}
+
+// TestLoadContractOrder tests the declaration order fallback and a package
+// split across files
+func TestLoadContractOrder(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.go":      "package c\n\nimport \"io\"\n\nfunc Second(r io.Reader, w io.Writer) error { return nil }\n",
+		"b.go":      "package c\n\nimport \"io\"\n\nfunc Third(r io.Reader, w io.Writer) error { return nil }\n\nfunc helper(r io.Reader) error { return nil }\n",
+		"0.go":      "package c\n\nimport \"io\"\n\nfunc First(in io.Reader, out io.Writer) error { return nil }\n",
+		"c_test.go": "package c\n\nimport \"io\"\n\nfunc Ignored(r io.Reader, w io.Writer) error { return nil }\n",
+	}
+	for name, src := range files {
+		os.WriteFile(filepath.Join(dir, name), []byte(src), 0644)
+	}
+
+	contract, err := LoadContract(dir)
+	if err != nil {
+		t.Fatalf("Failed to load contract: %v", err)
+	}
+	if !reflect.DeepEqual(contract.Steps, []string{"First", "Second", "Third"}) {
+		t.Errorf("Unexpected steps: %v", contract.Steps)
+	}
+}
+
+// TestLoadContractErrors tests contracts that cannot drive a pipeline
+func TestLoadContractErrors(t *testing.T) {
+	tests := map[string]string{
+		"no step functions": "package c\n\nfunc Step() error { return nil }\n",
+		"unknown step":      "package c\n\nimport \"io\"\n\nvar Steps = []string{\"Missing\"}\n\nfunc Step(r io.Reader, w io.Writer) error { return nil }\n",
+		"syntax error":      "package c\n\nfunc {\n",
+	}
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "c.contract")
+			os.WriteFile(path, []byte(src), 0644)
+			if _, err := LoadContract(path); err == nil {
+				t.Error("Expected the contract to be rejected")
+			}
+		})
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.go"), []byte("package a\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "b.go"), []byte("package b\n"), 0644)
+	if _, err := LoadContract(dir); err == nil || !strings.Contains(err.Error(), "mix packages") {
+		t.Errorf("Expected mixed packages to be rejected, got %v", err)
+	}
+}
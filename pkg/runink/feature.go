@@ -0,0 +1,146 @@
+package runink
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Feature is the compiler's view of a feature DSL file
+type Feature struct {
+	Name     string
+	Scenario string
+	Metadata map[string]string
+	Source   FeatureSource
+	Steps    []string
+	Sinks    []FeatureSink
+}
+
+// FeatureSource is the stream a scenario reads from
+type FeatureSource struct {
+	Name string
+	URI  string
+}
+
+// FeatureSink is a routing target declared in a Then block
+type FeatureSink struct {
+	Name    string
+	URI     string
+	Invalid bool
+	Step    string
+}
+
+var (
+	sourcePattern    = regexp.MustCompile(`source\b.*?"([^"]+)"\s+from\s+"([^"]+)"`)
+	sinkPattern      = regexp.MustCompile(`\(sink:\s*"?([^")]+)"?\)`)
+	quotedPattern    = regexp.MustCompile(`"([^"]+)"`)
+	metadataPattern  = regexp.MustCompile(`^\s+([A-Za-z_][A-Za-z0-9_]*):\s*(.+)$`)
+	sectionEndMarker = []string{"Assertions:", "GoldenTest:", "Notifications:"}
+)
+
+// ParseFeatureFile parses a feature DSL file from disk
+func ParseFeatureFile(path string) (*Feature, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open feature: %w", err)
+	}
+	defer f.Close()
+	return ParseFeature(f)
+}
+
+// ParseFeature parses the feature, scenario metadata, source, steps and sinks
+func ParseFeature(r io.Reader) (*Feature, error) {
+	feature := &Feature{Metadata: make(map[string]string)}
+
+	inMetadata, inThen := false, false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "Feature:"):
+			feature.Name = strings.TrimSpace(strings.TrimPrefix(line, "Feature:"))
+			continue
+		case strings.HasPrefix(line, "Scenario:"):
+			feature.Scenario = strings.TrimSpace(strings.TrimPrefix(line, "Scenario:"))
+			continue
+		case line == "Metadata:":
+			inMetadata = true
+			continue
+		case strings.HasPrefix(line, "Then"):
+			inMetadata, inThen = false, true
+			continue
+		case isSectionEnd(line):
+			inMetadata, inThen = false, false
+			continue
+		}
+
+		if inMetadata {
+			if m := metadataPattern.FindStringSubmatch(raw); m != nil {
+				feature.Metadata[m[1]] = normalizeMetadataValue(m[2])
+				continue
+			}
+			inMetadata = false
+		}
+
+		if m := sourcePattern.FindStringSubmatch(line); m != nil && strings.HasPrefix(line, "Given") {
+			feature.Source = FeatureSource{Name: m[1], URI: m[2]}
+			continue
+		}
+
+		if inThen && strings.HasPrefix(line, "- ") {
+			step := strings.TrimSpace(strings.TrimPrefix(line, "- "))
+			if m := sinkPattern.FindStringSubmatch(step); m != nil {
+				sink := FeatureSink{URI: strings.TrimSpace(m[1]), Step: step}
+				if q := quotedPattern.FindStringSubmatch(step); q != nil {
+					sink.Name = q[1]
+				}
+				sink.Invalid = strings.Contains(strings.ToLower(step), "invalid")
+				feature.Sinks = append(feature.Sinks, sink)
+				continue
+			}
+			feature.Steps = append(feature.Steps, step)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read feature: %w", err)
+	}
+
+	if feature.Name == "" {
+		return nil, fmt.Errorf("feature has no Feature: line")
+	}
+	if feature.Source.URI == "" {
+		return nil, fmt.Errorf("feature %q has no source", feature.Name)
+	}
+	return feature, nil
+}
+
+// isSectionEnd reports whether line starts a section that closes the Then block
+func isSectionEnd(line string) bool {
+	for _, marker := range sectionEndMarker {
+		if line == marker {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeMetadataValue strips quotes and flattens ["a", "b"] lists to "a,b"
+func normalizeMetadataValue(value string) string {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+		items := strings.Split(strings.Trim(value, "[]"), ",")
+		for i, item := range items {
+			items[i] = strings.Trim(strings.TrimSpace(item), `"'`)
+		}
+		return strings.Join(items, ",")
+	}
+	return strings.Trim(value, `"'`)
+}
@@ -0,0 +1,50 @@
+package runink
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestParseFeatureFile tests parsing the cdm_trade demo feature
+func TestParseFeatureFile(t *testing.T) {
+	feature, err := ParseFeatureFile(cdmTradeFeature)
+	if err != nil {
+		t.Fatalf("Failed to parse feature: %v", err)
+	}
+
+	if feature.Name != "Financial Trade Event Validation & Compliance" || feature.Scenario != "Validate and Enrich Incoming Trade Events" {
+		t.Errorf("Unexpected feature %q / %q", feature.Name, feature.Scenario)
+	}
+	if feature.Metadata["contract"] != "cdm_trade/fdc3events.contract" || feature.Metadata["compliance"] != "SOX,GDPR,PCI-DSS" {
+		t.Errorf("Unexpected metadata: %v", feature.Metadata)
+	}
+	if feature.Source != (FeatureSource{Name: "Trade Events Stream", URI: "source/sample.csv"}) {
+		t.Errorf("Unexpected source: %+v", feature.Source)
+	}
+	if len(feature.Steps) != 5 || feature.Steps[0] != "Decode each trade event according to CDM format" {
+		t.Errorf("Unexpected steps: %q", feature.Steps)
+	}
+
+	if len(feature.Sinks) != 2 {
+		t.Fatalf("Expected 2 sinks, got %+v", feature.Sinks)
+	}
+	valid, invalid := feature.Sinks[0], feature.Sinks[1]
+	if valid.Name != "Validated Trades Table" || valid.URI != "target/validated.csv" || valid.Invalid || !strings.HasPrefix(valid.Step, "Route valid records") {
+		t.Errorf("Unexpected valid sink: %+v", valid)
+	}
+	if invalid.URI != "target/dql.csv" || !invalid.Invalid {
+		t.Errorf("Unexpected invalid sink: %+v", invalid)
+	}
+}
+
+// TestParseFeatureErrors tests features missing their name or source
+func TestParseFeatureErrors(t *testing.T) {
+	for _, src := range []string{
+		"Scenario: Load\n  Given source \"s\" from \"in.json\"\n",
+		"Feature: Trades\nScenario: Load\n",
+	} {
+		if _, err := ParseFeature(strings.NewReader(src)); err == nil {
+			t.Errorf("Expected %q to be rejected", src)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+package runink
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// RenderOptions configures rendering a feature and contract into a Go program
+type RenderOptions struct {
+	FeaturePath  string
+	ContractPath string
+	ContractsDir string
+	OutDir       string
+	Output       string
+	Build        bool
+}
+
+// PipelineSpec binds the steps and sinks of a feature to contract functions
+type PipelineSpec struct {
+	FeaturePath string
+	Feature     *Feature
+	Contract    *Contract
+	Stages      []PipelineStage
+	ValidSink   FeatureSink
+	InvalidSink FeatureSink
+	ValidRouter string
+	Settings    []ContractSetting
+}
+
+// PipelineStage is one contract step function in the rendered pipeline
+type PipelineStage struct {
+	Func        string
+	Description string
+}
+
+// ContractSetting assigns a feature metadata value to a contract variable
+type ContractSetting struct {
+	Name  string
+	Value string
+}
+
+// PlanPipeline binds each of the feature's steps to the contract step it
+// names, see MatchContractStep, and runs them in the feature's order. The
+// contract step named by the valid sink's line, if any, routes records to
+// the valid sink.
+func PlanPipeline(featurePath string, feature *Feature, contract *Contract) (*PipelineSpec, error) {
+	if len(feature.Steps) == 0 {
+		return nil, fmt.Errorf("feature %q declares no steps", feature.Name)
+	}
+
+	spec := &PipelineSpec{
+		FeaturePath: featurePath,
+		Feature:     feature,
+		Contract:    contract,
+	}
+	bound := make(map[string]string)
+	for _, step := range feature.Steps {
+		fn, err := MatchContractStep(contract, step)
+		if err != nil {
+			return nil, fmt.Errorf("feature %q: %w", feature.Name, err)
+		}
+		if previous, ok := bound[fn]; ok {
+			return nil, fmt.Errorf("feature %q: steps %q and %q both name contract step %s", feature.Name, previous, step, fn)
+		}
+		bound[fn] = step
+		spec.Stages = append(spec.Stages, PipelineStage{
+			Func:        fn,
+			Description: step,
+		})
+	}
+
+	for _, sink := range feature.Sinks {
+		if sink.Invalid {
+			spec.InvalidSink = sink
+		} else if spec.ValidSink.URI == "" {
+			spec.ValidSink = sink
+		}
+	}
+	if spec.ValidSink.URI == "" {
+		return nil, fmt.Errorf("feature %q routes no records to a valid sink", feature.Name)
+	}
+	if fn, err := MatchContractStep(contract, spec.ValidSink.Step); err == nil {
+		if _, ok := bound[fn]; ok {
+			return nil, fmt.Errorf("feature %q: the valid sink and step %q both name contract step %s", feature.Name, bound[fn], fn)
+		}
+		spec.ValidRouter = fn
+	}
+
+	// Feature metadata seeds contract variables, e.g. contract_version -> ContractVersion
+	for _, name := range sortedKeys(contract.Vars) {
+		if value, ok := feature.Metadata[snakeCase(name)]; ok {
+			spec.Settings = append(spec.Settings, ContractSetting{Name: name, Value: value})
+		}
+	}
+
+	return spec, nil
+}
+
+// MatchContractStep returns the contract step a feature step names: the one
+// whose name's words all appear in the step, e.g. ApplyFieldMasking for
+// "Apply field masking to sensitive fields". When several match, the one
+// with the most words wins.
+func MatchContractStep(contract *Contract, step string) (string, error) {
+	words := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(step), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		words[word] = true
+	}
+
+	var best []string
+	bestWords := 0
+	for _, name := range contract.Steps {
+		nameWords := splitName(name)
+		if !containsWords(words, nameWords) || len(nameWords) < bestWords {
+			continue
+		}
+		if len(nameWords) > bestWords {
+			best, bestWords = nil, len(nameWords)
+		}
+		best = append(best, name)
+	}
+
+	switch len(best) {
+	case 0:
+		return "", fmt.Errorf("step %q names no step of contract %s", step, contract.Package)
+	case 1:
+		return best[0], nil
+	}
+	return "", fmt.Errorf("step %q matches contract steps %s", step, strings.Join(best, ", "))
+}
+
+// containsWords reports whether every name word starts a word of the step,
+// so that Field matches "fields"
+func containsWords(words map[string]bool, nameWords []string) bool {
+	for _, nameWord := range nameWords {
+		if !words[nameWord] && !hasWordWithPrefix(words, nameWord) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasWordWithPrefix(words map[string]bool, prefix string) bool {
+	for word := range words {
+		if strings.HasPrefix(word, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitName splits DecodeCDMEvent into decode, cdm and event
+func splitName(name string) []string {
+	runes := []rune(name)
+	var words []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		lowerToUpper := unicode.IsUpper(runes[i]) && !unicode.IsUpper(runes[i-1])
+		acronymEnd := unicode.IsUpper(runes[i]) && unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+		if lowerToUpper || acronymEnd {
+			words = append(words, strings.ToLower(string(runes[start:i])))
+			start = i
+		}
+	}
+	return append(words, strings.ToLower(string(runes[start:])))
+}
+
+// RenderPipeline writes the pipeline's main package source to w
+func RenderPipeline(w io.Writer, spec *PipelineSpec) error {
+	var buf bytes.Buffer
+	if err := pipelineTemplate.Execute(&buf, spec); err != nil {
+		return fmt.Errorf("render pipeline: %w", err)
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("format pipeline: %w", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// WritePipeline lays out a buildable module in outDir: go.mod, the copied
+// contract package and the generated main.go
+func WritePipeline(spec *PipelineSpec, outDir string) error {
+	contractDir := filepath.Join(outDir, "contract")
+	if err := os.MkdirAll(contractDir, 0755); err != nil {
+		return fmt.Errorf("create output dir: %w", err)
+	}
+
+	gomod := fmt.Sprintf("module %s\n\ngo 1.21\n", pipelineModule)
+	if err := os.WriteFile(filepath.Join(outDir, "go.mod"), []byte(gomod), 0644); err != nil {
+		return fmt.Errorf("write go.mod: %w", err)
+	}
+
+	// Contracts may be kept as .contract files; Go only compiles .go files
+	for _, file := range spec.Contract.Files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("read contract: %w", err)
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".contract")
+		if !strings.HasSuffix(name, ".go") {
+			name += ".go"
+		}
+		if err := os.WriteFile(filepath.Join(contractDir, name), src, 0644); err != nil {
+			return fmt.Errorf("copy contract: %w", err)
+		}
+	}
+
+	var main bytes.Buffer
+	if err := RenderPipeline(&main, spec); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(outDir, "main.go"), main.Bytes(), 0644); err != nil {
+		return fmt.Errorf("write main.go: %w", err)
+	}
+	return nil
+}
+
+// BuildPipeline compiles the module in outDir into a standalone binary
+func BuildPipeline(outDir, output string) error {
+	output, err := filepath.Abs(output)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("go", "build", "-o", output, ".")
+	cmd.Dir = outDir
+	cmd.Env = append(os.Environ(), "GOWORK=off", "CGO_ENABLED=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("go build: %w\n%s", err, out)
+	}
+	return nil
+}
+
+// Render parses the feature and contract, writes the pipeline source and
+// optionally builds it
+func Render(opts RenderOptions) error {
+	feature, err := ParseFeatureFile(opts.FeaturePath)
+	if err != nil {
+		return err
+	}
+
+	contractPath := opts.ContractPath
+	if contractPath == "" {
+		ref := feature.Metadata["contract"]
+		if ref == "" {
+			return fmt.Errorf("feature %q names no contract; pass --contract", feature.Name)
+		}
+		contractPath = filepath.Join(opts.ContractsDir, ref)
+	}
+	contract, err := LoadContract(contractPath)
+	if err != nil {
+		return err
+	}
+
+	spec, err := PlanPipeline(opts.FeaturePath, feature, contract)
+	if err != nil {
+		return err
+	}
+	if err := WritePipeline(spec, opts.OutDir); err != nil {
+		return err
+	}
+	if !opts.Build {
+		return nil
+	}
+	return BuildPipeline(opts.OutDir, opts.Output)
+}
+
+// pipelineModule is the module path of rendered pipeline programs
+const pipelineModule = "runink.local/pipeline"
+
+// snakeCase converts ContractVersion to contract_version
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var pipelineTemplate = template.Must(template.New("pipeline").Parse(`// Code generated by runink render from {{.FeaturePath}}. DO NOT EDIT.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	contract "` + pipelineModule + `/contract"
+)
+
+const (
+	featureName  = {{printf "%q" .Feature.Name}}
+	scenarioName = {{printf "%q" .Feature.Scenario}}
+)
+
+// stage is a contract step function wired into the pipeline
+type stage struct {
+	name string
+	run  func(io.Reader, io.Writer) error
+}
+
+var stages = []stage{
+{{- range .Stages}}
+	{ {{- printf "%q" .Func}}, contract.{{.Func}}}, // {{.Description}}
+{{- end}}
+}
+
+var (
+	recordsOut  = expvar.NewMap("runink_records_out_total")
+	failedSteps = expvar.NewMap("runink_failed_steps_total")
+)
+
+func main() {
+	source := flag.String("source", {{printf "%q" .Feature.Source.URI}}, "Source: file path, file:// URI or - for stdin")
+	validSink := flag.String("valid-sink", {{printf "%q" .ValidSink.URI}}, "Sink for valid records")
+	invalidSink := flag.String("invalid-sink", {{printf "%q" .InvalidSink.URI}}, "Sink for dead letters")
+	metricsAddr := flag.String("metrics-addr", "", "Serve expvar metrics on this address")
+	flag.Parse()
+{{range .Settings}}
+	contract.{{.Name}} = {{printf "%q" .Value}}
+{{- end}}
+
+	if *metricsAddr != "" {
+		go func() {
+			log.Println(http.ListenAndServe(*metricsAddr, nil))
+		}()
+	}
+
+	if err := run(*source, *validSink, *invalidSink); err != nil {
+		fmt.Fprintf(os.Stderr, "%s / %s: %v\n", featureName, scenarioName, err)
+		os.Exit(1)
+	}
+}
+
+// run streams the source through every stage and routes the output to the sinks
+func run(sourceURI, validURI, invalidURI string) error {
+	in, err := openSource(sourceURI)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	valid, err := openSink(validURI)
+	if err != nil {
+		return err
+	}
+	defer valid.Close()
+
+	// Each stage reads the previous stage's output through a pipe
+	var wg sync.WaitGroup
+	errs := make([]error, len(stages))
+	var r io.Reader = in
+	for i, s := range stages {
+		pr, pw := io.Pipe()
+		wg.Add(1)
+		go func(i int, s stage, r io.Reader, pw *io.PipeWriter) {
+			defer wg.Done()
+			err := s.run(r, &countingWriter{w: pw, step: s.name})
+			if err != nil {
+				failedSteps.Add(s.name, 1)
+				errs[i] = err
+			}
+			pw.CloseWithError(err)
+			// Drain unread input so upstream stages can finish
+			io.Copy(io.Discard, r)
+		}(i, s, r, pw)
+		r = pr
+	}
+
+{{- if .ValidRouter}}
+	routeErr := contract.{{.ValidRouter}}(r, valid)
+{{- else}}
+	_, routeErr := io.Copy(valid, r)
+{{- end}}
+	io.Copy(io.Discard, r)
+	wg.Wait()
+
+	return routeFailures(errs, routeErr, invalidURI)
+}
+
+// routeFailures writes a dead letter for every failed stage and returns the first failure
+func routeFailures(errs []error, routeErr error, invalidURI string) error {
+	var first, upstream error
+	var letters []map[string]string
+	for i, err := range errs {
+		// Downstream stages see an upstream failure through their pipe; report it once
+		if err == nil || (upstream != nil && errors.Is(err, upstream)) {
+			continue
+		}
+		upstream = err
+		if first == nil {
+			first = fmt.Errorf("step %s: %w", stages[i].name, err)
+		}
+		letters = append(letters, map[string]string{
+			"feature": featureName,
+			"step":    stages[i].name,
+			"error":   err.Error(),
+		})
+	}
+	if first == nil && routeErr != nil {
+		first = fmt.Errorf("route to valid sink: %w", routeErr)
+	}
+
+	if len(letters) == 0 || invalidURI == "" {
+		return first
+	}
+	dlq, err := openSink(invalidURI)
+	if err != nil {
+		return err
+	}
+	defer dlq.Close()
+	encoder := json.NewEncoder(dlq)
+	for _, letter := range letters {
+		if err := encoder.Encode(letter); err != nil {
+			return err
+		}
+	}
+	return first
+}
+
+// countingWriter counts the NDJSON records a stage emits
+type countingWriter struct {
+	w    io.Writer
+	step string
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	recordsOut.Add(c.step, int64(strings.Count(string(p[:n]), "\n")))
+	return n, err
+}
+
+func openSource(uri string) (io.ReadCloser, error) {
+	if uri == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	path, err := localPath(uri)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func openSink(uri string) (io.WriteCloser, error) {
+	if uri == "-" {
+		return os.Stdout, nil
+	}
+	path, err := localPath(uri)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+// localPath accepts plain paths and file:// URIs; other schemes need a connector
+func localPath(uri string) (string, error) {
+	if strings.HasPrefix(uri, "file://") {
+		return strings.TrimPrefix(uri, "file://"), nil
+	}
+	if i := strings.Index(uri, "://"); i > 0 {
+		return "", fmt.Errorf("unsupported scheme %q in %s", uri[:i], uri)
+	}
+	return uri, nil
+}
+`))
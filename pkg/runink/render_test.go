@@ -0,0 +1,192 @@
+package runink
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden pipeline source")
+
+const (
+	cdmTradeFeature  = "../../demo/features/cdm_trade/fdc3events.dsl"
+	cdmTradeContract = "../../demo/contracts/cdm_trade/fdc3events.contract"
+	cdmTradeGolden   = "../../demo/dags/cdm_trade/fdc3events.pipeline.golden"
+)
+
+// planCDMTrade plans the cdm_trade demo pipeline
+func planCDMTrade(t *testing.T) *PipelineSpec {
+	t.Helper()
+	feature, err := ParseFeatureFile(cdmTradeFeature)
+	if err != nil {
+		t.Fatalf("Failed to parse feature: %v", err)
+	}
+	contract, err := LoadContract(cdmTradeContract)
+	if err != nil {
+		t.Fatalf("Failed to load contract: %v", err)
+	}
+	spec, err := PlanPipeline("features/cdm_trade/fdc3events.dsl", feature, contract)
+	if err != nil {
+		t.Fatalf("Failed to plan pipeline: %v", err)
+	}
+	return spec
+}
+
+// writeContract writes a contract whose step functions copy their input
+func writeContract(t *testing.T, steps string, funcs ...string) *Contract {
+	t.Helper()
+	src := "package contracts\n\nimport \"io\"\n\nvar ContractVersion string\n\n" + steps + "\n"
+	for _, fn := range funcs {
+		src += "\nfunc " + fn + "(r io.Reader, w io.Writer) error {\n\t_, err := io.Copy(w, r)\n\treturn err\n}\n"
+	}
+	path := filepath.Join(t.TempDir(), "steps.contract")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatalf("Failed to write contract: %v", err)
+	}
+	contract, err := LoadContract(path)
+	if err != nil {
+		t.Fatalf("Failed to load contract: %v", err)
+	}
+	return contract
+}
+
+// TestRenderCDMTrade tests the pipeline rendered from the cdm_trade demo
+// against its golden source; run with -update after changing the template
+func TestRenderCDMTrade(t *testing.T) {
+	spec := planCDMTrade(t)
+
+	var out bytes.Buffer
+	if err := RenderPipeline(&out, spec); err != nil {
+		t.Fatalf("Failed to render pipeline: %v", err)
+	}
+	if *update {
+		if err := os.WriteFile(cdmTradeGolden, out.Bytes(), 0644); err != nil {
+			t.Fatalf("Failed to update golden source: %v", err)
+		}
+	}
+	golden, err := os.ReadFile(cdmTradeGolden)
+	if err != nil {
+		t.Fatalf("Failed to read golden source: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), golden) {
+		t.Errorf("Rendered pipeline differs from %s:\n%s", cdmTradeGolden, out.String())
+	}
+}
+
+// TestBuildPipeline tests that the rendered cdm_trade pipeline builds and
+// routes records through the contract steps
+func TestBuildPipeline(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Building a pipeline is slow")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("The go tool is not installed")
+	}
+
+	dir := t.TempDir()
+	outDir := filepath.Join(dir, "pipeline")
+	if err := WritePipeline(planCDMTrade(t), outDir); err != nil {
+		t.Fatalf("Failed to write pipeline: %v", err)
+	}
+	binary := filepath.Join(dir, "cdm_trade")
+	if err := BuildPipeline(outDir, binary); err != nil {
+		t.Fatalf("Failed to build pipeline: %v", err)
+	}
+
+	input := filepath.Join(dir, "input.json")
+	records := `{"trade_id": "T1001", "symbol": "AAPL", "price": 190.5, "timestamp": "2025-01-02T15:04:05Z"}
+{"trade_id": "T1002", "symbol": "", "price": 0, "timestamp": "2025-01-02T15:04:05Z"}
+`
+	if err := os.WriteFile(input, []byte(records), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+	valid := filepath.Join(dir, "validated.json")
+	cmd := exec.Command(binary, "--source", input, "--valid-sink", valid, "--invalid-sink", filepath.Join(dir, "dlq.json"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Pipeline failed: %v\n%s", err, out)
+	}
+
+	output, err := os.ReadFile(valid)
+	if err != nil {
+		t.Fatalf("Failed to read valid sink: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"trade_id":"T1001"`) || !strings.Contains(lines[0], `"contract_version":"1.0.0"`) {
+		t.Errorf("Expected the one valid record, got %s", output)
+	}
+}
+
+// TestPlanPipelineBindsStepsByName tests that steps run the contract steps
+// they name whatever the contract's order
+func TestPlanPipelineBindsStepsByName(t *testing.T) {
+	contract := writeContract(t, `var Steps = []string{"RouteValidRecords", "MaskFields", "DecodeCDMEvent"}`,
+		"DecodeCDMEvent", "MaskFields", "RouteValidRecords")
+	feature := &Feature{
+		Name:  "Trades",
+		Steps: []string{"Decode each trade event according to CDM format", "Mask sensitive fields"},
+		Sinks: []FeatureSink{{Name: "Validated", URI: "valid.json", Step: `Route valid records to "Validated" (sink: valid.json)`}},
+	}
+
+	spec, err := PlanPipeline("trades.dsl", feature, contract)
+	if err != nil {
+		t.Fatalf("Failed to plan pipeline: %v", err)
+	}
+	if len(spec.Stages) != 2 || spec.Stages[0].Func != "DecodeCDMEvent" || spec.Stages[1].Func != "MaskFields" {
+		t.Errorf("Unexpected stages: %+v", spec.Stages)
+	}
+	if spec.ValidRouter != "RouteValidRecords" {
+		t.Errorf("Expected RouteValidRecords to route valid records, got %q", spec.ValidRouter)
+	}
+
+	// A sink line naming no contract step copies records to the sink
+	feature.Sinks[0].Step = `Write records to "Validated" (sink: valid.json)`
+	if spec, err := PlanPipeline("trades.dsl", feature, contract); err != nil || spec.ValidRouter != "" {
+		t.Errorf("Expected no router, got %q (%v)", spec.ValidRouter, err)
+	}
+}
+
+// TestPlanPipelineErrors tests features that cannot be bound to a contract
+func TestPlanPipelineErrors(t *testing.T) {
+	contract := writeContract(t, "", "MaskFields", "MaskAllFields", "MaskEmails", "MaskEmailsNow", "RouteValidRecords")
+	sinks := []FeatureSink{{URI: "valid.json", Step: "Route valid records (sink: valid.json)"}}
+
+	tests := []struct {
+		name     string
+		feature  Feature
+		expected string
+	}{
+		{"no steps", Feature{Name: "f", Sinks: sinks}, "declares no steps"},
+		{"unknown step", Feature{Name: "f", Steps: []string{"Decode events"}, Sinks: sinks}, `step "Decode events" names no step`},
+		{"ambiguous step", Feature{Name: "f", Steps: []string{"Mask all fields and emails now"}, Sinks: sinks}, "matches contract steps MaskAllFields, MaskEmailsNow"},
+		{"repeated step", Feature{Name: "f", Steps: []string{"Mask fields", "Mask the fields again"}, Sinks: sinks}, "both name contract step MaskFields"},
+		{"missing sink", Feature{Name: "f", Steps: []string{"Mask fields"}}, "routes no records to a valid sink"},
+		{"only an invalid sink", Feature{Name: "f", Steps: []string{"Mask fields"}, Sinks: []FeatureSink{{URI: "dlq.json", Invalid: true}}}, "routes no records to a valid sink"},
+		{"sink reuses a step", Feature{Name: "f", Steps: []string{"Route valid records"}, Sinks: sinks}, "the valid sink and step"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := PlanPipeline("f.dsl", &tt.feature, contract)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected an error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+// TestSplitName tests splitting step function names into words
+func TestSplitName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"DecodeCDMEvent":    "decode cdm event",
+		"ApplyFieldMasking": "apply field masking",
+		"LoadS3Object":      "load s3 object",
+		"Enrich":            "enrich",
+	} {
+		if words := strings.Join(splitName(name), " "); words != expected {
+			t.Errorf("splitName(%q) = %q, expected %q", name, words, expected)
+		}
+	}
+}